@@ -8,6 +8,7 @@ import (
 	"net"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/docker/go-events"
 	"github.com/docker/libnetwork/cluster"
@@ -36,6 +37,7 @@ func (b ByTime) Less(i, j int) bool { return b[i].LamportTime < b[j].LamportTime
 
 type agent struct {
 	networkDB         *networkdb.NetworkDB
+	serviceRecords    serviceRecords
 	bindAddr          string
 	advertiseAddr     string
 	dataPathAddr      string
@@ -285,6 +287,14 @@ func (c *controller) agentInit(listenAddr, bindAddrOrInterface, advertiseAddr, d
 	netDBConf.BindAddr = listenAddr
 	netDBConf.AdvertiseAddr = advertiseAddr
 	netDBConf.Keys = keys
+	netDBConf.SnapshotPath = c.Config().Daemon.NetworkDBSnapshotPath
+	netDBConf.TCPOnly = c.Config().Daemon.NetworkDBTCPOnly
+	netDBConf.BindPort = c.Config().Daemon.NetworkDBPort
+	gossip := c.GossipParams()
+	netDBConf.GossipInterval = gossip.GossipInterval
+	netDBConf.PushPullInterval = gossip.PushPullInterval
+	netDBConf.GossipNodes = gossip.GossipNodes
+	netDBConf.RetransmitMult = gossip.RetransmitMult
 	if c.Config().Daemon.NetworkControlPlaneMTU != 0 {
 		// Consider the MTU remove the IP hdr (IPv4 or IPv6) and the TCP/UDP hdr.
 		// To be on the safe side let's cut 100 bytes
@@ -297,18 +307,34 @@ func (c *controller) agentInit(listenAddr, bindAddrOrInterface, advertiseAddr, d
 		return err
 	}
 
+	var records serviceRecords = &gossipServiceRecords{nDB: nDB}
+	if cfg := c.Config().Daemon.ServiceRecordStore; cfg.IsValid() {
+		kvRecords, err := newKVServiceRecords(cfg, serviceRecordsNode(advertiseAddr))
+		if err != nil {
+			nDB.Close()
+			return err
+		}
+		logrus.Infof("Service records stored in %s %s", cfg.Client.Provider, cfg.Client.Address)
+		records = kvRecords
+	}
+
 	// Register the diagnostic handlers
 	c.DiagnosticServer.RegisterHandler(nDB, networkdb.NetDbPaths2Func)
 
 	var cancelList []func()
-	ch, cancel := nDB.Watch(libnetworkEPTable, "", "")
+	ch, cancel := records.Watch()
 	cancelList = append(cancelList, cancel)
 	nodeCh, cancel := nDB.Watch(networkdb.NodeTable, "", "")
 	cancelList = append(cancelList, cancel)
+	healthCh, cancel := nDB.Watch(libnetworkEPHealthTable, "", "")
+	cancelList = append(cancelList, cancel)
+	partitionCh, cancel := nDB.Watch(networkdb.PartitionTable, "", "")
+	cancelList = append(cancelList, cancel)
 
 	c.Lock()
 	c.agent = &agent{
 		networkDB:         nDB,
+		serviceRecords:    records,
 		bindAddr:          bindAddr,
 		advertiseAddr:     advertiseAddr,
 		dataPathAddr:      dataPathAddr,
@@ -318,7 +344,9 @@ func (c *controller) agentInit(listenAddr, bindAddrOrInterface, advertiseAddr, d
 	c.Unlock()
 
 	go c.handleTableEvents(ch, c.handleEpTableEvent)
+	go c.handleTableEvents(healthCh, c.handleEpHealthTableEvent)
 	go c.handleTableEvents(nodeCh, c.handleNodeTableEvent)
+	go c.handleTableEvents(partitionCh, c.handlePartitionTableEvent)
 
 	drvEnc := discoverapi.DriverEncryptionConfig{}
 	keys, tags := c.getKeys(subsysIPSec)
@@ -346,6 +374,61 @@ func (c *controller) agentJoin(remoteAddrList []string) error {
 	return agent.networkDB.Join(remoteAddrList)
 }
 
+// SetGossipParams changes the gossip parameters of the running agent. They
+// are kept for the agents started later.
+func (c *controller) SetGossipParams(p networkdb.GossipParams) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	c.Lock()
+	if c.cfg != nil {
+		cur := &c.cfg.Daemon.NetworkDBGossip
+		if p.GossipInterval != 0 {
+			cur.GossipInterval = p.GossipInterval
+		}
+		if p.PushPullInterval != 0 {
+			cur.PushPullInterval = p.PushPullInterval
+		}
+		if p.GossipNodes != 0 {
+			cur.GossipNodes = p.GossipNodes
+		}
+		if p.RetransmitMult != 0 {
+			cur.RetransmitMult = p.RetransmitMult
+		}
+	}
+	agent := c.agent
+	c.Unlock()
+
+	if agent == nil {
+		return nil
+	}
+	return agent.networkDB.SetGossipParams(p)
+}
+
+// GossipParams returns the gossip parameters of the running agent, or the
+// configured ones when the agent is not running
+func (c *controller) GossipParams() networkdb.GossipParams {
+	if agent := c.getAgent(); agent != nil {
+		return agent.networkDB.GossipParams()
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.cfg == nil {
+		return networkdb.GossipParams{}
+	}
+	return c.cfg.Daemon.NetworkDBGossip
+}
+
+// ClusterPartitions returns the partitions of the gossip cluster suspected
+// by this node
+func (c *controller) ClusterPartitions() []networkdb.Partition {
+	if agent := c.getAgent(); agent != nil {
+		return agent.networkDB.Partitions()
+	}
+	return nil
+}
+
 func (c *controller) agentDriverNotify(d driverapi.Driver) {
 	agent := c.getAgent()
 	if agent == nil {
@@ -400,6 +483,7 @@ func (c *controller) agentClose() {
 		cancel()
 	}
 
+	agent.serviceRecords.Close()
 	agent.networkDB.Close()
 }
 
@@ -437,11 +521,11 @@ func (n *network) Services() map[string]ServiceInfo {
 	}
 
 	// Walk through libnetworkEPTable and fetch the driver agnostic endpoint info
-	entries := agent.networkDB.GetTableByNetwork(libnetworkEPTable, n.id)
+	entries := agent.serviceRecords.GetTableByNetwork(n.id)
 	for eid, value := range entries {
 		var epRec EndpointRecord
 		nid := n.ID()
-		if err := proto.Unmarshal(value.Value, &epRec); err != nil {
+		if err := proto.Unmarshal(value, &epRec); err != nil {
 			logrus.Errorf("Unmarshal of libnetworkEPTable failed for endpoint %s in network %s, %v", eid, nid, err)
 			continue
 		}
@@ -555,8 +639,12 @@ func (ep *endpoint) addDriverInfoToCluster() error {
 		return nil
 	}
 
+	create := agent.networkDB.CreateEntry
+	if ep.isMigrated() {
+		create = agent.networkDB.TakeEntry
+	}
 	for _, te := range ep.joinInfo.driverTableEntries {
-		if err := agent.networkDB.CreateEntry(te.tableName, n.ID(), te.key, te.value); err != nil {
+		if err := create(te.tableName, n.ID(), te.key, te.value); err != nil {
 			return err
 		}
 	}
@@ -572,6 +660,12 @@ func (ep *endpoint) deleteDriverInfoFromCluster() error {
 		return nil
 	}
 
+	// The entries of an endpoint exported to another node are left for
+	// that node to take over
+	if ep.isExported() {
+		return nil
+	}
+
 	agent := n.getController().getAgent()
 	if agent == nil {
 		return nil
@@ -630,7 +724,7 @@ func (ep *endpoint) addServiceInfoToCluster(sb *sandbox) error {
 		if n.ingress {
 			ingressPorts = ep.ingressPorts
 		}
-		if err := c.addServiceBinding(ep.svcName, ep.svcID, n.ID(), ep.ID(), name, ep.virtualIP, ingressPorts, ep.svcAliases, ep.myAliases, ep.Iface().Address().IP, "addServiceInfoToCluster"); err != nil {
+		if err := c.addServiceBinding(ep.svcName, ep.svcID, n.ID(), ep.ID(), name, ep.virtualIP, ingressPorts, ep.svcScheduler, ep.svcPersistence, ep.svcWeight, ep.svcAliases, ep.myAliases, ep.Iface().Address().IP, "addServiceInfoToCluster"); err != nil {
 			return err
 		}
 	} else {
@@ -641,25 +735,34 @@ func (ep *endpoint) addServiceInfoToCluster(sb *sandbox) error {
 	}
 
 	buf, err := proto.Marshal(&EndpointRecord{
-		Name:            name,
-		ServiceName:     ep.svcName,
-		ServiceID:       ep.svcID,
-		VirtualIP:       ep.virtualIP.String(),
-		IngressPorts:    ingressPorts,
-		Aliases:         ep.svcAliases,
-		TaskAliases:     ep.myAliases,
-		EndpointIP:      ep.Iface().Address().IP.String(),
-		ServiceDisabled: false,
+		Name:               name,
+		ServiceName:        ep.svcName,
+		ServiceID:          ep.svcID,
+		VirtualIP:          ep.virtualIP.String(),
+		IngressPorts:       ingressPorts,
+		Aliases:            ep.svcAliases,
+		TaskAliases:        ep.myAliases,
+		EndpointIP:         ep.Iface().Address().IP.String(),
+		ServiceDisabled:    false,
+		LBScheduler:        ep.svcScheduler,
+		PersistenceTimeout: uint32(ep.svcPersistence / time.Second),
+		Weight:             uint32(ep.svcWeight),
 	})
 	if err != nil {
 		return err
 	}
 
 	if agent != nil {
-		if err := agent.networkDB.CreateEntry(libnetworkEPTable, n.ID(), ep.ID(), buf); err != nil {
+		create := agent.serviceRecords.CreateEntry
+		if ep.isMigrated() {
+			create = agent.serviceRecords.TakeEntry
+		}
+		if err := create(n.ID(), ep.ID(), buf); err != nil {
 			logrus.Warnf("addServiceInfoToCluster NetworkDB CreateEntry failed for %s %s err:%s", ep.id, n.id, err)
 			return err
 		}
+		// the records of the exported endpoint are taken over
+		ep.clearMigrated()
 	}
 
 	logrus.Debugf("addServiceInfoToCluster END for %s %s", ep.svcName, ep.ID())
@@ -700,8 +803,10 @@ func (ep *endpoint) deleteServiceInfoFromCluster(sb *sandbox, fullRemove bool, m
 
 	if agent != nil {
 		// First update the networkDB then locally
-		if fullRemove {
-			if err := agent.networkDB.DeleteEntry(libnetworkEPTable, n.ID(), ep.ID()); err != nil {
+		if ep.isExported() {
+			agent.serviceRecords.ReleaseEntry(n.ID(), ep.ID())
+		} else if fullRemove {
+			if err := agent.serviceRecords.DeleteEntry(n.ID(), ep.ID()); err != nil {
 				logrus.Warnf("deleteServiceInfoFromCluster NetworkDB DeleteEntry failed for %s %s err:%s", ep.id, n.id, err)
 			}
 		} else {
@@ -738,7 +843,7 @@ func disableServiceInNetworkDB(a *agent, n *network, ep *endpoint) {
 	logrus.Debugf("disableServiceInNetworkDB for %s %s", ep.svcName, ep.ID())
 
 	// Update existing record to indicate that the service is disabled
-	inBuf, err := a.networkDB.GetEntry(libnetworkEPTable, n.ID(), ep.ID())
+	inBuf, err := a.serviceRecords.GetEntry(n.ID(), ep.ID())
 	if err != nil {
 		logrus.Warnf("disableServiceInNetworkDB GetEntry failed for %s %s err:%s", ep.id, n.id, err)
 		return
@@ -756,7 +861,7 @@ func disableServiceInNetworkDB(a *agent, n *network, ep *endpoint) {
 		return
 	}
 	// Send update to the whole cluster
-	if err := a.networkDB.UpdateEntry(libnetworkEPTable, n.ID(), ep.ID(), outBuf); err != nil {
+	if err := a.serviceRecords.UpdateEntry(n.ID(), ep.ID(), outBuf); err != nil {
 		logrus.Warnf("disableServiceInNetworkDB UpdateEntry failed for %s %s err:%s", ep.id, n.id, err)
 	}
 }
@@ -885,6 +990,35 @@ func (c *controller) handleNodeTableEvent(ev events.Event) {
 
 }
 
+func (c *controller) handlePartitionTableEvent(ev events.Event) {
+	var (
+		value    []byte
+		detected bool
+		p        networkdb.Partition
+	)
+	switch event := ev.(type) {
+	case networkdb.CreateEvent:
+		value = event.Value
+		detected = true
+	case networkdb.DeleteEvent:
+		value = event.Value
+	default:
+		logrus.Errorf("Unexpected partition table event = %#v", event)
+		return
+	}
+
+	if err := json.Unmarshal(value, &p); err != nil {
+		logrus.Errorf("Error unmarshalling partition table event %v", err)
+		return
+	}
+	if c.cfg == nil {
+		return
+	}
+	for _, hook := range c.cfg.Daemon.PartitionHooks {
+		hook(p, detected)
+	}
+}
+
 func (c *controller) handleEpTableEvent(ev events.Event) {
 	var (
 		nid   string
@@ -923,6 +1057,9 @@ func (c *controller) handleEpTableEvent(ev events.Event) {
 	vip := net.ParseIP(epRec.VirtualIP)
 	ip := net.ParseIP(epRec.EndpointIP)
 	ingressPorts := epRec.IngressPorts
+	scheduler := epRec.LBScheduler
+	persistence := time.Duration(epRec.PersistenceTimeout) * time.Second
+	weight := int(epRec.Weight)
 	serviceAliases := epRec.Aliases
 	taskAliases := epRec.TaskAliases
 
@@ -936,7 +1073,7 @@ func (c *controller) handleEpTableEvent(ev events.Event) {
 		logrus.Debugf("handleEpTableEvent ADD %s R:%v", eid, epRec)
 		if svcID != "" {
 			// This is a remote task part of a service
-			if err := c.addServiceBinding(svcName, svcID, nid, eid, containerName, vip, ingressPorts, serviceAliases, taskAliases, ip, "handleEpTableEvent"); err != nil {
+			if err := c.addServiceBinding(svcName, svcID, nid, eid, containerName, vip, ingressPorts, scheduler, persistence, weight, serviceAliases, taskAliases, ip, "handleEpTableEvent"); err != nil {
 				logrus.Errorf("failed adding service binding for %s epRec:%v err:%v", eid, epRec, err)
 				return
 			}
@@ -79,6 +79,13 @@ type EndpointRecord struct {
 	TaskAliases []string `protobuf:"bytes,8,rep,name=task_aliases,json=taskAliases" json:"task_aliases,omitempty"`
 	// Whether this enpoint's service has been disabled
 	ServiceDisabled bool `protobuf:"varint,9,opt,name=service_disabled,json=serviceDisabled,proto3" json:"service_disabled,omitempty"`
+	// Load balancing scheduler of the service to which this endpoint belongs.
+	LBScheduler string `protobuf:"bytes,10,opt,name=lb_scheduler,json=lbScheduler,proto3" json:"lb_scheduler,omitempty"`
+	// Session affinity timeout in seconds of the service to which this
+	// endpoint belongs, no affinity when zero.
+	PersistenceTimeout uint32 `protobuf:"varint,11,opt,name=persistence_timeout,json=persistenceTimeout,proto3" json:"persistence_timeout,omitempty"`
+	// Load balancing weight of this endpoint in its service, 1 when zero.
+	Weight uint32 `protobuf:"varint,12,opt,name=weight,proto3" json:"weight,omitempty"`
 }
 
 func (m *EndpointRecord) Reset()                    { *m = EndpointRecord{} }
@@ -148,6 +155,27 @@ func (m *EndpointRecord) GetServiceDisabled() bool {
 	return false
 }
 
+func (m *EndpointRecord) GetLBScheduler() string {
+	if m != nil {
+		return m.LBScheduler
+	}
+	return ""
+}
+
+func (m *EndpointRecord) GetPersistenceTimeout() uint32 {
+	if m != nil {
+		return m.PersistenceTimeout
+	}
+	return 0
+}
+
+func (m *EndpointRecord) GetWeight() uint32 {
+	if m != nil {
+		return m.Weight
+	}
+	return 0
+}
+
 // PortConfig specifies an exposed port which can be
 // addressed using the given name. This can be later queried
 // using a service discovery api or a DNS SRV query. The node
@@ -211,7 +239,7 @@ func (this *EndpointRecord) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 13)
+	s := make([]string, 0, 16)
 	s = append(s, "&libnetwork.EndpointRecord{")
 	s = append(s, "Name: "+fmt.Sprintf("%#v", this.Name)+",\n")
 	s = append(s, "ServiceName: "+fmt.Sprintf("%#v", this.ServiceName)+",\n")
@@ -224,6 +252,9 @@ func (this *EndpointRecord) GoString() string {
 	s = append(s, "Aliases: "+fmt.Sprintf("%#v", this.Aliases)+",\n")
 	s = append(s, "TaskAliases: "+fmt.Sprintf("%#v", this.TaskAliases)+",\n")
 	s = append(s, "ServiceDisabled: "+fmt.Sprintf("%#v", this.ServiceDisabled)+",\n")
+	s = append(s, "LBScheduler: "+fmt.Sprintf("%#v", this.LBScheduler)+",\n")
+	s = append(s, "PersistenceTimeout: "+fmt.Sprintf("%#v", this.PersistenceTimeout)+",\n")
+	s = append(s, "Weight: "+fmt.Sprintf("%#v", this.Weight)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -345,6 +376,22 @@ func (m *EndpointRecord) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i++
 	}
+	if len(m.LBScheduler) > 0 {
+		dAtA[i] = 0x52
+		i++
+		i = encodeVarintAgent(dAtA, i, uint64(len(m.LBScheduler)))
+		i += copy(dAtA[i:], m.LBScheduler)
+	}
+	if m.PersistenceTimeout != 0 {
+		dAtA[i] = 0x58
+		i++
+		i = encodeVarintAgent(dAtA, i, uint64(m.PersistenceTimeout))
+	}
+	if m.Weight != 0 {
+		dAtA[i] = 0x60
+		i++
+		i = encodeVarintAgent(dAtA, i, uint64(m.Weight))
+	}
 	return i, nil
 }
 
@@ -440,6 +487,16 @@ func (m *EndpointRecord) Size() (n int) {
 	if m.ServiceDisabled {
 		n += 2
 	}
+	l = len(m.LBScheduler)
+	if l > 0 {
+		n += 1 + l + sovAgent(uint64(l))
+	}
+	if m.PersistenceTimeout != 0 {
+		n += 1 + sovAgent(uint64(m.PersistenceTimeout))
+	}
+	if m.Weight != 0 {
+		n += 1 + sovAgent(uint64(m.Weight))
+	}
 	return n
 }
 
@@ -489,6 +546,9 @@ func (this *EndpointRecord) String() string {
 		`Aliases:` + fmt.Sprintf("%v", this.Aliases) + `,`,
 		`TaskAliases:` + fmt.Sprintf("%v", this.TaskAliases) + `,`,
 		`ServiceDisabled:` + fmt.Sprintf("%v", this.ServiceDisabled) + `,`,
+		`LBScheduler:` + fmt.Sprintf("%v", this.LBScheduler) + `,`,
+		`PersistenceTimeout:` + fmt.Sprintf("%v", this.PersistenceTimeout) + `,`,
+		`Weight:` + fmt.Sprintf("%v", this.Weight) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -797,6 +857,73 @@ func (m *EndpointRecord) Unmarshal(dAtA []byte) error {
 				}
 			}
 			m.ServiceDisabled = bool(v != 0)
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LBScheduler", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAgent
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthAgent
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.LBScheduler = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PersistenceTimeout", wireType)
+			}
+			m.PersistenceTimeout = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAgent
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.PersistenceTimeout |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 12:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Weight", wireType)
+			}
+			m.Weight = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowAgent
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Weight |= (uint32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipAgent(dAtA[iNdEx:])
@@ -1062,34 +1189,38 @@ var (
 func init() { proto.RegisterFile("agent.proto", fileDescriptorAgent) }
 
 var fileDescriptorAgent = []byte{
-	// 459 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x91, 0x31, 0x6f, 0xd3, 0x4c,
-	0x18, 0xc7, 0xe3, 0xc4, 0x6f, 0x1b, 0x3f, 0x4e, 0x52, 0xeb, 0xf4, 0x0a, 0x59, 0x1e, 0x1c, 0x13,
-	0x09, 0x29, 0x48, 0x28, 0x95, 0xca, 0xd8, 0x89, 0x26, 0x0c, 0x5e, 0x90, 0x75, 0x4d, 0x59, 0x83,
-	0x13, 0x1f, 0xe6, 0x54, 0xe3, 0xb3, 0xee, 0xae, 0x65, 0x65, 0x03, 0xf5, 0x3b, 0x74, 0xe2, 0xcb,
-	0x30, 0x32, 0x32, 0x55, 0xd4, 0x9f, 0x80, 0x95, 0x0d, 0xdd, 0xf9, 0xae, 0x11, 0x52, 0xb7, 0xf3,
-	0xef, 0xff, 0x3b, 0xeb, 0xb9, 0xff, 0x03, 0x7e, 0x5e, 0x92, 0x5a, 0x2e, 0x1a, 0xce, 0x24, 0x43,
-	0x50, 0xd1, 0x6d, 0x4d, 0xe4, 0x27, 0xc6, 0x2f, 0xa3, 0xff, 0x4b, 0x56, 0x32, 0x8d, 0x8f, 0xd5,
-	0xa9, 0x33, 0x66, 0x7f, 0xfa, 0x30, 0x79, 0x5d, 0x17, 0x0d, 0xa3, 0xb5, 0xc4, 0x64, 0xc7, 0x78,
-	0x81, 0x10, 0xb8, 0x75, 0xfe, 0x91, 0x84, 0x4e, 0xe2, 0xcc, 0x3d, 0xac, 0xcf, 0xe8, 0x29, 0x8c,
-	0x04, 0xe1, 0xd7, 0x74, 0x47, 0x36, 0x3a, 0xeb, 0xeb, 0xcc, 0x37, 0xec, 0x8d, 0x52, 0x5e, 0x00,
-	0x58, 0x85, 0x16, 0xe1, 0x40, 0x09, 0x67, 0xe3, 0xf6, 0x6e, 0xea, 0x9d, 0x77, 0x34, 0x5d, 0x61,
-	0xcf, 0x08, 0x69, 0xa1, 0xec, 0x6b, 0xca, 0xe5, 0x55, 0x5e, 0x6d, 0x68, 0x13, 0xba, 0x7b, 0xfb,
-	0x6d, 0x47, 0xd3, 0x0c, 0x7b, 0x46, 0x48, 0x1b, 0x74, 0x0c, 0x3e, 0x31, 0x43, 0x2a, 0xfd, 0x3f,
-	0xad, 0x4f, 0xda, 0xbb, 0x29, 0xd8, 0xd9, 0xd3, 0x0c, 0x83, 0x55, 0xd2, 0x06, 0x9d, 0xc2, 0x98,
-	0xd6, 0x25, 0x27, 0x42, 0x6c, 0x1a, 0xc6, 0xa5, 0x08, 0x0f, 0x92, 0xc1, 0xdc, 0x3f, 0x79, 0xb2,
-	0xd8, 0x17, 0xb2, 0xc8, 0x18, 0x97, 0x4b, 0x56, 0xbf, 0xa7, 0x25, 0x1e, 0x19, 0x59, 0x21, 0x81,
-	0x42, 0x38, 0xcc, 0x2b, 0x9a, 0x0b, 0x22, 0xc2, 0xc3, 0x64, 0x30, 0xf7, 0xb0, 0xfd, 0x54, 0x35,
-	0xc8, 0x5c, 0x5c, 0x6e, 0x6c, 0x3c, 0xd4, 0xb1, 0xaf, 0xd8, 0x2b, 0xa3, 0x3c, 0x87, 0xc0, 0xd6,
-	0x50, 0x50, 0x91, 0x6f, 0x2b, 0x52, 0x84, 0x5e, 0xe2, 0xcc, 0x87, 0xf8, 0xc8, 0xf0, 0x95, 0xc1,
-	0xb3, 0x2f, 0x7d, 0x80, 0xfd, 0x10, 0x8f, 0xf6, 0x7e, 0x0a, 0x43, 0xbd, 0xa7, 0x1d, 0xab, 0x74,
-	0xe7, 0x93, 0x93, 0xe9, 0xe3, 0x4f, 0x58, 0x64, 0x46, 0xc3, 0x0f, 0x17, 0xd0, 0x14, 0x7c, 0x99,
-	0xf3, 0x92, 0x48, 0xdd, 0x81, 0x5e, 0xc9, 0x18, 0x43, 0x87, 0xd4, 0x4d, 0xf4, 0x0c, 0x26, 0xcd,
-	0xd5, 0xb6, 0xa2, 0xe2, 0x03, 0x29, 0x3a, 0xc7, 0xd5, 0xce, 0xf8, 0x81, 0x2a, 0x6d, 0xf6, 0x0e,
-	0x86, 0xf6, 0xef, 0x28, 0x84, 0xc1, 0x7a, 0x99, 0x05, 0xbd, 0xe8, 0xe8, 0xe6, 0x36, 0xf1, 0x2d,
-	0x5e, 0x2f, 0x33, 0x95, 0x5c, 0xac, 0xb2, 0xc0, 0xf9, 0x37, 0xb9, 0x58, 0x65, 0x28, 0x02, 0xf7,
-	0x7c, 0xb9, 0xce, 0x82, 0x7e, 0x14, 0xdc, 0xdc, 0x26, 0x23, 0x1b, 0x29, 0x16, 0xb9, 0x5f, 0xbf,
-	0xc5, 0xbd, 0xb3, 0xf0, 0xe7, 0x7d, 0xdc, 0xfb, 0x7d, 0x1f, 0x3b, 0x9f, 0xdb, 0xd8, 0xf9, 0xde,
-	0xc6, 0xce, 0x8f, 0x36, 0x76, 0x7e, 0xb5, 0xb1, 0xb3, 0x3d, 0xd0, 0xaf, 0x79, 0xf9, 0x37, 0x00,
-	0x00, 0xff, 0xff, 0x55, 0x29, 0x75, 0x5c, 0xd7, 0x02, 0x00, 0x00,
+	// 525 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x92, 0x41, 0x6f, 0xd3, 0x30,
+	0x14, 0xc7, 0x97, 0xb5, 0x6c, 0xcd, 0x4b, 0xdb, 0x55, 0x06, 0x4d, 0x56, 0x0f, 0x69, 0xa8, 0x84,
+	0x54, 0x24, 0xd4, 0x4a, 0xe5, 0xb8, 0x13, 0x6d, 0x39, 0x44, 0x42, 0x28, 0x72, 0x3b, 0xae, 0x21,
+	0x69, 0x4c, 0x6a, 0x2d, 0x8b, 0xa3, 0xd8, 0xdd, 0xae, 0xdc, 0x40, 0xfb, 0x0e, 0x3b, 0xf1, 0x65,
+	0x38, 0x72, 0xe4, 0x54, 0xb1, 0x7c, 0x02, 0x4e, 0x9c, 0x91, 0x9d, 0xa4, 0x05, 0x69, 0x37, 0xbf,
+	0xdf, 0xff, 0x67, 0xcb, 0x7e, 0xcf, 0x60, 0x05, 0x31, 0x4d, 0xe5, 0x38, 0xcb, 0xb9, 0xe4, 0x08,
+	0x12, 0x16, 0xa6, 0x54, 0xde, 0xf2, 0xfc, 0xaa, 0xff, 0x2c, 0xe6, 0x31, 0xd7, 0x78, 0xa2, 0x56,
+	0xa5, 0x31, 0xfc, 0xd3, 0x80, 0xee, 0xdb, 0x34, 0xca, 0x38, 0x4b, 0x25, 0xa1, 0x6b, 0x9e, 0x47,
+	0x08, 0x41, 0x33, 0x0d, 0xae, 0x29, 0x36, 0x1c, 0x63, 0x64, 0x12, 0xbd, 0x46, 0xcf, 0xa1, 0x2d,
+	0x68, 0x7e, 0xc3, 0xd6, 0xd4, 0xd7, 0xd9, 0xb1, 0xce, 0xac, 0x8a, 0xbd, 0x57, 0xca, 0x2b, 0x80,
+	0x5a, 0x61, 0x11, 0x6e, 0x28, 0x61, 0xd6, 0x29, 0x76, 0x03, 0x73, 0x59, 0x52, 0x77, 0x41, 0xcc,
+	0x4a, 0x70, 0x23, 0x65, 0xdf, 0xb0, 0x5c, 0x6e, 0x83, 0xc4, 0x67, 0x19, 0x6e, 0x1e, 0xec, 0x0f,
+	0x25, 0x75, 0x3d, 0x62, 0x56, 0x82, 0x9b, 0xa1, 0x09, 0x58, 0xb4, 0xba, 0xa4, 0xd2, 0x9f, 0x68,
+	0xbd, 0x5b, 0xec, 0x06, 0x50, 0xdf, 0xdd, 0xf5, 0x08, 0xd4, 0x8a, 0x9b, 0xa1, 0x0b, 0xe8, 0xb0,
+	0x34, 0xce, 0xa9, 0x10, 0x7e, 0xc6, 0x73, 0x29, 0xf0, 0x89, 0xd3, 0x18, 0x59, 0xd3, 0xf3, 0xf1,
+	0xa1, 0x21, 0x63, 0x8f, 0xe7, 0x72, 0xce, 0xd3, 0x4f, 0x2c, 0x26, 0xed, 0x4a, 0x56, 0x48, 0x20,
+	0x0c, 0xa7, 0x41, 0xc2, 0x02, 0x41, 0x05, 0x3e, 0x75, 0x1a, 0x23, 0x93, 0xd4, 0xa5, 0x6a, 0x83,
+	0x0c, 0xc4, 0x95, 0x5f, 0xc7, 0x2d, 0x1d, 0x5b, 0x8a, 0xbd, 0xa9, 0x94, 0x97, 0xd0, 0xab, 0xdb,
+	0x10, 0x31, 0x11, 0x84, 0x09, 0x8d, 0xb0, 0xe9, 0x18, 0xa3, 0x16, 0x39, 0xab, 0xf8, 0xa2, 0xc2,
+	0x68, 0x0a, 0xed, 0x24, 0xf4, 0xc5, 0x7a, 0x43, 0xa3, 0x6d, 0x42, 0x73, 0x0c, 0xfa, 0x59, 0x67,
+	0xc5, 0x6e, 0x60, 0xbd, 0x9b, 0x2d, 0x6b, 0x4c, 0xac, 0x24, 0xdc, 0x17, 0x68, 0x02, 0x4f, 0x33,
+	0x9a, 0x0b, 0x26, 0x24, 0x4d, 0xd7, 0xd4, 0x97, 0xec, 0x9a, 0xf2, 0xad, 0xc4, 0x96, 0x63, 0x8c,
+	0x3a, 0x04, 0xfd, 0x13, 0xad, 0xca, 0x04, 0x9d, 0xc3, 0xc9, 0x2d, 0x65, 0xf1, 0x46, 0xe2, 0xb6,
+	0x76, 0xaa, 0x6a, 0xf8, 0xe5, 0x18, 0xe0, 0xd0, 0x81, 0x47, 0x87, 0x7e, 0x01, 0x2d, 0xfd, 0x49,
+	0xd6, 0x3c, 0xd1, 0x03, 0xef, 0x4e, 0x07, 0x8f, 0xf7, 0x6f, 0xec, 0x55, 0x1a, 0xd9, 0x6f, 0x40,
+	0x03, 0xb0, 0x64, 0x90, 0xc7, 0x54, 0xea, 0x01, 0xe8, 0xff, 0xd0, 0x21, 0x50, 0x22, 0xb5, 0x13,
+	0xbd, 0x80, 0x6e, 0xb6, 0x0d, 0x13, 0x26, 0x36, 0x34, 0x2a, 0x9d, 0xa6, 0x76, 0x3a, 0x7b, 0xaa,
+	0xb4, 0xe1, 0x47, 0x68, 0xd5, 0xa7, 0x23, 0x0c, 0x8d, 0xd5, 0xdc, 0xeb, 0x1d, 0xf5, 0xcf, 0xee,
+	0xee, 0x1d, 0xab, 0xc6, 0xab, 0xb9, 0xa7, 0x92, 0xcb, 0x85, 0xd7, 0x33, 0xfe, 0x4f, 0x2e, 0x17,
+	0x1e, 0xea, 0x43, 0x73, 0x39, 0x5f, 0x79, 0xbd, 0xe3, 0x7e, 0xef, 0xee, 0xde, 0x69, 0xd7, 0x91,
+	0x62, 0xfd, 0xe6, 0xd7, 0x6f, 0xf6, 0xd1, 0x0c, 0xff, 0x7c, 0xb0, 0x8f, 0x7e, 0x3f, 0xd8, 0xc6,
+	0xe7, 0xc2, 0x36, 0xbe, 0x17, 0xb6, 0xf1, 0xa3, 0xb0, 0x8d, 0x5f, 0x85, 0x6d, 0x84, 0x27, 0xfa,
+	0x35, 0xaf, 0xff, 0x0e, 0x00, 0x29, 0x91, 0xc1, 0x52, 0x54, 0x03, 0x00, 0x00,
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/docker/libnetwork"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/networkdb"
 	"github.com/docker/libnetwork/types"
 	"github.com/gorilla/mux"
 )
@@ -106,6 +107,9 @@ func (h *httpHandler) initRouter() {
 			{"/networks/" + nwID + "/endpoints", []string{"partial-id", epPIDQr}, procGetEndpoints},
 			{"/networks/" + nwID + "/endpoints", nil, procGetEndpoints},
 			{"/networks/" + nwID + "/endpoints/" + epID, nil, procGetEndpoint},
+			{"/networks/" + nwID + "/endpoints/" + epID + "/datapath", nil, procGetEndpointDatapath},
+			{"/networks/" + nwID + "/datapath", nil, procGetNetworkDatapath},
+			{"/datapath", nil, procGetDatapath},
 			{"/services", []string{"network", nwNameQr}, procGetServices},
 			{"/services", []string{"name", epNameQr}, procGetServices},
 			{"/services", []string{"partial-id", epPIDQr}, procGetServices},
@@ -117,6 +121,7 @@ func (h *httpHandler) initRouter() {
 			{"/sandboxes", []string{"partial-id", sbPIDQr}, procGetSandboxes},
 			{"/sandboxes", nil, procGetSandboxes},
 			{"/sandboxes/" + sbID, nil, procGetSandbox},
+			{"/gossip", nil, procGetGossipParams},
 		},
 		"POST": {
 			{"/networks", nil, procCreateNetwork},
@@ -125,6 +130,7 @@ func (h *httpHandler) initRouter() {
 			{"/services", nil, procPublishService},
 			{"/services/" + epID + "/backend", nil, procAttachBackend},
 			{"/sandboxes", nil, procCreateSandbox},
+			{"/gossip", nil, procSetGossipParams},
 		},
 		"DELETE": {
 			{"/networks/" + nwID, nil, procDeleteNetwork},
@@ -334,6 +340,32 @@ func procGetNetwork(c libnetwork.NetworkController, vars map[string]string, body
 	return buildNetworkResource(nw), &successResponse
 }
 
+func procGetNetworkDatapath(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	t, by := detectNetworkTarget(vars)
+	nw, errRsp := findNetwork(c, t, by)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+
+	st, err := nw.DatapathState()
+	if err != nil {
+		return nil, convertNetworkError(err)
+	}
+	return st, &successResponse
+}
+
+// procGetDatapath returns the datapath state of all the networks, the
+// networks failing it are skipped
+func procGetDatapath(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	list := []*libnetwork.NetworkDatapath{}
+	for _, nw := range c.Networks() {
+		if st, err := nw.DatapathState(); err == nil {
+			list = append(list, st)
+		}
+	}
+	return list, &successResponse
+}
+
 func procGetNetworks(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	var list []*networkResource
 
@@ -424,6 +456,22 @@ func procGetEndpoint(c libnetwork.NetworkController, vars map[string]string, bod
 	return buildEndpointResource(ep), &successResponse
 }
 
+func procGetEndpointDatapath(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	nwT, nwBy := detectNetworkTarget(vars)
+	epT, epBy := detectEndpointTarget(vars)
+
+	ep, errRsp := findEndpoint(c, nwT, epT, nwBy, epBy)
+	if !errRsp.isOK() {
+		return nil, errRsp
+	}
+
+	st, err := ep.DatapathState()
+	if err != nil {
+		return nil, convertNetworkError(err)
+	}
+	return st, &successResponse
+}
+
 func procGetEndpoints(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
 	// Look for query filters and validate
 	name, queryByName := vars[urlEpName]
@@ -813,6 +861,21 @@ func procDeleteSandbox(c libnetwork.NetworkController, vars map[string]string, b
 	return nil, &successResponse
 }
 
+func procGetGossipParams(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	return c.GossipParams(), &successResponse
+}
+
+func procSetGossipParams(c libnetwork.NetworkController, vars map[string]string, body []byte) (interface{}, *responseStatus) {
+	var p networkdb.GossipParams
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, &responseStatus{Status: "Invalid body: " + err.Error(), StatusCode: http.StatusBadRequest}
+	}
+	if err := c.SetGossipParams(p); err != nil {
+		return nil, convertNetworkError(err)
+	}
+	return c.GossipParams(), &successResponse
+}
+
 /***********
   Utilities
 ************/
@@ -256,6 +256,75 @@ func TestCreateDeleteNetwork(t *testing.T) {
 	}
 }
 
+func TestGetDatapath(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	// Cleanup local datastore file
+	os.Remove(datastore.DefaultScopes("")[datastore.LocalScope].Client.Address)
+
+	c, err := libnetwork.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	ops := GetOpsMap("api_test_nw", "")
+	nc := networkCreate{Name: "sh", NetworkType: bridgeNetType, DriverOpts: ops}
+	body, err := json.Marshal(nc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := make(map[string]string)
+	_, errRsp := procCreateNetwork(c, vars, body)
+	if errRsp != &createdResponse {
+		t.Fatalf("Unexpected failure: %v", errRsp)
+	}
+	b, err := json.Marshal(endpointCreate{Name: "ep1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars[urlNwName] = "sh"
+	ieid, errRsp := procCreateEndpoint(c, vars, b)
+	if errRsp != &createdResponse {
+		t.Fatalf("Unexpected failure: %v", errRsp)
+	}
+
+	i, errRsp := procGetNetworkDatapath(c, vars, nil)
+	if errRsp != &successResponse {
+		t.Fatalf("Unexpected failure: %v", errRsp)
+	}
+	nst := i.(*libnetwork.NetworkDatapath)
+	if nst.Name != "sh" || nst.Driver["BridgeName"] != "api_test_nw" {
+		t.Fatalf("Unexpected network datapath state: %+v", nst)
+	}
+	if len(nst.Endpoints) != 1 || nst.Endpoints[0].ID != i2s(ieid) {
+		t.Fatalf("Unexpected endpoints in the network datapath state: %+v", nst.Endpoints)
+	}
+
+	vars[urlEpName] = "ep1"
+	i, errRsp = procGetEndpointDatapath(c, vars, nil)
+	if errRsp != &successResponse {
+		t.Fatalf("Unexpected failure: %v", errRsp)
+	}
+	if est := i.(*libnetwork.EndpointDatapath); est.Name != "ep1" || est.Sandbox != nil {
+		t.Fatalf("Unexpected endpoint datapath state: %+v", est)
+	}
+
+	vars[urlEpName] = "ep2"
+	if _, errRsp = procGetEndpointDatapath(c, vars, nil); errRsp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected not found, got: %v", errRsp)
+	}
+
+	i, errRsp = procGetDatapath(c, nil, nil)
+	if errRsp != &successResponse {
+		t.Fatalf("Unexpected failure: %v", errRsp)
+	}
+	if l := i.([]*libnetwork.NetworkDatapath); len(l) == 0 {
+		t.Fatal("Expected the datapath state of the networks")
+	}
+}
+
 func TestGetNetworksAndEndpoints(t *testing.T) {
 	defer testutils.SetupTestOSContext(t)()
 
@@ -2,6 +2,7 @@ package client
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	_ "github.com/docker/libnetwork/testutils"
@@ -120,3 +121,16 @@ func TestClientNetworkServiceCreateMissingArgument(t *testing.T) {
 	}
 }
 */
+
+func TestClientServiceInspect(t *testing.T) {
+	var out, errOut bytes.Buffer
+	cli := NewNetworkCli(&out, &errOut, callbackFunc)
+
+	err := cli.Cmd("docker", "service", "inspect", mockServiceName+"."+mockNwName)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.Contains(out.String(), "/networks/"+mockNwID+"/endpoints/"+mockServiceID+"/datapath") {
+		t.Fatalf("Unexpected output: %q", out.String())
+	}
+}
@@ -59,7 +59,9 @@ func setupMockHTTPCallback() {
 		var rsp string
 		switch method {
 		case "GET":
-			if strings.Contains(path, fmt.Sprintf("networks?name=%s", mockNwName)) {
+			if strings.HasSuffix(path, "/datapath") {
+				rsp = fmt.Sprintf(`{"Path":%q}`, path)
+			} else if strings.Contains(path, fmt.Sprintf("networks?name=%s", mockNwName)) {
 				rsp = string(mockNwListJSON)
 			} else if strings.Contains(path, "networks?name=") {
 				rsp = "[]"
@@ -193,6 +195,19 @@ func TestClientNetworkInfoById(t *testing.T) {
 	}
 }
 
+func TestClientNetworkInspect(t *testing.T) {
+	var out, errOut bytes.Buffer
+	cli := NewNetworkCli(&out, &errOut, callbackFunc)
+
+	err := cli.Cmd("docker", "network", "inspect", mockNwName)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if expected := "{\n  \"Path\": \"/networks/" + mockNwID + "/datapath\"\n}\n"; out.String() != expected {
+		t.Fatalf("Unexpected output: %q", out.String())
+	}
+}
+
 // Docker Flag processing in flag.go uses os.Exit() frequently, even for --help
 // TODO : Handle the --help test-case in the IT when CLI is available
 /*
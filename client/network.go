@@ -24,6 +24,7 @@ var (
 		{"rm", "Remove a network"},
 		{"ls", "List all networks"},
 		{"info", "Display information of a network"},
+		{"inspect", "Dump the datapath state of a network in JSON"},
 	}
 )
 
@@ -206,6 +207,40 @@ func (cli *NetworkCli) CmdNetworkInfo(chain string, args ...string) error {
 	return nil
 }
 
+// CmdNetworkInspect handles Network Inspect UI
+func (cli *NetworkCli) CmdNetworkInspect(chain string, args ...string) error {
+	cmd := cli.Subcmd(chain, "inspect", "NETWORK", "Dumps the datapath state of a network and its endpoints in JSON", false)
+	cmd.Require(flag.Exact, 1)
+	err := cmd.ParseFlags(args, true)
+	if err != nil {
+		return err
+	}
+
+	id, err := lookupNetworkID(cli, cmd.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return cli.printJSON(readBody(cli.call("GET", "/networks/"+id+"/datapath", nil, nil)))
+}
+
+// printJSON prints indented the JSON body of a successful reply
+func (cli *NetworkCli) printJSON(obj []byte, statusCode int, err error) error {
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusOK {
+		return fmt.Errorf("request failed due to : statuscode(%d) %s", statusCode, strings.TrimSpace(string(obj)))
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, obj, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(cli.out)
+	return err
+}
+
 // Helper function to predict if a string is a name or id or partial-id
 // This provides a best-effort mechanism to identify an id with the help of GET Filter APIs
 // Being a UI, its most likely that name will be used by the user, which is used to lookup
@@ -23,6 +23,7 @@ var (
 		{"detach", "Detach the backend from the service"},
 		{"ls", "Lists all services"},
 		{"info", "Display information about a service"},
+		{"inspect", "Dump the datapath state of a service in JSON"},
 	}
 )
 
@@ -323,6 +324,37 @@ func (cli *NetworkCli) CmdServiceInfo(chain string, args ...string) error {
 	return nil
 }
 
+// CmdServiceInspect handles service inspect UI
+func (cli *NetworkCli) CmdServiceInspect(chain string, args ...string) error {
+	cmd := cli.Subcmd(chain, "inspect", "SERVICE[.NETWORK]", "Dumps the datapath state of a service in JSON", false)
+	cmd.Require(flag.Min, 1)
+
+	err := cmd.ParseFlags(args, true)
+	if err != nil {
+		return err
+	}
+
+	sn, nn := parseServiceName(cmd.Arg(0))
+	serviceID, err := lookupServiceID(cli, nn, sn)
+	if err != nil {
+		return err
+	}
+	obj, _, err := readBody(cli.call("GET", "/services/"+serviceID, nil, nil))
+	if err != nil {
+		return err
+	}
+	sr := &serviceResource{}
+	if err := json.NewDecoder(bytes.NewReader(obj)).Decode(sr); err != nil {
+		return err
+	}
+	networkID, err := lookupNetworkID(cli, sr.Network)
+	if err != nil {
+		return err
+	}
+
+	return cli.printJSON(readBody(cli.call("GET", "/networks/"+networkID+"/endpoints/"+serviceID+"/datapath", nil, nil)))
+}
+
 // CmdServiceAttach handles service attach UI
 func (cli *NetworkCli) CmdServiceAttach(chain string, args ...string) error {
 	cmd := cli.Subcmd(chain, "attach", "CONTAINER SERVICE[.NETWORK]", "Sets a container as a service backend", false)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -26,6 +27,12 @@ var (
 		Action: runContainerRm,
 	}
 
+	diagnoseCommand = cli.Command{
+		Name:   "diagnose",
+		Usage:  "Dump the datapath state of all the networks in JSON",
+		Action: runDiagnose,
+	}
+
 	containerCommands = []cli.Command{
 		containerCreateCommand,
 		containerRmCommand,
@@ -39,6 +46,8 @@ var (
 			Usage:       "Container management commands",
 			Subcommands: containerCommands,
 		},
+		diagnoseCommand,
+		migrateCommand,
 	}
 )
 
@@ -98,6 +107,24 @@ func runContainerRm(c *cli.Context) {
 	}
 }
 
+func runDiagnose(c *cli.Context) {
+	obj, statusCode, err := readBody(epConn.httpCall("GET", "/datapath", nil, nil))
+	if err == nil && statusCode != http.StatusOK {
+		err = fmt.Errorf("statuscode(%d) %s", statusCode, obj)
+	}
+	if err != nil {
+		fmt.Printf("GET failed during diagnose: %v\n", err)
+		os.Exit(1)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, obj, "", "  "); err != nil {
+		fmt.Printf("Invalid diagnose response: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(out.String())
+}
+
 func runDockerCommand(c *cli.Context, cmd string) {
 	_, stdout, stderr := term.StdStreams()
 	oldcli := client.NewNetworkCli(stdout, stderr, epConn.httpCall)
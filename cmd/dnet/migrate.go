@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/codegangsta/cli"
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+	"github.com/docker/libkv/store/consul"
+	"github.com/docker/libkv/store/etcd"
+	"github.com/docker/libkv/store/zookeeper"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/datastore/redis"
+)
+
+var migrateCommand = cli.Command{
+	Name:  "migrate",
+	Usage: "Copy the libnetwork keys from a datastore to another",
+	Flags: []cli.Flag{
+		cli.StringFlag{Name: "src-provider", Usage: "Provider of the source datastore (boltdb, consul, etcd, zk, redis)"},
+		cli.StringFlag{Name: "src-url", Usage: "Address of the source datastore, the file of a boltdb one"},
+		cli.StringFlag{Name: "dst-provider", Usage: "Provider of the destination datastore"},
+		cli.StringFlag{Name: "dst-url", Usage: "Address of the destination datastore"},
+		cli.BoolFlag{Name: "dry-run", Usage: "Report what would be copied without writing"},
+		cli.BoolFlag{Name: "overwrite", Usage: "Overwrite the keys the destination holds with another value"},
+	},
+	Action: runMigrate,
+}
+
+func migrationStore(provider, url string) (datastore.DataStore, error) {
+	if provider == "" || url == "" {
+		return nil, fmt.Errorf("missing provider or url")
+	}
+	cfg := &datastore.ScopeCfg{
+		Client: datastore.ScopeClientCfg{
+			Provider: provider,
+			Address:  url,
+		},
+	}
+	if provider == string(store.BOLTDB) {
+		cfg.Client.Config = &store.Config{Bucket: "libnetwork"}
+	}
+	return datastore.NewDataStore(datastore.GlobalScope, cfg)
+}
+
+func runMigrate(c *cli.Context) {
+	consul.Register()
+	zookeeper.Register()
+	etcd.Register()
+	boltdb.Register()
+	redis.Register()
+
+	src, err := migrationStore(c.String("src-provider"), c.String("src-url"))
+	if err != nil {
+		fmt.Printf("Failed to open the source datastore: %v\n", err)
+		os.Exit(1)
+	}
+	defer src.Close()
+	dst, err := migrationStore(c.String("dst-provider"), c.String("dst-url"))
+	if err != nil {
+		fmt.Printf("Failed to open the destination datastore: %v\n", err)
+		os.Exit(1)
+	}
+	defer dst.Close()
+
+	r, err := datastore.Migrate(src, dst, c.Bool("dry-run"), c.Bool("overwrite"))
+	if err != nil {
+		fmt.Printf("Migration failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(r.String())
+	if len(r.Errors) > 0 {
+		os.Exit(1)
+	}
+}
@@ -7,16 +7,19 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"github.com/docker/libnetwork/proxy"
+	"github.com/docker/libnetwork/types"
 	"github.com/ishidawataru/sctp"
 )
 
 func main() {
 	f := os.NewFile(3, "signal-parent")
-	host, container := parseHostContainerAddrs()
+	host, container, opts := parseHostContainerAddrs()
 
-	p, err := NewProxy(host, container)
+	p, err := proxy.NewProxy(host, container, opts...)
 	if err != nil {
 		fmt.Fprintf(f, "1\n%s", err)
 		f.Close()
@@ -30,15 +33,16 @@ func main() {
 	p.Run()
 }
 
-// parseHostContainerAddrs parses the flags passed on reexec to create the TCP/UDP/SCTP
-// net.Addrs to map the host and container ports
-func parseHostContainerAddrs() (host net.Addr, container net.Addr) {
+// parseHostContainerAddrs parses the flags passed on reexec to create the TCP/UDP/SCTP/DCCP
+// net.Addrs to map the host and container ports, and the options of the proxy
+func parseHostContainerAddrs() (host net.Addr, container net.Addr, opts []proxy.Option) {
 	var (
 		proto         = flag.String("proto", "tcp", "proxy protocol")
 		hostIP        = flag.String("host-ip", "", "host ip")
 		hostPort      = flag.Int("host-port", -1, "host port")
 		containerIP   = flag.String("container-ip", "", "container ip")
 		containerPort = flag.Int("container-port", -1, "container port")
+		proxyProtocol = flag.Int("proxy-protocol", 0, "version of the PROXY protocol header sent to the container")
 	)
 
 	flag.Parse()
@@ -51,16 +55,33 @@ func parseHostContainerAddrs() (host net.Addr, container net.Addr) {
 		host = &net.UDPAddr{IP: net.ParseIP(*hostIP), Port: *hostPort}
 		container = &net.UDPAddr{IP: net.ParseIP(*containerIP), Port: *containerPort}
 	case "sctp":
-		host = &sctp.SCTPAddr{IP: []net.IP{net.ParseIP(*hostIP)}, Port: *hostPort}
-		container = &sctp.SCTPAddr{IP: []net.IP{net.ParseIP(*containerIP)}, Port: *containerPort}
+		// the addresses of multihomed endpoints are separated by commas
+		host = &sctp.SCTPAddr{IP: parseIPs(*hostIP), Port: *hostPort}
+		container = &sctp.SCTPAddr{IP: parseIPs(*containerIP), Port: *containerPort}
+	case "dccp":
+		host = &types.DCCPAddr{IP: net.ParseIP(*hostIP), Port: *hostPort}
+		container = &types.DCCPAddr{IP: net.ParseIP(*containerIP), Port: *containerPort}
 	default:
 		log.Fatalf("unsupported protocol %s", *proto)
 	}
 
-	return host, container
+	if *proxyProtocol != 0 {
+		opts = append(opts, proxy.WithProxyProtocol(*proxyProtocol))
+	}
+
+	return host, container, opts
+}
+
+// parseIPs parses the IP addresses separated by commas
+func parseIPs(s string) []net.IP {
+	var ips []net.IP
+	for _, ip := range strings.Split(s, ",") {
+		ips = append(ips, net.ParseIP(ip))
+	}
+	return ips
 }
 
-func handleStopSignals(p Proxy) {
+func handleStopSignals(p proxy.Proxy) {
 	s := make(chan os.Signal, 10)
 	signal.Notify(s, os.Interrupt, syscall.SIGTERM)
 
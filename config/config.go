@@ -2,6 +2,7 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/docker/docker/pkg/discovery"
@@ -11,8 +12,12 @@ import (
 	"github.com/docker/libnetwork/cluster"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/ipamutils"
+	"github.com/docker/libnetwork/keyprovider"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/networkdb"
 	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/rootless"
+	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 )
 
@@ -43,6 +48,126 @@ type DaemonCfg struct {
 	ClusterProvider        cluster.Provider
 	NetworkControlPlaneMTU int
 	DefaultAddressPool     []*ipamutils.NetworkToSplit
+	DNSExport              DNSExportCfg
+	// DNSUpstreams, when not empty, are the external nameservers used by
+	// the sandboxes instead of the ones found in the host resolv.conf
+	DNSUpstreams []string
+	// KeyProvider, when set, supplies the gossip and data path encryption
+	// keys in place of the swarm manager
+	KeyProvider keyprovider.Provider
+	// KeyRotationHooks are called with the new keyring after every key change
+	KeyRotationHooks []func([]*types.EncryptionKey)
+	// NetworkDBSnapshotPath, when set, is the file the NetworkDB tables are
+	// saved to, so that they can be served right after a restart
+	NetworkDBSnapshotPath string
+	// NetworkDBGossip are the gossip parameters of NetworkDB, the zero
+	// values keep the defaults
+	NetworkDBGossip networkdb.GossipParams
+	// NetworkDBTCPOnly runs the NetworkDB gossip over TCP only, for the
+	// environments where UDP between the nodes is blocked
+	NetworkDBTCPOnly bool
+	// NetworkDBPort is the port of the NetworkDB gossip, 7946 when zero.
+	// All the nodes of the cluster must use the same port.
+	NetworkDBPort int
+	// ServiceRecordStore, when valid, is the consistent KV store the
+	// service records are kept in instead of being gossiped. All the nodes
+	// of the cluster must use the same store.
+	ServiceRecordStore *datastore.ScopeCfg
+	// PartitionHooks are called when a partition of the gossip cluster is
+	// suspected, with detected true, and when the suspicion is cleared
+	PartitionHooks []func(p networkdb.Partition, detected bool)
+	// HealthGrace is how long an unhealthy service endpoint must report
+	// healthy before it is put back in the load balancers and in the DNS
+	// answers, so that flapping backends stay out. Zero puts them back
+	// right away.
+	HealthGrace time.Duration
+	// DrainPeriod is how long a removed service backend keeps serving its
+	// established connections, with no new ones, before it is deleted from
+	// the load balancers. Zero deletes it right away.
+	DrainPeriod time.Duration
+	// IPVSSync, when enabled, runs the kernel IPVS connection
+	// synchronization daemons in the ingress sandbox, so that the
+	// established ingress connections survive the failover to another node
+	IPVSSync IPVSSyncCfg
+	// VerifyLocalStore and CompactLocalStore run the consistency check and
+	// the compaction of the local boltdb store at startup, the compaction
+	// is skipped when the check fails
+	VerifyLocalStore  bool
+	CompactLocalStore bool
+	// Diagnostic secures the diagnostic server
+	Diagnostic DiagnosticCfg
+	// Rootless runs the networking in the namespaces of RootlessKit, the
+	// published ports are exposed through its port driver and the features
+	// needing privileges on the host are disabled. It defaults to whether
+	// the process runs with RootlessKit.
+	Rootless bool
+	// FirewallBackend is the netfilter backend of the firewall rules, as
+	// named by the firewallapi package, empty for the backend of the
+	// iptables commands of the host
+	FirewallBackend string
+	// TagFirewallRules tags the firewall rules of the networks with a
+	// comment naming their network, for the rules of the networks gone
+	// to be cleaned with CleanOrphans
+	TagFirewallRules bool
+	// FirewallAudit is the audit mode of the commands changing the
+	// firewall rules: "log" logs them, "dry-run" logs and records them
+	// without running them, empty or "off" runs them unlogged
+	FirewallAudit string
+	// Xtables locates the iptables and ip6tables commands
+	Xtables XtablesCfg
+	// FirewallReconcileInterval is the interval the iptables rules of the
+	// networks are checked at and repaired when missing, 0 not checking
+	FirewallReconcileInterval time.Duration
+}
+
+// XtablesCfg represents the paths of the iptables and ip6tables commands,
+// looked up in PATH with the names of the firewall backend when empty, and
+// the time they wait for the xtables lock
+type XtablesCfg struct {
+	IptablesPath         string
+	IptablesRestorePath  string
+	Ip6tablesPath        string
+	Ip6tablesRestorePath string
+	// WaitSeconds is the time in seconds the commands wait for the
+	// xtables lock, 0 waiting until it is released
+	WaitSeconds int
+}
+
+// DiagnosticCfg represents the authentication of the diagnostic server
+// clients. With a CAFile, the server is served over mutual TLS.
+type DiagnosticCfg struct {
+	// Token, when not empty, must be presented by the clients as a
+	// bearer token
+	Token string
+	// CertFile and KeyFile, when set, serve the diagnostic server over TLS
+	CertFile string
+	KeyFile  string
+	// CAFile, when set, holds the CA the client certificates must be
+	// signed by
+	CAFile string
+}
+
+// IPVSSyncCfg represents the configuration of the IPVS connection
+// synchronization between the nodes. The connection states are multicast,
+// the interface must carry multicast between the nodes.
+type IPVSSyncCfg struct {
+	Enable bool
+	// Interface is the ingress sandbox interface the states are exchanged
+	// on, the ingress network interface when empty
+	Interface string
+	// SyncID identifies the nodes synchronizing together, the states of
+	// the other IDs are ignored
+	SyncID uint8
+}
+
+// DNSExportCfg represents the configuration of the host facing DNS server
+// which exports the container records of the embedded DNS server
+type DNSExportCfg struct {
+	// Address the export server listens on, in host:port form. An empty
+	// address disables the export
+	Address string
+	// Zone the records are served under, as <name>.<network>.<zone>
+	Zone string
 }
 
 // ClusterCfg represents cluster configuration
@@ -83,6 +208,7 @@ func ParseConfigOptions(cfgOptions ...Option) *Config {
 	cfg := &Config{
 		Daemon: DaemonCfg{
 			DriverCfg: make(map[string]interface{}),
+			Rootless:  rootless.RunningWithRootlessKit(),
 		},
 		Scopes: make(map[string]*datastore.ScopeCfg),
 	}
@@ -160,6 +286,44 @@ func OptionKVProviderURL(url string) Option {
 	}
 }
 
+// OptionKVCacheTTL function returns an option setter for the time the
+// reads of the kvstore are cached
+func OptionKVCacheTTL(ttl time.Duration) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option OptionKVCacheTTL: %v", ttl)
+		if _, ok := c.Scopes[datastore.GlobalScope]; !ok {
+			c.Scopes[datastore.GlobalScope] = &datastore.ScopeCfg{}
+		}
+		c.Scopes[datastore.GlobalScope].Client.CacheTTL = ttl
+	}
+}
+
+// OptionKVNamespace function returns an option setter for the namespace
+// the keys of the kvstore are put under, for the daemons sharing a kvstore
+func OptionKVNamespace(namespace string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option OptionKVNamespace: %s", namespace)
+		if _, ok := c.Scopes[datastore.GlobalScope]; !ok {
+			c.Scopes[datastore.GlobalScope] = &datastore.ScopeCfg{}
+		}
+		c.Scopes[datastore.GlobalScope].Client.Namespace = strings.TrimSpace(namespace)
+	}
+}
+
+// OptionKVSlowThreshold function returns an option setter for the
+// latency from which the calls to the local and global kvstores are logged
+func OptionKVSlowThreshold(threshold time.Duration) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option OptionKVSlowThreshold: %v", threshold)
+		for _, scope := range []string{datastore.LocalScope, datastore.GlobalScope} {
+			if _, ok := c.Scopes[scope]; !ok {
+				c.Scopes[scope] = &datastore.ScopeCfg{}
+			}
+			c.Scopes[scope].Client.SlowThreshold = threshold
+		}
+	}
+}
+
 // OptionKVOpts function returns an option setter for kvstore options
 func OptionKVOpts(opts map[string]string) Option {
 	return func(c *Config) {
@@ -238,6 +402,79 @@ func OptionExperimental(exp bool) Option {
 	}
 }
 
+// OptionRootless function returns an option setter for the rootless mode
+func OptionRootless(enable bool) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option Rootless: %v", enable)
+		c.Daemon.Rootless = enable
+	}
+}
+
+// OptionFirewallBackend function returns an option setter for the netfilter
+// backend of the firewall rules
+func OptionFirewallBackend(name string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option FirewallBackend: %s", name)
+		c.Daemon.FirewallBackend = name
+	}
+}
+
+// OptionTagFirewallRules function returns an option setter for the tagging
+// of the firewall rules with their owner
+func OptionTagFirewallRules(enable bool) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option TagFirewallRules: %v", enable)
+		c.Daemon.TagFirewallRules = enable
+	}
+}
+
+// OptionFirewallAudit function returns an option setter for the audit mode
+// of the commands changing the firewall rules
+func OptionFirewallAudit(mode string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option FirewallAudit: %s", mode)
+		c.Daemon.FirewallAudit = mode
+	}
+}
+
+// OptionIptablesPaths function returns an option setter for the paths of
+// the iptables and iptables-restore commands
+func OptionIptablesPaths(iptables, restore string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option IptablesPaths: %s, %s", iptables, restore)
+		c.Daemon.Xtables.IptablesPath = iptables
+		c.Daemon.Xtables.IptablesRestorePath = restore
+	}
+}
+
+// OptionIp6tablesPaths function returns an option setter for the paths of
+// the ip6tables and ip6tables-restore commands
+func OptionIp6tablesPaths(ip6tables, restore string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option Ip6tablesPaths: %s, %s", ip6tables, restore)
+		c.Daemon.Xtables.Ip6tablesPath = ip6tables
+		c.Daemon.Xtables.Ip6tablesRestorePath = restore
+	}
+}
+
+// OptionXtablesWait function returns an option setter for the time in
+// seconds the iptables and ip6tables commands wait for the xtables lock
+func OptionXtablesWait(seconds int) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option XtablesWait: %d", seconds)
+		c.Daemon.Xtables.WaitSeconds = seconds
+	}
+}
+
+// OptionFirewallReconcileInterval function returns an option setter for the
+// interval the iptables rules are checked and repaired at
+func OptionFirewallReconcileInterval(interval time.Duration) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option FirewallReconcileInterval: %v", interval)
+		c.Daemon.FirewallReconcileInterval = interval
+	}
+}
+
 // OptionNetworkControlPlaneMTU function returns an option setter for control plane MTU
 func OptionNetworkControlPlaneMTU(exp int) Option {
 	return func(c *Config) {
@@ -253,6 +490,151 @@ func OptionNetworkControlPlaneMTU(exp int) Option {
 	}
 }
 
+// OptionDNSExport function returns an option setter for the host facing DNS
+// server exporting the container records under the passed zone
+func OptionDNSExport(address, zone string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option DNSExport: %s %s", address, zone)
+		c.Daemon.DNSExport = DNSExportCfg{
+			Address: strings.TrimSpace(address),
+			Zone:    strings.Trim(strings.TrimSpace(zone), "."),
+		}
+	}
+}
+
+// OptionDNSUpstreams function returns an option setter for the external
+// nameservers overriding the ones of the host resolv.conf
+func OptionDNSUpstreams(servers []string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option DNSUpstreams: %v", servers)
+		c.Daemon.DNSUpstreams = servers
+	}
+}
+
+// OptionNetworkDBSnapshot function returns an option setter for the file the
+// NetworkDB snapshot is saved to
+func OptionNetworkDBSnapshot(path string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option NetworkDBSnapshot: %s", path)
+		c.Daemon.NetworkDBSnapshotPath = path
+	}
+}
+
+// OptionNetworkDBGossip function returns an option setter for the NetworkDB
+// gossip parameters
+func OptionNetworkDBGossip(p networkdb.GossipParams) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option NetworkDBGossip: %+v", p)
+		c.Daemon.NetworkDBGossip = p
+	}
+}
+
+// OptionNetworkDBTransport function returns an option setter for the
+// transport of the NetworkDB gossip
+func OptionNetworkDBTransport(tcpOnly bool, port int) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option NetworkDBTransport: tcp-only:%t port:%d", tcpOnly, port)
+		c.Daemon.NetworkDBTCPOnly = tcpOnly
+		c.Daemon.NetworkDBPort = port
+	}
+}
+
+// OptionServiceRecordStore function returns an option setter for the KV
+// store backing the service records of the cluster
+func OptionServiceRecordStore(provider, url string) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option ServiceRecordStore: %s %s", provider, url)
+		c.Daemon.ServiceRecordStore = &datastore.ScopeCfg{
+			Client: datastore.ScopeClientCfg{
+				Provider: strings.TrimSpace(provider),
+				Address:  strings.TrimSpace(url),
+			},
+		}
+	}
+}
+
+// OptionKeyProvider function returns an option setter for the provider of
+// the gossip and data path encryption keys
+func OptionKeyProvider(p keyprovider.Provider) Option {
+	return func(c *Config) {
+		c.Daemon.KeyProvider = p
+	}
+}
+
+// OptionKeyRotationHook function returns an option setter adding a function
+// called with the new keyring after every key change
+func OptionKeyRotationHook(hook func([]*types.EncryptionKey)) Option {
+	return func(c *Config) {
+		c.Daemon.KeyRotationHooks = append(c.Daemon.KeyRotationHooks, hook)
+	}
+}
+
+// OptionPartitionHook function returns an option setter adding a function
+// called when a partition of the gossip cluster is suspected or cleared
+func OptionPartitionHook(hook func(p networkdb.Partition, detected bool)) Option {
+	return func(c *Config) {
+		c.Daemon.PartitionHooks = append(c.Daemon.PartitionHooks, hook)
+	}
+}
+
+// OptionHealthGrace function returns an option setter for the time an
+// unhealthy service endpoint must stay healthy before being used again
+func OptionHealthGrace(grace time.Duration) Option {
+	return func(c *Config) {
+		c.Daemon.HealthGrace = grace
+	}
+}
+
+// OptionDrainPeriod function returns an option setter for the time a removed
+// service backend keeps serving its established connections
+func OptionDrainPeriod(period time.Duration) Option {
+	return func(c *Config) {
+		c.Daemon.DrainPeriod = period
+	}
+}
+
+// OptionDiagnosticToken function returns an option setter for the bearer
+// token the diagnostic server requires
+func OptionDiagnosticToken(token string) Option {
+	return func(c *Config) {
+		c.Daemon.Diagnostic.Token = token
+	}
+}
+
+// OptionDiagnosticTLS function returns an option setter serving the
+// diagnostic server over TLS, requiring client certificates signed by the
+// CA of caFile when not empty
+func OptionDiagnosticTLS(certFile, keyFile, caFile string) Option {
+	return func(c *Config) {
+		c.Daemon.Diagnostic.CertFile = certFile
+		c.Daemon.Diagnostic.KeyFile = keyFile
+		c.Daemon.Diagnostic.CAFile = caFile
+	}
+}
+
+// OptionIPVSSync function returns an option setter enabling the IPVS
+// connection synchronization of the ingress load balancers
+func OptionIPVSSync(iface string, syncID uint8) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option IPVSSync: %s %d", iface, syncID)
+		c.Daemon.IPVSSync = IPVSSyncCfg{
+			Enable:    true,
+			Interface: strings.TrimSpace(iface),
+			SyncID:    syncID,
+		}
+	}
+}
+
+// OptionLocalStoreMaintenance function returns an option setter for the
+// maintenance of the local boltdb store at startup
+func OptionLocalStoreMaintenance(verify, compact bool) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option LocalStoreMaintenance: verify:%t compact:%t", verify, compact)
+		c.Daemon.VerifyLocalStore = verify
+		c.Daemon.CompactLocalStore = compact
+	}
+}
+
 // ProcessOptions processes options and stores it in config
 func (c *Config) ProcessOptions(options ...Option) {
 	for _, opt := range options {
@@ -300,6 +682,19 @@ func OptionLocalKVProviderConfig(config *store.Config) Option {
 	}
 }
 
+// OptionLocalKVEncryption function returns an option setter for the key
+// provider encrypting the values of the local kvstore, such as a
+// datastore.StaticKey or datastore.FileKey
+func OptionLocalKVEncryption(keys datastore.KeyProvider) Option {
+	return func(c *Config) {
+		logrus.Debugf("Option OptionLocalKVEncryption: %T", keys)
+		if _, ok := c.Scopes[datastore.LocalScope]; !ok {
+			c.Scopes[datastore.LocalScope] = &datastore.ScopeCfg{}
+		}
+		c.Scopes[datastore.LocalScope].Client.KeyProvider = keys
+	}
+}
+
 // OptionActiveSandboxes function returns an option setter for passing the sandboxes
 // which were active during previous daemon life
 func OptionActiveSandboxes(sandboxes map[string]interface{}) Option {
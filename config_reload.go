@@ -0,0 +1,188 @@
+package libnetwork
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigReload reports the daemon settings changed by a configuration
+// reload. The driver settings are named after their driver, as in
+// "bridge.userland-proxy".
+type ConfigReload struct {
+	// Applied are the changed settings in effect
+	Applied []string
+	// Recreate are the changed settings in effect for the new networks,
+	// with the existing networks keeping the previous value until they
+	// are recreated
+	Recreate map[string][]string
+	// Restart are the changed settings which only apply after a restart
+	// of the controller
+	Restart []string
+}
+
+func (r *ConfigReload) warn() {
+	for s, nids := range r.Recreate {
+		logrus.Warnf("The changed %s setting applies to the networks %v once they are recreated", s, nids)
+	}
+	for _, s := range r.Restart {
+		logrus.Warnf("The changed %s setting applies after a restart", s)
+	}
+}
+
+func (c *controller) ReloadDaemonConfiguration(cfgOptions ...config.Option) (*ConfigReload, error) {
+	procReloadConfig <- true
+	defer func() { <-procReloadConfig }()
+
+	return c.reloadDaemonConfig(config.ParseConfigOptions(cfgOptions...))
+}
+
+// reloadDaemonConfig applies the daemon settings of cfg which changed, the
+// settings cfg leaves unset keeping their value. The settings are applied
+// in turn, an error rolls back the ones already applied and leaves the
+// configuration of the controller as it was.
+func (c *controller) reloadDaemonConfig(cfg *config.Config) (_ *ConfigReload, err error) {
+	c.Lock()
+	old := c.cfg
+	agentRunning := c.agent != nil
+	c.Unlock()
+
+	keepUnsetDaemonConfig(cfg, old)
+
+	r := &ConfigReload{Recreate: map[string][]string{}}
+
+	var rollback []func() error
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(rollback) - 1; i >= 0; i-- {
+			if rerr := rollback[i](); rerr != nil {
+				logrus.Errorf("Failed to roll back the configuration reload: %v", rerr)
+			}
+		}
+	}()
+
+	// The networks keep their pools, only the new ones come from the new
+	// default pools
+	if !reflect.DeepEqual(old.Daemon.DefaultAddressPool, cfg.Daemon.DefaultAddressPool) {
+		if err := reloadIPAMDefaultPools(c.drvRegistry, cfg.Daemon.DefaultAddressPool); err != nil {
+			return nil, types.BadRequestErrorf("invalid default address pools: %v", err)
+		}
+		rollback = append(rollback, func() error {
+			return reloadIPAMDefaultPools(c.drvRegistry, old.Daemon.DefaultAddressPool)
+		})
+		r.Applied = append(r.Applied, "default-address-pools")
+	}
+
+	c.drvRegistry.WalkDrivers(func(name string, driver driverapi.Driver, capability driverapi.Capability) bool {
+		if !driver.IsBuiltIn() || reflect.DeepEqual(driverOptions(old, name), driverOptions(cfg, name)) {
+			return false
+		}
+		cr, ok := driver.(driverapi.ConfigReloader)
+		if !ok {
+			r.Restart = append(r.Restart, name+".config")
+			return false
+		}
+		changed, rerr := cr.ReloadConfig(driverConfig(cfg, name))
+		if rerr != nil {
+			err = types.InternalErrorf("failed to reload the %s driver configuration: %v", name, rerr)
+			return true
+		}
+		rollback = append(rollback, func() error {
+			_, err := cr.ReloadConfig(driverConfig(old, name))
+			return err
+		})
+		for s, nids := range changed {
+			if len(nids) == 0 {
+				r.Applied = append(r.Applied, name+"."+s)
+			} else {
+				r.Recreate[name+"."+s] = nids
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The control plane MTU is read when the agent starts
+	if old.Daemon.NetworkControlPlaneMTU != cfg.Daemon.NetworkControlPlaneMTU {
+		if agentRunning {
+			r.Restart = append(r.Restart, "network-control-plane-mtu")
+		} else {
+			r.Applied = append(r.Applied, "network-control-plane-mtu")
+		}
+	}
+	if old.Daemon.Rootless != cfg.Daemon.Rootless {
+		r.Restart = append(r.Restart, "rootless")
+	}
+	if old.Daemon.FirewallBackend != cfg.Daemon.FirewallBackend {
+		r.Restart = append(r.Restart, "firewall-backend")
+	}
+	if old.Daemon.Xtables != cfg.Daemon.Xtables {
+		r.Restart = append(r.Restart, "xtables")
+	}
+	if old.Daemon.FirewallReconcileInterval != cfg.Daemon.FirewallReconcileInterval {
+		r.Restart = append(r.Restart, "firewall-reconcile-interval")
+	}
+	if old.Daemon.FirewallAudit != cfg.Daemon.FirewallAudit {
+		if err := setFirewallAudit(cfg.Daemon.FirewallAudit); err != nil {
+			return nil, err
+		}
+		r.Applied = append(r.Applied, "firewall-audit")
+	}
+	sort.Strings(r.Applied)
+	sort.Strings(r.Restart)
+
+	c.Lock()
+	ncfg := *c.cfg
+	ncfg.Daemon.DefaultAddressPool = cfg.Daemon.DefaultAddressPool
+	ncfg.Daemon.Labels = cfg.Daemon.Labels
+	ncfg.Daemon.DriverCfg = cfg.Daemon.DriverCfg
+	ncfg.Daemon.NetworkControlPlaneMTU = cfg.Daemon.NetworkControlPlaneMTU
+	ncfg.Daemon.FirewallAudit = cfg.Daemon.FirewallAudit
+	c.cfg = &ncfg
+	c.Unlock()
+
+	return r, nil
+}
+
+// keepUnsetDaemonConfig sets the daemon settings cfg leaves unset to their
+// value in old, so that a reload only changes the settings it is given
+func keepUnsetDaemonConfig(cfg, old *config.Config) {
+	d, o := &cfg.Daemon, &old.Daemon
+	if d.DefaultAddressPool == nil {
+		d.DefaultAddressPool = o.DefaultAddressPool
+	}
+	if d.Labels == nil {
+		d.Labels = o.Labels
+	}
+	if d.DriverCfg == nil {
+		d.DriverCfg = make(map[string]interface{}, len(o.DriverCfg))
+	}
+	for name, dcfg := range o.DriverCfg {
+		if _, ok := d.DriverCfg[name]; !ok {
+			d.DriverCfg[name] = dcfg
+		}
+	}
+	if d.NetworkControlPlaneMTU == 0 {
+		d.NetworkControlPlaneMTU = o.NetworkControlPlaneMTU
+	}
+	if d.FirewallBackend == "" {
+		d.FirewallBackend = o.FirewallBackend
+	}
+	if d.Xtables == (config.XtablesCfg{}) {
+		d.Xtables = o.Xtables
+	}
+	if d.FirewallReconcileInterval == 0 {
+		d.FirewallReconcileInterval = o.FirewallReconcileInterval
+	}
+	if d.FirewallAudit == "" {
+		d.FirewallAudit = o.FirewallAudit
+	}
+}
@@ -44,6 +44,7 @@ create network namespaces and allocate interfaces for containers to use.
 package libnetwork
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"path/filepath"
@@ -59,6 +60,7 @@ import (
 	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/libnetwork/cluster"
 	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/correlation"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/diagnostic"
 	"github.com/docker/libnetwork/discoverapi"
@@ -67,7 +69,11 @@ import (
 	"github.com/docker/libnetwork/hostdiscovery"
 	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/networkdb"
+	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/resolvconf"
+	"github.com/docker/libnetwork/tracing"
 	"github.com/docker/libnetwork/types"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -91,6 +97,10 @@ type NetworkController interface {
 	// Create a new network. The options parameter carries network specific options.
 	NewNetwork(networkType, name string, id string, options ...NetworkOption) (Network, error)
 
+	// NewNetworkContext is NewNetwork which gives up and rolls back the network
+	// creation when the context is done.
+	NewNetworkContext(ctx context.Context, networkType, name string, id string, options ...NetworkOption) (Network, error)
+
 	// Networks returns the list of Network(s) managed by this controller.
 	Networks() []Network
 
@@ -106,6 +116,10 @@ type NetworkController interface {
 	// NewSandbox creates a new network sandbox for the passed container id
 	NewSandbox(containerID string, options ...SandboxOption) (Sandbox, error)
 
+	// NewSandboxContext is NewSandbox which gives up and rolls back the sandbox
+	// creation when the context is done.
+	NewSandboxContext(ctx context.Context, containerID string, options ...SandboxOption) (Sandbox, error)
+
 	// Sandboxes returns the list of Sandbox(s) managed by this controller.
 	Sandboxes() []Sandbox
 
@@ -121,9 +135,15 @@ type NetworkController interface {
 	// Stop network controller
 	Stop()
 
-	// ReloadConfiguration updates the controller configuration
+	// ReloadConfiguration updates the controller configuration, the
+	// settings the options leave unset keeping their value
 	ReloadConfiguration(cfgOptions ...config.Option) error
 
+	// ReloadDaemonConfiguration applies the changed daemon settings of the
+	// configuration to the running controller, and reports the changes. The
+	// settings the options leave unset keep their value.
+	ReloadDaemonConfiguration(cfgOptions ...config.Option) (*ConfigReload, error)
+
 	// SetClusterProvider sets cluster provider
 	SetClusterProvider(provider cluster.Provider)
 
@@ -136,12 +156,77 @@ type NetworkController interface {
 	// SetKeys configures the encryption key for gossip and overlay data path
 	SetKeys(keys []*types.EncryptionKey) error
 
+	// SetGossipParams changes the gossip parameters of NetworkDB
+	SetGossipParams(p networkdb.GossipParams) error
+	// GossipParams returns the gossip parameters of NetworkDB
+	GossipParams() networkdb.GossipParams
+	// ClusterPartitions returns the partitions of the gossip cluster
+	// suspected by this node
+	ClusterPartitions() []networkdb.Partition
+
+	// LoadBalancerStats returns the load balancer counters of the services
+	// of this node, restricted to the network identified by nid if not empty
+	LoadBalancerStats(nid string) []*ServiceLBStats
+
+	// RegisterIngressProxy redirects the ingress traffic of a TCP published
+	// port to a local proxy listening on proxyPort
+	RegisterIngressProxy(publishedPort uint32, proxyPort uint16) error
+	// UnregisterIngressProxy gives the published port back to the ingress
+	// load balancer
+	UnregisterIngressProxy(publishedPort uint32) error
+	// IngressProxyUpstream returns the address a proxy forwards the
+	// connections of the published port to
+	IngressProxyUpstream(publishedPort uint32) (string, error)
+
 	// StartDiagnostic start the network diagnostic mode
 	StartDiagnostic(port int)
 	// StopDiagnostic start the network diagnostic mode
 	StopDiagnostic()
 	// IsDiagnosticEnabled returns true if the diagnostic is enabled
 	IsDiagnosticEnabled() bool
+
+	// SetDNSViews replaces the DNS view rules for the queries coming from the
+	// sandboxes attached to the network identified by the passed id
+	SetDNSViews(nid string, rules []*DNSViewRule) error
+	// DNSViews returns the DNS view rules defined for the network identified by the passed id
+	DNSViews(nid string) []*DNSViewRule
+
+	// SetDNSRecordWeights replaces the weights steering the answers returned for
+	// the passed name in the network identified by the passed id
+	SetDNSRecordWeights(nid, name string, weights []*DNSRecordWeight) error
+	// DNSRecordWeights returns the weights set for the passed name in the network identified by the passed id
+	DNSRecordWeights(nid, name string) []*DNSRecordWeight
+
+	// DNSUpstreams returns the external nameservers used by the sandboxes
+	// inheriting the host DNS configuration, and why they were chosen
+	DNSUpstreams() (*resolvconf.Upstreams, error)
+
+	// SetEndpointHealth records the health state of the endpoint identified by
+	// eid, so that unhealthy backends are left out of the service DNS answers
+	SetEndpointHealth(nid, eid string, healthy bool) error
+
+	// Subscribe returns a subscription to the lifecycle events of the
+	// networks, endpoints and sandboxes and to the driver errors
+	Subscribe() *EventSubscription
+
+	// SetNetworkPolicy replaces the rules allowing or denying the traffic
+	// between the endpoints, and keeps them enforced on this host
+	SetNetworkPolicy(rules []*PolicyRule) error
+	// NetworkPolicy returns the network policy rules
+	NetworkPolicy() []*PolicyRule
+
+	// CleanOrphans deletes the tagged firewall rules of the networks which
+	// no longer exist, as the ones left behind by an unclean shutdown, and
+	// returns the number of the deleted rules
+	CleanOrphans() (int, error)
+
+	// SetNetworkFirewallRules replaces the firewall rules of the caller
+	// registered under name in the network identified by nid, installed
+	// in the chains of the network and re-applied with its rules
+	SetNetworkFirewallRules(nid, name string, rules []driverapi.FirewallRule) error
+	// NetworkFirewallRules returns the firewall rules of the callers of the
+	// network identified by nid, by name
+	NetworkFirewallRules(nid string) (map[string][]driverapi.FirewallRule, error)
 }
 
 // NetworkWalker is a client provided function which will be used to walk the Networks.
@@ -177,6 +262,25 @@ type controller struct {
 	keys                   []*types.EncryptionKey
 	clusterConfigAvailable bool
 	DiagnosticServer       *diagnostic.Server
+	dnsExporter            *dnsExporter
+	mdnsResponders         map[string]*mdnsResponder
+	dnsViews               map[string][]*DNSViewRule
+	dnsWeights             map[string]map[string][]*DNSRecordWeight
+	endpointHealth         map[string]map[string][]net.IP
+	healthMu               sync.Mutex
+	healthRecoveries       map[string]*time.Timer
+	keyProviderStop        chan struct{}
+	migrationSweepStop     chan struct{}
+	eventsMu               sync.Mutex
+	eventSeq               uint64
+	subscribers            map[*EventSubscription]struct{}
+	policy                 []*PolicyRule
+	policyEvents           *EventSubscription
+	policyMu               sync.Mutex
+	policyProgrammed       map[int]*compiledPolicy
+	policySynced           bool
+	policyReloadOnce       sync.Once
+	removeDriftCallback    func()
 	sync.Mutex
 }
 
@@ -198,6 +302,25 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 		DiagnosticServer: diagnostic.New(),
 	}
 	c.DiagnosticServer.Init()
+	c.DiagnosticServer.RegisterHandler(c, resolverPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, lbPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, storePaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, datapathPaths2Func)
+	c.DiagnosticServer.RegisterHandler(c, allocatorPaths2Func)
+	if err := c.secureDiagnostic(); err != nil {
+		return nil, err
+	}
+	if err := c.setXtables(); err != nil {
+		return nil, err
+	}
+	if err := c.setFirewallBackend(); err != nil {
+		return nil, err
+	}
+	c.setOwnerTagging()
+	if err := setFirewallAudit(c.cfg.Daemon.FirewallAudit); err != nil {
+		return nil, err
+	}
+	c.startFirewallReconciler()
 
 	if err := c.initStores(); err != nil {
 		return nil, err
@@ -248,10 +371,26 @@ func New(cfgOptions ...config.Option) (NetworkController, error) {
 	c.cleanupLocalEndpoints()
 	c.networkCleanup()
 
+	c.restoreMDNS()
+	c.restoreDNSViews()
+	c.restoreDNSRecordWeights()
+	c.startMigrationSweeper()
+
 	if err := c.startExternalKeyListener(); err != nil {
 		return nil, err
 	}
 
+	if err := c.startKeyProvider(); err != nil {
+		return nil, err
+	}
+
+	if exp := c.cfg.Daemon.DNSExport; exp.Address != "" {
+		c.dnsExporter = newDNSExporter(c, exp.Address, exp.Zone)
+		if err := c.dnsExporter.start(); err != nil {
+			return nil, err
+		}
+	}
+
 	return c, nil
 }
 
@@ -283,6 +422,14 @@ func isValidClusteringIP(addr string) bool {
 // libnetwork side of agent depends on the keys. On the first receipt of
 // keys setup the agent. For subsequent key set handle the key change
 func (c *controller) SetKeys(keys []*types.EncryptionKey) error {
+	if c.cfg != nil && c.cfg.Daemon.KeyProvider != nil {
+		logrus.Debug("Ignoring the keys set by the cluster manager, the keys of the key provider are used")
+		return nil
+	}
+	return c.setKeys(keys)
+}
+
+func (c *controller) setKeys(keys []*types.EncryptionKey) error {
 	subsysKeys := make(map[string]int)
 	for _, key := range keys {
 		if key.Subsystem != subsysGossip &&
@@ -303,9 +450,14 @@ func (c *controller) SetKeys(keys []*types.EncryptionKey) error {
 		c.Lock()
 		c.keys = keys
 		c.Unlock()
+		c.keysRotated(keys)
 		return nil
 	}
-	return c.handleKeyChange(keys)
+	if err := c.handleKeyChange(keys); err != nil {
+		return err
+	}
+	c.keysRotated(keys)
+	return nil
 }
 
 func (c *controller) getAgent() *agent {
@@ -316,7 +468,8 @@ func (c *controller) getAgent() *agent {
 
 func (c *controller) clusterAgentInit() {
 	clusterProvider := c.cfg.Daemon.ClusterProvider
-	var keysAvailable bool
+	// The keys of the key provider are there without waiting for the manager
+	keysAvailable := c.loadProviderKeys() == nil
 	for {
 		eventType := <-clusterProvider.ListenClusterEvents()
 		// The events: EventSocketChange, EventNodeReady and EventNetworkKeysAvailable are not ordered
@@ -417,13 +570,15 @@ func (c *controller) agentStopComplete() {
 }
 
 func (c *controller) makeDriverConfig(ntype string) map[string]interface{} {
-	if c.cfg == nil {
-		return nil
-	}
+	return driverConfig(c.cfg, ntype)
+}
 
+// driverOptions returns the options of the driver set in the daemon labels
+// and driver configuration
+func driverOptions(cfg *config.Config, ntype string) map[string]interface{} {
 	config := make(map[string]interface{})
 
-	for _, label := range c.cfg.Daemon.Labels {
+	for _, label := range cfg.Daemon.Labels {
 		if !strings.HasPrefix(netlabel.Key(label), netlabel.DriverPrefix+"."+ntype) {
 			continue
 		}
@@ -431,25 +586,81 @@ func (c *controller) makeDriverConfig(ntype string) map[string]interface{} {
 		config[netlabel.Key(label)] = netlabel.Value(label)
 	}
 
-	drvCfg, ok := c.cfg.Daemon.DriverCfg[ntype]
+	drvCfg, ok := cfg.Daemon.DriverCfg[ntype]
 	if ok {
 		for k, v := range drvCfg.(map[string]interface{}) {
 			config[k] = v
 		}
 	}
 
-	for k, v := range c.cfg.Scopes {
+	return config
+}
+
+// iptablesEnabled returns whether the bridge driver programs the iptables
+// rules, as it does unless its configuration disables them
+func (c *controller) iptablesEnabled() bool {
+	return c.bridgeOption("EnableIPTables", true)
+}
+
+// ip6tablesEnabled returns whether the bridge driver programs the
+// ip6tables rules, which it does not by default
+func (c *controller) ip6tablesEnabled() bool {
+	return c.bridgeOption("EnableIP6Tables", false)
+}
+
+// bridgeOption returns the boolean option of the bridge driver, def when it
+// is not set
+func (c *controller) bridgeOption(name string, def bool) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.cfg == nil {
+		return false
+	}
+	cfgBridge, ok := c.cfg.Daemon.DriverCfg["bridge"].(map[string]interface{})
+	if !ok {
+		return def
+	}
+	var enabled interface{}
+	switch generic := cfgBridge[netlabel.GenericData].(type) {
+	case options.Generic:
+		enabled = generic[name]
+	case map[string]interface{}:
+		enabled = generic[name]
+	}
+	if e, ok := enabled.(bool); ok {
+		return e
+	}
+	return def
+}
+
+func driverConfig(cfg *config.Config, ntype string) map[string]interface{} {
+	if cfg == nil {
+		return nil
+	}
+
+	config := driverOptions(cfg, ntype)
+
+	for k, v := range cfg.Scopes {
 		if !v.IsValid() {
 			continue
 		}
 		config[netlabel.MakeKVClient(k)] = discoverapi.DatastoreConfigData{
-			Scope:    k,
-			Provider: v.Client.Provider,
-			Address:  v.Client.Address,
-			Config:   v.Client.Config,
+			Scope:         k,
+			Provider:      v.Client.Provider,
+			Address:       v.Client.Address,
+			Config:        v.Client.Config,
+			CacheTTL:      v.Client.CacheTTL,
+			SlowThreshold: v.Client.SlowThreshold,
+			KeyProvider:   v.Client.KeyProvider,
+			Namespace:     v.Client.Namespace,
 		}
 	}
 
+	if cfg.Daemon.Rootless {
+		config[netlabel.Rootless] = true
+	}
+
 	return config
 }
 
@@ -459,35 +670,57 @@ func (c *controller) ReloadConfiguration(cfgOptions ...config.Option) error {
 	procReloadConfig <- true
 	defer func() { <-procReloadConfig }()
 
-	// For now we accept the configuration reload only as a mean to provide a global store config after boot.
-	// Refuse the configuration if it alters an existing datastore client configuration.
-	update := false
 	cfg := config.ParseConfigOptions(cfgOptions...)
 
-	for s := range c.cfg.Scopes {
+	// The stores are reloaded only as a mean to provide a global store config after boot.
+	// Refuse the configuration if it alters an existing datastore client configuration,
+	// before changing any setting.
+	c.Lock()
+	scopes := c.cfg.Scopes
+	c.Unlock()
+	for s := range scopes {
 		if _, ok := cfg.Scopes[s]; !ok {
 			return types.ForbiddenErrorf("cannot accept new configuration because it removes an existing datastore client")
 		}
 	}
 	for s, nSCfg := range cfg.Scopes {
-		if eSCfg, ok := c.cfg.Scopes[s]; ok {
+		if eSCfg, ok := scopes[s]; ok {
 			if eSCfg.Client.Provider != nSCfg.Client.Provider ||
-				eSCfg.Client.Address != nSCfg.Client.Address {
+				eSCfg.Client.Address != nSCfg.Client.Address ||
+				eSCfg.Client.Namespace != nSCfg.Client.Namespace {
 				return types.ForbiddenErrorf("cannot accept new configuration because it modifies an existing datastore client")
 			}
-		} else {
-			if err := c.initScopedStore(s, nSCfg); err != nil {
-				return err
-			}
-			update = true
 		}
 	}
+
+	r, err := c.reloadDaemonConfig(cfg)
+	if err != nil {
+		return err
+	}
+	r.warn()
+
+	update := false
+	for s, nSCfg := range cfg.Scopes {
+		if _, ok := scopes[s]; ok {
+			continue
+		}
+		if err := c.initScopedStore(s, nSCfg); err != nil {
+			return err
+		}
+		update = true
+	}
 	if !update {
 		return nil
 	}
 
+	// The settings other than the stores and the discovery keep their value
 	c.Lock()
-	c.cfg = cfg
+	ncfg := *c.cfg
+	ncfg.Scopes = cfg.Scopes
+	if cfg.Cluster.Watcher != nil || cfg.Cluster.Discovery != "" || cfg.Cluster.Address != "" {
+		ncfg.Cluster = cfg.Cluster
+	}
+	c.cfg = &ncfg
 	c.Unlock()
 
 	var dsConfig *discoverapi.DatastoreConfigData
@@ -496,10 +729,13 @@ func (c *controller) ReloadConfiguration(cfgOptions ...config.Option) error {
 			continue
 		}
 		dsConfig = &discoverapi.DatastoreConfigData{
-			Scope:    scope,
-			Provider: sCfg.Client.Provider,
-			Address:  sCfg.Client.Address,
-			Config:   sCfg.Client.Config,
+			Scope:         scope,
+			Provider:      sCfg.Client.Provider,
+			Address:       sCfg.Client.Address,
+			Config:        sCfg.Client.Config,
+			CacheTTL:      sCfg.Client.CacheTTL,
+			SlowThreshold: sCfg.Client.SlowThreshold,
+			Namespace:     sCfg.Client.Namespace,
 		}
 		break
 	}
@@ -703,9 +939,44 @@ func (c *controller) RegisterDriver(networkType string, driver driverapi.Driver,
 // XXX  This should be made driver agnostic.  See comment below.
 const overlayDSROptionString = "dsr"
 
+// overlayDSRTunnel is the value of the DSR option selecting IPIP tunneling to
+// the backends instead of direct routing
+const overlayDSRTunnel = "tunnel"
+
+// overlayLoadBalancerMode returns the load balancing mode the DSR option of
+// the overlay driver options selects
+func overlayLoadBalancerMode(optMap map[string]string) string {
+	v, ok := optMap[overlayDSROptionString]
+	switch {
+	case !ok:
+		return loadBalancerModeDefault
+	case v == overlayDSRTunnel:
+		return loadBalancerModeTunnel
+	}
+	return loadBalancerModeDSR
+}
+
 // NewNetwork creates a new network of the specified network type. The options
 // are network specific and modeled in a generic way.
 func (c *controller) NewNetwork(networkType, name string, id string, options ...NetworkOption) (Network, error) {
+	return c.NewNetworkContext(context.Background(), networkType, name, id, options...)
+}
+
+// NewNetworkContext creates a new network like NewNetwork. The creation is
+// checked for the context between the driver, ipam and store operations, a
+// done context rolls back what was done and returns the context error.
+func (c *controller) NewNetworkContext(ctx context.Context, networkType, name string, id string, options ...NetworkOption) (Network, error) {
+	ctx = correlation.Ensure(ctx)
+	ctx, span := tracing.Start(ctx, "libnetwork.NewNetwork",
+		tracing.String("network.name", name), tracing.String("network.driver", networkType), correlationAttr(correlation.ID(ctx)))
+	n, err := c.newNetwork(ctx, networkType, name, id, options...)
+	tracing.End(span, err)
+	return n, err
+}
+
+func (c *controller) newNetwork(ctx context.Context, networkType, name string, id string, options ...NetworkOption) (Network, error) {
+	log := correlation.Logger(ctx)
+
 	if id != "" {
 		c.networkLocker.Lock(id)
 		defer c.networkLocker.Unlock(id)
@@ -758,6 +1029,10 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 		goto addToStore
 	}
 
+	if err = c.checkRootless(network); err != nil {
+		return nil, err
+	}
+
 	_, cap, err = network.resolveDriver(network.networkType, true)
 	if err != nil {
 		return nil, err
@@ -804,14 +1079,17 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 		defer func() {
 			if err == nil {
 				if err := t.getEpCnt().IncEndpointCnt(); err != nil {
-					logrus.Warnf("Failed to update reference count for configuration network %q on creation of network %q: %v",
+					log.Warnf("Failed to update reference count for configuration network %q on creation of network %q: %v",
 						t.Name(), network.Name(), err)
 				}
 			}
 		}()
 	}
 
-	err = network.ipamAllocate()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	err = tracing.Trace(ctx, "ipam.Allocate", network.ipamAllocate, network.spanAttrs()...)
 	if err != nil {
 		return nil, err
 	}
@@ -821,14 +1099,18 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 		}
 	}()
 
-	err = c.addNetwork(network)
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	log.Debugf("Creating %s network %s (%s)", network.networkType, network.name, network.id)
+	err = tracing.Trace(ctx, "driver.CreateNetwork", func() error { return c.addNetwork(network) }, network.driverSpanAttrs()...)
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
 		if err != nil {
 			if e := network.deleteNetwork(); e != nil {
-				logrus.Warnf("couldn't roll back driver network on network %s creation failure: %v", network.name, err)
+				log.Warnf("couldn't roll back driver network on network %s creation failure: %v", network.name, err)
 			}
 		}
 	}()
@@ -842,41 +1124,42 @@ func (c *controller) NewNetwork(networkType, name string, id string, options ...
 	// "libnetwork.network" data type.  Hence we need this hack code
 	// to implement in this manner.
 	if gval, ok := network.generic[netlabel.GenericData]; ok && network.networkType == "overlay" {
-		optMap := gval.(map[string]string)
-		if _, ok := optMap[overlayDSROptionString]; ok {
-			network.loadBalancerMode = loadBalancerModeDSR
-		}
+		network.loadBalancerMode = overlayLoadBalancerMode(gval.(map[string]string))
 	}
 
 addToStore:
 	// First store the endpoint count, then the network. To avoid to
 	// end up with a datastore containing a network and not an epCnt,
 	// in case of an ungraceful shutdown during this function call.
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
 	epCnt := &endpointCnt{n: network}
-	if err = c.updateToStore(epCnt); err != nil {
+	if err = c.updateToStoreContext(ctx, epCnt); err != nil {
 		return nil, err
 	}
 	defer func() {
 		if err != nil {
-			if e := c.deleteFromStore(epCnt); e != nil {
-				logrus.Warnf("could not rollback from store, epCnt %v on failure (%v): %v", epCnt, err, e)
+			if e := c.deleteFromStoreContext(ctx, epCnt); e != nil {
+				log.Warnf("could not rollback from store, epCnt %v on failure (%v): %v", epCnt, err, e)
 			}
 		}
 	}()
 
 	network.epCnt = epCnt
-	if err = c.updateToStore(network); err != nil {
+	if err = c.updateToStoreContext(ctx, network); err != nil {
 		return nil, err
 	}
 	defer func() {
 		if err != nil {
-			if e := c.deleteFromStore(network); e != nil {
-				logrus.Warnf("could not rollback from store, network %v on failure (%v): %v", network, err, e)
+			if e := c.deleteFromStoreContext(ctx, network); e != nil {
+				log.Warnf("could not rollback from store, network %v on failure (%v): %v", network, err, e)
 			}
 		}
 	}()
 
 	if network.configOnly {
+		c.publishNetwork(EventNetworkCreate, network)
 		return network, nil
 	}
 
@@ -885,13 +1168,13 @@ addToStore:
 		if err != nil {
 			network.cancelDriverWatches()
 			if e := network.leaveCluster(); e != nil {
-				logrus.Warnf("Failed to leave agent cluster on network %s on failure (%v): %v", network.name, err, e)
+				log.Warnf("Failed to leave agent cluster on network %s on failure (%v): %v", network.name, err, e)
 			}
 		}
 	}()
 
 	if network.hasLoadBalancerEndpoint() {
-		if err = network.createLoadBalancerSandbox(); err != nil {
+		if err = network.createLoadBalancerSandbox(ctx); err != nil {
 			return nil, err
 		}
 	}
@@ -902,8 +1185,13 @@ addToStore:
 		c.Unlock()
 	}
 
-	c.arrangeUserFilterRule()
+	tracing.Trace(ctx, "firewall.ArrangeUserFilterRule", func() error {
+		c.arrangeUserFilterRule()
+		return nil
+	})
 
+	log.Debugf("Created %s network %s (%s)", network.networkType, network.name, network.id)
+	c.publishNetwork(EventNetworkCreate, network)
 	return network, nil
 }
 
@@ -996,10 +1284,12 @@ func (c *controller) addNetwork(n *network) error {
 
 	// Create the network
 	if err := d.CreateNetwork(n.id, n.generic, n, n.getIPData(4), n.getIPData(6)); err != nil {
+		c.publishDriverError("CreateNetwork", n, nil, err)
 		return err
 	}
 
 	n.startResolver()
+	c.startMDNS(n)
 
 	return nil
 }
@@ -1068,6 +1358,21 @@ func (c *controller) NetworkByID(id string) (Network, error) {
 
 // NewSandbox creates a new sandbox for the passed container id
 func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (Sandbox, error) {
+	return c.NewSandboxContext(context.Background(), containerID, options...)
+}
+
+// NewSandboxContext creates a new sandbox like NewSandbox, the creation is
+// given up when the context is done before the osl sandbox is created
+func (c *controller) NewSandboxContext(ctx context.Context, containerID string, options ...SandboxOption) (Sandbox, error) {
+	ctx = correlation.Ensure(ctx)
+	ctx, span := tracing.Start(ctx, "libnetwork.NewSandbox",
+		tracing.String("container.id", containerID), correlationAttr(correlation.ID(ctx)))
+	sb, err := c.newSandbox(ctx, containerID, options...)
+	tracing.End(span, err)
+	return sb, err
+}
+
+func (c *controller) newSandbox(ctx context.Context, containerID string, options ...SandboxOption) (Sandbox, error) {
 	if containerID == "" {
 		return nil, types.BadRequestErrorf("invalid container ID")
 	}
@@ -1115,6 +1420,10 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (S
 
 	sb.processOptions(options...)
 
+	if err := sb.validateSysctls(); err != nil {
+		return nil, err
+	}
+
 	c.Lock()
 	if sb.ingress && c.ingressSandbox != nil {
 		c.Unlock()
@@ -1146,6 +1455,10 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (S
 		return nil, err
 	}
 
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	if sb.config.useDefaultSandBox {
 		c.sboxOnce.Do(func() {
 			c.defOsSbox, err = osl.NewSandbox(sb.Key(), false, false)
@@ -1170,6 +1483,10 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (S
 		sb.osSbox.ApplyOSTweaks(sb.oslTypes)
 	}
 
+	if err = sb.setSysctls(); err != nil {
+		return nil, err
+	}
+
 	c.Lock()
 	c.sandboxes[sb.id] = sb
 	c.Unlock()
@@ -1186,6 +1503,8 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (S
 		return nil, fmt.Errorf("failed to update the store state of sandbox: %v", err)
 	}
 
+	correlation.Logger(ctx).Debugf("Created sandbox %s for container %s", sb.id, containerID)
+	c.publishSandbox(EventSandboxCreate, sb)
 	return sb, nil
 }
 
@@ -1326,8 +1645,16 @@ func (c *controller) getIPAMDriver(name string) (ipamapi.Ipam, *ipamapi.Capabili
 }
 
 func (c *controller) Stop() {
+	if c.dnsExporter != nil {
+		c.dnsExporter.stop()
+	}
+	c.stopAllMDNS()
+	c.stopPolicyReconciler()
+	c.stopFirewallReconciler()
+	c.stopMigrationSweeper()
 	c.closeStores()
 	c.stopExternalKeyListener()
+	c.stopKeyProvider()
 	osl.GC()
 }
 
@@ -0,0 +1,72 @@
+// Package correlation carries the correlation ID of an operation, such as
+// the network work of a container start, from the context passed to the
+// controller down to the drivers, and tags the log lines of the operation
+// with it.
+package correlation
+
+import (
+	"context"
+
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/sirupsen/logrus"
+)
+
+// Field is the name of the log field holding the correlation ID
+const Field = "correlation_id"
+
+type idKey struct{}
+
+// WithID returns a copy of ctx carrying the correlation ID id
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, idKey{}, id)
+}
+
+// ID returns the correlation ID of ctx, empty if it carries none
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// Ensure returns ctx if it carries a correlation ID, else a copy of ctx
+// carrying a new one
+func Ensure(ctx context.Context) context.Context {
+	if ID(ctx) != "" {
+		return ctx
+	}
+	return WithID(ctx, stringid.TruncateID(stringid.GenerateRandomID()))
+}
+
+// Logger returns a log entry tagged with the correlation ID of ctx
+func Logger(ctx context.Context) *logrus.Entry {
+	return entry(ID(ctx))
+}
+
+// Options returns a copy of the driver options carrying the correlation ID
+// of ctx, for the drivers to tag their log lines with
+func Options(ctx context.Context, options map[string]interface{}) map[string]interface{} {
+	id := ID(ctx)
+	if id == "" {
+		return options
+	}
+	opts := make(map[string]interface{}, len(options)+1)
+	for k, v := range options {
+		opts[k] = v
+	}
+	opts[netlabel.CorrelationID] = id
+	return opts
+}
+
+// OptionsLogger returns a log entry tagged with the correlation ID of the
+// driver options
+func OptionsLogger(options map[string]interface{}) *logrus.Entry {
+	id, _ := options[netlabel.CorrelationID].(string)
+	return entry(id)
+}
+
+func entry(id string) *logrus.Entry {
+	if id == "" {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+	return logrus.WithField(Field, id)
+}
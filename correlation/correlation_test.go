@@ -0,0 +1,43 @@
+package correlation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+)
+
+func TestEnsure(t *testing.T) {
+	ctx := Ensure(context.Background())
+	id := ID(ctx)
+	if id == "" {
+		t.Fatal("expected a correlation ID")
+	}
+	if ID(Ensure(ctx)) != id {
+		t.Fatal("expected the correlation ID to be kept")
+	}
+	if got := Logger(ctx).Data[Field]; got != id {
+		t.Fatalf("expected log field %q, got %v", id, got)
+	}
+	if _, ok := Logger(context.Background()).Data[Field]; ok {
+		t.Fatal("unexpected log field without a correlation ID")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	ctx := WithID(context.Background(), "abc")
+	labels := map[string]interface{}{"foo": "bar"}
+	opts := Options(ctx, labels)
+	if opts[netlabel.CorrelationID] != "abc" || opts["foo"] != "bar" {
+		t.Fatalf("unexpected options %v", opts)
+	}
+	if _, ok := labels[netlabel.CorrelationID]; ok {
+		t.Fatal("the passed options were modified")
+	}
+	if got := OptionsLogger(opts).Data[Field]; got != "abc" {
+		t.Fatalf("expected log field abc, got %v", got)
+	}
+	if opts := Options(context.Background(), labels); len(opts) != 1 {
+		t.Fatalf("unexpected options %v", opts)
+	}
+}
@@ -0,0 +1,101 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+)
+
+// NetworkDatapath is the datapath state of a network, serializable to JSON
+// for support bundles. The parts which could not be collected are reported
+// in Errors and left empty.
+type NetworkDatapath struct {
+	ID     string
+	Name   string
+	Type   string
+	Scope  string
+	Driver map[string]interface{} `json:",omitempty"`
+	// Endpoints are the endpoints of the network known to this node
+	Endpoints []*EndpointDatapath
+	Errors    []string `json:",omitempty"`
+}
+
+// EndpointDatapath is the datapath state of an endpoint. Sandbox is the
+// network state of the sandbox the endpoint is joined to: the interfaces,
+// routes, neighbors and iptables rules of the namespace.
+type EndpointDatapath struct {
+	ID          string
+	Name        string
+	NetworkID   string
+	SandboxID   string                     `json:",omitempty"`
+	ContainerID string                     `json:",omitempty"`
+	Driver      map[string]interface{}     `json:",omitempty"`
+	Statistics  *types.InterfaceStatistics `json:",omitempty"`
+	Sandbox     *osl.Checkpoint            `json:",omitempty"`
+	Errors      []string                   `json:",omitempty"`
+}
+
+func (n *network) DatapathState() (*NetworkDatapath, error) {
+	d, err := n.driver(true)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &NetworkDatapath{
+		ID:    n.ID(),
+		Name:  n.Name(),
+		Type:  n.Type(),
+		Scope: n.Scope(),
+	}
+	if di, ok := d.(driverapi.DatapathInspector); ok {
+		if st.Driver, err = di.NetworkDatapath(n.ID()); err != nil {
+			st.Errors = append(st.Errors, err.Error())
+		}
+	}
+
+	for _, e := range n.Endpoints() {
+		est, err := e.DatapathState()
+		if err != nil {
+			st.Errors = append(st.Errors, err.Error())
+			continue
+		}
+		st.Endpoints = append(st.Endpoints, est)
+	}
+	return st, nil
+}
+
+func (ep *endpoint) DatapathState() (*EndpointDatapath, error) {
+	n, err := ep.getNetworkFromStore()
+	if err != nil {
+		return nil, err
+	}
+	d, err := n.driver(true)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &EndpointDatapath{ID: ep.ID(), Name: ep.Name(), NetworkID: n.ID()}
+	if st.Driver, err = d.EndpointOperInfo(n.ID(), ep.ID()); err != nil {
+		st.Errors = append(st.Errors, err.Error())
+	}
+
+	sb, ok := ep.getSandbox()
+	if !ok {
+		return st, nil
+	}
+	st.SandboxID = sb.ID()
+	st.ContainerID = sb.ContainerID()
+	if st.Statistics, err = ep.Statistics(); err != nil {
+		st.Errors = append(st.Errors, err.Error())
+	}
+
+	sb.Lock()
+	osSbox := sb.osSbox
+	sb.Unlock()
+	if osSbox != nil {
+		if st.Sandbox, err = osSbox.Checkpoint(); err != nil {
+			st.Errors = append(st.Errors, err.Error())
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,184 @@
+package libnetwork
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/docker/go-connections/tlsconfig"
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// datapathPaths2Func are the diagnostic handlers exposing the port mappings
+// and the firewall rules of the networks
+var datapathPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/portmappings": portMappings2Diag,
+	"/firewalldiff": firewallDiff2Diag,
+}
+
+// secureDiagnostic sets up the authentication of the diagnostic server
+// clients from the daemon configuration
+func (c *controller) secureDiagnostic() error {
+	dc := c.cfg.Daemon.Diagnostic
+	if dc.CertFile == "" && dc.KeyFile == "" {
+		if dc.CAFile != "" {
+			return types.BadRequestErrorf("the diagnostic server needs a certificate to verify the clients")
+		}
+		c.DiagnosticServer.SetAuth(dc.Token, nil)
+		return nil
+	}
+
+	opts := tlsconfig.Options{CertFile: dc.CertFile, KeyFile: dc.KeyFile, CAFile: dc.CAFile}
+	if dc.CAFile != "" {
+		opts.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	tlsConfig, err := tlsconfig.Server(opts)
+	if err != nil {
+		return fmt.Errorf("invalid TLS configuration of the diagnostic server: %v", err)
+	}
+	c.DiagnosticServer.SetAuth(dc.Token, tlsConfig)
+	return nil
+}
+
+// PortMappingsResult carries the port mappings of the endpoints, keyed by
+// network then endpoint name
+type PortMappingsResult struct {
+	Networks map[string]map[string][]types.PortBinding `json:"networks"`
+}
+
+func (r *PortMappingsResult) String() string {
+	var lines []string
+	for nw, eps := range r.Networks {
+		for ep, pbs := range eps {
+			for _, pb := range pbs {
+				lines = append(lines, fmt.Sprintf("network %s endpoint %s %s", nw, ep, pb.String()))
+			}
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// portMappings collects the port mappings the drivers report for the
+// endpoints of the networks
+func (c *controller) portMappings() *PortMappingsResult {
+	res := &PortMappingsResult{Networks: make(map[string]map[string][]types.PortBinding)}
+	for _, nw := range c.Networks() {
+		n := nw.(*network)
+		d, err := n.driver(false)
+		if err != nil || d == nil {
+			continue
+		}
+		for _, ep := range n.Endpoints() {
+			info, err := d.EndpointOperInfo(n.ID(), ep.ID())
+			if err != nil {
+				continue
+			}
+			pbs, ok := info[netlabel.PortMap].([]types.PortBinding)
+			if !ok || len(pbs) == 0 {
+				continue
+			}
+			if res.Networks[n.Name()] == nil {
+				res.Networks[n.Name()] = make(map[string][]types.PortBinding)
+			}
+			res.Networks[n.Name()][ep.Name()] = pbs
+		}
+	}
+	return res
+}
+
+// FirewallDiffResult carries the firewall rules missing for the networks
+// whose driver can verify them, keyed by network name. Errors are the
+// networks which could not be verified.
+type FirewallDiffResult struct {
+	Missing map[string][]string `json:"missing"`
+	Errors  map[string]string   `json:"errors,omitempty"`
+}
+
+func (r *FirewallDiffResult) String() string {
+	names := make([]string, 0, len(r.Missing))
+	for name := range r.Missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "network %s: %d missing rules\n", name, len(r.Missing[name]))
+		for _, rule := range r.Missing[name] {
+			fmt.Fprintf(&b, "  %s\n", rule)
+		}
+	}
+	for name, err := range r.Errors {
+		fmt.Fprintf(&b, "network %s: %s\n", name, err)
+	}
+	return b.String()
+}
+
+// firewallDiff checks the firewall rules of the networks
+func (c *controller) firewallDiff() *FirewallDiffResult {
+	res := &FirewallDiffResult{Missing: make(map[string][]string), Errors: make(map[string]string)}
+	for _, nw := range c.Networks() {
+		n := nw.(*network)
+		d, err := n.driver(false)
+		if err != nil || d == nil {
+			continue
+		}
+		fv, ok := d.(driverapi.FirewallVerifier)
+		if !ok {
+			continue
+		}
+		missing, err := fv.MissingFirewallRules(n.ID())
+		if err != nil {
+			res.Errors[n.Name()] = err.Error()
+			continue
+		}
+		res.Missing[n.Name()] = missing
+	}
+	return res
+}
+
+func portMappings2Diag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("port mappings")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+	rsp := c.portMappings()
+	log.Info("port mappings done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(rsp), json)
+}
+
+func firewallDiff2Diag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("firewall diff")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+	rsp := c.firewallDiff()
+	log.Info("firewall diff done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(rsp), json)
+}
@@ -0,0 +1,52 @@
+// +build !windows
+
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/docker/libnetwork/portallocator"
+	"github.com/sirupsen/logrus"
+)
+
+// allocatorPaths2Func are the diagnostic handlers exposing the utilization
+// of the port allocator
+var allocatorPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/portallocator": portAllocator2Diag,
+}
+
+// PortAllocatorResult carries the utilization of the host ports
+type PortAllocatorResult struct {
+	Ports []portallocator.Utilization `json:"ports"`
+}
+
+func (r *PortAllocatorResult) String() string {
+	lines := make([]string, 0, len(r.Ports))
+	for _, u := range r.Ports {
+		ranges := make([]string, 0, len(u.Ranges))
+		for _, r := range u.Ranges {
+			ranges = append(ranges, r.String())
+		}
+		lines = append(lines, fmt.Sprintf("%s/%s allocated: %d, dynamic: %d/%d, remaining: %d, conflicts: %d, exhausted: %d, ports: %s",
+			u.IP, u.Proto, u.Allocated, u.Dynamic, u.DynamicSize, u.Remaining, u.Conflicts, u.Exhausted, strings.Join(ranges, ",")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func portAllocator2Diag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("port allocator")
+
+	rsp := &PortAllocatorResult{Ports: portallocator.Get().Utilization()}
+	log.Info("port allocator done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(rsp), json)
+}
@@ -0,0 +1,6 @@
+package libnetwork
+
+import "github.com/docker/libnetwork/diagnostic"
+
+// allocatorPaths2Func is empty, there is no port allocator on windows
+var allocatorPaths2Func = map[string]diagnostic.HTTPHandlerFunc{}
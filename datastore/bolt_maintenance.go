@@ -0,0 +1,209 @@
+package datastore
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store/boltdb"
+	"github.com/docker/libnetwork/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltMetadataLen is the size of the index the libkv boltdb store puts
+// before the values
+const boltMetadataLen = 8
+
+// BoltMaintainer is implemented by the data stores backed by a boltdb file
+type BoltMaintainer interface {
+	// Compact rewrites the boltdb file without its free pages
+	Compact() (*BoltCompactResult, error)
+	// Verify checks the consistency of the boltdb file
+	Verify() (*BoltVerifyResult, error)
+}
+
+// BoltStats describes a boltdb file
+type BoltStats struct {
+	Path string `json:"path"`
+	// Size is the size of the file in bytes
+	Size     int64 `json:"size"`
+	PageSize int   `json:"page_size"`
+	// FreePages is the number of pages of the file not in use
+	FreePages int `json:"free_pages"`
+	// Keys is the number of keys of the top level buckets
+	Keys int `json:"keys"`
+}
+
+func (s *BoltStats) String() string {
+	return fmt.Sprintf("%s: size: %d, page size: %d, free pages: %d, keys: %d", s.Path, s.Size, s.PageSize, s.FreePages, s.Keys)
+}
+
+// BoltCompactResult is the state of a boltdb file before and after its
+// compaction
+type BoltCompactResult struct {
+	Before BoltStats `json:"before"`
+	After  BoltStats `json:"after"`
+}
+
+func (r *BoltCompactResult) String() string {
+	return fmt.Sprintf("before %s\nafter %s\n", r.Before.String(), r.After.String())
+}
+
+// BoltVerifyResult is the outcome of the consistency check of a boltdb
+// file, the file is sound when there are no errors
+type BoltVerifyResult struct {
+	Stats  BoltStats `json:"stats"`
+	Errors []string  `json:"errors"`
+}
+
+func (r *BoltVerifyResult) String() string {
+	if len(r.Errors) == 0 {
+		return fmt.Sprintf("%s\nno errors\n", r.Stats.String())
+	}
+	return fmt.Sprintf("%s\n%d errors:\n%s\n", r.Stats.String(), len(r.Errors), strings.Join(r.Errors, "\n"))
+}
+
+// boltStore returns the libkv boltdb store of the data store, locked: the
+// store doesn't touch the file while the maintenance runs
+func (ds *datastore) boltStore() (*boltdb.BoltDB, error) {
+	b, ok := ds.backend().(*boltdb.BoltDB)
+	if !ok || ds.boltPath == "" {
+		return nil, types.NotImplementedErrorf("the %s data store is not a boltdb store", ds.scope)
+	}
+	if b.PersistConnection {
+		return nil, types.ForbiddenErrorf("the %s boltdb store holds its connection", ds.scope)
+	}
+	b.Lock()
+	return b, nil
+}
+
+func (ds *datastore) openBolt(path string) (*bolt.DB, error) {
+	timeout := ds.boltTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	return bolt.Open(path, 0644, &bolt.Options{Timeout: timeout})
+}
+
+func boltStats(db *bolt.DB) (BoltStats, error) {
+	st := BoltStats{
+		Path:      db.Path(),
+		PageSize:  db.Info().PageSize,
+		FreePages: db.Stats().FreePageN,
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		st.Size = tx.Size()
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			st.Keys += b.Stats().KeyN
+			return nil
+		})
+	})
+	return st, err
+}
+
+// Verify checks the consistency of the boltdb file of the data store
+func (ds *datastore) Verify() (*BoltVerifyResult, error) {
+	b, err := ds.boltStore()
+	if err != nil {
+		return nil, err
+	}
+	defer b.Unlock()
+
+	db, err := ds.openBolt(ds.boltPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	res := &BoltVerifyResult{Errors: []string{}}
+	err = db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			res.Errors = append(res.Errors, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Stats, err = boltStats(db); err != nil {
+		res.Errors = append(res.Errors, err.Error())
+	}
+	return res, nil
+}
+
+// Compact rewrites the boltdb file of the data store to a new file without
+// the free pages, and replaces the file with it
+func (ds *datastore) Compact() (*BoltCompactResult, error) {
+	b, err := ds.boltStore()
+	if err != nil {
+		return nil, err
+	}
+	defer b.Unlock()
+
+	src, err := ds.openBolt(ds.boltPath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	res := &BoltCompactResult{}
+	if res.Before, err = boltStats(src); err != nil {
+		return nil, err
+	}
+
+	tmpPath := ds.boltPath + ".compact"
+	os.Remove(tmpPath)
+	dst, err := ds.openBolt(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	err = src.View(func(stx *bolt.Tx) error {
+		return dst.Update(func(dtx *bolt.Tx) error {
+			return stx.ForEach(func(name []byte, sb *bolt.Bucket) error {
+				db, err := dtx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(db, sb)
+			})
+		})
+	})
+	if err == nil {
+		res.After, err = boltStats(dst)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to compact %s: %v", ds.boltPath, err)
+	}
+
+	src.Close()
+	if err := os.Rename(tmpPath, ds.boltPath); err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to replace %s with its compacted copy: %v", ds.boltPath, err)
+	}
+	res.After.Path = ds.boltPath
+	return res, nil
+}
+
+// copyBucket copies the keys, the nested buckets and the sequence of src to
+// dst, the pages of dst are filled up
+func copyBucket(dst, src *bolt.Bucket) error {
+	dst.FillPercent = 1.0
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		nested, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(nested, src.Bucket(k))
+	})
+}
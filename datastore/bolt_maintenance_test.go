@@ -0,0 +1,53 @@
+package datastore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestBoltMaintenance(t *testing.T) {
+	boltdb.Register()
+	dir, err := ioutil.TempDir("", "bolt-maintenance")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	ds, err := newClient(LocalScope, ScopeClientCfg{Provider: string(store.BOLTDB), Address: filepath.Join(dir, "local-kv.db"), Config: &store.Config{Bucket: "libnetwork"}}, false)
+	assert.NilError(t, err)
+	defer ds.Close()
+	kv := ds.KVStore()
+
+	value := make([]byte, 4096)
+	for i := 0; i < 200; i++ {
+		assert.NilError(t, kv.Put(Key("test", fmt.Sprint(i)), value, nil))
+	}
+	for i := 1; i < 200; i++ {
+		assert.NilError(t, kv.Delete(Key("test", fmt.Sprint(i))))
+	}
+
+	m, ok := ds.(BoltMaintainer)
+	assert.Assert(t, ok)
+
+	vres, err := m.Verify()
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(vres.Errors, 0))
+	assert.Check(t, is.Equal(vres.Stats.Keys, 1))
+
+	cres, err := m.Compact()
+	assert.NilError(t, err)
+	assert.Check(t, cres.After.Size < cres.Before.Size, "compaction from %d to %d bytes", cres.Before.Size, cres.After.Size)
+	assert.Check(t, is.Equal(cres.After.Keys, 1))
+
+	// the store uses the compacted file
+	pair, err := kv.Get(Key("test", "0"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(pair.Value, len(value)))
+	assert.NilError(t, kv.Put(Key("test", "1"), value, nil))
+}
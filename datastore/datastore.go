@@ -28,6 +28,8 @@ type DataStore interface {
 	DeleteObjectAtomic(kvObject KVObject) error
 	// DeleteTree deletes a record
 	DeleteTree(kvObject KVObject) error
+	// AtomicTxn puts and deletes the KV objects in one atomic operation
+	AtomicTxn(ops ...TxOp) error
 	// Watchable returns whether the store is watchable or not
 	Watchable() bool
 	// Watch for changes on a KVObject
@@ -57,12 +59,23 @@ var (
 )
 
 type datastore struct {
+	// txnIndex is the counter of the indexes given by the transactions,
+	// first for its 64-bit alignment
+	txnIndex   uint64
 	scope      string
 	store      store.Store
 	cache      *cache
 	watchCh    chan struct{}
 	active     bool
 	sequential bool
+	// boltPath, boltBucket and boltTimeout are the file, bucket and lock
+	// timeout of a boltdb store, for its maintenance and transactions
+	boltPath    string
+	boltBucket  []byte
+	boltTimeout time.Duration
+	metrics     *storeMetrics
+	// cipher encrypts the values, nil when the store is not encrypted
+	cipher *valueCipher
 	sync.Mutex
 }
 
@@ -109,6 +122,20 @@ type ScopeClientCfg struct {
 	Provider string
 	Address  string
 	Config   *store.Config
+	// CacheTTL, when not zero, caches the reads of the store for this
+	// long, see ReadCache. Only the stores out of the local scope are
+	// cached.
+	CacheTTL time.Duration
+	// SlowThreshold, when not zero, logs the calls to the store taking
+	// longer than this
+	SlowThreshold time.Duration
+	// KeyProvider, when set, encrypts the values of the store with its
+	// key. Only the stores of the local scope are encrypted.
+	KeyProvider KeyProvider `toml:"-"`
+	// Namespace, when set, puts the keys of the store under this path, so
+	// that independent daemons can share a store. The stores of the local
+	// scope have no namespace.
+	Namespace string
 }
 
 const (
@@ -193,11 +220,22 @@ func ParseKey(key string) ([]string, error) {
 }
 
 // newClient used to connect to KV Store
-func newClient(scope string, kv string, addr string, config *store.Config, cached bool) (DataStore, error) {
+func newClient(scope string, cfg ScopeClientCfg, cached bool) (DataStore, error) {
+	kv, addr, config := cfg.Provider, cfg.Address, cfg.Config
 
 	if cached && scope != LocalScope {
 		return nil, fmt.Errorf("caching supported only for scope %s", LocalScope)
 	}
+	if cfg.KeyProvider != nil && scope != LocalScope {
+		return nil, fmt.Errorf("encryption supported only for scope %s", LocalScope)
+	}
+	namespace, err := parseNamespace(cfg.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if namespace != "" && scope == LocalScope {
+		return nil, fmt.Errorf("key namespaces not supported for scope %s", LocalScope)
+	}
 	sequential := false
 	if scope == LocalScope {
 		sequential = true
@@ -209,7 +247,8 @@ func newClient(scope string, kv string, addr string, config *store.Config, cache
 
 	var addrs []string
 
-	if kv == string(store.BOLTDB) {
+	isBolt := kv == string(store.BOLTDB)
+	if isBolt {
 		// Parse file path
 		addrs = strings.Split(addr, ",")
 	} else {
@@ -227,11 +266,39 @@ func newClient(scope string, kv string, addr string, config *store.Config, cache
 	if err != nil {
 		return nil, err
 	}
+	if namespace != "" {
+		store = &namespacedStore{Store: store, ns: namespace}
+	}
+
+	var vc *valueCipher
+	if cfg.KeyProvider != nil {
+		if vc, err = newValueCipher(cfg.KeyProvider); err != nil {
+			store.Close()
+			return nil, err
+		}
+		if err := encryptPlaintext(store, vc, Key()); err != nil {
+			store.Close()
+			return nil, err
+		}
+		store = &encryptedStore{Store: store, c: vc}
+	}
+
+	metrics := newStoreMetrics(scope, kv, cfg.SlowThreshold)
+	store = &instrumentedStore{Store: &faultyStore{Store: store}, m: metrics}
+	if cfg.CacheTTL > 0 && scope != LocalScope {
+		store = NewReadCache(store, cfg.CacheTTL, Key())
+	}
 
-	ds := &datastore{scope: scope, store: store, active: true, watchCh: make(chan struct{}), sequential: sequential}
+	ds := &datastore{scope: scope, store: store, active: true, watchCh: make(chan struct{}), sequential: sequential, metrics: metrics, cipher: vc}
 	if cached {
 		ds.cache = newCache(ds)
 	}
+	if isBolt && len(addrs) == 1 {
+		ds.boltPath = addrs[0]
+		ds.boltBucket = []byte(config.Bucket)
+		ds.boltTimeout = config.ConnectionTimeout
+		ds.txnIndex = uint64(time.Now().UnixNano()) &^ boltTxnIndexBit
+	}
 
 	return ds, nil
 }
@@ -244,6 +311,13 @@ func NewDataStore(scope string, cfg *ScopeCfg) (DataStore, error) {
 			return nil, fmt.Errorf("unexpected scope %s without configuration passed", scope)
 		}
 
+		if cfg != nil && (cfg.Client.SlowThreshold != 0 || cfg.Client.KeyProvider != nil || cfg.Client.Namespace != "") {
+			dc := *c
+			dc.Client.SlowThreshold = cfg.Client.SlowThreshold
+			dc.Client.KeyProvider = cfg.Client.KeyProvider
+			dc.Client.Namespace = cfg.Client.Namespace
+			c = &dc
+		}
 		cfg = c
 	}
 
@@ -252,7 +326,7 @@ func NewDataStore(scope string, cfg *ScopeCfg) (DataStore, error) {
 		cached = true
 	}
 
-	return newClient(scope, cfg.Client.Provider, cfg.Client.Address, cfg.Client.Config, cached)
+	return newClient(scope, cfg.Client, cached)
 }
 
 // NewDataStoreFromConfig creates a new instance of LibKV data store starting from the datastore config data
@@ -267,11 +341,20 @@ func NewDataStoreFromConfig(dsc discoverapi.DatastoreConfigData) (DataStore, err
 		return nil, fmt.Errorf("cannot parse store configuration: %v", dsc.Config)
 	}
 
+	keys, ok := dsc.KeyProvider.(KeyProvider)
+	if !ok && dsc.KeyProvider != nil {
+		return nil, fmt.Errorf("cannot parse store key provider: %T", dsc.KeyProvider)
+	}
+
 	scopeCfg := &ScopeCfg{
 		Client: ScopeClientCfg{
-			Address:  dsc.Address,
-			Provider: dsc.Provider,
-			Config:   sCfgP,
+			Address:       dsc.Address,
+			Provider:      dsc.Provider,
+			Config:        sCfgP,
+			CacheTTL:      dsc.CacheTTL,
+			SlowThreshold: dsc.SlowThreshold,
+			KeyProvider:   keys,
+			Namespace:     dsc.Namespace,
 		},
 	}
 
@@ -300,67 +383,54 @@ func (ds *datastore) Watchable() bool {
 }
 
 func (ds *datastore) Watch(kvObject KVObject, stopCh <-chan struct{}) (<-chan KVObject, error) {
-	sCh := make(chan struct{})
-
 	ctor, ok := kvObject.(KVConstructor)
 	if !ok {
 		return nil, fmt.Errorf("error watching object type %T, object does not implement KVConstructor interface", kvObject)
 	}
 
-	kvpCh, err := ds.store.Watch(Key(kvObject.Key()...), sCh)
-	if err != nil {
-		return nil, err
+	opts := WatchOptions{
+		// If the backend KV store gets reset the datastore is inactive
+		// until RestartWatch, which retries the watch at once
+		Disconnected: func(err error) {
+			ds.Lock()
+			ds.active = false
+			ds.Unlock()
+		},
+		Wake: func() <-chan struct{} {
+			ds.Lock()
+			defer ds.Unlock()
+			return ds.watchCh
+		},
 	}
+	evCh := WatchKV(ds.store, Key(kvObject.Key()...), opts, stopCh)
 
 	kvoCh := make(chan KVObject)
 
 	go func() {
-	retry_watch:
-		var err error
+		for ev := range evCh {
+			if ev.Err != nil {
+				log.Printf("Could not watch the key %s in store: %v", Key(kvObject.Key()...), ev.Err)
+				return
+			}
+			if len(ev.Pairs) == 0 {
+				continue
+			}
+			kvPair := ev.Pairs[0]
 
-		// Make sure to get a new instance of watch channel
-		ds.Lock()
-		watchCh := ds.watchCh
-		ds.Unlock()
+			dstO := ctor.New()
+
+			if err := dstO.SetValue(kvPair.Value); err != nil {
+				log.Printf("Could not unmarshal kvpair value = %s", string(kvPair.Value))
+				continue
+			}
 
-	loop:
-		for {
+			dstO.SetIndex(kvPair.LastIndex)
 			select {
+			case kvoCh <- dstO:
 			case <-stopCh:
-				close(sCh)
 				return
-			case kvPair := <-kvpCh:
-				// If the backend KV store gets reset libkv's go routine
-				// for the watch can exit resulting in a nil value in
-				// channel.
-				if kvPair == nil {
-					ds.Lock()
-					ds.active = false
-					ds.Unlock()
-					break loop
-				}
-
-				dstO := ctor.New()
-
-				if err = dstO.SetValue(kvPair.Value); err != nil {
-					log.Printf("Could not unmarshal kvpair value = %s", string(kvPair.Value))
-					break
-				}
-
-				dstO.SetIndex(kvPair.LastIndex)
-				kvoCh <- dstO
 			}
 		}
-
-		// Wait on watch channel for a re-trigger when datastore becomes active
-		<-watchCh
-
-		kvpCh, err = ds.store.Watch(Key(kvObject.Key()...), sCh)
-		if err != nil {
-			log.Printf("Could not watch the key %s in store: %v", Key(kvObject.Key()...), err)
-		}
-
-		goto retry_watch
 	}()
 
 	return kvoCh, nil
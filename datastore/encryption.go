@@ -0,0 +1,280 @@
+package datastore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// sealedMagic starts the encrypted values, followed by the nonce and the
+// AES-GCM sealed value
+var sealedMagic = []byte("lnenc1:")
+
+// KeyProvider supplies the AES key encrypting the values of a data store,
+// of 16, 24 or 32 bytes. An external key management service plugs in by
+// implementing it.
+type KeyProvider interface {
+	Key() ([]byte, error)
+}
+
+// StaticKey is a key passed in the configuration
+type StaticKey []byte
+
+// Key returns the key
+func (k StaticKey) Key() ([]byte, error) {
+	return []byte(k), nil
+}
+
+// FileKey is the path of a file holding the base64 encoded key, such as
+// the files the key management agents write on a tmpfs
+type FileKey string
+
+// Key reads and decodes the key file
+func (f FileKey) Key() ([]byte, error) {
+	b, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the key file %s: %v", f, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the key file %s: %v", f, err)
+	}
+	return key, nil
+}
+
+// valueCipher encrypts the values of the keys, the key is authenticated
+// along with its value: a value moved under another key fails to open
+type valueCipher struct {
+	aead cipher.AEAD
+}
+
+func newValueCipher(p KeyProvider) (*valueCipher, error) {
+	key, err := p.Key()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, types.BadRequestErrorf("invalid data store encryption key: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &valueCipher{aead: aead}, nil
+}
+
+func sealed(value []byte) bool {
+	return bytes.HasPrefix(value, sealedMagic)
+}
+
+func (c *valueCipher) seal(key string, value []byte) ([]byte, error) {
+	size := len(sealedMagic) + c.aead.NonceSize()
+	out := make([]byte, size, size+len(value)+c.aead.Overhead())
+	copy(out, sealedMagic)
+	nonce := out[len(sealedMagic):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(out, nonce, value, []byte(strings.Trim(key, "/"))), nil
+}
+
+func (c *valueCipher) open(key string, value []byte) ([]byte, error) {
+	if !sealed(value) || len(value) < len(sealedMagic)+c.aead.NonceSize() {
+		return nil, fmt.Errorf("the value of %s is not encrypted", key)
+	}
+	value = value[len(sealedMagic):]
+	nonce, value := value[:c.aead.NonceSize()], value[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, value, []byte(strings.Trim(key, "/")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the value of %s: %v", key, err)
+	}
+	return plain, nil
+}
+
+// openPair returns a copy of the pair with its value decrypted, the
+// directories have no value
+func (c *valueCipher) openPair(pair *store.KVPair) (*store.KVPair, error) {
+	if pair == nil || len(pair.Value) == 0 {
+		return pair, nil
+	}
+	value, err := c.open(pair.Key, pair.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &store.KVPair{Key: pair.Key, Value: value, LastIndex: pair.LastIndex}, nil
+}
+
+// encryptedStore encrypts the values written to a KV store and decrypts the
+// values read
+type encryptedStore struct {
+	store.Store
+	c *valueCipher
+}
+
+func (s *encryptedStore) Get(key string) (*store.KVPair, error) {
+	pair, err := s.Store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.c.openPair(pair)
+}
+
+func (s *encryptedStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	v, err := s.c.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return s.Store.Put(key, v, options)
+}
+
+func (s *encryptedStore) List(directory string) ([]*store.KVPair, error) {
+	pairs, err := s.Store.List(directory)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*store.KVPair, 0, len(pairs))
+	for _, p := range pairs {
+		op, err := s.c.openPair(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, op)
+	}
+	return res, nil
+}
+
+func (s *encryptedStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	v, err := s.c.seal(key, value)
+	if err != nil {
+		return false, nil, err
+	}
+	ok, pair, err := s.Store.AtomicPut(key, v, previous, options)
+	if err != nil || pair == nil {
+		return ok, pair, err
+	}
+	return ok, &store.KVPair{Key: pair.Key, Value: value, LastIndex: pair.LastIndex}, nil
+}
+
+func (s *encryptedStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	ch, err := s.Store.Watch(key, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *store.KVPair)
+	go func() {
+		defer close(out)
+		for p := range ch {
+			op, err := s.c.openPair(p)
+			if err != nil {
+				logrus.Warnf("Dropping the watch event of %s: %v", key, err)
+				continue
+			}
+			select {
+			case out <- op:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *encryptedStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	ch, err := s.Store.WatchTree(directory, stopCh)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer close(out)
+		for pairs := range ch {
+			res := make([]*store.KVPair, 0, len(pairs))
+			for _, p := range pairs {
+				op, err := s.c.openPair(p)
+				if err != nil {
+					logrus.Warnf("Dropping the watch event of %s: %v", p.Key, err)
+					continue
+				}
+				res = append(res, op)
+			}
+			select {
+			case out <- res:
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// AtomicTxn seals the values and passes the transaction to a store
+// implementing TxnStore
+func (s *encryptedStore) AtomicTxn(ops []*TxnOp) ([]*store.KVPair, error) {
+	ts, ok := s.Store.(TxnStore)
+	if !ok {
+		return nil, store.ErrCallNotSupported
+	}
+	sealedOps := make([]*TxnOp, 0, len(ops))
+	for _, op := range ops {
+		sop := *op
+		if op.Value != nil {
+			v, err := s.c.seal(op.Key, op.Value)
+			if err != nil {
+				return nil, err
+			}
+			sop.Value = v
+		}
+		sealedOps = append(sealedOps, &sop)
+	}
+	pairs, err := ts.AtomicTxn(sealedOps)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range pairs {
+		if p != nil {
+			pairs[i] = &store.KVPair{Key: p.Key, Value: ops[i].Value, LastIndex: p.LastIndex}
+		}
+	}
+	return pairs, nil
+}
+
+// encryptPlaintext encrypts in place the values written under root before
+// the encryption was enabled
+func encryptPlaintext(kv store.Store, c *valueCipher, root string) error {
+	pairs, err := kv.List(root)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var n int
+	for _, p := range pairs {
+		if len(p.Value) == 0 || sealed(p.Value) {
+			continue
+		}
+		v, err := c.seal(p.Key, p.Value)
+		if err != nil {
+			return err
+		}
+		if _, _, err := kv.AtomicPut(p.Key, v, p, nil); err != nil {
+			return fmt.Errorf("failed to encrypt the value of %s: %v", p.Key, err)
+		}
+		n++
+	}
+	if n > 0 {
+		logrus.Infof("Encrypted %d values of the data store written before the encryption", n)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package datastore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	boltdb.Register()
+	dir, err := ioutil.TempDir("", "bolt-encryption")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "local-kv.db")
+	config := &store.Config{Bucket: "libnetwork"}
+	key := StaticKey(bytes.Repeat([]byte{1}, 32))
+
+	// a store written before the encryption is enabled
+	ds, err := newClient(LocalScope, ScopeClientCfg{Provider: string(store.BOLTDB), Address: path, Config: config}, false)
+	assert.NilError(t, err)
+	o1 := dummyKVObject("1", true)
+	assert.NilError(t, ds.PutObjectAtomic(o1))
+	ds.Close()
+
+	ds, err = newClient(LocalScope, ScopeClientCfg{Provider: string(store.BOLTDB), Address: path, Config: config, KeyProvider: key}, false)
+	assert.NilError(t, err)
+	defer ds.Close()
+	raw := ds.(*datastore).backend()
+	pair, err := raw.Get(Key(o1.Key()...))
+	assert.NilError(t, err)
+	assert.Check(t, sealed(pair.Value), "the plaintext value is encrypted at the start")
+
+	got := dummyKVObject("1", true)
+	assert.NilError(t, ds.GetObject(Key(o1.Key()...), got))
+	assert.Check(t, is.Equal(got.Name, "testNw"))
+
+	o2 := dummyKVObject("2", true)
+	assert.NilError(t, ds.AtomicTxn(TxPut(got), TxPut(o2)))
+	pair, err = raw.Get(Key(o2.Key()...))
+	assert.NilError(t, err)
+	assert.Check(t, sealed(pair.Value), "the transactions encrypt the values")
+	pairs, err := ds.KVStore().List(Key(dummyKey))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(pairs, 2))
+	for _, p := range pairs {
+		assert.Check(t, !sealed(p.Value))
+	}
+
+	// the values are bound to their key
+	assert.NilError(t, raw.Put(Key(o1.Key()...), pair.Value, nil))
+	err = ds.GetObject(Key(o1.Key()...), dummyKVObject("1", true))
+	assert.Check(t, is.ErrorContains(err, "failed to decrypt"))
+
+	_, err = newValueCipher(StaticKey([]byte("short")))
+	assert.Check(t, err != nil)
+}
+
+func TestFileKey(t *testing.T) {
+	f, err := ioutil.TempFile("", "store-key")
+	assert.NilError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("AQEBAQEBAQEBAQEBAQEBAQ==\n")
+	assert.NilError(t, err)
+	f.Close()
+
+	key, err := FileKey(f.Name()).Key()
+	assert.NilError(t, err)
+	assert.Check(t, is.DeepEqual(key, bytes.Repeat([]byte{1}, 16)))
+}
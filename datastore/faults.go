@@ -0,0 +1,80 @@
+package datastore
+
+import (
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/faults"
+)
+
+// faultyStore fails or delays the calls to a KV store with the fault
+// injected at faults.Store
+type faultyStore struct {
+	store.Store
+}
+
+func (s *faultyStore) Get(key string) (*store.KVPair, error) {
+	if err := faults.Check(faults.Store); err != nil {
+		return nil, err
+	}
+	return s.Store.Get(key)
+}
+
+func (s *faultyStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	if err := faults.Check(faults.Store); err != nil {
+		return err
+	}
+	return s.Store.Put(key, value, options)
+}
+
+func (s *faultyStore) Delete(key string) error {
+	if err := faults.Check(faults.Store); err != nil {
+		return err
+	}
+	return s.Store.Delete(key)
+}
+
+func (s *faultyStore) Exists(key string) (bool, error) {
+	if err := faults.Check(faults.Store); err != nil {
+		return false, err
+	}
+	return s.Store.Exists(key)
+}
+
+func (s *faultyStore) List(directory string) ([]*store.KVPair, error) {
+	if err := faults.Check(faults.Store); err != nil {
+		return nil, err
+	}
+	return s.Store.List(directory)
+}
+
+func (s *faultyStore) DeleteTree(directory string) error {
+	if err := faults.Check(faults.Store); err != nil {
+		return err
+	}
+	return s.Store.DeleteTree(directory)
+}
+
+func (s *faultyStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	if err := faults.Check(faults.Store); err != nil {
+		return false, nil, err
+	}
+	return s.Store.AtomicPut(key, value, previous, options)
+}
+
+func (s *faultyStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if err := faults.Check(faults.Store); err != nil {
+		return false, err
+	}
+	return s.Store.AtomicDelete(key, previous)
+}
+
+// AtomicTxn passes the transaction to a store implementing TxnStore
+func (s *faultyStore) AtomicTxn(ops []*TxnOp) ([]*store.KVPair, error) {
+	ts, ok := s.Store.(TxnStore)
+	if !ok {
+		return nil, store.ErrCallNotSupported
+	}
+	if err := faults.Check(faults.Store); err != nil {
+		return nil, err
+	}
+	return ts.AtomicTxn(ops)
+}
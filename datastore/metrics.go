@@ -0,0 +1,250 @@
+package datastore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+// OpMetrics are the counters of an operation of a KV store on the keys
+// under a prefix
+type OpMetrics struct {
+	Op string `json:"op"`
+	// Prefix is the first element of the keys after the root chain, such
+	// as network or endpoint
+	Prefix   string `json:"prefix"`
+	Calls    uint64 `json:"calls"`
+	Failures uint64 `json:"failures"`
+	// Slow is the number of calls which took longer than the slow
+	// threshold of the store
+	Slow         uint64        `json:"slow"`
+	TotalLatency time.Duration `json:"total_latency"`
+	MaxLatency   time.Duration `json:"max_latency"`
+}
+
+// AvgLatency returns the mean latency of the calls
+func (m *OpMetrics) AvgLatency() time.Duration {
+	if m.Calls == 0 {
+		return 0
+	}
+	return m.TotalLatency / time.Duration(m.Calls)
+}
+
+// StoreMetrics is a point in time snapshot of the counters of the calls of
+// a data store to its KV store
+type StoreMetrics struct {
+	Scope   string `json:"scope"`
+	Backend string `json:"backend"`
+	// SlowThreshold is the latency from which the calls are logged, zero
+	// when the slow calls are not logged
+	SlowThreshold time.Duration `json:"slow_threshold"`
+	Ops           []*OpMetrics  `json:"ops"`
+}
+
+func (m *StoreMetrics) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "scope: %s, backend: %s, slow threshold: %v\n", m.Scope, m.Backend, m.SlowThreshold)
+	for _, o := range m.Ops {
+		fmt.Fprintf(&b, "%s %s: calls: %d, failures: %d, slow: %d, avg: %v, max: %v\n",
+			o.Op, o.Prefix, o.Calls, o.Failures, o.Slow, o.AvgLatency(), o.MaxLatency)
+	}
+	return b.String()
+}
+
+// MetricsReporter is implemented by the data stores keeping the metrics of
+// their KV store calls
+type MetricsReporter interface {
+	Metrics() *StoreMetrics
+}
+
+type opKey struct {
+	op     string
+	prefix string
+}
+
+// storeMetrics keeps the counters of the calls to a KV store and logs the
+// slow ones
+type storeMetrics struct {
+	scope   string
+	backend string
+	slow    time.Duration
+
+	sync.Mutex
+	ops map[opKey]*OpMetrics
+}
+
+func newStoreMetrics(scope, backend string, slow time.Duration) *storeMetrics {
+	return &storeMetrics{
+		scope:   scope,
+		backend: backend,
+		slow:    slow,
+		ops:     make(map[opKey]*OpMetrics),
+	}
+}
+
+// metricsPrefix returns the prefix the calls on the key are counted under
+func metricsPrefix(key string) string {
+	chain, err := ParseKey(key)
+	if err != nil {
+		return "/"
+	}
+	return chain[0]
+}
+
+// observe records a call started at start, it is meant to be deferred.
+// The missing keys are not failures, the callers look up keys which may
+// not be there.
+func (m *storeMetrics) observe(op, key string, start time.Time, err *error) {
+	if m == nil {
+		return
+	}
+	d := time.Since(start)
+	failed := err != nil && *err != nil && *err != store.ErrKeyNotFound
+	slow := m.slow > 0 && d >= m.slow
+
+	k := opKey{op: op, prefix: metricsPrefix(key)}
+	m.Lock()
+	o, ok := m.ops[k]
+	if !ok {
+		o = &OpMetrics{Op: k.op, Prefix: k.prefix}
+		m.ops[k] = o
+	}
+	o.Calls++
+	o.TotalLatency += d
+	if d > o.MaxLatency {
+		o.MaxLatency = d
+	}
+	if failed {
+		o.Failures++
+	}
+	if slow {
+		o.Slow++
+	}
+	m.Unlock()
+
+	if slow {
+		l := logrus.WithFields(logrus.Fields{"scope": m.scope, "backend": m.backend, "op": op, "key": key, "latency": d})
+		if failed {
+			l = l.WithError(*err)
+		}
+		l.Warn("Slow datastore operation")
+	}
+}
+
+func (m *storeMetrics) snapshot() *StoreMetrics {
+	s := &StoreMetrics{Scope: m.scope, Backend: m.backend, SlowThreshold: m.slow, Ops: []*OpMetrics{}}
+	m.Lock()
+	for _, o := range m.ops {
+		cp := *o
+		s.Ops = append(s.Ops, &cp)
+	}
+	m.Unlock()
+	sort.Slice(s.Ops, func(i, j int) bool {
+		if s.Ops[i].Op != s.Ops[j].Op {
+			return s.Ops[i].Op < s.Ops[j].Op
+		}
+		return s.Ops[i].Prefix < s.Ops[j].Prefix
+	})
+	return s
+}
+
+// instrumentedStore records the calls to a KV store in the metrics
+type instrumentedStore struct {
+	store.Store
+	m *storeMetrics
+}
+
+func (s *instrumentedStore) Get(key string) (p *store.KVPair, err error) {
+	defer s.m.observe("get", key, time.Now(), &err)
+	return s.Store.Get(key)
+}
+
+func (s *instrumentedStore) Put(key string, value []byte, options *store.WriteOptions) (err error) {
+	defer s.m.observe("put", key, time.Now(), &err)
+	return s.Store.Put(key, value, options)
+}
+
+func (s *instrumentedStore) Delete(key string) (err error) {
+	defer s.m.observe("delete", key, time.Now(), &err)
+	return s.Store.Delete(key)
+}
+
+func (s *instrumentedStore) Exists(key string) (ok bool, err error) {
+	defer s.m.observe("exists", key, time.Now(), &err)
+	return s.Store.Exists(key)
+}
+
+func (s *instrumentedStore) Watch(key string, stopCh <-chan struct{}) (ch <-chan *store.KVPair, err error) {
+	defer s.m.observe("watch", key, time.Now(), &err)
+	return s.Store.Watch(key, stopCh)
+}
+
+func (s *instrumentedStore) WatchTree(directory string, stopCh <-chan struct{}) (ch <-chan []*store.KVPair, err error) {
+	defer s.m.observe("watchtree", directory, time.Now(), &err)
+	return s.Store.WatchTree(directory, stopCh)
+}
+
+func (s *instrumentedStore) List(directory string) (pairs []*store.KVPair, err error) {
+	defer s.m.observe("list", directory, time.Now(), &err)
+	return s.Store.List(directory)
+}
+
+func (s *instrumentedStore) DeleteTree(directory string) (err error) {
+	defer s.m.observe("deletetree", directory, time.Now(), &err)
+	return s.Store.DeleteTree(directory)
+}
+
+func (s *instrumentedStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (ok bool, p *store.KVPair, err error) {
+	defer s.m.observe("atomicput", key, time.Now(), &err)
+	return s.Store.AtomicPut(key, value, previous, options)
+}
+
+func (s *instrumentedStore) AtomicDelete(key string, previous *store.KVPair) (ok bool, err error) {
+	defer s.m.observe("atomicdelete", key, time.Now(), &err)
+	return s.Store.AtomicDelete(key, previous)
+}
+
+// AtomicTxn passes the transaction to a store implementing TxnStore, the
+// call is counted under the prefix of the first key
+func (s *instrumentedStore) AtomicTxn(ops []*TxnOp) (pairs []*store.KVPair, err error) {
+	ts, ok := s.Store.(TxnStore)
+	if !ok {
+		return nil, store.ErrCallNotSupported
+	}
+	if len(ops) > 0 {
+		defer s.m.observe("txn", ops[0].Key, time.Now(), &err)
+	}
+	return ts.AtomicTxn(ops)
+}
+
+// Metrics returns the metrics of the calls of the data store to its KV
+// store
+func (ds *datastore) Metrics() *StoreMetrics {
+	if ds.metrics == nil {
+		return &StoreMetrics{Scope: ds.scope, Ops: []*OpMetrics{}}
+	}
+	return ds.metrics.snapshot()
+}
+
+// backend returns the KV store under the instrumentation, the fault
+// injection and the encryption
+func (ds *datastore) backend() store.Store {
+	kv := ds.store
+	for {
+		switch s := kv.(type) {
+		case *instrumentedStore:
+			kv = s.Store
+		case *faultyStore:
+			kv = s.Store
+		case *encryptedStore:
+			kv = s.Store
+		default:
+			return kv
+		}
+	}
+}
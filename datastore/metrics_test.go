@@ -0,0 +1,82 @@
+package datastore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/faults"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// slowMockStore is a MockStore taking its time to answer the Gets
+type slowMockStore struct {
+	*MockStore
+	delay time.Duration
+	err   error
+}
+
+func (s *slowMockStore) Get(key string) (*store.KVPair, error) {
+	time.Sleep(s.delay)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.MockStore.Get(key)
+}
+
+func TestStoreMetrics(t *testing.T) {
+	kv := &slowMockStore{MockStore: NewMockStore()}
+	m := newStoreMetrics(GlobalScope, "mock", 10*time.Millisecond)
+	ds := &datastore{scope: GlobalScope, store: &instrumentedStore{Store: kv, m: m}, metrics: m}
+
+	o := dummyKVObject("1", true)
+	assert.NilError(t, ds.PutObjectAtomic(o))
+	kv.delay = 20 * time.Millisecond
+	assert.NilError(t, ds.GetObject(Key(o.Key()...), dummyKVObject("1", true)))
+	kv.delay = 0
+	kv.err = errors.New("unreachable")
+	assert.Check(t, ds.GetObject(Key("network", "n1"), dummyKVObject("1", true)) != nil)
+	kv.err = store.ErrKeyNotFound
+	assert.Check(t, is.Equal(ds.GetObject(Key("network", "n2"), dummyKVObject("1", true)), ErrKeyNotFound))
+
+	res := ds.Metrics()
+	assert.Check(t, is.Equal(res.Backend, "mock"))
+	assert.Assert(t, is.Len(res.Ops, 3))
+
+	put := res.Ops[0]
+	assert.Check(t, is.Equal(put.Op, "atomicput"))
+	assert.Check(t, is.Equal(put.Prefix, dummyKey))
+	assert.Check(t, is.Equal(put.Calls, uint64(1)))
+
+	get := res.Ops[1]
+	assert.Check(t, is.Equal(get.Op, "get"))
+	assert.Check(t, is.Equal(get.Prefix, dummyKey))
+	assert.Check(t, is.Equal(get.Slow, uint64(1)))
+	assert.Check(t, get.MaxLatency >= 20*time.Millisecond)
+
+	// the missing keys are not failures
+	netGet := res.Ops[2]
+	assert.Check(t, is.Equal(netGet.Prefix, "network"))
+	assert.Check(t, is.Equal(netGet.Calls, uint64(2)))
+	assert.Check(t, is.Equal(netGet.Failures, uint64(1)))
+	assert.Check(t, is.Equal(netGet.Slow, uint64(0)))
+}
+
+func TestStoreFaults(t *testing.T) {
+	defer faults.Reset()
+	m := newStoreMetrics(GlobalScope, "mock", 0)
+	ds := &datastore{scope: GlobalScope, store: &instrumentedStore{Store: &faultyStore{Store: NewMockStore()}, m: m}, metrics: m}
+
+	faults.Inject(faults.Store, faults.Fault{Err: errors.New("store unavailable"), Count: 1})
+	o := dummyKVObject("1", true)
+	assert.Check(t, ds.PutObjectAtomic(o) != nil)
+	assert.NilError(t, ds.PutObjectAtomic(o))
+
+	var failures uint64
+	for _, op := range ds.Metrics().Ops {
+		failures += op.Failures
+	}
+	assert.Check(t, is.Equal(failures, uint64(1)))
+}
@@ -0,0 +1,166 @@
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/types"
+)
+
+// MigrationReport is the outcome of the migration of the libnetwork keys
+// from a data store to another. In a dry run nothing is written, Copied
+// lists the keys which would be.
+type MigrationReport struct {
+	DryRun bool `json:"dry_run"`
+	// Keys is the number of libnetwork keys of the source
+	Keys int `json:"keys"`
+	// Copied are the keys written to the destination
+	Copied []string `json:"copied"`
+	// Identical are the keys the destination already holds with the same
+	// value
+	Identical []string `json:"identical"`
+	// Conflicts are the keys the destination holds with another value,
+	// they are overwritten only if asked to
+	Conflicts []string `json:"conflicts"`
+	// Errors are the keys which could not be copied, or whose copy does
+	// not match the source, with the reason
+	Errors []string `json:"errors"`
+}
+
+func (r *MigrationReport) String() string {
+	var b strings.Builder
+	mode := "migration"
+	if r.DryRun {
+		mode = "dry run"
+	}
+	fmt.Fprintf(&b, "%s: %d keys, %d copied, %d identical, %d conflicts, %d errors\n",
+		mode, r.Keys, len(r.Copied), len(r.Identical), len(r.Conflicts), len(r.Errors))
+	for _, k := range r.Conflicts {
+		fmt.Fprintf(&b, "conflict: %s\n", k)
+	}
+	for _, e := range r.Errors {
+		fmt.Fprintf(&b, "error: %s\n", e)
+	}
+	return b.String()
+}
+
+// migrationKey puts a key listed by a backend in the form of Key, the
+// backends differ on the leading and trailing slashes
+func migrationKey(key string) string {
+	return strings.Trim(key, "/") + "/"
+}
+
+// listTree returns the pairs under the directory, walking down the backends
+// which only list the direct children
+func listTree(kv store.Store, directory string, pairs map[string]*store.KVPair) error {
+	list, err := kv.List(directory)
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, p := range list {
+		key := migrationKey(p.Key)
+		if _, ok := pairs[key]; ok {
+			continue
+		}
+		if len(p.Value) == 0 && key != migrationKey(directory) {
+			// a directory, or an empty key
+			n := len(pairs)
+			if err := listTree(kv, key, pairs); err != nil {
+				return err
+			}
+			if len(pairs) > n {
+				continue
+			}
+		}
+		pairs[key] = p
+	}
+	return nil
+}
+
+// Migrate copies the libnetwork keys of the src data store to dst. The keys
+// dst already holds with another value are reported as conflicts and kept,
+// unless overwrite is set. Every copied key is read back from dst and
+// compared with the source. With dryRun nothing is written and the report
+// tells what the migration would do.
+func Migrate(src, dst DataStore, dryRun, overwrite bool) (*MigrationReport, error) {
+	if src == nil || dst == nil {
+		return nil, types.BadRequestErrorf("migration needs a source and a destination data store")
+	}
+	skv, dkv := src.KVStore(), dst.KVStore()
+
+	pairs := make(map[string]*store.KVPair)
+	if err := listTree(skv, Key(), pairs); err != nil {
+		return nil, fmt.Errorf("failed to list the keys of the source: %v", err)
+	}
+	keys := make([]string, 0, len(pairs))
+	for k := range pairs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	r := &MigrationReport{
+		DryRun:    dryRun,
+		Keys:      len(keys),
+		Copied:    []string{},
+		Identical: []string{},
+		Conflicts: []string{},
+		Errors:    []string{},
+	}
+	for _, k := range keys {
+		value := pairs[k].Value
+		cur, err := dkv.Get(k)
+		switch {
+		case err == nil && bytes.Equal(cur.Value, value):
+			r.Identical = append(r.Identical, k)
+			continue
+		case err == nil:
+			r.Conflicts = append(r.Conflicts, k)
+			if !overwrite {
+				continue
+			}
+		case err != store.ErrKeyNotFound:
+			r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", k, err))
+			continue
+		}
+		if dryRun {
+			r.Copied = append(r.Copied, k)
+			continue
+		}
+		if err := dkv.Put(k, value, nil); err != nil {
+			r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", k, err))
+			continue
+		}
+		if err := checkMigrated(skv, dkv, k, pairs[k]); err != nil {
+			r.Errors = append(r.Errors, fmt.Sprintf("%s: %v", k, err))
+			continue
+		}
+		r.Copied = append(r.Copied, k)
+	}
+	return r, nil
+}
+
+// checkMigrated verifies that dst holds the value of the key as listed from
+// src, and that the key did not change in src meanwhile
+func checkMigrated(src, dst store.Store, key string, listed *store.KVPair) error {
+	got, err := dst.Get(key)
+	if err != nil {
+		return fmt.Errorf("could not read back the copy: %v", err)
+	}
+	if !bytes.Equal(got.Value, listed.Value) {
+		return fmt.Errorf("the copy does not match the source")
+	}
+	now, err := src.Get(key)
+	if err != nil {
+		return fmt.Errorf("could not read the source again: %v", err)
+	}
+	if !bytes.Equal(now.Value, listed.Value) {
+		return fmt.Errorf("the source changed during the migration")
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package datastore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestMigrate(t *testing.T) {
+	boltdb.Register()
+	dir, err := ioutil.TempDir("", "migrate")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	newStore := func(name string) DataStore {
+		ds, err := newClient(LocalScope, ScopeClientCfg{Provider: string(store.BOLTDB), Address: filepath.Join(dir, name), Config: &store.Config{Bucket: "libnetwork"}}, false)
+		assert.NilError(t, err)
+		return ds
+	}
+	src, dst := newStore("src.db"), newStore("dst.db")
+	defer src.Close()
+	defer dst.Close()
+
+	for k, v := range map[string]string{
+		Key("network", "n1"):         "n1",
+		Key("network", "n2"):         "n2",
+		Key("endpoint", "n1", "ep1"): "ep1",
+		Key("endpoint", "n2", "ep2"): "ep2",
+		"other/application/key/":     "ignored",
+	} {
+		assert.NilError(t, src.KVStore().Put(k, []byte(v), nil))
+	}
+	assert.NilError(t, dst.KVStore().Put(Key("network", "n1"), []byte("n1"), nil))
+	assert.NilError(t, dst.KVStore().Put(Key("network", "n2"), []byte("old"), nil))
+
+	r, err := Migrate(src, dst, true, false)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(r.Keys, 4))
+	assert.Check(t, is.DeepEqual(r.Copied, []string{Key("endpoint", "n1", "ep1"), Key("endpoint", "n2", "ep2")}))
+	assert.Check(t, is.DeepEqual(r.Identical, []string{Key("network", "n1")}))
+	assert.Check(t, is.DeepEqual(r.Conflicts, []string{Key("network", "n2")}))
+	_, err = dst.KVStore().Get(Key("endpoint", "n1", "ep1"))
+	assert.Check(t, is.Equal(err, store.ErrKeyNotFound))
+
+	r, err = Migrate(src, dst, false, true)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(r.Errors, 0))
+	assert.Check(t, is.Len(r.Copied, 3))
+	p, err := dst.KVStore().Get(Key("network", "n2"))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(p.Value), "n2"))
+
+	// a second run has nothing left to copy
+	r, err = Migrate(src, dst, false, false)
+	assert.NilError(t, err)
+	assert.Check(t, is.Len(r.Copied, 0))
+	assert.Check(t, is.Len(r.Identical, 4))
+}
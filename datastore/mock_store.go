@@ -2,6 +2,7 @@ package datastore
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/docker/libkv/store"
 	"github.com/docker/libnetwork/types"
@@ -46,6 +47,7 @@ func (s *MockStore) Put(key string, value []byte, options *store.WriteOptions) e
 	if mData == nil {
 		mData = &MockData{value, 0}
 	}
+	mData.Data = value
 	mData.Index = mData.Index + 1
 	s.db[key] = mData
 	return nil
@@ -65,7 +67,16 @@ func (s *MockStore) Exists(key string) (bool, error) {
 
 // List gets a range of values at "directory"
 func (s *MockStore) List(prefix string) ([]*store.KVPair, error) {
-	return nil, ErrNotImplemented
+	var pairs []*store.KVPair
+	for key, mData := range s.db {
+		if strings.HasPrefix(key, prefix) {
+			pairs = append(pairs, &store.KVPair{Key: key, Value: mData.Data, LastIndex: mData.Index})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return pairs, nil
 }
 
 // DeleteTree deletes a range of values at "directory"
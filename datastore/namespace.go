@@ -0,0 +1,171 @@
+package datastore
+
+import (
+	"strings"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/types"
+)
+
+// parseNamespace validates a key namespace and returns it without the
+// leading and trailing slashes
+func parseNamespace(ns string) (string, error) {
+	ns = strings.Trim(strings.TrimSpace(ns), "/")
+	if ns == "" {
+		return "", nil
+	}
+	for _, e := range strings.Split(ns, "/") {
+		if e == "" || e == "." || e == ".." || strings.ContainsAny(e, " \t\n*") {
+			return "", types.BadRequestErrorf("invalid key namespace %q", ns)
+		}
+	}
+	return ns, nil
+}
+
+// namespacedStore puts the keys of a KV store under a namespace. The keys
+// it returns are the keys without the namespace, so the namespace is
+// invisible to the callers.
+type namespacedStore struct {
+	store.Store
+	ns string
+}
+
+func (s *namespacedStore) key(key string) string {
+	return s.ns + "/" + strings.TrimPrefix(key, "/")
+}
+
+func (s *namespacedStore) trim(key string) string {
+	k := strings.TrimPrefix(key, "/")
+	if k == s.ns {
+		return ""
+	}
+	return strings.TrimPrefix(k, s.ns+"/")
+}
+
+func (s *namespacedStore) pair(p *store.KVPair) *store.KVPair {
+	if p == nil {
+		return nil
+	}
+	return &store.KVPair{Key: s.trim(p.Key), Value: p.Value, LastIndex: p.LastIndex}
+}
+
+func (s *namespacedStore) previous(p *store.KVPair) *store.KVPair {
+	if p == nil {
+		return nil
+	}
+	return &store.KVPair{Key: s.key(p.Key), Value: p.Value, LastIndex: p.LastIndex}
+}
+
+func (s *namespacedStore) Get(key string) (*store.KVPair, error) {
+	p, err := s.Store.Get(s.key(key))
+	if err != nil {
+		return nil, err
+	}
+	return s.pair(p), nil
+}
+
+func (s *namespacedStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	return s.Store.Put(s.key(key), value, options)
+}
+
+func (s *namespacedStore) Delete(key string) error {
+	return s.Store.Delete(s.key(key))
+}
+
+func (s *namespacedStore) Exists(key string) (bool, error) {
+	return s.Store.Exists(s.key(key))
+}
+
+func (s *namespacedStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	ch, err := s.Store.Watch(s.key(key), stopCh)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *store.KVPair)
+	go func() {
+		defer close(out)
+		for p := range ch {
+			select {
+			case out <- s.pair(p):
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *namespacedStore) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	ch, err := s.Store.WatchTree(s.key(directory), stopCh)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan []*store.KVPair)
+	go func() {
+		defer close(out)
+		for pairs := range ch {
+			select {
+			case out <- s.pairs(pairs):
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *namespacedStore) pairs(pairs []*store.KVPair) []*store.KVPair {
+	res := make([]*store.KVPair, 0, len(pairs))
+	for _, p := range pairs {
+		res = append(res, s.pair(p))
+	}
+	return res
+}
+
+func (s *namespacedStore) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return s.Store.NewLock(s.key(key), options)
+}
+
+func (s *namespacedStore) List(directory string) ([]*store.KVPair, error) {
+	pairs, err := s.Store.List(s.key(directory))
+	if err != nil {
+		return nil, err
+	}
+	return s.pairs(pairs), nil
+}
+
+func (s *namespacedStore) DeleteTree(directory string) error {
+	return s.Store.DeleteTree(s.key(directory))
+}
+
+func (s *namespacedStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	ok, p, err := s.Store.AtomicPut(s.key(key), value, s.previous(previous), options)
+	if err != nil {
+		return ok, nil, err
+	}
+	return ok, s.pair(p), nil
+}
+
+func (s *namespacedStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	return s.Store.AtomicDelete(s.key(key), s.previous(previous))
+}
+
+// AtomicTxn passes the transaction to a store implementing TxnStore
+func (s *namespacedStore) AtomicTxn(ops []*TxnOp) ([]*store.KVPair, error) {
+	ts, ok := s.Store.(TxnStore)
+	if !ok {
+		return nil, store.ErrCallNotSupported
+	}
+	nsOps := make([]*TxnOp, 0, len(ops))
+	for _, op := range ops {
+		nsOps = append(nsOps, &TxnOp{Key: s.key(op.Key), Value: op.Value, Previous: s.previous(op.Previous)})
+	}
+	pairs, err := ts.AtomicTxn(nsOps)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range pairs {
+		pairs[i] = s.pair(p)
+	}
+	return pairs, nil
+}
@@ -0,0 +1,49 @@
+package datastore
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestParseNamespace(t *testing.T) {
+	for ns, exp := range map[string]string{"": "", "/": "", "/prod/": "prod", "team/prod": "team/prod"} {
+		got, err := parseNamespace(ns)
+		assert.Check(t, err)
+		assert.Check(t, is.Equal(got, exp))
+	}
+	for _, ns := range []string{"a//b", "../a", "a b", "a/*"} {
+		_, err := parseNamespace(ns)
+		assert.Check(t, err != nil, ns)
+	}
+}
+
+func TestNamespacedStore(t *testing.T) {
+	kv := NewMockStore()
+	a := &datastore{scope: GlobalScope, store: &namespacedStore{Store: kv, ns: "a"}}
+	b := &datastore{scope: GlobalScope, store: &namespacedStore{Store: kv, ns: "b"}}
+
+	oa := dummyKVObject("1", true)
+	assert.NilError(t, a.PutObjectAtomic(oa))
+	ob := dummyKVObject("1", true)
+	ob.Name = "other"
+	assert.NilError(t, b.PutObjectAtomic(ob))
+	assert.Check(t, is.Len(kv.db, 2))
+	assert.Check(t, kv.db["a/"+Key(oa.Key()...)] != nil)
+
+	got := dummyKVObject("1", true)
+	assert.NilError(t, a.GetObject(Key(oa.Key()...), got))
+	assert.Check(t, is.Equal(got.Name, "testNw"))
+	assert.NilError(t, b.GetObject(Key(ob.Key()...), got))
+	assert.Check(t, is.Equal(got.Name, "other"))
+
+	// the keys are returned without the namespace
+	pairs, err := a.KVStore().List(Key())
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(pairs, 1))
+	assert.Check(t, is.Equal(pairs[0].Key, Key(oa.Key()...)))
+
+	assert.NilError(t, a.DeleteObjectAtomic(oa))
+	assert.Check(t, is.Len(kv.db, 1))
+}
@@ -0,0 +1,271 @@
+package datastore
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/sirupsen/logrus"
+)
+
+// ReadCacheStats are the counters of a ReadCache
+type ReadCacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Invalidations uint64 `json:"invalidations"`
+}
+
+// HitRate returns the share of the reads served by the cache
+func (s ReadCacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type readCacheEntry struct {
+	pair    *store.KVPair
+	expires time.Time
+}
+
+type readCacheList struct {
+	pairs   []*store.KVPair
+	expires time.Time
+}
+
+// ReadCache is a read-through cache of the Get and List calls of a slow KV
+// store. The entries expire after a TTL and are invalidated by the writes
+// going through the cache and, when the store can watch, by the changes
+// made by the other clients under the root key.
+type ReadCache struct {
+	// the counters come first for their 64-bit alignment
+	hits          uint64
+	misses        uint64
+	invalidations uint64
+
+	store.Store
+	ttl    time.Duration
+	stopCh chan struct{}
+
+	sync.Mutex
+	pairs map[string]*readCacheEntry
+	lists map[string]*readCacheList
+	// gen changes with every invalidation, a read racing with one is not
+	// cached
+	gen uint64
+}
+
+// NewReadCache wraps kv in a read cache with the passed TTL. The changes to
+// the keys under root invalidate the cache if kv supports tree watches.
+func NewReadCache(kv store.Store, ttl time.Duration, root string) *ReadCache {
+	c := &ReadCache{
+		Store:  kv,
+		ttl:    ttl,
+		stopCh: make(chan struct{}),
+		pairs:  make(map[string]*readCacheEntry),
+		lists:  make(map[string]*readCacheList),
+	}
+	go c.watch(root)
+	return c
+}
+
+func cacheKey(key string) string {
+	return strings.Trim(key, "/")
+}
+
+// watch keeps the cache in line with the tree under root, the watch is
+// restarted when the store drops it
+func (c *ReadCache) watch(root string) {
+	opts := WatchOptions{
+		Tree:          true,
+		RetryInterval: c.ttl,
+		// changes may be missed until the watch is back
+		Disconnected: func(error) { c.invalidateAll() },
+	}
+	for ev := range WatchKV(c.Store, root, opts, c.stopCh) {
+		if ev.Err == store.ErrCallNotSupported {
+			logrus.Debugf("Read cache relying on its TTL of %v, the store can't watch", c.ttl)
+			return
+		}
+		if ev.Err != nil {
+			logrus.Warnf("Read cache relying on its TTL of %v: %v", c.ttl, ev.Err)
+			c.invalidateAll()
+			return
+		}
+		c.refresh(ev.Pairs)
+	}
+}
+
+// refresh updates the cache from the current pairs of the tree: the keys
+// with a new index and the deleted keys are dropped, along with the lists
+func (c *ReadCache) refresh(pairs []*store.KVPair) {
+	current := make(map[string]uint64, len(pairs))
+	for _, p := range pairs {
+		current[cacheKey(p.Key)] = p.LastIndex
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.gen++
+	for k, e := range c.pairs {
+		if idx, ok := current[k]; !ok || idx != e.pair.LastIndex {
+			delete(c.pairs, k)
+			atomic.AddUint64(&c.invalidations, 1)
+		}
+	}
+	if len(c.lists) > 0 {
+		c.lists = make(map[string]*readCacheList)
+	}
+}
+
+func (c *ReadCache) invalidate(key string) {
+	c.Lock()
+	c.gen++
+	delete(c.pairs, cacheKey(key))
+	c.lists = make(map[string]*readCacheList)
+	c.Unlock()
+	atomic.AddUint64(&c.invalidations, 1)
+}
+
+func (c *ReadCache) invalidateAll() {
+	c.Lock()
+	c.gen++
+	c.pairs = make(map[string]*readCacheEntry)
+	c.lists = make(map[string]*readCacheList)
+	c.Unlock()
+	atomic.AddUint64(&c.invalidations, 1)
+}
+
+// Stats returns the counters of the cache
+func (c *ReadCache) Stats() ReadCacheStats {
+	return ReadCacheStats{
+		Hits:          atomic.LoadUint64(&c.hits),
+		Misses:        atomic.LoadUint64(&c.misses),
+		Invalidations: atomic.LoadUint64(&c.invalidations),
+	}
+}
+
+// Get returns the cached pair of the key, read from the store on a miss
+func (c *ReadCache) Get(key string) (*store.KVPair, error) {
+	k := cacheKey(key)
+	c.Lock()
+	if e, ok := c.pairs[k]; ok && time.Now().Before(e.expires) {
+		c.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		pair := *e.pair
+		return &pair, nil
+	}
+	gen := c.gen
+	c.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+
+	pair, err := c.Store.Get(key)
+	if err != nil || pair == nil {
+		return pair, err
+	}
+	c.Lock()
+	if c.gen == gen {
+		c.pairs[k] = &readCacheEntry{pair: pair, expires: time.Now().Add(c.ttl)}
+	}
+	c.Unlock()
+	cp := *pair
+	return &cp, nil
+}
+
+// List returns the cached pairs under the directory, read from the store on
+// a miss
+func (c *ReadCache) List(directory string) ([]*store.KVPair, error) {
+	k := cacheKey(directory)
+	c.Lock()
+	if l, ok := c.lists[k]; ok && time.Now().Before(l.expires) {
+		c.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		return copyPairs(l.pairs), nil
+	}
+	gen := c.gen
+	c.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+
+	pairs, err := c.Store.List(directory)
+	if err != nil {
+		return nil, err
+	}
+	c.Lock()
+	if c.gen == gen {
+		c.lists[k] = &readCacheList{pairs: pairs, expires: time.Now().Add(c.ttl)}
+	}
+	c.Unlock()
+	return copyPairs(pairs), nil
+}
+
+func copyPairs(pairs []*store.KVPair) []*store.KVPair {
+	res := make([]*store.KVPair, 0, len(pairs))
+	for _, p := range pairs {
+		cp := *p
+		res = append(res, &cp)
+	}
+	return res
+}
+
+// Put writes through and invalidates the key
+func (c *ReadCache) Put(key string, value []byte, options *store.WriteOptions) error {
+	err := c.Store.Put(key, value, options)
+	c.invalidate(key)
+	return err
+}
+
+// Delete writes through and invalidates the key
+func (c *ReadCache) Delete(key string) error {
+	err := c.Store.Delete(key)
+	c.invalidate(key)
+	return err
+}
+
+// DeleteTree writes through and invalidates the cache
+func (c *ReadCache) DeleteTree(directory string) error {
+	err := c.Store.DeleteTree(directory)
+	c.invalidateAll()
+	return err
+}
+
+// AtomicPut writes through and caches the new pair. A failure invalidates
+// the key, the caller is likely to read it again to retry.
+func (c *ReadCache) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	ok, pair, err := c.Store.AtomicPut(key, value, previous, options)
+	c.invalidate(key)
+	if err == nil && pair != nil && (options == nil || options.TTL == 0) {
+		c.Lock()
+		c.pairs[cacheKey(key)] = &readCacheEntry{pair: pair, expires: time.Now().Add(c.ttl)}
+		c.Unlock()
+	}
+	return ok, pair, err
+}
+
+// AtomicDelete writes through and invalidates the key
+func (c *ReadCache) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	ok, err := c.Store.AtomicDelete(key, previous)
+	c.invalidate(key)
+	return ok, err
+}
+
+// AtomicTxn writes through to a store implementing TxnStore and invalidates
+// the keys
+func (c *ReadCache) AtomicTxn(ops []*TxnOp) ([]*store.KVPair, error) {
+	ts, ok := c.Store.(TxnStore)
+	if !ok {
+		return nil, store.ErrCallNotSupported
+	}
+	pairs, err := ts.AtomicTxn(ops)
+	for _, op := range ops {
+		c.invalidate(op.Key)
+	}
+	return pairs, err
+}
+
+// Close stops the invalidation watch and closes the store
+func (c *ReadCache) Close() {
+	close(c.stopCh)
+	c.Store.Close()
+}
@@ -0,0 +1,80 @@
+package datastore
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// watchedMockStore is a MockStore whose tree watch is fed by the test, the
+// watch lists the tree concurrently with the test
+type watchedMockStore struct {
+	*MockStore
+	treeCh chan []*store.KVPair
+	sync.Mutex
+}
+
+func (s *watchedMockStore) Get(key string) (*store.KVPair, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.MockStore.Get(key)
+}
+
+func (s *watchedMockStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.MockStore.Put(key, value, options)
+}
+
+func (s *watchedMockStore) List(prefix string) ([]*store.KVPair, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.MockStore.List(prefix)
+}
+
+func (s *watchedMockStore) WatchTree(prefix string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return s.treeCh, nil
+}
+
+func TestReadCache(t *testing.T) {
+	kv := &watchedMockStore{MockStore: NewMockStore(), treeCh: make(chan []*store.KVPair)}
+	c := NewReadCache(kv, time.Hour, Key())
+	defer c.Close()
+
+	key := Key("network", "n1")
+	assert.NilError(t, c.Put(key, []byte("v1"), nil))
+
+	p, err := c.Get(key)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(p.Value), "v1"))
+	p, err = c.Get(key)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(p.LastIndex, uint64(1)))
+	assert.Check(t, is.Equal(c.Stats().Hits, uint64(1)))
+	assert.Check(t, is.Equal(c.Stats().Misses, uint64(1)))
+
+	// a write of another client shows in the tree watch
+	assert.NilError(t, kv.Put(key, []byte("v2"), nil))
+	invalidations := c.Stats().Invalidations
+	kv.treeCh <- []*store.KVPair{{Key: key, Value: []byte("v2"), LastIndex: 2}}
+	for i := 0; c.Stats().Invalidations == invalidations; i++ {
+		assert.Assert(t, i < 100, "the tree event was not processed")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	p, err = c.Get(key)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(p.Value), "v2"))
+	assert.Check(t, is.Equal(c.Stats().Misses, uint64(2)))
+
+	// the writes through the cache invalidate the key
+	assert.NilError(t, c.Put(key, []byte("v3"), nil))
+	p, err = c.Get(key)
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(p.Value), "v3"))
+	assert.Check(t, is.Equal(c.Stats().HitRate(), 0.25))
+}
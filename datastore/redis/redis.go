@@ -0,0 +1,681 @@
+// Package redis implements the libkv store interface on Redis, as a
+// lighter weight global store than consul, etcd or zookeeper.
+//
+// Every key is a hash holding the value and the modify index of the key,
+// the indexes come from a counter shared by the keys. The atomic operations
+// are Lua scripts comparing the index, the watches are based on the
+// keyspace notifications, which the store enables on the server when it
+// can. Redis cluster is not supported: the scripts touch the key and the
+// counter.
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/libkv"
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/sirupsen/logrus"
+)
+
+// REDIS backend
+const REDIS store.Backend = "redis"
+
+const (
+	defaultTimeout = 10 * time.Second
+	// indexKey is the counter the modify indexes are taken from
+	indexKey = "libkv:index"
+	// notifyFlags are the keyspace notification classes the watches need:
+	// keyspace events of the generic, hash and expiration commands
+	notifyFlags = "Kghx"
+	scanCount   = 100
+)
+
+var (
+	// ErrNoEndpoint is thrown when no endpoint is passed
+	ErrNoEndpoint = errors.New("redis requires at least one endpoint")
+)
+
+const putScript = `
+local idx = redis.call('INCR', KEYS[2])
+redis.call('HMSET', KEYS[1], 'value', ARGV[1], 'index', idx)
+if tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+else
+	redis.call('PERSIST', KEYS[1])
+end
+return idx
+`
+
+// atomicPutScript returns -1 when the key exists or was modified, -2 when
+// the previous key is gone
+const atomicPutScript = `
+local cur = redis.call('HGET', KEYS[1], 'index')
+if ARGV[3] == '' then
+	if cur then
+		return -1
+	end
+else
+	if not cur then
+		return -2
+	end
+	if cur ~= ARGV[3] then
+		return -1
+	end
+end
+local idx = redis.call('INCR', KEYS[2])
+redis.call('HMSET', KEYS[1], 'value', ARGV[1], 'index', idx)
+if tonumber(ARGV[2]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+else
+	redis.call('PERSIST', KEYS[1])
+end
+return idx
+`
+
+// atomicDeleteScript returns -1 when the key was modified, -2 when it is
+// gone
+const atomicDeleteScript = `
+local cur = redis.call('HGET', KEYS[1], 'index')
+if not cur then
+	return -2
+end
+if cur ~= ARGV[1] then
+	return -1
+end
+redis.call('DEL', KEYS[1])
+return 1
+`
+
+// atomicTxnScript checks the index of every key, ARGV holding the previous
+// index, the operation and the value of each key, before writing any. It
+// returns {-1, i} when the key i exists or was modified, {-2, i} when it is
+// gone, else the new indexes, 0 for the deleted keys.
+const atomicTxnScript = `
+local n = #KEYS - 1
+for i = 1, n do
+	local cur = redis.call('HGET', KEYS[i], 'index')
+	local prev = ARGV[3*i-2]
+	if prev == '' then
+		if cur then
+			return {-1, i}
+		end
+	else
+		if not cur then
+			return {-2, i}
+		end
+		if cur ~= prev then
+			return {-1, i}
+		end
+	end
+end
+local res = {}
+for i = 1, n do
+	if ARGV[3*i-1] == 'del' then
+		redis.call('DEL', KEYS[i])
+		res[i] = 0
+	else
+		local idx = redis.call('INCR', KEYS[n+1])
+		redis.call('HMSET', KEYS[i], 'value', ARGV[3*i], 'index', idx)
+		redis.call('PERSIST', KEYS[i])
+		res[i] = idx
+	end
+end
+return res
+`
+
+// Redis is the receiver type for the Store interface
+type Redis struct {
+	sync.Mutex
+	addrs   []string
+	options *store.Config
+	// c is the connection of the commands, dialed on demand, the watches
+	// have their own
+	c *conn
+}
+
+// Register registers redis to libkv
+func Register() {
+	libkv.AddStore(REDIS, New)
+}
+
+// New creates a new Redis client given a list of endpoints, tried in order,
+// and an optional configuration
+func New(endpoints []string, options *store.Config) (store.Store, error) {
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	if options == nil {
+		options = &store.Config{}
+	}
+	s := &Redis{addrs: endpoints, options: options}
+
+	if err := s.enableNotifications(); err != nil {
+		if _, ok := err.(respError); !ok {
+			s.Close()
+			return nil, err
+		}
+		// CONFIG is often disabled on the managed servers, the
+		// notifications may be enabled in their configuration
+		logrus.Warnf("Could not enable the keyspace notifications of the redis store, watches need notify-keyspace-events %s: %v", notifyFlags, err)
+	}
+	return s, nil
+}
+
+// enableNotifications adds the notification classes needed by the watches
+// to the ones configured on the server
+func (s *Redis) enableNotifications() error {
+	rep, err := s.do("CONFIG", "GET", "notify-keyspace-events")
+	if err != nil {
+		return err
+	}
+	var flags string
+	if values, ok := rep.([]interface{}); ok && len(values) == 2 {
+		if b, ok := values[1].([]byte); ok {
+			flags = string(b)
+		}
+	}
+	missing := missingFlags(flags)
+	if missing == "" {
+		return nil
+	}
+	_, err = s.do("CONFIG", "SET", "notify-keyspace-events", flags+missing)
+	return err
+}
+
+// missingFlags returns the classes of notifyFlags not enabled by flags
+func missingFlags(flags string) string {
+	var missing string
+	for _, f := range notifyFlags {
+		if strings.ContainsRune(flags, f) {
+			continue
+		}
+		// A is the alias of all the classes
+		if f != 'K' && strings.ContainsRune(flags, 'A') {
+			continue
+		}
+		missing += string(f)
+	}
+	return missing
+}
+
+func (s *Redis) dial() (*conn, error) {
+	timeout := s.options.ConnectionTimeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	var lastErr error
+	for _, addr := range s.addrs {
+		c, err := dial(addr, timeout, s.options.TLS)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if s.options.Password != "" {
+			args := []interface{}{"AUTH"}
+			if s.options.Username != "" {
+				args = append(args, s.options.Username)
+			}
+			if _, err := c.do(append(args, s.options.Password)...); err != nil {
+				c.Close()
+				return nil, fmt.Errorf("redis authentication failed: %v", err)
+			}
+		}
+		return c, nil
+	}
+	return nil, lastErr
+}
+
+// do runs a command on the shared connection, which is dialed again after
+// a failure. The command failing on a connection the server closed while
+// idle, without a reply, is run again on a new connection.
+func (s *Redis) do(args ...interface{}) (interface{}, error) {
+	rep, dialed, err := s.doOnce(args...)
+	if err != nil && !dialed && closedByServer(err) {
+		logrus.Debugf("Reconnecting to the redis store: %v", err)
+		rep, _, err = s.doOnce(args...)
+	}
+	return rep, err
+}
+
+// doOnce runs a command on the shared connection, dialing it when there is
+// none, and returns whether it was dialed for the command
+func (s *Redis) doOnce(args ...interface{}) (interface{}, bool, error) {
+	s.Lock()
+	c := s.c
+	dialed := c == nil
+	if dialed {
+		var err error
+		if c, err = s.dial(); err != nil {
+			s.Unlock()
+			return nil, true, err
+		}
+		s.c = c
+	}
+	s.Unlock()
+
+	rep, err := c.do(args...)
+	if err != nil {
+		if _, ok := err.(respError); !ok {
+			s.Lock()
+			if s.c == c {
+				s.c = nil
+			}
+			s.Unlock()
+			c.Close()
+		}
+	}
+	return rep, dialed, err
+}
+
+// closedByServer returns whether the error is the one of a connection the
+// server closed, the command having no reply
+func closedByServer(err error) bool {
+	return err == io.EOF || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// normalize the key for usage in Redis, without its leading and trailing
+// slashes
+func normalize(key string) string {
+	return strings.Trim(store.Normalize(key), "/")
+}
+
+// escapeGlob escapes the glob special characters of the MATCH and
+// PSUBSCRIBE patterns
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func keyspaceChannel(key string) string {
+	return "__keyspace@0__:" + key
+}
+
+// Get the value at "key", returns the last modified index
+// to use in conjunction to Atomic calls
+func (s *Redis) Get(key string) (*store.KVPair, error) {
+	key = normalize(key)
+	rep, err := s.do("HMGET", key, "value", "index")
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := rep.([]interface{})
+	if !ok || len(fields) != 2 {
+		return nil, fmt.Errorf("unexpected reply %v for key %s", rep, key)
+	}
+	value, _ := fields[0].([]byte)
+	index, _ := fields[1].([]byte)
+	if value == nil || index == nil {
+		return nil, store.ErrKeyNotFound
+	}
+	lastIndex, err := strconv.ParseUint(string(index), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid index of key %s: %v", key, err)
+	}
+	return &store.KVPair{Key: key, Value: value, LastIndex: lastIndex}, nil
+}
+
+func ttlMillis(options *store.WriteOptions) int64 {
+	if options == nil {
+		return 0
+	}
+	return int64(options.TTL / time.Millisecond)
+}
+
+// Put a value at "key"
+func (s *Redis) Put(key string, value []byte, options *store.WriteOptions) error {
+	_, err := s.do("EVAL", putScript, 2, normalize(key), indexKey, value, ttlMillis(options))
+	return err
+}
+
+// Delete a value at "key"
+func (s *Redis) Delete(key string) error {
+	rep, err := s.do("DEL", normalize(key))
+	if err != nil {
+		return err
+	}
+	if n, _ := rep.(int64); n == 0 {
+		return store.ErrKeyNotFound
+	}
+	return nil
+}
+
+// Exists checks if the key exists inside the store
+func (s *Redis) Exists(key string) (bool, error) {
+	rep, err := s.do("EXISTS", normalize(key))
+	if err != nil {
+		return false, err
+	}
+	n, _ := rep.(int64)
+	return n > 0, nil
+}
+
+// scan returns the key of the directory and the keys under it. The MATCH
+// pattern also matches the keys the directory name is the prefix of, as
+// dir for dir2/key, they are left out.
+func (s *Redis) scan(prefix string) ([]string, error) {
+	var keys []string
+	cursor := "0"
+	pattern := escapeGlob(prefix) + "*"
+	for {
+		rep, err := s.do("SCAN", cursor, "MATCH", pattern, "COUNT", scanCount)
+		if err != nil {
+			return nil, err
+		}
+		res, ok := rep.([]interface{})
+		if !ok || len(res) != 2 {
+			return nil, fmt.Errorf("unexpected scan reply %v", rep)
+		}
+		next, _ := res[0].([]byte)
+		batch, _ := res[1].([]interface{})
+		for _, k := range batch {
+			if b, ok := k.([]byte); ok && inDirectory(string(b), prefix) {
+				keys = append(keys, string(b))
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return keys, nil
+		}
+	}
+}
+
+// inDirectory returns whether the key is the directory or under it
+func inDirectory(key, directory string) bool {
+	if key == indexKey {
+		return false
+	}
+	return directory == "" || key == directory || strings.HasPrefix(key, strings.TrimSuffix(directory, "/")+"/")
+}
+
+// List child nodes of a given directory
+func (s *Redis) List(directory string) ([]*store.KVPair, error) {
+	prefix := normalize(directory)
+	keys, err := s.scan(prefix)
+	if err != nil {
+		return nil, err
+	}
+	kv := []*store.KVPair{}
+	for _, k := range keys {
+		if k == prefix {
+			continue
+		}
+		pair, err := s.Get(k)
+		if err == store.ErrKeyNotFound {
+			// deleted or expired in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		kv = append(kv, pair)
+	}
+	if len(kv) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	return kv, nil
+}
+
+// DeleteTree deletes a range of keys under a given directory
+func (s *Redis) DeleteTree(directory string) error {
+	keys, err := s.scan(normalize(directory))
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, err := s.do("DEL", k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AtomicPut puts a value at "key" if the key has not been
+// modified in the meantime, throws an error if this is the case
+func (s *Redis) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	key = normalize(key)
+	var prevIndex string
+	if previous != nil {
+		prevIndex = strconv.FormatUint(previous.LastIndex, 10)
+	}
+	rep, err := s.do("EVAL", atomicPutScript, 2, key, indexKey, value, ttlMillis(options), prevIndex)
+	if err != nil {
+		return false, nil, err
+	}
+	idx, _ := rep.(int64)
+	switch {
+	case idx == -1 && previous == nil:
+		return false, nil, store.ErrKeyExists
+	case idx == -1:
+		return false, nil, store.ErrKeyModified
+	case idx == -2:
+		return false, nil, store.ErrKeyNotFound
+	}
+	return true, &store.KVPair{Key: key, Value: value, LastIndex: uint64(idx)}, nil
+}
+
+// AtomicDelete deletes a value at "key" if the key
+// has not been modified in the meantime, throws an
+// error if this is the case
+func (s *Redis) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	rep, err := s.do("EVAL", atomicDeleteScript, 1, normalize(key), strconv.FormatUint(previous.LastIndex, 10))
+	if err != nil {
+		return false, err
+	}
+	switch n, _ := rep.(int64); n {
+	case -1:
+		return false, store.ErrKeyModified
+	case -2:
+		return false, store.ErrKeyNotFound
+	}
+	return true, nil
+}
+
+// AtomicTxn applies the compare-and-set of every key in one script, either
+// all the keys are written or none
+func (s *Redis) AtomicTxn(ops []*datastore.TxnOp) ([]*store.KVPair, error) {
+	args := []interface{}{"EVAL", atomicTxnScript, len(ops) + 1}
+	for _, op := range ops {
+		args = append(args, normalize(op.Key))
+	}
+	args = append(args, indexKey)
+	for _, op := range ops {
+		var prevIndex string
+		if op.Previous != nil {
+			prevIndex = strconv.FormatUint(op.Previous.LastIndex, 10)
+		}
+		cmd, value := "put", op.Value
+		if value == nil {
+			cmd, value = "del", []byte{}
+		}
+		args = append(args, prevIndex, cmd, value)
+	}
+	rep, err := s.do(args...)
+	if err != nil {
+		return nil, err
+	}
+	res, ok := rep.([]interface{})
+	if !ok || len(res) == 0 {
+		return nil, fmt.Errorf("unexpected reply to the transaction: %v", rep)
+	}
+	if code, _ := res[0].(int64); code < 0 && len(res) == 2 {
+		i, _ := res[1].(int64)
+		if i < 1 || int(i) > len(ops) {
+			return nil, fmt.Errorf("unexpected reply to the transaction: %v", rep)
+		}
+		switch {
+		case code == -1 && ops[i-1].Previous == nil:
+			return nil, store.ErrKeyExists
+		case code == -1:
+			return nil, store.ErrKeyModified
+		}
+		return nil, store.ErrKeyNotFound
+	}
+	if len(res) != len(ops) {
+		return nil, fmt.Errorf("unexpected reply to the transaction: %v", rep)
+	}
+	pairs := make([]*store.KVPair, len(ops))
+	for i, op := range ops {
+		if op.Value == nil {
+			continue
+		}
+		idx, _ := res[i].(int64)
+		pairs[i] = &store.KVPair{Key: normalize(op.Key), Value: op.Value, LastIndex: uint64(idx)}
+	}
+	return pairs, nil
+}
+
+// subscribe opens a connection subscribed to a keyspace channel, or
+// pattern with PSUBSCRIBE, and returns it with the channel signaling its
+// notifications. The channel is closed when the connection is.
+func (s *Redis) subscribe(cmd, channel string) (*conn, <-chan struct{}, error) {
+	c, err := s.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := c.do(cmd, channel); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		for {
+			rep, err := c.receive()
+			if err != nil {
+				return
+			}
+			msg, ok := rep.([]interface{})
+			if !ok || len(msg) == 0 {
+				continue
+			}
+			if kind, _ := msg[0].([]byte); string(kind) != "message" && string(kind) != "pmessage" {
+				continue
+			}
+			// the watchers read the current state, the pending
+			// notifications coalesce
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return c, events, nil
+}
+
+// Watch for changes on a "key"
+// It returns a channel that will receive changes or pass
+// on errors. Upon creation, the current value will first
+// be sent to the channel. Providing a non-nil stopCh can
+// be used to stop watching.
+func (s *Redis) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	key = normalize(key)
+	c, events, err := s.subscribe("SUBSCRIBE", keyspaceChannel(key))
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan *store.KVPair)
+	go func() {
+		defer close(watchCh)
+		defer c.Close()
+		for {
+			pair, err := s.Get(key)
+			switch err {
+			case nil:
+				select {
+				case watchCh <- pair:
+				case <-stopCh:
+					return
+				}
+			case store.ErrKeyNotFound:
+			default:
+				return
+			}
+			select {
+			case <-stopCh:
+				return
+			case _, ok := <-events:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	return watchCh, nil
+}
+
+// WatchTree watches for changes on a "directory"
+// It returns a channel that will receive changes or pass
+// on errors. Upon creating a watch, the current childs values
+// will be sent to the channel. Providing a non-nil stopCh can
+// be used to stop watching.
+func (s *Redis) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	directory = normalize(directory)
+	c, events, err := s.subscribe("PSUBSCRIBE", keyspaceChannel(escapeGlob(directory))+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	watchCh := make(chan []*store.KVPair)
+	go func() {
+		defer close(watchCh)
+		defer c.Close()
+		for {
+			pairs, err := s.List(directory)
+			switch err {
+			case nil:
+			case store.ErrKeyNotFound:
+				pairs = []*store.KVPair{}
+			default:
+				return
+			}
+			select {
+			case watchCh <- pairs:
+			case <-stopCh:
+				return
+			}
+			select {
+			case <-stopCh:
+				return
+			case _, ok := <-events:
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+	return watchCh, nil
+}
+
+// NewLock is not supported by the redis store
+func (s *Redis) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// Close the store connection
+func (s *Redis) Close() {
+	s.Lock()
+	defer s.Unlock()
+	if s.c != nil {
+		s.c.Close()
+		s.c = nil
+	}
+}
@@ -0,0 +1,467 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestRESP(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+	defer server.Close()
+
+	go func() {
+		buf := make([]byte, 256)
+		n, _ := server.Read(buf)
+		server.Write(buf[:n])
+		server.Write([]byte("*3\r\n$5\r\nvalue\r\n$-1\r\n:42\r\n"))
+		server.Write([]byte("-ERR unknown command\r\n"))
+	}()
+
+	// the server echoes the command first
+	if err := c.send("HMGET", "a/b", "value", 7); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := c.receive()
+	assert.NilError(t, err)
+	cmd, ok := rep.([]interface{})
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, cmd, []interface{}{[]byte("HMGET"), []byte("a/b"), []byte("value"), []byte("7")})
+
+	rep, err = c.receive()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, rep, []interface{}{[]byte("value"), []byte(nil), int64(42)})
+
+	rep, err = c.receive()
+	assert.NilError(t, err)
+	assert.Equal(t, rep, respError("ERR unknown command"))
+}
+
+func TestMissingFlags(t *testing.T) {
+	assert.Check(t, is.Equal(missingFlags(""), "Kghx"))
+	assert.Check(t, is.Equal(missingFlags("Ex"), "Kgh"))
+	assert.Check(t, is.Equal(missingFlags("AE"), "K"))
+	assert.Check(t, is.Equal(missingFlags("KA"), ""))
+}
+
+func TestEscapeGlob(t *testing.T) {
+	assert.Check(t, is.Equal(escapeGlob("docker/network/v1.0/"), "docker/network/v1.0/"))
+	assert.Check(t, is.Equal(escapeGlob(`a*b?[c]\`), `a\*b\?\[c\]\\`))
+}
+
+// fakeServer is a Redis server keeping the keys of the store in memory. It
+// runs the scripts of the store, recognized by their source, and notifies
+// the keyspace subscriptions.
+type fakeServer struct {
+	sync.Mutex
+	l     net.Listener
+	keys  map[string]fakeKey
+	index int64
+	conns map[net.Conn]*sync.Mutex
+	subs  map[net.Conn][]string
+	// hang leaves the commands without reply
+	hang bool
+}
+
+type fakeKey struct {
+	value []byte
+	index int64
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	f := &fakeServer{l: l, keys: map[string]fakeKey{}, conns: map[net.Conn]*sync.Mutex{}, subs: map[net.Conn][]string{}}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			f.Lock()
+			f.conns[c] = &sync.Mutex{}
+			f.Unlock()
+			go f.serve(c)
+		}
+	}()
+	return f
+}
+
+func (f *fakeServer) addr() string {
+	return f.l.Addr().String()
+}
+
+func (f *fakeServer) close() {
+	f.l.Close()
+	f.dropConns()
+}
+
+// dropConns closes the connections of the clients
+func (f *fakeServer) dropConns() {
+	f.Lock()
+	defer f.Unlock()
+	for c := range f.conns {
+		c.Close()
+	}
+}
+
+func (f *fakeServer) setHang(hang bool) {
+	f.Lock()
+	f.hang = hang
+	f.Unlock()
+}
+
+func (f *fakeServer) serve(c net.Conn) {
+	defer func() {
+		f.Lock()
+		delete(f.conns, c)
+		delete(f.subs, c)
+		f.Unlock()
+		c.Close()
+	}()
+	rc := newConn(c)
+	for {
+		rep, err := rc.receive()
+		if err != nil {
+			return
+		}
+		cmd, _ := rep.([]interface{})
+		args := make([]string, len(cmd))
+		for i, a := range cmd {
+			b, _ := a.([]byte)
+			args[i] = string(b)
+		}
+		f.Lock()
+		hang := f.hang
+		f.Unlock()
+		if hang || len(args) == 0 {
+			continue
+		}
+		f.reply(c, f.run(c, args))
+	}
+}
+
+func (f *fakeServer) reply(c net.Conn, rep interface{}) {
+	f.Lock()
+	mu := f.conns[c]
+	f.Unlock()
+	if mu == nil {
+		return
+	}
+	var b bytes.Buffer
+	writeReply(&b, rep)
+	mu.Lock()
+	c.Write(b.Bytes())
+	mu.Unlock()
+}
+
+func writeReply(b *bytes.Buffer, rep interface{}) {
+	switch v := rep.(type) {
+	case string:
+		fmt.Fprintf(b, "+%s\r\n", v)
+	case respError:
+		fmt.Fprintf(b, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(b, ":%d\r\n", v)
+	case []byte:
+		if v == nil {
+			b.WriteString("$-1\r\n")
+			return
+		}
+		fmt.Fprintf(b, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(b, "*%d\r\n", len(v))
+		for _, e := range v {
+			writeReply(b, e)
+		}
+	}
+}
+
+func (f *fakeServer) run(c net.Conn, args []string) interface{} {
+	f.Lock()
+	defer f.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "CONFIG":
+		if strings.ToUpper(args[1]) == "GET" {
+			return []interface{}{[]byte(args[2]), []byte("")}
+		}
+		return "OK"
+	case "HMGET":
+		k, ok := f.keys[args[1]]
+		if !ok {
+			return []interface{}{[]byte(nil), []byte(nil)}
+		}
+		return []interface{}{k.value, []byte(strconv.FormatInt(k.index, 10))}
+	case "EXISTS":
+		if _, ok := f.keys[args[1]]; ok {
+			return int64(1)
+		}
+		return int64(0)
+	case "DEL":
+		if _, ok := f.keys[args[1]]; !ok {
+			return int64(0)
+		}
+		f.del(args[1])
+		return int64(1)
+	case "SCAN":
+		prefix := strings.Replace(strings.TrimSuffix(args[3], "*"), `\`, "", -1)
+		keys := []interface{}{}
+		for k := range f.keys {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, []byte(k))
+			}
+		}
+		return []interface{}{[]byte("0"), keys}
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		f.subs[c] = append(f.subs[c], args[1])
+		return []interface{}{[]byte(strings.ToLower(args[0])), []byte(args[1]), int64(len(f.subs[c]))}
+	case "EVAL":
+		return f.eval(args[1], args[3:])
+	}
+	return respError("ERR unknown command " + args[0])
+}
+
+// eval runs the script of the store, the keys of the scripts first
+func (f *fakeServer) eval(script string, args []string) interface{} {
+	switch script {
+	case putScript:
+		return f.set(args[0], args[2])
+	case atomicPutScript:
+		cur, ok := f.keys[args[0]]
+		switch {
+		case args[4] == "" && ok:
+			return int64(-1)
+		case args[4] != "" && !ok:
+			return int64(-2)
+		case args[4] != "" && strconv.FormatInt(cur.index, 10) != args[4]:
+			return int64(-1)
+		}
+		return f.set(args[0], args[2])
+	case atomicDeleteScript:
+		cur, ok := f.keys[args[0]]
+		switch {
+		case !ok:
+			return int64(-2)
+		case strconv.FormatInt(cur.index, 10) != args[1]:
+			return int64(-1)
+		}
+		f.del(args[0])
+		return int64(1)
+	}
+	return respError("ERR unknown script")
+}
+
+func (f *fakeServer) set(key, value string) int64 {
+	f.index++
+	f.keys[key] = fakeKey{value: []byte(value), index: f.index}
+	f.notify(key, "hset")
+	return f.index
+}
+
+func (f *fakeServer) del(key string) {
+	delete(f.keys, key)
+	f.notify(key, "del")
+}
+
+// notify sends the keyspace notification of the key to the subscribers,
+// the lock being held
+func (f *fakeServer) notify(key, event string) {
+	channel := keyspaceChannel(key)
+	for c, subs := range f.subs {
+		for _, sub := range subs {
+			var msg []interface{}
+			switch {
+			case sub == channel:
+				msg = []interface{}{[]byte("message"), []byte(channel), []byte(event)}
+			case strings.HasSuffix(sub, "*") && strings.HasPrefix(channel, strings.Replace(strings.TrimSuffix(sub, "*"), `\`, "", -1)):
+				msg = []interface{}{[]byte("pmessage"), []byte(sub), []byte(channel), []byte(event)}
+			default:
+				continue
+			}
+			var b bytes.Buffer
+			writeReply(&b, msg)
+			mu := f.conns[c]
+			go func(c net.Conn) {
+				mu.Lock()
+				c.Write(b.Bytes())
+				mu.Unlock()
+			}(c)
+		}
+	}
+}
+
+func newTestStore(t *testing.T, f *fakeServer, options *store.Config) *Redis {
+	s, err := New([]string{f.addr()}, options)
+	assert.NilError(t, err)
+	return s.(*Redis)
+}
+
+func TestStoreGetPut(t *testing.T) {
+	f := newFakeServer(t)
+	defer f.close()
+	s := newTestStore(t, f, nil)
+	defer s.Close()
+
+	_, err := s.Get("/docker/network/v1.0/network/n1")
+	assert.Check(t, is.Equal(err, store.ErrKeyNotFound))
+
+	assert.NilError(t, s.Put("/docker/network/v1.0/network/n1", []byte("v1"), nil))
+	pair, err := s.Get("/docker/network/v1.0/network/n1")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(pair.Key, "docker/network/v1.0/network/n1"))
+	assert.Check(t, is.Equal(string(pair.Value), "v1"))
+	assert.Check(t, pair.LastIndex > 0)
+
+	ok, err := s.Exists("docker/network/v1.0/network/n1")
+	assert.NilError(t, err)
+	assert.Check(t, ok)
+
+	assert.NilError(t, s.Delete("docker/network/v1.0/network/n1"))
+	assert.Check(t, is.Equal(s.Delete("docker/network/v1.0/network/n1"), store.ErrKeyNotFound))
+}
+
+func TestStoreAtomicPut(t *testing.T) {
+	f := newFakeServer(t)
+	defer f.close()
+	s := newTestStore(t, f, nil)
+	defer s.Close()
+
+	ok, pair, err := s.AtomicPut("a/key", []byte("v1"), nil, nil)
+	assert.NilError(t, err)
+	assert.Check(t, ok)
+
+	_, _, err = s.AtomicPut("a/key", []byte("v2"), nil, nil)
+	assert.Check(t, is.Equal(err, store.ErrKeyExists))
+
+	ok, next, err := s.AtomicPut("a/key", []byte("v2"), pair, nil)
+	assert.NilError(t, err)
+	assert.Check(t, ok)
+	assert.Check(t, next.LastIndex > pair.LastIndex)
+
+	// the previous pair is stale
+	_, _, err = s.AtomicPut("a/key", []byte("v3"), pair, nil)
+	assert.Check(t, is.Equal(err, store.ErrKeyModified))
+	_, err = s.AtomicDelete("a/key", pair)
+	assert.Check(t, is.Equal(err, store.ErrKeyModified))
+
+	ok, err = s.AtomicDelete("a/key", next)
+	assert.NilError(t, err)
+	assert.Check(t, ok)
+	_, _, err = s.AtomicPut("a/key", []byte("v3"), next, nil)
+	assert.Check(t, is.Equal(err, store.ErrKeyNotFound))
+}
+
+func TestStoreList(t *testing.T) {
+	f := newFakeServer(t)
+	defer f.close()
+	s := newTestStore(t, f, nil)
+	defer s.Close()
+
+	for _, k := range []string{"net/n1", "net/n1/ep1", "net/n1/ep2", "net/n10/ep1"} {
+		assert.NilError(t, s.Put(k, []byte(k), nil))
+	}
+
+	// the keys of net/n10 share the prefix of the directory, not the
+	// directory
+	pairs, err := s.List("net/n1")
+	assert.NilError(t, err)
+	var keys []string
+	for _, p := range pairs {
+		keys = append(keys, p.Key)
+	}
+	sort.Strings(keys)
+	assert.Check(t, is.DeepEqual(keys, []string{"net/n1/ep1", "net/n1/ep2"}))
+
+	assert.NilError(t, s.DeleteTree("net/n1"))
+	_, err = s.List("net/n1")
+	assert.Check(t, is.Equal(err, store.ErrKeyNotFound))
+	ok, err := s.Exists("net/n1")
+	assert.NilError(t, err)
+	assert.Check(t, !ok)
+	ok, err = s.Exists("net/n10/ep1")
+	assert.NilError(t, err)
+	assert.Check(t, ok)
+}
+
+func TestStoreWatch(t *testing.T) {
+	f := newFakeServer(t)
+	defer f.close()
+	s := newTestStore(t, f, nil)
+	defer s.Close()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	watchCh, err := s.Watch("w/key", stopCh)
+	assert.NilError(t, err)
+	treeCh, err := s.WatchTree("w", stopCh)
+	assert.NilError(t, err)
+
+	// the tree is empty
+	select {
+	case pairs := <-treeCh:
+		assert.Check(t, is.Len(pairs, 0))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the current tree")
+	}
+
+	for _, v := range []string{"v1", "v2"} {
+		assert.NilError(t, s.Put("w/key", []byte(v), nil))
+		select {
+		case pair := <-watchCh:
+			assert.Check(t, is.Equal(string(pair.Value), v))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for the watch of %s", v)
+		}
+		select {
+		case pairs := <-treeCh:
+			assert.Assert(t, is.Len(pairs, 1))
+			assert.Check(t, is.Equal(string(pairs[0].Value), v))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for the tree watch of %s", v)
+		}
+	}
+}
+
+func TestStoreReconnect(t *testing.T) {
+	f := newFakeServer(t)
+	defer f.close()
+	s := newTestStore(t, f, nil)
+	defer s.Close()
+
+	assert.NilError(t, s.Put("a/key", []byte("v1"), nil))
+	// the server closes the idle connection
+	f.dropConns()
+	pair, err := s.Get("a/key")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(pair.Value), "v1"))
+}
+
+func TestStoreTimeout(t *testing.T) {
+	f := newFakeServer(t)
+	defer f.close()
+	s := newTestStore(t, f, &store.Config{ConnectionTimeout: 100 * time.Millisecond})
+	defer s.Close()
+
+	f.setHang(true)
+	start := time.Now()
+	_, err := s.Get("a/key")
+	assert.Check(t, err != nil)
+	assert.Check(t, time.Since(start) < 5*time.Second)
+
+	// the next request has a new connection
+	f.setHang(false)
+	_, err = s.Get("a/key")
+	assert.Check(t, is.Equal(err, store.ErrKeyNotFound))
+}
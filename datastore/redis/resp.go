@@ -0,0 +1,169 @@
+package redis
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// respError is an error reply of the server
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// conn is a connection to a Redis server speaking the RESP protocol. The
+// replies are decoded to string (status), respError, int64, []byte (bulk
+// string, nil when missing) and []interface{} (array, nil when missing).
+type conn struct {
+	sync.Mutex
+	c net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+	// timeout is the deadline of a command and its reply, none when zero
+	timeout time.Duration
+}
+
+// dial connects to the server, timeout bounding the connection and each
+// command
+func dial(addr string, timeout time.Duration, tlsConfig *tls.Config) (*conn, error) {
+	d := &net.Dialer{Timeout: timeout}
+	var (
+		c   net.Conn
+		err error
+	)
+	if tlsConfig != nil {
+		c, err = tls.DialWithDialer(d, "tcp", addr, tlsConfig)
+	} else {
+		c, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := newConn(c)
+	rc.timeout = timeout
+	return rc, nil
+}
+
+func newConn(c net.Conn) *conn {
+	return &conn{c: c, r: bufio.NewReader(c), w: bufio.NewWriter(c)}
+}
+
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+// do sends a command and returns its reply, failing past the timeout of
+// the connection. An error reply is returned as the error.
+func (c *conn) do(args ...interface{}) (interface{}, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.timeout > 0 {
+		c.c.SetDeadline(time.Now().Add(c.timeout))
+		// the subscriptions wait for their messages without deadline
+		defer c.c.SetDeadline(time.Time{})
+	}
+	if err := c.send(args...); err != nil {
+		return nil, err
+	}
+	rep, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := rep.(respError); ok {
+		return nil, e
+	}
+	return rep, nil
+}
+
+// send writes a command as an array of bulk strings
+func (c *conn) send(args ...interface{}) error {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		var b []byte
+		switch v := a.(type) {
+		case string:
+			b = []byte(v)
+		case []byte:
+			b = v
+		case int:
+			b = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			b = strconv.AppendInt(nil, v, 10)
+		case uint64:
+			b = strconv.AppendUint(nil, v, 10)
+		default:
+			return fmt.Errorf("unsupported argument type %T", a)
+		}
+		fmt.Fprintf(c.w, "$%d\r\n", len(b))
+		c.w.Write(b)
+		c.w.WriteString("\r\n")
+	}
+	return c.w.Flush()
+}
+
+// receive reads a reply
+func (c *conn) receive() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(string(line[1:]), 10, 64)
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return []byte(nil), nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return []interface{}(nil), nil
+		}
+		res := make([]interface{}, n)
+		for i := range res {
+			if res[i], err = c.receive(); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+func (c *conn) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
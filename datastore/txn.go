@@ -0,0 +1,264 @@
+package datastore
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// TxOp is an operation of a transaction over KV objects
+type TxOp struct {
+	Object KVObject
+	// Delete deletes the object, else the object is put
+	Delete bool
+}
+
+// TxPut returns the operation atomically putting the object
+func TxPut(o KVObject) TxOp {
+	return TxOp{Object: o}
+}
+
+// TxDelete returns the operation atomically deleting the object
+func TxDelete(o KVObject) TxOp {
+	return TxOp{Object: o, Delete: true}
+}
+
+// TxnOp is a compare-and-set over a key of a transaction. A nil Previous
+// requires the key to be absent, else the key must be at the index of
+// Previous. A nil Value deletes the key.
+type TxnOp struct {
+	Key      string
+	Value    []byte
+	Previous *store.KVPair
+}
+
+// TxnStore is implemented by the KV stores committing the compare-and-set
+// over multiple keys atomically. AtomicTxn returns the new pairs, nil for
+// the deleted keys, or store.ErrCallNotSupported when the transactions are
+// not available.
+type TxnStore interface {
+	AtomicTxn(ops []*TxnOp) ([]*store.KVPair, error)
+}
+
+// boltTxnIndexBit marks the indexes given by the boltdb transactions, apart
+// from the ones given by the libkv store counter
+const boltTxnIndexBit = 1 << 63
+
+// AtomicTxn puts and deletes the objects atomically: either all the
+// operations are done or none, each being a compare-and-set like
+// PutObjectAtomic and DeleteObjectAtomic. The boltdb stores and the stores
+// implementing TxnStore commit in one transaction, the operations on the
+// other stores are done in order and undone on a failure, which a crash can
+// interrupt.
+func (ds *datastore) AtomicTxn(ops ...TxOp) error {
+	if ds.sequential {
+		ds.Lock()
+		defer ds.Unlock()
+	}
+
+	var (
+		txnOps  []*TxnOp
+		objects []TxOp
+	)
+	seen := make(map[string]bool)
+	for _, op := range ops {
+		if op.Object == nil {
+			return types.BadRequestErrorf("invalid KV Object : nil")
+		}
+		key := Key(op.Object.Key()...)
+		if seen[key] {
+			return types.BadRequestErrorf("key %s more than once in the transaction", key)
+		}
+		seen[key] = true
+		if op.Object.Skip() {
+			continue
+		}
+		t := &TxnOp{Key: key}
+		if op.Delete || op.Object.Exists() {
+			t.Previous = &store.KVPair{Key: key, LastIndex: op.Object.Index()}
+		}
+		if !op.Delete {
+			if t.Value = op.Object.Value(); t.Value == nil {
+				return types.BadRequestErrorf("invalid KV Object with a nil Value for key %s", key)
+			}
+		}
+		txnOps = append(txnOps, t)
+		objects = append(objects, op)
+	}
+
+	if len(txnOps) > 0 {
+		pairs, err := ds.commitTxn(txnOps)
+		if err != nil {
+			if err == store.ErrKeyExists {
+				return ErrKeyModified
+			}
+			return err
+		}
+		for i, op := range objects {
+			if !op.Delete {
+				op.Object.SetIndex(pairs[i].LastIndex)
+			}
+		}
+	}
+
+	if ds.cache == nil {
+		return nil
+	}
+	for _, op := range ops {
+		// the objects which are not persisted are sequenced by the cache
+		var err error
+		if op.Delete {
+			err = ds.cache.del(op.Object, op.Object.Skip())
+		} else {
+			err = ds.cache.add(op.Object, op.Object.Skip())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *datastore) commitTxn(ops []*TxnOp) (pairs []*store.KVPair, err error) {
+	if b, ok := ds.backend().(*boltdb.BoltDB); ok && ds.boltPath != "" && !b.PersistConnection {
+		defer ds.metrics.observe("txn", ops[0].Key, time.Now(), &err)
+		return ds.boltTxn(ops)
+	}
+	if ts, ok := ds.store.(TxnStore); ok {
+		pairs, err := ts.AtomicTxn(ops)
+		if err != store.ErrCallNotSupported {
+			return pairs, err
+		}
+	}
+	return sequentialTxn(ds.store, ops)
+}
+
+// boltTxn commits the operations in a boltdb transaction, writing the
+// values in the format of the libkv store
+func (ds *datastore) boltTxn(ops []*TxnOp) ([]*store.KVPair, error) {
+	b, err := ds.boltStore()
+	if err != nil {
+		return nil, err
+	}
+	defer b.Unlock()
+
+	db, err := ds.openBolt(ds.boltPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	pairs := make([]*store.KVPair, len(ops))
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(ds.boltBucket)
+		if err != nil {
+			return err
+		}
+		for i, op := range ops {
+			val := bucket.Get([]byte(op.Key))
+			if op.Previous == nil {
+				if len(val) != 0 {
+					return store.ErrKeyExists
+				}
+			} else {
+				if len(val) < boltMetadataLen {
+					return store.ErrKeyNotFound
+				}
+				if binary.LittleEndian.Uint64(val[:boltMetadataLen]) != op.Previous.LastIndex {
+					return store.ErrKeyModified
+				}
+			}
+			if op.Value == nil {
+				if err := bucket.Delete([]byte(op.Key)); err != nil {
+					return err
+				}
+				continue
+			}
+			value := op.Value
+			if ds.cipher != nil {
+				if value, err = ds.cipher.seal(op.Key, value); err != nil {
+					return err
+				}
+			}
+			index := atomic.AddUint64(&ds.txnIndex, 1) | boltTxnIndexBit
+			dbval := make([]byte, boltMetadataLen, boltMetadataLen+len(value))
+			binary.LittleEndian.PutUint64(dbval, index)
+			if err := bucket.Put([]byte(op.Key), append(dbval, value...)); err != nil {
+				return err
+			}
+			pairs[i] = &store.KVPair{Key: op.Key, Value: op.Value, LastIndex: index}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// sequentialTxn applies the operations one by one and undoes the applied
+// ones on a failure
+func sequentialTxn(kv store.Store, ops []*TxnOp) ([]*store.KVPair, error) {
+	var undo []func() error
+	rollback := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err := undo[i](); err != nil {
+				logrus.Errorf("Failed to undo an operation of a failed transaction: %v", err)
+			}
+		}
+	}
+
+	pairs := make([]*store.KVPair, len(ops))
+	for i, op := range ops {
+		key := op.Key
+		var old *store.KVPair
+		if op.Previous != nil {
+			p, err := kv.Get(key)
+			if err == nil && p == nil {
+				err = store.ErrKeyNotFound
+			}
+			if err == nil && p.LastIndex != op.Previous.LastIndex {
+				err = store.ErrKeyModified
+			}
+			if err != nil {
+				rollback()
+				return nil, err
+			}
+			old = p
+		}
+
+		if op.Value == nil {
+			if _, err := kv.AtomicDelete(key, op.Previous); err != nil {
+				rollback()
+				return nil, err
+			}
+			undo = append(undo, func() error {
+				_, _, err := kv.AtomicPut(key, old.Value, nil, nil)
+				return err
+			})
+			continue
+		}
+
+		_, pair, err := kv.AtomicPut(key, op.Value, op.Previous, nil)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		pairs[i] = pair
+		undo = append(undo, func() error {
+			if old == nil {
+				_, err := kv.AtomicDelete(key, pair)
+				return err
+			}
+			_, _, err := kv.AtomicPut(key, old.Value, pair, nil)
+			return err
+		})
+	}
+	return pairs, nil
+}
@@ -0,0 +1,81 @@
+package datastore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestAtomicTxnBolt(t *testing.T) {
+	boltdb.Register()
+	dir, err := ioutil.TempDir("", "bolt-txn")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	ds, err := newClient(LocalScope, ScopeClientCfg{Provider: string(store.BOLTDB), Address: filepath.Join(dir, "local-kv.db"), Config: &store.Config{Bucket: "libnetwork"}}, false)
+	assert.NilError(t, err)
+	defer ds.Close()
+
+	o1 := dummyKVObject("1", true)
+	o2 := dummyKVObject("2", true)
+	assert.NilError(t, ds.AtomicTxn(TxPut(o1), TxPut(o2)))
+	assert.Check(t, o1.Exists())
+	assert.Check(t, o1.Index() != o2.Index())
+
+	// the libkv store reads the keys written by the transaction
+	pair, err := ds.KVStore().Get(Key(o1.Key()...))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(pair.LastIndex, o1.Index()))
+	assert.NilError(t, ds.PutObjectAtomic(o1))
+
+	// a conflict on a key leaves the other ones untouched
+	o1.Name = "updated"
+	stale := dummyKVObject("2", true)
+	err = ds.AtomicTxn(TxPut(o1), TxPut(stale))
+	assert.Check(t, is.Equal(err, ErrKeyModified))
+	got := dummyKVObject("1", true)
+	assert.NilError(t, ds.GetObject(Key(o1.Key()...), got))
+	assert.Check(t, is.Equal(got.Name, "testNw"))
+
+	err = ds.AtomicTxn(TxPut(o1), TxPut(o1))
+	assert.Check(t, err != nil)
+
+	assert.NilError(t, ds.AtomicTxn(TxDelete(o1), TxDelete(o2)))
+	_, err = ds.KVStore().Get(Key(o2.Key()...))
+	assert.Check(t, is.Equal(err, store.ErrKeyNotFound))
+}
+
+func TestAtomicTxnSequential(t *testing.T) {
+	ds := NewTestDataStore()
+
+	o1 := dummyKVObject("1", true)
+	assert.NilError(t, ds.PutObjectAtomic(o1))
+	o2 := dummyKVObject("2", true)
+	assert.NilError(t, ds.PutObjectAtomic(o2))
+
+	// the failure on the stale object undoes the first writes
+	o3 := dummyKVObject("3", true)
+	stale := dummyKVObject("1", true)
+	stale.SetIndex(o1.Index() + 1)
+	err := ds.AtomicTxn(TxPut(o3), TxDelete(o2), TxPut(stale))
+	assert.Check(t, err != nil)
+	kv := ds.KVStore()
+	pair, err := kv.Get(Key(o3.Key()...))
+	assert.NilError(t, err)
+	assert.Check(t, is.Nil(pair))
+	pair, err = kv.Get(Key(o2.Key()...))
+	assert.NilError(t, err)
+	assert.Check(t, pair != nil)
+
+	assert.NilError(t, ds.AtomicTxn(TxPut(o3), TxPut(o1)))
+	assert.Check(t, o3.Exists())
+	pair, err = kv.Get(Key(o1.Key()...))
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(pair.LastIndex, o1.Index()))
+}
@@ -0,0 +1,314 @@
+package datastore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+const defaultWatchRetryInterval = 5 * time.Second
+
+// errWatchLost is the error of a watch the backend stopped
+var errWatchLost = errors.New("watch lost")
+
+// WatchToken identifies a state of a watched key or tree. A watch resumed
+// with the token of the last event it delivered skips this state.
+type WatchToken string
+
+// WatchEvent is the state of a watched key or tree after a change, or the
+// error ending the watch. A missing key and an empty tree have no pairs.
+type WatchEvent struct {
+	Pairs []*store.KVPair
+	Token WatchToken
+	Err   error
+}
+
+// WatchOptions are the options of WatchKV
+type WatchOptions struct {
+	// Tree watches the keys under the key, else the key itself
+	Tree bool
+	// Resume is the token of the last state delivered by an earlier watch
+	Resume WatchToken
+	// Coalesce holds the events for this long, only the last state of the
+	// changes in between is delivered
+	Coalesce time.Duration
+	// RetryInterval is the time between the attempts to watch again after
+	// the backend lost the watch, 5 seconds when zero
+	RetryInterval time.Duration
+	// MaxRetries is the number of failed attempts in a row after which the
+	// watch ends with an error, zero retries forever
+	MaxRetries int
+	// PollInterval, when not zero, polls the backends which can't watch,
+	// else the watch on these backends ends with ErrCallNotSupported
+	PollInterval time.Duration
+	// Disconnected is called when the backend loses the watch
+	Disconnected func(error)
+	// Wake returns a channel whose closing makes a lost watch retry at
+	// once
+	Wake func() <-chan struct{}
+}
+
+// WatchKV watches a key, or a tree, of the KV store with the same semantics
+// on all the backends: the first event is the current state, unless it is
+// the state of the Resume token, and every event is the full state after a
+// change. The watch is restarted when the backend loses it, the changes
+// made meanwhile are delivered whatever the backend replays. The channel
+// is closed when stopCh is, or after an event with the error which ended
+// the watch.
+func WatchKV(kv store.Store, key string, opts WatchOptions, stopCh <-chan struct{}) <-chan *WatchEvent {
+	if opts.RetryInterval == 0 {
+		opts.RetryInterval = defaultWatchRetryInterval
+	}
+	w := &watcher{
+		kv:     kv,
+		key:    key,
+		opts:   opts,
+		stopCh: stopCh,
+		out:    make(chan *WatchEvent),
+		last:   opts.Resume,
+	}
+	go w.run()
+	return w.out
+}
+
+type watcher struct {
+	kv     store.Store
+	key    string
+	opts   WatchOptions
+	stopCh <-chan struct{}
+	out    chan *WatchEvent
+	// last is the token of the last delivered state, lastIndex the
+	// highest index it holds
+	last      WatchToken
+	lastIndex uint64
+}
+
+func (w *watcher) run() {
+	defer close(w.out)
+	var (
+		polling bool
+		retries int
+	)
+	for {
+		var (
+			connected bool
+			err       error
+		)
+		if polling {
+			connected, err = w.poll()
+		} else {
+			connected, err = w.watch()
+		}
+		if err == nil {
+			return
+		}
+		if err == store.ErrCallNotSupported && !polling {
+			if w.opts.PollInterval == 0 {
+				w.fail(err)
+				return
+			}
+			polling = true
+			continue
+		}
+
+		if w.opts.Disconnected != nil {
+			w.opts.Disconnected(err)
+		}
+		if connected {
+			retries = 0
+		}
+		retries++
+		if w.opts.MaxRetries > 0 && retries > w.opts.MaxRetries {
+			w.fail(fmt.Errorf("failed to watch %s after %d attempts: %v", w.key, retries, err))
+			return
+		}
+		if !w.wait(w.opts.RetryInterval) {
+			return
+		}
+	}
+}
+
+// watch runs a watch of the backend until it is lost, connected is true
+// once the state was read. A nil error means the watch was stopped.
+func (w *watcher) watch() (connected bool, err error) {
+	sCh := make(chan struct{})
+	defer close(sCh)
+
+	var (
+		keyCh  <-chan *store.KVPair
+		treeCh <-chan []*store.KVPair
+	)
+	if w.opts.Tree {
+		treeCh, err = w.kv.WatchTree(w.key, sCh)
+	} else {
+		keyCh, err = w.kv.Watch(w.key, sCh)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// the watch may start after changes the previous one missed
+	pairs, err := w.snapshot()
+	if err != nil {
+		return false, err
+	}
+
+	var (
+		pending *WatchEvent
+		hold    <-chan time.Time
+	)
+	setPending := func(ev *WatchEvent) {
+		if ev != nil && pending == nil && w.opts.Coalesce > 0 {
+			hold = time.After(w.opts.Coalesce)
+		}
+		if ev == nil {
+			hold = nil
+		}
+		pending = ev
+	}
+	setPending(w.event(pairs))
+
+	for {
+		var out chan<- *WatchEvent
+		if pending != nil && hold == nil {
+			out = w.out
+		}
+		select {
+		case <-w.stopCh:
+			return true, nil
+		case <-hold:
+			hold = nil
+		case out <- pending:
+			w.delivered(pending)
+			pending = nil
+		case p, ok := <-keyCh:
+			if !ok || p == nil {
+				return true, errWatchLost
+			}
+			if p.LastIndex < w.lastIndex {
+				// replayed by the backend
+				continue
+			}
+			setPending(w.event([]*store.KVPair{w.keyPair(p)}))
+		case _, ok := <-treeCh:
+			if !ok {
+				return true, errWatchLost
+			}
+			// the backends differ on the depth of the trees they return
+			if pairs, err = w.snapshot(); err != nil {
+				return true, err
+			}
+			setPending(w.event(pairs))
+		}
+	}
+}
+
+// poll reads the state of the key every PollInterval
+func (w *watcher) poll() (connected bool, err error) {
+	for {
+		pairs, err := w.snapshot()
+		if err != nil {
+			return connected, err
+		}
+		connected = true
+		if ev := w.event(pairs); ev != nil {
+			select {
+			case w.out <- ev:
+				w.delivered(ev)
+			case <-w.stopCh:
+				return true, nil
+			}
+		}
+		select {
+		case <-w.stopCh:
+			return true, nil
+		case <-time.After(w.opts.PollInterval):
+		}
+	}
+}
+
+// snapshot reads the current state of the key or of the tree
+func (w *watcher) snapshot() ([]*store.KVPair, error) {
+	if !w.opts.Tree {
+		p, err := w.kv.Get(w.key)
+		if err == store.ErrKeyNotFound || (err == nil && p == nil) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []*store.KVPair{w.keyPair(p)}, nil
+	}
+	tree := make(map[string]*store.KVPair)
+	if err := listTree(w.kv, w.key, tree); err != nil {
+		return nil, err
+	}
+	pairs := make([]*store.KVPair, 0, len(tree))
+	for _, p := range tree {
+		pairs = append(pairs, p)
+	}
+	return pairs, nil
+}
+
+// keyPair returns the pair of the watched key under the key it is watched
+// with, the backends differ on the leading slash
+func (w *watcher) keyPair(p *store.KVPair) *store.KVPair {
+	return &store.KVPair{Key: w.key, Value: p.Value, LastIndex: p.LastIndex}
+}
+
+// event returns the event of the state, nil when it is the last delivered
+func (w *watcher) event(pairs []*store.KVPair) *WatchEvent {
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	token := watchToken(pairs)
+	if token == w.last {
+		return nil
+	}
+	return &WatchEvent{Pairs: pairs, Token: token}
+}
+
+func (w *watcher) delivered(ev *WatchEvent) {
+	w.last = ev.Token
+	for _, p := range ev.Pairs {
+		if p.LastIndex > w.lastIndex {
+			w.lastIndex = p.LastIndex
+		}
+	}
+}
+
+// fail delivers the error ending the watch
+func (w *watcher) fail(err error) {
+	select {
+	case w.out <- &WatchEvent{Err: err}:
+	case <-w.stopCh:
+	}
+}
+
+// wait returns false when the watch is stopped during the interval
+func (w *watcher) wait(d time.Duration) bool {
+	var wake <-chan struct{}
+	if w.opts.Wake != nil {
+		wake = w.opts.Wake()
+	}
+	select {
+	case <-w.stopCh:
+		return false
+	case <-wake:
+	case <-time.After(d):
+	}
+	return true
+}
+
+// watchToken is a digest of the keys and indexes of the sorted pairs
+func watchToken(pairs []*store.KVPair) WatchToken {
+	h := sha256.New()
+	for _, p := range pairs {
+		fmt.Fprintf(h, "%s\x00%d\n", strings.Trim(p.Key, "/"), p.LastIndex)
+	}
+	return WatchToken(hex.EncodeToString(h.Sum(nil)[:16]))
+}
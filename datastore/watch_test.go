@@ -0,0 +1,133 @@
+package datastore
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// flakyWatchStore is a MockStore whose key watches are fed and dropped by
+// the test
+type flakyWatchStore struct {
+	*MockStore
+	sync.Mutex
+	watches chan chan *store.KVPair
+	err     error
+}
+
+func (s *flakyWatchStore) Get(key string) (*store.KVPair, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.MockStore.Get(key)
+}
+
+func (s *flakyWatchStore) Put(key string, value []byte, options *store.WriteOptions) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.MockStore.Put(key, value, options)
+}
+
+func (s *flakyWatchStore) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	ch := make(chan *store.KVPair)
+	s.watches <- ch
+	return ch, nil
+}
+
+func nextEvent(t *testing.T, ch <-chan *WatchEvent) *WatchEvent {
+	select {
+	case ev := <-ch:
+		assert.Assert(t, ev != nil, "watch closed")
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no watch event")
+	}
+	return nil
+}
+
+func TestWatchKVResumes(t *testing.T) {
+	kv := &flakyWatchStore{MockStore: NewMockStore(), watches: make(chan chan *store.KVPair)}
+	key := Key("network", "n1")
+	assert.NilError(t, kv.Put(key, []byte("v1"), nil))
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	var lost int
+	opts := WatchOptions{
+		RetryInterval: time.Millisecond,
+		Disconnected:  func(error) { lost++ },
+	}
+	evCh := WatchKV(kv, key, opts, stopCh)
+
+	backend := <-kv.watches
+	ev := nextEvent(t, evCh)
+	assert.Check(t, is.Equal(string(ev.Pairs[0].Value), "v1"))
+	first := ev.Token
+
+	assert.NilError(t, kv.Put(key, []byte("v2"), nil))
+	backend <- &store.KVPair{Key: key, Value: []byte("v2"), LastIndex: 2}
+	ev = nextEvent(t, evCh)
+	assert.Check(t, is.Equal(string(ev.Pairs[0].Value), "v2"))
+
+	// a replayed event is dropped
+	backend <- &store.KVPair{Key: key, Value: []byte("v1"), LastIndex: 1}
+
+	// the change made while the watch is lost is delivered on the restart
+	close(backend)
+	assert.NilError(t, kv.Put(key, []byte("v3"), nil))
+	backend = <-kv.watches
+	ev = nextEvent(t, evCh)
+	assert.Check(t, is.Equal(string(ev.Pairs[0].Value), "v3"))
+	assert.Check(t, is.Equal(ev.Pairs[0].LastIndex, uint64(3)))
+	assert.Check(t, is.Equal(lost, 1))
+
+	// the same state is not delivered again
+	backend <- &store.KVPair{Key: key, Value: []byte("v3"), LastIndex: 3}
+	select {
+	case ev := <-evCh:
+		t.Fatalf("unexpected event %v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// a watch resumed from the current state skips it
+	resumed := WatchKV(kv, key, WatchOptions{Resume: ev.Token}, stopCh)
+	backend = <-kv.watches
+	assert.NilError(t, kv.Put(key, []byte("v4"), nil))
+	backend <- &store.KVPair{Key: key, Value: []byte("v4"), LastIndex: 4}
+	ev = nextEvent(t, resumed)
+	assert.Check(t, is.Equal(string(ev.Pairs[0].Value), "v4"))
+	assert.Check(t, ev.Token != first)
+}
+
+func TestWatchKVErrors(t *testing.T) {
+	kv := &flakyWatchStore{MockStore: NewMockStore(), err: store.ErrCallNotSupported}
+	key := Key("network", "n1")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	ev := nextEvent(t, WatchKV(kv, key, WatchOptions{}, stopCh))
+	assert.Check(t, is.Equal(ev.Err, store.ErrCallNotSupported))
+
+	// the stores which can't watch are polled
+	evCh := WatchKV(kv, key, WatchOptions{PollInterval: time.Millisecond}, stopCh)
+	ev = nextEvent(t, evCh)
+	assert.NilError(t, ev.Err)
+	assert.Check(t, is.Len(ev.Pairs, 0))
+	assert.NilError(t, kv.Put(key, []byte("v1"), nil))
+	ev = nextEvent(t, evCh)
+	assert.Check(t, is.Equal(string(ev.Pairs[0].Value), "v1"))
+
+	kv.err = errors.New("connection refused")
+	evCh = WatchKV(kv, key, WatchOptions{RetryInterval: time.Millisecond, MaxRetries: 2}, stopCh)
+	ev = nextEvent(t, evCh)
+	assert.Check(t, is.ErrorContains(ev.Err, "after 3 attempts"))
+	_, ok := <-evCh
+	assert.Check(t, !ok, "the watch is closed after its error")
+}
@@ -2,9 +2,12 @@ package diagnostic
 
 import (
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 
@@ -41,6 +44,8 @@ type Server struct {
 	port              int
 	mux               *http.ServeMux
 	registeredHanders map[string]bool
+	token             string
+	tlsConfig         *tls.Config
 	sync.Mutex
 }
 
@@ -72,12 +77,41 @@ func (s *Server) RegisterHandler(ctx interface{}, hdlrs map[string]HTTPHandlerFu
 	}
 }
 
+// SetAuth secures the server started next. When token is not empty the
+// requests must carry it as a bearer token in their Authorization header.
+// When tlsConfig is not nil the server is served over TLS, and the clients
+// must present a certificate when its ClientAuth requires one.
+func (s *Server) SetAuth(token string, tlsConfig *tls.Config) {
+	s.Lock()
+	defer s.Unlock()
+	s.token = token
+	s.tlsConfig = tlsConfig
+}
+
 // ServeHTTP this is the method called bu the ListenAndServe, and is needed to allow us to
 // use our custom mux
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	token := s.token
+	s.Unlock()
+	if token != "" && !validToken(r, token) {
+		logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "url": r.URL.String()}).Warn("unauthorized request")
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
 	s.mux.ServeHTTP(w, r)
 }
 
+func validToken(r *http.Request, token string) bool {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(token)) == 1
+}
+
 // EnableDiagnostic opens a TCP socket to debug the passed network DB
 func (s *Server) EnableDiagnostic(ip string, port int) {
 	s.Lock()
@@ -91,12 +125,19 @@ func (s *Server) EnableDiagnostic(ip string, port int) {
 	}
 
 	logrus.Infof("Starting the diagnostic server listening on %d for commands", port)
-	srv := &http.Server{Addr: fmt.Sprintf("%s:%d", ip, port), Handler: s}
+	srv := &http.Server{Addr: fmt.Sprintf("%s:%d", ip, port), Handler: s, TLSConfig: s.tlsConfig}
 	s.srv = srv
 	s.enable = 1
 	go func(n *Server) {
+		var err error
+		if srv.TLSConfig != nil {
+			// the certificates are in the TLS configuration
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
 		// Ignore ErrServerClosed that is returned on the Shutdown call
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err != nil && err != http.ErrServerClosed {
 			logrus.Errorf("ListenAndServe error: %s", err)
 			atomic.SwapInt32(&n.enable, 0)
 		}
@@ -0,0 +1,41 @@
+package diagnostic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeHTTPToken(t *testing.T) {
+	s := New()
+	s.Init()
+	s.SetAuth("secret", nil)
+
+	for _, c := range []struct {
+		auth string
+		code int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", "/ready", nil)
+		if c.auth != "" {
+			r.Header.Set("Authorization", c.auth)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Fatalf("authorization %q: expected status %d, got %d", c.auth, c.code, w.Code)
+		}
+	}
+
+	// without a token every request is served
+	s.SetAuth("", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
@@ -1,5 +1,7 @@
 package discoverapi
 
+import "time"
+
 // Discover is an interface to be implemented by the component interested in receiving discover events
 // like new node joining the cluster or datastore updates
 type Discover interface {
@@ -37,6 +39,17 @@ type DatastoreConfigData struct {
 	Provider string
 	Address  string
 	Config   interface{}
+	// CacheTTL is how long the reads of the store can be cached, zero
+	// disables the cache
+	CacheTTL time.Duration
+	// SlowThreshold is the latency from which the calls to the store are
+	// logged, zero disables the log
+	SlowThreshold time.Duration
+	// KeyProvider is the datastore.KeyProvider encrypting the values of
+	// the local store, nil when the store is not encrypted
+	KeyProvider interface{}
+	// Namespace is the path the keys of the store are put under
+	Namespace string
 }
 
 // DriverEncryptionConfig contains the initial datapath encryption key(s)
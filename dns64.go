@@ -0,0 +1,257 @@
+package libnetwork
+
+import (
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// dns64WellKnownPrefix is the prefix reserved for the algorithmic mapping of
+// IPv4 addresses to IPv6 [RFC 6052 Section-2.1]
+var dns64WellKnownPrefix = &net.IPNet{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 128)}
+
+// dns64ExcludedAAAA are the AAAA records ignored when deciding whether an
+// answer has to be synthesized: IPv4 mapped addresses [RFC 6147 Section-5.1.4]
+var dns64ExcludedAAAA = []*net.IPNet{
+	{IP: net.ParseIP("::ffff:0:0"), Mask: net.CIDRMask(96, 128)},
+}
+
+// dns64ExcludedA are the IPv4 addresses no AAAA record is synthesized for
+var dns64ExcludedA = []*net.IPNet{
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(169, 254, 0, 0), Mask: net.CIDRMask(16, 32)},
+	{IP: net.IPv4(224, 0, 0, 0), Mask: net.CIDRMask(4, 32)},
+}
+
+// dns64PrivateA are the non global IPv4 addresses which must not be
+// represented with the well known prefix [RFC 6052 Section-3.1]
+var dns64PrivateA = []*net.IPNet{
+	{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(172, 16, 0, 0), Mask: net.CIDRMask(12, 32)},
+	{IP: net.IPv4(192, 168, 0, 0), Mask: net.CIDRMask(16, 32)},
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+}
+
+// validateNAT64Prefix checks the prefix is one of the lengths defined for
+// IPv4-embedded IPv6 addresses [RFC 6052 Section-2.2]
+func validateNAT64Prefix(prefix string) (*net.IPNet, error) {
+	ip, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil || ip.To4() != nil {
+		return nil, types.BadRequestErrorf("invalid NAT64 prefix %s", prefix)
+	}
+	switch ones, _ := ipNet.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64:
+	case 96:
+		// bits 64 to 71 are reserved and must be zero
+		if ipNet.IP[8] != 0 {
+			return nil, types.BadRequestErrorf("invalid NAT64 prefix %s: bits 64 to 71 must be zero", prefix)
+		}
+	default:
+		return nil, types.BadRequestErrorf("invalid NAT64 prefix length in %s", prefix)
+	}
+	return ipNet, nil
+}
+
+// dns64Embed returns the IPv6 address embedding ip in prefix
+func dns64Embed(prefix *net.IPNet, ip net.IP) net.IP {
+	ip6 := make(net.IP, net.IPv6len)
+	copy(ip6, prefix.IP.To16())
+	ones, _ := prefix.Mask.Size()
+	pos := ones / 8
+	for _, b := range ip.To4() {
+		// skip the reserved octet
+		if pos == 8 {
+			pos++
+		}
+		ip6[pos] = b
+		pos++
+	}
+	return ip6
+}
+
+// dns64Extract returns the IPv4 address embedded in ip6, nil if ip6 is not
+// part of prefix
+func dns64Extract(prefix *net.IPNet, ip6 net.IP) net.IP {
+	if ip6.To4() != nil || !prefix.Contains(ip6) {
+		return nil
+	}
+	ones, _ := prefix.Mask.Size()
+	pos := ones / 8
+	ip := make(net.IP, net.IPv4len)
+	for i := range ip {
+		if pos == 8 {
+			pos++
+		}
+		ip[i] = ip6[pos]
+		pos++
+	}
+	return ip
+}
+
+func dns64Excluded(prefix *net.IPNet, ip net.IP) bool {
+	excluded := dns64ExcludedA
+	if prefix.String() == dns64WellKnownPrefix.String() {
+		excluded = append(excluded[:len(excluded):len(excluded)], dns64PrivateA...)
+	}
+	for _, n := range excluded {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUsableAAAA checks whether resp carries AAAA records outside of the
+// excluded ranges
+func hasUsableAAAA(resp *dns.Msg) bool {
+	for _, rr := range resp.Answer {
+		aaaa, ok := rr.(*dns.AAAA)
+		if !ok {
+			continue
+		}
+		usable := true
+		for _, n := range dns64ExcludedAAAA {
+			if n.Contains(aaaa.AAAA) {
+				usable = false
+				break
+			}
+		}
+		if usable {
+			return true
+		}
+	}
+	return false
+}
+
+// dns64Answer builds the AAAA answers synthesized from the A records of aResp.
+// CNAME records are copied so that the chain leading to the name is kept.
+// The TTL of the records is capped to the negative caching TTL of the AAAA
+// response, when one was received [RFC 6147 Section-5.1.7]
+func dns64Answer(prefix *net.IPNet, aResp *dns.Msg, maxTTL uint32) []dns.RR {
+	var answer []dns.RR
+	for _, rr := range aResp.Answer {
+		switch rec := rr.(type) {
+		case *dns.CNAME:
+			answer = append(answer, dns.Copy(rec))
+		case *dns.A:
+			if dns64Excluded(prefix, rec.A) {
+				continue
+			}
+			ttl := rec.Hdr.Ttl
+			if maxTTL > 0 && ttl > maxTTL {
+				ttl = maxTTL
+			}
+			answer = append(answer, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: rec.Hdr.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+				AAAA: dns64Embed(prefix, rec.A),
+			})
+		}
+	}
+	return answer
+}
+
+// internalDNS64 answers AAAA queries for the names known to the embedded
+// server which only have IPv4 addresses
+func (r *resolver) internalDNS64(name string, query *dns.Msg) *dns.Msg {
+	prefix := r.backend.NAT64Prefix()
+	if prefix == nil {
+		return nil
+	}
+	addr, _ := r.backend.ResolveName(name, types.IPv4)
+	if len(addr) == 0 {
+		return nil
+	}
+
+	logrus.Debugf("[resolver] synthesizing AAAA records for %s with prefix %s", name, prefix)
+
+	resp := createRespMsg(query)
+	for _, ip := range shuffleAddr(addr) {
+		if dns64Excluded(prefix, ip) {
+			continue
+		}
+		resp.Answer = append(resp.Answer, &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: respTTL},
+			AAAA: dns64Embed(prefix, ip),
+		})
+	}
+	return resp
+}
+
+// externalDNS64 synthesizes the answer to a forwarded AAAA query when the
+// external nameservers did not return any usable AAAA record for the name
+func (r *resolver) externalDNS64(proto string, maxSize int, query, resp *dns.Msg) *dns.Msg {
+	prefix := r.backend.NAT64Prefix()
+	if prefix == nil || query.Question[0].Qtype != dns.TypeAAAA {
+		return resp
+	}
+	// Synthesized answers would not validate for a client doing its own
+	// DNSSEC validation [RFC 6147 Section-5.5]
+	if opt := query.IsEdns0(); query.CheckingDisabled && opt != nil && opt.Do() {
+		return resp
+	}
+	// A name which doesn't exist has no A records to synthesize from either.
+	// Other errors are handled as an empty answer [RFC 6147 Section-5.1.2]
+	if resp != nil && (resp.Rcode == dns.RcodeNameError || (resp.Rcode == dns.RcodeSuccess && hasUsableAAAA(resp))) {
+		return resp
+	}
+
+	var maxTTL uint32
+	if resp != nil {
+		for _, rr := range resp.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				maxTTL = soa.Minttl
+			}
+		}
+	}
+
+	aQuery := query.Copy()
+	aQuery.Question[0].Qtype = dns.TypeA
+	aResp, _ := r.forwardExtDNS(proto, maxSize, aQuery)
+	if aResp == nil || aResp.Rcode != dns.RcodeSuccess {
+		return resp
+	}
+	answer := dns64Answer(prefix, aResp, maxTTL)
+	if len(answer) == 0 {
+		return resp
+	}
+
+	logrus.Debugf("[resolver] synthesized %d AAAA records for %s with prefix %s", len(answer), query.Question[0].Name, prefix)
+
+	synth := createRespMsg(query)
+	synth.Answer = answer
+	synth.Compress = true
+	if proto == "udp" && synth.Len() > maxSize {
+		truncateResp(synth, maxSize, false)
+	}
+	return synth
+}
+
+// dns64PTR maps the PTR queries for the addresses of the NAT64 prefix to the
+// IPv4 address they embed [RFC 6147 Section-5.3.1]
+func (r *resolver) dns64PTR(ptr string) string {
+	prefix := r.backend.NAT64Prefix()
+	if prefix == nil || !strings.HasSuffix(ptr, ptrIPv6domain) {
+		return ""
+	}
+	nibbles := strings.Split(strings.TrimSuffix(ptr, ptrIPv6domain), ".")
+	if len(nibbles) != 2*net.IPv6len {
+		return ""
+	}
+	var hex strings.Builder
+	for i := len(nibbles) - 1; i >= 0; i-- {
+		hex.WriteString(nibbles[i])
+		if i%4 == 0 && i > 0 {
+			hex.WriteByte(':')
+		}
+	}
+	ip := dns64Extract(prefix, net.ParseIP(hex.String()))
+	if ip == nil {
+		return ""
+	}
+	return r.backend.ResolveIP(netutils.ReverseIP(ip.String()))
+}
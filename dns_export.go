@@ -0,0 +1,159 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// dnsExporter serves the records known to the embedded DNS server on a
+// host facing socket, so that host processes and other machines can resolve
+// container names as <name>.<network>.<zone>
+type dnsExporter struct {
+	c         *controller
+	zone      string
+	address   string
+	server    *dns.Server
+	tcpServer *dns.Server
+}
+
+// defaultExportZone is the zone used when the configuration does not set one
+const defaultExportZone = "docker"
+
+func newDNSExporter(c *controller, address, zone string) *dnsExporter {
+	if zone == "" {
+		zone = defaultExportZone
+	}
+	return &dnsExporter{
+		c:       c,
+		zone:    dns.Fqdn(zone),
+		address: address,
+	}
+}
+
+func (e *dnsExporter) start() error {
+	conn, err := net.ListenPacket("udp", e.address)
+	if err != nil {
+		return fmt.Errorf("error in opening DNS export socket %v", err)
+	}
+	l, err := net.Listen("tcp", e.address)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error in opening DNS export TCP socket %v", err)
+	}
+
+	e.server = &dns.Server{Handler: e, PacketConn: conn}
+	e.tcpServer = &dns.Server{Handler: e, Listener: l}
+	go e.server.ActivateAndServe()
+	go e.tcpServer.ActivateAndServe()
+
+	logrus.Infof("Exporting container DNS records for zone %s on %s", e.zone, e.address)
+	return nil
+}
+
+func (e *dnsExporter) stop() {
+	if e.server != nil {
+		e.server.Shutdown()
+	}
+	if e.tcpServer != nil {
+		e.tcpServer.Shutdown()
+	}
+}
+
+// resolveName looks up a name of the form <name>.<network> in the networks
+// managed by the controller. Both the container and the network name may
+// contain dots, so all the possible splits are tried.
+func (e *dnsExporter) resolveName(name string, ipType int) []net.IP {
+	for i := 0; i < len(name); i++ {
+		if name[i] != '.' {
+			continue
+		}
+		n, err := e.c.NetworkByName(name[i+1:])
+		if err != nil {
+			continue
+		}
+		if ip, _ := n.(*network).ResolveName(name[:i], ipType); ip != nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+func (e *dnsExporter) resolveIP(ptr string) string {
+	var ip string
+	switch {
+	case strings.HasSuffix(ptr, ptrIPv4domain):
+		ip = strings.TrimSuffix(ptr, ptrIPv4domain)
+	case strings.HasSuffix(ptr, ptrIPv6domain):
+		ip = strings.TrimSuffix(ptr, ptrIPv6domain)
+	default:
+		return ""
+	}
+	for _, n := range e.c.Networks() {
+		if host := n.(*network).ResolveIP(ip); host != "" {
+			return host + "." + e.zone
+		}
+	}
+	return ""
+}
+
+func (e *dnsExporter) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
+	if query == nil || len(query.Question) == 0 {
+		return
+	}
+	q := query.Question[0]
+	name := q.Name
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	resp.Authoritative = true
+
+	switch q.Qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		if !strings.HasSuffix(name, "."+e.zone) {
+			resp.SetRcode(query, dns.RcodeRefused)
+			break
+		}
+		ipType := types.IPv4
+		if q.Qtype == dns.TypeAAAA {
+			ipType = types.IPv6
+		}
+		addr := e.resolveName(strings.TrimSuffix(name, "."+e.zone), ipType)
+		if addr == nil {
+			resp.SetRcode(query, dns.RcodeNameError)
+			break
+		}
+		for _, ip := range addr {
+			if ipType == types.IPv4 {
+				resp.Answer = append(resp.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: respTTL},
+					A:   ip,
+				})
+				continue
+			}
+			resp.Answer = append(resp.Answer, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: q.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: respTTL},
+				AAAA: ip,
+			})
+		}
+	case dns.TypePTR:
+		host := e.resolveIP(name)
+		if host == "" {
+			resp.SetRcode(query, dns.RcodeNameError)
+			break
+		}
+		resp.Answer = append(resp.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: respTTL},
+			Ptr: dns.Fqdn(host),
+		})
+	default:
+		resp.SetRcode(query, dns.RcodeNotImplemented)
+	}
+
+	if err := w.WriteMsg(resp); err != nil {
+		logrus.Errorf("[dns export] error writing response, %s", err)
+	}
+}
@@ -0,0 +1,77 @@
+package libnetwork
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestDNSExporterZone(t *testing.T) {
+	assert.Check(t, is.Equal(newDNSExporter(nil, "", "").zone, "docker."))
+	assert.Check(t, is.Equal(newDNSExporter(nil, "", "containers.example.com").zone, "containers.example.com."))
+}
+
+func TestDNSExporterServeDNS(t *testing.T) {
+	c, err := New()
+	assert.NilError(t, err)
+	defer c.Stop()
+
+	n1, err := c.NewNetwork("bridge", "front", "", nil)
+	assert.NilError(t, err)
+	defer n1.Delete()
+	// the network names may have dots
+	n2, err := c.NewNetwork("bridge", "back.internal", "", nil)
+	assert.NilError(t, err)
+	defer n2.Delete()
+
+	n1.(*network).addSvcRecords("ep1", "web", "ep1", net.ParseIP("10.0.0.2"), net.ParseIP("fd00::2"), true, "test")
+	n2.(*network).addSvcRecords("ep2", "db.1", "ep2", net.ParseIP("10.1.0.2"), nil, true, "test")
+
+	e := newDNSExporter(c.(*controller), "", "")
+	query := func(name string, qtype uint16) *dns.Msg {
+		q := new(dns.Msg)
+		q.SetQuestion(name, qtype)
+		w := new(tstwriter)
+		e.ServeDNS(w, q)
+		assert.Assert(t, w.msg != nil, name)
+		return w.msg
+	}
+
+	resp := query("web.front.docker.", dns.TypeA)
+	assert.Assert(t, is.Len(resp.Answer, 1))
+	assert.Check(t, resp.Authoritative)
+	assert.Check(t, is.Equal(resp.Answer[0].(*dns.A).A.String(), "10.0.0.2"))
+
+	resp = query("web.front.docker.", dns.TypeAAAA)
+	assert.Assert(t, is.Len(resp.Answer, 1))
+	assert.Check(t, is.Equal(resp.Answer[0].(*dns.AAAA).AAAA.String(), "fd00::2"))
+
+	resp = query("db.1.back.internal.docker.", dns.TypeA)
+	assert.Assert(t, is.Len(resp.Answer, 1))
+	assert.Check(t, is.Equal(resp.Answer[0].(*dns.A).A.String(), "10.1.0.2"))
+
+	resp = query("2.0.0.10.in-addr.arpa.", dns.TypePTR)
+	assert.Assert(t, is.Len(resp.Answer, 1))
+	assert.Check(t, is.Equal(resp.Answer[0].(*dns.PTR).Ptr, "web.front.docker."))
+
+	// only the names of the zone are answered
+	for _, m := range []struct {
+		name  string
+		qtype uint16
+		rcode int
+	}{
+		{"web.front.example.com.", dns.TypeA, dns.RcodeRefused},
+		{"docker.", dns.TypeA, dns.RcodeRefused},
+		{"cache.front.docker.", dns.TypeA, dns.RcodeNameError},
+		{"web.docker.", dns.TypeA, dns.RcodeNameError},
+		{"3.0.0.10.in-addr.arpa.", dns.TypePTR, dns.RcodeNameError},
+		{"web.front.docker.", dns.TypeMX, dns.RcodeNotImplemented},
+	} {
+		resp := query(m.name, m.qtype)
+		assert.Check(t, is.Equal(resp.Rcode, m.rcode), m.name)
+		assert.Check(t, is.Len(resp.Answer, 0), m.name)
+	}
+}
@@ -0,0 +1,21 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/resolvconf"
+)
+
+// DNSUpstreams returns the external nameservers used by the sandboxes which
+// inherit the host DNS configuration, as found in the default resolv.conf
+func (c *controller) DNSUpstreams() (*resolvconf.Upstreams, error) {
+	return resolvconf.EffectiveUpstreams(c.upstreamOptions(""))
+}
+
+// upstreamOptions returns the options to choose the upstream nameservers
+// from the origin resolv.conf at path
+func (c *controller) upstreamOptions(path string) resolvconf.UpstreamOptions {
+	opts := resolvconf.UpstreamOptions{Path: path}
+	if c.cfg != nil {
+		opts.Override = c.cfg.Daemon.DNSUpstreams
+	}
+	return opts
+}
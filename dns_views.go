@@ -0,0 +1,140 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DNSViewRule overrides the records a name resolves to for the sandboxes
+// attached to the network the rule is defined for, so that the same name can
+// resolve differently depending on where the query comes from
+type DNSViewRule struct {
+	// Name the rule applies to. A leading "*." matches all the names in
+	// the domain
+	Name string
+	// Network, by name or id, the name is resolved in instead of the
+	// networks the querying sandbox is attached to
+	Network string
+	// Addresses the name resolves to. Takes precedence over Network
+	Addresses []net.IP
+}
+
+// Validate checks whether the rule is valid
+func (r *DNSViewRule) Validate() error {
+	name := strings.TrimPrefix(strings.TrimSuffix(r.Name, "."), "*.")
+	if name == "" || strings.Contains(name, "*") {
+		return types.BadRequestErrorf("invalid name %q in DNS view rule", r.Name)
+	}
+	if r.Network == "" && len(r.Addresses) == 0 {
+		return types.BadRequestErrorf("DNS view rule for %q has neither a network nor addresses", r.Name)
+	}
+	return nil
+}
+
+func (r *DNSViewRule) matches(name string) bool {
+	rn := strings.ToLower(strings.TrimSuffix(r.Name, "."))
+	if strings.HasPrefix(rn, "*.") {
+		return strings.HasSuffix(name, rn[1:])
+	}
+	return name == rn
+}
+
+// SetDNSViews replaces the DNS view rules applied to the queries coming from
+// the sandboxes attached to the network identified by nid. The rules are
+// persisted with the network.
+func (c *controller) SetDNSViews(nid string, rules []*DNSViewRule) error {
+	if _, err := c.NetworkByID(nid); err != nil {
+		return err
+	}
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	rules = append([]*DNSViewRule(nil), rules...)
+
+	if err := c.updateNetworkInStore(nid, func(n *network) {
+		n.dnsViews = rules
+	}); err != nil {
+		return fmt.Errorf("failed to persist the DNS view rules of network %s: %v", nid, err)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if len(rules) == 0 {
+		delete(c.dnsViews, nid)
+		return nil
+	}
+	if c.dnsViews == nil {
+		c.dnsViews = make(map[string][]*DNSViewRule)
+	}
+	c.dnsViews[nid] = rules
+	return nil
+}
+
+// restoreDNSViews restores the DNS view rules of the networks from the store
+func (c *controller) restoreDNSViews() {
+	c.WalkNetworks(func(nw Network) bool {
+		n := nw.(*network)
+		n.Lock()
+		rules := n.dnsViews
+		n.Unlock()
+		if len(rules) > 0 {
+			c.Lock()
+			if c.dnsViews == nil {
+				c.dnsViews = make(map[string][]*DNSViewRule)
+			}
+			c.dnsViews[n.ID()] = rules
+			c.Unlock()
+		}
+		return false
+	})
+}
+
+// DNSViews returns the DNS view rules defined for the network identified by nid
+func (c *controller) DNSViews(nid string) []*DNSViewRule {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*DNSViewRule(nil), c.dnsViews[nid]...)
+}
+
+// resolveView resolves name against the view rules of the networks the
+// sandbox is attached to, in priority order. The second return value is
+// true when a rule matched the name, in which case the regular resolution
+// must not be attempted.
+func (sb *sandbox) resolveView(name string, ipType int) ([]net.IP, bool) {
+	c := sb.controller
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	for _, ep := range sb.getConnectedEndpoints() {
+		for _, r := range c.DNSViews(ep.getNetwork().ID()) {
+			if !r.matches(name) {
+				continue
+			}
+			if len(r.Addresses) > 0 {
+				var ips []net.IP
+				for _, ip := range r.Addresses {
+					if (ip.To4() != nil) == (ipType == types.IPv4) {
+						ips = append(ips, ip)
+					}
+				}
+				return ips, true
+			}
+
+			n, err := c.NetworkByName(r.Network)
+			if err != nil {
+				if n, err = c.NetworkByID(r.Network); err != nil {
+					logrus.Debugf("[resolver] network %s of DNS view rule for %s not found", r.Network, r.Name)
+					return nil, true
+				}
+			}
+			ips, _ := n.(*network).ResolveName(name, ipType)
+			return ips, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,143 @@
+package libnetwork
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// DNSRecordWeight steers the answers the embedded DNS server returns for a
+// service name or alias backed by multiple addresses. Only the addresses with
+// the lowest priority are returned. When some of them have a weight, a single
+// address is picked among those, with a probability proportional to its
+// weight. Addresses without a DNSRecordWeight have priority and weight 0.
+type DNSRecordWeight struct {
+	IP       net.IP
+	Priority uint16
+	Weight   uint16
+}
+
+// SetDNSRecordWeights replaces the weights of the addresses name resolves to
+// in the network identified by nid. Passing no weights restores the regular
+// round robin answers. The weights are persisted with the network.
+func (c *controller) SetDNSRecordWeights(nid, name string, weights []*DNSRecordWeight) error {
+	if _, err := c.NetworkByID(nid); err != nil {
+		return err
+	}
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return types.BadRequestErrorf("invalid empty name for DNS record weights")
+	}
+	seen := make(map[string]bool, len(weights))
+	for _, w := range weights {
+		if w == nil || w.IP == nil {
+			return types.BadRequestErrorf("missing address in DNS record weights for %q", name)
+		}
+		if seen[w.IP.String()] {
+			return types.BadRequestErrorf("duplicate address %s in DNS record weights for %q", w.IP, name)
+		}
+		seen[w.IP.String()] = true
+	}
+	weights = append([]*DNSRecordWeight(nil), weights...)
+
+	if err := c.updateNetworkInStore(nid, func(n *network) {
+		if len(weights) == 0 {
+			delete(n.dnsWeights, name)
+			return
+		}
+		if n.dnsWeights == nil {
+			n.dnsWeights = make(map[string][]*DNSRecordWeight)
+		}
+		n.dnsWeights[name] = weights
+	}); err != nil {
+		return fmt.Errorf("failed to persist the DNS record weights of network %s: %v", nid, err)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if len(weights) == 0 {
+		delete(c.dnsWeights[nid], name)
+		return nil
+	}
+	if c.dnsWeights == nil {
+		c.dnsWeights = make(map[string]map[string][]*DNSRecordWeight)
+	}
+	if c.dnsWeights[nid] == nil {
+		c.dnsWeights[nid] = make(map[string][]*DNSRecordWeight)
+	}
+	c.dnsWeights[nid][name] = weights
+	return nil
+}
+
+// restoreDNSRecordWeights restores the DNS record weights of the networks
+// from the store
+func (c *controller) restoreDNSRecordWeights() {
+	c.WalkNetworks(func(nw Network) bool {
+		n := nw.(*network)
+		n.Lock()
+		weights := n.dnsWeights
+		n.Unlock()
+		if len(weights) > 0 {
+			c.Lock()
+			if c.dnsWeights == nil {
+				c.dnsWeights = make(map[string]map[string][]*DNSRecordWeight)
+			}
+			c.dnsWeights[n.ID()] = weights
+			c.Unlock()
+		}
+		return false
+	})
+}
+
+// DNSRecordWeights returns the weights set for name in the network identified by nid
+func (c *controller) DNSRecordWeights(nid, name string) []*DNSRecordWeight {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*DNSRecordWeight(nil), c.dnsWeights[nid][strings.TrimSuffix(name, ".")]...)
+}
+
+// selectWeighted returns the addresses of ips to answer with according to
+// the passed weights
+func selectWeighted(ips []net.IP, weights []*DNSRecordWeight) []net.IP {
+	wm := make(map[string]*DNSRecordWeight, len(weights))
+	for _, w := range weights {
+		wm[w.IP.String()] = w
+	}
+	weightOf := func(ip net.IP) *DNSRecordWeight {
+		if w, ok := wm[ip.String()]; ok {
+			return w
+		}
+		return &DNSRecordWeight{IP: ip}
+	}
+
+	var (
+		best  []net.IP
+		prio  uint16
+		total int
+	)
+	for _, ip := range ips {
+		w := weightOf(ip)
+		if len(best) > 0 && w.Priority > prio {
+			continue
+		}
+		if len(best) == 0 || w.Priority < prio {
+			best, prio, total = nil, w.Priority, 0
+		}
+		best = append(best, ip)
+		total += int(w.Weight)
+	}
+	if total == 0 {
+		return best
+	}
+
+	n := rand.Intn(total)
+	for _, ip := range best {
+		if n -= int(weightOf(ip).Weight); n < 0 {
+			return []net.IP{ip}
+		}
+	}
+	return best
+}
@@ -89,6 +89,57 @@ type Driver interface {
 	IsBuiltIn() bool
 }
 
+// DatapathInspector is implemented by the drivers which report the
+// datapath state they programmed for a network, such as the iptables rules
+// or the peers, to be dumped for troubleshooting.
+type DatapathInspector interface {
+	// NetworkDatapath returns the datapath state of the network, as
+	// JSON serializable values keyed by their kind.
+	NetworkDatapath(nid string) (map[string]interface{}, error)
+}
+
+// FirewallVerifier is implemented by the drivers which can check that the
+// firewall rules they programmed for a network are still in place.
+type FirewallVerifier interface {
+	// MissingFirewallRules returns the rules of the network missing from
+	// the firewall, in iptables-save format
+	MissingFirewallRules(nid string) ([]string, error)
+}
+
+// FirewallRule is a rule of a caller installed by a driver in a chain of a
+// network, in the iptables syntax
+type FirewallRule struct {
+	// IPv6 programs the rule with ip6tables rather than iptables
+	IPv6 bool
+	// Table is the table of the rule, filter when empty
+	Table string
+	// Args are the arguments of the rule, the matches and the target
+	Args []string
+	// Raw is the rule as a string, when it has no Args, its arguments
+	// quoted when they have spaces
+	Raw string
+}
+
+// FirewallRuleInjector is implemented by the drivers which install the
+// firewall rules of the callers in chains of their networks, kept along the
+// rules of the driver and re-applied with them.
+type FirewallRuleInjector interface {
+	// SetNetworkFirewallRules replaces the rules of the network registered
+	// under name, no rules removing them
+	SetNetworkFirewallRules(nid, name string, rules []FirewallRule) error
+	// NetworkFirewallRules returns the rules of the network by name
+	NetworkFirewallRules(nid string) (map[string][]FirewallRule, error)
+}
+
+// ConfigReloader is implemented by the drivers which can apply a changed
+// driver configuration at runtime.
+type ConfigReloader interface {
+	// ReloadConfig applies the configuration, passed as to the driver
+	// initializer. It returns the changed settings, with the existing
+	// networks keeping the previous value until they are recreated.
+	ReloadConfig(option map[string]interface{}) (map[string][]string, error)
+}
+
 // NetworkInfo provides a go interface for drivers to provide network
 // specific information to libnetwork.
 type NetworkInfo interface {
@@ -11,18 +11,22 @@ import (
 	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/docker/libnetwork/correlation"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/ip6tables"
 	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/metrics"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/ns"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/osl"
 	"github.com/docker/libnetwork/portmapper"
+	"github.com/docker/libnetwork/rootless"
 	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
@@ -43,6 +47,11 @@ const (
 	DefaultGatewayV6AuxKey = "DefaultGatewayIPv6"
 )
 
+var (
+	setupLatencyHisto    = metrics.NewHistogram("bridge", "network_setup_seconds", "Duration of the bridge network setups", nil)
+	setupFailuresCounter = metrics.NewCounter("bridge", "network_setup_failures_total", "Bridge network setups which failed")
+)
+
 type defaultBridgeNetworkConflict struct {
 	ID string
 }
@@ -138,6 +147,9 @@ type bridgeNetwork struct {
 	driver         *driver // The network's driver
 	iptCleanFuncs  iptablesCleanFuncs
 	ip6tCleanFuncs ip6tablesCleanFuncs
+	// firewallRules are the firewall rules of the callers, by the name
+	// they are registered under
+	firewallRules map[string][]driverapi.FirewallRule
 	sync.Mutex
 }
 
@@ -155,6 +167,7 @@ type driver struct {
 	networks            map[string]*bridgeNetwork
 	store               datastore.DataStore
 	nlh                 *netlink.Handle
+	portDriver          portmapper.PortDriver
 	configNetwork       sync.Mutex
 	sync.Mutex
 }
@@ -337,7 +350,29 @@ func (n *bridgeNetwork) isolateNetwork(others []*bridgeNetwork, enable bool) err
 	}
 
 	// Install the rules to isolate this network against each of the other networks
-	return setINC(thisConfig.BridgeName, enable)
+	return setINC(thisConfig.BridgeName, networkOwner(n.id), enable)
+}
+
+// parseDriverConfig returns the driver configuration of the options, nil
+// when the options carry none
+func parseDriverConfig(option map[string]interface{}) (*configuration, error) {
+	genericData, ok := option[netlabel.GenericData]
+	if !ok || genericData == nil {
+		return nil, nil
+	}
+
+	switch opt := genericData.(type) {
+	case options.Generic:
+		opaqueConfig, err := options.GenerateFromModel(opt, &configuration{})
+		if err != nil {
+			return nil, err
+		}
+		return opaqueConfig.(*configuration), nil
+	case *configuration:
+		return opt, nil
+	default:
+		return nil, &ErrInvalidDriverConfig{}
+	}
 }
 
 func (d *driver) configure(option map[string]interface{}) error {
@@ -354,25 +389,20 @@ func (d *driver) configure(option map[string]interface{}) error {
 		ip6tIsolationChain2 *ip6tables.ChainInfo
 	)
 
-	genericData, ok := option[netlabel.GenericData]
-	if !ok || genericData == nil {
-		return nil
+	config, err = parseDriverConfig(option)
+	if err != nil || config == nil {
+		return err
 	}
 
-	switch opt := genericData.(type) {
-	case options.Generic:
-		opaqueConfig, err := options.GenerateFromModel(opt, &configuration{})
-		if err != nil {
-			return err
+	var portDriver portmapper.PortDriver
+	if isRootless, _ := option[netlabel.Rootless].(bool); isRootless {
+		// Without the userland proxy, the published ports would only be
+		// reachable from the namespace of the daemon
+		if !config.EnableUserlandProxy {
+			return rootless.UnsupportedError("disabling the userland proxy")
 		}
-		config = opaqueConfig.(*configuration)
-	case *configuration:
-		config = opt
-	default:
-		return &ErrInvalidDriverConfig{}
-	}
-
-	if config.EnableIPTables || config.EnableIP6Tables {
+		portDriver = rootless.NewPortDriver(rootless.StateDir())
+	} else if config.EnableIPTables || config.EnableIP6Tables {
 		if _, err := os.Stat("/proc/sys/net/bridge"); err != nil {
 			if out, err := exec.Command("modprobe", "-va", "bridge", "br_netfilter").CombinedOutput(); err != nil {
 				logrus.Warnf("Running modprobe bridge br_netfilter failed with message: %s, error: %v", out, err)
@@ -388,6 +418,7 @@ func (d *driver) configure(option map[string]interface{}) error {
 		}
 		// Make sure on firewall reload, first thing being re-played is chains creation
 		iptables.OnReloaded(func() { logrus.Debugf("Recreating iptables chains on firewall reload"); setupIPChains(config) })
+		watchIPChains(config)
 	}
 
 	if config.EnableIP6Tables {
@@ -418,6 +449,7 @@ func (d *driver) configure(option map[string]interface{}) error {
 	d.ip6tIsolationChain1 = ip6tIsolationChain1
 	d.ip6tIsolationChain2 = ip6tIsolationChain2
 	d.config = config
+	d.portDriver = portDriver
 	d.Unlock()
 
 	err = d.initStore(option)
@@ -686,6 +718,11 @@ func (d *driver) createNetwork(config *networkConfiguration) (err error) {
 		bridge:     bridgeIface,
 		driver:     d,
 	}
+	network.portMapper.SetOwner(networkOwner(config.ID))
+	network.portMapper.OnReMapped(network.reapplyFirewallRules)
+	if d.portDriver != nil {
+		network.portMapper.SetPortDriver(d.portDriver)
+	}
 
 	d.Lock()
 	d.networks[config.ID] = network
@@ -697,6 +734,7 @@ func (d *driver) createNetwork(config *networkConfiguration) (err error) {
 			d.Lock()
 			delete(d.networks, config.ID)
 			d.Unlock()
+			iptables.Unwatch("network:" + config.ID)
 		}
 	}()
 
@@ -760,7 +798,7 @@ func (d *driver) createNetwork(config *networkConfiguration) (err error) {
 
 		//We want to track firewalld configuration so that
 		//if it is started/reloaded, the rules can be applied correctly
-		{d.config.EnableIPTables, network.setupFirewalld},
+		{d.config.EnableIPTables || d.config.EnableIP6Tables, network.setupFirewalld},
 
 		// Setup DefaultGatewayIPv4
 		{config.DefaultGatewayIPv4 != nil, setupGatewayIPv4},
@@ -781,7 +819,13 @@ func (d *driver) createNetwork(config *networkConfiguration) (err error) {
 
 	// Apply the prepared list of steps, and abort at the first error.
 	bridgeSetup.queueStep(setupDeviceUp)
-	return bridgeSetup.apply()
+	start := time.Now()
+	if err := bridgeSetup.apply(); err != nil {
+		setupFailuresCounter.Inc()
+		return err
+	}
+	setupLatencyHisto.Observe(time.Since(start).Seconds())
+	return nil
 }
 
 func (d *driver) DeleteNetwork(nid string) error {
@@ -854,6 +898,9 @@ func (d *driver) deleteNetwork(nid string) error {
 		// Don't delete the bridge interface if it was not created by libnetwork.
 	}
 
+	iptables.Unwatch("network:" + nid)
+	n.removeFirewallRules()
+
 	// clean all relevant iptables rules
 	for _, cleanFunc := range n.iptCleanFuncs {
 		if errClean := cleanFunc(); errClean != nil {
@@ -1320,7 +1367,8 @@ func (d *driver) ProgramExternalConnectivity(nid, eid string, options map[string
 	}
 
 	// Program any required port mapping and store them in the endpoint
-	endpoint.portMapping, err = network.allocatePorts(endpoint, network.config.DefaultBindingIP, d.config.EnableUserlandProxy)
+	log := correlation.OptionsLogger(options)
+	endpoint.portMapping, err = network.allocatePorts(log, endpoint, network.config.DefaultBindingIP, d.config.EnableUserlandProxy)
 	if err != nil {
 		return err
 	}
@@ -1328,7 +1376,7 @@ func (d *driver) ProgramExternalConnectivity(nid, eid string, options map[string
 	defer func() {
 		if err != nil {
 			if e := network.releasePorts(endpoint); e != nil {
-				logrus.Errorf("Failed to release ports allocated for the bridge endpoint %s on failure %v because of %v",
+				log.Errorf("Failed to release ports allocated for the bridge endpoint %s on failure %v because of %v",
 					eid, err, e)
 			}
 			endpoint.portMapping = nil
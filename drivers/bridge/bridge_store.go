@@ -8,6 +8,7 @@ import (
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/portallocator"
 	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 )
@@ -32,6 +33,13 @@ func (d *driver) initStore(option map[string]interface{}) error {
 			return types.InternalErrorf("bridge driver failed to initialize data store: %v", err)
 		}
 
+		// The ports published before the restart stay allocated until the
+		// restored endpoints request them back
+		pa := portallocator.Get()
+		if err := pa.SetStore(d.store); err != nil {
+			logrus.Warnf("Failed to restore the port allocations: %v", err)
+		}
+
 		err = d.populateNetworks()
 		if err != nil {
 			return err
@@ -41,6 +49,14 @@ func (d *driver) initStore(option map[string]interface{}) error {
 		if err != nil {
 			return err
 		}
+
+		stale, err := pa.Reconcile()
+		if err != nil {
+			logrus.Warnf("Failed to persist the reconciled port allocations: %v", err)
+		}
+		if len(stale) > 0 {
+			logrus.Infof("Released %d ports allocated to endpoints no longer present", len(stale))
+		}
 	}
 
 	return nil
@@ -380,7 +396,7 @@ func (n *bridgeNetwork) restorePortAllocations(ep *bridgeEndpoint) {
 	}
 	tmp := ep.extConnConfig.PortBindings
 	ep.extConnConfig.PortBindings = ep.portMapping
-	_, err := n.allocatePorts(ep, n.config.DefaultBindingIP, n.driver.config.EnableUserlandProxy)
+	_, err := n.allocatePorts(logrus.NewEntry(logrus.StandardLogger()), ep, n.config.DefaultBindingIP, n.driver.config.EnableUserlandProxy)
 	if err != nil {
 		logrus.Warnf("Failed to reserve existing port mapping for endpoint %.7s:%v", ep.id, err)
 	}
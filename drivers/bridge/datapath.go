@@ -0,0 +1,141 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/ip6tables"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+// NetworkDatapath returns the bridge of the network, the iptables rules
+// matching on it and the port mappings of its endpoints
+func (d *driver) NetworkDatapath(nid string) (map[string]interface{}, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	bridgeName := n.config.BridgeName
+	portMappings := make(map[string][]types.PortBinding)
+	for eid, ep := range n.endpoints {
+		if len(ep.portMapping) == 0 {
+			continue
+		}
+		pmc := make([]types.PortBinding, 0, len(ep.portMapping))
+		for _, pm := range ep.portMapping {
+			pmc = append(pmc, pm.GetCopy())
+		}
+		portMappings[eid] = pmc
+	}
+	n.Unlock()
+
+	d.Lock()
+	ipt, ip6t := d.config.EnableIPTables, d.config.EnableIP6Tables
+	d.Unlock()
+
+	m := map[string]interface{}{
+		"BridgeName":   bridgeName,
+		"PortMappings": portMappings,
+	}
+	if ipt {
+		if m["IptablesRules"], err = bridgeRules(iptables.Raw, bridgeName); err != nil {
+			return nil, err
+		}
+	}
+	if ip6t {
+		if m["Ip6tablesRules"], err = bridgeRules(ip6tables.Raw, bridgeName); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// bridgeRules lists the rules of the nat and filter tables which match on
+// the bridge interface, the rules the network owns
+func bridgeRules(raw func(...string) ([]byte, error), bridgeName string) ([]string, error) {
+	rules := []string{}
+	for _, table := range []string{"nat", "filter"} {
+		out, err := raw("-t", table, "-S")
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range strings.Split(string(out), "\n") {
+			for _, f := range strings.Fields(rule) {
+				if f == bridgeName {
+					rules = append(rules, "-t "+table+" "+rule)
+					break
+				}
+			}
+		}
+	}
+	return rules, nil
+}
+
+// MissingFirewallRules returns the iptables rules of the network which are
+// no longer in their chains
+func (d *driver) MissingFirewallRules(nid string) ([]string, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Lock()
+	driverConfig := d.config
+	d.Unlock()
+	if !driverConfig.EnableIPTables {
+		return nil, nil
+	}
+
+	missing := []string{}
+	for _, r := range n.iptablesRules(!driverConfig.EnableUserlandProxy) {
+		if !iptables.Exists(r.table, r.chain, r.args...) {
+			missing = append(missing, fmt.Sprintf("-t %s -A %s %s", r.table, r.chain, strings.Join(r.args, " ")))
+		}
+	}
+	return missing, nil
+}
+
+// iptablesRules returns the rules setupIPTables and isolateNetwork program
+// for the network
+func (n *bridgeNetwork) iptablesRules(hairpin bool) []iptRule {
+	n.Lock()
+	config := n.config
+	bridge := n.bridge
+	n.Unlock()
+	if bridge == nil || bridge.bridgeIPv4 == nil {
+		return nil
+	}
+
+	addr := &net.IPNet{
+		IP:   bridge.bridgeIPv4.IP.Mask(bridge.bridgeIPv4.Mask),
+		Mask: bridge.bridgeIPv4.Mask,
+	}
+	owner := networkOwner(n.id)
+	if config.Internal {
+		inDropRule, outDropRule := internalNetworkRules(config.BridgeName, addr, owner)
+		return []iptRule{inDropRule, outDropRule, iccRule(config.BridgeName, owner, config.EnableICC)}
+	}
+
+	var (
+		nr    = newNetworkRules(config.BridgeName, addr, owner)
+		rules []iptRule
+	)
+	if config.EnableIPMasquerade {
+		rules = append(rules, nr.nat)
+		if !hairpin {
+			rules = append(rules, nr.skipDNAT)
+		}
+	}
+	if hairpin {
+		rules = append(rules, nr.hpNat)
+	}
+	rules = append(rules, iccRule(config.BridgeName, owner, config.EnableICC), nr.out)
+	inc := incRules(config.BridgeName, owner)
+	return append(rules,
+		iptRule{table: iptables.Filter, chain: IsolationChain1, args: inc[0]},
+		iptRule{table: iptables.Filter, chain: IsolationChain2, args: inc[1]})
+}
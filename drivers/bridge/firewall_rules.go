@@ -0,0 +1,300 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/firewallapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// firewallRulesChainPrefix prefixes the bridge name in the name of the
+// chains of the firewall rules of the callers of a network
+const firewallRulesChainPrefix = "DOCKER-NET-"
+
+// firewallRulesNewChainPrefix prefixes the bridge name in the name of the
+// chains the new rules of the callers are programmed in, before they take
+// the place of the chains of the network
+const firewallRulesNewChainPrefix = "DOCKER-NEW-"
+
+// ruleJump is a rule jumping to the chain of the rules of the callers, for
+// the traffic coming in (-i) or going out (-o) of the bridge
+type ruleJump struct {
+	chain string
+	dir   string
+}
+
+// firewallRulesJumps are the tables the rules of the callers are installed
+// in, with the rules jumping to their chain
+var firewallRulesJumps = map[string][]ruleJump{
+	"filter": {{"FORWARD", "-i"}, {"FORWARD", "-o"}},
+	"nat":    {{"PREROUTING", "-i"}, {"POSTROUTING", "-o"}},
+	"mangle": {{"PREROUTING", "-i"}, {"POSTROUTING", "-o"}},
+}
+
+// getFirewall returns the Firewall of the rules of the family
+var getFirewall = firewallapi.Get
+
+// SetNetworkFirewallRules replaces the firewall rules of the callers of the
+// network registered under name. The rules are installed in a chain of the
+// network per table and family, jumped to by the traffic of the bridge, and
+// re-applied with the rules of the port mappings. They are not persisted.
+func (d *driver) SetNetworkFirewallRules(nid, name string, rules []driverapi.FirewallRule) error {
+	if name == "" {
+		return types.BadRequestErrorf("invalid firewall rules name")
+	}
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	d.Lock()
+	driverConfig := d.config
+	d.Unlock()
+
+	parsed := make([]driverapi.FirewallRule, 0, len(rules))
+	for _, r := range rules {
+		if r.Table == "" {
+			r.Table = "filter"
+		}
+		if _, ok := firewallRulesJumps[r.Table]; !ok {
+			return types.BadRequestErrorf("invalid table %q of the firewall rule %v", r.Table, r)
+		}
+		if len(r.Args) == 0 {
+			r.Args = iptables.SplitArgs(r.Raw)
+		}
+		if len(r.Args) == 0 {
+			return types.BadRequestErrorf("the firewall rules of %s have an empty rule", name)
+		}
+		if r.IPv6 && !driverConfig.EnableIP6Tables || !r.IPv6 && !driverConfig.EnableIPTables {
+			return types.ForbiddenErrorf("the firewall rules of the family of %v are disabled", r)
+		}
+		r.Args = append([]string(nil), r.Args...)
+		parsed = append(parsed, r)
+	}
+
+	n.Lock()
+	if n.firewallRules == nil {
+		n.firewallRules = make(map[string][]driverapi.FirewallRule)
+	}
+	prev, hadPrev := n.firewallRules[name]
+	if len(parsed) == 0 {
+		delete(n.firewallRules, name)
+	} else {
+		n.firewallRules[name] = parsed
+	}
+	n.Unlock()
+
+	if err := n.applyFirewallRules(true, true); err != nil {
+		n.Lock()
+		if hadPrev {
+			n.firewallRules[name] = prev
+		} else {
+			delete(n.firewallRules, name)
+		}
+		n.Unlock()
+		if rerr := n.applyFirewallRules(true, true); rerr != nil {
+			logrus.Warnf("Failed to restore the firewall rules of network %s: %v", nid, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// NetworkFirewallRules returns the firewall rules of the callers of the
+// network by name
+func (d *driver) NetworkFirewallRules(nid string) (map[string][]driverapi.FirewallRule, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+	n.Lock()
+	defer n.Unlock()
+	rules := make(map[string][]driverapi.FirewallRule, len(n.firewallRules))
+	for name, r := range n.firewallRules {
+		rules[name] = append([]driverapi.FirewallRule(nil), r...)
+	}
+	return rules, nil
+}
+
+// reapplyFirewallRules re-applies the firewall rules of the callers of the
+// families, when the network has some
+func (n *bridgeNetwork) reapplyFirewallRules(ipv4, ipv6 bool) {
+	n.Lock()
+	empty := len(n.firewallRules) == 0
+	n.Unlock()
+	if empty {
+		return
+	}
+	if err := n.applyFirewallRules(ipv4, ipv6); err != nil {
+		logrus.Warnf("Failed to re-apply the firewall rules of network %s: %v", n.id, err)
+	}
+}
+
+// removeFirewallRules removes the firewall rules of the callers and their
+// chains, as the network is deleted
+func (n *bridgeNetwork) removeFirewallRules() {
+	n.Lock()
+	empty := len(n.firewallRules) == 0
+	n.firewallRules = nil
+	n.Unlock()
+	if empty {
+		return
+	}
+	if err := n.applyFirewallRules(true, true); err != nil {
+		logrus.Warnf("Failed to remove the firewall rules of network %s: %v", n.id, err)
+	}
+}
+
+// applyFirewallRules programs the chains of the firewall rules of the
+// callers of the families, in the order of their names, and removes the
+// chains left without rules
+func (n *bridgeNetwork) applyFirewallRules(ipv4, ipv6 bool) error {
+	n.driver.Lock()
+	driverConfig := n.driver.config
+	n.driver.Unlock()
+
+	n.Lock()
+	bridgeName := n.config.BridgeName
+	names := make([]string, 0, len(n.firewallRules))
+	for name := range n.firewallRules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// the arguments of the rules, by family and table
+	byFamily := map[bool]map[string][][]string{false: {}, true: {}}
+	for _, name := range names {
+		for _, r := range n.firewallRules[name] {
+			byFamily[r.IPv6][r.Table] = append(byFamily[r.IPv6][r.Table], r.Args)
+		}
+	}
+	n.Unlock()
+
+	chain := firewallRulesChainPrefix + bridgeName
+	tables := make([]string, 0, len(firewallRulesJumps))
+	for table := range firewallRulesJumps {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, f := range []struct {
+		enabled bool
+		ipv6    bool
+		family  firewallapi.Family
+	}{
+		{ipv4 && driverConfig.EnableIPTables, false, firewallapi.IPv4},
+		{ipv6 && driverConfig.EnableIP6Tables, true, firewallapi.IPv6},
+	} {
+		if !f.enabled {
+			continue
+		}
+		fw, err := getFirewall(f.family)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			rules := byFamily[f.ipv6][table]
+			if len(rules) == 0 {
+				err = removeChain(fw, table, chain, bridgeName)
+			} else {
+				err = programChain(fw, table, chain, bridgeName, rules)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// programChain programs the chain of the table with the rules, in place of
+// its rules, and the rules jumping to it. The rules are programmed in a new
+// chain, which the jumps are then swapped to before it takes the place of
+// the chain, so that the traffic never sees a partial set of rules.
+func programChain(fw firewallapi.Firewall, table, chain, bridgeName string, rules [][]string) error {
+	newChain := firewallRulesNewChainPrefix + bridgeName
+	if fw.ExistChain(table, newChain) {
+		// left over by a failed swap
+		if err := dropChain(fw, table, newChain); err != nil {
+			return err
+		}
+	}
+	if err := firewallapi.Run(fw, "-t", table, "-N", newChain); err != nil {
+		return fmt.Errorf("failed to create the chain %s of the %s table: %v", newChain, table, err)
+	}
+	chainRules := make([]firewallapi.Rule, 0, len(rules))
+	for _, args := range rules {
+		chainRules = append(chainRules, firewallapi.Rule{Table: table, Chain: newChain, Args: args})
+	}
+	if err := fw.ProgramRules("-A", chainRules); err != nil {
+		dropChain(fw, table, newChain)
+		return fmt.Errorf("failed to program the rules of the chain %s of the %s table: %v", chain, table, err)
+	}
+
+	for i, j := range firewallRulesJumps[table] {
+		if err := swapJump(fw, table, j, bridgeName, chain, newChain); err != nil {
+			for _, done := range firewallRulesJumps[table][:i] {
+				if rerr := swapJump(fw, table, done, bridgeName, newChain, chain); rerr != nil {
+					logrus.Warnf("Failed to restore the jump to the chain %s of the %s table: %v", chain, table, rerr)
+				}
+			}
+			dropChain(fw, table, newChain)
+			return fmt.Errorf("failed to program the jump to the chain %s of the %s table: %v", chain, table, err)
+		}
+	}
+	if fw.ExistChain(table, chain) {
+		if err := dropChain(fw, table, chain); err != nil {
+			return err
+		}
+	}
+	// the jumps follow the chain they refer to
+	if err := firewallapi.Run(fw, "-t", table, "-E", newChain, chain); err != nil {
+		return fmt.Errorf("failed to rename the chain %s of the %s table: %v", newChain, table, err)
+	}
+	return nil
+}
+
+// swapJump replaces the rule jumping to the chain from with a rule jumping
+// to the chain to, in one command. Without a rule to replace, the rule is
+// inserted, or deleted when to does not exist.
+func swapJump(fw firewallapi.Firewall, table string, j ruleJump, bridgeName, from, to string) error {
+	if pos := firewallapi.RulePosition(fw, table, j.chain, []string{j.dir, bridgeName, "-j", from}); pos > 0 {
+		if !fw.ExistChain(table, to) {
+			return fw.ProgramRule(table, j.chain, "-D", []string{j.dir, bridgeName, "-j", from})
+		}
+		return firewallapi.Run(fw, "-t", table, "-R", j.chain, strconv.Itoa(pos), j.dir, bridgeName, "-j", to)
+	}
+	if !fw.ExistChain(table, to) {
+		return nil
+	}
+	return fw.ProgramRule(table, j.chain, "-I", []string{j.dir, bridgeName, "-j", to})
+}
+
+// removeChain removes the chain of the table and the rules jumping to it,
+// when it exists
+func removeChain(fw firewallapi.Firewall, table, chain, bridgeName string) error {
+	if !fw.ExistChain(table, chain) {
+		return nil
+	}
+	for _, j := range firewallRulesJumps[table] {
+		if err := fw.ProgramRule(table, j.chain, "-D", []string{j.dir, bridgeName, "-j", chain}); err != nil {
+			return fmt.Errorf("failed to remove the jump to the chain %s of the %s table: %v", chain, table, err)
+		}
+	}
+	return dropChain(fw, table, chain)
+}
+
+// dropChain flushes and deletes the chain of the table
+func dropChain(fw firewallapi.Firewall, table, chain string) error {
+	if err := firewallapi.Run(fw, "-t", table, "-F", chain); err != nil {
+		return fmt.Errorf("failed to flush the chain %s of the %s table: %v", chain, table, err)
+	}
+	if err := firewallapi.Run(fw, "-t", table, "-X", chain); err != nil {
+		return fmt.Errorf("failed to remove the chain %s of the %s table: %v", chain, table, err)
+	}
+	return nil
+}
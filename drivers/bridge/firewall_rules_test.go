@@ -0,0 +1,227 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/firewallapi"
+)
+
+// fakeFirewall records the commands changing the rules of a family, the
+// rules of each chain in order
+type fakeFirewall struct {
+	family firewallapi.Family
+	chains map[string][]string
+	cmds   []string
+	// fail fails the commands of the rules with the argument
+	fail string
+}
+
+func newFakeFirewall(family firewallapi.Family) *fakeFirewall {
+	return &fakeFirewall{family: family, chains: map[string][]string{"filter FORWARD": nil, "nat PREROUTING": nil, "nat POSTROUTING": nil, "mangle PREROUTING": nil, "mangle POSTROUTING": nil}}
+}
+
+func (f *fakeFirewall) Family() firewallapi.Family { return f.family }
+
+func (f *fakeFirewall) Backend() string { return firewallapi.Default }
+
+func (f *fakeFirewall) ProgramRule(table, chain, action string, args []string) error {
+	if f.Exists(table, chain, args...) != (action == "-D") {
+		return nil
+	}
+	_, err := f.Raw(append([]string{"-t", table, action, chain}, args...)...)
+	return err
+}
+
+func (f *fakeFirewall) ProgramRules(action string, rules []firewallapi.Rule) error {
+	for _, r := range rules {
+		if err := f.ProgramRule(r.Table, r.Chain, action, r.Args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeFirewall) Exists(table, chain string, args ...string) bool {
+	for _, r := range f.chains[table+" "+chain] {
+		if r == strings.Join(args, " ") {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeFirewall) ExistChain(table, chain string) bool {
+	_, ok := f.chains[table+" "+chain]
+	return ok
+}
+
+// userChains returns the chains the rules created
+func (f *fakeFirewall) userChains() []string {
+	var chains []string
+	for c := range f.chains {
+		if strings.Contains(c, "DOCKER-") {
+			chains = append(chains, c)
+		}
+	}
+	return chains
+}
+
+func (f *fakeFirewall) Raw(args ...string) ([]byte, error) {
+	table, chain := args[1], args[3]
+	key := table + " " + chain
+	if args[2] == "-S" {
+		if !f.ExistChain(table, chain) {
+			return nil, errors.New("No chain/target/match by that name")
+		}
+		var b strings.Builder
+		for _, r := range f.chains[key] {
+			fmt.Fprintf(&b, "-A %s %s\n", chain, r)
+		}
+		return []byte(b.String()), nil
+	}
+	if f.fail != "" && strings.Contains(strings.Join(args, " "), f.fail) {
+		return nil, errors.New("invalid argument")
+	}
+	f.cmds = append(f.cmds, strings.Join(args, " "))
+	switch args[2] {
+	case "-N":
+		f.chains[key] = nil
+	case "-F":
+		f.chains[key] = nil
+	case "-X":
+		delete(f.chains, key)
+	case "-E":
+		f.chains[table+" "+args[4]] = f.chains[key]
+		delete(f.chains, key)
+		for c, rules := range f.chains {
+			for i, r := range rules {
+				if strings.HasSuffix(r, "-j "+chain) {
+					f.chains[c][i] = strings.TrimSuffix(r, chain) + args[4]
+				}
+			}
+		}
+	case "-A":
+		f.chains[key] = append(f.chains[key], strings.Join(args[4:], " "))
+	case "-I":
+		f.chains[key] = append([]string{strings.Join(args[4:], " ")}, f.chains[key]...)
+	case "-R":
+		pos, _ := strconv.Atoi(args[4])
+		f.chains[key][pos-1] = strings.Join(args[5:], " ")
+	case "-D":
+		rule := strings.Join(args[4:], " ")
+		for i, r := range f.chains[key] {
+			if r == rule {
+				f.chains[key] = append(f.chains[key][:i], f.chains[key][i+1:]...)
+				break
+			}
+		}
+	}
+	return nil, nil
+}
+
+func TestNetworkFirewallRules(t *testing.T) {
+	v4, v6 := newFakeFirewall(firewallapi.IPv4), newFakeFirewall(firewallapi.IPv6)
+	defer func(get func(firewallapi.Family) (firewallapi.Firewall, error)) { getFirewall = get }(getFirewall)
+	getFirewall = func(family firewallapi.Family) (firewallapi.Firewall, error) {
+		if family == firewallapi.IPv6 {
+			return v6, nil
+		}
+		return v4, nil
+	}
+
+	d := newDriver()
+	d.config = &configuration{EnableIPTables: true}
+	n := &bridgeNetwork{id: "n1", config: &networkConfiguration{BridgeName: "br0"}, driver: d}
+	d.networks["n1"] = n
+
+	if err := d.SetNetworkFirewallRules("n1", "audit", []driverapi.FirewallRule{{Raw: "-s 10.0.0.0/8 -j DROP"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetNetworkFirewallRules("n1", "v6", []driverapi.FirewallRule{{IPv6: true, Args: []string{"-j", "DROP"}}}); err == nil {
+		t.Fatal("Expected the rules of the disabled ip6tables to be rejected")
+	}
+	if err := d.SetNetworkFirewallRules("n1", "raw", []driverapi.FirewallRule{{Table: "raw", Args: []string{"-j", "DROP"}}}); err == nil {
+		t.Fatal("Expected the rules of an unsupported table to be rejected")
+	}
+
+	expected := []string{
+		"-t filter -N DOCKER-NEW-br0",
+		"-t filter -A DOCKER-NEW-br0 -s 10.0.0.0/8 -j DROP",
+		"-t filter -I FORWARD -i br0 -j DOCKER-NEW-br0",
+		"-t filter -I FORWARD -o br0 -j DOCKER-NEW-br0",
+		"-t filter -E DOCKER-NEW-br0 DOCKER-NET-br0",
+	}
+	if !reflect.DeepEqual(v4.cmds, expected) {
+		t.Fatalf("Expected the commands %q, got %q", expected, v4.cmds)
+	}
+	if len(v6.cmds) != 0 {
+		t.Fatalf("Expected no ip6tables commands, got %q", v6.cmds)
+	}
+
+	rules, err := d.NetworkFirewallRules("n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := rules["audit"]; len(r) != 1 || r[0].Table != "filter" || strings.Join(r[0].Args, " ") != "-s 10.0.0.0/8 -j DROP" {
+		t.Fatalf("Unexpected rules %v", rules)
+	}
+
+	// The jumps are swapped to the chain of the new rules, in place
+	v4.chains["filter FORWARD"] = append([]string{"-j DOCKER-USER"}, v4.chains["filter FORWARD"]...)
+	v4.cmds = nil
+	if err := d.SetNetworkFirewallRules("n1", "audit", []driverapi.FirewallRule{{Raw: "-s 10.0.0.0/16 -j DROP"}}); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{
+		"-t filter -N DOCKER-NEW-br0",
+		"-t filter -A DOCKER-NEW-br0 -s 10.0.0.0/16 -j DROP",
+		"-t filter -R FORWARD 3 -i br0 -j DOCKER-NEW-br0",
+		"-t filter -R FORWARD 2 -o br0 -j DOCKER-NEW-br0",
+		"-t filter -F DOCKER-NET-br0",
+		"-t filter -X DOCKER-NET-br0",
+		"-t filter -E DOCKER-NEW-br0 DOCKER-NET-br0",
+	}
+	if !reflect.DeepEqual(v4.cmds, expected) {
+		t.Fatalf("Expected the commands %q, got %q", expected, v4.cmds)
+	}
+	expected = []string{"-j DOCKER-USER", "-o br0 -j DOCKER-NET-br0", "-i br0 -j DOCKER-NET-br0"}
+	if !reflect.DeepEqual(v4.chains["filter FORWARD"], expected) {
+		t.Fatalf("Expected the jumps %q, got %q", expected, v4.chains["filter FORWARD"])
+	}
+	if !reflect.DeepEqual(v4.chains["filter DOCKER-NET-br0"], []string{"-s 10.0.0.0/16 -j DROP"}) {
+		t.Fatalf("Unexpected rules %q", v4.chains["filter DOCKER-NET-br0"])
+	}
+
+	// A flush of the chain is repaired when the rules are re-applied
+	v4.chains["filter FORWARD"] = []string{"-o br0 -j DOCKER-NET-br0"}
+	n.reapplyFirewallRules(true, false)
+	if !v4.Exists("filter", "FORWARD", "-i", "br0", "-j", "DOCKER-NET-br0") {
+		t.Fatalf("Expected the jump to be re-applied, got %q", v4.chains["filter FORWARD"])
+	}
+
+	// A failure leaves the rules in place
+	v4.fail = "DNAT"
+	if err := d.SetNetworkFirewallRules("n1", "bad", []driverapi.FirewallRule{{Table: "nat", Raw: "-j DNAT"}}); err == nil {
+		t.Fatal("Expected the rules of the failing table to be rejected")
+	}
+	v4.fail = ""
+	if !reflect.DeepEqual(v4.chains["filter DOCKER-NET-br0"], []string{"-s 10.0.0.0/16 -j DROP"}) {
+		t.Fatalf("Expected the rules to be kept, got %q", v4.chains["filter DOCKER-NET-br0"])
+	}
+	for _, c := range v4.userChains() {
+		if c != "filter DOCKER-NET-br0" {
+			t.Fatalf("Expected the chains of the failed rules to be removed, got %q", v4.userChains())
+		}
+	}
+
+	n.removeFirewallRules()
+	if chains := v4.userChains(); len(chains) != 0 || len(v4.chains["filter FORWARD"]) != 0 {
+		t.Fatalf("Expected the chain and its jumps to be removed, got %q and %q", chains, v4.chains["filter FORWARD"])
+	}
+}
@@ -15,7 +15,7 @@ var (
 	defaultBindingIP = net.IPv4(0, 0, 0, 0)
 )
 
-func (n *bridgeNetwork) allocatePorts(ep *bridgeEndpoint, reqDefBindIP net.IP, ulPxyEnabled bool) ([]types.PortBinding, error) {
+func (n *bridgeNetwork) allocatePorts(log *logrus.Entry, ep *bridgeEndpoint, reqDefBindIP net.IP, ulPxyEnabled bool) ([]types.PortBinding, error) {
 	if ep.extConnConfig == nil || ep.extConnConfig.PortBindings == nil {
 		return nil, nil
 	}
@@ -26,19 +26,19 @@ func (n *bridgeNetwork) allocatePorts(ep *bridgeEndpoint, reqDefBindIP net.IP, u
 	}
 
 	if ep.addrv6 != nil {
-		return n.allocatePortsInternal(ep.extConnConfig.PortBindings, ep.addr.IP, ep.addrv6.IP, defHostIP, ulPxyEnabled)
+		return n.allocatePortsInternal(log, ep.extConnConfig.PortBindings, ep.addr.IP, ep.addrv6.IP, defHostIP, ulPxyEnabled)
 	}
-	return n.allocatePortsInternal(ep.extConnConfig.PortBindings, ep.addr.IP, nil, defHostIP, ulPxyEnabled)
+	return n.allocatePortsInternal(log, ep.extConnConfig.PortBindings, ep.addr.IP, nil, defHostIP, ulPxyEnabled)
 }
 
-func (n *bridgeNetwork) allocatePortsInternal(bindings []types.PortBinding, containerIP, containerIPv6, defHostIP net.IP, ulPxyEnabled bool) ([]types.PortBinding, error) {
+func (n *bridgeNetwork) allocatePortsInternal(log *logrus.Entry, bindings []types.PortBinding, containerIP, containerIPv6, defHostIP net.IP, ulPxyEnabled bool) ([]types.PortBinding, error) {
 	bs := make([]types.PortBinding, 0, len(bindings))
 	for _, c := range bindings {
 		b := c.GetCopy()
-		if err := n.allocatePort(&b, containerIP, containerIPv6, defHostIP, ulPxyEnabled); err != nil {
+		if err := n.allocatePort(log, &b, containerIP, containerIPv6, defHostIP, ulPxyEnabled); err != nil {
 			// On allocation failure, release previously allocated ports. On cleanup error, just log a warning message
 			if cuErr := n.releasePortsInternal(bs); cuErr != nil {
-				logrus.Warnf("Upon allocation failure for %v, failed to clear previously allocated port bindings: %v", b, cuErr)
+				log.Warnf("Upon allocation failure for %v, failed to clear previously allocated port bindings: %v", b, cuErr)
 			}
 			return nil, err
 		}
@@ -47,7 +47,7 @@ func (n *bridgeNetwork) allocatePortsInternal(bindings []types.PortBinding, cont
 	return bs, nil
 }
 
-func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, containerIPv6, defHostIP net.IP, ulPxyEnabled bool) error {
+func (n *bridgeNetwork) allocatePort(log *logrus.Entry, bnd *types.PortBinding, containerIP, containerIPv6, defHostIP net.IP, ulPxyEnabled bool) error {
 	var (
 		host net.Addr
 		err  error
@@ -82,14 +82,15 @@ func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, contai
 		}
 		// There is no point in immediately retrying to map an explicitly chosen port.
 		if bnd.HostPort != 0 {
-			logrus.Warnf("Failed to allocate and map port %d-%d: %s", bnd.HostPort, bnd.HostPortEnd, err)
+			log.Warnf("Failed to allocate and map port %d-%d: %s", bnd.HostPort, bnd.HostPortEnd, err)
 			break
 		}
-		logrus.Warnf("Failed to allocate and map port: %s, retry: %d", err, i+1)
+		log.Warnf("Failed to allocate and map port: %s, retry: %d", err, i+1)
 	}
 	if err != nil {
 		return err
 	}
+	log.Debugf("Mapped host address %s/%s to %s", host, bnd.Proto.String(), container)
 
 	// Save the host port (regardless it was or not specified in the binding)
 	switch netAddr := host.(type) {
@@ -102,6 +103,9 @@ func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, contai
 	case *sctp.SCTPAddr:
 		bnd.HostPort = uint16(host.(*sctp.SCTPAddr).Port)
 		return nil
+	case *types.DCCPAddr:
+		bnd.HostPort = uint16(netAddr.Port)
+		return nil
 	default:
 		// For completeness
 		return ErrUnsupportedAddressType(fmt.Sprintf("%T", netAddr))
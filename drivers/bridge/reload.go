@@ -0,0 +1,98 @@
+package bridge
+
+import (
+	"sort"
+
+	"github.com/docker/libnetwork/ip6tables"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/rootless"
+	"github.com/sirupsen/logrus"
+)
+
+// ReloadConfig applies a changed driver configuration. The firewall and
+// userland proxy settings apply to the networks created from now on, the
+// existing networks are programmed with the previous ones until they are
+// recreated.
+func (d *driver) ReloadConfig(option map[string]interface{}) (map[string][]string, error) {
+	config, err := parseDriverConfig(option)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &configuration{}
+	}
+	if isRootless, _ := option[netlabel.Rootless].(bool); isRootless && !config.EnableUserlandProxy {
+		return nil, rootless.UnsupportedError("disabling the userland proxy")
+	}
+
+	d.configNetwork.Lock()
+	defer d.configNetwork.Unlock()
+
+	d.Lock()
+	old := d.config
+	d.Unlock()
+
+	var changed []string
+	if config.EnableIPTables != old.EnableIPTables {
+		changed = append(changed, "iptables")
+	}
+	if config.EnableIP6Tables != old.EnableIP6Tables {
+		changed = append(changed, "ip6tables")
+	}
+	if config.EnableUserlandProxy != old.EnableUserlandProxy || config.UserlandProxyPath != old.UserlandProxyPath {
+		changed = append(changed, "userland-proxy")
+	}
+	if len(changed) == 0 && config.EnableIPForwarding == old.EnableIPForwarding {
+		return nil, nil
+	}
+
+	if config.EnableIPTables && !old.EnableIPTables {
+		natChain, filterChain, isolationChain1, isolationChain2, err := setupIPChains(config)
+		if err != nil {
+			return nil, err
+		}
+		iptables.OnReloaded(func() { logrus.Debugf("Recreating iptables chains on firewall reload"); setupIPChains(config) })
+		watchIPChains(config)
+		d.Lock()
+		d.natChain, d.filterChain = natChain, filterChain
+		d.isolationChain1, d.isolationChain2 = isolationChain1, isolationChain2
+		d.Unlock()
+	}
+	if config.EnableIP6Tables && !old.EnableIP6Tables {
+		natChain, filterChain, isolationChain1, isolationChain2, err := setupIP6Chains(config)
+		if err != nil {
+			return nil, err
+		}
+		ip6tables.OnReloaded(func() { logrus.Debugf("Recreating ip6tables chains on firewall reload"); setupIP6Chains(config) })
+		d.Lock()
+		d.ip6tNatChain, d.ip6tFilterChain = natChain, filterChain
+		d.ip6tIsolationChain1, d.ip6tIsolationChain2 = isolationChain1, isolationChain2
+		d.Unlock()
+	}
+	// Disabling the forwarding leaves it enabled on the host, the networks
+	// of the other drivers may depend on it
+	if config.EnableIPForwarding && !old.EnableIPForwarding {
+		if err := setupIPForwarding(config.EnableIPTables); err != nil {
+			return nil, err
+		}
+	}
+
+	d.Lock()
+	d.config = config
+	nids := make([]string, 0, len(d.networks))
+	for id := range d.networks {
+		nids = append(nids, id)
+	}
+	d.Unlock()
+	sort.Strings(nids)
+
+	result := map[string][]string{}
+	if config.EnableIPForwarding != old.EnableIPForwarding {
+		result["ip-forward"] = nil
+	}
+	for _, s := range changed {
+		result[s] = nids
+	}
+	return result, nil
+}
@@ -0,0 +1,46 @@
+package bridge
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/testutils"
+)
+
+func TestReloadConfig(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+	d := newDriver()
+
+	netconfig := &networkConfiguration{BridgeName: DefaultBridgeName}
+	genericOption := map[string]interface{}{netlabel.GenericData: netconfig}
+	if err := d.CreateNetwork("dummy", genericOption, nil, getIPv4Data(t, ""), nil); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	changed, err := d.ReloadConfig(map[string]interface{}{netlabel.GenericData: &configuration{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 0 {
+		t.Fatalf("expected no changes, got %v", changed)
+	}
+
+	config := &configuration{EnableUserlandProxy: true, UserlandProxyPath: "/bin/docker-proxy"}
+	changed, err = d.ReloadConfig(map[string]interface{}{netlabel.GenericData: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string][]string{"userland-proxy": {"dummy"}}; !reflect.DeepEqual(changed, expected) {
+		t.Fatalf("expected %v, got %v", expected, changed)
+	}
+	if d.config != config {
+		t.Fatal("expected the reloaded configuration to be in use")
+	}
+
+	if _, err := d.ReloadConfig(map[string]interface{}{netlabel.GenericData: &configuration{}, netlabel.Rootless: true}); err == nil {
+		t.Fatal("expected the userland proxy to be required in rootless mode")
+	}
+}
@@ -1,6 +1,9 @@
 package bridge
 
-import "github.com/docker/libnetwork/iptables"
+import (
+	"github.com/docker/libnetwork/ip6tables"
+	"github.com/docker/libnetwork/iptables"
+)
 
 func (n *bridgeNetwork) setupFirewalld(config *networkConfiguration, i *bridgeInterface) error {
 	d := n.driver
@@ -9,12 +12,20 @@ func (n *bridgeNetwork) setupFirewalld(config *networkConfiguration, i *bridgeIn
 	d.Unlock()
 
 	// Sanity check.
-	if !driverConfig.EnableIPTables {
+	if !driverConfig.EnableIPTables && !driverConfig.EnableIP6Tables {
 		return IPTableCfgError(config.BridgeName)
 	}
 
-	iptables.OnReloaded(func() { n.setupIPTables(config, i) })
-	iptables.OnReloaded(n.portMapper.ReMapAll)
+	// firewalld reloads the iptables and ip6tables rules apart, the
+	// callbacks of each family re-apply its own rules
+	if driverConfig.EnableIPTables {
+		iptables.OnReloaded(func() { n.setupIPTables(config, i) })
+		iptables.OnReloaded(n.portMapper.ReMapIPv4)
+	}
+	if driverConfig.EnableIP6Tables && config.AddressIPv6 != nil {
+		ip6tables.OnReloaded(func() { n.setupIP6Tables(config, i) })
+		ip6tables.OnReloaded(n.portMapper.ReMapIPv6)
+	}
 
 	return nil
 }
@@ -113,37 +113,38 @@ func (n *bridgeNetwork) setupIP6Tables(config *networkConfiguration, i *bridgeIn
 		IP:   i.bridgeIPv6.IP.Mask(i.bridgeIPv6.Mask),
 		Mask: i.bridgeIPv6.Mask,
 	}
+	owner := networkOwner(n.id)
 	if config.Internal {
-		if err = setupInternalNetworkRules(config.BridgeName, maskedAddrv6, config.EnableICC, true); err != nil {
+		if err = setupInternalNetworkRules(config.BridgeName, maskedAddrv6, owner, config.EnableICC, true); err != nil {
 			return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
 		}
 		n.registerIptCleanFunc(func() error {
-			return setupInternalNetworkRules(config.BridgeName, maskedAddrv6, config.EnableICC, false)
+			return setupInternalNetworkRules(config.BridgeName, maskedAddrv6, owner, config.EnableICC, false)
 		})
 	} else {
-		if err = setupIP6TablesInternal(config.BridgeName, maskedAddrv6, config.EnableICC, config.EnableIPMasquerade, hairpinMode, true); err != nil {
+		if err = setupIP6TablesInternal(config.BridgeName, maskedAddrv6, owner, config.EnableICC, config.EnableIPMasquerade, hairpinMode, true); err != nil {
 			return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
 		}
 		n.registerIptCleanFunc(func() error {
-			return setupIP6TablesInternal(config.BridgeName, maskedAddrv6, config.EnableICC, config.EnableIPMasquerade, hairpinMode, false)
+			return setupIP6TablesInternal(config.BridgeName, maskedAddrv6, owner, config.EnableICC, config.EnableIPMasquerade, hairpinMode, false)
 		})
 		ip6tNatChain, ip6tFilterChain, _, _, err := n.getIP6DriverChains()
 		if err != nil {
 			return fmt.Errorf("Failed to setup IP tables, cannot acquire chain info %s", err.Error())
 		}
 
-		err = ip6tables.ProgramChain(ip6tNatChain, config.BridgeName, hairpinMode, true)
+		err = ip6tables.ProgramChain(ip6tNatChain, config.BridgeName, owner, hairpinMode, true)
 		if err != nil {
 			return fmt.Errorf("Failed to program NAT chain: %s", err.Error())
 		}
 
-		err = ip6tables.ProgramChain(ip6tFilterChain, config.BridgeName, hairpinMode, true)
+		err = ip6tables.ProgramChain(ip6tFilterChain, config.BridgeName, owner, hairpinMode, true)
 		if err != nil {
 			return fmt.Errorf("Failed to program FILTER chain: %s", err.Error())
 		}
 
 		n.registerIP6tCleanFunc(func() error {
-			return ip6tables.ProgramChain(ip6tFilterChain, config.BridgeName, hairpinMode, false)
+			return ip6tables.ProgramChain(ip6tFilterChain, config.BridgeName, owner, hairpinMode, false)
 		})
 
 		n.portMapper.SetIP6tablesChain(ip6tNatChain, n.getNetworkBridgeName())
@@ -166,14 +167,14 @@ type ip6tRule struct {
 	args    []string
 }
 
-func setupIP6TablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, hairpin, enable bool) error {
+func setupIP6TablesInternal(bridgeIface string, addr net.Addr, owner string, icc, ipmasq, hairpin, enable bool) error {
 
 	var (
 		address   = addr.String()
-		natRule   = ip6tRule{table: ip6tables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", address, "!", "-o", bridgeIface, "-j", "MASQUERADE"}}
-		hpNatRule = ip6tRule{table: ip6tables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-m", "addrtype", "--src-type", "LOCAL", "-o", bridgeIface, "-j", "MASQUERADE"}}
-		skipDNAT  = ip6tRule{table: ip6tables.Nat, chain: ip6tDockerChain, preArgs: []string{"-t", "nat"}, args: []string{"-i", bridgeIface, "-j", "RETURN"}}
-		outRule   = ip6tRule{table: ip6tables.Filter, chain: "FORWARD", args: []string{"-i", bridgeIface, "!", "-o", bridgeIface, "-j", "ACCEPT"}}
+		natRule   = ip6tRule{table: ip6tables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: ip6tables.OwnedArgs(owner, []string{"-s", address, "!", "-o", bridgeIface, "-j", "MASQUERADE"})}
+		hpNatRule = ip6tRule{table: ip6tables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: ip6tables.OwnedArgs(owner, []string{"-m", "addrtype", "--src-type", "LOCAL", "-o", bridgeIface, "-j", "MASQUERADE"})}
+		skipDNAT  = ip6tRule{table: ip6tables.Nat, chain: ip6tDockerChain, preArgs: []string{"-t", "nat"}, args: ip6tables.OwnedArgs(owner, []string{"-i", bridgeIface, "-j", "RETURN"})}
+		outRule   = ip6tRule{table: ip6tables.Filter, chain: "FORWARD", args: ip6tables.OwnedArgs(owner, []string{"-i", bridgeIface, "!", "-o", bridgeIface, "-j", "ACCEPT"})}
 	)
 
 	// Set NAT.
@@ -197,7 +198,7 @@ func setupIP6TablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, hair
 	}
 
 	// Set Inter Container Communication.
-	if err := setIP6Icc(bridgeIface, icc, enable); err != nil {
+	if err := setIP6Icc(bridgeIface, owner, icc, enable); err != nil {
 		return err
 	}
 
@@ -235,13 +236,12 @@ func programIP6ChainRule(rule ip6tRule, ruleDescr string, insert bool) error {
 	return nil
 }
 
-func setIP6Icc(bridgeIface string, iccEnable, insert bool) error {
+func setIP6Icc(bridgeIface, owner string, iccEnable, insert bool) error {
 	var (
 		table      = ip6tables.Filter
 		chain      = "FORWARD"
-		args       = []string{"-i", bridgeIface, "-o", bridgeIface, "-j"}
-		acceptArgs = append(args, "ACCEPT")
-		dropArgs   = append(args, "DROP")
+		acceptArgs = ip6tables.OwnedArgs(owner, []string{"-i", bridgeIface, "-o", bridgeIface, "-j", "ACCEPT"})
+		dropArgs   = ip6tables.OwnedArgs(owner, []string{"-i", bridgeIface, "-o", bridgeIface, "-j", "DROP"})
 	)
 
 	if insert {
@@ -279,14 +279,14 @@ func setIP6Icc(bridgeIface string, iccEnable, insert bool) error {
 }
 
 // Control Inter Network Communication. Install[Remove] only if it is [not] present.
-func setIP6INC(iface string, enable bool) error {
+func setIP6INC(iface, owner string, enable bool) error {
 	var (
 		action    = ip6tables.Insert
 		actionMsg = "add"
 		chains    = []string{IsolationChain1, IsolationChain2}
 		rules     = [][]string{
-			{"-i", iface, "!", "-o", iface, "-j", IsolationChain2},
-			{"-o", iface, "-j", "DROP"},
+			ip6tables.OwnedArgs(owner, []string{"-i", iface, "!", "-o", iface, "-j", IsolationChain2}),
+			ip6tables.OwnedArgs(owner, []string{"-o", iface, "-j", "DROP"}),
 		}
 	)
 
@@ -335,10 +335,10 @@ func removeIP6Chains() {
 	}
 }
 
-func setupIP6InternalNetworkRules(bridgeIface string, addr net.Addr, icc, insert bool) error {
+func setupIP6InternalNetworkRules(bridgeIface string, addr net.Addr, owner string, icc, insert bool) error {
 	var (
-		inDropRule  = ip6tRule{table: ip6tables.Filter, chain: IsolationChain1, args: []string{"-i", bridgeIface, "!", "-d", addr.String(), "-j", "DROP"}}
-		outDropRule = ip6tRule{table: ip6tables.Filter, chain: IsolationChain1, args: []string{"-o", bridgeIface, "!", "-s", addr.String(), "-j", "DROP"}}
+		inDropRule  = ip6tRule{table: ip6tables.Filter, chain: IsolationChain1, args: ip6tables.OwnedArgs(owner, []string{"-i", bridgeIface, "!", "-d", addr.String(), "-j", "DROP"})}
+		outDropRule = ip6tRule{table: ip6tables.Filter, chain: IsolationChain1, args: ip6tables.OwnedArgs(owner, []string{"-o", bridgeIface, "!", "-s", addr.String(), "-j", "DROP"})}
 	)
 	if err := programIP6ChainRule(inDropRule, "DROP INCOMING", insert); err != nil {
 		return err
@@ -347,7 +347,7 @@ func setupIP6InternalNetworkRules(bridgeIface string, addr net.Addr, icc, insert
 		return err
 	}
 	// Set Inter Container Communication.
-	return setIcc(bridgeIface, icc, insert)
+	return setIcc(bridgeIface, owner, icc, insert)
 }
 
 func clearIP6EndpointConnections(nlh *netlink.Handle, ep *bridgeEndpoint) {
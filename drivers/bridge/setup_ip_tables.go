@@ -113,37 +113,38 @@ func (n *bridgeNetwork) setupIPTables(config *networkConfiguration, i *bridgeInt
 		IP:   i.bridgeIPv4.IP.Mask(i.bridgeIPv4.Mask),
 		Mask: i.bridgeIPv4.Mask,
 	}
+	owner := networkOwner(n.id)
 	if config.Internal {
-		if err = setupInternalNetworkRules(config.BridgeName, maskedAddrv4, config.EnableICC, true); err != nil {
+		if err = setupInternalNetworkRules(config.BridgeName, maskedAddrv4, owner, config.EnableICC, true); err != nil {
 			return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
 		}
 		n.registerIptCleanFunc(func() error {
-			return setupInternalNetworkRules(config.BridgeName, maskedAddrv4, config.EnableICC, false)
+			return setupInternalNetworkRules(config.BridgeName, maskedAddrv4, owner, config.EnableICC, false)
 		})
 	} else {
-		if err = setupIPTablesInternal(config.BridgeName, maskedAddrv4, config.EnableICC, config.EnableIPMasquerade, hairpinMode, true); err != nil {
+		if err = setupIPTablesInternal(config.BridgeName, maskedAddrv4, owner, config.EnableICC, config.EnableIPMasquerade, hairpinMode, true); err != nil {
 			return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
 		}
 		n.registerIptCleanFunc(func() error {
-			return setupIPTablesInternal(config.BridgeName, maskedAddrv4, config.EnableICC, config.EnableIPMasquerade, hairpinMode, false)
+			return setupIPTablesInternal(config.BridgeName, maskedAddrv4, owner, config.EnableICC, config.EnableIPMasquerade, hairpinMode, false)
 		})
 		natChain, filterChain, _, _, err := n.getDriverChains()
 		if err != nil {
 			return fmt.Errorf("Failed to setup IP tables, cannot acquire chain info %s", err.Error())
 		}
 
-		err = iptables.ProgramChain(natChain, config.BridgeName, hairpinMode, true)
+		err = iptables.ProgramChain(natChain, config.BridgeName, owner, hairpinMode, true)
 		if err != nil {
 			return fmt.Errorf("Failed to program NAT chain: %s", err.Error())
 		}
 
-		err = iptables.ProgramChain(filterChain, config.BridgeName, hairpinMode, true)
+		err = iptables.ProgramChain(filterChain, config.BridgeName, owner, hairpinMode, true)
 		if err != nil {
 			return fmt.Errorf("Failed to program FILTER chain: %s", err.Error())
 		}
 
 		n.registerIptCleanFunc(func() error {
-			return iptables.ProgramChain(filterChain, config.BridgeName, hairpinMode, false)
+			return iptables.ProgramChain(filterChain, config.BridgeName, owner, hairpinMode, false)
 		})
 
 		n.portMapper.SetIptablesChain(natChain, n.getNetworkBridgeName())
@@ -156,9 +157,60 @@ func (n *bridgeNetwork) setupIPTables(config *networkConfiguration, i *bridgeInt
 		return err
 	}
 
+	n.watchIPTables(config, i, hairpinMode)
 	return nil
 }
 
+// watchIPChains makes the iptables reconciler repair the chains of the
+// driver when they are removed or flushed
+func watchIPChains(config *configuration) {
+	checks := []iptables.Rule{
+		{Table: iptables.Nat, Chain: DockerChain},
+		{Table: iptables.Filter, Chain: DockerChain},
+		{Table: iptables.Filter, Chain: IsolationChain1},
+		{Table: iptables.Filter, Chain: IsolationChain2},
+		{Table: iptables.Filter, Chain: IsolationChain1, Args: []string{"-j", "RETURN"}},
+		{Table: iptables.Filter, Chain: IsolationChain2, Args: []string{"-j", "RETURN"}},
+	}
+	iptables.Watch("bridge", checks, func([]iptables.Rule) error {
+		_, _, _, _, err := setupIPChains(config)
+		return err
+	})
+}
+
+// watchIPTables makes the iptables reconciler repair the rules of the
+// network and of its port mappings, when the rules of the network or the
+// jumps to its chains are removed. The chains of the driver, watched as
+// "bridge", are repaired first.
+func (n *bridgeNetwork) watchIPTables(config *networkConfiguration, i *bridgeInterface, hairpinMode bool) {
+	var checks []iptables.Rule
+	for _, r := range n.iptablesRules(hairpinMode) {
+		checks = append(checks, iptables.Rule{Table: r.table, Chain: r.chain, Args: r.args})
+	}
+	if !config.Internal {
+		if natChain, filterChain, _, _, err := n.getDriverChains(); err == nil {
+			owner := networkOwner(n.id)
+			checks = append(checks, natChain.JumpRules(config.BridgeName, owner, hairpinMode)...)
+			checks = append(checks, filterChain.JumpRules(config.BridgeName, owner, hairpinMode)...)
+		}
+	}
+	checks = append(checks, iptables.Rule{Table: iptables.Filter, Chain: "FORWARD", Args: []string{"-j", IsolationChain1}})
+
+	iptables.Watch("network:"+n.id, checks, func([]iptables.Rule) error {
+		if err := n.setupIPTables(config, i); err != nil {
+			return err
+		}
+		n.portMapper.ReMapIPv4()
+		return nil
+	})
+}
+
+// networkOwner returns the owner the firewall rules of the network are
+// tagged with, when the rules are tagged
+func networkOwner(nid string) string {
+	return "network:" + nid
+}
+
 type iptRule struct {
 	table   iptables.Table
 	chain   string
@@ -166,43 +218,51 @@ type iptRule struct {
 	args    []string
 }
 
-func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, hairpin, enable bool) error {
+// networkRules are the rules programmed for a network which is not internal
+type networkRules struct {
+	nat, hpNat, skipDNAT, out iptRule
+}
 
-	var (
-		address   = addr.String()
-		natRule   = iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-s", address, "!", "-o", bridgeIface, "-j", "MASQUERADE"}}
-		hpNatRule = iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: []string{"-m", "addrtype", "--src-type", "LOCAL", "-o", bridgeIface, "-j", "MASQUERADE"}}
-		skipDNAT  = iptRule{table: iptables.Nat, chain: DockerChain, preArgs: []string{"-t", "nat"}, args: []string{"-i", bridgeIface, "-j", "RETURN"}}
-		outRule   = iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeIface, "!", "-o", bridgeIface, "-j", "ACCEPT"}}
-	)
+func newNetworkRules(bridgeIface string, addr net.Addr, owner string) networkRules {
+	address := addr.String()
+	return networkRules{
+		nat:      iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: iptables.OwnedArgs(owner, []string{"-s", address, "!", "-o", bridgeIface, "-j", "MASQUERADE"})},
+		hpNat:    iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"}, args: iptables.OwnedArgs(owner, []string{"-m", "addrtype", "--src-type", "LOCAL", "-o", bridgeIface, "-j", "MASQUERADE"})},
+		skipDNAT: iptRule{table: iptables.Nat, chain: DockerChain, preArgs: []string{"-t", "nat"}, args: iptables.OwnedArgs(owner, []string{"-i", bridgeIface, "-j", "RETURN"})},
+		out:      iptRule{table: iptables.Filter, chain: "FORWARD", args: iptables.OwnedArgs(owner, []string{"-i", bridgeIface, "!", "-o", bridgeIface, "-j", "ACCEPT"})},
+	}
+}
+
+func setupIPTablesInternal(bridgeIface string, addr net.Addr, owner string, icc, ipmasq, hairpin, enable bool) error {
+	rules := newNetworkRules(bridgeIface, addr, owner)
 
 	// Set NAT.
 	if ipmasq {
-		if err := programChainRule(natRule, "NAT", enable); err != nil {
+		if err := programChainRule(rules.nat, "NAT", enable); err != nil {
 			return err
 		}
 	}
 
 	if ipmasq && !hairpin {
-		if err := programChainRule(skipDNAT, "SKIP DNAT", enable); err != nil {
+		if err := programChainRule(rules.skipDNAT, "SKIP DNAT", enable); err != nil {
 			return err
 		}
 	}
 
 	// In hairpin mode, masquerade traffic from localhost
 	if hairpin {
-		if err := programChainRule(hpNatRule, "MASQ LOCAL HOST", enable); err != nil {
+		if err := programChainRule(rules.hpNat, "MASQ LOCAL HOST", enable); err != nil {
 			return err
 		}
 	}
 
 	// Set Inter Container Communication.
-	if err := setIcc(bridgeIface, icc, enable); err != nil {
+	if err := setIcc(bridgeIface, owner, icc, enable); err != nil {
 		return err
 	}
 
 	// Set Accept on all non-intercontainer outgoing packets.
-	return programChainRule(outRule, "ACCEPT NON_ICC OUTGOING", enable)
+	return programChainRule(rules.out, "ACCEPT NON_ICC OUTGOING", enable)
 }
 
 func programChainRule(rule iptRule, ruleDescr string, insert bool) error {
@@ -235,13 +295,22 @@ func programChainRule(rule iptRule, ruleDescr string, insert bool) error {
 	return nil
 }
 
-func setIcc(bridgeIface string, iccEnable, insert bool) error {
+// iccRule is the rule accepting, or dropping, the traffic between the
+// containers of the network
+func iccRule(bridgeIface, owner string, iccEnable bool) iptRule {
+	target := "DROP"
+	if iccEnable {
+		target = "ACCEPT"
+	}
+	return iptRule{table: iptables.Filter, chain: "FORWARD", args: iptables.OwnedArgs(owner, []string{"-i", bridgeIface, "-o", bridgeIface, "-j", target})}
+}
+
+func setIcc(bridgeIface, owner string, iccEnable, insert bool) error {
 	var (
 		table      = iptables.Filter
 		chain      = "FORWARD"
-		args       = []string{"-i", bridgeIface, "-o", bridgeIface, "-j"}
-		acceptArgs = append(args, "ACCEPT")
-		dropArgs   = append(args, "DROP")
+		acceptArgs = iccRule(bridgeIface, owner, true).args
+		dropArgs   = iccRule(bridgeIface, owner, false).args
 	)
 
 	if insert {
@@ -278,16 +347,21 @@ func setIcc(bridgeIface string, iccEnable, insert bool) error {
 	return nil
 }
 
+// incRules are the rules of the isolation chains for the network
+func incRules(iface, owner string) [][]string {
+	return [][]string{
+		iptables.OwnedArgs(owner, []string{"-i", iface, "!", "-o", iface, "-j", IsolationChain2}),
+		iptables.OwnedArgs(owner, []string{"-o", iface, "-j", "DROP"}),
+	}
+}
+
 // Control Inter Network Communication. Install[Remove] only if it is [not] present.
-func setINC(iface string, enable bool) error {
+func setINC(iface, owner string, enable bool) error {
 	var (
 		action    = iptables.Insert
 		actionMsg = "add"
 		chains    = []string{IsolationChain1, IsolationChain2}
-		rules     = [][]string{
-			{"-i", iface, "!", "-o", iface, "-j", IsolationChain2},
-			{"-o", iface, "-j", "DROP"},
-		}
+		rules     = incRules(iface, owner)
 	)
 
 	if !enable {
@@ -335,11 +409,16 @@ func removeIPChains() {
 	}
 }
 
-func setupInternalNetworkRules(bridgeIface string, addr net.Addr, icc, insert bool) error {
-	var (
-		inDropRule  = iptRule{table: iptables.Filter, chain: IsolationChain1, args: []string{"-i", bridgeIface, "!", "-d", addr.String(), "-j", "DROP"}}
-		outDropRule = iptRule{table: iptables.Filter, chain: IsolationChain1, args: []string{"-o", bridgeIface, "!", "-s", addr.String(), "-j", "DROP"}}
-	)
+// internalNetworkRules are the rules dropping the traffic leaving an
+// internal network
+func internalNetworkRules(bridgeIface string, addr net.Addr, owner string) (inDropRule, outDropRule iptRule) {
+	inDropRule = iptRule{table: iptables.Filter, chain: IsolationChain1, args: iptables.OwnedArgs(owner, []string{"-i", bridgeIface, "!", "-d", addr.String(), "-j", "DROP"})}
+	outDropRule = iptRule{table: iptables.Filter, chain: IsolationChain1, args: iptables.OwnedArgs(owner, []string{"-o", bridgeIface, "!", "-s", addr.String(), "-j", "DROP"})}
+	return inDropRule, outDropRule
+}
+
+func setupInternalNetworkRules(bridgeIface string, addr net.Addr, owner string, icc, insert bool) error {
+	inDropRule, outDropRule := internalNetworkRules(bridgeIface, addr, owner)
 	if err := programChainRule(inDropRule, "DROP INCOMING", insert); err != nil {
 		return err
 	}
@@ -347,7 +426,7 @@ func setupInternalNetworkRules(bridgeIface string, addr net.Addr, icc, insert bo
 		return err
 	}
 	// Set Inter Container Communication.
-	return setIcc(bridgeIface, icc, insert)
+	return setIcc(bridgeIface, owner, icc, insert)
 }
 
 func clearEndpointConnections(nlh *netlink.Handle, ep *bridgeEndpoint) {
@@ -2,6 +2,7 @@ package bridge
 
 import (
 	"net"
+	"reflect"
 	"testing"
 
 	"github.com/docker/libnetwork/iptables"
@@ -134,3 +135,43 @@ func assertBridgeConfig(config *networkConfiguration, br *bridgeInterface, d *dr
 		t.Fatalf("%v", err)
 	}
 }
+
+func TestBridgeRules(t *testing.T) {
+	raw := func(args ...string) ([]byte, error) {
+		if args[1] == "nat" {
+			return []byte("-P PREROUTING ACCEPT\n-A POSTROUTING -s 172.18.0.0/16 ! -o br-test -j MASQUERADE\n-A DOCKER -i br-test0 -j RETURN\n"), nil
+		}
+		return []byte("-A FORWARD -i br-test ! -o br-test -j ACCEPT\n"), nil
+	}
+	rules, err := bridgeRules(raw, "br-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"-t nat -A POSTROUTING -s 172.18.0.0/16 ! -o br-test -j MASQUERADE",
+		"-t filter -A FORWARD -i br-test ! -o br-test -j ACCEPT",
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("unexpected rules %q", rules)
+	}
+}
+
+func TestIptablesRules(t *testing.T) {
+	_, addr, _ := net.ParseCIDR("172.18.0.1/16")
+	n := &bridgeNetwork{
+		config: &networkConfiguration{BridgeName: "br-test", EnableIPMasquerade: true, EnableICC: true},
+		bridge: &bridgeInterface{bridgeIPv4: &net.IPNet{IP: net.ParseIP("172.18.0.1"), Mask: addr.Mask}},
+	}
+	if rules := n.iptablesRules(false); len(rules) != 6 {
+		t.Fatalf("expected 6 rules, got %d: %v", len(rules), rules)
+	}
+	// the hairpin mode replaces the skip DNAT rule with the hairpin NAT one
+	if rules := n.iptablesRules(true); len(rules) != 6 || rules[1].table != iptables.Nat {
+		t.Fatalf("unexpected hairpin rules: %v", rules)
+	}
+
+	n.config.Internal = true
+	if rules := n.iptablesRules(false); len(rules) != 3 {
+		t.Fatalf("expected 3 rules for an internal network, got %d: %v", len(rules), rules)
+	}
+}
@@ -0,0 +1,61 @@
+package overlay
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/types"
+)
+
+type subnetState struct {
+	Subnet string
+	VNI    uint32
+	Bridge string
+	Vxlan  string
+}
+
+type peerState struct {
+	EndpointID string
+	IP         string
+	MAC        string
+	VTEP       string
+	Local      bool
+}
+
+// NetworkDatapath returns the vxlan subnets of the network and its peers
+// as known by the peer db
+func (d *driver) NetworkDatapath(nid string) (map[string]interface{}, error) {
+	n := d.network(nid)
+	if n == nil {
+		return nil, types.NotFoundErrorf("network %s not found", nid)
+	}
+
+	subnets := []subnetState{}
+	n.Lock()
+	for _, s := range n.subnets {
+		subnets = append(subnets, subnetState{
+			Subnet: s.subnetIP.String(),
+			VNI:    s.vni,
+			Bridge: s.brName,
+			Vxlan:  s.vxlanName,
+		})
+	}
+	n.Unlock()
+
+	peers := []peerState{}
+	d.peerDbNetworkWalk(nid, func(pKey *peerKey, pEntry *peerEntry) bool {
+		ip := &net.IPNet{IP: pKey.peerIP, Mask: pEntry.peerIPMask}
+		peers = append(peers, peerState{
+			EndpointID: pEntry.eid,
+			IP:         ip.String(),
+			MAC:        pKey.peerMac.String(),
+			VTEP:       pEntry.vtep.String(),
+			Local:      pEntry.isLocal,
+		})
+		return false
+	})
+
+	return map[string]interface{}{
+		"Subnets": subnets,
+		"Peers":   peers,
+	}, nil
+}
@@ -196,6 +196,14 @@ func (d *driver) EventNotify(etype driverapi.EventType, nid, tableName, key stri
 		return
 	}
 
+	// An endpoint migrated to another node keeps its addresses, the update
+	// of its record moves the peer to the VTEP of that node
+	if etype == driverapi.Update {
+		if _, pEntry, err := d.peerDbSearch(nid, addr.IP); err == nil && pEntry.eid == eid && !pEntry.isLocal && !pEntry.vtep.Equal(vtep) {
+			d.peerDelete(nid, eid, addr.IP, addr.Mask, mac, pEntry.vtep, false)
+		}
+	}
+
 	d.peerAdd(nid, eid, addr.IP, addr.Mask, mac, vtep, false, false, false)
 }
 
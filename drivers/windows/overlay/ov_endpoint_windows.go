@@ -86,8 +86,7 @@ func (n *network) removeEndpointWithAddress(addr *net.IPNet) {
 }
 
 func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
-	epOptions map[string]interface{}) error {
-	var err error
+	epOptions map[string]interface{}) (err error) {
 	if err = validateID(nid, eid); err != nil {
 		return err
 	}
@@ -106,6 +105,7 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 		if err != nil {
 			return err
 		}
+		windows.ReleasePorts(ep.portMapping)
 	}
 
 	ep = &endpoint{
@@ -149,6 +149,13 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 
 	hnsEndpoint.Policies = append(hnsEndpoint.Policies, paPolicy)
 
+	var portMapping []types.PortBinding
+	defer func() {
+		if err != nil {
+			windows.ReleasePorts(portMapping)
+		}
+	}()
+
 	if system.GetOSVersion().Build > 16236 {
 		natPolicy, err := json.Marshal(hcsshim.PaPolicy{
 			Type: "OutBoundNAT",
@@ -165,7 +172,12 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 			return err
 		}
 
-		pbPolicy, err := windows.ConvertPortBindings(epConnectivity.PortBindings)
+		portMapping, err = windows.AllocatePorts(epConnectivity.PortBindings)
+		if err != nil {
+			return err
+		}
+
+		pbPolicy, err := windows.ConvertPortBindings(portMapping)
 		if err != nil {
 			return err
 		}
@@ -197,11 +209,7 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 		}
 	}
 
-	ep.portMapping, err = windows.ParsePortBindingPolicies(hnsresponse.Policies)
-	if err != nil {
-		hcsshim.HNSEndpointRequest("DELETE", hnsresponse.Id, "")
-		return err
-	}
+	ep.portMapping = portMapping
 
 	n.addEndpoint(ep)
 
@@ -230,6 +238,10 @@ func (d *driver) DeleteEndpoint(nid, eid string) error {
 		return err
 	}
 
+	if err := windows.ReleasePorts(ep.portMapping); err != nil {
+		logrus.Warnf("Failed to release the host ports of endpoint %.7s: %v", ep.id, err)
+	}
+
 	return nil
 }
 
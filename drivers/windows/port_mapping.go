@@ -0,0 +1,97 @@
+// +build windows
+
+package windows
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/portallocator"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// natKey identifies the HNS NAT policy of a port binding. HNS publishes a
+// port on all the host addresses, the IPv4 and IPv6 bindings of a
+// container port share the policy and the host port.
+type natKey struct {
+	proto       types.Protocol
+	port        uint16
+	hostPort    uint16
+	hostPortEnd uint16
+}
+
+// AllocatePorts allocates the host ports of the bindings from the port
+// allocator, the first free port of the host port range or an ephemeral
+// port when no host port is specified. The returned bindings have a single
+// host port each.
+func AllocatePorts(bindings []types.PortBinding) ([]types.PortBinding, error) {
+	bs := make([]types.PortBinding, 0, len(bindings))
+	allocated := make(map[natKey]uint16, len(bindings))
+	for _, c := range bindings {
+		b := c.GetCopy()
+		if b.HostPortEnd == 0 {
+			b.HostPortEnd = b.HostPort
+		}
+		if err := validateHostIP(b.HostIP); err != nil {
+			releasePorts(bs)
+			return nil, err
+		}
+		k := natKey{b.Proto, b.Port, b.HostPort, b.HostPortEnd}
+		port, ok := allocated[k]
+		if !ok {
+			p, err := portallocator.Get().RequestPortInRange(nil, b.Proto.String(), int(b.HostPort), int(b.HostPortEnd))
+			if err != nil {
+				releasePorts(bs)
+				return nil, fmt.Errorf("failed to allocate host port for %s: %v", b.String(), err)
+			}
+			port = uint16(p)
+			allocated[k] = port
+		}
+		b.HostPort, b.HostPortEnd = port, port
+		bs = append(bs, b)
+	}
+	return bs, nil
+}
+
+// ReleasePorts releases the host ports of the bindings AllocatePorts
+// returned
+func ReleasePorts(bindings []types.PortBinding) error {
+	var errs []string
+	for _, b := range bindings {
+		if err := portallocator.Get().ReleasePort(nil, b.Proto.String(), int(b.HostPort)); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", b.String(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to release host ports %v", errs)
+	}
+	return nil
+}
+
+func releasePorts(bindings []types.PortBinding) {
+	if err := ReleasePorts(bindings); err != nil {
+		logrus.Warnf("Failed to clear the allocated port bindings: %v", err)
+	}
+}
+
+// reservePorts reserves the host ports of the bindings of a restored
+// endpoint
+func reservePorts(bindings []types.PortBinding) {
+	for _, b := range bindings {
+		if _, err := portallocator.Get().RequestPort(nil, b.Proto.String(), int(b.HostPort)); err != nil {
+			if _, ok := err.(portallocator.ErrPortAlreadyAllocated); !ok {
+				logrus.Warnf("Failed to reserve host port for restored binding %s: %v", b.String(), err)
+			}
+		}
+	}
+}
+
+// validateHostIP accepts the unspecified IPv4 and IPv6 addresses, the NAT
+// policies do not publish on a single host address
+func validateHostIP(ip net.IP) error {
+	if len(ip) == 0 || ip.IsUnspecified() {
+		return nil
+	}
+	return types.NotImplementedErrorf("Windows does not support host IP address %s in NAT settings", ip)
+}
@@ -0,0 +1,48 @@
+// +build windows
+
+package windows
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestAllocatePorts(t *testing.T) {
+	bindings := []types.PortBinding{
+		{Proto: types.TCP, Port: 80, HostIP: net.IPv4zero, HostPort: 18080, HostPortEnd: 18089},
+		{Proto: types.TCP, Port: 80, HostIP: net.IPv6zero, HostPort: 18080, HostPortEnd: 18089},
+		{Proto: types.UDP, Port: 53},
+	}
+	pbs, err := AllocatePorts(bindings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ReleasePorts(pbs)
+
+	if len(pbs) != len(bindings) {
+		t.Fatalf("expected %d bindings, got %v", len(bindings), pbs)
+	}
+	if pbs[0].HostPort < 18080 || pbs[0].HostPort > 18089 || pbs[0].HostPort != pbs[0].HostPortEnd {
+		t.Fatalf("expected a host port of the range, got %s", pbs[0].String())
+	}
+	if pbs[1].HostPort != pbs[0].HostPort || !pbs[1].HostIP.Equal(net.IPv6zero) {
+		t.Fatalf("expected the IPv6 binding to share the host port, got %s", pbs[1].String())
+	}
+	if pbs[2].HostPort == 0 {
+		t.Fatalf("expected an ephemeral host port, got %s", pbs[2].String())
+	}
+
+	policies, err := ConvertPortBindings(pbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 2 {
+		t.Fatalf("expected 2 NAT policies, got %d", len(policies))
+	}
+
+	if _, err := AllocatePorts([]types.PortBinding{{Proto: types.TCP, Port: 80, HostIP: net.ParseIP("2001:db8::1"), HostPort: 18090}}); err == nil {
+		t.Fatal("expected an error for a specific host IP address")
+	}
+}
@@ -442,9 +442,12 @@ func convertQosPolicies(qosPolicies []types.QosPolicy) ([]json.RawMessage, error
 	return qps, nil
 }
 
-// ConvertPortBindings converts PortBindings to JSON for HNS request
+// ConvertPortBindings converts PortBindings to JSON for HNS request. The
+// host ports of the ranges are allocated with AllocatePorts first. The IPv4
+// and IPv6 bindings of a container port result in a single policy.
 func ConvertPortBindings(portBindings []types.PortBinding) ([]json.RawMessage, error) {
 	var pbs []json.RawMessage
+	policies := make(map[natKey]bool, len(portBindings))
 
 	// Enumerate through the port bindings specified by the user and convert
 	// them into the internal structure matching the JSON blob that can be
@@ -459,9 +462,15 @@ func ConvertPortBindings(portBindings []types.PortBinding) ([]json.RawMessage, e
 			return nil, fmt.Errorf("Windows does not support more than one host port in NAT settings")
 		}
 
-		if len(elem.HostIP) != 0 {
-			return nil, fmt.Errorf("Windows does not support host IP addresses in NAT settings")
+		if err := validateHostIP(elem.HostIP); err != nil {
+			return nil, err
+		}
+
+		k := natKey{elem.Proto, elem.Port, elem.HostPort, elem.HostPortEnd}
+		if policies[k] {
+			continue
 		}
+		policies[k] = true
 
 		encodedPolicy, err := json.Marshal(hcsshim.NatPolicy{
 			Type:         "NAT",
@@ -579,7 +588,7 @@ func ParseEndpointConnectivity(epOptions map[string]interface{}) (*EndpointConne
 	return ec, nil
 }
 
-func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo, epOptions map[string]interface{}) error {
+func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo, epOptions map[string]interface{}) (err error) {
 	n, err := d.getNetwork(nid)
 	if err != nil {
 		return err
@@ -610,7 +619,17 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 		endpointStruct.MacAddress = strings.Replace(macAddress.String(), ":", "-", -1)
 	}
 
-	endpointStruct.Policies, err = ConvertPortBindings(epConnectivity.PortBindings)
+	portMapping, err := AllocatePorts(epConnectivity.PortBindings)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			releasePorts(portMapping)
+		}
+	}()
+
+	endpointStruct.Policies, err = ConvertPortBindings(portMapping)
 	if err != nil {
 		return err
 	}
@@ -684,12 +703,7 @@ func (d *driver) CreateEndpoint(nid, eid string, ifInfo driverapi.InterfaceInfo,
 	endpoint.profileID = hnsresponse.Id
 	endpoint.epConnectivity = epConnectivity
 	endpoint.epOption = epOption
-	endpoint.portMapping, err = ParsePortBindingPolicies(hnsresponse.Policies)
-
-	if err != nil {
-		hcsshim.HNSEndpointRequest("DELETE", hnsresponse.Id, "")
-		return err
-	}
+	endpoint.portMapping = portMapping
 
 	n.Lock()
 	n.endpoints[eid] = endpoint
@@ -730,6 +744,10 @@ func (d *driver) DeleteEndpoint(nid, eid string) error {
 		return err
 	}
 
+	if err := ReleasePorts(ep.portMapping); err != nil {
+		logrus.Warnf("Failed to release the host ports of endpoint %.7s: %v", ep.id, err)
+	}
+
 	if err := d.storeDelete(ep); err != nil {
 		logrus.Warnf("Failed to remove bridge endpoint %.7s from store: %v", ep.id, err)
 	}
@@ -23,3 +23,19 @@ func initIPAMDrivers(r *drvregistry.DrvRegistry, lDs, gDs interface{}, addressPo
 
 	return nil
 }
+
+// reloadIPAMDefaultPools configures the default address pools again and
+// makes the IPAM drivers request the new networks pools from them
+func reloadIPAMDefaultPools(r *drvregistry.DrvRegistry, addressPool []*ipamutils.NetworkToSplit) error {
+	if err := ipamutils.ConfigLocalScopeDefaultNetworks(addressPool); err != nil {
+		return err
+	}
+	builtinIpam.SetDefaultIPAddressPool(addressPool)
+	r.WalkIPAMs(func(name string, driver ipamapi.Ipam, cap *ipamapi.Capability) bool {
+		if pr, ok := driver.(ipamapi.DefaultPoolsReloader); ok {
+			pr.ReloadDefaultPools()
+		}
+		return false
+	})
+	return nil
+}
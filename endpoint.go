@@ -1,16 +1,20 @@
 package libnetwork
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/docker/libnetwork/correlation"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/tracing"
 	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 )
@@ -30,15 +34,39 @@ type Endpoint interface {
 	// the network resources allocated for the endpoint.
 	Join(sandbox Sandbox, options ...EndpointOption) error
 
+	// JoinContext is Join which gives up and rolls back the join when the
+	// context is done.
+	JoinContext(ctx context.Context, sandbox Sandbox, options ...EndpointOption) error
+
 	// Leave detaches the network resources populated in the sandbox.
 	Leave(sandbox Sandbox, options ...EndpointOption) error
 
+	// LeaveContext is Leave which gives up when the context is done before
+	// the resources are detached, a started leave is always completed.
+	LeaveContext(ctx context.Context, sandbox Sandbox, options ...EndpointOption) error
+
 	// Return certain operational data belonging to this endpoint
 	Info() EndpointInfo
 
 	// DriverInfo returns a collection of driver operational data related to this endpoint retrieved from the driver
 	DriverInfo() (map[string]interface{}, error)
 
+	// Statistics returns the statistics of the endpoint interface in the
+	// sandbox the endpoint is joined to
+	Statistics() (*types.InterfaceStatistics, error)
+
+	// DatapathState returns the datapath state of the endpoint, for
+	// troubleshooting
+	DatapathState() (*EndpointDatapath, error)
+
+	// Export returns the identity of the endpoint, to migrate it to another
+	// node with ImportEndpoint once it is deleted on this one.
+	Export() (*EndpointMigration, error)
+
+	// Unexport cancels the export of the endpoint, which then releases its
+	// addresses and cluster records when it is deleted.
+	Unexport() error
+
 	// Delete and detaches this endpoint from the network.
 	Delete(force bool) error
 }
@@ -70,11 +98,28 @@ type endpoint struct {
 	svcName           string
 	virtualIP         net.IP
 	svcAliases        []string
+	svcScheduler      string
+	svcPersistence    time.Duration
+	svcWeight         int
 	ingressPorts      []*PortConfig
 	dbIndex           uint64
 	dbExists          bool
 	serviceEnabled    bool
 	loadBalancer      bool
+	// exported is set once the endpoint was exported to be migrated to
+	// another node, until exportExpiry, with the exportToken the import
+	// presents. migrated is set on the endpoints imported from another
+	// node until they took over its cluster records, with the
+	// migrationToken of the export while they are created.
+	exported       bool
+	exportToken    string
+	exportExpiry   time.Time
+	migrated       bool
+	migrationToken string
+	sysctls        map[string]string
+	// sysctlsPrev are the values the sysctls of the endpoint had in its
+	// sandbox before they were set, restored when the endpoint leaves
+	sysctlsPrev map[string]string
 	sync.Mutex
 }
 
@@ -101,7 +146,22 @@ func (ep *endpoint) MarshalJSON() ([]byte, error) {
 	epMap["virtualIP"] = ep.virtualIP.String()
 	epMap["ingressPorts"] = ep.ingressPorts
 	epMap["svcAliases"] = ep.svcAliases
+	epMap["svcScheduler"] = ep.svcScheduler
+	epMap["svcPersistence"] = ep.svcPersistence
+	epMap["svcWeight"] = ep.svcWeight
 	epMap["loadBalancer"] = ep.loadBalancer
+	epMap["exported"] = ep.exported
+	if ep.exported {
+		epMap["exportToken"] = ep.exportToken
+		epMap["exportExpiry"] = ep.exportExpiry
+	}
+	epMap["migrated"] = ep.migrated
+	if len(ep.sysctls) > 0 {
+		epMap["sysctls"] = ep.sysctls
+	}
+	if len(ep.sysctlsPrev) > 0 {
+		epMap["sysctlsPrev"] = ep.sysctlsPrev
+	}
 
 	return json.Marshal(epMap)
 }
@@ -202,10 +262,38 @@ func (ep *endpoint) UnmarshalJSON(b []byte) (err error) {
 		ep.virtualIP = net.ParseIP(vip.(string))
 	}
 
+	if ss, ok := epMap["svcScheduler"]; ok {
+		ep.svcScheduler = ss.(string)
+	}
+
+	if sp, ok := epMap["svcPersistence"]; ok {
+		ep.svcPersistence = time.Duration(sp.(float64))
+	}
+
+	if sw, ok := epMap["svcWeight"]; ok {
+		ep.svcWeight = int(sw.(float64))
+	}
+
 	if v, ok := epMap["loadBalancer"]; ok {
 		ep.loadBalancer = v.(bool)
 	}
 
+	if v, ok := epMap["exported"]; ok {
+		ep.exported = v.(bool)
+	}
+
+	if v, ok := epMap["exportToken"]; ok {
+		ep.exportToken = v.(string)
+	}
+
+	if v, ok := epMap["exportExpiry"]; ok {
+		ep.exportExpiry, _ = time.Parse(time.RFC3339Nano, v.(string))
+	}
+
+	if v, ok := epMap["migrated"]; ok {
+		ep.migrated = v.(bool)
+	}
+
 	sal, _ := json.Marshal(epMap["svcAliases"])
 	var svcAliases []string
 	json.Unmarshal(sal, &svcAliases)
@@ -220,6 +308,19 @@ func (ep *endpoint) UnmarshalJSON(b []byte) (err error) {
 	var myAliases []string
 	json.Unmarshal(ma, &myAliases)
 	ep.myAliases = myAliases
+
+	if v, ok := epMap["sysctls"]; ok {
+		sc, _ := json.Marshal(v)
+		var sysctls map[string]string
+		json.Unmarshal(sc, &sysctls)
+		ep.sysctls = sysctls
+	}
+	if v, ok := epMap["sysctlsPrev"]; ok {
+		sc, _ := json.Marshal(v)
+		var prev map[string]string
+		json.Unmarshal(sc, &prev)
+		ep.sysctlsPrev = prev
+	}
 	return nil
 }
 
@@ -243,7 +344,14 @@ func (ep *endpoint) CopyTo(o datastore.KVObject) error {
 	dstEp.svcName = ep.svcName
 	dstEp.svcID = ep.svcID
 	dstEp.virtualIP = ep.virtualIP
+	dstEp.svcScheduler = ep.svcScheduler
+	dstEp.svcPersistence = ep.svcPersistence
+	dstEp.svcWeight = ep.svcWeight
 	dstEp.loadBalancer = ep.loadBalancer
+	dstEp.exported = ep.exported
+	dstEp.exportToken = ep.exportToken
+	dstEp.exportExpiry = ep.exportExpiry
+	dstEp.migrated = ep.migrated
 
 	dstEp.svcAliases = make([]string, len(ep.svcAliases))
 	copy(dstEp.svcAliases, ep.svcAliases)
@@ -267,6 +375,21 @@ func (ep *endpoint) CopyTo(o datastore.KVObject) error {
 	dstEp.myAliases = make([]string, len(ep.myAliases))
 	copy(dstEp.myAliases, ep.myAliases)
 
+	dstEp.sysctls = nil
+	if ep.sysctls != nil {
+		dstEp.sysctls = make(map[string]string, len(ep.sysctls))
+		for k, v := range ep.sysctls {
+			dstEp.sysctls[k] = v
+		}
+	}
+	dstEp.sysctlsPrev = nil
+	if ep.sysctlsPrev != nil {
+		dstEp.sysctlsPrev = make(map[string]string, len(ep.sysctlsPrev))
+		for k, v := range ep.sysctlsPrev {
+			dstEp.sysctlsPrev[k] = v
+		}
+	}
+
 	dstEp.generic = options.Generic{}
 	for k, v := range ep.generic {
 		dstEp.generic[k] = v
@@ -427,6 +550,10 @@ func (ep *endpoint) getNetworkFromStore() (*network, error) {
 }
 
 func (ep *endpoint) Join(sbox Sandbox, options ...EndpointOption) error {
+	return ep.JoinContext(context.Background(), sbox, options...)
+}
+
+func (ep *endpoint) JoinContext(ctx context.Context, sbox Sandbox, options ...EndpointOption) error {
 	if sbox == nil {
 		return types.BadRequestErrorf("endpoint cannot be joined by nil container")
 	}
@@ -436,13 +563,20 @@ func (ep *endpoint) Join(sbox Sandbox, options ...EndpointOption) error {
 		return types.BadRequestErrorf("not a valid Sandbox interface")
 	}
 
-	sb.joinLeaveStart()
-	defer sb.joinLeaveEnd()
-
-	return ep.sbJoin(sb, options...)
+	ctx = correlation.Ensure(ctx)
+	ctx, span := tracing.Start(ctx, "libnetwork.Join",
+		append(ep.spanAttrs(), tracing.String("sandbox.id", sb.ID()), correlationAttr(correlation.ID(ctx)))...)
+	err := sb.joinLeaveStart(ctx)
+	if err == nil {
+		err = ep.sbJoin(ctx, sb, options...)
+		sb.joinLeaveEnd()
+	}
+	tracing.End(span, err)
+	return err
 }
 
-func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
+func (ep *endpoint) sbJoin(ctx context.Context, sb *sandbox, options ...EndpointOption) (err error) {
+	log := correlation.Logger(ctx)
 	n, err := ep.getNetworkFromStore()
 	if err != nil {
 		return fmt.Errorf("failed to get network from store during join: %v", err)
@@ -475,19 +609,29 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 
 	ep.processOptions(options...)
 
+	if err = sb.checkEndpointSysctls(ep); err != nil {
+		return err
+	}
+
 	d, err := n.driver(true)
 	if err != nil {
 		return fmt.Errorf("failed to get driver during join: %v", err)
 	}
 
-	err = d.Join(nid, epid, sb.Key(), ep, sb.Labels())
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	err = tracing.Trace(ctx, "driver.Join", func() error {
+		return d.Join(nid, epid, sb.Key(), ep, sb.Labels())
+	}, n.driverSpanAttrs()...)
 	if err != nil {
+		n.getController().publishDriverError("Join", n, ep, err)
 		return err
 	}
 	defer func() {
 		if err != nil {
 			if e := d.Leave(nid, epid); e != nil {
-				logrus.Warnf("driver leave failed while rolling back join: %v", e)
+				log.Warnf("driver leave failed while rolling back join: %v", e)
 			}
 		}
 	}()
@@ -524,9 +668,17 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 		return err
 	}
 
-	if err = n.getController().updateToStore(ep); err != nil {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = n.getController().updateToStoreContext(ctx, ep); err != nil {
 		return err
 	}
+	defer func() {
+		if err == nil {
+			n.getController().publishEndpoint(EventEndpointJoin, ep, sb)
+		}
+	}()
 
 	if err = ep.addDriverInfoToCluster(); err != nil {
 		return err
@@ -535,11 +687,21 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 	defer func() {
 		if err != nil {
 			if e := ep.deleteDriverInfoFromCluster(); e != nil {
-				logrus.Errorf("Could not delete endpoint state for endpoint %s from cluster on join failure: %v", ep.Name(), e)
+				log.Errorf("Could not delete endpoint state for endpoint %s from cluster on join failure: %v", ep.Name(), e)
 			}
 		}
 	}()
 
+	// Without service records to take over, the takeover of the migrated
+	// endpoint is done with its driver entries
+	if !n.getController().isAgent() || ep.isAnonymous() && len(ep.myAliases) == 0 {
+		defer func() {
+			if err == nil {
+				ep.clearMigrated()
+			}
+		}()
+	}
+
 	// Load balancing endpoints should never have a default gateway nor
 	// should they alter the status of a network's default gateway
 	if ep.loadBalancer && !sb.ingress {
@@ -547,14 +709,14 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 	}
 
 	if sb.needDefaultGW() && sb.getEndpointInGWNetwork() == nil {
-		return sb.setupDefaultGW()
+		return sb.setupDefaultGW(ctx)
 	}
 
 	moveExtConn := sb.getGatewayEndpoint() != extEp
 
 	if moveExtConn {
 		if extEp != nil {
-			logrus.Debugf("Revoking external connectivity on endpoint %s (%s)", extEp.Name(), extEp.ID())
+			log.Debugf("Revoking external connectivity on endpoint %s (%s)", extEp.Name(), extEp.ID())
 			extN, err := extEp.getNetworkFromStore()
 			if err != nil {
 				return fmt.Errorf("failed to get network from store for revoking external connectivity during join: %v", err)
@@ -564,22 +726,26 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 				return fmt.Errorf("failed to get driver for revoking external connectivity during join: %v", err)
 			}
 			if err = extD.RevokeExternalConnectivity(extEp.network.ID(), extEp.ID()); err != nil {
+				n.getController().publishDriverError("RevokeExternalConnectivity", extN, extEp, err)
 				return types.InternalErrorf(
 					"driver failed revoking external connectivity on endpoint %s (%s): %v",
 					extEp.Name(), extEp.ID(), err)
 			}
 			defer func() {
 				if err != nil {
-					if e := extD.ProgramExternalConnectivity(extEp.network.ID(), extEp.ID(), sb.Labels()); e != nil {
-						logrus.Warnf("Failed to roll-back external connectivity on endpoint %s (%s): %v",
+					if e := extD.ProgramExternalConnectivity(extEp.network.ID(), extEp.ID(), correlation.Options(ctx, sb.Labels())); e != nil {
+						log.Warnf("Failed to roll-back external connectivity on endpoint %s (%s): %v",
 							extEp.Name(), extEp.ID(), e)
 					}
 				}
 			}()
 		}
 		if !n.internal {
-			logrus.Debugf("Programming external connectivity on endpoint %s (%s)", ep.Name(), ep.ID())
-			if err = d.ProgramExternalConnectivity(n.ID(), ep.ID(), sb.Labels()); err != nil {
+			log.Debugf("Programming external connectivity on endpoint %s (%s)", ep.Name(), ep.ID())
+			if err = tracing.Trace(ctx, "driver.ProgramExternalConnectivity", func() error {
+				return d.ProgramExternalConnectivity(n.ID(), ep.ID(), correlation.Options(ctx, sb.Labels()))
+			}, n.driverSpanAttrs()...); err != nil {
+				n.getController().publishDriverError("ProgramExternalConnectivity", n, ep, err)
 				return types.InternalErrorf(
 					"driver failed programming external connectivity on endpoint %s (%s): %v",
 					ep.Name(), ep.ID(), err)
@@ -590,11 +756,12 @@ func (ep *endpoint) sbJoin(sb *sandbox, options ...EndpointOption) (err error) {
 
 	if !sb.needDefaultGW() {
 		if e := sb.clearDefaultGW(); e != nil {
-			logrus.Warnf("Failure while disconnecting sandbox %s (%s) from gateway network: %v",
+			log.Warnf("Failure while disconnecting sandbox %s (%s) from gateway network: %v",
 				sb.ID(), sb.ContainerID(), e)
 		}
 	}
 
+	log.Debugf("Joined endpoint %s (%s) to sandbox %s (%s)", ep.Name(), ep.ID(), sb.ID(), sb.ContainerID())
 	return nil
 }
 
@@ -688,7 +855,32 @@ func (ep *endpoint) hasInterface(iName string) bool {
 	return ep.iface != nil && ep.iface.srcName == iName
 }
 
+func (ep *endpoint) Statistics() (*types.InterfaceStatistics, error) {
+	sb, ok := ep.getSandbox()
+	if !ok {
+		return nil, types.ForbiddenErrorf("endpoint %s is not joined to a sandbox", ep.Name())
+	}
+
+	sb.Lock()
+	osSbox := sb.osSbox
+	sb.Unlock()
+	if osSbox == nil {
+		return nil, types.NotFoundErrorf("no network namespace for the sandbox of endpoint %s", ep.Name())
+	}
+
+	for _, i := range osSbox.Info().Interfaces() {
+		if ep.hasInterface(i.SrcName()) {
+			return i.Statistics()
+		}
+	}
+	return nil, types.NotFoundErrorf("no interface of endpoint %s in its sandbox", ep.Name())
+}
+
 func (ep *endpoint) Leave(sbox Sandbox, options ...EndpointOption) error {
+	return ep.LeaveContext(context.Background(), sbox, options...)
+}
+
+func (ep *endpoint) LeaveContext(ctx context.Context, sbox Sandbox, options ...EndpointOption) error {
 	if sbox == nil || sbox.ID() == "" || sbox.Key() == "" {
 		return types.BadRequestErrorf("invalid Sandbox passed to endpoint leave: %v", sbox)
 	}
@@ -698,13 +890,18 @@ func (ep *endpoint) Leave(sbox Sandbox, options ...EndpointOption) error {
 		return types.BadRequestErrorf("not a valid Sandbox interface")
 	}
 
-	sb.joinLeaveStart()
-	defer sb.joinLeaveEnd()
-
-	return ep.sbLeave(sb, false, options...)
+	ctx, span := tracing.Start(ctx, "libnetwork.Leave",
+		append(ep.spanAttrs(), tracing.String("sandbox.id", sb.ID()))...)
+	err := sb.joinLeaveStart(ctx)
+	if err == nil {
+		err = ep.sbLeave(ctx, sb, false, options...)
+		sb.joinLeaveEnd()
+	}
+	tracing.End(span, err)
+	return err
 }
 
-func (ep *endpoint) sbLeave(sb *sandbox, force bool, options ...EndpointOption) error {
+func (ep *endpoint) sbLeave(ctx context.Context, sb *sandbox, force bool, options ...EndpointOption) error {
 	n, err := ep.getNetworkFromStore()
 	if err != nil {
 		return fmt.Errorf("failed to get network from store during leave: %v", err)
@@ -733,6 +930,11 @@ func (ep *endpoint) sbLeave(sb *sandbox, force bool, options ...EndpointOption)
 		return fmt.Errorf("failed to get driver during endpoint leave: %v", err)
 	}
 
+	// Past this point the leave is completed whatever the context
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	ep.Lock()
 	ep.sandboxID = ""
 	ep.network = n
@@ -745,14 +947,18 @@ func (ep *endpoint) sbLeave(sb *sandbox, force bool, options ...EndpointOption)
 	if d != nil {
 		if moveExtConn {
 			logrus.Debugf("Revoking external connectivity on endpoint %s (%s)", ep.Name(), ep.ID())
-			if err := d.RevokeExternalConnectivity(n.id, ep.id); err != nil {
+			if err := tracing.Trace(ctx, "driver.RevokeExternalConnectivity", func() error {
+				return d.RevokeExternalConnectivity(n.id, ep.id)
+			}, n.driverSpanAttrs()...); err != nil {
+				n.getController().publishDriverError("RevokeExternalConnectivity", n, ep, err)
 				logrus.Warnf("driver failed revoking external connectivity on endpoint %s (%s): %v",
 					ep.Name(), ep.ID(), err)
 			}
 		}
 
-		if err := d.Leave(n.id, ep.id); err != nil {
+		if err := tracing.Trace(ctx, "driver.Leave", func() error { return d.Leave(n.id, ep.id) }, n.driverSpanAttrs()...); err != nil {
 			if _, ok := err.(types.MaskableError); !ok {
+				n.getController().publishDriverError("Leave", n, ep, err)
 				logrus.Warnf("driver error disconnecting container %s : %v", ep.name, err)
 			}
 		}
@@ -778,10 +984,11 @@ func (ep *endpoint) sbLeave(sb *sandbox, force bool, options ...EndpointOption)
 	if e := ep.deleteDriverInfoFromCluster(); e != nil {
 		logrus.Errorf("Failed to delete endpoint state for endpoint %s from cluster: %v", ep.Name(), e)
 	}
+	n.getController().publishEndpoint(EventEndpointLeave, ep, sb)
 
 	sb.deleteHostsEntries(n.getSvcRecords(ep))
 	if !sb.inDelete && sb.needDefaultGW() && sb.getEndpointInGWNetwork() == nil {
-		return sb.setupDefaultGW()
+		return sb.setupDefaultGW(context.Background())
 	}
 
 	// New endpoint providing external connectivity for the sandbox
@@ -797,6 +1004,7 @@ func (ep *endpoint) sbLeave(sb *sandbox, force bool, options ...EndpointOption)
 			return fmt.Errorf("failed to get driver for programming external connectivity during leave: %v", err)
 		}
 		if err := extD.ProgramExternalConnectivity(extEp.network.ID(), extEp.ID(), sb.Labels()); err != nil {
+			n.getController().publishDriverError("ProgramExternalConnectivity", extN, extEp, err)
 			logrus.Warnf("driver failed programming external connectivity on endpoint %s: (%s) %v",
 				extEp.Name(), extEp.ID(), err)
 		}
@@ -836,7 +1044,7 @@ func (ep *endpoint) Delete(force bool) error {
 	}
 
 	if sb != nil {
-		if e := ep.sbLeave(sb.(*sandbox), force); e != nil {
+		if e := ep.sbLeave(context.Background(), sb.(*sandbox), force); e != nil {
 			logrus.Warnf("failed to leave sandbox for endpoint %s : %v", name, e)
 		}
 	}
@@ -856,17 +1064,20 @@ func (ep *endpoint) Delete(force bool) error {
 
 	// unwatch for service records
 	n.getController().unWatchSvcRecord(ep)
+	n.getController().clearEndpointHealth(n.ID(), ep.ID())
 
 	if err = ep.deleteEndpoint(force); err != nil && !force {
 		return err
 	}
 
 	ep.releaseAddress()
+	ep.handOver(n)
 
 	if err := n.getEpCnt().DecEndpointCnt(); err != nil {
 		logrus.Warnf("failed to decrement endpoint count for ep %s: %v", ep.ID(), err)
 	}
 
+	n.getController().publishEndpoint(EventEndpointDelete, ep, nil)
 	return nil
 }
 
@@ -887,6 +1098,10 @@ func (ep *endpoint) deleteEndpoint(force bool) error {
 	}
 
 	if err := driver.DeleteEndpoint(n.id, epid); err != nil {
+		if _, ok := err.(types.MaskableError); !ok {
+			n.getController().publishDriverError("DeleteEndpoint", n, ep, err)
+		}
+
 		if _, ok := err.(types.ForbiddenError); ok {
 			return err
 		}
@@ -988,6 +1203,23 @@ func CreateOptionDNS(dns []string) EndpointOption {
 	}
 }
 
+// CreateOptionSysctls function returns an option setter for the network
+// sysctls to be set in the sandbox the endpoint joins. A sysctl of the
+// sandbox interface of the endpoint names it with osl.SysctlIfname, as in
+// "net.ipv4.conf.IFNAME.rp_filter". The sysctls are reset when the
+// endpoint leaves the sandbox. The join is refused for the sysctls the
+// sandbox or another of its endpoints sets, and in the default sandbox.
+func CreateOptionSysctls(sysctls map[string]string) EndpointOption {
+	return func(ep *endpoint) {
+		if ep.sysctls == nil {
+			ep.sysctls = make(map[string]string, len(sysctls))
+		}
+		for k, v := range sysctls {
+			ep.sysctls[k] = v
+		}
+	}
+}
+
 // CreateOptionAnonymous function returns an option setter for setting
 // this endpoint as anonymous
 func CreateOptionAnonymous() EndpointOption {
@@ -1025,6 +1257,31 @@ func CreateOptionService(name, id string, vip net.IP, ingressPorts []*PortConfig
 	}
 }
 
+// CreateOptionServiceScheduler function returns an option setter for setting
+// the load balancing scheduler of the service, see LBSchedulers
+func CreateOptionServiceScheduler(scheduler string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.svcScheduler = scheduler
+	}
+}
+
+// CreateOptionServicePersistence function returns an option setter for setting
+// the session affinity of the service: the connections from a client go to the
+// same backend until the client is idle for the timeout
+func CreateOptionServicePersistence(timeout time.Duration) EndpointOption {
+	return func(ep *endpoint) {
+		ep.svcPersistence = timeout
+	}
+}
+
+// CreateOptionServiceWeight function returns an option setter for setting the
+// load balancing weight of the endpoint in its service, see MaxLBWeight
+func CreateOptionServiceWeight(weight int) EndpointOption {
+	return func(ep *endpoint) {
+		ep.svcWeight = weight
+	}
+}
+
 // CreateOptionMyAlias function returns an option setter for setting endpoint's self alias
 func CreateOptionMyAlias(alias string) EndpointOption {
 	return func(ep *endpoint) {
@@ -1125,6 +1382,13 @@ func (ep *endpoint) assignAddressVersion(ipVer int, ipam ipamapi.Ipam) error {
 			continue
 		}
 		addr, _, err := ipam.RequestAddress(d.PoolID, progAdd, ep.ipamOptions)
+		if err == ipamapi.ErrIPAlreadyAllocated && ep.isMigrated() && n.DataScope() == datastore.GlobalScope {
+			// the address is still allocated to the endpoint the
+			// migration exported, if its claim hands it over
+			if err = n.checkMigrationClaim(ep, progAdd); err == nil {
+				addr = &net.IPNet{IP: progAdd, Mask: d.Pool.Mask}
+			}
+		}
 		if err == nil {
 			ep.Lock()
 			*address = addr
@@ -1148,6 +1412,14 @@ func (ep *endpoint) releaseAddress() {
 		return
 	}
 
+	// The addresses of the global scope networks are shared by the nodes,
+	// they stay allocated to the endpoint migrated to another node, and
+	// with the claim of the export when its import fails
+	if n.DataScope() == datastore.GlobalScope && (ep.isExported() || ep.isMigrated() && n.hasMigrationClaim(ep.ID())) {
+		logrus.Debugf("Keeping the addresses of endpoint %s migrated from network %s", ep.Name(), n.Name())
+		return
+	}
+
 	logrus.Debugf("Releasing addresses for endpoint %s's interface on network %s", ep.Name(), n.Name())
 
 	ipam, _, err := n.getController().getIPAMDriver(n.ipamType)
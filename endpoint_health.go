@@ -0,0 +1,251 @@
+package libnetwork
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/docker/go-events"
+	"github.com/docker/libnetwork/networkdb"
+	"github.com/sirupsen/logrus"
+)
+
+// libnetworkEPHealthTable carries the addresses of the unhealthy service
+// endpoints, keyed by endpoint id, so that all the nodes can leave them out
+// of their DNS answers
+const libnetworkEPHealthTable = "endpoint_health_table"
+
+// SetEndpointHealth records the health state reported for the endpoint
+// identified by eid on the network identified by nid. The addresses of
+// unhealthy endpoints are left out of the answers for names resolving to
+// multiple addresses, unless no healthy address is left, and their backends
+// are taken out of the service load balancers. An unhealthy endpoint
+// reporting healthy is used again after the health grace period.
+func (c *controller) SetEndpointHealth(nid, eid string, healthy bool) error {
+	n, err := c.NetworkByID(nid)
+	if err != nil {
+		return err
+	}
+	e, err := n.EndpointByID(eid)
+	if err != nil {
+		return err
+	}
+
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	if healthy && c.deferRecovery(nid, eid) {
+		return nil
+	}
+	c.cancelRecovery(eid)
+	c.applyEndpointHealth(n.(*network), e.(*endpoint), healthy)
+	return nil
+}
+
+// deferRecovery delays the recovery of an unhealthy endpoint by the health
+// grace period. Returns false when the recovery is immediate. Must be called
+// with the health lock held.
+func (c *controller) deferRecovery(nid, eid string) bool {
+	grace := c.Config().Daemon.HealthGrace
+	if grace <= 0 || !c.isEndpointUnhealthy(nid, eid) {
+		return false
+	}
+	if _, ok := c.healthRecoveries[eid]; ok {
+		return true
+	}
+	var t *time.Timer
+	t = time.AfterFunc(grace, func() {
+		c.healthMu.Lock()
+		defer c.healthMu.Unlock()
+		if c.healthRecoveries[eid] != t {
+			// cancelled in the meantime
+			return
+		}
+		delete(c.healthRecoveries, eid)
+		n, err := c.NetworkByID(nid)
+		if err != nil {
+			return
+		}
+		e, err := n.EndpointByID(eid)
+		if err != nil {
+			return
+		}
+		c.applyEndpointHealth(n.(*network), e.(*endpoint), true)
+	})
+	if c.healthRecoveries == nil {
+		c.healthRecoveries = make(map[string]*time.Timer)
+	}
+	c.healthRecoveries[eid] = t
+	logrus.Debugf("Endpoint %s of network %s is healthy, recovering in %v", eid, nid, grace)
+	return true
+}
+
+// cancelRecovery cancels the pending recovery of an endpoint. Must be called
+// with the health lock held.
+func (c *controller) cancelRecovery(eid string) {
+	if t, ok := c.healthRecoveries[eid]; ok {
+		t.Stop()
+		delete(c.healthRecoveries, eid)
+	}
+}
+
+func (c *controller) applyEndpointHealth(n *network, ep *endpoint, healthy bool) {
+	nid, eid := n.ID(), ep.ID()
+	var ips []net.IP
+	if iface := ep.Iface(); iface != nil {
+		if iface.Address() != nil {
+			ips = append(ips, iface.Address().IP)
+		}
+		if iface.AddressIPv6() != nil {
+			ips = append(ips, iface.AddressIPv6().IP)
+		}
+	}
+	if !c.updateEndpointHealth(nid, eid, ips, healthy) {
+		return
+	}
+
+	logrus.Debugf("Endpoint %s of network %s is now healthy:%t", eid, nid, healthy)
+
+	if ep.svcID == "" || !n.isClusterEligible() {
+		return
+	}
+	c.setServiceBindingHealth(nid, eid, healthy)
+	agent := c.getAgent()
+	if agent == nil {
+		return
+	}
+	var err error
+	if healthy {
+		err = agent.networkDB.DeleteEntry(libnetworkEPHealthTable, nid, eid)
+	} else {
+		err = agent.networkDB.CreateEntry(libnetworkEPHealthTable, nid, eid, []byte(joinIPs(ips)))
+	}
+	if err != nil {
+		logrus.Warnf("Failed to propagate the health state of endpoint %s in network %s: %v", eid, nid, err)
+	}
+}
+
+// updateEndpointHealth updates the addresses of the unhealthy endpoints of
+// the network. Returns false if the state did not change.
+func (c *controller) updateEndpointHealth(nid, eid string, ips []net.IP, healthy bool) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	_, unhealthy := c.endpointHealth[nid][eid]
+	if healthy {
+		if !unhealthy {
+			return false
+		}
+		delete(c.endpointHealth[nid], eid)
+		return true
+	}
+	if unhealthy {
+		return false
+	}
+	if c.endpointHealth == nil {
+		c.endpointHealth = make(map[string]map[string][]net.IP)
+	}
+	if c.endpointHealth[nid] == nil {
+		c.endpointHealth[nid] = make(map[string][]net.IP)
+	}
+	c.endpointHealth[nid][eid] = ips
+	return true
+}
+
+// isEndpointUnhealthy returns true if the endpoint was reported unhealthy
+func (c *controller) isEndpointUnhealthy(nid, eid string) bool {
+	c.Lock()
+	defer c.Unlock()
+	_, unhealthy := c.endpointHealth[nid][eid]
+	return unhealthy
+}
+
+// clearEndpointHealth drops the health state of a deleted endpoint
+func (c *controller) clearEndpointHealth(nid, eid string) {
+	c.healthMu.Lock()
+	c.cancelRecovery(eid)
+	c.healthMu.Unlock()
+	if !c.updateEndpointHealth(nid, eid, nil, true) {
+		return
+	}
+	if agent := c.getAgent(); agent != nil {
+		// the entry only exists for the service endpoints in the cluster
+		agent.networkDB.DeleteEntry(libnetworkEPHealthTable, nid, eid)
+	}
+}
+
+// filterUnhealthy returns the addresses of ips which do not belong to an
+// unhealthy endpoint of the network. All the addresses are returned when
+// none of them is healthy. Must be called with the controller lock held.
+func (c *controller) filterUnhealthy(nid string, ips []net.IP) []net.IP {
+	unhealthy := c.endpointHealth[nid]
+	if len(unhealthy) == 0 || len(ips) < 2 {
+		return ips
+	}
+	skip := make(map[string]bool)
+	for _, epIPs := range unhealthy {
+		for _, ip := range epIPs {
+			skip[ip.String()] = true
+		}
+	}
+	var healthy []net.IP
+	for _, ip := range ips {
+		if !skip[ip.String()] {
+			healthy = append(healthy, ip)
+		}
+	}
+	if len(healthy) == 0 {
+		return ips
+	}
+	return healthy
+}
+
+func (c *controller) handleEpHealthTableEvent(ev events.Event) {
+	var (
+		nid   string
+		eid   string
+		value []byte
+		isAdd bool
+	)
+
+	switch event := ev.(type) {
+	case networkdb.CreateEvent:
+		nid = event.NetworkID
+		eid = event.Key
+		value = event.Value
+		isAdd = true
+	case networkdb.UpdateEvent:
+		nid = event.NetworkID
+		eid = event.Key
+		value = event.Value
+		isAdd = true
+	case networkdb.DeleteEvent:
+		nid = event.NetworkID
+		eid = event.Key
+	default:
+		logrus.Errorf("Unexpected endpoint health table event = %#v", event)
+		return
+	}
+
+	logrus.Debugf("handleEpHealthTableEvent %s unhealthy:%t ips:%s", eid, isAdd, value)
+	if c.updateEndpointHealth(nid, eid, splitIPs(string(value)), !isAdd) {
+		c.setServiceBindingHealth(nid, eid, !isAdd)
+	}
+}
+
+func joinIPs(ips []net.IP) string {
+	s := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		s = append(s, ip.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func splitIPs(s string) []net.IP {
+	var ips []net.IP
+	for _, v := range strings.Split(s, ",") {
+		if ip := net.ParseIP(v); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,730 @@
+package libnetwork
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// exportTimeout is how long an exported endpoint waits for its import.
+// Past it the export is void: deleting the endpoint releases its addresses
+// and cluster records, and the ones kept by a deleted endpoint are released.
+var exportTimeout = 10 * time.Minute
+
+// migrationSweepInterval is how often the claims of the global scope
+// networks are swept for the expired and released ones
+var migrationSweepInterval = time.Minute
+
+// EndpointMigration is the identity of an endpoint exported from a node, to
+// re-create the endpoint on another node with the same id, addresses and
+// service registrations. It is JSON serializable.
+type EndpointMigration struct {
+	ID                string
+	Name              string
+	NetworkID         string
+	Address           string `json:",omitempty"`
+	AddressIPv6       string `json:",omitempty"`
+	MacAddress        string `json:",omitempty"`
+	ExposedPorts      []types.TransportPort
+	Anonymous         bool
+	DisableResolution bool
+	Aliases           map[string]string
+	MyAliases         []string
+	ServiceName       string `json:",omitempty"`
+	ServiceID         string `json:",omitempty"`
+	VirtualIP         string `json:",omitempty"`
+	ServiceAliases    []string
+	IngressPorts      []*PortConfig
+	LBScheduler       string        `json:",omitempty"`
+	LBPersistence     time.Duration `json:",omitempty"`
+	LBWeight          int           `json:",omitempty"`
+	LoadBalancer      bool
+	// Token proves the import is the one of this export
+	Token string
+}
+
+// isExported returns whether the endpoint was exported, and the export did
+// not expire
+func (ep *endpoint) isExported() bool {
+	ep.Lock()
+	defer ep.Unlock()
+	return ep.exported && time.Now().Before(ep.exportExpiry)
+}
+
+func (ep *endpoint) isMigrated() bool {
+	ep.Lock()
+	defer ep.Unlock()
+	return ep.migrated
+}
+
+// Export returns the identity of the endpoint and marks it as exported. The
+// endpoint is then deleted on this node and imported on the other node with
+// ImportEndpoint. The exported endpoint keeps its cluster records and its
+// addresses in the global scope networks when it is deleted, the imported
+// endpoint presenting the token of the export takes them over. The export
+// expires after exportTimeout, or is cancelled with Unexport.
+func (ep *endpoint) Export() (*EndpointMigration, error) {
+	n, err := ep.getNetworkFromStore()
+	if err != nil {
+		return nil, err
+	}
+	if n.hasSpecialDriver() {
+		return nil, types.ForbiddenErrorf("the endpoints of network %s cannot be migrated", n.Name())
+	}
+	ep, err = n.getEndpointFromStore(ep.ID())
+	if err != nil {
+		return nil, err
+	}
+
+	token := stringid.GenerateRandomID()
+	expiry := time.Now().Add(exportTimeout)
+	claim := &migrationClaim{n: n, EndpointID: ep.ID(), Token: token, Expiry: expiry}
+	c := n.getController()
+	if agent := c.getAgent(); agent != nil && n.isClusterEligible() {
+		claim.Node = agent.networkDB.NodeID()
+	}
+
+	ep.Lock()
+	m := &EndpointMigration{
+		ID:                ep.id,
+		Name:              ep.name,
+		NetworkID:         n.ID(),
+		ExposedPorts:      append([]types.TransportPort(nil), ep.exposedPorts...),
+		Anonymous:         ep.anonymous,
+		DisableResolution: ep.disableResolution,
+		MyAliases:         append([]string(nil), ep.myAliases...),
+		ServiceName:       ep.svcName,
+		ServiceID:         ep.svcID,
+		ServiceAliases:    append([]string(nil), ep.svcAliases...),
+		IngressPorts:      ep.ingressPorts,
+		LBScheduler:       ep.svcScheduler,
+		LBPersistence:     ep.svcPersistence,
+		LBWeight:          ep.svcWeight,
+		LoadBalancer:      ep.loadBalancer,
+		Token:             token,
+	}
+	if len(ep.aliases) > 0 {
+		m.Aliases = make(map[string]string, len(ep.aliases))
+		for k, v := range ep.aliases {
+			m.Aliases[k] = v
+		}
+	}
+	if ep.virtualIP != nil {
+		m.VirtualIP = ep.virtualIP.String()
+	}
+	if ep.iface.addr != nil {
+		m.Address = ep.iface.addr.String()
+		claim.Address = ep.iface.addr.IP.String()
+		claim.V4PoolID = ep.iface.v4PoolID
+	}
+	if ep.iface.addrv6 != nil {
+		m.AddressIPv6 = ep.iface.addrv6.String()
+		claim.AddressIPv6 = ep.iface.addrv6.IP.String()
+		claim.V6PoolID = ep.iface.v6PoolID
+	}
+	if ep.iface.mac != nil {
+		m.MacAddress = ep.iface.mac.String()
+	}
+	ep.Unlock()
+
+	// the sandbox holds its own copy of the endpoint, with the entries the
+	// driver added to the cluster
+	sbEp := ep.sandboxEndpoint()
+	if sbEp != nil {
+		sbEp.Lock()
+		if sbEp.joinInfo != nil {
+			for _, te := range sbEp.joinInfo.driverTableEntries {
+				claim.Entries = append(claim.Entries, migrationEntry{Table: te.tableName, Key: te.key})
+			}
+		}
+		sbEp.Unlock()
+	}
+
+	if n.DataScope() == datastore.GlobalScope {
+		if err := c.updateToStore(claim); err != nil {
+			return nil, err
+		}
+	}
+	ep.setExport(token, expiry)
+	if err := c.updateToStore(ep); err != nil {
+		n.deleteMigrationClaim(ep.ID())
+		return nil, err
+	}
+	if sbEp != nil {
+		sbEp.setExport(token, expiry)
+	}
+
+	logrus.Debugf("Exported endpoint %s (%s) of network %s", m.Name, m.ID, n.Name())
+	return m, nil
+}
+
+// Unexport cancels the export of the endpoint
+func (ep *endpoint) Unexport() error {
+	n, err := ep.getNetworkFromStore()
+	if err != nil {
+		return err
+	}
+	ep, err = n.getEndpointFromStore(ep.ID())
+	if err != nil {
+		return err
+	}
+
+	ep.Lock()
+	exported := ep.exported
+	ep.Unlock()
+	if !exported {
+		return nil
+	}
+
+	ep.setExport("", time.Time{})
+	if err := n.getController().updateToStore(ep); err != nil {
+		return err
+	}
+	if sbEp := ep.sandboxEndpoint(); sbEp != nil {
+		sbEp.setExport("", time.Time{})
+	}
+	n.deleteMigrationClaim(ep.ID())
+
+	logrus.Debugf("Cancelled the export of endpoint %s (%s) of network %s", ep.Name(), ep.ID(), n.Name())
+	return nil
+}
+
+// setExport marks the endpoint as exported with the token until expiry,
+// or as not exported with an empty token
+func (ep *endpoint) setExport(token string, expiry time.Time) {
+	ep.Lock()
+	ep.exported = token != ""
+	ep.exportToken = token
+	ep.exportExpiry = expiry
+	ep.Unlock()
+}
+
+// sandboxEndpoint returns the copy of the endpoint held by its sandbox, if
+// it is joined to one
+func (ep *endpoint) sandboxEndpoint() *endpoint {
+	if sb, ok := ep.getSandbox(); ok {
+		return sb.getEndpoint(ep.ID())
+	}
+	return nil
+}
+
+// handOver leaves the addresses and cluster records of the deleted exported
+// endpoint to its import until the export expires, or releases them when
+// the export already expired
+func (ep *endpoint) handOver(n *network) {
+	ep.Lock()
+	exported, expiry, eid := ep.exported, ep.exportExpiry, ep.id
+	ep.Unlock()
+	if !exported || n.DataScope() != datastore.GlobalScope {
+		return
+	}
+	if d := time.Until(expiry); d > 0 {
+		time.AfterFunc(d, func() { n.expireMigrationClaim(eid) })
+		return
+	}
+	n.deleteMigrationClaim(eid)
+}
+
+// clearMigrated marks the migrated endpoint as having taken over the
+// cluster records of the exported one, its next registrations creating
+// their own
+func (ep *endpoint) clearMigrated() {
+	ep.Lock()
+	if !ep.migrated {
+		ep.Unlock()
+		return
+	}
+	ep.migrated = false
+	ep.Unlock()
+
+	n := ep.getNetwork()
+	stored, err := n.getEndpointFromStore(ep.ID())
+	if err != nil {
+		logrus.Warnf("Failed to get the migrated endpoint %s from the store: %v", ep.Name(), err)
+		return
+	}
+	stored.Lock()
+	stored.migrated = false
+	stored.Unlock()
+	if err := n.getController().updateToStore(stored); err != nil {
+		logrus.Warnf("Failed to update the migrated endpoint %s in the store: %v", ep.Name(), err)
+	}
+	if sbEp := stored.sandboxEndpoint(); sbEp != nil && sbEp != ep {
+		sbEp.Lock()
+		sbEp.migrated = false
+		sbEp.Unlock()
+	}
+}
+
+// ImportEndpoint re-creates on this node the endpoint exported from another
+// node, with the same id, addresses and service registrations
+func (n *network) ImportEndpoint(ctx context.Context, m *EndpointMigration, options ...EndpointOption) (Endpoint, error) {
+	if m.NetworkID != n.ID() {
+		return nil, types.BadRequestErrorf("endpoint %s was exported from network %s, not from %s", m.Name, m.NetworkID, n.ID())
+	}
+	if _, err := n.EndpointByID(m.ID); err == nil {
+		return nil, types.ForbiddenErrorf("endpoint %s already exists in network %s", m.ID, n.Name())
+	}
+	opts, err := m.endpointOptions()
+	if err != nil {
+		return nil, err
+	}
+	ep, err := n.CreateEndpointContext(ctx, m.Name, append(opts, options...)...)
+	if err != nil {
+		return nil, err
+	}
+	// the addresses are the ones of the imported endpoint now
+	n.deleteMigrationClaim(m.ID)
+	return ep, nil
+}
+
+// AbortMigration cancels the migration of the endpoint exported with m
+func (n *network) AbortMigration(m *EndpointMigration) error {
+	if m.NetworkID != n.ID() {
+		return types.BadRequestErrorf("endpoint %s was exported from network %s, not from %s", m.Name, m.NetworkID, n.ID())
+	}
+	if ep, err := n.EndpointByID(m.ID); err == nil {
+		return ep.Unexport()
+	}
+	if n.DataScope() != datastore.GlobalScope {
+		// the deleted endpoint released its addresses
+		return nil
+	}
+
+	claim, err := n.getMigrationClaim(m.ID)
+	if err != nil {
+		return types.NotFoundErrorf("no pending migration of endpoint %s in network %s", m.ID, n.Name())
+	}
+	if claim.Token != m.Token {
+		return types.ForbiddenErrorf("the migration of endpoint %s in network %s is not the one of this export", m.ID, n.Name())
+	}
+	return n.releaseMigrationClaim(claim)
+}
+
+// endpointOptions returns the endpoint options re-creating the endpoint
+func (m *EndpointMigration) endpointOptions() ([]EndpointOption, error) {
+	var ip, ip6 net.IP
+	if m.Address != "" {
+		addr, err := types.ParseCIDR(m.Address)
+		if err != nil {
+			return nil, types.BadRequestErrorf("invalid address %q of endpoint %s: %v", m.Address, m.Name, err)
+		}
+		ip = addr.IP
+	}
+	if m.AddressIPv6 != "" {
+		addr, err := types.ParseCIDR(m.AddressIPv6)
+		if err != nil {
+			return nil, types.BadRequestErrorf("invalid IPv6 address %q of endpoint %s: %v", m.AddressIPv6, m.Name, err)
+		}
+		ip6 = addr.IP
+	}
+
+	opts := []EndpointOption{
+		func(ep *endpoint) {
+			ep.id = m.ID
+			ep.migrated = true
+			ep.migrationToken = m.Token
+		},
+		CreateOptionIpam(ip, ip6, nil, nil),
+		CreateOptionExposedPorts(m.ExposedPorts),
+	}
+	if m.MacAddress != "" {
+		mac, err := net.ParseMAC(m.MacAddress)
+		if err != nil {
+			return nil, types.BadRequestErrorf("invalid MAC address %q of endpoint %s: %v", m.MacAddress, m.Name, err)
+		}
+		opts = append(opts, EndpointOptionGeneric(map[string]interface{}{netlabel.MacAddress: mac}))
+	}
+	if m.Anonymous {
+		opts = append(opts, CreateOptionAnonymous())
+	}
+	if m.DisableResolution {
+		opts = append(opts, CreateOptionDisableResolution())
+	}
+	for name, alias := range m.Aliases {
+		opts = append(opts, CreateOptionAlias(name, alias))
+	}
+	for _, alias := range m.MyAliases {
+		opts = append(opts, CreateOptionMyAlias(alias))
+	}
+	if m.ServiceID != "" {
+		opts = append(opts,
+			CreateOptionService(m.ServiceName, m.ServiceID, net.ParseIP(m.VirtualIP), m.IngressPorts, m.ServiceAliases),
+			CreateOptionServiceScheduler(m.LBScheduler),
+			CreateOptionServicePersistence(m.LBPersistence),
+			CreateOptionServiceWeight(m.LBWeight))
+	}
+	if m.LoadBalancer {
+		opts = append(opts, CreateOptionLoadBalancer())
+	}
+	return opts, nil
+}
+
+const migrationClaimPrefix = "endpoint_migration"
+
+// migrationClaim is the record, in the store of a global scope network, of
+// the addresses and cluster entries an exported endpoint keeps for its
+// import. Only the import presenting the token of the export takes them
+// over. The cluster entries are the ones of Node, the node of the export,
+// which alone deletes them: a claim released by another node is marked
+// Released until its node sweeps it.
+type migrationClaim struct {
+	n           *network
+	EndpointID  string
+	Token       string
+	Expiry      time.Time
+	Node        string           `json:",omitempty"`
+	Released    bool             `json:",omitempty"`
+	Address     string           `json:",omitempty"`
+	V4PoolID    string           `json:",omitempty"`
+	AddressIPv6 string           `json:",omitempty"`
+	V6PoolID    string           `json:",omitempty"`
+	Entries     []migrationEntry `json:",omitempty"`
+	dbIndex     uint64
+	dbExists    bool
+	sync.Mutex
+}
+
+// migrationEntry is a cluster entry the driver added for the endpoint
+type migrationEntry struct {
+	Table string
+	Key   string
+}
+
+func (mc *migrationClaim) Key() []string {
+	mc.Lock()
+	defer mc.Unlock()
+
+	return []string{migrationClaimPrefix, mc.n.id, mc.EndpointID}
+}
+
+func (mc *migrationClaim) KeyPrefix() []string {
+	mc.Lock()
+	defer mc.Unlock()
+
+	return []string{migrationClaimPrefix, mc.n.id}
+}
+
+func (mc *migrationClaim) Value() []byte {
+	mc.Lock()
+	defer mc.Unlock()
+
+	b, err := json.Marshal(mc)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (mc *migrationClaim) SetValue(value []byte) error {
+	mc.Lock()
+	defer mc.Unlock()
+
+	return json.Unmarshal(value, mc)
+}
+
+func (mc *migrationClaim) Index() uint64 {
+	mc.Lock()
+	defer mc.Unlock()
+	return mc.dbIndex
+}
+
+func (mc *migrationClaim) SetIndex(index uint64) {
+	mc.Lock()
+	mc.dbIndex = index
+	mc.dbExists = true
+	mc.Unlock()
+}
+
+func (mc *migrationClaim) Exists() bool {
+	mc.Lock()
+	defer mc.Unlock()
+	return mc.dbExists
+}
+
+func (mc *migrationClaim) Skip() bool {
+	mc.Lock()
+	defer mc.Unlock()
+	return !mc.n.persist
+}
+
+func (mc *migrationClaim) New() datastore.KVObject {
+	mc.Lock()
+	defer mc.Unlock()
+
+	return &migrationClaim{
+		n:          mc.n,
+		EndpointID: mc.EndpointID,
+	}
+}
+
+func (mc *migrationClaim) CopyTo(o datastore.KVObject) error {
+	mc.Lock()
+	defer mc.Unlock()
+
+	dstMc := o.(*migrationClaim)
+	dstMc.n = mc.n
+	dstMc.EndpointID = mc.EndpointID
+	dstMc.Token = mc.Token
+	dstMc.Expiry = mc.Expiry
+	dstMc.Node = mc.Node
+	dstMc.Released = mc.Released
+	dstMc.Address = mc.Address
+	dstMc.V4PoolID = mc.V4PoolID
+	dstMc.AddressIPv6 = mc.AddressIPv6
+	dstMc.V6PoolID = mc.V6PoolID
+	dstMc.Entries = append([]migrationEntry(nil), mc.Entries...)
+	dstMc.dbIndex = mc.dbIndex
+	dstMc.dbExists = mc.dbExists
+
+	return nil
+}
+
+func (mc *migrationClaim) DataScope() string {
+	return mc.n.DataScope()
+}
+
+// getMigrationClaim returns the claim of the migration of the endpoint
+func (n *network) getMigrationClaim(eid string) (*migrationClaim, error) {
+	store := n.getController().getStore(n.DataScope())
+	if store == nil {
+		return nil, ErrDataStoreNotInitialized(n.DataScope())
+	}
+	claim := &migrationClaim{n: n, EndpointID: eid}
+	if err := store.GetObject(datastore.Key(claim.Key()...), claim); err != nil {
+		return nil, err
+	}
+	return claim, nil
+}
+
+// hasMigrationClaim returns whether the migration of the endpoint has a
+// claim on its addresses
+func (n *network) hasMigrationClaim(eid string) bool {
+	claim, err := n.getMigrationClaim(eid)
+	return err == nil && !claim.Released
+}
+
+// checkMigrationClaim checks that the address allocated to another endpoint
+// is handed over to the migrated endpoint by the claim of its export
+func (n *network) checkMigrationClaim(ep *endpoint, ip net.IP) error {
+	ep.Lock()
+	eid, token := ep.id, ep.migrationToken
+	ep.Unlock()
+
+	claim, err := n.getMigrationClaim(eid)
+	if err != nil {
+		return types.ForbiddenErrorf("address %s is in use and no export of endpoint %s hands it over: %v", ip, eid, err)
+	}
+	if claim.Released {
+		return types.ForbiddenErrorf("address %s is in use and the export of endpoint %s was released", ip, eid)
+	}
+	if token == "" || claim.Token != token {
+		return types.ForbiddenErrorf("address %s is in use and endpoint %s was not imported from its export", ip, eid)
+	}
+	if !time.Now().Before(claim.Expiry) {
+		return types.ForbiddenErrorf("address %s is in use and the export of endpoint %s expired", ip, eid)
+	}
+	if !ip.Equal(net.ParseIP(claim.Address)) && !ip.Equal(net.ParseIP(claim.AddressIPv6)) {
+		return types.ForbiddenErrorf("address %s is in use and is not an address of the exported endpoint %s", ip, eid)
+	}
+	return nil
+}
+
+// deleteMigrationClaim deletes the claim of the migration of the endpoint,
+// if any
+func (n *network) deleteMigrationClaim(eid string) {
+	if n.DataScope() != datastore.GlobalScope {
+		return
+	}
+	claim, err := n.getMigrationClaim(eid)
+	if err != nil {
+		return
+	}
+	if err := n.getController().deleteFromStore(claim); err != nil {
+		logrus.Warnf("Failed to delete the migration claim of endpoint %s in network %s: %v", eid, n.Name(), err)
+	}
+}
+
+// expireMigrationClaim releases the addresses and cluster records kept for
+// the import of the deleted endpoint, once its export expired without it
+func (n *network) expireMigrationClaim(eid string) {
+	claim, err := n.getMigrationClaim(eid)
+	if err != nil {
+		// imported or aborted
+		return
+	}
+	if !claim.Released {
+		logrus.Infof("The export of endpoint %s of network %s expired without import", eid, n.Name())
+	}
+	if err := n.releaseMigrationClaim(claim); err != nil {
+		logrus.Warnf("Failed to release the migration claim of endpoint %s in network %s: %v", eid, n.Name(), err)
+	}
+}
+
+// releaseMigrationClaim releases the addresses of the claim, and the
+// cluster records of the claim when they are the ones of this node. The
+// claim is then deleted, or marked released for its node to delete its
+// cluster records, until this node joins the cluster. The records of a
+// node gone from the cluster were reaped with it.
+func (n *network) releaseMigrationClaim(claim *migrationClaim) error {
+	c := n.getController()
+	agent := c.getAgent()
+	if !n.isClusterEligible() {
+		agent = nil
+	}
+	owned := claim.Node == "" || agent != nil && claim.Node == agent.networkDB.NodeID()
+	gone := !owned && agent != nil && !agent.isClusterPeer(claim.Node)
+	released := claim.Released
+
+	// the claim is deleted, or marked released, first so that a
+	// concurrent import fails to take over the addresses
+	switch {
+	case owned || gone:
+		if err := c.deleteFromStore(claim); err != nil {
+			return err
+		}
+	case !released:
+		claim.Released = true
+		if err := c.updateToStore(claim); err != nil {
+			return err
+		}
+	default:
+		// left to the node of the claim
+		return nil
+	}
+
+	if !released && (claim.Address != "" || claim.AddressIPv6 != "") {
+		ipam, _, err := c.getIPAMDriver(n.ipamType)
+		if err != nil {
+			return err
+		}
+		for _, a := range []struct{ pool, ip string }{{claim.V4PoolID, claim.Address}, {claim.V6PoolID, claim.AddressIPv6}} {
+			if a.ip == "" {
+				continue
+			}
+			if err := ipam.ReleaseAddress(a.pool, net.ParseIP(a.ip)); err != nil {
+				logrus.Warnf("Failed to release address %s of the migrated endpoint %s: %v", a.ip, claim.EndpointID, err)
+			}
+		}
+	}
+
+	if owned && agent != nil {
+		for _, e := range claim.Entries {
+			if err := agent.networkDB.DeleteOwnEntry(e.Table, n.ID(), e.Key); err != nil {
+				logrus.Debugf("Failed to delete the %s entry of the migrated endpoint %s: %v", e.Table, claim.EndpointID, err)
+			}
+		}
+		if err := agent.serviceRecords.DeleteEntry(n.ID(), claim.EndpointID); err != nil {
+			logrus.Debugf("Failed to delete the service record of the migrated endpoint %s: %v", claim.EndpointID, err)
+		}
+	}
+
+	logrus.Debugf("Released the migration claim of endpoint %s in network %s", claim.EndpointID, n.Name())
+	return nil
+}
+
+// sweepMigrationClaims releases the claims of the network whose export
+// expired, in place of the node of the export which may be gone, and
+// deletes the cluster records of the claims of this node another node
+// released. The claim of an expired export whose endpoint is not deleted
+// yet is only deleted, the endpoint releasing its addresses.
+func (n *network) sweepMigrationClaims() {
+	store := n.getController().getStore(n.DataScope())
+	if store == nil {
+		return
+	}
+	kvol, err := store.List(datastore.Key(migrationClaimPrefix, n.ID()), &migrationClaim{n: n})
+	if err != nil {
+		if err != datastore.ErrKeyNotFound {
+			logrus.Warnf("Failed to list the migration claims of network %s: %v", n.Name(), err)
+		}
+		return
+	}
+	for _, kvo := range kvol {
+		claim := kvo.(*migrationClaim)
+		if !claim.Released && time.Now().Before(claim.Expiry) {
+			continue
+		}
+		if !claim.Released {
+			if _, err := n.getEndpointFromStore(claim.EndpointID); err == nil {
+				n.deleteMigrationClaim(claim.EndpointID)
+				continue
+			}
+			logrus.Infof("The export of endpoint %s of network %s expired without import", claim.EndpointID, n.Name())
+		}
+		if err := n.releaseMigrationClaim(claim); err != nil {
+			logrus.Warnf("Failed to release the migration claim of endpoint %s in network %s: %v", claim.EndpointID, n.Name(), err)
+		}
+	}
+}
+
+// sweepMigrationClaims sweeps the migration claims of the global scope
+// networks
+func (c *controller) sweepMigrationClaims() {
+	if c.getStore(datastore.GlobalScope) == nil {
+		return
+	}
+	nws, err := c.getNetworksFromStore()
+	if err != nil {
+		logrus.Warnf("Failed to get the networks to sweep their migration claims: %v", err)
+		return
+	}
+	for _, n := range nws {
+		if n.DataScope() == datastore.GlobalScope && !n.hasSpecialDriver() {
+			n.sweepMigrationClaims()
+		}
+	}
+}
+
+// startMigrationSweeper sweeps the migration claims now, for the exports
+// which expired while no node swept them, and every migrationSweepInterval
+func (c *controller) startMigrationSweeper() {
+	if c.getStore(datastore.GlobalScope) == nil {
+		return
+	}
+	stop := make(chan struct{})
+	c.Lock()
+	c.migrationSweepStop = stop
+	c.Unlock()
+	go func() {
+		c.sweepMigrationClaims()
+		ticker := time.NewTicker(migrationSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.sweepMigrationClaims()
+			}
+		}
+	}()
+}
+
+func (c *controller) stopMigrationSweeper() {
+	c.Lock()
+	stop := c.migrationSweepStop
+	c.migrationSweepStop = nil
+	c.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+}
+
+// isClusterPeer returns whether the node is a member of the cluster
+func (a *agent) isClusterPeer(node string) bool {
+	for _, p := range a.networkDB.ClusterPeers() {
+		if p.Name == node {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package libnetwork
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/netlabel"
+)
+
+func TestEndpointMigrationOptions(t *testing.T) {
+	m := &EndpointMigration{
+		ID:          "ep1",
+		Name:        "web",
+		NetworkID:   "n1",
+		Address:     "10.0.0.2/24",
+		MacAddress:  "02:42:0a:00:00:02",
+		MyAliases:   []string{"web.1"},
+		ServiceName: "web",
+		ServiceID:   "svc1",
+		VirtualIP:   "10.0.0.10",
+		LBWeight:    3,
+		Token:       "token1",
+	}
+	opts, err := m.endpointOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &endpoint{generic: make(map[string]interface{})}
+	ep.processOptions(opts...)
+
+	if ep.id != "ep1" || !ep.migrated {
+		t.Fatalf("expected the migrated endpoint to keep its id, got %q (migrated %v)", ep.id, ep.migrated)
+	}
+	if ep.migrationToken != "token1" {
+		t.Fatalf("expected the migrated endpoint to carry the export token, got %q", ep.migrationToken)
+	}
+	if !ep.prefAddress.Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("unexpected preferred address %v", ep.prefAddress)
+	}
+	if mac, ok := ep.generic[netlabel.MacAddress].(net.HardwareAddr); !ok || mac.String() != m.MacAddress {
+		t.Fatalf("unexpected MAC address %v", ep.generic[netlabel.MacAddress])
+	}
+	if ep.svcID != "svc1" || !ep.virtualIP.Equal(net.ParseIP("10.0.0.10")) || ep.svcWeight != 3 {
+		t.Fatalf("unexpected service registration %s %v %d", ep.svcID, ep.virtualIP, ep.svcWeight)
+	}
+	if len(ep.myAliases) != 1 || ep.myAliases[0] != "web.1" {
+		t.Fatalf("unexpected aliases %v", ep.myAliases)
+	}
+
+	m.Address = "10.0.0.2"
+	if _, err := m.endpointOptions(); err == nil {
+		t.Fatal("expected an invalid address to fail")
+	}
+}
+
+func TestEndpointExportExpiry(t *testing.T) {
+	ep := &endpoint{}
+	if ep.isExported() {
+		t.Fatal("expected a new endpoint not to be exported")
+	}
+
+	ep.setExport("token1", time.Now().Add(time.Minute))
+	if !ep.isExported() {
+		t.Fatal("expected the endpoint to be exported")
+	}
+
+	ep.setExport("token1", time.Now().Add(-time.Second))
+	if ep.isExported() {
+		t.Fatal("expected the expired export to be void")
+	}
+
+	ep.setExport("", time.Time{})
+	if ep.isExported() || ep.exportToken != "" {
+		t.Fatal("expected the cancelled export to be cleared")
+	}
+}
+
+func TestEndpointExportMarshal(t *testing.T) {
+	expiry := time.Now().Add(time.Minute).Round(0)
+	ep := &endpoint{id: "ep1", name: "web", iface: &endpointInterface{}}
+	ep.setExport("token1", expiry)
+
+	b, err := ep.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := &endpoint{}
+	if err := dst.UnmarshalJSON(b); err != nil {
+		t.Fatal(err)
+	}
+	if !dst.exported || dst.exportToken != "token1" || !dst.exportExpiry.Equal(expiry) {
+		t.Fatalf("unexpected export after unmarshal: %v %q %v", dst.exported, dst.exportToken, dst.exportExpiry)
+	}
+}
+
+func TestMigrationClaimValue(t *testing.T) {
+	n := &network{id: "n1"}
+	mc := &migrationClaim{
+		n:          n,
+		EndpointID: "ep1",
+		Token:      "token1",
+		Expiry:     time.Now().Add(time.Minute).Round(0),
+		Address:    "10.0.0.2",
+		V4PoolID:   "pool1",
+		Entries:    []migrationEntry{{Table: "overlay_peer_table", Key: "ep1"}},
+		Node:       "node1",
+		Released:   true,
+	}
+	if k := mc.Key(); len(k) != 3 || k[0] != migrationClaimPrefix || k[1] != "n1" || k[2] != "ep1" {
+		t.Fatalf("unexpected key %v", k)
+	}
+
+	dst := mc.New().(*migrationClaim)
+	if err := dst.SetValue(mc.Value()); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Token != mc.Token || !dst.Expiry.Equal(mc.Expiry) || dst.Address != mc.Address ||
+		dst.V4PoolID != mc.V4PoolID || len(dst.Entries) != 1 || dst.Entries[0] != mc.Entries[0] ||
+		dst.Node != mc.Node || dst.Released != mc.Released {
+		t.Fatalf("unexpected claim after SetValue: %+v", dst)
+	}
+}
+
+func TestSweepMigrationClaims(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "libnetwork-claims-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	ds, err := datastore.NewDataStore(datastore.GlobalScope, &datastore.ScopeCfg{
+		Client: datastore.ScopeClientCfg{Provider: "boltdb", Address: tmp.Name(), Config: &store.Config{Bucket: "libnetwork"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+	c := &controller{stores: []datastore.DataStore{ds}}
+	n := &network{id: "n1", name: "net1", ctrlr: c, scope: datastore.GlobalScope, persist: true}
+
+	expired := time.Now().Add(-time.Minute)
+	claims := []*migrationClaim{
+		// expired without import
+		{n: n, EndpointID: "ep1", Token: "token1", Expiry: expired},
+		// pending
+		{n: n, EndpointID: "ep2", Token: "token2", Expiry: time.Now().Add(time.Minute)},
+		// expired, its cluster records are the ones of another node
+		{n: n, EndpointID: "ep3", Token: "token3", Expiry: expired, Node: "node2"},
+	}
+	for _, mc := range claims {
+		if err := c.updateToStore(mc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n.sweepMigrationClaims()
+
+	if _, err := n.getMigrationClaim("ep1"); err == nil {
+		t.Fatal("expected the expired claim to be released")
+	}
+	if !n.hasMigrationClaim("ep2") {
+		t.Fatal("expected the pending claim to be kept")
+	}
+	mc, err := n.getMigrationClaim("ep3")
+	if err != nil {
+		t.Fatalf("expected the claim of the other node to be kept for it: %v", err)
+	}
+	if !mc.Released || n.hasMigrationClaim("ep3") {
+		t.Fatalf("expected the claim of the other node to be marked released: %+v", mc)
+	}
+}
@@ -0,0 +1,170 @@
+package libnetwork
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/libnetwork/metrics"
+)
+
+// eventBufferSize is the number of events buffered for a subscriber. The
+// events are dropped once the buffer is full, a consumer falling behind
+// finds the gap in the sequence numbers.
+const eventBufferSize = 256
+
+var (
+	eventsCounter       = metrics.NewCounter("controller", "events_total", "Lifecycle events of the networks, endpoints and sandboxes", "type")
+	driverErrorsCounter = metrics.NewCounter("controller", "driver_errors_total", "Failed network driver operations", "driver", "op")
+)
+
+// EventType is the type of a controller event
+type EventType string
+
+const (
+	// EventNetworkCreate is sent after a network is created
+	EventNetworkCreate EventType = "network.create"
+	// EventNetworkDelete is sent after a network is deleted
+	EventNetworkDelete EventType = "network.delete"
+	// EventEndpointCreate is sent after an endpoint is created
+	EventEndpointCreate EventType = "endpoint.create"
+	// EventEndpointDelete is sent after an endpoint is deleted
+	EventEndpointDelete EventType = "endpoint.delete"
+	// EventEndpointJoin is sent after an endpoint joins a sandbox
+	EventEndpointJoin EventType = "endpoint.join"
+	// EventEndpointLeave is sent after an endpoint leaves a sandbox
+	EventEndpointLeave EventType = "endpoint.leave"
+	// EventSandboxCreate is sent after a sandbox is created
+	EventSandboxCreate EventType = "sandbox.create"
+	// EventSandboxDestroy is sent after a sandbox is destroyed
+	EventSandboxDestroy EventType = "sandbox.destroy"
+	// EventDriverError is sent when a network driver fails an operation
+	EventDriverError EventType = "driver.error"
+	// EventFirewallDrift is sent when firewall rules found missing are
+	// repaired, or failed to be
+	EventFirewallDrift EventType = "firewall.drift"
+)
+
+// Event is a lifecycle event of the controller. The fields not related to
+// the event type are empty.
+type Event struct {
+	// Seq is the sequence number of the event, increasing by one from 1
+	// for each event of the controller
+	Seq          uint64
+	Type         EventType
+	Time         time.Time
+	NetworkID    string
+	NetworkName  string
+	EndpointID   string
+	EndpointName string
+	SandboxID    string
+	ContainerID  string
+	// Driver is the network driver type and Op the failed driver operation
+	// of EventDriverError
+	Driver string
+	Op     string
+	Error  string
+	// Rules are the missing firewall rules of EventFirewallDrift
+	Rules []string
+}
+
+// EventSubscription receives the events of the controller sent after its
+// creation, until it is closed
+type EventSubscription struct {
+	// C receives the events in the order of their sequence numbers
+	C <-chan Event
+
+	c       *controller
+	ch      chan Event
+	dropped uint64
+	once    sync.Once
+}
+
+// Dropped returns the number of events dropped because the subscriber fell
+// behind
+func (s *EventSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the subscription and closes C
+func (s *EventSubscription) Close() {
+	s.once.Do(func() {
+		s.c.eventsMu.Lock()
+		delete(s.c.subscribers, s)
+		s.c.eventsMu.Unlock()
+		close(s.ch)
+	})
+}
+
+// Subscribe returns a subscription to the lifecycle events of the
+// networks, endpoints and sandboxes and to the driver errors. The events
+// are never blocked on a slow subscriber, they are dropped instead.
+func (c *controller) Subscribe() *EventSubscription {
+	s := &EventSubscription{c: c, ch: make(chan Event, eventBufferSize)}
+	s.C = s.ch
+	c.eventsMu.Lock()
+	if c.subscribers == nil {
+		c.subscribers = make(map[*EventSubscription]struct{})
+	}
+	c.subscribers[s] = struct{}{}
+	c.eventsMu.Unlock()
+	return s
+}
+
+// publish numbers the event and sends it to the subscribers
+func (c *controller) publish(ev Event) {
+	eventsCounter.Inc(string(ev.Type))
+
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+	c.eventSeq++
+	ev.Seq = c.eventSeq
+	ev.Time = time.Now()
+	for s := range c.subscribers {
+		select {
+		case s.ch <- ev:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+func (c *controller) publishNetwork(t EventType, n *network) {
+	c.publish(Event{Type: t, NetworkID: n.ID(), NetworkName: n.Name()})
+}
+
+func (c *controller) publishEndpoint(t EventType, ep *endpoint, sb *sandbox) {
+	ev := Event{Type: t, EndpointID: ep.ID(), EndpointName: ep.Name()}
+	if n := ep.getNetwork(); n != nil {
+		ev.NetworkID = n.ID()
+		ev.NetworkName = n.Name()
+	}
+	if sb != nil {
+		ev.SandboxID = sb.ID()
+		ev.ContainerID = sb.ContainerID()
+	}
+	c.publish(ev)
+}
+
+func (c *controller) publishSandbox(t EventType, sb *sandbox) {
+	c.publish(Event{Type: t, SandboxID: sb.ID(), ContainerID: sb.ContainerID()})
+}
+
+// publishDriverError sends the failure of the driver operation op on the
+// network, and on the endpoint when not nil
+func (c *controller) publishDriverError(op string, n *network, ep *endpoint, err error) {
+	driverErrorsCounter.Inc(n.Type(), op)
+	ev := Event{
+		Type:        EventDriverError,
+		NetworkID:   n.ID(),
+		NetworkName: n.Name(),
+		Driver:      n.Type(),
+		Op:          op,
+		Error:       err.Error(),
+	}
+	if ep != nil {
+		ev.EndpointID = ep.ID()
+		ev.EndpointName = ep.Name()
+	}
+	c.publish(ev)
+}
@@ -0,0 +1,52 @@
+package libnetwork
+
+import (
+	"errors"
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestEventSubscription(t *testing.T) {
+	c := &controller{}
+	n := &network{id: "nid", name: "net1", networkType: "bridge", ctrlr: c}
+
+	s := c.Subscribe()
+	c.publishNetwork(EventNetworkCreate, n)
+	c.publishDriverError("Join", n, nil, errors.New("boom"))
+
+	ev := <-s.C
+	assert.Check(t, is.Equal(ev.Seq, uint64(1)))
+	assert.Check(t, is.Equal(ev.Type, EventNetworkCreate))
+	assert.Check(t, is.Equal(ev.NetworkName, "net1"))
+	ev = <-s.C
+	assert.Check(t, is.Equal(ev.Seq, uint64(2)))
+	assert.Check(t, is.Equal(ev.Type, EventDriverError))
+	assert.Check(t, is.Equal(ev.Driver, "bridge"))
+	assert.Check(t, is.Equal(ev.Op, "Join"))
+	assert.Check(t, is.Equal(ev.Error, "boom"))
+
+	// a subscriber falling behind loses the events past its buffer
+	for i := 0; i < eventBufferSize+3; i++ {
+		c.publishNetwork(EventNetworkDelete, n)
+	}
+	assert.Check(t, is.Equal(s.Dropped(), uint64(3)))
+	ev = <-s.C
+	assert.Check(t, is.Equal(ev.Seq, uint64(3)))
+
+	// a late subscriber only gets the later events
+	late := c.Subscribe()
+	c.publishNetwork(EventNetworkCreate, n)
+	ev = <-late.C
+	assert.Check(t, is.Equal(ev.Seq, uint64(eventBufferSize+6)))
+
+	s.Close()
+	s.Close()
+	for range s.C {
+	}
+	late.Close()
+	_, ok := <-late.C
+	assert.Check(t, !ok)
+	c.publishNetwork(EventNetworkCreate, n)
+}
@@ -0,0 +1,147 @@
+// Package faults is a registry of the faults injected in the libnetwork
+// operations, for chaos and integration tests to exercise the real code
+// paths against failing iptables calls, slow stores, dying userland
+// proxies and lost gossip. No fault is injected until one is registered.
+package faults
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The fault injection points
+const (
+	// Iptables fails or delays the iptables calls
+	Iptables = "iptables"
+	// Ip6tables fails or delays the ip6tables calls
+	Ip6tables = "ip6tables"
+	// Store fails or delays the datastore operations
+	Store = "store"
+	// UserlandProxyKill kills the running userland proxies when triggered
+	UserlandProxyKill = "userland-proxy-kill"
+	// GossipDrop drops the gossip messages the node receives
+	GossipDrop = "gossip-drop"
+)
+
+// Fault describes the fault injected at a point
+type Fault struct {
+	// Err is returned by the faulty operations, nil to only delay them or
+	// at the points dropping messages
+	Err error
+	// Delay delays the faulty operations
+	Delay time.Duration
+	// Probability is the probability of an operation to be faulty, 0 for
+	// all the operations
+	Probability float64
+	// Count is the number of faulty operations before the fault is
+	// cleared, 0 for no limit
+	Count int
+}
+
+type fault struct {
+	Fault
+	hits int
+}
+
+var (
+	mu       sync.Mutex
+	faults   = map[string]*fault{}
+	triggers = map[string][]func(){}
+	// active is the number of faults, to skip the lookup on the data path
+	active int32
+)
+
+// Inject injects the fault at the point, replacing the one injected
+// before
+func Inject(point string, f Fault) {
+	mu.Lock()
+	defer mu.Unlock()
+	faults[point] = &fault{Fault: f}
+	atomic.StoreInt32(&active, int32(len(faults)))
+}
+
+// Clear clears the fault injected at the point
+func Clear(point string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(faults, point)
+	atomic.StoreInt32(&active, int32(len(faults)))
+}
+
+// Reset clears all the faults
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	faults = map[string]*fault{}
+	atomic.StoreInt32(&active, 0)
+}
+
+// Hits returns the number of faulty operations at the point since the
+// fault was injected
+func Hits(point string) int {
+	mu.Lock()
+	defer mu.Unlock()
+	if f, ok := faults[point]; ok {
+		return f.hits
+	}
+	return 0
+}
+
+// hit returns the fault at the point if the operation is faulty
+func hit(point string) (Fault, bool) {
+	if atomic.LoadInt32(&active) == 0 {
+		return Fault{}, false
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	f, ok := faults[point]
+	if !ok || (f.Probability > 0 && rand.Float64() >= f.Probability) {
+		return Fault{}, false
+	}
+	f.hits++
+	if f.Count > 0 && f.hits >= f.Count {
+		delete(faults, point)
+		atomic.StoreInt32(&active, int32(len(faults)))
+	}
+	return f.Fault, true
+}
+
+// Check delays the operation and returns the error of the fault injected
+// at the point if the operation is faulty, nil otherwise
+func Check(point string) error {
+	f, ok := hit(point)
+	if !ok {
+		return nil
+	}
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+	return f.Err
+}
+
+// Drop returns whether the message at the point is dropped
+func Drop(point string) bool {
+	_, ok := hit(point)
+	return ok
+}
+
+// OnTrigger registers the action run when the point is triggered
+func OnTrigger(point string, action func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	triggers[point] = append(triggers[point], action)
+}
+
+// Trigger runs the actions registered for the point, and returns how many
+// ran
+func Trigger(point string) int {
+	mu.Lock()
+	actions := append([]func(){}, triggers[point]...)
+	mu.Unlock()
+	for _, action := range actions {
+		action()
+	}
+	return len(actions)
+}
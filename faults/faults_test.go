@@ -0,0 +1,68 @@
+package faults
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	defer Reset()
+
+	if err := Check(Iptables); err != nil {
+		t.Fatalf("expected no fault, got %v", err)
+	}
+
+	failure := errors.New("iptables failed")
+	Inject(Iptables, Fault{Err: failure, Count: 2})
+	for i := 0; i < 2; i++ {
+		if err := Check(Iptables); err != failure {
+			t.Fatalf("expected the injected error, got %v", err)
+		}
+	}
+	if err := Check(Iptables); err != nil {
+		t.Fatalf("expected the fault to be cleared after its count, got %v", err)
+	}
+
+	Inject(Store, Fault{Delay: 20 * time.Millisecond})
+	start := time.Now()
+	if err := Check(Store); err != nil {
+		t.Fatalf("expected a delay only, got %v", err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("expected the operation to be delayed, took %v", d)
+	}
+	if hits := Hits(Store); hits != 1 {
+		t.Fatalf("expected 1 hit, got %d", hits)
+	}
+	Clear(Store)
+	if Drop(Store) {
+		t.Fatal("expected the fault to be cleared")
+	}
+}
+
+func TestDrop(t *testing.T) {
+	defer Reset()
+
+	Inject(GossipDrop, Fault{Probability: 0.5})
+	dropped := 0
+	for i := 0; i < 1000; i++ {
+		if Drop(GossipDrop) {
+			dropped++
+		}
+	}
+	if dropped == 0 || dropped == 1000 {
+		t.Fatalf("expected about half of the messages to be dropped, dropped %d", dropped)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	runs := 0
+	OnTrigger("test-trigger", func() { runs++ })
+	if n := Trigger("test-trigger"); n != 1 || runs != 1 {
+		t.Fatalf("expected the action to run once, ran %d times for %d actions", runs, n)
+	}
+	if n := Trigger("unregistered"); n != 0 {
+		t.Fatalf("expected no action, got %d", n)
+	}
+}
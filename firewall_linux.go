@@ -1,12 +1,175 @@
 package libnetwork
 
 import (
+	"strings"
+
+	"github.com/docker/libnetwork/drivers/bridge"
+	"github.com/docker/libnetwork/firewallapi"
+	"github.com/docker/libnetwork/ip6tables"
 	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 )
 
 const userChain = "DOCKER-USER"
 
+// userWatch is the name of the reconciler watch of the user chain
+const userWatch = "user:" + userChain
+
+// setFirewallBackend selects the netfilter backend of the configuration,
+// before the drivers program their rules
+func (c *controller) setFirewallBackend() error {
+	name := c.cfg.Daemon.FirewallBackend
+	if name == firewallapi.Default {
+		return nil
+	}
+	if err := firewallapi.SetBackend(name); err != nil {
+		return types.BadRequestErrorf("invalid firewall backend: %v", err)
+	}
+	logrus.Infof("Programming the firewall rules with the %s backend", name)
+	return nil
+}
+
+// setXtables sets the paths of the iptables and ip6tables commands of the
+// configuration and the time they wait for the xtables lock, before the
+// firewall backend is selected
+func (c *controller) setXtables() error {
+	x := c.cfg.Daemon.Xtables
+	if x.IptablesPath != "" || x.IptablesRestorePath != "" {
+		if err := iptables.SetPaths(x.IptablesPath, x.IptablesRestorePath); err != nil {
+			return types.BadRequestErrorf("%v", err)
+		}
+	}
+	if x.Ip6tablesPath != "" || x.Ip6tablesRestorePath != "" {
+		if err := ip6tables.SetPaths(x.Ip6tablesPath, x.Ip6tablesRestorePath); err != nil {
+			return types.BadRequestErrorf("%v", err)
+		}
+	}
+	if err := iptables.SetWait(x.WaitSeconds); err != nil {
+		return types.BadRequestErrorf("%v", err)
+	}
+	if err := ip6tables.SetWait(x.WaitSeconds); err != nil {
+		return types.BadRequestErrorf("%v", err)
+	}
+	return nil
+}
+
+// setOwnerTagging sets whether the firewall rules are tagged with their
+// owner, before the drivers program their rules
+func (c *controller) setOwnerTagging() {
+	iptables.SetOwnerTagging(c.cfg.Daemon.TagFirewallRules)
+	ip6tables.SetOwnerTagging(c.cfg.Daemon.TagFirewallRules)
+}
+
+// setFirewallAudit sets the audit mode of the commands changing the
+// firewall rules
+func setFirewallAudit(mode string) error {
+	a, err := iptables.ParseAudit(mode)
+	if err != nil {
+		return types.BadRequestErrorf("invalid firewall audit mode: %v", err)
+	}
+	iptables.SetAudit(a)
+	ip6tables.SetAudit(a)
+	if a != iptables.AuditOff {
+		logrus.Infof("Auditing the firewall rules in the %s mode", a)
+	}
+	return nil
+}
+
+// orphanChains are the chains of the tables the tagged rules are in
+var orphanChains = map[string][]string{
+	"nat":    {"DOCKER", "POSTROUTING", ingressChain},
+	"filter": {"DOCKER", userChain, "FORWARD", bridge.IsolationChain1, bridge.IsolationChain2, ingressChain, policyChain},
+}
+
+func (c *controller) CleanOrphans() (int, error) {
+	if !c.cfg.Daemon.TagFirewallRules {
+		return 0, types.ForbiddenErrorf("the firewall rules are not tagged with their owner")
+	}
+	alive := func(owner string) bool {
+		kind, id := owner, ""
+		if i := strings.Index(owner, ":"); i >= 0 {
+			kind, id = owner[:i], owner[i+1:]
+		}
+		if kind != "network" {
+			// Keep the rules of the owners of unknown kinds
+			return true
+		}
+		_, err := c.NetworkByID(id)
+		return err == nil
+	}
+
+	deleted := 0
+	for _, table := range []string{"nat", "filter"} {
+		n, err := iptables.CleanOrphans(iptables.Table(table), orphanChains[table], alive)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+		n, err = ip6tables.CleanOrphans(ip6tables.Table(table), orphanChains[table], alive)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	if deleted > 0 {
+		logrus.Infof("Deleted %d orphan firewall rules", deleted)
+	}
+	return deleted, nil
+}
+
+// startFirewallReconciler runs the iptables reconciler at the interval of
+// the configuration, publishing the drifts it corrects
+func (c *controller) startFirewallReconciler() {
+	interval := c.cfg.Daemon.FirewallReconcileInterval
+	if interval <= 0 {
+		return
+	}
+	c.removeDriftCallback = iptables.OnDrift(c.onFirewallDrift)
+	iptables.StartReconciler(interval)
+	logrus.Infof("Reconciling the iptables rules every %v", interval)
+}
+
+func (c *controller) stopFirewallReconciler() {
+	if c.cfg.Daemon.FirewallReconcileInterval > 0 {
+		iptables.StopReconciler()
+	}
+	if c.removeDriftCallback != nil {
+		c.removeDriftCallback()
+		c.removeDriftCallback = nil
+	}
+}
+
+// onFirewallDrift keeps the jump to the user chain ahead of the rules the
+// repair of the drift inserted in FORWARD, and publishes the drift
+func (c *controller) onFirewallDrift(d iptables.Drift) {
+	if d.Err == nil && d.Name != userWatch {
+		c.Lock()
+		arrangeUserFilterRule()
+		c.Unlock()
+	}
+	c.publishFirewallDrift(d)
+}
+
+// publishFirewallDrift sends the drift of the rules of a watch, the ones of
+// a network when its name is the one of a network
+func (c *controller) publishFirewallDrift(d iptables.Drift) {
+	ev := Event{Type: EventFirewallDrift, Op: d.Name}
+	if strings.HasPrefix(d.Name, "network:") {
+		ev.NetworkID = strings.TrimPrefix(d.Name, "network:")
+		if n, err := c.NetworkByID(ev.NetworkID); err == nil {
+			ev.NetworkName = n.Name()
+		}
+	}
+	for _, r := range d.Missing {
+		ev.Rules = append(ev.Rules, r.String())
+	}
+	if d.Err != nil {
+		ev.Error = d.Err.Error()
+	}
+	c.publish(ev)
+}
+
 func (c *controller) arrangeUserFilterRule() {
 	c.Lock()
 	arrangeUserFilterRule()
@@ -16,6 +179,16 @@ func (c *controller) arrangeUserFilterRule() {
 		arrangeUserFilterRule()
 		c.Unlock()
 	})
+	iptables.Watch(userWatch, []iptables.Rule{
+		{Table: iptables.Filter, Chain: userChain},
+		{Table: iptables.Filter, Chain: userChain, Args: []string{"-j", "RETURN"}},
+		{Table: iptables.Filter, Chain: "FORWARD", Args: []string{"-j", userChain}},
+	}, func([]iptables.Rule) error {
+		c.Lock()
+		arrangeUserFilterRule()
+		c.Unlock()
+		return nil
+	})
 }
 
 // This chain allow users to configure firewall policies in a way that persists
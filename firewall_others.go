@@ -2,5 +2,30 @@
 
 package libnetwork
 
+func (c *controller) setFirewallBackend() error {
+	return nil
+}
+
+func (c *controller) setXtables() error {
+	return nil
+}
+
+func (c *controller) setOwnerTagging() {
+}
+
+func setFirewallAudit(mode string) error {
+	return nil
+}
+
+func (c *controller) CleanOrphans() (int, error) {
+	return 0, nil
+}
+
+func (c *controller) startFirewallReconciler() {
+}
+
+func (c *controller) stopFirewallReconciler() {
+}
+
 func (c *controller) arrangeUserFilterRule() {
 }
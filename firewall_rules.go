@@ -0,0 +1,41 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// networkRuleInjector returns the driver of the network identified by nid,
+// installing the firewall rules of the callers
+func (c *controller) networkRuleInjector(nid string) (driverapi.FirewallRuleInjector, error) {
+	nw, err := c.NetworkByID(nid)
+	if err != nil {
+		return nil, err
+	}
+	n := nw.(*network)
+	d, err := n.driver(true)
+	if err != nil {
+		return nil, err
+	}
+	ri, ok := d.(driverapi.FirewallRuleInjector)
+	if !ok {
+		return nil, types.NotImplementedErrorf("the %s driver of network %s does not install firewall rules", n.Type(), n.Name())
+	}
+	return ri, nil
+}
+
+func (c *controller) SetNetworkFirewallRules(nid, name string, rules []driverapi.FirewallRule) error {
+	ri, err := c.networkRuleInjector(nid)
+	if err != nil {
+		return err
+	}
+	return ri.SetNetworkFirewallRules(nid, name, rules)
+}
+
+func (c *controller) NetworkFirewallRules(nid string) (map[string][]driverapi.FirewallRule, error) {
+	ri, err := c.networkRuleInjector(nid)
+	if err != nil {
+		return nil, err
+	}
+	return ri.NetworkFirewallRules(nid)
+}
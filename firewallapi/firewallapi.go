@@ -0,0 +1,145 @@
+// Package firewallapi is the interface to the netfilter backends programming
+// the firewall rules of the IPv4 and IPv6 families.
+package firewallapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/libnetwork/ip6tables"
+	"github.com/docker/libnetwork/iptables"
+)
+
+// Family is the address family of the rules of a Firewall
+type Family string
+
+const (
+	// IPv4 is the family of the iptables rules
+	IPv4 Family = "ipv4"
+	// IPv6 is the family of the ip6tables rules
+	IPv6 Family = "ipv6"
+)
+
+// The names of the backends
+const (
+	// Default is the backend of the iptables commands of the host
+	Default = ""
+	// Legacy programs the x_tables of the kernel with the iptables-legacy
+	// commands
+	Legacy = "iptables-legacy"
+	// NFT programs the nftables of the kernel with the iptables-nft
+	// commands
+	NFT = "iptables-nft"
+)
+
+// Rule is a rule of a chain of a table
+type Rule struct {
+	Table string
+	Chain string
+	Args  []string
+}
+
+// Firewall programs the netfilter rules of a family. The rules are given in
+// the iptables syntax, whatever the backend.
+type Firewall interface {
+	// Family returns the address family of the rules
+	Family() Family
+	// Backend returns the name of the backend the rules are programmed with
+	Backend() string
+	// ProgramRule applies the action, as "-A", "-I" or "-D", to the rule of
+	// the chain of the table, unless the rule is already in the state the
+	// action leads to
+	ProgramRule(table, chain, action string, args []string) error
+	// ProgramRules applies the action to all the rules, or to none of them
+	ProgramRules(action string, rules []Rule) error
+	// Exists returns whether the rule is in the chain of the table
+	Exists(table, chain string, args ...string) bool
+	// ExistChain returns whether the chain is in the table
+	ExistChain(table, chain string) bool
+	// Raw runs the command of the backend with the arguments
+	Raw(args ...string) ([]byte, error)
+}
+
+// Get returns the Firewall of the family
+func Get(family Family) (Firewall, error) {
+	switch family {
+	case IPv4:
+		return ipv4{}, nil
+	case IPv6:
+		return ipv6{}, nil
+	}
+	return nil, fmt.Errorf("unknown address family %q", family)
+}
+
+// SetBackend makes all the rules, of the consumers of the firewallapi and
+// of the iptables and ip6tables packages alike, programmed with the named
+// backend. It is set before the first rule is programmed. It fails, leaving
+// the backends of both families in use, when the commands of the backend
+// are not installed for either family.
+func SetBackend(name string) error {
+	var b4 iptables.Backend
+	var b6 ip6tables.Backend
+	switch name {
+	case Default:
+		b4, b6 = iptables.DefaultBackend, ip6tables.DefaultBackend
+	case Legacy:
+		b4, b6 = iptables.LegacyBackend, ip6tables.LegacyBackend
+	case NFT:
+		b4, b6 = iptables.NFTablesBackend, ip6tables.NFTablesBackend
+	default:
+		return fmt.Errorf("unknown firewall backend %q", name)
+	}
+	prev := iptables.GetBackend()
+	if err := iptables.SetBackend(b4); err != nil {
+		return err
+	}
+	if err := ip6tables.SetBackend(b6); err != nil {
+		if rerr := iptables.SetBackend(prev); rerr != nil {
+			return fmt.Errorf("%v, and the IPv4 rules failed to get back to the %q backend: %v", err, backendName(string(prev)), rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// backendName returns the name of the backend of the iptables packages
+func backendName(b string) string {
+	switch b {
+	case string(iptables.LegacyBackend):
+		return Legacy
+	case string(iptables.NFTablesBackend):
+		return NFT
+	}
+	return Default
+}
+
+// Run runs the command of the firewall with the arguments, failing when it
+// has output
+func Run(fw Firewall, args ...string) error {
+	if output, err := fw.Raw(args...); err != nil || len(output) != 0 {
+		return fmt.Errorf("%s (%v)", string(output), err)
+	}
+	return nil
+}
+
+// RulePosition returns the position, from 1, of the rule in the chain of
+// the table, 0 when the rule is not in the chain
+func RulePosition(fw Firewall, table, chain string, args []string) int {
+	out, err := fw.Raw("-t", table, "-S", chain)
+	if err != nil {
+		return 0
+	}
+	rule := strings.Join(args, " ")
+	pos := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := iptables.SplitArgs(line)
+		if len(fields) < 2 || fields[0] != "-A" || fields[1] != chain {
+			continue
+		}
+		pos++
+		if strings.Join(fields[2:], " ") == rule {
+			return pos
+		}
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package firewallapi
+
+import (
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	for _, family := range []Family{IPv4, IPv6} {
+		fw, err := Get(family)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fw.Family() != family {
+			t.Fatalf("Expected the %s firewall, got %s", family, fw.Family())
+		}
+		if fw.Backend() != Default {
+			t.Fatalf("Expected the default backend, got %q", fw.Backend())
+		}
+	}
+	if _, err := Get("ipx"); err == nil {
+		t.Fatal("Expected the unknown family to be rejected")
+	}
+}
+
+func TestSetBackend(t *testing.T) {
+	if err := SetBackend("ebtables"); err == nil {
+		t.Fatal("Expected the unknown backend to be rejected")
+	}
+	if err := SetBackend(Default); err != nil {
+		t.Fatal(err)
+	}
+	for b, name := range map[string]string{"": Default, "legacy": Legacy, "nft": NFT} {
+		if n := backendName(b); n != name {
+			t.Fatalf("Expected the %q backend to be named %q, got %q", b, name, n)
+		}
+	}
+}
+
+// listFirewall lists the rules of the FORWARD chain
+type listFirewall struct {
+	Firewall
+	rules string
+}
+
+func (f listFirewall) Raw(args ...string) ([]byte, error) {
+	return []byte(f.rules), nil
+}
+
+func TestRulePosition(t *testing.T) {
+	fw := listFirewall{rules: "-P FORWARD ACCEPT\n-A FORWARD -j DOCKER-USER\n" +
+		"-A FORWARD -i br0 -m comment --comment \"a b\" -j ACCEPT\n-A FORWARD -o br0 -j DOCKER-NET-br0\n"}
+	for _, c := range []struct {
+		args []string
+		pos  int
+	}{
+		{[]string{"-j", "DOCKER-USER"}, 1},
+		{[]string{"-i", "br0", "-m", "comment", "--comment", "a b", "-j", "ACCEPT"}, 2},
+		{[]string{"-o", "br0", "-j", "DOCKER-NET-br0"}, 3},
+		{[]string{"-i", "br0", "-j", "DOCKER-NET-br0"}, 0},
+	} {
+		if pos := RulePosition(fw, "filter", "FORWARD", c.args); pos != c.pos {
+			t.Fatalf("Expected the rule %q at %d, got %d", c.args, c.pos, pos)
+		}
+	}
+}
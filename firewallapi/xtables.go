@@ -0,0 +1,64 @@
+package firewallapi
+
+import (
+	"github.com/docker/libnetwork/ip6tables"
+	"github.com/docker/libnetwork/iptables"
+)
+
+// ipv4 is the Firewall of the iptables package
+type ipv4 struct{}
+
+func (ipv4) Family() Family { return IPv4 }
+
+func (ipv4) Backend() string { return backendName(string(iptables.GetBackend())) }
+
+func (ipv4) ProgramRule(table, chain, action string, args []string) error {
+	return iptables.ProgramRule(iptables.Table(table), chain, iptables.Action(action), args)
+}
+
+func (ipv4) ProgramRules(action string, rules []Rule) error {
+	rs := make([]iptables.Rule, 0, len(rules))
+	for _, r := range rules {
+		rs = append(rs, iptables.Rule{Table: iptables.Table(r.Table), Chain: r.Chain, Args: r.Args})
+	}
+	return iptables.ProgramRules(iptables.Action(action), rs)
+}
+
+func (ipv4) Exists(table, chain string, args ...string) bool {
+	return iptables.Exists(iptables.Table(table), chain, args...)
+}
+
+func (ipv4) ExistChain(table, chain string) bool {
+	return iptables.ExistChain(chain, iptables.Table(table))
+}
+
+func (ipv4) Raw(args ...string) ([]byte, error) { return iptables.Raw(args...) }
+
+// ipv6 is the Firewall of the ip6tables package
+type ipv6 struct{}
+
+func (ipv6) Family() Family { return IPv6 }
+
+func (ipv6) Backend() string { return backendName(string(ip6tables.GetBackend())) }
+
+func (ipv6) ProgramRule(table, chain, action string, args []string) error {
+	return ip6tables.ProgramRule(ip6tables.Table(table), chain, ip6tables.Action(action), args)
+}
+
+func (ipv6) ProgramRules(action string, rules []Rule) error {
+	rs := make([]ip6tables.Rule, 0, len(rules))
+	for _, r := range rules {
+		rs = append(rs, ip6tables.Rule{Table: ip6tables.Table(r.Table), Chain: r.Chain, Args: r.Args})
+	}
+	return ip6tables.ProgramRules(ip6tables.Action(action), rs)
+}
+
+func (ipv6) Exists(table, chain string, args ...string) bool {
+	return ip6tables.Exists(ip6tables.Table(table), chain, args...)
+}
+
+func (ipv6) ExistChain(table, chain string) bool {
+	return ip6tables.ExistChain(chain, ip6tables.Table(table))
+}
+
+func (ipv6) Raw(args ...string) ([]byte, error) { return ip6tables.Raw(args...) }
@@ -0,0 +1,32 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/types"
+)
+
+// RegisterIngressProxy redirects the ingress traffic of a TCP published port
+// to the local proxy listening on proxyPort, in place of the ingress load
+// balancer. The proxy, HTTP aware for instance, forwards the connections it
+// accepts to the IngressProxyUpstream address of the port, this is how
+// host-header routing is layered on the published services.
+func (c *controller) RegisterIngressProxy(publishedPort uint32, proxyPort uint16) error {
+	if publishedPort == 0 || publishedPort > 65535 {
+		return types.BadRequestErrorf("invalid published port %d", publishedPort)
+	}
+	if proxyPort == 0 {
+		return types.BadRequestErrorf("invalid proxy port for published port %d", publishedPort)
+	}
+	return setIngressProxy(publishedPort, proxyPort, false)
+}
+
+// UnregisterIngressProxy gives the ingress traffic of the published port
+// back to the ingress load balancer
+func (c *controller) UnregisterIngressProxy(publishedPort uint32) error {
+	return setIngressProxy(publishedPort, 0, true)
+}
+
+// IngressProxyUpstream returns the address the proxy of the published port
+// forwards its connections to, in host:port form
+func (c *controller) IngressProxyUpstream(publishedPort uint32) (string, error) {
+	return ingressProxyUpstream(publishedPort)
+}
@@ -0,0 +1,150 @@
+package xtables
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Audit is the mode of the auditing of the commands changing the rules
+type Audit int
+
+const (
+	// AuditOff runs the commands without logging them
+	AuditOff Audit = iota
+	// AuditLog runs the commands and logs each of them
+	AuditLog
+	// AuditDryRun logs and records the commands without running them, the
+	// commands reading the rules still run
+	AuditDryRun
+)
+
+func (a Audit) String() string {
+	switch a {
+	case AuditLog:
+		return "log"
+	case AuditDryRun:
+		return "dry-run"
+	}
+	return "off"
+}
+
+// ParseAudit returns the audit mode of its name, as returned by String
+func ParseAudit(name string) (Audit, error) {
+	for _, a := range []Audit{AuditOff, AuditLog, AuditDryRun} {
+		if name == a.String() {
+			return a, nil
+		}
+	}
+	if name == "" {
+		return AuditOff, nil
+	}
+	return AuditOff, fmt.Errorf("unknown iptables audit mode %q", name)
+}
+
+// maxAudited is the number of the recorded commands of a dry run kept, the
+// oldest ones being dropped
+const maxAudited = 4096
+
+var changing = map[string]bool{
+	"-A": true, "--append": true,
+	"-I": true, "--insert": true,
+	"-D": true, "--delete": true,
+	"-R": true, "--replace": true,
+	"-N": true, "--new-chain": true,
+	"-X": true, "--delete-chain": true,
+	"-F": true, "--flush": true,
+	"-Z": true, "--zero": true,
+	"-P": true, "--policy": true,
+	"-E": true, "--rename-chain": true,
+}
+
+// SetAudit sets the audit mode of the commands changing the rules of the
+// version
+func (v Version) SetAudit(a Audit) {
+	s := v.state()
+	s.auditMu.Lock()
+	s.audit = a
+	s.auditMu.Unlock()
+}
+
+// GetAudit returns the audit mode of the commands changing the rules of
+// the version
+func (v Version) GetAudit() Audit {
+	s := v.state()
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	return s.audit
+}
+
+// Audited returns the commands recorded by the dry run since the last call,
+// in order, and forgets them
+func (v Version) Audited() []string {
+	s := v.state()
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	cmds := s.audited
+	if s.dropped > 0 {
+		logrus.Warnf("Dropped the %d oldest commands of the %s dry run", s.dropped, v.Command())
+	}
+	s.audited, s.dropped = nil, 0
+	return cmds
+}
+
+// AuditCommand audits the command cmd run with the arguments, and returns
+// whether it is to be skipped, as the commands changing the rules of the
+// dry runs
+func (v Version) AuditCommand(cmd string, args []string) bool {
+	if !changesRules(args) {
+		return false
+	}
+	return v.auditLines(cmd, []string{strings.Join(args, " ")})
+}
+
+// AuditRestore audits the input of the restore command cmd, and returns
+// whether it is to be skipped
+func (v Version) AuditRestore(cmd string, input []byte) bool {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(string(input)), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return v.auditLines(cmd, lines)
+}
+
+func (v Version) auditLines(cmd string, lines []string) bool {
+	s := v.state()
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	switch s.audit {
+	case AuditLog:
+		for _, line := range lines {
+			logrus.Infof("%s: %s", cmd, line)
+		}
+		return false
+	case AuditDryRun:
+		for _, line := range lines {
+			logrus.Infof("%s (dry run): %s", cmd, line)
+			s.audited = append(s.audited, cmd+" "+line)
+		}
+		if n := len(s.audited) - maxAudited; n > 0 {
+			s.audited = append([]string(nil), s.audited[n:]...)
+			s.dropped += n
+		}
+		return true
+	}
+	return false
+}
+
+// changesRules returns whether the arguments are the ones of a command
+// changing the rules or chains
+func changesRules(args []string) bool {
+	for _, arg := range args {
+		if changing[arg] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package xtables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangesRules(t *testing.T) {
+	for _, c := range []struct {
+		args     []string
+		expected bool
+	}{
+		{[]string{"-t", "nat", "-C", "DOCKER", "-j", "RETURN"}, false},
+		{[]string{"-t", "filter", "-nL", "DOCKER"}, false},
+		{[]string{"-t", "filter", "-S", "DOCKER"}, false},
+		{[]string{"-t", "nat", "-A", "DOCKER", "-j", "RETURN"}, true},
+		{[]string{"--delete-chain", "DOCKER"}, true},
+		{[]string{"-t", "filter", "-F", "DOCKER"}, true},
+	} {
+		if changes := changesRules(c.args); changes != c.expected {
+			t.Fatalf("Expected %v to change the rules %v", c.args, c.expected)
+		}
+	}
+}
+
+func TestAuditVersions(t *testing.T) {
+	defer IPv4.SetAudit(IPv4.GetAudit())
+	defer IPv6.SetAudit(IPv6.GetAudit())
+	IPv4.SetAudit(AuditDryRun)
+	IPv6.SetAudit(AuditLog)
+	IPv4.Audited()
+
+	if !IPv4.AuditCommand("iptables", []string{"-N", "DOCKER"}) {
+		t.Fatal("Expected the IPv4 command of the dry run to be skipped")
+	}
+	if IPv6.AuditCommand("ip6tables", []string{"-N", "DOCKER"}) {
+		t.Fatal("Expected the logged IPv6 command to run")
+	}
+	if IPv4.AuditCommand("iptables", []string{"-S", "DOCKER"}) {
+		t.Fatal("Expected the command reading the rules to run")
+	}
+	if !IPv4.AuditRestore("iptables-restore", []byte("*nat\n-F DOCKER\nCOMMIT\n")) {
+		t.Fatal("Expected the restore of the dry run to be skipped")
+	}
+
+	expected := []string{"iptables -N DOCKER", "iptables-restore *nat", "iptables-restore -F DOCKER", "iptables-restore COMMIT"}
+	if cmds := IPv4.Audited(); !reflect.DeepEqual(cmds, expected) {
+		t.Fatalf("Expected the commands %q, got %q", expected, cmds)
+	}
+	if cmds := IPv6.Audited(); cmds != nil {
+		t.Fatalf("Expected no IPv6 command to be recorded, got %q", cmds)
+	}
+}
@@ -0,0 +1,114 @@
+package xtables
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrRuleNotFound is returned when the counters of a rule which is not
+// programmed are read
+var ErrRuleNotFound = errors.New("rule not found")
+
+// ParseCounters returns the packet and byte counters of the rule of the
+// chain from the output of the -S -v command. The output lists the rules in
+// a normalized form, the rule matches a line whose options include those of
+// the rule.
+func (v Version) ParseCounters(output, chain string, rule []string) (packets, bytes uint64, err error) {
+	want := optionGroups(v.normalizeRule(rule))
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" || fields[1] != chain {
+			continue
+		}
+		have := map[string]bool{}
+		var counters []string
+		for _, g := range optionGroups(fields[2:]) {
+			if strings.HasPrefix(g, "-c ") {
+				counters = strings.Fields(g)[1:]
+				continue
+			}
+			have[g] = true
+		}
+		if len(counters) != 2 || !containsAll(have, want) {
+			continue
+		}
+		p, err := strconv.ParseUint(counters[0], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid packet counter in %q: %v", line, err)
+		}
+		b, err := strconv.ParseUint(counters[1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid byte counter in %q: %v", line, err)
+		}
+		packets += p
+		bytes += b
+		found = true
+	}
+	if !found {
+		return 0, 0, ErrRuleNotFound
+	}
+	return packets, bytes, nil
+}
+
+// normalizeRule returns the rule with the addresses in the form the command
+// of the version lists them
+func (v Version) normalizeRule(rule []string) []string {
+	var normalized []string
+	for i := 0; i < len(rule); i++ {
+		if (rule[i] == "-d" || rule[i] == "-s") && i+1 < len(rule) {
+			addr := rule[i+1]
+			i++
+			if addr == "0/0" {
+				// the unspecified address is not listed
+				continue
+			}
+			if !strings.Contains(addr, "/") {
+				addr += v.hostPrefix()
+			}
+			normalized = append(normalized, rule[i-1], addr)
+			continue
+		}
+		normalized = append(normalized, rule[i])
+	}
+	return normalized
+}
+
+// optionGroups splits the rule in its options with their values, as in
+// "! -i docker0" or "--dport 80"
+func optionGroups(rule []string) []string {
+	var (
+		groups []string
+		cur    []string
+	)
+	for _, f := range rule {
+		if (f == "!" || strings.HasPrefix(f, "-")) && len(cur) > 0 && cur[len(cur)-1] != "!" {
+			groups = append(groups, strings.Join(cur, " "))
+			cur = nil
+		}
+		cur = append(cur, f)
+	}
+	if len(cur) > 0 {
+		groups = append(groups, strings.Join(cur, " "))
+	}
+	return groups
+}
+
+// hostPrefix returns the prefix length of the host addresses of the version
+func (v Version) hostPrefix() string {
+	if v == IPv6 {
+		return "/128"
+	}
+	return "/32"
+}
+
+func containsAll(have map[string]bool, want []string) bool {
+	for _, g := range want {
+		if !have[g] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package xtables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeRule(t *testing.T) {
+	rule := []string{"-s", "0/0", "-d", "172.17.0.2", "-p", "tcp", "--dport", "80", "-j", "ACCEPT"}
+	expected := []string{"-d", "172.17.0.2/32", "-p", "tcp", "--dport", "80", "-j", "ACCEPT"}
+	if n := IPv4.normalizeRule(rule); !reflect.DeepEqual(n, expected) {
+		t.Fatalf("Expected %q, got %q", expected, n)
+	}
+
+	rule = []string{"-d", "fd00::2", "-s", "fd00:1::/64", "-j", "ACCEPT"}
+	expected = []string{"-d", "fd00::2/128", "-s", "fd00:1::/64", "-j", "ACCEPT"}
+	if n := IPv6.normalizeRule(rule); !reflect.DeepEqual(n, expected) {
+		t.Fatalf("Expected %q, got %q", expected, n)
+	}
+}
+
+func TestParseCountersSums(t *testing.T) {
+	output := "-A DOCKER -d fd00::2/128 -p udp -c 1 100 -j ACCEPT\n" +
+		"-A DOCKER -d fd00::2/128 -p udp -c 2 200 -j ACCEPT\n" +
+		"-A OTHER -d fd00::2/128 -p udp -c 4 400 -j ACCEPT\n"
+	packets, bytes, err := IPv6.ParseCounters(output, "DOCKER", []string{"-d", "fd00::2", "-p", "udp", "-j", "ACCEPT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packets != 3 || bytes != 300 {
+		t.Fatalf("Expected the counters of the duplicated rules to be summed, got %d and %d", packets, bytes)
+	}
+	if _, _, err := IPv4.ParseCounters(output, "DOCKER", []string{"-d", "fd00::2"}); err != ErrRuleNotFound {
+		t.Fatalf("Expected %v, got %v", ErrRuleNotFound, err)
+	}
+}
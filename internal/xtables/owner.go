@@ -0,0 +1,123 @@
+package xtables
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ownerPrefix prefixes the owner in the comment of the tagged rules
+const ownerPrefix = "libnetwork:"
+
+// SetOwnerTagging sets whether the rules of the owners are tagged with a
+// comment naming their owner
+func (v Version) SetOwnerTagging(enabled bool) {
+	v.state().ownerTagging = enabled
+}
+
+// OwnerTagging returns whether the rules of the owners are tagged
+func (v Version) OwnerTagging() bool {
+	return v.state().ownerTagging
+}
+
+// OwnerArgs returns the arguments of the comment match tagging a rule of
+// the owner, none when the rules are not tagged
+func (v Version) OwnerArgs(owner string) []string {
+	if !v.OwnerTagging() || owner == "" {
+		return nil
+	}
+	return []string{"-m", "comment", "--comment", ownerPrefix + owner}
+}
+
+// OwnedArgs returns the arguments of the rule of the owner, tagged with the
+// comment match of OwnerArgs ahead of its target
+func (v Version) OwnedArgs(owner string, args []string) []string {
+	tag := v.OwnerArgs(owner)
+	if len(tag) == 0 {
+		return args
+	}
+	i := len(args)
+	for j, arg := range args {
+		if arg == "-j" {
+			i = j
+			break
+		}
+	}
+	owned := make([]string, 0, len(args)+len(tag))
+	owned = append(owned, args[:i]...)
+	owned = append(owned, tag...)
+	return append(owned, args[i:]...)
+}
+
+// CleanOrphans deletes the tagged rules of the chains of the table whose
+// owner is not alive, and returns the number of the deleted rules
+func CleanOrphans(cmds Commands, table string, chains []string, alive func(owner string) bool) (int, error) {
+	deleted := 0
+	for _, chain := range chains {
+		if !cmds.ExistChain(table, chain) {
+			continue
+		}
+		out, err := cmds.Raw("-t", table, "-S", chain)
+		if err != nil {
+			return deleted, err
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			args := SplitArgs(line)
+			owner := ruleOwner(args)
+			if owner == "" || alive(owner) || len(args) < 2 || args[0] != "-A" {
+				continue
+			}
+			if err := cmds.RawCombinedOutput(append([]string{"-t", table, "-D"}, args[1:]...)...); err != nil {
+				logrus.Warnf("Failed to delete the orphan rule %q of %s: %v", line, owner, err)
+				continue
+			}
+			logrus.Debugf("Deleted the orphan rule %q of %s", line, owner)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// ruleOwner returns the owner of the tagged rule of the arguments
+func ruleOwner(args []string) string {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == "--comment" && strings.HasPrefix(args[i+1], ownerPrefix) {
+			return strings.TrimPrefix(args[i+1], ownerPrefix)
+		}
+	}
+	return ""
+}
+
+// SplitArgs splits a rule, as listed by the -S command, into its arguments,
+// which are quoted when they have spaces
+func SplitArgs(line string) []string {
+	var (
+		args    []string
+		b       strings.Builder
+		quoted  bool
+		pending bool
+	)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && quoted && i+1 < len(line):
+			i++
+			b.WriteByte(line[i])
+		case c == '"':
+			quoted = !quoted
+			pending = true
+		case c == ' ' && !quoted:
+			if pending {
+				args = append(args, b.String())
+				b.Reset()
+				pending = false
+			}
+		default:
+			b.WriteByte(c)
+			pending = true
+		}
+	}
+	if pending {
+		args = append(args, b.String())
+	}
+	return args
+}
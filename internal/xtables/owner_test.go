@@ -0,0 +1,84 @@
+package xtables
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOwnerTaggingVersions(t *testing.T) {
+	defer IPv4.SetOwnerTagging(IPv4.OwnerTagging())
+	defer IPv6.SetOwnerTagging(IPv6.OwnerTagging())
+
+	IPv4.SetOwnerTagging(true)
+	IPv6.SetOwnerTagging(false)
+	if args := IPv4.OwnerArgs("network:n1"); len(args) == 0 {
+		t.Fatal("Expected the IPv4 rules to be tagged")
+	}
+	if args := IPv6.OwnerArgs("network:n1"); args != nil {
+		t.Fatalf("Expected the IPv6 rules not to be tagged, got %v", args)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	for _, c := range []struct {
+		line     string
+		expected []string
+	}{
+		{"", nil},
+		{"-N DOCKER", []string{"-N", "DOCKER"}},
+		{`-A DOCKER -d 172.17.0.2/32 -m comment --comment libnetwork:network:n1 -j ACCEPT`,
+			[]string{"-A", "DOCKER", "-d", "172.17.0.2/32", "-m", "comment", "--comment", "libnetwork:network:n1", "-j", "ACCEPT"}},
+		{`-A DOCKER-USER -m comment --comment "a \"quoted\" comment" -j RETURN`,
+			[]string{"-A", "DOCKER-USER", "-m", "comment", "--comment", `a "quoted" comment`, "-j", "RETURN"}},
+		{`-A X --comment ""`, []string{"-A", "X", "--comment", ""}},
+	} {
+		if args := SplitArgs(c.line); !reflect.DeepEqual(args, c.expected) {
+			t.Fatalf("Expected %q to split into %q, got %q", c.line, c.expected, args)
+		}
+	}
+}
+
+func TestRuleOwner(t *testing.T) {
+	if owner := ruleOwner(SplitArgs("-A DOCKER -m comment --comment libnetwork:network:n1 -j ACCEPT")); owner != "network:n1" {
+		t.Fatalf("Expected owner network:n1, got %q", owner)
+	}
+	if owner := ruleOwner(SplitArgs(`-A DOCKER -m comment --comment "set by hand" -j ACCEPT`)); owner != "" {
+		t.Fatalf("Expected no owner of an untagged rule, got %q", owner)
+	}
+}
+
+// fakeCommands lists the rules of a chain and records the other commands
+type fakeCommands struct {
+	rules string
+	cmds  []string
+}
+
+func (f *fakeCommands) Version() Version { return IPv4 }
+
+func (f *fakeCommands) Raw(args ...string) ([]byte, error) {
+	return []byte(f.rules), nil
+}
+
+func (f *fakeCommands) RawCombinedOutput(args ...string) error {
+	f.cmds = append(f.cmds, strings.Join(args, " "))
+	return nil
+}
+
+func (f *fakeCommands) ExistChain(table, chain string) bool { return chain == "DOCKER" }
+
+func TestCleanOrphans(t *testing.T) {
+	f := &fakeCommands{rules: "-N DOCKER\n" +
+		"-A DOCKER -d 172.17.0.2/32 -m comment --comment libnetwork:network:n1 -j ACCEPT\n" +
+		"-A DOCKER -d 172.18.0.2/32 -m comment --comment libnetwork:network:n2 -j ACCEPT\n" +
+		"-A DOCKER -d 172.19.0.2/32 -j ACCEPT\n"}
+
+	n, err := CleanOrphans(f, "filter", []string{"DOCKER", "DOCKER-GONE"}, func(owner string) bool { return owner == "network:n1" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-t filter -D DOCKER -d 172.18.0.2/32 -m comment --comment libnetwork:network:n2 -j ACCEPT"}
+	if n != 1 || !reflect.DeepEqual(f.cmds, expected) {
+		t.Fatalf("Expected the commands %q, got %d deleted with %q", expected, n, f.cmds)
+	}
+}
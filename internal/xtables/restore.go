@@ -0,0 +1,170 @@
+package xtables
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Rule is a rule of a chain of a table
+type Rule struct {
+	Table string
+	Chain string
+	Args  []string
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("-t %s %s %s", r.Table, r.Chain, strings.Join(r.Args, " "))
+}
+
+// Restorer programs the rules of a version in batches
+type Restorer interface {
+	// Exists returns whether the rule is in the chain of the table
+	Exists(table, chain string, args ...string) bool
+	// ProgramRule applies the action to the rule of the chain of the table,
+	// unless the rule is already in the state the action leads to
+	ProgramRule(table, chain, action string, args []string) error
+	// Restore runs the restore command with the input programming what,
+	// the filterArgs being the arguments of its output filter
+	Restore(input []byte, what string, filterArgs ...string) error
+}
+
+// ProgramRules programs the rules with one restore transaction per table:
+// all the rules of a table are programmed or none are, and the tables
+// already committed are reverted when a table fails. As a rule already
+// gone fails the transaction deleting it, a failed deletion is retried with
+// the rules still present. Unless
+// batched, the rules are programmed one at a time and the programmed ones
+// reverted on failure.
+func ProgramRules(r Restorer, batched bool, action string, rules []Rule) error {
+	if !batched {
+		return programRules(r, action, rules)
+	}
+	tables, byTable := GroupRules(rules)
+	for i, table := range tables {
+		err := restore(r, table, action, byTable[table])
+		if err != nil && action == "-D" {
+			if present := presentRules(r, byTable[table]); len(present) < len(byTable[table]) {
+				logrus.Debugf("Deleting the %d rules of the %s table still present", len(present), table)
+				byTable[table], err = present, nil
+				if len(present) > 0 {
+					err = restore(r, table, action, present)
+				}
+			}
+		}
+		if err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if rerr := restore(r, tables[j], Revert(action), byTable[tables[j]]); rerr != nil {
+					logrus.Warnf("Failed to revert the %s table rules: %v", tables[j], rerr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// programRules programs the rules one at a time
+func programRules(r Restorer, action string, rules []Rule) error {
+	for i, rule := range rules {
+		if err := r.ProgramRule(rule.Table, rule.Chain, action, rule.Args); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if rerr := r.ProgramRule(rules[j].Table, rules[j].Chain, Revert(action), rules[j].Args); rerr != nil {
+					logrus.Warnf("Failed to revert the rule %s: %v", rules[j], rerr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// presentRules returns the rules which are in their chain
+func presentRules(r Restorer, rules []Rule) []Rule {
+	var present []Rule
+	for _, rule := range rules {
+		if r.Exists(rule.Table, rule.Chain, rule.Args...) {
+			present = append(present, rule)
+		}
+	}
+	return present
+}
+
+func restore(r Restorer, table, action string, rules []Rule) error {
+	return r.Restore(RestoreInput(table, action, rules), fmt.Sprintf("%d rules of the %s table", len(rules), table), "-t", table)
+}
+
+// Revert returns the action undoing action
+func Revert(action string) string {
+	if action == "-D" {
+		return "-A"
+	}
+	return "-D"
+}
+
+// GroupRules returns the tables of the rules, in the order they first
+// appear, and the rules of each table
+func GroupRules(rules []Rule) ([]string, map[string][]Rule) {
+	var tables []string
+	byTable := map[string][]Rule{}
+	for _, r := range rules {
+		if _, ok := byTable[r.Table]; !ok {
+			tables = append(tables, r.Table)
+		}
+		byTable[r.Table] = append(byTable[r.Table], r)
+	}
+	return tables, byTable
+}
+
+// RestoreInput returns the restore input programming the rules of table
+func RestoreInput(table, action string, rules []Rule) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%s\n", table)
+	for _, r := range rules {
+		b.WriteString(RestoreLine(action, r.Chain, r.Args))
+	}
+	b.WriteString("COMMIT\n")
+	return b.Bytes()
+}
+
+// RestoreLine returns the line of the restore input applying the action to
+// the rule of the chain
+func RestoreLine(action, chain string, args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, quoteArg(arg))
+	}
+	return fmt.Sprintf("%s %s %s\n", action, chain, strings.Join(quoted, " "))
+}
+
+// quoteArg returns the argument as the restore command parses it: the
+// arguments which are empty or have spaces, as the comments, are quoted,
+// the quotes and backslashes they have escaped
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// RunRestore runs the restore command of the version at path with the
+// arguments and the input, and returns its output
+func (v Version) RunRestore(path string, args []string, input []byte) ([]byte, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		name := v.Command() + "-restore"
+		return nil, fmt.Errorf("%s failed: %s %v: %s (%s)", name, name, strings.Join(args, " "), output, err)
+	}
+	return output, nil
+}
+
+// SupportsRestoreWait returns whether the restore command of the version
+// supports the --wait option, from 1.6.2
+func SupportsRestoreWait(mj, mn, mc int) bool {
+	return mj > 1 || (mj == 1 && (mn > 6 || (mn == 6 && mc >= 2)))
+}
@@ -0,0 +1,129 @@
+package xtables
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeRestorer records the restore inputs, failing the ones of the table
+// named by fail, or the deletions of the rules not in present
+type fakeRestorer struct {
+	fail    string
+	present map[string]bool
+	inputs  []string
+}
+
+func (f *fakeRestorer) Exists(table, chain string, args ...string) bool {
+	return f.present[RestoreLine("-D", chain, args)]
+}
+
+func (f *fakeRestorer) ProgramRule(table, chain, action string, args []string) error {
+	f.inputs = append(f.inputs, RestoreLine(action, chain, args))
+	return nil
+}
+
+func (f *fakeRestorer) Restore(input []byte, what string, filterArgs ...string) error {
+	if f.fail != "" && strings.HasPrefix(string(input), "*"+f.fail+"\n") {
+		return errors.New("restore failed")
+	}
+	for _, line := range strings.SplitAfter(string(input), "\n") {
+		if f.present != nil && strings.HasPrefix(line, "-D ") && !f.present[line] {
+			return errors.New("restore failed: no such rule")
+		}
+	}
+	f.inputs = append(f.inputs, string(input))
+	return nil
+}
+
+func TestProgramRules(t *testing.T) {
+	rules := []Rule{
+		{Table: "nat", Chain: "DOCKER", Args: []string{"-j", "RETURN"}},
+		{Table: "filter", Chain: "DOCKER", Args: []string{"-j", "DROP"}},
+	}
+
+	f := &fakeRestorer{}
+	if err := ProgramRules(f, true, "-A", rules); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"*nat\n-A DOCKER -j RETURN\nCOMMIT\n", "*filter\n-A DOCKER -j DROP\nCOMMIT\n"}
+	if !reflect.DeepEqual(f.inputs, expected) {
+		t.Fatalf("Expected the inputs %q, got %q", expected, f.inputs)
+	}
+
+	f = &fakeRestorer{fail: "filter"}
+	if err := ProgramRules(f, true, "-A", rules); err == nil {
+		t.Fatal("Expected the failed table to fail the rules")
+	}
+	expected = []string{"*nat\n-A DOCKER -j RETURN\nCOMMIT\n", "*nat\n-D DOCKER -j RETURN\nCOMMIT\n"}
+	if !reflect.DeepEqual(f.inputs, expected) {
+		t.Fatalf("Expected the committed table to be reverted, got %q", f.inputs)
+	}
+
+	f = &fakeRestorer{}
+	if err := ProgramRules(f, false, "-A", rules); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"-A DOCKER -j RETURN\n", "-A DOCKER -j DROP\n"}
+	if !reflect.DeepEqual(f.inputs, expected) {
+		t.Fatalf("Expected the rules programmed one at a time, got %q", f.inputs)
+	}
+}
+
+func TestProgramRulesDeleteGone(t *testing.T) {
+	rules := []Rule{
+		{Table: "nat", Chain: "DOCKER", Args: []string{"-j", "RETURN"}},
+		{Table: "nat", Chain: "DOCKER", Args: []string{"-j", "DROP"}},
+		{Table: "filter", Chain: "DOCKER", Args: []string{"-j", "DROP"}},
+	}
+	f := &fakeRestorer{present: map[string]bool{"-D DOCKER -j DROP\n": true}}
+	if err := ProgramRules(f, true, "-D", rules); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"*nat\n-D DOCKER -j DROP\nCOMMIT\n", "*filter\n-D DOCKER -j DROP\nCOMMIT\n"}
+	if !reflect.DeepEqual(f.inputs, expected) {
+		t.Fatalf("Expected the rules still present to be deleted, got %q", f.inputs)
+	}
+
+	f = &fakeRestorer{present: map[string]bool{}}
+	if err := ProgramRules(f, true, "-D", rules[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if f.inputs != nil {
+		t.Fatalf("Expected no deletion of the rules gone, got %q", f.inputs)
+	}
+}
+
+func TestRestoreLine(t *testing.T) {
+	args := []string{"-m", "comment", "--comment", `a "quoted" \ comment`, "-m", "string", "--string", "", "-j", "ACCEPT"}
+	expected := `-A DOCKER -m comment --comment "a \"quoted\" \\ comment" -m string --string "" -j ACCEPT` + "\n"
+	line := RestoreLine("-A", "DOCKER", args)
+	if line != expected {
+		t.Fatalf("Expected the line %q, got %q", expected, line)
+	}
+	// the listed rules are split back into the same arguments
+	if split := SplitArgs(strings.TrimSpace(line)); !reflect.DeepEqual(split[2:], args) {
+		t.Fatalf("Expected the arguments %q, got %q", args, split[2:])
+	}
+}
+
+func TestSupportsRestoreWait(t *testing.T) {
+	input := []struct {
+		mj int
+		mn int
+		mc int
+		ok bool
+	}{
+		{1, 6, 2, true},
+		{1, 8, 0, true},
+		{2, 0, 0, true},
+		{1, 6, 1, false},
+		{1, 4, 21, false},
+	}
+	for ind, inp := range input {
+		if inp.ok != SupportsRestoreWait(inp.mj, inp.mn, inp.mc) {
+			t.Fatalf("Incorrect check: %d", ind)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Package xtables holds the logic the iptables and ip6tables packages
+// share, parameterized by the IP version of their rules.
+package xtables
+
+import "sync"
+
+// Version is the IP version of the rules of the commands
+type Version int
+
+const (
+	// IPv4 is the version of the iptables rules
+	IPv4 Version = 4
+	// IPv6 is the version of the ip6tables rules
+	IPv6 Version = 6
+)
+
+// Command returns the name of the command of the rules of the version
+func (v Version) Command() string {
+	if v == IPv6 {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+// Commands runs the commands of the rules of a version
+type Commands interface {
+	// Version returns the IP version of the rules
+	Version() Version
+	// Raw runs the command with the arguments
+	Raw(args ...string) ([]byte, error)
+	// RawCombinedOutput runs the command with the arguments, failing when
+	// it has output
+	RawCombinedOutput(args ...string) error
+	// ExistChain returns whether the chain is in the table
+	ExistChain(table, chain string) bool
+}
+
+// state is the configuration of the commands of a version
+type state struct {
+	ownerTagging bool
+
+	auditMu sync.Mutex
+	audit   Audit
+	audited []string
+	dropped int
+}
+
+var states = map[Version]*state{IPv4: {}, IPv6: {}}
+
+func (v Version) state() *state {
+	return states[v]
+}
@@ -0,0 +1,124 @@
+package ip6tables
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// Backend is the netfilter backend the ip6tables commands program
+type Backend string
+
+const (
+	// DefaultBackend is the backend of the ip6tables command of the host
+	DefaultBackend Backend = ""
+	// LegacyBackend programs the x_tables of the kernel, with the
+	// ip6tables-legacy commands
+	LegacyBackend Backend = "legacy"
+	// NFTablesBackend programs the nftables of the kernel, with the
+	// ip6tables-nft commands
+	NFTablesBackend Backend = "nft"
+)
+
+var (
+	backend = DefaultBackend
+	// binPath and binRestorePath are the configured paths of the ip6tables
+	// and ip6tables-restore commands, looked up in PATH when empty
+	binPath, binRestorePath string
+	// waitSeconds is the time the commands wait for the xtables lock, 0
+	// waiting until it is released
+	waitSeconds int
+)
+
+// command returns the name of the command of the backend
+func command(name string) string {
+	if backend == DefaultBackend {
+		return name
+	}
+	return name + "-" + string(backend)
+}
+
+// SetBackend makes the rules programmed with the commands of the backend.
+// It fails, leaving the backend in use, when the commands of the legacy or
+// nftables backend are not installed and their paths are not set with
+// SetPaths.
+func SetBackend(b Backend) error {
+	switch b {
+	case DefaultBackend, LegacyBackend, NFTablesBackend:
+	default:
+		return fmt.Errorf("unknown ip6tables backend %q", b)
+	}
+	prev := backend
+	backend = b
+	if _, err := exec.LookPath(command("ip6tables")); err != nil && b != DefaultBackend && binPath == "" {
+		backend = prev
+		return fmt.Errorf("the ip6tables commands of the %q backend are not installed: %v", b, err)
+	}
+	ip6tablesPath, restorePath = "", ""
+	detectIP6tables()
+	return nil
+}
+
+// GetBackend returns the backend the rules are programmed with
+func GetBackend() Backend {
+	return backend
+}
+
+// SetPaths sets the paths of the ip6tables and ip6tables-restore commands, in
+// place of the commands of the backend looked up in PATH. An empty path is
+// looked up. It fails, leaving the paths in use, when a path is not the one
+// of an executable.
+func SetPaths(path, restore string) error {
+	for _, p := range []string{path, restore} {
+		if p == "" {
+			continue
+		}
+		if _, err := exec.LookPath(p); err != nil {
+			return fmt.Errorf("invalid ip6tables command path: %v", err)
+		}
+	}
+	binPath, binRestorePath = path, restore
+	ip6tablesPath, restorePath = "", ""
+	detectIP6tables()
+	return nil
+}
+
+// GetPaths returns the configured paths of the ip6tables and ip6tables-restore
+// commands, empty when they are looked up in PATH
+func GetPaths() (string, string) {
+	return binPath, binRestorePath
+}
+
+// SetWait sets the time in seconds the commands wait for the xtables lock
+// held by another process before failing, 0 waiting until it is released.
+// A time needs ip6tables 1.6.0 or later.
+func SetWait(seconds int) error {
+	if seconds < 0 {
+		return fmt.Errorf("invalid xtables lock wait of %d seconds", seconds)
+	}
+	waitSeconds = seconds
+	return nil
+}
+
+// GetWait returns the time in seconds the commands wait for the xtables
+// lock, 0 waiting until it is released
+func GetWait() int {
+	return waitSeconds
+}
+
+// lookPath returns the configured path of a command, or the path of the
+// command found in PATH
+func lookPath(configured, name string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	return exec.LookPath(name)
+}
+
+// waitArgs returns the arguments of the wait for the xtables lock
+func waitArgs() []string {
+	if waitSeconds > 0 {
+		return []string{"--wait", strconv.Itoa(waitSeconds)}
+	}
+	return []string{"--wait"}
+}
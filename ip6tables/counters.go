@@ -0,0 +1,16 @@
+package ip6tables
+
+import "github.com/docker/libnetwork/internal/xtables"
+
+// ErrRuleNotFound is returned when the counters of a rule which is not
+// programmed are read
+var ErrRuleNotFound = xtables.ErrRuleNotFound
+
+// Counters returns the packet and byte counters of the rule of the chain
+func Counters(table Table, chain string, rule ...string) (packets, bytes uint64, err error) {
+	output, err := Raw("-t", string(table), "-S", chain, "-v")
+	if err != nil {
+		return 0, 0, err
+	}
+	return xtables.IPv6.ParseCounters(string(output), chain, rule)
+}
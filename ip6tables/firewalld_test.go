@@ -26,7 +26,7 @@ func TestReloaded(t *testing.T) {
 	}
 	bridgeName := "lo"
 
-	err = ProgramChain(fwdChain, bridgeName, false, true)
+	err = ProgramChain(fwdChain, bridgeName, "", false, true)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -78,7 +78,7 @@ func TestPassthrough(t *testing.T) {
 		"-j", "ACCEPT"}
 
 	if firewalldRunning {
-		_, err := Passthrough(Iptables, append([]string{"-A"}, rule1...)...)
+		_, err := Passthrough(IP6Tables, append([]string{"-A"}, rule1...)...)
 		if err != nil {
 			t.Fatal(err)
 		}
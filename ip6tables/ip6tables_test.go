@@ -26,7 +26,7 @@ func TestNewChain(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = ProgramChain(natChain, bridgeName, false, true)
+	err = ProgramChain(natChain, bridgeName, "", false, true)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -35,7 +35,7 @@ func TestNewChain(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = ProgramChain(filterChain, bridgeName, false, true)
+	err = ProgramChain(filterChain, bridgeName, "", false, true)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,39 @@
+package ip6tables
+
+import "github.com/docker/libnetwork/internal/xtables"
+
+// SetOwnerTagging sets whether the rules of the owners are tagged with a
+// comment naming their owner, to find the rules left behind by the owners
+// gone with CleanOrphans. It is set before the first rule is programmed.
+func SetOwnerTagging(enabled bool) {
+	xtables.IPv6.SetOwnerTagging(enabled)
+}
+
+// OwnerTagging returns whether the rules of the owners are tagged
+func OwnerTagging() bool {
+	return xtables.IPv6.OwnerTagging()
+}
+
+// OwnerArgs returns the arguments of the comment match tagging a rule of
+// the owner, none when the rules are not tagged. The owner has no spaces.
+func OwnerArgs(owner string) []string {
+	return xtables.IPv6.OwnerArgs(owner)
+}
+
+// OwnedArgs returns the arguments of the rule of the owner, tagged with the
+// comment match of OwnerArgs ahead of its target
+func OwnedArgs(owner string, args []string) []string {
+	return xtables.IPv6.OwnedArgs(owner, args)
+}
+
+// CleanOrphans deletes the tagged rules of the chains of the table whose
+// owner is not alive, and returns the number of the deleted rules
+func CleanOrphans(table Table, chains []string, alive func(owner string) bool) (int, error) {
+	return xtables.CleanOrphans(commands{}, string(table), chains, alive)
+}
+
+// SplitArgs splits a rule, as listed by ip6tables -S, into its arguments,
+// which are quoted when they have spaces
+func SplitArgs(line string) []string {
+	return xtables.SplitArgs(line)
+}
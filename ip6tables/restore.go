@@ -0,0 +1,83 @@
+package ip6tables
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/libnetwork/faults"
+	"github.com/docker/libnetwork/internal/xtables"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	restorePath         string
+	supportsRestoreWait = false
+)
+
+// Rule is a rule of a chain, to program in a batch with ProgramRules
+type Rule struct {
+	Table Table
+	Chain string
+	Args  []string
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf("-t %s %s %s", r.Table, r.Chain, strings.Join(r.Args, " "))
+}
+
+// ProgramRules programs the rules with one ip6tables-restore transaction per
+// table: all the rules of a table are programmed or none are, and the
+// tables already committed are reverted when a table fails. Without
+// ip6tables-restore, or with firewalld running, the rules are programmed
+// one at a time and the programmed ones reverted on failure. Unlike
+// ProgramRule, the rules are added without checking whether they are
+// present, the caller owns them, while the rules to delete already gone
+// are skipped.
+func ProgramRules(action Action, rules []Rule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	if err := faults.Check(faults.Ip6tables); err != nil {
+		return err
+	}
+	if err := initCheck(); err != nil {
+		return err
+	}
+	return xtables.ProgramRules(commands{}, !firewalldRunning && restorePath != "", string(action), xtablesRules(rules))
+}
+
+// xtablesRules returns the rules of the logic shared with the
+// iptables package
+func xtablesRules(rules []Rule) []xtables.Rule {
+	rs := make([]xtables.Rule, 0, len(rules))
+	for _, r := range rules {
+		rs = append(rs, xtables.Rule{Table: string(r.Table), Chain: r.Chain, Args: r.Args})
+	}
+	return rs
+}
+
+// runRestore runs ip6tables-restore --noflush with the input programming
+// what, the filterArgs being the arguments of the output filter
+func runRestore(input []byte, what string, filterArgs ...string) error {
+	if auditRestore(input) {
+		return nil
+	}
+	args := []string{"--noflush"}
+	if supportsRestoreWait {
+		args = append(args, waitArgs()...)
+	} else {
+		bestEffortLock.Lock()
+		defer bestEffortLock.Unlock()
+	}
+
+	logrus.Debugf("%s, %v: %s", restorePath, args, what)
+
+	startTime := time.Now()
+	output, err := xtables.IPv6.RunRestore(restorePath, args, input)
+	if err != nil {
+		return err
+	}
+	filterOutput(startTime, output, append(filterArgs, args...)...)
+	return nil
+}
@@ -0,0 +1,27 @@
+package ip6tables
+
+import "github.com/docker/libnetwork/internal/xtables"
+
+// commands are the ip6tables commands of the logic shared with the
+// iptables package
+type commands struct{}
+
+func (commands) Version() xtables.Version { return xtables.IPv6 }
+
+func (commands) Raw(args ...string) ([]byte, error) { return Raw(args...) }
+
+func (commands) RawCombinedOutput(args ...string) error { return RawCombinedOutput(args...) }
+
+func (commands) ExistChain(table, chain string) bool { return ExistChain(chain, Table(table)) }
+
+func (commands) Exists(table, chain string, args ...string) bool {
+	return Exists(Table(table), chain, args...)
+}
+
+func (commands) ProgramRule(table, chain, action string, args []string) error {
+	return ProgramRule(Table(table), chain, Action(action), args)
+}
+
+func (commands) Restore(input []byte, what string, filterArgs ...string) error {
+	return runRestore(input, what, filterArgs...)
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/ipamapi"
 	"github.com/docker/libnetwork/ipamutils"
+	"github.com/docker/libnetwork/metrics"
 	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 )
@@ -26,6 +27,13 @@ const (
 	dsDataKey   = "ipam/" + ipamapi.DefaultIPAM + "/data"
 )
 
+var (
+	poolsGauge               = metrics.NewGauge("ipam", "pools", "Address pools held from the default IPAM, counting each request of a shared pool", "address_space")
+	addressesCounter         = metrics.NewCounter("ipam", "addresses_allocated_total", "Addresses allocated by the default IPAM", "address_space")
+	releasedAddressesCounter = metrics.NewCounter("ipam", "addresses_released_total", "Addresses released to the default IPAM", "address_space")
+	addressFailuresCounter   = metrics.NewCounter("ipam", "address_failures_total", "Address requests the default IPAM could not satisfy", "address_space")
+)
+
 // Allocator provides per address space ipv4/ipv6 book keeping
 type Allocator struct {
 	// Predefined pools for default address spaces
@@ -252,7 +260,11 @@ retry:
 		goto retry
 	}
 
-	return k.String(), nw, nil, insert()
+	if err := insert(); err != nil {
+		return "", nil, nil, err
+	}
+	poolsGauge.Inc(addressSpace)
+	return k.String(), nw, nil, nil
 }
 
 // ReleasePool releases the address pool identified by the passed id
@@ -285,7 +297,11 @@ retry:
 		goto retry
 	}
 
-	return remove()
+	if err := remove(); err != nil {
+		return err
+	}
+	poolsGauge.Dec(k.AddressSpace)
+	return nil
 }
 
 // Given the address space, returns the local or global PoolConfig based on whether the
@@ -384,6 +400,18 @@ func (a *Allocator) retrieveBitmask(k SubnetKey, n *net.IPNet) (*bitseq.Handle,
 	return bm, nil
 }
 
+// ReloadDefaultPools reloads the predefined pools of the default address
+// spaces from the configured default address pools
+func (a *Allocator) ReloadDefaultPools() {
+	a.Lock()
+	a.predefined = map[string][]*net.IPNet{
+		localAddressSpace:  ipamutils.GetLocalScopeDefaultNetworks(),
+		globalAddressSpace: ipamutils.GetGlobalScopeDefaultNetworks(),
+	}
+	a.predefinedStartIndices = make(map[string]int)
+	a.Unlock()
+}
+
 func (a *Allocator) getPredefineds(as string) []*net.IPNet {
 	a.Lock()
 	defer a.Unlock()
@@ -498,8 +526,10 @@ func (a *Allocator) RequestAddress(poolID string, prefAddress net.IP, opts map[s
 	}
 	ip, err := a.getAddress(p.Pool, bm, prefAddress, p.Range, serial)
 	if err != nil {
+		addressFailuresCounter.Inc(k.AddressSpace)
 		return nil, nil, err
 	}
+	addressesCounter.Inc(k.AddressSpace)
 
 	return &net.IPNet{IP: ip, Mask: p.Pool.Mask}, nil, nil
 }
@@ -559,7 +589,11 @@ func (a *Allocator) ReleaseAddress(poolID string, address net.IP) error {
 	}
 	defer logrus.Debugf("Released address PoolID:%s, Address:%v Sequence:%s", poolID, address, bm.String())
 
-	return bm.Unset(ipToUint64(h))
+	if err := bm.Unset(ipToUint64(h)); err != nil {
+		return err
+	}
+	releasedAddressesCounter.Inc(k.AddressSpace)
+	return nil
 }
 
 func (a *Allocator) getAddress(nw *net.IPNet, bitmask *bitseq.Handle, prefAddress net.IP, ipr *AddressRange, serial bool) (net.IP, error) {
@@ -17,6 +17,7 @@ import (
 	"github.com/docker/libnetwork/bitseq"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/ipamapi"
+	"github.com/docker/libnetwork/ipamutils"
 	_ "github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
 	"gotest.tools/assert"
@@ -461,6 +462,34 @@ func TestPredefinedPool(t *testing.T) {
 	}
 }
 
+func TestReloadDefaultPools(t *testing.T) {
+	a, err := getAllocator(false)
+	assert.NilError(t, err)
+	defer func() {
+		assert.NilError(t, ipamutils.ConfigLocalScopeDefaultNetworks(nil))
+	}()
+
+	pid, nw, _, err := a.RequestPool(localAddressSpace, "", "", nil, false)
+	assert.NilError(t, err)
+
+	assert.NilError(t, ipamutils.ConfigLocalScopeDefaultNetworks([]*ipamutils.NetworkToSplit{{Base: "10.200.0.0/16", Size: 24}}))
+	a.ReloadDefaultPools()
+
+	pid2, nw2, _, err := a.RequestPool(localAddressSpace, "", "", nil, false)
+	assert.NilError(t, err)
+	if nw2.String() != "10.200.0.0/24" {
+		t.Fatalf("expected a pool of the reloaded defaults, got %s", nw2)
+	}
+
+	if types.CompareIPNet(nw, nw2) {
+		t.Fatalf("Unexpected default network returned: %s = %s", nw2, nw)
+	}
+
+	// the pool allocated before the reload is kept
+	assert.NilError(t, a.ReleasePool(pid2))
+	assert.NilError(t, a.ReleasePool(pid))
+}
+
 func TestRemoveSubnet(t *testing.T) {
 	for _, store := range []bool{false, true} {
 		a, err := getAllocator(store)
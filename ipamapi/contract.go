@@ -85,6 +85,15 @@ type Ipam interface {
 	IsBuiltIn() bool
 }
 
+// DefaultPoolsReloader is implemented by the IPAM drivers which can reload
+// their default pools, after the default address pools were configured
+// again at runtime
+type DefaultPoolsReloader interface {
+	// ReloadDefaultPools makes the pools requested from now on come from the
+	// configured default address pools. The allocated pools are kept.
+	ReloadDefaultPools()
+}
+
 // Capability represents the requirements and capabilities of the IPAM driver
 type Capability struct {
 	// Whether on address request, libnetwork must
@@ -80,10 +80,11 @@ func ConfigGlobalScopeDefaultNetworks(defaultAddressPool []*NetworkToSplit) erro
 }
 
 // ConfigLocalScopeDefaultNetworks configures local default pool.
-// Ideally this will be called during libnetwork init
+// Ideally this will be called during libnetwork init. A nil pool restores
+// the built-in defaults.
 func ConfigLocalScopeDefaultNetworks(defaultAddressPool []*NetworkToSplit) error {
 	if defaultAddressPool == nil {
-		return nil
+		defaultAddressPool = localScopeDefaultNetworks
 	}
 	return configDefaultNetworks(defaultAddressPool, &PredefinedLocalScopeDefaultNetworks)
 }
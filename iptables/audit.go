@@ -0,0 +1,51 @@
+package iptables
+
+import "github.com/docker/libnetwork/internal/xtables"
+
+// Audit is the mode of the auditing of the commands changing the rules
+type Audit = xtables.Audit
+
+const (
+	// AuditOff runs the commands without logging them
+	AuditOff = xtables.AuditOff
+	// AuditLog runs the commands and logs each of them
+	AuditLog = xtables.AuditLog
+	// AuditDryRun logs and records the commands without running them, the
+	// commands reading the rules still run
+	AuditDryRun = xtables.AuditDryRun
+)
+
+// ParseAudit returns the audit mode of its name, as returned by String
+func ParseAudit(name string) (Audit, error) {
+	return xtables.ParseAudit(name)
+}
+
+// SetAudit sets the audit mode of the commands changing the rules, which
+// lets the operators review the rules programmed on a host, or the ones
+// which would be, before letting them be programmed
+func SetAudit(a Audit) {
+	xtables.IPv4.SetAudit(a)
+}
+
+// GetAudit returns the audit mode of the commands changing the rules
+func GetAudit() Audit {
+	return xtables.IPv4.GetAudit()
+}
+
+// Audited returns the commands recorded by the dry run since the last call,
+// in order, and forgets them
+func Audited() []string {
+	return xtables.IPv4.Audited()
+}
+
+// auditCommand audits the command of the arguments, and returns whether it
+// is to be skipped, as the commands changing the rules of the dry runs
+func auditCommand(args []string) bool {
+	return xtables.IPv4.AuditCommand(command("iptables"), args)
+}
+
+// auditRestore audits the input of iptables-restore, and returns whether it
+// is to be skipped
+func auditRestore(input []byte) bool {
+	return xtables.IPv4.AuditRestore(command("iptables")+"-restore", input)
+}
@@ -0,0 +1,47 @@
+package iptables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuditDryRun(t *testing.T) {
+	defer SetAudit(GetAudit())
+	SetAudit(AuditDryRun)
+	Audited()
+
+	if _, err := Raw("-t", "nat", "-A", "DOCKER", "-p", "tcp", "-j", "RETURN"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RawCombinedOutputNative("-N", "DOCKER-TEST"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runRestore([]byte("*filter\n-I DOCKER -j DROP\nCOMMIT\n"), "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		command("iptables") + " -t nat -A DOCKER -p tcp -j RETURN",
+		command("iptables") + " -N DOCKER-TEST",
+		command("iptables") + "-restore *filter",
+		command("iptables") + "-restore -I DOCKER -j DROP",
+		command("iptables") + "-restore COMMIT",
+	}
+	if cmds := Audited(); !reflect.DeepEqual(cmds, expected) {
+		t.Fatalf("Expected the commands %q, got %q", expected, cmds)
+	}
+	if cmds := Audited(); cmds != nil {
+		t.Fatalf("Expected the commands to be forgotten, got %q", cmds)
+	}
+}
+
+func TestParseAudit(t *testing.T) {
+	for name, expected := range map[string]Audit{"": AuditOff, "off": AuditOff, "log": AuditLog, "dry-run": AuditDryRun} {
+		if a, err := ParseAudit(name); err != nil || a != expected {
+			t.Fatalf("Expected %q to be parsed as %v, got %v (%v)", name, expected, a, err)
+		}
+	}
+	if _, err := ParseAudit("verbose"); err == nil {
+		t.Fatal("Expected an unknown audit mode to fail")
+	}
+}
@@ -0,0 +1,39 @@
+package iptables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetPaths(t *testing.T) {
+	if err := SetPaths("/nonexistent/iptables", ""); err == nil {
+		t.Fatal("Expected the path of a missing command to fail")
+	}
+	if path, restore := GetPaths(); path != "" || restore != "" {
+		t.Fatalf("Expected the failed paths not to be set, got %q and %q", path, restore)
+	}
+
+	if path, err := lookPath("/opt/xtables/iptables", command("iptables")); err != nil || path != "/opt/xtables/iptables" {
+		t.Fatalf("Expected the configured path to be used, got %q (%v)", path, err)
+	}
+}
+
+func TestSetWait(t *testing.T) {
+	defer SetWait(GetWait())
+
+	if err := SetWait(-1); err == nil {
+		t.Fatal("Expected a negative wait to fail")
+	}
+	if err := SetWait(0); err != nil {
+		t.Fatal(err)
+	}
+	if args := waitArgs(); !reflect.DeepEqual(args, []string{"--wait"}) {
+		t.Fatalf("Expected to wait until the lock is released, got %v", args)
+	}
+	if err := SetWait(5); err != nil {
+		t.Fatal(err)
+	}
+	if args := waitArgs(); !reflect.DeepEqual(args, []string{"--wait", "5"}) {
+		t.Fatalf("Expected to wait 5 seconds, got %v", args)
+	}
+}
@@ -2,6 +2,7 @@ package iptables
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"syscall"
 
@@ -57,3 +58,64 @@ func purgeConntrackState(nlh *netlink.Handle, family netlink.InetFamily, ipAddre
 	filter.AddIP(netlink.ConntrackNatAnyIP, ipAddress)
 	return nlh.ConntrackDeleteFilter(netlink.ConntrackTable, family, filter)
 }
+
+// DeleteConntrackMappingEntries deletes the conntrack connections on the
+// host translated from the host ports from hostPort to hostPortEnd of hostIP
+// to destIP, the connections of all the host addresses when hostIP is
+// unspecified. It returns the number of flows deleted.
+func DeleteConntrackMappingEntries(nlh *netlink.Handle, proto string, hostIP net.IP, hostPort, hostPortEnd int, destIP net.IP) (uint, error) {
+	if !IsConntrackProgrammable(nlh) {
+		return 0, ErrConntrackNotConfigurable
+	}
+	filter, err := newMappingFilter(proto, hostIP, hostPort, hostPortEnd, destIP)
+	if err != nil {
+		return 0, err
+	}
+	family := netlink.InetFamily(syscall.AF_INET)
+	if destIP.To4() == nil {
+		family = syscall.AF_INET6
+	}
+	flowPurged, err := nlh.ConntrackDeleteFilter(netlink.ConntrackTable, family, filter)
+	if err != nil {
+		return 0, err
+	}
+	logrus.Debugf("DeleteConntrackMappingEntries purged %d %s flows to %s", flowPurged, proto, destIP)
+	return flowPurged, nil
+}
+
+// mappingFilter matches the conntrack flows translated by a port mapping
+type mappingFilter struct {
+	proto                 uint8
+	hostIP                net.IP
+	hostPort, hostPortEnd uint16
+	destIP                net.IP
+}
+
+func newMappingFilter(proto string, hostIP net.IP, hostPort, hostPortEnd int, destIP net.IP) (*mappingFilter, error) {
+	f := &mappingFilter{hostPort: uint16(hostPort), hostPortEnd: uint16(hostPortEnd), destIP: destIP}
+	switch proto {
+	case "tcp":
+		f.proto = syscall.IPPROTO_TCP
+	case "udp":
+		f.proto = syscall.IPPROTO_UDP
+	case "sctp":
+		f.proto = syscall.IPPROTO_SCTP
+	case "dccp":
+		f.proto = syscall.IPPROTO_DCCP
+	default:
+		return nil, fmt.Errorf("unknown protocol %s", proto)
+	}
+	if !hostIP.IsUnspecified() {
+		f.hostIP = hostIP
+	}
+	return f, nil
+}
+
+// MatchConntrackFlow returns whether the flow was sent to the host ports
+// and translated to the destination address
+func (f *mappingFilter) MatchConntrackFlow(flow *netlink.ConntrackFlow) bool {
+	return flow.Forward.Protocol == f.proto &&
+		flow.Forward.DstPort >= f.hostPort && flow.Forward.DstPort <= f.hostPortEnd &&
+		(f.hostIP == nil || flow.Forward.DstIP.Equal(f.hostIP)) &&
+		flow.Reverse.SrcIP.Equal(f.destIP)
+}
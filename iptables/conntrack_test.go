@@ -0,0 +1,50 @@
+package iptables
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestMappingFilter(t *testing.T) {
+	hostIP, destIP := net.ParseIP("192.168.1.10"), net.ParseIP("172.17.0.2")
+	flow := func(proto uint8, dst string, dport uint16, replySrc string) *netlink.ConntrackFlow {
+		f := &netlink.ConntrackFlow{}
+		f.Forward.Protocol, f.Forward.DstIP, f.Forward.DstPort = proto, net.ParseIP(dst), dport
+		f.Reverse.SrcIP = net.ParseIP(replySrc)
+		return f
+	}
+
+	f, err := newMappingFilter("udp", hostIP, 5000, 5009, destIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		flow  *netlink.ConntrackFlow
+		match bool
+	}{
+		{flow(syscall.IPPROTO_UDP, "192.168.1.10", 5000, "172.17.0.2"), true},
+		{flow(syscall.IPPROTO_UDP, "192.168.1.10", 5009, "172.17.0.2"), true},
+		{flow(syscall.IPPROTO_UDP, "192.168.1.10", 5010, "172.17.0.2"), false},
+		{flow(syscall.IPPROTO_TCP, "192.168.1.10", 5000, "172.17.0.2"), false},
+		{flow(syscall.IPPROTO_UDP, "192.168.1.10", 5000, "172.17.0.3"), false},
+	} {
+		if f.MatchConntrackFlow(tc.flow) != tc.match {
+			t.Fatalf("expected the match of %s to be %v", tc.flow, tc.match)
+		}
+	}
+
+	// the unspecified host address matches all the host addresses
+	f, err = newMappingFilter("udp", net.IPv4zero, 5000, 5000, destIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.MatchConntrackFlow(flow(syscall.IPPROTO_UDP, "10.0.0.1", 5000, "172.17.0.2")) {
+		t.Fatal("expected the flows to all the host addresses to match")
+	}
+	if _, err := newMappingFilter("icmp", hostIP, 5000, 5000, destIP); err == nil {
+		t.Fatal("expected an unknown protocol to be rejected")
+	}
+}
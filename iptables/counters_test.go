@@ -0,0 +1,41 @@
+package iptables
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/internal/xtables"
+)
+
+func TestParseCounters(t *testing.T) {
+	output := `-N DOCKER
+-A DOCKER -i docker0 -c 0 0 -j RETURN
+-A DOCKER -d 192.168.0.1/32 ! -i docker0 -p tcp -m tcp --dport 8080 -c 12 720 -j DNAT --to-destination 172.17.0.2:80
+-A DOCKER ! -i docker0 -p tcp -m tcp --dport 8081 -c 3 180 -j DNAT --to-destination 172.17.0.2:80
+-A DOCKER -d 172.17.0.2/32 ! -i docker0 -o docker0 -p tcp -m tcp --dport 80 -c 40 52000 -j ACCEPT
+`
+	c := &ChainInfo{Name: "DOCKER", Table: Nat}
+	expected := []struct {
+		rule    Rule
+		packets uint64
+		bytes   uint64
+	}{
+		{c.ForwardRules(net.ParseIP("192.168.0.1"), 8080, "tcp", "172.17.0.2", 80, "docker0")[0], 12, 720},
+		{c.ForwardRules(net.IPv4zero, 8081, "tcp", "172.17.0.2", 80, "docker0")[0], 3, 180},
+		{c.ForwardRules(net.IPv4zero, 8081, "tcp", "172.17.0.2", 80, "docker0")[1], 40, 52000},
+	}
+	for i, e := range expected {
+		packets, bytes, err := xtables.IPv4.ParseCounters(output, e.rule.Chain, e.rule.Args)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if packets != e.packets || bytes != e.bytes {
+			t.Fatalf("%d: expected %d packets and %d bytes, got %d and %d", i, e.packets, e.bytes, packets, bytes)
+		}
+	}
+
+	rule := c.ForwardRules(net.IPv4zero, 9090, "tcp", "172.17.0.2", 80, "docker0")[0]
+	if _, _, err := xtables.IPv4.ParseCounters(output, rule.Chain, rule.Args); err != ErrRuleNotFound {
+		t.Fatalf("expected %v, got %v", ErrRuleNotFound, err)
+	}
+}
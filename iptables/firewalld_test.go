@@ -25,7 +25,7 @@ func TestReloaded(t *testing.T) {
 	}
 	bridgeName := "lo"
 
-	err = ProgramChain(fwdChain, bridgeName, false, true)
+	err = ProgramChain(fwdChain, bridgeName, "", false, true)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -3,6 +3,7 @@ package iptables
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"net"
 	"os/exec"
 	"regexp"
@@ -11,6 +12,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/docker/libnetwork/faults"
+	"github.com/docker/libnetwork/internal/xtables"
 	"github.com/sirupsen/logrus"
 )
 
@@ -40,6 +43,9 @@ const (
 	Drop Policy = "DROP"
 	// Accept is the default iptables ACCEPT policy
 	Accept Policy = "ACCEPT"
+	// HairpinMark is the packet mark of the traffic from a bridge
+	// translated by the hairpin rules, to be masqueraded.
+	HairpinMark = "0x100000"
 )
 
 var (
@@ -87,18 +93,22 @@ func initFirewalld() {
 }
 
 func detectIptables() {
-	path, err := exec.LookPath("iptables")
+	path, err := lookPath(binPath, command("iptables"))
 	if err != nil {
 		return
 	}
 	iptablesPath = path
 	supportsXlock = exec.Command(iptablesPath, "--wait", "-L", "-n").Run() == nil
+	if path, err := lookPath(binRestorePath, command("iptables")+"-restore"); err == nil {
+		restorePath = path
+	}
 	mj, mn, mc, err := GetVersion()
 	if err != nil {
 		logrus.Warnf("Failed to read iptables version: %v", err)
 		return
 	}
 	supportsCOpt = supportsCOption(mj, mn, mc)
+	supportsRestoreWait = xtables.SupportsRestoreWait(mj, mn, mc)
 }
 
 func initDependencies() {
@@ -138,8 +148,9 @@ func NewChain(name string, table Table, hairpinMode bool) (*ChainInfo, error) {
 	return c, nil
 }
 
-// ProgramChain is used to add rules to a chain
-func ProgramChain(c *ChainInfo, bridgeName string, hairpinMode, enable bool) error {
+// ProgramChain is used to add rules to a chain. The rules of the bridge
+// in the filter table are tagged with the owner, when the rules are tagged.
+func ProgramChain(c *ChainInfo, bridgeName, owner string, hairpinMode, enable bool) error {
 	if c.Name == "" {
 		return errors.New("Could not program chain, missing chain name")
 	}
@@ -180,9 +191,9 @@ func ProgramChain(c *ChainInfo, bridgeName string, hairpinMode, enable bool) err
 			return fmt.Errorf("Could not program chain %s/%s, missing bridge name",
 				c.Table, c.Name)
 		}
-		link := []string{
+		link := OwnedArgs(owner, []string{
 			"-o", bridgeName,
-			"-j", c.Name}
+			"-j", c.Name})
 		if !Exists(Filter, "FORWARD", link...) && enable {
 			insert := append([]string{string(Insert), "FORWARD"}, link...)
 			if output, err := Raw(insert...); err != nil {
@@ -199,11 +210,11 @@ func ProgramChain(c *ChainInfo, bridgeName string, hairpinMode, enable bool) err
 			}
 
 		}
-		establish := []string{
+		establish := OwnedArgs(owner, []string{
 			"-o", bridgeName,
 			"-m", "conntrack",
 			"--ctstate", "RELATED,ESTABLISHED",
-			"-j", "ACCEPT"}
+			"-j", "ACCEPT"})
 		if !Exists(Filter, "FORWARD", establish...) && enable {
 			insert := append([]string{string(Insert), "FORWARD"}, establish...)
 			if output, err := Raw(insert...); err != nil {
@@ -237,50 +248,65 @@ func RemoveExistingChain(name string, table Table) error {
 
 // Forward adds forwarding rule to 'filter' table and corresponding nat rule to 'nat' table.
 func (c *ChainInfo) Forward(action Action, ip net.IP, port int, proto, destAddr string, destPort int, bridgeName string) error {
-	daddr := ip.String()
-	if ip.IsUnspecified() {
-		// iptables interprets "0.0.0.0" as "0.0.0.0/32", whereas we
-		// want "0.0.0.0/0". "0/0" is correctly interpreted as "any
-		// value" by both iptables and ip6tables.
-		daddr = "0/0"
+	return c.ForwardRange(action, ip, port, port, proto, destAddr, destPort, bridgeName)
+}
+
+// ForwardRange adds the forwarding rules of the host ports from port to
+// portEnd to the as many consecutive ports of destAddr from destPort.
+func (c *ChainInfo) ForwardRange(action Action, ip net.IP, port, portEnd int, proto, destAddr string, destPort int, bridgeName string) error {
+	for _, r := range c.ForwardRangeRules(ip, port, portEnd, proto, destAddr, destPort, bridgeName) {
+		if err := ProgramRule(r.Table, r.Chain, action, r.Args); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// ForwardRules returns the rules Forward programs, to program them in a
+// batch with ProgramRules.
+func (c *ChainInfo) ForwardRules(ip net.IP, port int, proto, destAddr string, destPort int, bridgeName string) []Rule {
+	return c.ForwardRangeRules(ip, port, port, proto, destAddr, destPort, bridgeName)
+}
+
+// ForwardRangeRules returns the rules ForwardRange programs. A range keeps
+// its ports when they are the same on the host and in the container, it
+// is otherwise shifted with the base port of the DNAT target, which needs
+// the revision 2 of the target, from Linux 4.19.
+func (c *ChainInfo) ForwardRangeRules(ip net.IP, port, portEnd int, proto, destAddr string, destPort int, bridgeName string) []Rule {
+	var rules []Rule
+
+	daddr, dport, destDport, toDestination := forwardSpecs(ip, port, portEnd, destAddr, destPort)
 
 	args := []string{
 		"-p", proto,
 		"-d", daddr,
-		"--dport", strconv.Itoa(port),
+		"--dport", dport,
 		"-j", "DNAT",
-		"--to-destination", net.JoinHostPort(destAddr, strconv.Itoa(destPort))}
+		"--to-destination", toDestination}
 	if !c.HairpinMode {
 		args = append(args, "!", "-i", bridgeName)
 	}
-	if err := ProgramRule(Nat, c.Name, action, args); err != nil {
-		return err
-	}
+	rules = append(rules, Rule{Table: Nat, Chain: c.Name, Args: args})
 
 	args = []string{
 		"!", "-i", bridgeName,
 		"-o", bridgeName,
 		"-p", proto,
 		"-d", destAddr,
-		"--dport", strconv.Itoa(destPort),
+		"--dport", destDport,
 		"-j", "ACCEPT",
 	}
-	if err := ProgramRule(Filter, c.Name, action, args); err != nil {
-		return err
-	}
+	rules = append(rules, Rule{Table: Filter, Chain: c.Name, Args: args})
 
 	args = []string{
 		"-p", proto,
 		"-s", destAddr,
 		"-d", destAddr,
-		"--dport", strconv.Itoa(destPort),
+		"--dport", destDport,
 		"-j", "MASQUERADE",
 	}
 
-	if err := ProgramRule(Nat, "POSTROUTING", action, args); err != nil {
-		return err
-	}
+	rules = append(rules, Rule{Table: Nat, Chain: "POSTROUTING", Args: args})
 
 	if proto == "sctp" {
 		// Linux kernel v4.9 and below enables NETIF_F_SCTP_CRC for veth by
@@ -292,16 +318,116 @@ func (c *ChainInfo) Forward(action Action, ip net.IP, port int, proto, destAddr
 		// https://github.com/torvalds/linux/commit/c80fafbbb59ef9924962f83aac85531039395b18
 		args = []string{
 			"-p", proto,
-			"--sport", strconv.Itoa(destPort),
+			"--sport", destDport,
 			"-j", "CHECKSUM",
 			"--checksum-fill",
 		}
-		if err := ProgramRule(Mangle, "POSTROUTING", action, args); err != nil {
-			return err
+		rules = append(rules, Rule{Table: Mangle, Chain: "POSTROUTING", Args: args})
+	}
+
+	return rules
+}
+
+// HairpinRules returns the rules translating the traffic from the bridge
+// to the host ports, for the containers of the bridge to reach destAddr
+// through the published address whatever the hairpin mode of the chain.
+// The traffic is marked to be masqueraded, for the replies to go back
+// through the host. The rules are to be inserted with ProgramRules, in
+// order, ahead of the rule of the chain skipping the DNAT of the traffic
+// from the bridge.
+func (c *ChainInfo) HairpinRules(ip net.IP, port, portEnd int, proto, destAddr string, destPort int, bridgeName string) []Rule {
+	daddr, dport, destDport, toDestination := forwardSpecs(ip, port, portEnd, destAddr, destPort)
+	return []Rule{
+		{Table: Nat, Chain: c.Name, Args: []string{
+			"-i", bridgeName,
+			"-p", proto,
+			"-d", daddr,
+			"--dport", dport,
+			"-j", "DNAT",
+			"--to-destination", toDestination}},
+		{Table: Nat, Chain: c.Name, Args: []string{
+			"-i", bridgeName,
+			"-p", proto,
+			"-d", daddr,
+			"--dport", dport,
+			"-j", "MARK",
+			"--set-xmark", HairpinMark + "/" + HairpinMark}},
+		{Table: Filter, Chain: c.Name, Args: []string{
+			"-i", bridgeName,
+			"-o", bridgeName,
+			"-p", proto,
+			"-d", destAddr,
+			"--dport", destDport,
+			"-j", "ACCEPT"}},
+		{Table: Nat, Chain: "POSTROUTING", Args: []string{
+			"-o", bridgeName,
+			"-p", proto,
+			"-d", destAddr,
+			"--dport", destDport,
+			"-m", "mark",
+			"--mark", HairpinMark + "/" + HairpinMark,
+			"-j", "MASQUERADE"}},
+	}
+}
+
+// RateLimitRules returns the rule dropping the new connections to the
+// ports of destAddr from destPort to destPortEnd above rate per second,
+// with a burst of burst connections, the hashlimit default when 0. The
+// connections to the ports share the limit, whatever the host address
+// they were published on. The rule is to be inserted with ProgramRules
+// ahead of the rule of the chain accepting the traffic.
+func (c *ChainInfo) RateLimitRules(proto, destAddr string, destPort, destPortEnd, rate, burst int, bridgeName string) []Rule {
+	destDport := portSpec(destPort, destPortEnd)
+	// the names of the hashlimit tables are limited to 15 characters
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s/%s:%s", proto, destAddr, destDport)
+	args := []string{
+		"-o", bridgeName,
+		"-p", proto,
+		"-d", destAddr,
+		"--dport", destDport,
+		"-m", "conntrack", "--ctstate", "NEW",
+		"-m", "hashlimit",
+		"--hashlimit-above", fmt.Sprintf("%d/sec", rate),
+		"--hashlimit-name", fmt.Sprintf("docker-%08x", h.Sum32()),
+	}
+	if burst > 0 {
+		args = append(args, "--hashlimit-burst", strconv.Itoa(burst))
+	}
+	return []Rule{{Table: Filter, Chain: c.Name, Args: append(args, "-j", "DROP")}}
+}
+
+// forwardSpecs returns the destination address and ports of the rules
+// forwarding the host ports from port to portEnd to destAddr
+func forwardSpecs(ip net.IP, port, portEnd int, destAddr string, destPort int) (daddr, dport, destDport, toDestination string) {
+	destPortEnd := destPort + portEnd - port
+	dport, destDport = portSpec(port, portEnd), portSpec(destPort, destPortEnd)
+	toDestination = net.JoinHostPort(destAddr, strconv.Itoa(destPort))
+	if portEnd != port {
+		if destPort == port {
+			toDestination = destAddr
+		} else {
+			toDestination = net.JoinHostPort(destAddr, fmt.Sprintf("%d-%d/%d", destPort, destPortEnd, port))
 		}
 	}
 
-	return nil
+	daddr = ip.String()
+	if ip.IsUnspecified() {
+		// iptables interprets "0.0.0.0" as "0.0.0.0/32", whereas we
+		// want "0.0.0.0/0". "0/0" is correctly interpreted as "any
+		// value" by both iptables and ip6tables.
+		daddr = "0/0"
+	}
+	return daddr, dport, destDport, toDestination
+}
+
+// portSpec returns the iptables argument matching the ports from port to
+// portEnd
+func portSpec(port, portEnd int) string {
+	if portEnd == port {
+		return strconv.Itoa(port)
+	}
+	return fmt.Sprintf("%d:%d", port, portEnd)
 }
 
 // Link adds reciprocal ACCEPT rule for two supplied IP addresses.
@@ -445,6 +571,12 @@ func filterOutput(start time.Time, output []byte, args ...string) []byte {
 
 // Raw calls 'iptables' system command, passing supplied arguments.
 func Raw(args ...string) ([]byte, error) {
+	if err := faults.Check(faults.Iptables); err != nil {
+		return nil, err
+	}
+	if auditCommand(args) {
+		return nil, nil
+	}
 	if firewalldRunning {
 		startTime := time.Now()
 		output, err := Passthrough(Iptables, args...)
@@ -460,7 +592,7 @@ func raw(args ...string) ([]byte, error) {
 		return nil, err
 	}
 	if supportsXlock {
-		args = append([]string{"--wait"}, args...)
+		args = append(waitArgs(), args...)
 	} else {
 		bestEffortLock.Lock()
 		defer bestEffortLock.Unlock()
@@ -489,6 +621,12 @@ func RawCombinedOutput(args ...string) error {
 // RawCombinedOutputNative behave as RawCombinedOutput with the difference it
 // will always invoke `iptables` binary
 func RawCombinedOutputNative(args ...string) error {
+	if err := faults.Check(faults.Iptables); err != nil {
+		return err
+	}
+	if auditCommand(args) {
+		return nil
+	}
 	if output, err := raw(args...); err != nil || len(output) != 0 {
 		return fmt.Errorf("%s (%v)", string(output), err)
 	}
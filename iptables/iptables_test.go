@@ -25,7 +25,7 @@ func TestNewChain(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = ProgramChain(natChain, bridgeName, false, true)
+	err = ProgramChain(natChain, bridgeName, "", false, true)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -34,7 +34,7 @@ func TestNewChain(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = ProgramChain(filterChain, bridgeName, false, true)
+	err = ProgramChain(filterChain, bridgeName, "", false, true)
 	if err != nil {
 		t.Fatal(err)
 	}
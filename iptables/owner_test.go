@@ -0,0 +1,43 @@
+package iptables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOwnerArgs(t *testing.T) {
+	defer SetOwnerTagging(OwnerTagging())
+
+	SetOwnerTagging(false)
+	if args := OwnerArgs("network:n1"); args != nil {
+		t.Fatalf("Expected no arguments without tagging, got %v", args)
+	}
+
+	SetOwnerTagging(true)
+	expected := []string{"-m", "comment", "--comment", "libnetwork:network:n1"}
+	if args := OwnerArgs("network:n1"); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Expected %v, got %v", expected, args)
+	}
+	if args := OwnerArgs(""); args != nil {
+		t.Fatalf("Expected no arguments without owner, got %v", args)
+	}
+}
+
+func TestOwnedArgs(t *testing.T) {
+	defer SetOwnerTagging(OwnerTagging())
+
+	args := []string{"-i", "br0", "-j", "ACCEPT"}
+	SetOwnerTagging(false)
+	if owned := OwnedArgs("network:n1", args); !reflect.DeepEqual(owned, args) {
+		t.Fatalf("Expected the arguments untouched without tagging, got %v", owned)
+	}
+
+	SetOwnerTagging(true)
+	expected := []string{"-i", "br0", "-m", "comment", "--comment", "libnetwork:network:n1", "-j", "ACCEPT"}
+	if owned := OwnedArgs("network:n1", args); !reflect.DeepEqual(owned, expected) {
+		t.Fatalf("Expected %v, got %v", expected, owned)
+	}
+	if len(args) != 4 {
+		t.Fatalf("Expected the arguments not to be modified, got %v", args)
+	}
+}
@@ -0,0 +1,251 @@
+package iptables
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Drift is the drift of the rules of a watch from the firewall, found and
+// corrected by the reconciler
+type Drift struct {
+	// Name is the name of the watch
+	Name string
+	// Missing are the rules and chains of the watch found missing
+	Missing []Rule
+	// Err is the error of the repair, nil when the drift was corrected
+	Err error
+}
+
+// watch is a set of rules kept in place by the reconciler
+type watch struct {
+	checks []Rule
+	repair func(missing []Rule) error
+}
+
+// driftCallback is a callback registered with OnDrift
+type driftCallback struct {
+	id int
+	fn func(Drift)
+}
+
+var (
+	reconcileMu sync.Mutex
+	// repairMu is held by the checks and repairs of a watch, so that the
+	// watch is not repaired once Unwatch returns
+	repairMu sync.Mutex
+	watches  = make(map[string]*watch)
+	onDrift  []driftCallback
+	driftSeq int
+	// reconcileStop stops the running reconciler, nil when none runs
+	reconcileStop chan struct{}
+	reconcileDone chan struct{}
+
+	// ruleExists returns whether the rule, or the chain of a rule without
+	// arguments, exists
+	ruleExists = func(r Rule) bool {
+		if len(r.Args) == 0 {
+			return ExistChain(r.Chain, r.Table)
+		}
+		return Exists(r.Table, r.Chain, r.Args...)
+	}
+)
+
+// JumpRules returns the rules ProgramChain programs jumping to the chain,
+// for the bridge of bridgeName and its owner in the filter table
+func (c *ChainInfo) JumpRules(bridgeName, owner string, hairpinMode bool) []Rule {
+	switch c.Table {
+	case Nat:
+		output := []string{"-m", "addrtype", "--dst-type", "LOCAL", "-j", c.Name}
+		if !hairpinMode {
+			output = append(output, "!", "--dst", "127.0.0.0/8")
+		}
+		return []Rule{
+			{Table: Nat, Chain: "PREROUTING", Args: []string{"-m", "addrtype", "--dst-type", "LOCAL", "-j", c.Name}},
+			{Table: Nat, Chain: "OUTPUT", Args: output},
+		}
+	case Filter:
+		return []Rule{
+			{Table: Filter, Chain: "FORWARD", Args: OwnedArgs(owner, []string{"-o", bridgeName, "-j", c.Name})},
+			{Table: Filter, Chain: "FORWARD", Args: OwnedArgs(owner, []string{"-o", bridgeName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"})},
+		}
+	}
+	return nil
+}
+
+// Watch makes the reconciler check that the rules are in place, a rule
+// without arguments standing for its chain, and repair the missing ones,
+// as when an external tool flushed the tables. The watch replaces the one
+// of the same name. With a nil repair, the missing chains are created and
+// the missing rules inserted at the top of their chains. The repair does not
+// call Unwatch.
+func Watch(name string, checks []Rule, repair func(missing []Rule) error) {
+	checks = append([]Rule(nil), checks...)
+	for i := range checks {
+		if checks[i].Table == "" {
+			checks[i].Table = Filter
+		}
+	}
+	reconcileMu.Lock()
+	watches[name] = &watch{checks: checks, repair: repair}
+	reconcileMu.Unlock()
+}
+
+// Unwatch removes the watch of the name, waiting for its running repair
+func Unwatch(name string) {
+	repairMu.Lock()
+	defer repairMu.Unlock()
+	reconcileMu.Lock()
+	delete(watches, name)
+	reconcileMu.Unlock()
+}
+
+// OnDrift registers a callback called with each drift found by the
+// reconciler, once it is repaired or failed to be, and returns the function
+// removing it
+func OnDrift(callback func(Drift)) func() {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+	driftSeq++
+	id := driftSeq
+	onDrift = append(onDrift, driftCallback{id: id, fn: callback})
+	return func() {
+		reconcileMu.Lock()
+		defer reconcileMu.Unlock()
+		for i, cb := range onDrift {
+			if cb.id == id {
+				onDrift = append(onDrift[:i:i], onDrift[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// StartReconciler runs the reconciler in the background, checking the
+// watches every interval, in place of the running one. A zero interval
+// stops it.
+func StartReconciler(interval time.Duration) {
+	StopReconciler()
+	if interval <= 0 {
+		return
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	reconcileMu.Lock()
+	reconcileStop, reconcileDone = stop, done
+	reconcileMu.Unlock()
+
+	go func() {
+		defer close(done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				Reconcile()
+			}
+		}
+	}()
+}
+
+// StopReconciler stops the reconciler running in the background
+func StopReconciler() {
+	reconcileMu.Lock()
+	stop, done := reconcileStop, reconcileDone
+	reconcileStop, reconcileDone = nil, nil
+	reconcileMu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Reconcile checks the watches in the order of their names and repairs the
+// missing rules, returning the drifts found
+func Reconcile() []Drift {
+	reconcileMu.Lock()
+	names := make([]string, 0, len(watches))
+	for name := range watches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reconcileMu.Unlock()
+
+	var drifts []Drift
+	for _, name := range names {
+		d, ok := reconcileWatch(name)
+		if !ok {
+			continue
+		}
+		reconcileMu.Lock()
+		callbacks := make([]func(Drift), 0, len(onDrift))
+		for _, cb := range onDrift {
+			callbacks = append(callbacks, cb.fn)
+		}
+		reconcileMu.Unlock()
+		for _, cb := range callbacks {
+			cb(d)
+		}
+		drifts = append(drifts, d)
+	}
+	return drifts
+}
+
+// reconcileWatch checks the watch of the name, when it is still watched,
+// and repairs its missing rules, returning whether any was missing
+func reconcileWatch(name string) (Drift, bool) {
+	repairMu.Lock()
+	defer repairMu.Unlock()
+	reconcileMu.Lock()
+	w, ok := watches[name]
+	reconcileMu.Unlock()
+	if !ok {
+		return Drift{}, false
+	}
+
+	var missing []Rule
+	for _, r := range w.checks {
+		if !ruleExists(r) {
+			missing = append(missing, r)
+		}
+	}
+	if len(missing) == 0 {
+		return Drift{}, false
+	}
+	d := Drift{Name: name, Missing: missing}
+	if w.repair != nil {
+		d.Err = w.repair(missing)
+	} else {
+		d.Err = repairRules(missing)
+	}
+	if d.Err != nil {
+		logrus.Warnf("Failed to repair the %d missing iptables rules of %s: %v", len(missing), d.Name, d.Err)
+	} else {
+		logrus.Infof("Repaired the %d missing iptables rules of %s", len(missing), d.Name)
+	}
+	return d, true
+}
+
+// repairRules creates the missing chains and inserts the missing rules
+func repairRules(missing []Rule) error {
+	for _, r := range missing {
+		if len(r.Args) > 0 {
+			continue
+		}
+		if err := RawCombinedOutput("-t", string(r.Table), "-N", r.Chain); err != nil {
+			return err
+		}
+	}
+	for _, r := range missing {
+		if len(r.Args) == 0 {
+			continue
+		}
+		if err := RawCombinedOutput(append([]string{"-t", string(r.Table), string(Insert), r.Chain}, r.Args...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package iptables
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReconcile(t *testing.T) {
+	present := map[string]bool{}
+	defer func(f func(Rule) bool) { ruleExists = f }(ruleExists)
+	ruleExists = func(r Rule) bool { return present[r.String()] }
+
+	chain := Rule{Table: Filter, Chain: "DOCKER-TEST"}
+	jump := Rule{Table: Filter, Chain: "FORWARD", Args: []string{"-j", "DOCKER-TEST"}}
+	present[chain.String()] = true
+	present[jump.String()] = true
+
+	var repaired [][]Rule
+	Watch("test", []Rule{chain, {Chain: "FORWARD", Args: []string{"-j", "DOCKER-TEST"}}}, func(missing []Rule) error {
+		repaired = append(repaired, missing)
+		for _, r := range missing {
+			present[r.String()] = true
+		}
+		return nil
+	})
+	defer Unwatch("test")
+	Watch("test-failing", []Rule{{Table: Nat, Chain: "DOCKER-TEST"}}, func([]Rule) error {
+		return errors.New("failed")
+	})
+
+	var drifts []Drift
+	remove := OnDrift(func(d Drift) { drifts = append(drifts, d) })
+	defer remove()
+
+	// The failing watch drifts until it is removed
+	if d := Reconcile(); len(d) != 1 || d[0].Name != "test-failing" || d[0].Err == nil {
+		t.Fatalf("Expected the failing watch to drift, got %v", d)
+	}
+	Unwatch("test-failing")
+	drifts = nil
+
+	if d := Reconcile(); len(d) != 0 || len(repaired) != 0 {
+		t.Fatalf("Expected no drift, got %v", d)
+	}
+
+	delete(present, jump.String())
+	d := Reconcile()
+	expected := []Drift{{Name: "test", Missing: []Rule{jump}}}
+	if !reflect.DeepEqual(d, expected) || !reflect.DeepEqual(drifts, expected) {
+		t.Fatalf("Expected the drift %v, got %v and %v", expected, d, drifts)
+	}
+	if !reflect.DeepEqual(repaired, [][]Rule{{jump}}) {
+		t.Fatalf("Expected the missing jump to be repaired, got %v", repaired)
+	}
+	if d := Reconcile(); len(d) != 0 {
+		t.Fatalf("Expected the drift to be corrected, got %v", d)
+	}
+
+	// The removed callback is not called anymore
+	remove()
+	drifts = nil
+	delete(present, jump.String())
+	if d := Reconcile(); len(d) != 1 || len(drifts) != 0 {
+		t.Fatalf("Expected the drift without the removed callback, got %v and %v", d, drifts)
+	}
+}
+
+func TestUnwatchWaitsForRepair(t *testing.T) {
+	defer func(f func(Rule) bool) { ruleExists = f }(ruleExists)
+	ruleExists = func(Rule) bool { return false }
+	started, release := make(chan struct{}), make(chan struct{})
+	Watch("test-unwatch", []Rule{{Chain: "DOCKER-TEST"}}, func([]Rule) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	reconciled := make(chan []Drift)
+	go func() { reconciled <- Reconcile() }()
+	<-started
+	unwatched := make(chan struct{})
+	go func() {
+		Unwatch("test-unwatch")
+		close(unwatched)
+	}()
+	select {
+	case <-unwatched:
+		t.Fatal("Expected Unwatch to wait for the running repair")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-unwatched
+	if d := <-reconciled; len(d) != 1 {
+		t.Fatalf("Expected the drift of the repaired watch, got %v", d)
+	}
+	if d := Reconcile(); len(d) != 0 {
+		t.Fatalf("Expected the removed watch not to be repaired, got %v", d)
+	}
+}
+
+func TestStartReconciler(t *testing.T) {
+	defer func(f func(Rule) bool) { ruleExists = f }(ruleExists)
+	repaired := make(chan struct{}, 1)
+	ruleExists = func(Rule) bool { return false }
+	Watch("test-loop", []Rule{{Chain: "DOCKER-TEST"}}, func([]Rule) error {
+		select {
+		case repaired <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	defer Unwatch("test-loop")
+
+	StartReconciler(10 * time.Millisecond)
+	defer StopReconciler()
+	select {
+	case <-repaired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the reconciler to repair the missing chain")
+	}
+}
+
+func TestJumpRules(t *testing.T) {
+	c := &ChainInfo{Name: "DOCKER", Table: Filter}
+	rules := c.JumpRules("br0", "", false)
+	if len(rules) != 2 || rules[0].String() != "-t filter FORWARD -o br0 -j DOCKER" {
+		t.Fatalf("Unexpected jump rules %v", rules)
+	}
+	c = &ChainInfo{Name: "DOCKER", Table: Nat}
+	rules = c.JumpRules("br0", "", false)
+	if len(rules) != 2 || rules[1].String() != "-t nat OUTPUT -m addrtype --dst-type LOCAL -j DOCKER ! --dst 127.0.0.0/8" {
+		t.Fatalf("Unexpected jump rules %v", rules)
+	}
+}
@@ -0,0 +1,136 @@
+package iptables
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/internal/xtables"
+)
+
+func TestRestoreInput(t *testing.T) {
+	c := &ChainInfo{Name: "DOCKER", Table: Nat}
+	rules := c.ForwardRules(net.IPv4zero, 8080, "tcp", "172.17.0.2", 80, "docker0")
+	if len(rules) != 3 {
+		t.Fatalf("expected 3 rules, got %v", rules)
+	}
+
+	tables, byTable := xtables.GroupRules(xtablesRules(rules))
+	if len(tables) != 2 || tables[0] != string(Nat) || tables[1] != string(Filter) {
+		t.Fatalf("unexpected tables %v", tables)
+	}
+	if len(byTable[string(Nat)]) != 2 || len(byTable[string(Filter)]) != 1 {
+		t.Fatalf("unexpected rules per table %v", byTable)
+	}
+
+	expected := "*nat\n" +
+		"-A DOCKER -p tcp -d 0/0 --dport 8080 -j DNAT --to-destination 172.17.0.2:80 ! -i docker0\n" +
+		"-A POSTROUTING -p tcp -s 172.17.0.2 -d 172.17.0.2 --dport 80 -j MASQUERADE\n" +
+		"COMMIT\n"
+	if input := string(xtables.RestoreInput(string(Nat), string(Append), byTable[string(Nat)])); input != expected {
+		t.Fatalf("expected the input\n%s\ngot\n%s", expected, input)
+	}
+}
+
+func TestForwardRangeRules(t *testing.T) {
+	c := &ChainInfo{Name: "DOCKER", Table: Nat}
+	for _, tc := range []struct {
+		port, portEnd, destPort int
+		toDestination           string
+		destDport               string
+	}{
+		{8080, 8080, 80, "172.17.0.2:80", "80"},
+		{10000, 10009, 10000, "172.17.0.2", "10000:10009"},
+		{20000, 20009, 10000, "172.17.0.2:10000-10009/20000", "10000:10009"},
+	} {
+		rules := c.ForwardRangeRules(net.IPv4zero, tc.port, tc.portEnd, "udp", "172.17.0.2", tc.destPort, "docker0")
+		dnat := rules[0].Args
+		if dnat[5] != portSpec(tc.port, tc.portEnd) || dnat[9] != tc.toDestination {
+			t.Fatalf("unexpected DNAT rule %v", dnat)
+		}
+		if accept := rules[1].Args; accept[10] != tc.destDport {
+			t.Fatalf("unexpected ACCEPT rule %v", accept)
+		}
+	}
+}
+
+func TestHairpinRules(t *testing.T) {
+	c := &ChainInfo{Name: "DOCKER", Table: Nat}
+	rules := c.HairpinRules(net.IPv4zero, 8080, 8080, "tcp", "172.17.0.2", 80, "docker0")
+	if len(rules) != 4 {
+		t.Fatalf("expected 4 rules, got %v", rules)
+	}
+	if dnat := rules[0]; dnat.Chain != "DOCKER" || dnat.Args[0] != "-i" || dnat.Args[len(dnat.Args)-1] != "172.17.0.2:80" {
+		t.Fatalf("unexpected hairpin DNAT rule %v", dnat)
+	}
+	mark := HairpinMark + "/" + HairpinMark
+	if rules[1].Args[len(rules[1].Args)-1] != mark {
+		t.Fatalf("unexpected hairpin MARK rule %v", rules[1])
+	}
+	if masq := rules[3]; masq.Chain != "POSTROUTING" || masq.Args[11] != mark || masq.Args[13] != "MASQUERADE" {
+		t.Fatalf("unexpected hairpin MASQUERADE rule %v", masq)
+	}
+}
+
+func TestRateLimitRules(t *testing.T) {
+	c := &ChainInfo{Name: "DOCKER", Table: Nat}
+	rules := c.RateLimitRules("tcp", "172.17.0.2", 80, 81, 10, 0, "docker0")
+	if len(rules) != 1 {
+		t.Fatalf("expected 1 rule, got %v", rules)
+	}
+	r := rules[0]
+	if r.Table != Filter || r.Chain != "DOCKER" || r.Args[7] != "80:81" || r.Args[len(r.Args)-1] != "DROP" {
+		t.Fatalf("unexpected rate limit rule %v", r)
+	}
+	if name := r.Args[17]; len(name) > 15 {
+		t.Fatalf("expected a hashlimit name of 15 characters at most, got %s", name)
+	}
+	if burst := c.RateLimitRules("tcp", "172.17.0.2", 80, 81, 10, 20, "docker0")[0]; burst.Args[19] != "20" || burst.Args[17] != r.Args[17] {
+		t.Fatalf("unexpected rate limit rule %v", burst)
+	}
+}
+
+func TestTxnRestoreInput(t *testing.T) {
+	c := &ChainInfo{Name: "DOCKER", Table: Nat}
+	txn := Begin().
+		Add(Append, c.ForwardRules(net.IPv4zero, 8080, "tcp", "172.17.0.2", 80, "docker0")...).
+		Add(Insert, Rule{Table: Nat, Chain: "DOCKER", Args: []string{"-j", "RETURN"}})
+	if txn.Len() != 4 {
+		t.Fatalf("expected 4 rules, got %d", txn.Len())
+	}
+
+	tables, input, lastLines := txn.restoreInput()
+	expected := "*nat\n" +
+		"-A DOCKER -p tcp -d 0/0 --dport 8080 -j DNAT --to-destination 172.17.0.2:80 ! -i docker0\n" +
+		"-A POSTROUTING -p tcp -s 172.17.0.2 -d 172.17.0.2 --dport 80 -j MASQUERADE\n" +
+		"-I DOCKER -j RETURN\n" +
+		"COMMIT\n" +
+		"*filter\n" +
+		"-A DOCKER ! -i docker0 -o docker0 -p tcp -d 172.17.0.2 --dport 80 -j ACCEPT\n" +
+		"COMMIT\n"
+	if string(input) != expected {
+		t.Fatalf("expected the input\n%s\ngot\n%s", expected, input)
+	}
+	if len(tables) != 2 || tables[0] != Nat || tables[1] != Filter {
+		t.Fatalf("unexpected tables %v", tables)
+	}
+	if len(lastLines) != 2 || lastLines[0] != 5 || lastLines[1] != 8 {
+		t.Fatalf("unexpected COMMIT lines %v", lastLines)
+	}
+
+	for _, tc := range []struct {
+		err       string
+		committed []Table
+		ok        bool
+	}{
+		{"iptables-restore: line 3 failed", nil, true},
+		{"iptables-restore: line 7 failed", []Table{Nat}, true},
+		{"iptables-restore: unknown failure", nil, false},
+	} {
+		committed, ok := committedTables(tables, lastLines, errors.New(tc.err))
+		if ok != tc.ok || !reflect.DeepEqual(committed, tc.committed) {
+			t.Fatalf("expected the committed tables %v for %q, got %v", tc.committed, tc.err, committed)
+		}
+	}
+}
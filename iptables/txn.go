@@ -0,0 +1,163 @@
+package iptables
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/docker/libnetwork/faults"
+	"github.com/docker/libnetwork/internal/xtables"
+	"github.com/sirupsen/logrus"
+)
+
+// restoreFailedLine matches the line of the input iptables-restore failed on
+var restoreFailedLine = regexp.MustCompile(`line (\d+) failed`)
+
+// txnOp is an action on a rule of a transaction
+type txnOp struct {
+	action Action
+	rule   Rule
+}
+
+// Txn is a transaction programming rules of several chains and tables with
+// a single iptables-restore invocation
+type Txn struct {
+	ops []txnOp
+}
+
+// Begin starts a transaction, whose rules are then added with Add and
+// programmed with Commit
+func Begin() *Txn {
+	return &Txn{}
+}
+
+// Add adds to the transaction the action on the rules, in order
+func (t *Txn) Add(action Action, rules ...Rule) *Txn {
+	for _, r := range rules {
+		t.ops = append(t.ops, txnOp{action: action, rule: r})
+	}
+	return t
+}
+
+// Len returns the number of the rules of the transaction
+func (t *Txn) Len() int {
+	return len(t.ops)
+}
+
+// Commit programs the rules of the transaction with a single
+// iptables-restore --noflush invocation, committing the tables in turn: all
+// the rules of a table are programmed or none are, and the tables already
+// committed are reverted when a table fails. Without iptables-restore, or
+// with firewalld running, the rules are programmed one at a time and the
+// programmed ones reverted on failure. As ProgramRules, the rules are
+// programmed without checking whether they are present.
+func (t *Txn) Commit() error {
+	if len(t.ops) == 0 {
+		return nil
+	}
+	if err := faults.Check(faults.Iptables); err != nil {
+		return err
+	}
+	if err := initCheck(); err != nil {
+		return err
+	}
+	if firewalldRunning || restorePath == "" {
+		return t.programOps()
+	}
+
+	tables, input, lastLines := t.restoreInput()
+	err := restoreTxn(input, len(t.ops))
+	if err == nil {
+		return nil
+	}
+
+	committed, ok := committedTables(tables, lastLines, err)
+	if !ok {
+		logrus.Warnf("Failed to find the failed table of the transaction, its committed tables are not reverted: %v", err)
+		return err
+	}
+	if len(committed) > 0 {
+		rt := &Txn{}
+		for i := len(t.ops) - 1; i >= 0; i-- {
+			op := t.ops[i]
+			for _, table := range committed {
+				if op.rule.Table == table {
+					rt.Add(Action(xtables.Revert(string(op.action))), op.rule)
+				}
+			}
+		}
+		_, rinput, _ := rt.restoreInput()
+		if rerr := restoreTxn(rinput, len(rt.ops)); rerr != nil {
+			logrus.Warnf("Failed to revert the committed tables %v of the transaction: %v", committed, rerr)
+		}
+	}
+	return err
+}
+
+// committedTables returns the tables committed by the iptables-restore
+// invocation failed with err, the ones before the table of the failed line,
+// and whether the failed line was found
+func committedTables(tables []Table, lastLines []int, err error) ([]Table, bool) {
+	m := restoreFailedLine.FindStringSubmatch(err.Error())
+	if m == nil {
+		return nil, false
+	}
+	line, _ := strconv.Atoi(m[1])
+	var committed []Table
+	for i, table := range tables {
+		if line <= lastLines[i] {
+			break
+		}
+		committed = append(committed, table)
+	}
+	return committed, true
+}
+
+// programOps programs the rules of the transaction one at a time
+func (t *Txn) programOps() error {
+	for i, op := range t.ops {
+		if err := ProgramRule(op.rule.Table, op.rule.Chain, op.action, op.rule.Args); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				r := t.ops[j].rule
+				if rerr := ProgramRule(r.Table, r.Chain, Action(xtables.Revert(string(t.ops[j].action))), r.Args); rerr != nil {
+					logrus.Warnf("Failed to revert the rule %s: %v", r, rerr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreInput returns the tables of the transaction in the order they
+// first appear, the iptables-restore input programming them and the number
+// of the COMMIT line of each table
+func (t *Txn) restoreInput() ([]Table, []byte, []int) {
+	var tables []Table
+	byTable := map[Table][]txnOp{}
+	for _, op := range t.ops {
+		if _, ok := byTable[op.rule.Table]; !ok {
+			tables = append(tables, op.rule.Table)
+		}
+		byTable[op.rule.Table] = append(byTable[op.rule.Table], op)
+	}
+
+	var b bytes.Buffer
+	lastLines := make([]int, 0, len(tables))
+	line := 0
+	for _, table := range tables {
+		fmt.Fprintf(&b, "*%s\n", table)
+		for _, op := range byTable[table] {
+			b.WriteString(xtables.RestoreLine(string(op.action), op.rule.Chain, op.rule.Args))
+		}
+		b.WriteString("COMMIT\n")
+		line += len(byTable[table]) + 2
+		lastLines = append(lastLines, line)
+	}
+	return tables, b.Bytes(), lastLines
+}
+
+func restoreTxn(input []byte, count int) error {
+	return runRestore(input, fmt.Sprintf("transaction of %d rules", count))
+}
@@ -88,6 +88,31 @@ const (
 	ipvsDestAttrAddressFamily
 )
 
+// Attributes used to describe a sync daemon. Used inside nested attribute
+// ipvsCmdAttrDaemon.
+const (
+	ipvsDaemonAttrUnspec int = iota
+	ipvsDaemonAttrState
+	ipvsDaemonAttrMcastIfn
+	ipvsDaemonAttrSyncID
+	ipvsDaemonAttrSyncMaxLen
+	ipvsDaemonAttrMcastGroup
+	ipvsDaemonAttrMcastGroup6
+	ipvsDaemonAttrMcastPort
+	ipvsDaemonAttrMcastTTL
+)
+
+// Sync daemon states
+const (
+	// SyncMaster is the state of the daemon multicasting the
+	// connections of this node.
+	SyncMaster = 0x1
+
+	// SyncBackup is the state of the daemon receiving the
+	// connections multicast by the other nodes.
+	SyncBackup = 0x2
+)
+
 // IPVS Svc Statistics constancs
 
 const (
@@ -131,6 +156,10 @@ const (
 	// real servers.
 	RoundRobin = "rr"
 
+	// WeightedRoundRobin distributes jobs amongst the available
+	// real servers in proportion to their weight.
+	WeightedRoundRobin = "wrr"
+
 	// LeastConnection assigns more jobs to real servers with
 	// fewer active jobs.
 	LeastConnection = "lc"
@@ -144,6 +173,23 @@ const (
 	// a statically assigned hash table by their source IP
 	// addresses.
 	SourceHashing = "sh"
+
+	// MaglevHashing assigns jobs to servers through looking up a
+	// consistent hash table built with the Maglev algorithm.
+	MaglevHashing = "mh"
+)
+
+// Service flags
+const (
+	// SvcFlagPersistent makes the connections from a client go to the
+	// same real server until the persistence timeout expires.
+	SvcFlagPersistent = 0x0001
+
+	// SvcFlagHashed is set by the kernel when the service is hashed.
+	SvcFlagHashed = 0x0002
+
+	// SvcFlagOnePacket schedules every UDP datagram independently.
+	SvcFlagOnePacket = 0x0004
 )
 
 const (
@@ -75,6 +75,14 @@ type Config struct {
 	TimeoutUDP    time.Duration
 }
 
+// SyncDaemon defines an IPVS connection synchronization daemon. The
+// daemons exchange the connection states over multicast on McastInterface.
+type SyncDaemon struct {
+	State          uint32
+	McastInterface string
+	SyncID         uint32
+}
+
 // Handle provides a namespace specific ipvs handle to program ipvs
 // rules.
 type Handle struct {
@@ -205,3 +213,19 @@ func (i *Handle) GetConfig() (*Config, error) {
 func (i *Handle) SetConfig(c *Config) error {
 	return i.doSetConfigCmd(c)
 }
+
+// NewSyncDaemon starts a connection synchronization daemon
+func (i *Handle) NewSyncDaemon(d *SyncDaemon) error {
+	return i.doSyncDaemonCmd(d, ipvsCmdNewDaemon)
+}
+
+// DelSyncDaemon stops the connection synchronization daemon in the state
+// of d
+func (i *Handle) DelSyncDaemon(d *SyncDaemon) error {
+	return i.doSyncDaemonCmd(d, ipvsCmdDelDaemon)
+}
+
+// GetSyncDaemons returns the running connection synchronization daemons
+func (i *Handle) GetSyncDaemons() ([]*SyncDaemon, error) {
+	return i.doGetSyncDaemonsCmd()
+}
@@ -373,3 +373,35 @@ func TestTimeouts(t *testing.T) {
 	assert.NilError(t, err)
 	assert.DeepEqual(t, *c3, Config{77 * time.Second, 66 * time.Second, 77 * time.Second})
 }
+
+func TestSyncDaemon(t *testing.T) {
+	if testutils.RunningOnCircleCI() {
+		t.Skip("Skipping as not supported on CIRCLE CI kernel")
+	}
+	defer testutils.SetupTestOSContext(t)()
+
+	i, err := New("")
+	assert.NilError(t, err)
+
+	for _, state := range []uint32{SyncMaster, SyncBackup} {
+		err = i.NewSyncDaemon(&SyncDaemon{State: state, McastInterface: "lo", SyncID: 7})
+		assert.NilError(t, err)
+	}
+
+	daemons, err := i.GetSyncDaemons()
+	assert.NilError(t, err)
+	assert.Equal(t, len(daemons), 2)
+	for _, d := range daemons {
+		assert.Equal(t, d.McastInterface, "lo")
+		assert.Equal(t, d.SyncID, uint32(7))
+	}
+
+	for _, state := range []uint32{SyncMaster, SyncBackup} {
+		err = i.DelSyncDaemon(&SyncDaemon{State: state})
+		assert.NilError(t, err)
+	}
+
+	daemons, err = i.GetSyncDaemons()
+	assert.NilError(t, err)
+	assert.Equal(t, len(daemons), 0)
+}
@@ -558,6 +558,85 @@ func (i *Handle) doSetConfigCmd(c *Config) error {
 	return err
 }
 
+func fillSyncDaemon(d *SyncDaemon) nl.NetlinkRequestData {
+	cmdAttr := nl.NewRtAttr(ipvsCmdAttrDaemon, nil)
+
+	nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrState, nl.Uint32Attr(d.State))
+	if d.McastInterface != "" {
+		nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrMcastIfn, nl.ZeroTerminated(d.McastInterface))
+	}
+	nl.NewRtAttrChild(cmdAttr, ipvsDaemonAttrSyncID, nl.Uint32Attr(d.SyncID))
+
+	return cmdAttr
+}
+
+// doSyncDaemonCmd a wrapper function to be used by NewSyncDaemon and DelSyncDaemon
+func (i *Handle) doSyncDaemonCmd(d *SyncDaemon, cmd uint8) error {
+	req := newIPVSRequest(cmd)
+	req.Seq = atomic.AddUint32(&i.seq, 1)
+	req.AddData(fillSyncDaemon(d))
+
+	_, err := execute(i.sock, req, 0)
+
+	return err
+}
+
+// parseSyncDaemon given a ipvs netlink response this function will respond with a valid sync daemon entry, an error otherwise
+func (i *Handle) parseSyncDaemon(msg []byte) (*SyncDaemon, error) {
+	var d SyncDaemon
+
+	//Remove General header for this message and parse the NetLink message
+	hdr := deserializeGenlMsg(msg)
+	NetLinkAttrs, err := nl.ParseRouteAttr(msg[hdr.Len():])
+	if err != nil {
+		return nil, err
+	}
+	if len(NetLinkAttrs) == 0 {
+		return nil, fmt.Errorf("error no valid netlink message found while parsing sync daemon record")
+	}
+
+	ipvsAttrs, err := nl.ParseRouteAttr(NetLinkAttrs[0].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attr := range ipvsAttrs {
+		attrType := int(attr.Attr.Type)
+		switch attrType {
+		case ipvsDaemonAttrState:
+			d.State = native.Uint32(attr.Value)
+		case ipvsDaemonAttrMcastIfn:
+			d.McastInterface = nl.BytesToString(attr.Value)
+		case ipvsDaemonAttrSyncID:
+			d.SyncID = native.Uint32(attr.Value)
+		}
+	}
+
+	return &d, nil
+}
+
+// doGetSyncDaemonsCmd a wrapper function to be used by GetSyncDaemons
+func (i *Handle) doGetSyncDaemonsCmd() ([]*SyncDaemon, error) {
+	req := newIPVSRequest(ipvsCmdGetDaemon)
+	req.Seq = atomic.AddUint32(&i.seq, 1)
+	req.Flags |= syscall.NLM_F_DUMP
+
+	msgs, err := execute(i.sock, req, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*SyncDaemon
+	for _, msg := range msgs {
+		d, err := i.parseSyncDaemon(msg)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
 // IPVS related netlink message format explained
 
 /* EACH NETLINK MSG is of the below format, this is what we will receive from execute() api.
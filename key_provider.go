@@ -0,0 +1,80 @@
+package libnetwork
+
+import (
+	"fmt"
+
+	"github.com/docker/libnetwork/keyprovider"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+func (c *controller) keyProvider() keyprovider.Provider {
+	if c.cfg == nil {
+		return nil
+	}
+	return c.cfg.Daemon.KeyProvider
+}
+
+// startKeyProvider loads the keys of the configured key provider and follows
+// their rotations
+func (c *controller) startKeyProvider() error {
+	p := c.keyProvider()
+	if p == nil {
+		return nil
+	}
+	if err := c.loadProviderKeys(); err != nil {
+		return fmt.Errorf("failed to load the encryption keys of the key provider: %v", err)
+	}
+
+	stop := make(chan struct{})
+	c.Lock()
+	c.keyProviderStop = stop
+	c.Unlock()
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-p.Updates():
+				if err := c.loadProviderKeys(); err != nil {
+					logrus.Errorf("Failed to apply the encryption keys rotated by the key provider: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *controller) stopKeyProvider() {
+	c.Lock()
+	stop := c.keyProviderStop
+	c.keyProviderStop = nil
+	c.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+}
+
+// loadProviderKeys sets the current keys of the key provider as the keyring
+// of the gossip and of the data path
+func (c *controller) loadProviderKeys() error {
+	p := c.keyProvider()
+	if p == nil {
+		return fmt.Errorf("no key provider configured")
+	}
+	keys, err := p.Keys()
+	if err != nil {
+		return err
+	}
+	return c.setKeys(keys)
+}
+
+// keysRotated runs the key rotation hooks with the new keyring
+func (c *controller) keysRotated(keys []*types.EncryptionKey) {
+	if c.cfg == nil {
+		return
+	}
+	for _, hook := range c.cfg.Daemon.KeyRotationHooks {
+		hook(keys)
+	}
+}
@@ -0,0 +1,199 @@
+// Package keyprovider supplies the encryption keys of the gossip and of the
+// overlay data path from a source other than the swarm manager
+package keyprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultInterval = 10 * time.Second
+
+// Provider is a source of encryption keys
+type Provider interface {
+	// Keys returns the current keys
+	Keys() ([]*types.EncryptionKey, error)
+	// Updates returns a channel receiving a value every time the keys
+	// were rotated
+	Updates() <-chan struct{}
+	// Close releases the resources of the provider
+	Close() error
+}
+
+// Fetcher returns the keys from an external key management system
+type Fetcher func() ([]*types.EncryptionKey, error)
+
+// Unwrapper decrypts the keys stored encrypted by a key management system or
+// an HSM, for the keys of the passed subsystem
+type Unwrapper interface {
+	Unwrap(subsystem string, wrapped []byte) ([]byte, error)
+}
+
+// pollingProvider fetches the keys at a regular interval and signals the
+// changes
+type pollingProvider struct {
+	sync.Mutex
+	fetch   Fetcher
+	keys    []*types.EncryptionKey
+	updates chan struct{}
+	stopCh  chan struct{}
+	once    sync.Once
+}
+
+// NewPollingProvider returns a provider fetching the keys every interval.
+// The first fetch has to succeed. Later failures keep the previous keys.
+func NewPollingProvider(fetch Fetcher, interval time.Duration) (Provider, error) {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	keys, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	p := &pollingProvider{
+		fetch:   fetch,
+		keys:    keys,
+		updates: make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+	}
+	go p.poll(interval)
+	return p, nil
+}
+
+func (p *pollingProvider) poll(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case <-t.C:
+			p.refresh()
+		}
+	}
+}
+
+func (p *pollingProvider) refresh() {
+	keys, err := p.fetch()
+	if err != nil {
+		logrus.Warnf("Failed to fetch the encryption keys, keeping the current ones: %v", err)
+		return
+	}
+	p.Lock()
+	changed := !sameKeys(p.keys, keys)
+	if changed {
+		p.keys = keys
+	}
+	p.Unlock()
+	if !changed {
+		return
+	}
+	logrus.Infof("Encryption keys rotated by the key provider")
+	select {
+	case p.updates <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pollingProvider) Keys() ([]*types.EncryptionKey, error) {
+	p.Lock()
+	defer p.Unlock()
+	keys := make([]*types.EncryptionKey, 0, len(p.keys))
+	for _, k := range p.keys {
+		c := *k
+		keys = append(keys, &c)
+	}
+	return keys, nil
+}
+
+func (p *pollingProvider) Updates() <-chan struct{} {
+	return p.updates
+}
+
+func (p *pollingProvider) Close() error {
+	p.once.Do(func() { close(p.stopCh) })
+	return nil
+}
+
+func sameKeys(a, b []*types.EncryptionKey) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Subsystem != b[i].Subsystem || a[i].Algorithm != b[i].Algorithm ||
+			a[i].LamportTime != b[i].LamportTime || !bytes.Equal(a[i].Key, b[i].Key) {
+			return false
+		}
+	}
+	return true
+}
+
+// FileConfig configures a provider reading the keys from a file
+type FileConfig struct {
+	// Path of the JSON file listing the keys
+	Path string
+	// Interval the file is read at to detect the rotations. Defaults to 10s
+	Interval time.Duration
+	// Unwrapper decrypts the keys of the file. Keys are used as is when nil
+	Unwrapper Unwrapper
+}
+
+// fileKey is the format of a key in the file
+type fileKey struct {
+	Subsystem   string `json:"subsystem"`
+	Algorithm   int32  `json:"algorithm"`
+	Key         []byte `json:"key"`
+	LamportTime uint64 `json:"lamport_time"`
+}
+
+// NewFileProvider returns a provider reading the keys from a JSON file of the
+// form {"keys": [{"subsystem": "networking:gossip", "algorithm": 0,
+// "key": "<base64>", "lamport_time": 1}, ...]}. Rewriting the file rotates
+// the keys.
+func NewFileProvider(cfg FileConfig) (Provider, error) {
+	if cfg.Path == "" {
+		return nil, types.BadRequestErrorf("missing key file path")
+	}
+	return NewPollingProvider(func() ([]*types.EncryptionKey, error) {
+		return readKeyFile(cfg.Path, cfg.Unwrapper)
+	}, cfg.Interval)
+}
+
+func readKeyFile(path string, u Unwrapper) ([]*types.EncryptionKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f struct {
+		Keys []fileKey `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %v", path, err)
+	}
+	keys := make([]*types.EncryptionKey, 0, len(f.Keys))
+	for _, fk := range f.Keys {
+		if fk.Subsystem == "" || len(fk.Key) == 0 {
+			return nil, fmt.Errorf("invalid key file %s: missing subsystem or key", path)
+		}
+		key := fk.Key
+		if u != nil {
+			if key, err = u.Unwrap(fk.Subsystem, fk.Key); err != nil {
+				return nil, fmt.Errorf("failed to unwrap the %s key of %s: %v", fk.Subsystem, path, err)
+			}
+		}
+		keys = append(keys, &types.EncryptionKey{
+			Subsystem:   fk.Subsystem,
+			Algorithm:   fk.Algorithm,
+			Key:         key,
+			LamportTime: fk.LamportTime,
+		})
+	}
+	return keys, nil
+}
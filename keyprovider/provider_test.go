@@ -0,0 +1,74 @@
+package keyprovider
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type xorUnwrapper byte
+
+func (x xorUnwrapper) Unwrap(subsystem string, wrapped []byte) ([]byte, error) {
+	key := make([]byte, len(wrapped))
+	for i, b := range wrapped {
+		key[i] = b ^ byte(x)
+	}
+	return key, nil
+}
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyprovider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+
+	// "AQID" is the base64 encoding of 1, 2, 3
+	if err := ioutil.WriteFile(path, []byte(`{"keys": [{"subsystem": "networking:gossip", "key": "AQID", "lamport_time": 1}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewFileProvider(FileConfig{Path: path, Interval: 10 * time.Millisecond, Unwrapper: xorUnwrapper(0xff)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	keys, err := p.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Subsystem != "networking:gossip" || keys[0].LamportTime != 1 || !bytes.Equal(keys[0].Key, []byte{0xfe, 0xfd, 0xfc}) {
+		t.Fatalf("Unexpected keys %+v", keys)
+	}
+
+	// an invalid file keeps the current keys
+	if err := ioutil.WriteFile(path, []byte(`{"keys": [{"key": "AQID"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-p.Updates():
+		t.Fatal("Unexpected rotation after an invalid update of the key file")
+	default:
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"keys": [{"subsystem": "networking:gossip", "key": "AQID", "lamport_time": 2}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-p.Updates():
+	case <-time.After(time.Second):
+		t.Fatal("Key rotation not detected")
+	}
+	if keys, _ = p.Keys(); len(keys) != 1 || keys[0].LamportTime != 2 {
+		t.Fatalf("Unexpected keys after the rotation %+v", keys)
+	}
+
+	if _, err := NewFileProvider(FileConfig{Path: filepath.Join(dir, "missing.json")}); err == nil {
+		t.Fatal("Expected an error for a missing key file")
+	}
+}
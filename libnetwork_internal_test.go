@@ -1,19 +1,28 @@
 package libnetwork
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"reflect"
 	"testing"
 	"time"
 
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/discoverapi"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/internal/setmatrix"
 	"github.com/docker/libnetwork/ipamapi"
+	builtinIpam "github.com/docker/libnetwork/ipams/builtin"
+	"github.com/docker/libnetwork/ipamutils"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
 )
@@ -119,6 +128,10 @@ func TestNetworkMarshalling(t *testing.T) {
 			"color":        "blue",
 			"superimposed": "",
 		},
+		dnsConfig: &DNSConfig{
+			Servers: []string{"10.1.1.53", "2001:db8::53"},
+			Options: []string{"ndots:2", "rotate"},
+		},
 		created: time.Now(),
 	}
 
@@ -141,7 +154,8 @@ func TestNetworkMarshalling(t *testing.T) {
 		!compareStringMaps(n.ipamOptions, nn.ipamOptions) ||
 		!compareStringMaps(n.labels, nn.labels) ||
 		!n.created.Equal(nn.created) ||
-		n.configOnly != nn.configOnly || n.configFrom != nn.configFrom {
+		n.configOnly != nn.configOnly || n.configFrom != nn.configFrom ||
+		!reflect.DeepEqual(n.dnsConfig, nn.dnsConfig) {
 		t.Fatalf("JSON marsh/unmarsh failed."+
 			"\nOriginal:\n%#v\nDecoded:\n%#v"+
 			"\nOriginal ipamV4Conf: %#v\n\nDecoded ipamV4Conf: %#v"+
@@ -155,6 +169,151 @@ func TestNetworkMarshalling(t *testing.T) {
 	}
 }
 
+func TestDNSConfigValidate(t *testing.T) {
+	valid := []*DNSConfig{
+		{},
+		{Servers: []string{"10.1.1.53", "2001:db8::53"}},
+		{Options: []string{"ndots:0", "timeout:2", "attempts:3", "rotate"}},
+		{NAT64Prefix: "64:ff9b::/96"},
+		{NAT64Prefix: "2001:db8:122::/48"},
+		{ForwardRules: []*ForwardRule{{Domain: "*.corp.example", Servers: []string{"10.1.1.53"}}}},
+	}
+	for _, c := range valid {
+		if err := c.Validate(); err != nil {
+			t.Fatalf("unexpected failure validating %v: %v", c, err)
+		}
+	}
+
+	invalid := []*DNSConfig{
+		{Servers: []string{"10.1.1"}},
+		{Options: []string{"ndots"}},
+		{Options: []string{"timeout:-1"}},
+		{Options: []string{"attempts:x"}},
+		{Options: []string{"debug"}},
+		{NAT64Prefix: "10.0.0.0/8"},
+		{NAT64Prefix: "2001:db8::/80"},
+		{NAT64Prefix: "2001:db8:0:0:ff00::/96"},
+		{ForwardRules: []*ForwardRule{{Domain: "corp.example"}}},
+		{MaxUDPResponseSize: 256},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("expected failure validating %v", c)
+		}
+	}
+}
+
+func TestMergeDNSOptions(t *testing.T) {
+	merged := mergeDNSOptions([]string{"ndots:1", "timeout:5", "edns0"}, []string{"ndots:3", "rotate"})
+	expected := []string{"timeout:5", "edns0", "ndots:3", "rotate"}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("expected %v, got %v", expected, merged)
+	}
+}
+
+func TestDNSViewRule(t *testing.T) {
+	r := &DNSViewRule{Name: "*.corp.example.", Addresses: []net.IP{net.ParseIP("10.1.1.10")}}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !r.matches("db.corp.example") || r.matches("corp.example") || r.matches("db.example") {
+		t.Fatal("unexpected match result for wildcard rule")
+	}
+
+	r = &DNSViewRule{Name: "db", Network: "backend"}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !r.matches("db") || r.matches("db.backend") {
+		t.Fatal("unexpected match result for exact rule")
+	}
+
+	for _, r := range []*DNSViewRule{{Name: "db"}, {Name: "*.", Network: "n"}, {Name: "a.*.b", Network: "n"}} {
+		if err := r.Validate(); err == nil {
+			t.Fatalf("expected failure validating %v", r)
+		}
+	}
+}
+
+func TestSelectWeighted(t *testing.T) {
+	ip1, ip2, ip3 := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")
+	ips := []net.IP{ip1, ip2, ip3}
+
+	// only the addresses with the lowest priority are returned
+	res := selectWeighted(ips, []*DNSRecordWeight{{IP: ip1, Priority: 10}})
+	if len(res) != 2 || !res[0].Equal(ip2) || !res[1].Equal(ip3) {
+		t.Fatalf("unexpected addresses selected by priority: %v", res)
+	}
+
+	// a single address is returned among the weighted ones
+	weights := []*DNSRecordWeight{{IP: ip1, Weight: 1}, {IP: ip3, Weight: 3}}
+	picked := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		res = selectWeighted(ips, weights)
+		if len(res) != 1 {
+			t.Fatalf("expected a single weighted address, got %v", res)
+		}
+		picked[res[0].String()]++
+	}
+	if picked[ip2.String()] != 0 || picked[ip3.String()] == 0 {
+		t.Fatalf("unexpected weighted selection: %v", picked)
+	}
+}
+
+func TestFilterUnhealthy(t *testing.T) {
+	ip1, ip2 := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	c := &controller{}
+
+	if !c.updateEndpointHealth("nid", "ep1", []net.IP{ip1}, false) {
+		t.Fatal("expected health state change")
+	}
+	if c.updateEndpointHealth("nid", "ep1", []net.IP{ip1}, false) {
+		t.Fatal("unexpected health state change")
+	}
+
+	res := c.filterUnhealthy("nid", []net.IP{ip1, ip2})
+	if len(res) != 1 || !res[0].Equal(ip2) {
+		t.Fatalf("unexpected healthy addresses: %v", res)
+	}
+	// names without healthy addresses keep resolving
+	if res = c.filterUnhealthy("nid", []net.IP{ip1}); len(res) != 1 {
+		t.Fatalf("unexpected healthy addresses: %v", res)
+	}
+
+	c.updateEndpointHealth("nid", "ep1", nil, true)
+	if res = c.filterUnhealthy("nid", []net.IP{ip1, ip2}); len(res) != 2 {
+		t.Fatalf("unexpected healthy addresses: %v", res)
+	}
+}
+
+func TestEndpointHealthRecovery(t *testing.T) {
+	c := &controller{cfg: &config.Config{}}
+	c.cfg.Daemon.HealthGrace = time.Hour
+
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	if c.deferRecovery("nid", "ep1") {
+		t.Fatal("unexpected recovery of a healthy endpoint")
+	}
+
+	c.updateEndpointHealth("nid", "ep1", []net.IP{net.ParseIP("10.0.0.1")}, false)
+	if !c.deferRecovery("nid", "ep1") || !c.deferRecovery("nid", "ep1") {
+		t.Fatal("expected the recovery to be deferred")
+	}
+	if len(c.healthRecoveries) != 1 {
+		t.Fatalf("unexpected pending recoveries: %v", c.healthRecoveries)
+	}
+	c.cancelRecovery("ep1")
+	if len(c.healthRecoveries) != 0 {
+		t.Fatalf("unexpected pending recoveries: %v", c.healthRecoveries)
+	}
+
+	c.cfg.Daemon.HealthGrace = 0
+	if c.deferRecovery("nid", "ep1") {
+		t.Fatal("unexpected deferred recovery without grace")
+	}
+}
+
 func printIpamConf(list []*IpamConf) string {
 	s := fmt.Sprintf("\n[]*IpamConfig{")
 	for _, i := range list {
@@ -620,6 +779,77 @@ func TestIpamReleaseOnNetDriverFailures(t *testing.T) {
 	}
 }
 
+func TestContextDoneRollsBack(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ipamOpt := NetworkOptionIpam(ipamapi.DefaultIPAM, "", []*IpamConf{{PreferredPool: "10.36.0.0/16", Gateway: "10.36.255.254"}}, nil, nil)
+	if _, err := c.NewNetworkContext(ctx, "bridge", "ctxnet", "", ipamOpt); err != context.Canceled {
+		t.Fatalf("expected the network creation to be canceled, got %v", err)
+	}
+	if _, err := c.NetworkByName("ctxnet"); err == nil {
+		t.Fatal("the canceled network creation left the network")
+	}
+
+	n, err := c.NewNetwork("bridge", "ctxnet", "", ipamOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+
+	if _, err := n.CreateEndpointContext(ctx, "ep1"); err != context.Canceled {
+		t.Fatalf("expected the endpoint creation to be canceled, got %v", err)
+	}
+	if len(n.Endpoints()) != 0 {
+		t.Fatalf("the canceled endpoint creation left endpoints: %v", n.Endpoints())
+	}
+
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete(false)
+
+	sb, err := c.NewSandbox("ctx_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Delete()
+
+	// a join waiting for another join in progress gives up on the deadline
+	sb.(*sandbox).joinLeaveStart(context.Background())
+	tctx, tcancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer tcancel()
+	if err := ep.JoinContext(tctx, sb); err != context.DeadlineExceeded {
+		t.Fatalf("expected the join to time out, got %v", err)
+	}
+	sb.(*sandbox).joinLeaveEnd()
+
+	if err := ep.Join(sb); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.LeaveContext(ctx, sb); err != context.Canceled {
+		t.Fatalf("expected the leave to be canceled, got %v", err)
+	}
+	if err := ep.Leave(sb); err != nil {
+		t.Fatal(err)
+	}
+}
+
 var badDriverName = "bad network driver"
 
 type badDriver struct {
@@ -689,3 +919,333 @@ func (b *badDriver) EventNotify(etype driverapi.EventType, nid, tableName, key s
 func (b *badDriver) DecodeTableEntry(tablename string, key string, value []byte) (string, map[string]string) {
 	return "", nil
 }
+
+type tstKeyProvider struct {
+	keys    []*types.EncryptionKey
+	updates chan struct{}
+}
+
+func (p *tstKeyProvider) Keys() ([]*types.EncryptionKey, error) { return p.keys, nil }
+func (p *tstKeyProvider) Updates() <-chan struct{}               { return p.updates }
+func (p *tstKeyProvider) Close() error                           { return nil }
+
+func TestKeyProvider(t *testing.T) {
+	var keys []*types.EncryptionKey
+	for i := 0; i < keyringSize; i++ {
+		keys = append(keys,
+			&types.EncryptionKey{Subsystem: subsysGossip, Key: []byte{byte(i)}, LamportTime: uint64(i)},
+			&types.EncryptionKey{Subsystem: subsysIPSec, Key: []byte{byte(i)}, LamportTime: uint64(i)})
+	}
+	p := &tstKeyProvider{keys: keys, updates: make(chan struct{})}
+	rotated := make(chan int, 1)
+	c := &controller{cfg: config.ParseConfigOptions(
+		config.OptionKeyProvider(p),
+		config.OptionKeyRotationHook(func(keys []*types.EncryptionKey) { rotated <- len(keys) }),
+	)}
+	if err := c.startKeyProvider(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.stopKeyProvider()
+	if n := <-rotated; n != 2*keyringSize || len(c.keys) != 2*keyringSize {
+		t.Fatalf("unexpected keyring size %d", n)
+	}
+
+	// the keys of the manager are ignored
+	if err := c.SetKeys(keys[:2*keyringSize]); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-rotated:
+		t.Fatal("unexpected key rotation")
+	default:
+	}
+
+	p.keys = append(keys[2:], &types.EncryptionKey{Subsystem: subsysGossip, Key: []byte{9}, LamportTime: 9}, &types.EncryptionKey{Subsystem: subsysIPSec, Key: []byte{9}, LamportTime: 9})
+	p.updates <- struct{}{}
+	select {
+	case <-rotated:
+	case <-time.After(time.Second):
+		t.Fatal("key rotation not applied")
+	}
+	c.Lock()
+	defer c.Unlock()
+	if len(c.keys) != 2*keyringSize || c.keys[len(c.keys)-1].LamportTime != 9 {
+		t.Fatalf("unexpected keyring after the rotation: %v", c.keys)
+	}
+}
+
+func TestReloadConfigurationStoreOnly(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pools := []*ipamutils.NetworkToSplit{{Base: "10.37.0.0/16", Size: 24}}
+	bridgeOption := map[string]interface{}{netlabel.GenericData: options.Generic{"EnableUserlandProxy": true}}
+	c, err := New(append(cfgOptions, config.OptionDefaultAddressPoolConfig(pools), config.OptionDriverConfig("bridge", bridgeOption))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	defer builtinIpam.SetDefaultIPAddressPool(nil)
+	defer ipamutils.ConfigLocalScopeDefaultNetworks(nil)
+
+	tmp, err := ioutil.TempFile("", "libnetwork-global-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	globalStore := func(cfg *config.Config) {
+		cfg.Scopes[datastore.GlobalScope] = &datastore.ScopeCfg{
+			Client: datastore.ScopeClientCfg{Provider: "boltdb", Address: tmp.Name(), Config: &store.Config{Bucket: "libnetwork"}},
+		}
+	}
+	if err := c.ReloadConfiguration(append(cfgOptions, globalStore)...); err != nil {
+		t.Fatal(err)
+	}
+
+	cc := c.(*controller)
+	cc.Lock()
+	cfg := cc.cfg
+	cc.Unlock()
+	if _, ok := cfg.Scopes[datastore.GlobalScope]; !ok {
+		t.Fatal("the reload did not add the global store")
+	}
+	if !reflect.DeepEqual(cfg.Daemon.DefaultAddressPool, pools) {
+		t.Fatalf("the reload changed the default address pools to %v", cfg.Daemon.DefaultAddressPool)
+	}
+
+	// The bridge driver keeps its configuration, reloading it changes none
+	// of its settings
+	d, _ := cc.drvRegistry.Driver("bridge")
+	changed, err := d.(driverapi.ConfigReloader).ReloadConfig(bridgeOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 0 {
+		t.Fatalf("the reload changed the bridge settings %v", changed)
+	}
+
+	// The new pools still come from the configured default pools
+	ipam, _ := cc.drvRegistry.IPAM(ipamapi.DefaultIPAM)
+	as, _, err := ipam.GetDefaultAddressSpaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, pool, _, err := ipam.RequestPool(as, "", "", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ipam.ReleasePool(pid)
+	if pool.String() != "10.37.0.0/24" {
+		t.Fatalf("expected a pool of the default pools, got %v", pool)
+	}
+
+	// A reload changing an existing store is refused before changing any
+	// setting
+	err = c.ReloadConfiguration(config.OptionLocalKVProviderURL("/tmp/other.db"), config.OptionDefaultAddressPoolConfig([]*ipamutils.NetworkToSplit{{Base: "10.38.0.0/16", Size: 24}}))
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("expected a forbidden error, got %v", err)
+	}
+	if builtinIpam.GetDefaultIPAddressPool()[0].Base != "10.37.0.0/16" {
+		t.Fatal("the refused reload changed the default address pools")
+	}
+}
+
+func TestReloadDaemonConfigRollback(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	pools := []*ipamutils.NetworkToSplit{{Base: "10.37.0.0/16", Size: 24}}
+	bridgeOption := map[string]interface{}{netlabel.GenericData: options.Generic{"EnableUserlandProxy": true}}
+	c, err := New(config.OptionDefaultAddressPoolConfig(pools), config.OptionDriverConfig("bridge", bridgeOption))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	defer builtinIpam.SetDefaultIPAddressPool(nil)
+	defer ipamutils.ConfigLocalScopeDefaultNetworks(nil)
+	cc := c.(*controller)
+	cc.Lock()
+	old := cc.cfg
+	cc.Unlock()
+
+	// The invalid audit mode fails the reload after the pools and the
+	// bridge driver got their new settings
+	_, err = cc.ReloadDaemonConfiguration(
+		config.OptionDefaultAddressPoolConfig([]*ipamutils.NetworkToSplit{{Base: "10.38.0.0/16", Size: 24}}),
+		config.OptionDriverConfig("bridge", map[string]interface{}{netlabel.GenericData: options.Generic{"EnableUserlandProxy": false}}),
+		config.OptionFirewallAudit("bogus"))
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("expected a bad request error, got %v", err)
+	}
+
+	cc.Lock()
+	cfg := cc.cfg
+	cc.Unlock()
+	if cfg != old {
+		t.Fatal("the failed reload changed the configuration of the controller")
+	}
+	if builtinIpam.GetDefaultIPAddressPool()[0].Base != "10.37.0.0/16" {
+		t.Fatalf("the failed reload left the default address pools %v", builtinIpam.GetDefaultIPAddressPool())
+	}
+	d, _ := cc.drvRegistry.Driver("bridge")
+	changed, err := d.(driverapi.ConfigReloader).ReloadConfig(bridgeOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 0 {
+		t.Fatalf("the failed reload left the bridge settings %v changed", changed)
+	}
+}
+
+func TestDNSViewsPersisted(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork("bridge", "views", "", nil)
+	if err != nil {
+		c.Stop()
+		t.Fatal(err)
+	}
+	rules := []*DNSViewRule{{Name: "db", Addresses: []net.IP{net.ParseIP("10.1.1.10")}}}
+	if err := c.(*controller).SetDNSViews(n.ID(), rules); err != nil {
+		c.Stop()
+		t.Fatal(err)
+	}
+	c.Stop()
+
+	// the controller restores the rules with the network
+	c, err = New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if n, err = c.NetworkByID(n.ID()); err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+	if views := c.(*controller).DNSViews(n.ID()); !reflect.DeepEqual(views, rules) {
+		t.Fatalf("expected the restored DNS view rules %v, got %v", rules, views)
+	}
+
+	if err := c.(*controller).SetDNSViews(n.ID(), nil); err != nil {
+		t.Fatal(err)
+	}
+	sn, err := c.(*controller).getNetworkFromStore(n.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sn.dnsViews) != 0 {
+		t.Fatalf("expected the DNS view rules to be removed from the store, got %v", sn.dnsViews)
+	}
+}
+
+func TestDNSRecordWeightsPersisted(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	cfgOptions, err := OptionBoltdbWithRandomDBFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork("bridge", "weights", "", nil)
+	if err != nil {
+		c.Stop()
+		t.Fatal(err)
+	}
+	weights := []*DNSRecordWeight{{IP: net.ParseIP("10.0.0.2"), Priority: 1, Weight: 3}}
+	if err := c.(*controller).SetDNSRecordWeights(n.ID(), "web", weights); err != nil {
+		c.Stop()
+		t.Fatal(err)
+	}
+	c.Stop()
+
+	// the controller restores the weights with the network
+	c, err = New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if n, err = c.NetworkByID(n.ID()); err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+	if w := c.(*controller).DNSRecordWeights(n.ID(), "web"); !reflect.DeepEqual(w, weights) {
+		t.Fatalf("expected the restored DNS record weights %v, got %v", weights, w)
+	}
+
+	if err := c.(*controller).SetDNSRecordWeights(n.ID(), "web", nil); err != nil {
+		t.Fatal(err)
+	}
+	sn, err := c.(*controller).getNetworkFromStore(n.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sn.dnsWeights) != 0 {
+		t.Fatalf("expected the DNS record weights to be removed from the store, got %v", sn.dnsWeights)
+	}
+}
+
+func TestSandboxQueryLogPersisted(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	cfg := QueryLogConfig{Path: "/tmp/libnetwork-query.log", MaxFiles: 2}
+	sb, err := c.NewSandbox("c1", OptionDNSQueryLog(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Delete()
+
+	// the query log is in the state the sandbox is restored from
+	kvol, err := c.(*controller).getStore(datastore.LocalScope).List(datastore.Key(sandboxPrefix), &sbState{c: c.(*controller)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sbs *sbState
+	for _, kvo := range kvol {
+		if s := kvo.(*sbState); s.ID == sb.ID() {
+			sbs = s
+		}
+	}
+	if sbs == nil {
+		t.Fatalf("sandbox %s not found in the store", sb.ID())
+	}
+	if sbs.QueryLog == nil || *sbs.QueryLog != cfg {
+		t.Fatalf("expected the query log %v in the sandbox state, got %v", cfg, sbs.QueryLog)
+	}
+	dst := &sbState{}
+	if err := sbs.CopyTo(dst); err != nil {
+		t.Fatal(err)
+	}
+	if dst.QueryLog == sbs.QueryLog || *dst.QueryLog != cfg {
+		t.Fatalf("unexpected copied query log %v", dst.QueryLog)
+	}
+}
@@ -263,6 +263,11 @@ func TestEndpointJoin(t *testing.T) {
 	if info.Sandbox() != nil {
 		t.Fatalf("Expected an empty sandbox key for an empty endpoint. Instead found a non-empty sandbox key: %s", info.Sandbox().Key())
 	}
+	if _, err := ep1.Statistics(); err == nil {
+		t.Fatalf("Expected to fail retrieving the statistics of an endpoint not joined")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Unexpected error type returned: %T", err)
+	}
 
 	// test invalid joins
 	err = ep1.Join(nil)
@@ -332,6 +337,9 @@ func TestEndpointJoin(t *testing.T) {
 	if _, ok := stats["eth0"]; !ok {
 		t.Fatalf("Did not find eth0 statistics")
 	}
+	if _, err := ep1.Statistics(); err != nil {
+		t.Fatalf("Failed to retrieve the endpoint statistics: %v", err)
+	}
 
 	// Now test the container joining another network
 	n2, err := createTestNetwork(bridgeNetType, "testnetwork2",
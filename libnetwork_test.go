@@ -1,6 +1,7 @@
 package libnetwork_test
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -931,6 +932,10 @@ func (f *fakeSandbox) Delete() error {
 	return nil
 }
 
+func (f *fakeSandbox) DeleteContext(ctx context.Context) error {
+	return nil
+}
+
 func (f *fakeSandbox) Rename(name string) error {
 	return nil
 }
@@ -0,0 +1,530 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	mdnsPort   = 5353
+	mdnsDomain = "local."
+	// mdnsCacheFlush is the cache-flush bit set in the class of the unique
+	// records a responder owns [RFC 6762 Section-10.2]
+	mdnsCacheFlush = 1 << 15
+	// mdnsUnicastResponse is the unicast-response bit clients set in the
+	// class of a question [RFC 6762 Section-5.4]
+	mdnsUnicastResponse = 1 << 15
+	mdnsTTL             = 120
+	// mdnsServicesName is the name of the PTR records enumerating the
+	// service types of the link [RFC 6763 Section-9]
+	mdnsServicesName = "_services._dns-sd._udp." + mdnsDomain
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+
+// mdnsAnnounceInterval separates the repeated announcements of the records
+// of a joining endpoint [RFC 6762 Section-8.3]
+var mdnsAnnounceInterval = time.Second
+
+// mdnsServiceNames are the DNS-SD service names of the well known ports,
+// the other exposed ports are announced as _port-<port>
+var mdnsServiceNames = map[uint16]string{
+	21:   "ftp",
+	22:   "ssh",
+	80:   "http",
+	443:  "https",
+	1883: "mqtt",
+	3306: "mysql",
+	5432: "postgresql",
+	6379: "redis",
+	8080: "http",
+	8443: "https",
+}
+
+// mdnsResponder answers multicast DNS queries on the local link for the
+// names of the containers attached to a network. Names are served both as
+// <name>.local and <name>.<network>.local. The exposed ports of the
+// containers are announced as DNS-SD services, <name>.<service>.local, and
+// the records of the containers joining and leaving the network are
+// announced unsolicited.
+type mdnsResponder struct {
+	n      *network
+	iface  *net.Interface
+	conn   *net.UDPConn
+	events *EventSubscription
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newMDNSResponder(n *network, ifaceName string) (*mdnsResponder, error) {
+	iface, err := mdnsInterface(n, ifaceName)
+	if err != nil {
+		return nil, err
+	}
+	return &mdnsResponder{
+		n:      n,
+		iface:  iface,
+		stopCh: make(chan struct{}),
+	}, nil
+}
+
+// mdnsInterface returns the host interface named ifaceName or, when no name
+// is passed, the one carrying the network IPv4 gateway address
+func mdnsInterface(n *network, ifaceName string) (*net.Interface, error) {
+	if ifaceName != "" {
+		return net.InterfaceByName(ifaceName)
+	}
+
+	var gw net.IP
+	for _, info := range n.getIPInfo(4) {
+		if info.Gateway != nil {
+			gw = info.Gateway.IP
+			break
+		}
+	}
+	if gw == nil {
+		return nil, types.BadRequestErrorf("network %s has no gateway to announce mDNS records on", n.Name())
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && ipn.IP.Equal(gw) {
+				return &ifaces[i], nil
+			}
+		}
+	}
+	return nil, types.NotFoundErrorf("no interface found with the gateway address %s of network %s", gw, n.Name())
+}
+
+func (m *mdnsResponder) start() error {
+	conn, err := net.ListenMulticastUDP("udp4", m.iface, mdnsGroup)
+	if err != nil {
+		return fmt.Errorf("error in opening mDNS socket on %s: %v", m.iface.Name, err)
+	}
+	m.conn = conn
+	m.events = m.n.getController().Subscribe()
+
+	m.wg.Add(2)
+	go m.serve()
+	go m.watch()
+
+	logrus.Debugf("[mdns] responder for network %s started on %s", m.n.Name(), m.iface.Name)
+	return nil
+}
+
+func (m *mdnsResponder) stop() {
+	close(m.stopCh)
+	if m.events != nil {
+		m.events.Close()
+	}
+	if m.conn != nil {
+		m.send(mdnsAnnouncement(m.records(), true))
+		m.conn.Close()
+	}
+	m.wg.Wait()
+}
+
+// watch announces the records of the endpoints joining the network, and
+// their goodbye when they leave it
+func (m *mdnsResponder) watch() {
+	defer m.wg.Done()
+
+	for ev := range m.events.C {
+		if ev.NetworkID != m.n.ID() || (ev.Type != EventEndpointJoin && ev.Type != EventEndpointLeave) {
+			continue
+		}
+		e, err := m.n.EndpointByID(ev.EndpointID)
+		if err != nil {
+			continue
+		}
+		records := m.endpointRecords(e.(*endpoint))
+		if len(records) == 0 {
+			continue
+		}
+		if ev.Type == EventEndpointLeave {
+			m.send(mdnsAnnouncement(records, true))
+			continue
+		}
+		m.send(mdnsAnnouncement(records, false))
+		time.AfterFunc(mdnsAnnounceInterval, func() {
+			select {
+			case <-m.stopCh:
+			default:
+				m.send(mdnsAnnouncement(records, false))
+			}
+		})
+	}
+}
+
+// send multicasts the unsolicited response on the link
+func (m *mdnsResponder) send(resp *dns.Msg) {
+	if resp == nil {
+		return
+	}
+	b, err := resp.Pack()
+	if err != nil {
+		logrus.Debugf("[mdns] failed to pack announcement: %v", err)
+		return
+	}
+	if _, err := m.conn.WriteToUDP(b, mdnsGroup); err != nil {
+		logrus.Debugf("[mdns] failed to send announcement on %s: %v", m.iface.Name, err)
+	}
+}
+
+func (m *mdnsResponder) serve() {
+	defer m.wg.Done()
+
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		l, from, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-m.stopCh:
+				return
+			default:
+			}
+			logrus.Debugf("[mdns] read failed on %s: %v", m.iface.Name, err)
+			continue
+		}
+
+		query := new(dns.Msg)
+		if err := query.Unpack(buf[:l]); err != nil || query.Response {
+			continue
+		}
+		resp, unicast := m.answer(query)
+		if resp == nil {
+			continue
+		}
+
+		// Legacy unicast resolvers, not sending from the mDNS port, and
+		// questions asking for a unicast response get a direct reply
+		dst := mdnsGroup
+		if unicast || from.Port != mdnsPort {
+			dst = from
+		}
+		if from.Port != mdnsPort {
+			// Legacy unicast responses must echo the query id and question
+			// [RFC 6762 Section-6.7]
+			resp.Id = query.Id
+			resp.Question = query.Question
+		}
+		b, err := resp.Pack()
+		if err != nil {
+			logrus.Debugf("[mdns] failed to pack response: %v", err)
+			continue
+		}
+		if _, err := m.conn.WriteToUDP(b, dst); err != nil {
+			logrus.Debugf("[mdns] failed to send response to %s: %v", dst, err)
+		}
+	}
+}
+
+// answer builds the response for the questions the responder has records
+// for. The second return value is true when all the answered questions
+// asked for a unicast response.
+func (m *mdnsResponder) answer(query *dns.Msg) (*dns.Msg, bool) {
+	var records []dns.RR
+	return mdnsAnswer(query, m.resolve, func() []dns.RR {
+		if records == nil {
+			records = m.records()
+		}
+		return records
+	})
+}
+
+// mdnsAnswer builds the response to the query from the addresses resolve
+// returns for the A and AAAA questions, and from the records for the
+// others. The SRV, TXT and address records of the answered services are
+// added as additional records [RFC 6763 Section-12].
+func mdnsAnswer(query *dns.Msg, resolve func(name string, ipType int) []net.IP, records func() []dns.RR) (*dns.Msg, bool) {
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Authoritative = true
+
+	unicast := true
+	for _, q := range query.Question {
+		if !strings.HasSuffix(strings.ToLower(q.Name), "."+mdnsDomain) {
+			continue
+		}
+		var answers []dns.RR
+		switch q.Qtype {
+		case dns.TypeA, dns.TypeAAAA:
+			answers = mdnsAddressRecords(q.Name, resolve(strings.TrimSuffix(q.Name[:len(q.Name)-len(mdnsDomain)], "."), mdnsIPType(q.Qtype)))
+		case dns.TypePTR, dns.TypeSRV, dns.TypeTXT, dns.TypeANY:
+			answers = mdnsMatch(records(), q.Name, q.Qtype)
+		}
+		if len(answers) == 0 {
+			continue
+		}
+		if q.Qclass&mdnsUnicastResponse == 0 {
+			unicast = false
+		}
+		resp.Answer = mdnsAppend(resp.Answer, answers...)
+	}
+
+	if len(resp.Answer) == 0 {
+		return nil, false
+	}
+	resp.Extra = mdnsAdditionals(resp.Answer, records)
+	return resp, unicast
+}
+
+// mdnsAdditionals returns the records of the services the PTR answers
+// point to, and the address records of the targets of the SRV records
+func mdnsAdditionals(answers []dns.RR, records func() []dns.RR) []dns.RR {
+	var extra []dns.RR
+	add := func(name string, qtype uint16) {
+		for _, rr := range mdnsMatch(records(), name, qtype) {
+			if !mdnsContains(answers, rr) {
+				extra = mdnsAppend(extra, rr)
+			}
+		}
+	}
+	for _, rr := range answers {
+		if ptr, ok := rr.(*dns.PTR); ok && !strings.EqualFold(rr.Header().Name, mdnsServicesName) {
+			add(ptr.Ptr, dns.TypeSRV)
+			add(ptr.Ptr, dns.TypeTXT)
+		}
+	}
+	for _, rr := range append(append([]dns.RR(nil), answers...), extra...) {
+		if srv, ok := rr.(*dns.SRV); ok {
+			add(srv.Target, dns.TypeA)
+			add(srv.Target, dns.TypeAAAA)
+		}
+	}
+	return extra
+}
+
+// mdnsMatch returns the records of the name and type, of all the types
+// for ANY
+func mdnsMatch(records []dns.RR, name string, qtype uint16) []dns.RR {
+	var match []dns.RR
+	for _, rr := range records {
+		if strings.EqualFold(rr.Header().Name, name) && (qtype == dns.TypeANY || rr.Header().Rrtype == qtype) {
+			match = mdnsAppend(match, rr)
+		}
+	}
+	return match
+}
+
+// mdnsAppend appends the records which are not in the list yet
+func mdnsAppend(list []dns.RR, records ...dns.RR) []dns.RR {
+	for _, rr := range records {
+		if !mdnsContains(list, rr) {
+			list = append(list, rr)
+		}
+	}
+	return list
+}
+
+func mdnsContains(list []dns.RR, rr dns.RR) bool {
+	for _, r := range list {
+		if r.String() == rr.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func mdnsIPType(qtype uint16) int {
+	if qtype == dns.TypeAAAA {
+		return types.IPv6
+	}
+	return types.IPv4
+}
+
+// mdnsAddressRecords returns the A and AAAA records of the addresses of
+// the name
+func mdnsAddressRecords(name string, ips []net.IP) []dns.RR {
+	var records []dns.RR
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			hdr := dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET | mdnsCacheFlush, Ttl: mdnsTTL}
+			records = append(records, &dns.A{Hdr: hdr, A: ip4})
+		} else {
+			hdr := dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET | mdnsCacheFlush, Ttl: mdnsTTL}
+			records = append(records, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	return records
+}
+
+// mdnsServiceType returns the DNS-SD service type of the exposed port,
+// _<service>._tcp or _<service>._udp
+func mdnsServiceType(p types.TransportPort) string {
+	name, ok := mdnsServiceNames[p.Port]
+	if !ok {
+		name = "port-" + strconv.Itoa(int(p.Port))
+	}
+	proto := "_udp"
+	if p.Proto == types.TCP {
+		proto = "_tcp"
+	}
+	return "_" + name + "." + proto
+}
+
+// mdnsEndpointRecords returns the records announcing the endpoint name on
+// the network: the addresses of the host names of the endpoint, and the
+// PTR, SRV and TXT records of the DNS-SD service of each exposed port
+// [RFC 6763 Section-4], the service instance being named after the
+// endpoint
+func mdnsEndpointRecords(name, nwName string, ips []net.IP, ports []types.TransportPort) []dns.RR {
+	host := name + "." + mdnsDomain
+	records := mdnsAddressRecords(host, ips)
+	records = append(records, mdnsAddressRecords(name+"."+nwName+"."+mdnsDomain, ips)...)
+	for _, p := range ports {
+		svcType := mdnsServiceType(p) + "." + mdnsDomain
+		instance := name + "." + svcType
+		records = mdnsAppend(records,
+			&dns.PTR{Hdr: dns.RR_Header{Name: mdnsServicesName, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: mdnsTTL}, Ptr: svcType},
+			&dns.PTR{Hdr: dns.RR_Header{Name: svcType, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: mdnsTTL}, Ptr: instance},
+			&dns.SRV{Hdr: dns.RR_Header{Name: instance, Rrtype: dns.TypeSRV, Class: dns.ClassINET | mdnsCacheFlush, Ttl: mdnsTTL}, Port: p.Port, Target: host},
+			// a service without attributes has a single empty string
+			// [RFC 6763 Section-6.1]
+			&dns.TXT{Hdr: dns.RR_Header{Name: instance, Rrtype: dns.TypeTXT, Class: dns.ClassINET | mdnsCacheFlush, Ttl: mdnsTTL}, Txt: []string{""}})
+	}
+	return records
+}
+
+// mdnsAnnouncement returns the unsolicited response announcing the
+// records [RFC 6762 Section-8.3], or their goodbye, with a zero TTL, when
+// they are withdrawn [RFC 6762 Section-10.1]
+func mdnsAnnouncement(records []dns.RR, goodbye bool) *dns.Msg {
+	if len(records) == 0 {
+		return nil
+	}
+	resp := new(dns.Msg)
+	resp.Response = true
+	resp.Authoritative = true
+	for _, rr := range records {
+		rr = dns.Copy(rr)
+		if goodbye {
+			rr.Header().Ttl = 0
+		}
+		resp.Answer = append(resp.Answer, rr)
+	}
+	return resp
+}
+
+// endpointRecords returns the records of the endpoint, none when it is not
+// resolvable by its name
+func (m *mdnsResponder) endpointRecords(ep *endpoint) []dns.RR {
+	ep.Lock()
+	defer ep.Unlock()
+	if ep.anonymous || ep.disableResolution || ep.iface == nil {
+		return nil
+	}
+	var ips []net.IP
+	if ep.iface.addr != nil {
+		ips = append(ips, ep.iface.addr.IP)
+	}
+	if ep.iface.addrv6 != nil {
+		ips = append(ips, ep.iface.addrv6.IP)
+	}
+	if len(ips) == 0 {
+		return nil
+	}
+	return mdnsEndpointRecords(ep.name, m.n.Name(), ips, ep.exposedPorts)
+}
+
+// records returns the records of the endpoints joined to the network
+func (m *mdnsResponder) records() []dns.RR {
+	var records []dns.RR
+	for _, e := range m.n.Endpoints() {
+		ep := e.(*endpoint)
+		if _, ok := ep.getSandbox(); !ok {
+			continue
+		}
+		records = mdnsAppend(records, m.endpointRecords(ep)...)
+	}
+	return records
+}
+
+func (m *mdnsResponder) resolve(name string, ipType int) []net.IP {
+	if ip, _ := m.n.ResolveName(name, ipType); ip != nil {
+		return ip
+	}
+	if nwName := "." + m.n.Name(); strings.HasSuffix(name, nwName) {
+		ip, _ := m.n.ResolveName(strings.TrimSuffix(name, nwName), ipType)
+		return ip
+	}
+	return nil
+}
+
+func (c *controller) startMDNS(n *network) {
+	dc := n.DNSConfig()
+	if dc == nil || !dc.MDNS {
+		return
+	}
+
+	m, err := newMDNSResponder(n, dc.MDNSInterface)
+	if err == nil {
+		err = m.start()
+	}
+	if err != nil {
+		logrus.Warnf("Failed to start the mDNS responder for network %s: %v", n.Name(), err)
+		return
+	}
+
+	c.Lock()
+	if c.mdnsResponders == nil {
+		c.mdnsResponders = make(map[string]*mdnsResponder)
+	}
+	old := c.mdnsResponders[n.ID()]
+	c.mdnsResponders[n.ID()] = m
+	c.Unlock()
+
+	if old != nil {
+		old.stop()
+	}
+}
+
+// restoreMDNS starts the mDNS responders of the networks restored from
+// the store
+func (c *controller) restoreMDNS() {
+	c.WalkNetworks(func(nw Network) bool {
+		if n := nw.(*network); !n.ConfigOnly() {
+			c.startMDNS(n)
+		}
+		return false
+	})
+}
+
+// stopAllMDNS stops the mDNS responders of all the networks
+func (c *controller) stopAllMDNS() {
+	c.Lock()
+	responders := c.mdnsResponders
+	c.mdnsResponders = nil
+	c.Unlock()
+
+	for _, m := range responders {
+		m.stop()
+	}
+}
+
+func (c *controller) stopMDNS(nid string) {
+	c.Lock()
+	m, ok := c.mdnsResponders[nid]
+	delete(c.mdnsResponders, nid)
+	c.Unlock()
+
+	if ok {
+		m.stop()
+	}
+}
@@ -0,0 +1,172 @@
+package libnetwork
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+)
+
+func mdnsTestRecords() []dns.RR {
+	return mdnsEndpointRecords("web", "net1", []net.IP{net.ParseIP("10.0.0.2")},
+		[]types.TransportPort{{Proto: types.TCP, Port: 80}, {Proto: types.UDP, Port: 9000}})
+}
+
+func mdnsTestQuery(t *testing.T, name string, qtype uint16) *dns.Msg {
+	q := new(dns.Msg)
+	q.SetQuestion(name, qtype)
+	// the query goes through the wire
+	b, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := new(dns.Msg)
+	if err := query.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	return query
+}
+
+func mdnsTestAnswer(t *testing.T, query *dns.Msg) (*dns.Msg, bool) {
+	resolve := func(name string, ipType int) []net.IP {
+		if name == "web" && ipType == types.IPv4 {
+			return []net.IP{net.ParseIP("10.0.0.2")}
+		}
+		return nil
+	}
+	resp, unicast := mdnsAnswer(query, resolve, mdnsTestRecords)
+	if resp == nil {
+		return nil, false
+	}
+	b, err := resp.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	return msg, unicast
+}
+
+func TestMDNSAnswerServiceBrowse(t *testing.T) {
+	resp, _ := mdnsTestAnswer(t, mdnsTestQuery(t, "_http._tcp.local.", dns.TypePTR))
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("expected one PTR answer, got %v", resp)
+	}
+	if ptr, ok := resp.Answer[0].(*dns.PTR); !ok || ptr.Ptr != "web._http._tcp.local." {
+		t.Fatalf("unexpected answer %v", resp.Answer[0])
+	}
+
+	// the service is resolved from the additional records
+	var srv *dns.SRV
+	var txt *dns.TXT
+	var a *dns.A
+	for _, rr := range resp.Extra {
+		switch rr := rr.(type) {
+		case *dns.SRV:
+			srv = rr
+		case *dns.TXT:
+			txt = rr
+		case *dns.A:
+			a = rr
+		}
+	}
+	if srv == nil || srv.Port != 80 || srv.Target != "web.local." {
+		t.Fatalf("unexpected SRV record %v", srv)
+	}
+	if txt == nil || len(txt.Txt) != 1 || txt.Txt[0] != "" {
+		t.Fatalf("unexpected TXT record %v", txt)
+	}
+	if a == nil || !a.A.Equal(net.ParseIP("10.0.0.2")) || a.Hdr.Name != "web.local." {
+		t.Fatalf("unexpected A record %v", a)
+	}
+}
+
+func TestMDNSAnswerServiceTypes(t *testing.T) {
+	resp, _ := mdnsTestAnswer(t, mdnsTestQuery(t, mdnsServicesName, dns.TypePTR))
+	if resp == nil {
+		t.Fatal("expected the service types to be enumerated")
+	}
+	svcTypes := map[string]bool{}
+	for _, rr := range resp.Answer {
+		svcTypes[rr.(*dns.PTR).Ptr] = true
+	}
+	if len(svcTypes) != 2 || !svcTypes["_http._tcp.local."] || !svcTypes["_port-9000._udp.local."] {
+		t.Fatalf("unexpected service types %v", svcTypes)
+	}
+	if len(resp.Extra) != 0 {
+		t.Fatalf("expected no additional records for the enumeration, got %v", resp.Extra)
+	}
+}
+
+func TestMDNSAnswerInstance(t *testing.T) {
+	resp, _ := mdnsTestAnswer(t, mdnsTestQuery(t, "web._port-9000._udp.local.", dns.TypeSRV))
+	if resp == nil || len(resp.Answer) != 1 {
+		t.Fatalf("expected one SRV answer, got %v", resp)
+	}
+	if srv := resp.Answer[0].(*dns.SRV); srv.Port != 9000 {
+		t.Fatalf("unexpected SRV answer %v", srv)
+	}
+	if len(resp.Extra) != 1 || resp.Extra[0].Header().Rrtype != dns.TypeA {
+		t.Fatalf("expected the address of the target, got %v", resp.Extra)
+	}
+
+	resp, _ = mdnsTestAnswer(t, mdnsTestQuery(t, "web._http._tcp.local.", dns.TypeANY))
+	if resp == nil || len(resp.Answer) != 2 {
+		t.Fatalf("expected the SRV and TXT answers, got %v", resp)
+	}
+}
+
+func TestMDNSAnswerAddress(t *testing.T) {
+	query := mdnsTestQuery(t, "web.local.", dns.TypeA)
+	resp, unicast := mdnsTestAnswer(t, query)
+	if resp == nil || len(resp.Answer) != 1 || unicast {
+		t.Fatalf("unexpected response %v (unicast %v)", resp, unicast)
+	}
+	if hdr := resp.Answer[0].Header(); hdr.Class != dns.ClassINET|mdnsCacheFlush || hdr.Ttl != mdnsTTL {
+		t.Fatalf("unexpected answer header %v", hdr)
+	}
+
+	query.Question[0].Qclass |= mdnsUnicastResponse
+	if _, unicast := mdnsTestAnswer(t, query); !unicast {
+		t.Fatal("expected a unicast response")
+	}
+
+	for _, name := range []string{"db.local.", "web.example.com."} {
+		if resp, _ := mdnsTestAnswer(t, mdnsTestQuery(t, name, dns.TypeA)); resp != nil {
+			t.Fatalf("expected no answer for %s, got %v", name, resp)
+		}
+	}
+}
+
+func TestMDNSAnnouncement(t *testing.T) {
+	records := mdnsTestRecords()
+	// the addresses of both host names, and the four records of each port
+	if len(records) != 2+4+4 {
+		t.Fatalf("unexpected records %v", records)
+	}
+
+	msg := mdnsAnnouncement(records, false)
+	if !msg.Response || !msg.Authoritative || len(msg.Answer) != len(records) || len(msg.Question) != 0 {
+		t.Fatalf("unexpected announcement %v", msg)
+	}
+	if _, err := msg.Pack(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg = mdnsAnnouncement(records, true)
+	for _, rr := range msg.Answer {
+		if rr.Header().Ttl != 0 {
+			t.Fatalf("expected the goodbye records to have a zero TTL, got %v", rr)
+		}
+	}
+	if records[0].Header().Ttl != mdnsTTL {
+		t.Fatal("expected the goodbye not to change the records")
+	}
+
+	if mdnsAnnouncement(nil, false) != nil {
+		t.Fatal("expected no announcement without records")
+	}
+}
@@ -0,0 +1,355 @@
+// Package metrics is the registry of the libnetwork operational metrics.
+// The controller, the drivers, the port mapper, IPAM, the embedded DNS
+// server and networkdb register their metrics here, and the embedding
+// daemon exposes them all through the single Collector returned by
+// Default, which serves the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Namespace prefixes the names of all the libnetwork metrics
+const Namespace = "libnetwork"
+
+// Metric types, as named in the Prometheus exposition format
+const (
+	CounterType   = "counter"
+	GaugeType     = "gauge"
+	HistogramType = "histogram"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the histograms
+// created without buckets
+var DefaultBuckets = []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10}
+
+// Sample is a single value of a metric family
+type Sample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Family is a point in time snapshot of a metric and of its values for
+// every set of labels
+type Family struct {
+	Name    string   `json:"name"`
+	Help    string   `json:"help"`
+	Type    string   `json:"type"`
+	Samples []Sample `json:"samples"`
+}
+
+type metric interface {
+	family() Family
+}
+
+// Collector collects the libnetwork metrics
+type Collector struct {
+	metrics map[string]metric
+	sync.Mutex
+}
+
+var defaultCollector = NewCollector()
+
+// NewCollector returns an empty collector
+func NewCollector() *Collector {
+	return &Collector{metrics: make(map[string]metric)}
+}
+
+// Default returns the collector the libnetwork metrics are registered to
+func Default() *Collector {
+	return defaultCollector
+}
+
+func (c *Collector) register(name string, m metric) {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.metrics[name]; ok {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	c.metrics[name] = m
+}
+
+// Gather returns the snapshot of the metrics, sorted by name
+func (c *Collector) Gather() []Family {
+	c.Lock()
+	ms := make([]metric, 0, len(c.metrics))
+	for _, m := range c.metrics {
+		ms = append(ms, m)
+	}
+	c.Unlock()
+
+	fs := make([]Family, 0, len(ms))
+	for _, m := range ms {
+		fs = append(fs, m.family())
+	}
+	sort.Slice(fs, func(i, j int) bool { return fs[i].Name < fs[j].Name })
+	return fs
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	for _, f := range c.Gather() {
+		fmt.Fprintf(&b, "# HELP %s %s\n", f.Name, escapeHelp(f.Help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", f.Name, f.Type)
+		for _, s := range f.Samples {
+			b.WriteString(s.Name)
+			writeLabels(&b, s.Labels)
+			b.WriteByte(' ')
+			b.WriteString(formatValue(s.Value))
+			b.WriteByte('\n')
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	c.WriteTo(w)
+}
+
+func writeLabels(b *strings.Builder, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(b, "%s=%q", n, labels[n])
+	}
+	b.WriteByte('}')
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func fullName(subsystem, name string) string {
+	return Namespace + "_" + subsystem + "_" + name
+}
+
+// vec holds the values of a metric for each set of label values
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	values map[string]*value
+	sync.Mutex
+}
+
+type value struct {
+	labelValues []string
+	v           float64
+	// buckets, sum and count of the histograms
+	buckets []uint64
+	count   uint64
+}
+
+func newVec(subsystem, name, help string, labels []string) *vec {
+	return &vec{
+		name:   fullName(subsystem, name),
+		help:   help,
+		labels: labels,
+		values: make(map[string]*value),
+	}
+}
+
+// with returns the value for the label values, with the vec locked
+func (v *vec) with(labelValues []string, nbuckets int) *value {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	val, ok := v.values[key]
+	if !ok {
+		val = &value{labelValues: append([]string(nil), labelValues...)}
+		if nbuckets > 0 {
+			val.buckets = make([]uint64, nbuckets)
+		}
+		v.values[key] = val
+	}
+	return val
+}
+
+func (v *vec) labelMap(val *value, extra ...string) map[string]string {
+	if len(v.labels) == 0 && len(extra) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(v.labels)+len(extra)/2)
+	for i, l := range v.labels {
+		m[l] = val.labelValues[i]
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		m[extra[i]] = extra[i+1]
+	}
+	return m
+}
+
+func (v *vec) sortedValues() []*value {
+	vals := make([]*value, 0, len(v.values))
+	for _, val := range v.values {
+		vals = append(vals, val)
+	}
+	sort.Slice(vals, func(i, j int) bool {
+		return strings.Join(vals[i].labelValues, "\xff") < strings.Join(vals[j].labelValues, "\xff")
+	})
+	return vals
+}
+
+func (v *vec) simpleFamily(typ string) Family {
+	v.Lock()
+	defer v.Unlock()
+	f := Family{Name: v.name, Help: v.help, Type: typ}
+	for _, val := range v.sortedValues() {
+		f.Samples = append(f.Samples, Sample{Name: v.name, Labels: v.labelMap(val), Value: val.v})
+	}
+	return f
+}
+
+// Counter is a metric which only goes up, partitioned by its labels
+type Counter struct {
+	*vec
+}
+
+// NewCounter registers a counter named libnetwork_<subsystem>_<name>
+func NewCounter(subsystem, name, help string, labels ...string) *Counter {
+	c := &Counter{newVec(subsystem, name, help, labels)}
+	defaultCollector.register(c.name, c)
+	return c
+}
+
+// Inc increments the counter of the label values by one
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must not be negative, to the counter of the label
+// values
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s decreased", c.name))
+	}
+	c.Lock()
+	c.with(labelValues, 0).v += delta
+	c.Unlock()
+}
+
+func (c *Counter) family() Family {
+	return c.simpleFamily(CounterType)
+}
+
+// Gauge is a metric which can go up and down, partitioned by its labels
+type Gauge struct {
+	*vec
+}
+
+// NewGauge registers a gauge named libnetwork_<subsystem>_<name>
+func NewGauge(subsystem, name, help string, labels ...string) *Gauge {
+	g := &Gauge{newVec(subsystem, name, help, labels)}
+	defaultCollector.register(g.name, g)
+	return g
+}
+
+// Set sets the gauge of the label values to v
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.Lock()
+	g.with(labelValues, 0).v = v
+	g.Unlock()
+}
+
+// Add adds delta to the gauge of the label values
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.Lock()
+	g.with(labelValues, 0).v += delta
+	g.Unlock()
+}
+
+// Inc increments the gauge of the label values by one
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec decrements the gauge of the label values by one
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (g *Gauge) family() Family {
+	return g.simpleFamily(GaugeType)
+}
+
+// Histogram counts observations in buckets, partitioned by its labels
+type Histogram struct {
+	*vec
+	buckets []float64
+}
+
+// NewHistogram registers a histogram named libnetwork_<subsystem>_<name>
+// with the bucket upper bounds, DefaultBuckets if nil
+func NewHistogram(subsystem, name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("histogram %s buckets are not sorted", name))
+	}
+	h := &Histogram{vec: newVec(subsystem, name, help, labels), buckets: buckets}
+	defaultCollector.register(h.name, h)
+	return h
+}
+
+// Observe adds the observation o to the histogram of the label values
+func (h *Histogram) Observe(o float64, labelValues ...string) {
+	i := sort.SearchFloat64s(h.buckets, o)
+	h.Lock()
+	val := h.with(labelValues, len(h.buckets)+1)
+	val.buckets[i]++
+	val.count++
+	val.v += o
+	h.Unlock()
+}
+
+func (h *Histogram) family() Family {
+	h.Lock()
+	defer h.Unlock()
+	f := Family{Name: h.name, Help: h.help, Type: HistogramType}
+	for _, val := range h.sortedValues() {
+		var cumulative uint64
+		for i, ub := range h.buckets {
+			cumulative += val.buckets[i]
+			f.Samples = append(f.Samples, Sample{Name: h.name + "_bucket", Labels: h.labelMap(val, "le", formatValue(ub)), Value: float64(cumulative)})
+		}
+		f.Samples = append(f.Samples,
+			Sample{Name: h.name + "_bucket", Labels: h.labelMap(val, "le", "+Inf"), Value: float64(val.count)},
+			Sample{Name: h.name + "_sum", Labels: h.labelMap(val), Value: val.v},
+			Sample{Name: h.name + "_count", Labels: h.labelMap(val), Value: float64(val.count)})
+	}
+	return f
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCollectorExposition(t *testing.T) {
+	c := NewCounter("test", "requests_total", "Test requests", "code")
+	c.Inc("200")
+	c.Add(2, "200")
+	c.Inc("500")
+
+	g := NewGauge("test", "inflight", "Test requests in flight")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+
+	h := NewHistogram("test", "latency_seconds", "Test latency", []float64{.25, 1})
+	h.Observe(.125)
+	h.Observe(.25)
+	h.Observe(3)
+
+	var b bytes.Buffer
+	if _, err := Default().WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, line := range []string{
+		"# HELP libnetwork_test_requests_total Test requests",
+		"# TYPE libnetwork_test_requests_total counter",
+		`libnetwork_test_requests_total{code="200"} 3`,
+		`libnetwork_test_requests_total{code="500"} 1`,
+		"# TYPE libnetwork_test_inflight gauge",
+		"libnetwork_test_inflight 1",
+		"# TYPE libnetwork_test_latency_seconds histogram",
+		`libnetwork_test_latency_seconds_bucket{le="0.25"} 2`,
+		`libnetwork_test_latency_seconds_bucket{le="1"} 2`,
+		`libnetwork_test_latency_seconds_bucket{le="+Inf"} 3`,
+		"libnetwork_test_latency_seconds_sum 3.375",
+		"libnetwork_test_latency_seconds_count 3",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, out)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	Default().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Body.String() != out {
+		t.Fatalf("unexpected served metrics:\n%s", w.Body.String())
+	}
+}
+
+func TestLabelValues(t *testing.T) {
+	c := NewCounter("test", "labels_total", "Test labels", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic on missing label values")
+		}
+	}()
+	c.Inc("x")
+}
@@ -53,6 +53,14 @@ const (
 
 	// ContainerIfacePrefix can be used to override the interface prefix used inside the container
 	ContainerIfacePrefix = Prefix + ".container_iface_prefix"
+
+	// CorrelationID constant represents the correlation ID of the operation
+	// the driver options are passed for
+	CorrelationID = Prefix + ".correlation_id"
+
+	// Rootless constant represents that the drivers run in the namespaces
+	// of RootlessKit, without privileges on the host
+	Rootless = Prefix + ".rootless"
 )
 
 var (
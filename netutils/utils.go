@@ -147,23 +147,12 @@ func ReverseIP(IP string) string {
 			reverseIP[i], reverseIP[j] = reverseIP[j], reverseIP[i]
 		}
 	} else {
-		reverseIP = strings.Split(IP, ":")
-
-		// Reversed IPv6 is represented in dotted decimal instead of the typical
-		// colon hex notation
-		for key := range reverseIP {
-			if len(reverseIP[key]) == 0 { // expand the compressed 0s
-				reverseIP[key] = strings.Repeat("0000", 8-strings.Count(IP, ":"))
-			} else if len(reverseIP[key]) < 4 { // 0-padding needed
-				reverseIP[key] = strings.Repeat("0", 4-len(reverseIP[key])) + reverseIP[key]
-			}
-		}
-
-		reverseIP = strings.Split(strings.Join(reverseIP, ""), "")
-
-		l := len(reverseIP)
-		for i, j := 0, l-1; i < l/2; i, j = i+1, j-1 {
-			reverseIP[i], reverseIP[j] = reverseIP[j], reverseIP[i]
+		// Reversed IPv6 is represented in dotted nibble form instead of the
+		// typical colon hex notation. Expanding from the parsed address covers
+		// the compressed and the IPv4 mapped forms alike.
+		ip := net.ParseIP(IP).To16()
+		for i := len(ip) - 1; i >= 0; i-- {
+			reverseIP = append(reverseIP, fmt.Sprintf("%x", ip[i]&0xf), fmt.Sprintf("%x", ip[i]>>4))
 		}
 	}
 
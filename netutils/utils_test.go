@@ -323,6 +323,23 @@ func TestElectInterfaceAddress(t *testing.T) {
 	}
 }
 
+func TestReverseIP(t *testing.T) {
+	for _, tc := range []struct {
+		ip       string
+		expected string
+	}{
+		{"172.21.0.2", "2.0.21.172"},
+		{"2001:db8::567:89ab", "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2"},
+		{"::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0"},
+		{"fe80::", "0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.e.f"},
+		{"2001:DB8:0:0:1:0:0:AB", "b.a.0.0.0.0.0.0.0.0.0.0.1.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2"},
+	} {
+		if got := ReverseIP(tc.ip); got != tc.expected {
+			t.Fatalf("unexpected reversed IP for %s: %s, expected %s", tc.ip, got, tc.expected)
+		}
+	}
+}
+
 func createInterface(t *testing.T, name string, nws ...string) {
 	// Add interface
 	link := &netlink.Bridge{
@@ -1,15 +1,18 @@
 package libnetwork
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/correlation"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/etchosts"
@@ -19,6 +22,7 @@ import (
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/networkdb"
 	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/tracing"
 	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 )
@@ -39,6 +43,20 @@ type Network interface {
 	// specified unique name. The options parameter carries driver specific options.
 	CreateEndpoint(name string, options ...EndpointOption) (Endpoint, error)
 
+	// CreateEndpointContext is CreateEndpoint which gives up and rolls back
+	// the endpoint creation when the context is done.
+	CreateEndpointContext(ctx context.Context, name string, options ...EndpointOption) (Endpoint, error)
+
+	// ImportEndpoint re-creates the endpoint exported from another node,
+	// with the same id, addresses and service registrations. The exported
+	// endpoint must be deleted on its node first.
+	ImportEndpoint(ctx context.Context, m *EndpointMigration, options ...EndpointOption) (Endpoint, error)
+
+	// AbortMigration cancels the migration of the endpoint exported with
+	// m: the endpoint is unexported, or once deleted, the addresses and
+	// cluster records it kept for the import are released.
+	AbortMigration(m *EndpointMigration) error
+
 	// Delete the network.
 	Delete(options ...NetworkDeleteOption) error
 
@@ -56,6 +74,10 @@ type Network interface {
 
 	// Return certain operational data belonging to this network
 	Info() NetworkInfo
+
+	// DatapathState returns the datapath state of the network and of its
+	// endpoints, for troubleshooting
+	DatapathState() (*NetworkDatapath, error)
 }
 
 // NetworkInfo returns some configuration and operational information about the network
@@ -72,6 +94,7 @@ type NetworkInfo interface {
 	ConfigOnly() bool
 	Labels() map[string]string
 	Dynamic() bool
+	DNSConfig() *DNSConfig
 	Created() time.Time
 	// Peers returns a slice of PeerInfo structures which has the information about the peer
 	// nodes participating in the same overlay network. This is currently the per-network
@@ -150,6 +173,128 @@ func (c *IpamConf) Validate() error {
 	return nil
 }
 
+// DNSConfig contains the embedded DNS server related configurations a network
+// applies to the sandboxes attached to it. Container level DNS settings take
+// precedence over the network ones, which in turn take precedence over the
+// settings inherited from the host resolv.conf
+type DNSConfig struct {
+	// Upstream nameservers the embedded DNS server forwards queries to,
+	// in place of the ones found in the host resolv.conf
+	Servers []string
+	// resolv.conf options set in the container: ndots:n, timeout:n,
+	// attempts:n and rotate are supported
+	Options []string
+	// MDNS enables a multicast DNS responder announcing the names of the
+	// containers attached to the network on the local link
+	MDNS bool
+	// MDNSInterface is the host interface the mDNS responder runs on.
+	// Defaults to the interface carrying the network gateway address
+	MDNSInterface string
+	// NAT64Prefix enables DNS64: AAAA answers are synthesized by embedding
+	// the IPv4 address of the names without IPv6 addresses in the prefix
+	NAT64Prefix string
+	// Forwarding is the policy used to forward the queries to the upstream
+	// nameservers. Defaults to trying them sequentially
+	Forwarding *ForwardPolicy
+	// QueryPolicy restricts the queries the sandboxes attached to the
+	// network can send
+	QueryPolicy *QueryPolicy
+	// QueryLog enables the structured log of the queries of the sandboxes
+	// attached to the network
+	QueryLog *QueryLogConfig
+	// ForwardRules send the queries for the names of specific domains to
+	// dedicated nameservers, for split DNS setups
+	ForwardRules []*ForwardRule
+	// MaxUDPResponseSize is the largest UDP response sent to the EDNS0
+	// clients, whatever the payload size they advertise. Larger responses
+	// are truncated so that the clients retry over TCP. Defaults to 4096
+	MaxUDPResponseSize uint16
+}
+
+// Validate checks whether the configuration is valid
+func (c *DNSConfig) Validate() error {
+	for _, s := range c.Servers {
+		if net.ParseIP(s) == nil {
+			return types.BadRequestErrorf("invalid nameserver address %s in DNS configuration", s)
+		}
+	}
+	for _, o := range c.Options {
+		if o == "rotate" {
+			continue
+		}
+		parts := strings.SplitN(o, ":", 2)
+		switch parts[0] {
+		case "ndots", "timeout", "attempts":
+			if len(parts) != 2 {
+				return types.BadRequestErrorf("missing value for DNS option %s", o)
+			}
+			if n, err := strconv.Atoi(parts[1]); err != nil || n < 0 {
+				return types.BadRequestErrorf("invalid value for DNS option %s", o)
+			}
+		default:
+			return types.BadRequestErrorf("unsupported DNS option %s", o)
+		}
+	}
+	if c.MDNSInterface != "" && !c.MDNS {
+		return types.BadRequestErrorf("mDNS interface %s configured with mDNS disabled", c.MDNSInterface)
+	}
+	if c.NAT64Prefix != "" {
+		if _, err := validateNAT64Prefix(c.NAT64Prefix); err != nil {
+			return err
+		}
+	}
+	if c.Forwarding != nil {
+		if err := c.Forwarding.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.QueryPolicy != nil {
+		if err := c.QueryPolicy.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.QueryLog != nil {
+		if err := c.QueryLog.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, fr := range c.ForwardRules {
+		if err := fr.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.MaxUDPResponseSize != 0 && c.MaxUDPResponseSize < defaultRespSize {
+		return types.BadRequestErrorf("invalid maximum UDP response size %d, must be at least %d", c.MaxUDPResponseSize, defaultRespSize)
+	}
+	return nil
+}
+
+// CopyTo deep copies to the destination DNSConfig
+func (c *DNSConfig) CopyTo(dstC *DNSConfig) error {
+	*dstC = *c
+	dstC.Servers = append([]string(nil), c.Servers...)
+	dstC.Options = append([]string(nil), c.Options...)
+	if c.Forwarding != nil {
+		p := *c.Forwarding
+		dstC.Forwarding = &p
+	}
+	if c.QueryPolicy != nil {
+		dstC.QueryPolicy = &QueryPolicy{}
+		c.QueryPolicy.CopyTo(dstC.QueryPolicy)
+	}
+	if c.QueryLog != nil {
+		ql := *c.QueryLog
+		dstC.QueryLog = &ql
+	}
+	if c.ForwardRules != nil {
+		dstC.ForwardRules = make([]*ForwardRule, 0, len(c.ForwardRules))
+		for _, fr := range c.ForwardRules {
+			dstC.ForwardRules = append(dstC.ForwardRules, &ForwardRule{Domain: fr.Domain, Servers: append([]string(nil), fr.Servers...)})
+		}
+	}
+	return nil
+}
+
 // IpamInfo contains all the ipam related operational info for a network
 type IpamInfo struct {
 	PoolID string
@@ -234,15 +379,27 @@ type network struct {
 	configFrom       string
 	loadBalancerIP   net.IP
 	loadBalancerMode string
+	dnsConfig        *DNSConfig
+	// the DNS view rules and the DNS record weights by name, persisted for
+	// the controller to restore them
+	dnsViews   []*DNSViewRule
+	dnsWeights map[string][]*DNSRecordWeight
 	sync.Mutex
 }
 
 const (
 	loadBalancerModeNAT     = "NAT"
 	loadBalancerModeDSR     = "DSR"
+	loadBalancerModeTunnel  = "TUN"
 	loadBalancerModeDefault = loadBalancerModeNAT
 )
 
+// isDSRMode returns true if the backends answer the clients directly in the
+// passed load balancing mode, through direct routing or IPIP tunneling
+func isDSRMode(mode string) bool {
+	return mode == loadBalancerModeDSR || mode == loadBalancerModeTunnel
+}
+
 func (n *network) Name() string {
 	n.Lock()
 	defer n.Unlock()
@@ -370,6 +527,11 @@ func (i *IpamInfo) CopyTo(dstI *IpamInfo) error {
 }
 
 func (n *network) validateConfiguration() error {
+	if n.dnsConfig != nil {
+		if err := n.dnsConfig.Validate(); err != nil {
+			return err
+		}
+	}
 	if n.configOnly {
 		// Only supports network specific configurations.
 		// Network operator configurations are not supported.
@@ -387,7 +549,8 @@ func (n *network) validateConfiguration() error {
 			n.ipamType != defaultIpamForNetworkType(n.networkType) ||
 			n.enableIPv6 ||
 			len(n.labels) > 0 || len(n.ipamOptions) > 0 ||
-			len(n.ipamV4Config) > 0 || len(n.ipamV6Config) > 0 {
+			len(n.ipamV4Config) > 0 || len(n.ipamV6Config) > 0 ||
+			n.dnsConfig != nil {
 			return types.ForbiddenErrorf("user specified configurations are not supported if the network depends on a configuration network")
 		}
 		if len(n.generic) > 0 {
@@ -452,6 +615,10 @@ func (n *network) applyConfigurationTo(to *network) error {
 			to.generic[k] = v
 		}
 	}
+	if n.dnsConfig != nil {
+		to.dnsConfig = &DNSConfig{}
+		n.dnsConfig.CopyTo(to.dnsConfig)
+	}
 	return nil
 }
 
@@ -526,6 +693,29 @@ func (n *network) CopyTo(o datastore.KVObject) error {
 		dstN.generic[k] = v
 	}
 
+	if n.dnsConfig != nil {
+		dstN.dnsConfig = &DNSConfig{}
+		n.dnsConfig.CopyTo(dstN.dnsConfig)
+	}
+
+	dstN.dnsViews = nil
+	for _, r := range n.dnsViews {
+		dstR := *r
+		dstR.Addresses = append([]net.IP(nil), r.Addresses...)
+		dstN.dnsViews = append(dstN.dnsViews, &dstR)
+	}
+
+	dstN.dnsWeights = nil
+	if len(n.dnsWeights) > 0 {
+		dstN.dnsWeights = make(map[string][]*DNSRecordWeight, len(n.dnsWeights))
+		for name, weights := range n.dnsWeights {
+			for _, w := range weights {
+				dstW := *w
+				dstN.dnsWeights[name] = append(dstN.dnsWeights[name], &dstW)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -599,6 +789,27 @@ func (n *network) MarshalJSON() ([]byte, error) {
 	netMap["configFrom"] = n.configFrom
 	netMap["loadBalancerIP"] = n.loadBalancerIP
 	netMap["loadBalancerMode"] = n.loadBalancerMode
+	if n.dnsConfig != nil {
+		dc, err := json.Marshal(n.dnsConfig)
+		if err != nil {
+			return nil, err
+		}
+		netMap["dnsConfig"] = string(dc)
+	}
+	if len(n.dnsViews) > 0 {
+		dv, err := json.Marshal(n.dnsViews)
+		if err != nil {
+			return nil, err
+		}
+		netMap["dnsViews"] = string(dv)
+	}
+	if len(n.dnsWeights) > 0 {
+		dw, err := json.Marshal(n.dnsWeights)
+		if err != nil {
+			return nil, err
+		}
+		netMap["dnsWeights"] = string(dw)
+	}
 	return json.Marshal(netMap)
 }
 
@@ -716,6 +927,22 @@ func (n *network) UnmarshalJSON(b []byte) (err error) {
 	if v, ok := netMap["loadBalancerMode"]; ok {
 		n.loadBalancerMode = v.(string)
 	}
+	if v, ok := netMap["dnsConfig"]; ok {
+		n.dnsConfig = &DNSConfig{}
+		if err := json.Unmarshal([]byte(v.(string)), n.dnsConfig); err != nil {
+			return err
+		}
+	}
+	if v, ok := netMap["dnsViews"]; ok {
+		if err := json.Unmarshal([]byte(v.(string)), &n.dnsViews); err != nil {
+			return err
+		}
+	}
+	if v, ok := netMap["dnsWeights"]; ok {
+		if err := json.Unmarshal([]byte(v.(string)), &n.dnsWeights); err != nil {
+			return err
+		}
+	}
 	// Reconcile old networks with the recently added `--ipv6` flag
 	if !n.enableIPv6 {
 		n.enableIPv6 = len(n.ipamV6Info) > 0
@@ -878,6 +1105,14 @@ func NetworkOptionConfigFrom(name string) NetworkOption {
 	}
 }
 
+// NetworkOptionDNSConfig function returns an option setter for the embedded
+// DNS server configuration of the sandboxes attached to the network
+func NetworkOptionDNSConfig(config *DNSConfig) NetworkOption {
+	return func(n *network) {
+		n.dnsConfig = config
+	}
+}
+
 func (n *network) processOptions(options ...NetworkOption) {
 	for _, opt := range options {
 		if opt != nil {
@@ -1103,6 +1338,7 @@ removeFromStore:
 		return fmt.Errorf("error deleting network from store: %v", err)
 	}
 
+	c.publishNetwork(EventNetworkDelete, n)
 	return nil
 }
 
@@ -1113,6 +1349,10 @@ func (n *network) deleteNetwork() error {
 	}
 
 	if err := d.DeleteNetwork(n.ID()); err != nil {
+		if _, ok := err.(types.MaskableError); !ok {
+			n.getController().publishDriverError("DeleteNetwork", n, nil, err)
+		}
+
 		// Forbidden Errors should be honored
 		if _, ok := err.(types.ForbiddenError); ok {
 			return err
@@ -1126,6 +1366,13 @@ func (n *network) deleteNetwork() error {
 	for _, resolver := range n.resolver {
 		resolver.Stop()
 	}
+	c := n.getController()
+	c.stopMDNS(n.ID())
+	c.Lock()
+	delete(c.dnsViews, n.ID())
+	delete(c.dnsWeights, n.ID())
+	delete(c.endpointHealth, n.ID())
+	c.Unlock()
 	return nil
 }
 
@@ -1137,6 +1384,7 @@ func (n *network) addEndpoint(ep *endpoint) error {
 
 	err = d.CreateEndpoint(n.id, ep.id, ep.Interface(), ep.generic)
 	if err != nil {
+		n.getController().publishDriverError("CreateEndpoint", n, ep, err)
 		return types.InternalErrorf("failed to create endpoint %s on network %s: %v",
 			ep.Name(), n.Name(), err)
 	}
@@ -1145,6 +1393,10 @@ func (n *network) addEndpoint(ep *endpoint) error {
 }
 
 func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoint, error) {
+	return n.CreateEndpointContext(context.Background(), name, options...)
+}
+
+func (n *network) CreateEndpointContext(ctx context.Context, name string, options ...EndpointOption) (Endpoint, error) {
 	var err error
 	if !config.IsValidName(name) {
 		return nil, ErrInvalidName(name)
@@ -1161,12 +1413,19 @@ func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoi
 	n.ctrlr.networkLocker.Lock(n.id)
 	defer n.ctrlr.networkLocker.Unlock(n.id)
 
-	return n.createEndpoint(name, options...)
+	ctx = correlation.Ensure(ctx)
+	ctx, span := tracing.Start(ctx, "libnetwork.CreateEndpoint",
+		append(n.spanAttrs(), tracing.String("endpoint.name", name), correlationAttr(correlation.ID(ctx)))...)
+	ep, err := n.createEndpoint(ctx, name, options...)
+	tracing.End(span, err)
+	return ep, err
 
 }
 
-func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoint, error) {
+func (n *network) createEndpoint(ctx context.Context, name string, options ...EndpointOption) (Endpoint, error) {
 	var err error
+	ctx = correlation.Ensure(ctx)
+	log := correlation.Logger(ctx)
 
 	ep := &endpoint{name: name, generic: make(map[string]interface{}), iface: &endpointInterface{}}
 	ep.id = stringid.GenerateRandomID()
@@ -1183,6 +1442,22 @@ func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoi
 
 	ep.processOptions(options...)
 
+	if !validLBScheduler(ep.svcScheduler) {
+		return nil, types.BadRequestErrorf("invalid load balancing scheduler %q, valid schedulers: %v", ep.svcScheduler, LBSchedulers)
+	}
+
+	if ep.svcPersistence < 0 || ep.svcPersistence%time.Second != 0 {
+		return nil, types.BadRequestErrorf("invalid session affinity timeout %v, must be a whole number of seconds", ep.svcPersistence)
+	}
+
+	if ep.svcWeight < 0 || ep.svcWeight > MaxLBWeight {
+		return nil, types.BadRequestErrorf("invalid load balancing weight %d, must be between 0 and %d", ep.svcWeight, MaxLBWeight)
+	}
+
+	if err = validateSysctls(ep.sysctls, true); err != nil {
+		return nil, err
+	}
+
 	for _, llIPNet := range ep.Iface().LinkLocalAddresses() {
 		if !llIPNet.IP.IsLinkLocalUnicast() {
 			return nil, types.BadRequestErrorf("invalid link local IP address: %v", llIPNet.IP)
@@ -1210,7 +1485,12 @@ func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoi
 		ep.ipamOptions[netlabel.MacAddress] = ep.iface.mac.String()
 	}
 
-	if err = ep.assignAddress(ipam, true, n.enableIPv6 && !n.postIPv6); err != nil {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err = tracing.Trace(ctx, "ipam.RequestAddress", func() error {
+		return ep.assignAddress(ipam, true, n.enableIPv6 && !n.postIPv6)
+	}, n.spanAttrs()...); err != nil {
 		return nil, err
 	}
 	defer func() {
@@ -1219,31 +1499,39 @@ func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoi
 		}
 	}()
 
-	if err = n.addEndpoint(ep); err != nil {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err = tracing.Trace(ctx, "driver.CreateEndpoint", func() error { return n.addEndpoint(ep) }, n.driverSpanAttrs()...); err != nil {
 		return nil, err
 	}
 	defer func() {
 		if err != nil {
 			if e := ep.deleteEndpoint(false); e != nil {
-				logrus.Warnf("cleaning up endpoint failed %s : %v", name, e)
+				log.Warnf("cleaning up endpoint failed %s : %v", name, e)
 			}
 		}
 	}()
 
 	// We should perform updateToStore call right after addEndpoint
 	// in order to have iface properly configured
-	if err = n.getController().updateToStore(ep); err != nil {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err = n.getController().updateToStoreContext(ctx, ep); err != nil {
 		return nil, err
 	}
 	defer func() {
 		if err != nil {
-			if e := n.getController().deleteFromStore(ep); e != nil {
-				logrus.Warnf("error rolling back endpoint %s from store: %v", name, e)
+			if e := n.getController().deleteFromStoreContext(ctx, ep); e != nil {
+				log.Warnf("error rolling back endpoint %s from store: %v", name, e)
 			}
 		}
 	}()
 
-	if err = ep.assignAddress(ipam, false, n.enableIPv6 && n.postIPv6); err != nil {
+	if err = tracing.Trace(ctx, "ipam.RequestAddress", func() error {
+		return ep.assignAddress(ipam, false, n.enableIPv6 && n.postIPv6)
+	}, n.spanAttrs()...); err != nil {
 		return nil, err
 	}
 
@@ -1260,6 +1548,8 @@ func (n *network) createEndpoint(name string, options ...EndpointOption) (Endpoi
 		return nil, err
 	}
 
+	log.Debugf("Created endpoint %s (%s) on network %s (%s)", ep.name, ep.id, n.Name(), n.ID())
+	n.getController().publishEndpoint(EventEndpointCreate, ep, nil)
 	return ep, nil
 }
 
@@ -1919,6 +2209,18 @@ func (n *network) Labels() map[string]string {
 	return lbls
 }
 
+func (n *network) DNSConfig() *DNSConfig {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.dnsConfig == nil {
+		return nil
+	}
+	dc := &DNSConfig{}
+	n.dnsConfig.CopyTo(dc)
+	return dc
+}
+
 func (n *network) TableEventRegister(tableName string, objType driverapi.ObjectType) error {
 	if !driverapi.IsValidType(objType) {
 		return fmt.Errorf("invalid object type %v in registering table, %s", objType, tableName)
@@ -1979,6 +2281,10 @@ func (n *network) ResolveName(req string, ipType int) ([]net.IP, bool) {
 				ipLocal = append(ipLocal, net.ParseIP(ip.(svcMapEntry).ip))
 			}
 		}
+		ipLocal = c.filterUnhealthy(n.ID(), ipLocal)
+		if weights := c.dnsWeights[n.ID()][req]; len(weights) > 0 {
+			ipLocal = selectWeighted(ipLocal, weights)
+		}
 		return ipLocal, ok
 	}
 
@@ -2101,6 +2407,18 @@ func (n *network) NdotsSet() bool {
 	return false
 }
 
+func (n *network) NAT64Prefix() *net.IPNet {
+	dc := n.DNSConfig()
+	if dc == nil || dc.NAT64Prefix == "" {
+		return nil
+	}
+	prefix, err := validateNAT64Prefix(dc.NAT64Prefix)
+	if err != nil {
+		return nil
+	}
+	return prefix
+}
+
 // config-only network is looked up by name
 func (c *controller) getConfigNetwork(name string) (*network, error) {
 	var n Network
@@ -2134,14 +2452,14 @@ func (n *network) lbEndpointName() string {
 	return n.name + "-endpoint"
 }
 
-func (n *network) createLoadBalancerSandbox() (retErr error) {
+func (n *network) createLoadBalancerSandbox(ctx context.Context) (retErr error) {
 	sandboxName := n.lbSandboxName()
 	// Mark the sandbox to be a load balancer
 	sbOptions := []SandboxOption{OptionLoadBalancer(n.id)}
 	if n.ingress {
 		sbOptions = append(sbOptions, OptionIngress())
 	}
-	sb, err := n.ctrlr.NewSandbox(sandboxName, sbOptions...)
+	sb, err := n.ctrlr.NewSandboxContext(ctx, sandboxName, sbOptions...)
 	if err != nil {
 		return err
 	}
@@ -2162,7 +2480,7 @@ func (n *network) createLoadBalancerSandbox() (retErr error) {
 		// Mark LB endpoints as anonymous so they don't show up in DNS
 		epOptions = append(epOptions, CreateOptionAnonymous())
 	}
-	ep, err := n.createEndpoint(endpointName, epOptions...)
+	ep, err := n.createEndpoint(ctx, endpointName, epOptions...)
 	if err != nil {
 		return err
 	}
@@ -2174,7 +2492,7 @@ func (n *network) createLoadBalancerSandbox() (retErr error) {
 		}
 	}()
 
-	if err := ep.Join(sb, nil); err != nil {
+	if err := ep.JoinContext(ctx, sb, nil); err != nil {
 		return err
 	}
 
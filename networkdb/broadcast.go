@@ -46,6 +46,7 @@ func (nDB *NetworkDB) sendNetworkEvent(nid string, event NetworkEvent_Type, ltim
 		id:   nid,
 		node: nDB.config.NodeID,
 	})
+	nDB.broadcastQueued()
 	return nil
 }
 
@@ -85,6 +86,7 @@ func (nDB *NetworkDB) sendNodeEvent(event NodeEvent_Type) error {
 		msg:    raw,
 		notify: notifyCh,
 	})
+	nDB.broadcastQueued()
 
 	nDB.RLock()
 	noPeers := len(nDB.nodes) <= 1
@@ -168,5 +170,6 @@ func (nDB *NetworkDB) sendTableEvent(event TableEvent_Type, nid string, tname st
 		tname: tname,
 		key:   key,
 	})
+	nDB.broadcastQueued()
 	return nil
 }
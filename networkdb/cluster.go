@@ -111,6 +111,10 @@ func (nDB *NetworkDB) clusterInit() error {
 	config.BindAddr = nDB.config.BindAddr
 	config.AdvertiseAddr = nDB.config.AdvertiseAddr
 	config.UDPBufferSize = nDB.config.PacketBufferSize
+	config.GossipInterval = nDB.config.GossipInterval
+	config.PushPullInterval = nDB.config.PushPullInterval
+	config.GossipNodes = nDB.config.GossipNodes
+	config.RetransmitMult = nDB.config.RetransmitMult
 
 	if nDB.config.BindPort != 0 {
 		config.BindPort = nDB.config.BindPort
@@ -124,6 +128,15 @@ func (nDB *NetworkDB) clusterInit() error {
 	config.Logger = log.New(&logWriter{}, "", 0)
 
 	var err error
+	if nDB.config.TCPOnly {
+		t, err := newTCPTransport(config.BindAddr, config.BindPort)
+		if err != nil {
+			return err
+		}
+		config.Transport = t
+		tcpOnlyTimings(config)
+	}
+
 	if len(nDB.config.Keys) > 0 {
 		for i, key := range nDB.config.Keys {
 			logrus.Debugf("Encryption key %d: %.5s", i+1, hex.EncodeToString(key))
@@ -157,6 +170,9 @@ func (nDB *NetworkDB) clusterInit() error {
 
 	mlist, err := memberlist.Create(config)
 	if err != nil {
+		if config.Transport != nil {
+			config.Transport.Shutdown()
+		}
 		return fmt.Errorf("failed to create memberlist: %v", err)
 	}
 
@@ -166,18 +182,34 @@ func (nDB *NetworkDB) clusterInit() error {
 	for _, trigger := range []struct {
 		interval time.Duration
 		fn       func()
+		reset    *chan time.Duration
 	}{
-		{reapPeriod, nDB.reapState},
-		{config.GossipInterval, nDB.gossip},
-		{config.PushPullInterval, nDB.bulkSyncTables},
-		{retryInterval, nDB.reconnectNode},
-		{nodeReapPeriod, nDB.reapDeadNode},
-		{rejoinInterval, nDB.rejoinClusterBootStrap},
+		{reapPeriod, nDB.reapState, nil},
+		{config.GossipInterval, nDB.gossip, &nDB.gossipReset},
+		{config.PushPullInterval, nDB.bulkSyncTables, &nDB.bulkSyncReset},
+		{retryInterval, nDB.reconnectNode, nil},
+		{nodeReapPeriod, nDB.reapDeadNode, nil},
+		{rejoinInterval, nDB.rejoinClusterBootStrap, nil},
+		{reapPeriod, nDB.reapRestoredEntries, nil},
+		{partitionCheckPeriod, nDB.checkPartitions, nil},
 	} {
+		if trigger.reset != nil {
+			reset := make(chan time.Duration, 1)
+			nDB.Lock()
+			*trigger.reset = reset
+			nDB.Unlock()
+			go nDB.resettableTriggerFunc(trigger.interval, reset, trigger.fn)
+			continue
+		}
 		t := time.NewTicker(trigger.interval)
 		go nDB.triggerFunc(trigger.interval, t.C, trigger.fn)
 		nDB.tickers = append(nDB.tickers, t)
 	}
+	if nDB.config.SnapshotPath != "" {
+		t := time.NewTicker(nDB.config.SnapshotInterval)
+		go nDB.triggerFunc(nDB.config.SnapshotInterval, t.C, nDB.saveSnapshot)
+		nDB.tickers = append(nDB.tickers, t)
+	}
 
 	return nil
 }
@@ -260,6 +292,33 @@ func (nDB *NetworkDB) triggerFunc(stagger time.Duration, C <-chan time.Time, f f
 	}
 }
 
+// resettableTriggerFunc calls f every interval, the interval being changed
+// by the ones received on reset. The ticker is owned by the goroutine, and
+// stopped with the context of nDB.
+func (nDB *NetworkDB) resettableTriggerFunc(interval time.Duration, reset <-chan time.Duration, f func()) {
+	t := time.NewTicker(interval)
+	defer func() { t.Stop() }()
+
+	// Use a random stagger to avoid synchronizing
+	randStagger := time.Duration(uint64(rnd.Int63()) % uint64(interval))
+	select {
+	case <-time.After(randStagger):
+	case <-nDB.ctx.Done():
+		return
+	}
+	for {
+		select {
+		case <-t.C:
+			f()
+		case interval := <-reset:
+			t.Stop()
+			t = time.NewTicker(interval)
+		case <-nDB.ctx.Done():
+			return
+		}
+	}
+}
+
 func (nDB *NetworkDB) reapDeadNode() {
 	nDB.Lock()
 	defer nDB.Unlock()
@@ -441,6 +500,7 @@ func (nDB *NetworkDB) gossip() {
 	}
 	printStats := time.Since(nDB.lastStatsTimestamp) >= nDB.config.StatsPrintPeriod
 	printHealth := time.Since(nDB.lastHealthTimestamp) >= nDB.config.HealthPrintPeriod
+	gossipNodes := nDB.config.GossipNodes
 	nDB.RUnlock()
 
 	if printHealth {
@@ -452,7 +512,7 @@ func (nDB *NetworkDB) gossip() {
 	}
 
 	for nid, nodes := range networkNodes {
-		mNodes := nDB.mRandomNodes(3, nodes)
+		mNodes := nDB.mRandomNodes(gossipNodes, nodes)
 		bytesAvail := nDB.config.PacketBufferSize - compoundHeaderOverhead
 
 		nDB.RLock()
@@ -474,6 +534,7 @@ func (nDB *NetworkDB) gossip() {
 		}
 
 		msgs := broadcastQ.GetBroadcasts(compoundOverhead, bytesAvail)
+		nDB.transmitted(len(msgs))
 		// Collect stats and print the queue info, note this code is here also to have a view of the queues empty
 		network.qMessagesSent += len(msgs)
 		if printStats {
@@ -568,7 +629,9 @@ func (nDB *NetworkDB) bulkSyncTables() {
 }
 
 func (nDB *NetworkDB) bulkSync(nodes []string, all bool) ([]string, error) {
-	if !all {
+	if all {
+		nodes = nDB.withoutQuarantined(nodes)
+	} else {
 		// Get 2 random nodes. 2nd node will be tried if the bulk sync to
 		// 1st node fails.
 		nodes = nDB.mRandomNodes(2, nodes)
@@ -588,6 +651,7 @@ func (nDB *NetworkDB) bulkSync(nodes []string, all bool) ([]string, error) {
 		logrus.Debugf("%v(%v): Initiating bulk sync with node %v", nDB.config.Hostname, nDB.config.NodeID, node)
 		networks = nDB.findCommonNetworks(node)
 		err = nDB.bulkSyncNode(networks, node, true)
+		nDB.bulkSynced(err)
 		if err != nil {
 			err = fmt.Errorf("bulk sync to node %s failed: %v", node, err)
 			logrus.Warn(err.Error())
@@ -633,7 +697,9 @@ func (nDB *NetworkDB) bulkSyncNode(networks []string, node string, unsolicited b
 	for _, nid := range networks {
 		nDB.indexes[byNetwork].WalkPrefix(fmt.Sprintf("/%s", nid), func(path string, v interface{}) bool {
 			entry, ok := v.(*entry)
-			if !ok {
+			// restored entries are not gossiped, they are only known
+			// from the snapshot of this node
+			if !ok || entry.restored {
 				return false
 			}
 
@@ -731,6 +797,7 @@ func randomOffset(n int) int {
 // mRandomNodes is used to select up to m random nodes. It is possible
 // that less than m nodes are returned.
 func (nDB *NetworkDB) mRandomNodes(m int, nodes []string) []string {
+	nodes = nDB.withoutQuarantined(nodes)
 	n := len(nodes)
 	mNodes := make([]string, 0, m)
 OUTER:
@@ -0,0 +1,56 @@
+package networkdb
+
+// EntryVersion is the version of a table entry known by a node
+type EntryVersion struct {
+	// Node is the node which wrote the version
+	Node string
+	// LTime is the Lamport time of the version
+	LTime uint64
+	// Value of the entry
+	Value []byte
+}
+
+// ConflictResolver returns the value of an entry written by several nodes,
+// from the local version and the one received from the cluster. The nodes
+// converge to the same value as long as the resolver is deterministic,
+// commutative and idempotent, as a set union is.
+type ConflictResolver func(tname, nid, key string, local, remote EntryVersion) []byte
+
+// SetConflictResolver registers the resolver of the conflicts of the
+// entries of a table, replacing last writer wins. A nil resolver restores
+// last writer wins.
+func (nDB *NetworkDB) SetConflictResolver(tname string, r ConflictResolver) {
+	nDB.Lock()
+	defer nDB.Unlock()
+	if r == nil {
+		delete(nDB.resolvers, tname)
+		return
+	}
+	if nDB.resolvers == nil {
+		nDB.resolvers = make(map[string]ConflictResolver)
+	}
+	nDB.resolvers[tname] = r
+}
+
+// resolveConflict merges the local entry with the version of the event,
+// when both are live versions written by different nodes and the table has
+// a resolver. The merged entry takes the node and the time of the newest
+// version. It returns false when last writer wins applies. nDB must be
+// locked.
+func (nDB *NetworkDB) resolveConflict(tEvent *TableEvent, e *entry) (*entry, bool) {
+	r, ok := nDB.resolvers[tEvent.TableName]
+	if !ok || e.deleting || tEvent.Type == TableEventTypeDelete || e.node == tEvent.NodeName {
+		return nil, false
+	}
+
+	value := r(tEvent.TableName, tEvent.NetworkID, tEvent.Key,
+		EntryVersion{Node: e.node, LTime: uint64(e.ltime), Value: e.value},
+		EntryVersion{Node: tEvent.NodeName, LTime: uint64(tEvent.LTime), Value: tEvent.Value})
+
+	merged := &entry{node: e.node, ltime: e.ltime, value: value}
+	if tEvent.LTime > e.ltime {
+		merged.node = tEvent.NodeName
+		merged.ltime = tEvent.LTime
+	}
+	return merged, true
+}
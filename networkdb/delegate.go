@@ -1,9 +1,11 @@
 package networkdb
 
 import (
+	"bytes"
 	"net"
 	"time"
 
+	"github.com/docker/libnetwork/faults"
 	"github.com/gogo/protobuf/proto"
 	"github.com/sirupsen/logrus"
 )
@@ -27,6 +29,7 @@ func (nDB *NetworkDB) handleNodeEvent(nEvent *NodeEvent) bool {
 	// check if the node exists
 	n, _, _ := nDB.findNode(nEvent.NodeName)
 	if n == nil {
+		nDB.heardOfUnknown(nEvent.NodeName)
 		return false
 	}
 
@@ -79,7 +82,7 @@ func (nDB *NetworkDB) handleNetworkEvent(nEvent *NetworkEvent) bool {
 	nDB.Lock()
 	defer nDB.Unlock()
 
-	if nEvent.NodeName == nDB.config.NodeID {
+	if nEvent.NodeName == nDB.config.NodeID || nDB.isQuarantined(nEvent.NodeName) {
 		return false
 	}
 
@@ -129,6 +132,7 @@ func (nDB *NetworkDB) handleNetworkEvent(nEvent *NetworkEvent) bool {
 	// If the node is not known from memberlist we cannot process save any state of it else if it actually
 	// dies we won't receive any notification and we will remain stuck with it
 	if _, ok := nDB.nodes[nEvent.NodeName]; !ok {
+		nDB.notMember(nEvent.NodeName, nEvent.LTime, false)
 		return false
 	}
 
@@ -148,6 +152,10 @@ func (nDB *NetworkDB) handleTableEvent(tEvent *TableEvent, isBulkSync bool) bool
 
 	// Ignore the table events for networks that are in the process of going away
 	nDB.RLock()
+	if nDB.isQuarantined(tEvent.NodeName) {
+		nDB.RUnlock()
+		return false
+	}
 	networks := nDB.networks[nDB.config.NodeID]
 	network, ok := networks[tEvent.NetworkID]
 	// Check if the owner of the event is still part of the network
@@ -159,6 +167,12 @@ func (nDB *NetworkDB) handleTableEvent(tEvent *TableEvent, isBulkSync bool) bool
 			break
 		}
 	}
+	// the bulk syncs relay the entries of the failed nodes
+	if !nodePresent && !isBulkSync {
+		if _, ok := nDB.nodes[tEvent.NodeName]; !ok {
+			nDB.notMember(tEvent.NodeName, tEvent.LTime, true)
+		}
+	}
 	nDB.RUnlock()
 
 	if !ok || network.leaving || !nodePresent {
@@ -168,10 +182,27 @@ func (nDB *NetworkDB) handleTableEvent(tEvent *TableEvent, isBulkSync bool) bool
 
 	nDB.Lock()
 	e, err := nDB.getEntry(tEvent.TableName, tEvent.NetworkID, tEvent.Key)
+	// the cluster already knows the entries this node restored
+	var confirmed bool
 	if err == nil {
+		if merged, ok := nDB.resolveConflict(tEvent, e); ok {
+			changed := !bytes.Equal(merged.value, e.value)
+			newer := tEvent.LTime > e.ltime
+			if changed || newer {
+				nDB.createOrUpdateEntry(tEvent.NetworkID, tEvent.TableName, tEvent.Key, merged)
+			}
+			nDB.Unlock()
+			if changed {
+				nDB.broadcaster.Write(makeEvent(opUpdate, tEvent.TableName, tEvent.NetworkID, tEvent.Key, merged.value))
+			}
+			// only the newer versions are propagated
+			return newer && network.inSync
+		}
+		confirmed = e.restored && e.ltime == tEvent.LTime
 		// We have the latest state. Ignore the event
-		// since it is stale.
-		if e.ltime >= tEvent.LTime {
+		// since it is stale. An entry restored from the snapshot is
+		// replaced by the same state received from the cluster.
+		if e.ltime > tEvent.LTime || (e.ltime == tEvent.LTime && !e.restored) {
 			nDB.Unlock()
 			return false
 		}
@@ -185,6 +216,14 @@ func (nDB *NetworkDB) handleTableEvent(tEvent *TableEvent, isBulkSync bool) bool
 		return false
 	}
 
+	if tEvent.Type != TableEventTypeDelete {
+		if reason := nDB.checkQuota(tEvent.TableName, tEvent.NetworkID, len(tEvent.Value), err != nil || e.deleting); reason != "" {
+			nDB.Unlock()
+			nDB.rejected(tEvent.TableName, tEvent.NetworkID, tEvent.Key, tEvent.NodeName, reason)
+			return false
+		}
+	}
+
 	e = &entry{
 		ltime:    tEvent.LTime,
 		node:     tEvent.NodeName,
@@ -196,10 +235,15 @@ func (nDB *NetworkDB) handleTableEvent(tEvent *TableEvent, isBulkSync bool) bool
 	// All the entries marked for deletion should have a reapTime set greater than 0
 	// This case can happen if the cluster is running different versions of the engine where the old version does not have the
 	// field. If that is not the case, this can be a BUG
+	reapInterval := nDB.reapInterval(tEvent.TableName)
 	if e.deleting && e.reapTime == 0 {
 		logrus.Warnf("%v(%v) handleTableEvent object %+v has a 0 reapTime, is the cluster running the same docker engine version?",
 			nDB.config.Hostname, nDB.config.NodeID, tEvent)
-		e.reapTime = nDB.config.reapEntryInterval
+		e.reapTime = reapInterval
+	}
+	// the tombstones don't outlive the reap interval of the table
+	if e.reapTime > reapInterval {
+		e.reapTime = reapInterval
 	}
 	nDB.createOrUpdateEntry(tEvent.NetworkID, tEvent.TableName, tEvent.Key, e)
 	nDB.Unlock()
@@ -215,7 +259,7 @@ func (nDB *NetworkDB) handleTableEvent(tEvent *TableEvent, isBulkSync bool) bool
 		// This also reduce the possibility that deletion of entries close to their garbage collection ends up circuling around
 		// forever
 		//logrus.Infof("exiting on delete not knowing the obj with rebroadcast:%t", network.inSync)
-		return network.inSync && e.reapTime > nDB.config.reapEntryInterval/6
+		return network.inSync && e.reapTime > reapInterval/6
 	}
 
 	var op opType
@@ -229,7 +273,7 @@ func (nDB *NetworkDB) handleTableEvent(tEvent *TableEvent, isBulkSync bool) bool
 	}
 
 	nDB.broadcaster.Write(makeEvent(op, tEvent.TableName, tEvent.NetworkID, tEvent.Key, tEvent.Value))
-	return network.inSync
+	return network.inSync && !confirmed
 }
 
 func (nDB *NetworkDB) handleCompound(buf []byte, isBulkSync bool) {
@@ -344,7 +388,17 @@ func (nDB *NetworkDB) handleBulkSync(buf []byte) {
 		nDB.tableClock.Witness(bsm.LTime)
 	}
 
-	nDB.handleMessage(bsm.Payload, true)
+	nDB.RLock()
+	quarantined := nDB.isQuarantined(bsm.NodeName)
+	// a bulk sync is a direct contact with its sender
+	if _, ok := nDB.nodes[bsm.NodeName]; !ok {
+		nDB.notMember(bsm.NodeName, 0, false)
+	}
+	nDB.RUnlock()
+	// the entries relayed by a quarantined node are not trusted either
+	if !quarantined {
+		nDB.handleMessage(bsm.Payload, true)
+	}
 
 	// Don't respond to a bulk sync which was not unsolicited
 	if !bsm.Unsolicited {
@@ -358,6 +412,9 @@ func (nDB *NetworkDB) handleBulkSync(buf []byte) {
 
 		return
 	}
+	if quarantined {
+		return
+	}
 
 	var nodeAddr net.IP
 	nDB.RLock()
@@ -395,7 +452,7 @@ func (nDB *NetworkDB) handleMessage(buf []byte, isBulkSync bool) {
 }
 
 func (d *delegate) NotifyMsg(buf []byte) {
-	if len(buf) == 0 {
+	if len(buf) == 0 || faults.Drop(faults.GossipDrop) {
 		return
 	}
 
@@ -405,6 +462,7 @@ func (d *delegate) NotifyMsg(buf []byte) {
 func (d *delegate) GetBroadcasts(overhead, limit int) [][]byte {
 	msgs := d.nDB.networkBroadcasts.GetBroadcasts(overhead, limit)
 	msgs = append(msgs, d.nDB.nodeBroadcasts.GetBroadcasts(overhead, limit)...)
+	d.nDB.transmitted(len(msgs))
 	return msgs
 }
 
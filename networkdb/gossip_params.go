@@ -0,0 +1,143 @@
+package networkdb
+
+import (
+	"time"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/hashicorp/memberlist"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultGossipNodes    = 3
+	defaultRetransmitMult = 4
+
+	minGossipInterval   = 10 * time.Millisecond
+	maxGossipInterval   = 10 * time.Second
+	minPushPullInterval = time.Second
+	maxPushPullInterval = 10 * time.Minute
+	maxGossipNodes      = 32
+	maxRetransmitMult   = 32
+)
+
+// GossipParams are the gossip tunables of NetworkDB. Shorter intervals and
+// a higher fanout speed up the convergence of the tables at the cost of
+// bandwidth. A zero value leaves the parameter unchanged.
+type GossipParams struct {
+	// GossipInterval is the period the table events are gossiped at
+	GossipInterval time.Duration `json:"gossip_interval"`
+	// PushPullInterval is the period of the bulk syncs of the tables with
+	// a random node
+	PushPullInterval time.Duration `json:"push_pull_interval"`
+	// GossipNodes is the number of random nodes the table events are
+	// gossiped to every GossipInterval
+	GossipNodes int `json:"gossip_nodes"`
+	// RetransmitMult is the multiplier of the number of times an event is
+	// gossiped, RetransmitMult * log(N+1) for a N nodes network
+	RetransmitMult int `json:"retransmit_mult"`
+}
+
+// Validate checks the parameters are within the safe bounds
+func (p GossipParams) Validate() error {
+	if p.GossipInterval != 0 && (p.GossipInterval < minGossipInterval || p.GossipInterval > maxGossipInterval) {
+		return types.BadRequestErrorf("gossip interval %v out of the [%v, %v] range", p.GossipInterval, minGossipInterval, maxGossipInterval)
+	}
+	if p.PushPullInterval != 0 && (p.PushPullInterval < minPushPullInterval || p.PushPullInterval > maxPushPullInterval) {
+		return types.BadRequestErrorf("push pull interval %v out of the [%v, %v] range", p.PushPullInterval, minPushPullInterval, maxPushPullInterval)
+	}
+	if p.GossipNodes < 0 || p.GossipNodes > maxGossipNodes {
+		return types.BadRequestErrorf("gossip nodes %d out of the [1, %d] range", p.GossipNodes, maxGossipNodes)
+	}
+	if p.RetransmitMult < 0 || p.RetransmitMult > maxRetransmitMult {
+		return types.BadRequestErrorf("retransmit multiplier %d out of the [1, %d] range", p.RetransmitMult, maxRetransmitMult)
+	}
+	return nil
+}
+
+// gossipDefaults fills the parameters missing in the config
+func (c *Config) gossipDefaults() {
+	lan := memberlist.DefaultLANConfig()
+	if c.GossipInterval == 0 {
+		c.GossipInterval = lan.GossipInterval
+	}
+	if c.PushPullInterval == 0 {
+		c.PushPullInterval = lan.PushPullInterval
+	}
+	if c.GossipNodes == 0 {
+		c.GossipNodes = defaultGossipNodes
+	}
+	if c.RetransmitMult == 0 {
+		c.RetransmitMult = defaultRetransmitMult
+	}
+}
+
+// GossipParams returns the gossip parameters in use
+func (nDB *NetworkDB) GossipParams() GossipParams {
+	nDB.RLock()
+	defer nDB.RUnlock()
+	return GossipParams{
+		GossipInterval:   nDB.config.GossipInterval,
+		PushPullInterval: nDB.config.PushPullInterval,
+		GossipNodes:      nDB.config.GossipNodes,
+		RetransmitMult:   nDB.config.RetransmitMult,
+	}
+}
+
+// SetGossipParams changes the gossip parameters at runtime. The gossip of
+// the memberlist membership keeps the parameters NetworkDB was created with.
+func (nDB *NetworkDB) SetGossipParams(p GossipParams) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	nDB.Lock()
+	defer nDB.Unlock()
+	if p.GossipInterval != 0 && p.GossipInterval != nDB.config.GossipInterval {
+		nDB.config.GossipInterval = p.GossipInterval
+		resetInterval(nDB.gossipReset, p.GossipInterval)
+	}
+	if p.PushPullInterval != 0 && p.PushPullInterval != nDB.config.PushPullInterval {
+		nDB.config.PushPullInterval = p.PushPullInterval
+		resetInterval(nDB.bulkSyncReset, p.PushPullInterval)
+	}
+	if p.GossipNodes != 0 {
+		nDB.config.GossipNodes = p.GossipNodes
+	}
+	if p.RetransmitMult != 0 && p.RetransmitMult != nDB.config.RetransmitMult {
+		nDB.config.RetransmitMult = p.RetransmitMult
+		for _, q := range []*memberlist.TransmitLimitedQueue{nDB.nodeBroadcasts, nDB.networkBroadcasts} {
+			setRetransmitMult(q, p.RetransmitMult)
+		}
+		for _, n := range nDB.networks[nDB.config.NodeID] {
+			setRetransmitMult(n.tableBroadcasts, p.RetransmitMult)
+		}
+	}
+	logrus.Infof("%v(%v): gossip parameters set to interval:%v push-pull:%v nodes:%d retransmit-mult:%d", nDB.config.Hostname, nDB.config.NodeID,
+		nDB.config.GossipInterval, nDB.config.PushPullInterval, nDB.config.GossipNodes, nDB.config.RetransmitMult)
+	return nil
+}
+
+// resetInterval hands the interval to the trigger of reset, in place of the
+// one it has not received yet. It is called with the lock of nDB held,
+// which orders the senders.
+func resetInterval(reset chan time.Duration, interval time.Duration) {
+	if reset == nil {
+		return
+	}
+	select {
+	case <-reset:
+	default:
+	}
+	reset <- interval
+}
+
+// setRetransmitMult changes the multiplier of a queue, the queue reads it
+// with its lock held
+func setRetransmitMult(q *memberlist.TransmitLimitedQueue, mult int) {
+	if q == nil {
+		return
+	}
+	q.Lock()
+	q.RetransmitMult = mult
+	q.Unlock()
+}
@@ -0,0 +1,170 @@
+package networkdb
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/libnetwork/metrics"
+)
+
+var (
+	broadcastsCounter = metrics.NewCounter("networkdb", "broadcasts_total", "Messages queued for gossip")
+	transmitsCounter  = metrics.NewCounter("networkdb", "transmits_total", "Messages gossiped, retransmissions included")
+	bulkSyncsCounter  = metrics.NewCounter("networkdb", "bulk_syncs_total", "Bulk syncs initiated by this node", "result")
+	rejectedCounter   = metrics.NewCounter("networkdb", "rejected_entries_total", "Table entries rejected for exceeding the quota of their table", "table")
+)
+
+// counters are the cumulative NetworkDB counters, updated atomically
+type counters struct {
+	broadcasts       uint64
+	transmits        uint64
+	bulkSyncs        uint64
+	bulkSyncFailures uint64
+	rejected         uint64
+}
+
+// NetworkMetrics is the state of a network this node participates in
+type NetworkMetrics struct {
+	// Peers is the number of nodes participating in the network
+	Peers int `json:"peers"`
+	// QueueLen is the number of table events waiting to be gossiped
+	QueueLen int `json:"qlen"`
+	// Entries is the number of live table entries, Tables the same number
+	// per table
+	Entries int            `json:"entries"`
+	Tables  map[string]int `json:"tables"`
+	// Tombstones is the number of deleted entries waiting to be garbage
+	// collected
+	Tombstones int `json:"tombstones"`
+	// InSync is true once the bulk sync following the join completed
+	InSync bool `json:"in_sync"`
+	// Convergence is the time the bulk sync following the join took
+	Convergence time.Duration `json:"convergence"`
+}
+
+// Metrics is a point in time snapshot of the NetworkDB state and counters
+type Metrics struct {
+	Nodes       int `json:"nodes"`
+	FailedNodes int `json:"failed_nodes"`
+	LeftNodes   int `json:"left_nodes"`
+	// HealthScore is the memberlist awareness score of this node, zero
+	// when healthy. Higher values mean connectivity issues
+	HealthScore int `json:"health_score"`
+	// NodeQueueLen and NetworkQueueLen are the number of node and network
+	// events waiting to be gossiped
+	NodeQueueLen    int `json:"node_qlen"`
+	NetworkQueueLen int `json:"network_qlen"`
+	// Broadcasts is the number of messages queued for gossip, Transmits the
+	// number of messages gossiped. Every message is sent several times to
+	// reach all the nodes, Retransmits counts these additional sends
+	Broadcasts  uint64 `json:"broadcasts"`
+	Transmits   uint64 `json:"transmits"`
+	Retransmits uint64 `json:"retransmits"`
+	// BulkSyncs and BulkSyncFailures count the bulk syncs initiated by
+	// this node
+	BulkSyncs        uint64 `json:"bulk_syncs"`
+	BulkSyncFailures uint64 `json:"bulk_sync_failures"`
+	// Rejected is the number of entries rejected for exceeding the quota
+	// of their table
+	Rejected uint64                     `json:"rejected"`
+	Networks map[string]*NetworkMetrics `json:"networks"`
+}
+
+func (m *Metrics) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "nodes: %d, failed: %d, left: %d, healthscore: %d\n", m.Nodes, m.FailedNodes, m.LeftNodes, m.HealthScore)
+	fmt.Fprintf(&b, "node qlen: %d, network qlen: %d\n", m.NodeQueueLen, m.NetworkQueueLen)
+	fmt.Fprintf(&b, "broadcasts: %d, transmits: %d, retransmits: %d\n", m.Broadcasts, m.Transmits, m.Retransmits)
+	fmt.Fprintf(&b, "bulk syncs: %d, failed: %d\n", m.BulkSyncs, m.BulkSyncFailures)
+	fmt.Fprintf(&b, "rejected entries: %d\n", m.Rejected)
+	for nid, n := range m.Networks {
+		fmt.Fprintf(&b, "network %s: peers: %d, qlen: %d, entries: %d, tombstones: %d, in sync: %t, convergence: %v\n", nid, n.Peers, n.QueueLen, n.Entries, n.Tombstones, n.InSync, n.Convergence)
+	}
+	return b.String()
+}
+
+func (nDB *NetworkDB) broadcastQueued() {
+	atomic.AddUint64(&nDB.counters.broadcasts, 1)
+	broadcastsCounter.Inc()
+}
+
+func (nDB *NetworkDB) transmitted(n int) {
+	atomic.AddUint64(&nDB.counters.transmits, uint64(n))
+	transmitsCounter.Add(float64(n))
+}
+
+func (nDB *NetworkDB) bulkSynced(err error) {
+	atomic.AddUint64(&nDB.counters.bulkSyncs, 1)
+	if err != nil {
+		atomic.AddUint64(&nDB.counters.bulkSyncFailures, 1)
+		bulkSyncsCounter.Inc("failure")
+		return
+	}
+	bulkSyncsCounter.Inc("success")
+}
+
+// Metrics returns a snapshot of the state and of the counters of the
+// NetworkDB instance
+func (nDB *NetworkDB) Metrics() *Metrics {
+	m := &Metrics{
+		Broadcasts:       atomic.LoadUint64(&nDB.counters.broadcasts),
+		Transmits:        atomic.LoadUint64(&nDB.counters.transmits),
+		BulkSyncs:        atomic.LoadUint64(&nDB.counters.bulkSyncs),
+		BulkSyncFailures: atomic.LoadUint64(&nDB.counters.bulkSyncFailures),
+		Rejected:         atomic.LoadUint64(&nDB.counters.rejected),
+		Networks:         make(map[string]*NetworkMetrics),
+	}
+	if m.Transmits > m.Broadcasts {
+		m.Retransmits = m.Transmits - m.Broadcasts
+	}
+	if nDB.memberlist != nil {
+		m.HealthScore = nDB.memberlist.GetHealthScore()
+	}
+	if nDB.nodeBroadcasts != nil {
+		m.NodeQueueLen = nDB.nodeBroadcasts.NumQueued()
+	}
+	if nDB.networkBroadcasts != nil {
+		m.NetworkQueueLen = nDB.networkBroadcasts.NumQueued()
+	}
+
+	nDB.RLock()
+	defer nDB.RUnlock()
+	m.Nodes = len(nDB.nodes)
+	m.FailedNodes = len(nDB.failedNodes)
+	m.LeftNodes = len(nDB.leftNodes)
+	for nid, n := range nDB.networks[nDB.config.NodeID] {
+		if n.leaving {
+			continue
+		}
+		nm := &NetworkMetrics{
+			Peers:       len(nDB.networkNodes[nid]),
+			InSync:      n.inSync,
+			Convergence: n.convergence,
+			Tables:      make(map[string]int),
+		}
+		if n.tableBroadcasts != nil {
+			nm.QueueLen = n.tableBroadcasts.NumQueued()
+		}
+		nDB.indexes[byNetwork].WalkPrefix("/"+nid+"/", func(path string, v interface{}) bool {
+			e, ok := v.(*entry)
+			if !ok {
+				return false
+			}
+			if e.deleting {
+				nm.Tombstones++
+				return false
+			}
+			// path is /<nid>/<table>/<key>
+			params := strings.SplitN(path[1:], "/", 3)
+			if len(params) == 3 {
+				nm.Tables[params[1]]++
+				nm.Entries++
+			}
+			return false
+		})
+		m.Networks[nid] = nm
+	}
+	return m
+}
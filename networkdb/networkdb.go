@@ -36,6 +36,9 @@ type NetworkDB struct {
 	// Global lamport clock for table events.
 	tableClock serf.LamportClock
 
+	// Counters exported as metrics, updated atomically
+	counters counters
+
 	sync.RWMutex
 
 	// NetworkDB configuration.
@@ -86,6 +89,28 @@ type NetworkDB struct {
 	// events.
 	broadcaster *events.Broadcaster
 
+	// Conflict resolvers of the tables, keyed by table name
+	resolvers map[string]ConflictResolver
+
+	// Reap intervals of the deleted entries of the tables, keyed by table
+	// name, overriding reapEntryInterval
+	tableReapIntervals map[string]time.Duration
+
+	// Quotas of the tables, keyed by <table>/<network id>, the quotas of
+	// a table in all the networks having an empty network id
+	tableQuotas map[string]TableQuota
+
+	// Symptoms of the partitions of the cluster
+	partitions partitionDetector
+
+	// Nodes administratively quarantined, their updates are ignored
+	quarantinedNodes map[string]struct{}
+
+	// Intervals handed to the triggers of the table gossip and of the bulk
+	// syncs, when the gossip parameters change
+	gossipReset   chan time.Duration
+	bulkSyncReset chan time.Duration
+
 	// List of all tickers which needed to be stopped when
 	// cleaning up.
 	tickers []*time.Ticker
@@ -151,6 +176,9 @@ type network struct {
 	// Its use is for statistics purposes. It keep tracks of database size and is printed per network every StatsPrintPeriod
 	// interval
 	entriesNumber int
+
+	// Time the bulk sync following the join took
+	convergence time.Duration
 }
 
 // Config represents the configuration of the networkdb instance and
@@ -199,6 +227,43 @@ type Config struct {
 	// HealthPrintPeriod the period to use to print the health score
 	// Default is 1min
 	HealthPrintPeriod time.Duration
+
+	// TCPOnly runs the gossip over TCP only, on BindPort, for the
+	// environments where UDP between the nodes is blocked. The failure
+	// detection is slowed down accordingly
+	TCPOnly bool
+
+	// GossipInterval is the period the table events are gossiped at
+	// Default is 200ms
+	GossipInterval time.Duration
+
+	// PushPullInterval is the period of the bulk syncs of the tables
+	// Default is 30s
+	PushPullInterval time.Duration
+
+	// GossipNodes is the number of nodes the table events are gossiped
+	// to every GossipInterval
+	// Default is 3
+	GossipNodes int
+
+	// RetransmitMult is the multiplier of the number of retransmissions
+	// of the events
+	// Default is 4
+	RetransmitMult int
+
+	// SnapshotPath is the file the table entries are periodically saved
+	// to and restored from on start. Snapshots are disabled when empty
+	SnapshotPath string
+
+	// SnapshotInterval the period to use to save the snapshot
+	// Default is 1min
+	SnapshotInterval time.Duration
+
+	// SnapshotValidity is how long the restored entries are served
+	// without being confirmed by the cluster, and the maximum age of a
+	// snapshot to be restored
+	// Default is 5min
+	SnapshotValidity time.Duration
 }
 
 // entry defines a table entry
@@ -217,8 +282,13 @@ type entry struct {
 	deleting bool
 
 	// Number of seconds still left before a deleted table entry gets
-	// removed from networkDB
+	// removed from networkDB. For a restored entry, the time left
+	// before it gets removed if not confirmed by the cluster
 	reapTime time.Duration
+
+	// The entry was loaded from the snapshot and was not received from
+	// the cluster yet
+	restored bool
 }
 
 // DefaultConfig returns a NetworkDB config with default values
@@ -232,6 +302,8 @@ func DefaultConfig() *Config {
 		StatsPrintPeriod:  5 * time.Minute,
 		HealthPrintPeriod: 1 * time.Minute,
 		reapEntryInterval: 30 * time.Minute,
+		SnapshotInterval:  defaultSnapshotInterval,
+		SnapshotValidity:  defaultSnapshotValidity,
 	}
 }
 
@@ -242,6 +314,7 @@ func New(c *Config) (*NetworkDB, error) {
 	// For this reason the expiration time of the network is put slightly higher than the entry expiration so that
 	// there is at least 5 extra cycle to make sure that all the entries are properly deleted before deleting the network.
 	c.reapNetworkInterval = c.reapEntryInterval + 5*reapPeriod
+	c.gossipDefaults()
 
 	nDB := &NetworkDB{
 		config:         c,
@@ -258,6 +331,18 @@ func New(c *Config) (*NetworkDB, error) {
 	nDB.indexes[byTable] = radix.New()
 	nDB.indexes[byNetwork] = radix.New()
 
+	if c.SnapshotPath != "" {
+		if c.SnapshotInterval <= 0 {
+			c.SnapshotInterval = defaultSnapshotInterval
+		}
+		if c.SnapshotValidity <= 0 {
+			c.SnapshotValidity = defaultSnapshotValidity
+		}
+		if err := nDB.restoreSnapshot(); err != nil {
+			logrus.Warnf("%v(%v): failed to restore the NetworkDB snapshot: %v", c.Hostname, c.NodeID, err)
+		}
+	}
+
 	logrus.Infof("New memberlist node - Node:%v will use memberlist nodeID:%v with config:%+v", c.Hostname, c.NodeID, c)
 	if err := nDB.clusterInit(); err != nil {
 		return nil, err
@@ -279,6 +364,9 @@ func (nDB *NetworkDB) Join(members []string) error {
 // Close destroys this NetworkDB instance by leave the cluster,
 // stopping timers, canceling goroutines etc.
 func (nDB *NetworkDB) Close() {
+	if nDB.config.SnapshotPath != "" {
+		nDB.saveSnapshot()
+	}
 	if err := nDB.clusterLeave(); err != nil {
 		logrus.Errorf("%v(%v) Could not close DB: %v", nDB.config.Hostname, nDB.config.NodeID, err)
 	}
@@ -301,6 +389,12 @@ func (nDB *NetworkDB) ClusterPeers() []PeerInfo {
 	return peers
 }
 
+// NodeID returns the ID of this node in the cluster, the owner of the
+// entries it creates
+func (nDB *NetworkDB) NodeID() string {
+	return nDB.config.NodeID
+}
+
 // Peers returns the gossip peers for a given network.
 func (nDB *NetworkDB) Peers(nid string) []PeerInfo {
 	nDB.RLock()
@@ -354,10 +448,15 @@ func (nDB *NetworkDB) getEntry(tname, nid, key string) (*entry, error) {
 func (nDB *NetworkDB) CreateEntry(tname, nid, key string, value []byte) error {
 	nDB.Lock()
 	oldEntry, err := nDB.getEntry(tname, nid, key)
-	if err == nil || (oldEntry != nil && !oldEntry.deleting) {
+	if err == nil && !oldEntry.restored {
 		nDB.Unlock()
 		return fmt.Errorf("cannot create entry in table %s with network id %s and key %s, already exists", tname, nid, key)
 	}
+	if reason := nDB.checkQuota(tname, nid, len(value), err != nil || oldEntry.deleting); reason != "" {
+		nDB.Unlock()
+		nDB.rejected(tname, nid, key, nDB.config.NodeID, reason)
+		return types.ForbiddenErrorf("cannot create entry in table %s with network id %s and key %s: %s", tname, nid, key, reason)
+	}
 
 	entry := &entry{
 		ltime: nDB.tableClock.Increment(),
@@ -381,10 +480,16 @@ func (nDB *NetworkDB) CreateEntry(tname, nid, key string, value []byte) error {
 // non-existent entry.
 func (nDB *NetworkDB) UpdateEntry(tname, nid, key string, value []byte) error {
 	nDB.Lock()
-	if _, err := nDB.getEntry(tname, nid, key); err != nil {
+	oldEntry, err := nDB.getEntry(tname, nid, key)
+	if err != nil {
 		nDB.Unlock()
 		return fmt.Errorf("cannot update entry as the entry in table %s with network id %s and key %s does not exist", tname, nid, key)
 	}
+	if reason := nDB.checkQuota(tname, nid, len(value), oldEntry.deleting); reason != "" {
+		nDB.Unlock()
+		nDB.rejected(tname, nid, key, nDB.config.NodeID, reason)
+		return types.ForbiddenErrorf("cannot update entry in table %s with network id %s and key %s: %s", tname, nid, key, reason)
+	}
 
 	entry := &entry{
 		ltime: nDB.tableClock.Increment(),
@@ -402,6 +507,20 @@ func (nDB *NetworkDB) UpdateEntry(tname, nid, key string, value []byte) error {
 	return nil
 }
 
+// TakeEntry creates a table entry in NetworkDB for given (network, table,
+// key) tuple, taking it over from the node which owns it if it exists. It
+// is used for the entries of the endpoints migrated from another node, the
+// other nodes see a single update of the entry.
+func (nDB *NetworkDB) TakeEntry(tname, nid, key string, value []byte) error {
+	nDB.RLock()
+	oldEntry, err := nDB.getEntry(tname, nid, key)
+	nDB.RUnlock()
+	if err != nil || oldEntry.deleting {
+		return nDB.CreateEntry(tname, nid, key, value)
+	}
+	return nDB.UpdateEntry(tname, nid, key, value)
+}
+
 // TableElem elem
 type TableElem struct {
 	Value []byte
@@ -428,6 +547,17 @@ func (nDB *NetworkDB) GetTableByNetwork(tname, nid string) map[string]*TableElem
 // table, key) tuple and if the NetworkDB is part of the cluster
 // propagates this event to the cluster.
 func (nDB *NetworkDB) DeleteEntry(tname, nid, key string) error {
+	return nDB.markEntryDeleted(tname, nid, key, false)
+}
+
+// DeleteOwnEntry deletes the table entry of the (network, table, key)
+// tuple as DeleteEntry does, only when this node owns it. The entries
+// owned by the other nodes are left to them.
+func (nDB *NetworkDB) DeleteOwnEntry(tname, nid, key string) error {
+	return nDB.markEntryDeleted(tname, nid, key, true)
+}
+
+func (nDB *NetworkDB) markEntryDeleted(tname, nid, key string, own bool) error {
 	nDB.Lock()
 	oldEntry, err := nDB.getEntry(tname, nid, key)
 	if err != nil || oldEntry == nil || oldEntry.deleting {
@@ -435,13 +565,18 @@ func (nDB *NetworkDB) DeleteEntry(tname, nid, key string) error {
 		return fmt.Errorf("cannot delete entry %s with network id %s and key %s "+
 			"does not exist or is already being deleted", tname, nid, key)
 	}
+	if own && oldEntry.node != nDB.config.NodeID {
+		nDB.Unlock()
+		return fmt.Errorf("cannot delete entry %s with network id %s and key %s "+
+			"owned by node %s", tname, nid, key, oldEntry.node)
+	}
 
 	entry := &entry{
 		ltime:    nDB.tableClock.Increment(),
 		node:     nDB.config.NodeID,
 		value:    oldEntry.value,
 		deleting: true,
-		reapTime: nDB.config.reapEntryInterval,
+		reapTime: nDB.reapInterval(tname),
 	}
 
 	nDB.createOrUpdateEntry(nid, tname, key, entry)
@@ -513,7 +648,7 @@ func (nDB *NetworkDB) deleteNodeNetworkEntries(nid, node string) {
 				node:     oldEntry.node,
 				value:    oldEntry.value,
 				deleting: true,
-				reapTime: nDB.config.reapEntryInterval,
+				reapTime: nDB.reapInterval(tname),
 			}
 
 			// we arrived at this point in 2 cases:
@@ -592,6 +727,7 @@ func (nDB *NetworkDB) WalkTable(tname string, fn func(string, string, []byte, bo
 // sub-cluster of this network and participates in the network-scoped
 // gossip and bulk sync for this network.
 func (nDB *NetworkDB) JoinNetwork(nid string) error {
+	start := time.Now()
 	ltime := nDB.networkClock.Increment()
 
 	nDB.Lock()
@@ -615,7 +751,7 @@ func (nDB *NetworkDB) JoinNetwork(nid string) error {
 			defer nDB.RUnlock()
 			return len(nDB.networkNodes[nid])
 		},
-		RetransmitMult: 4,
+		RetransmitMult: nDB.config.RetransmitMult,
 	}
 	nDB.addNetworkNode(nid, nDB.config.NodeID)
 	networkNodes := nDB.networkNodes[nid]
@@ -635,6 +771,7 @@ func (nDB *NetworkDB) JoinNetwork(nid string) error {
 	// note this is a best effort, we are not checking the result of the bulk sync
 	nDB.Lock()
 	n.inSync = true
+	n.convergence = time.Since(start)
 	nDB.Unlock()
 
 	return nil
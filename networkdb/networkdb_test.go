@@ -6,6 +6,8 @@ import (
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -136,7 +138,7 @@ func (db *NetworkDB) verifyEntryExistence(t *testing.T, tname, nid, key, value s
 	t.Errorf("Entry existence verification test failed for %v(%v)", db.config.Hostname, db.config.NodeID)
 }
 
-func testWatch(t *testing.T, ch chan events.Event, ev interface{}, tname, nid, key, value string) {
+func testWatch(t *testing.T, ch <-chan events.Event, ev interface{}, tname, nid, key, value string) {
 	select {
 	case rcvdEv := <-ch:
 		assert.Check(t, is.Equal(fmt.Sprintf("%T", rcvdEv), fmt.Sprintf("%T", ev)))
@@ -255,6 +257,38 @@ func TestNetworkDBCRUDTableEntry(t *testing.T) {
 	closeNetworkDBInstances(dbs)
 }
 
+func TestNetworkDBTakeEntry(t *testing.T) {
+	dbs := createNetworkDBInstances(t, 2, "node", DefaultConfig())
+
+	assert.NilError(t, dbs[0].JoinNetwork("network1"))
+	dbs[1].verifyNetworkExistence(t, dbs[0].config.NodeID, "network1", true)
+	assert.NilError(t, dbs[1].JoinNetwork("network1"))
+	dbs[0].verifyNetworkExistence(t, dbs[1].config.NodeID, "network1", true)
+
+	assert.NilError(t, dbs[0].CreateEntry("test_table", "network1", "test_key", []byte("test_value")))
+	dbs[1].verifyEntryExistence(t, "test_table", "network1", "test_key", "test_value", true)
+
+	// the entry is taken over by the second node
+	assert.NilError(t, dbs[1].TakeEntry("test_table", "network1", "test_key", []byte("test_taken_value")))
+	dbs[0].verifyEntryExistence(t, "test_table", "network1", "test_key", "test_taken_value", true)
+	dbs[0].RLock()
+	entry, err := dbs[0].getEntry("test_table", "network1", "test_key")
+	dbs[0].RUnlock()
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(entry.node, dbs[1].config.NodeID))
+
+	// the entry is no longer the one of the first node to delete
+	assert.Check(t, dbs[0].DeleteOwnEntry("test_table", "network1", "test_key") != nil)
+	assert.NilError(t, dbs[1].DeleteOwnEntry("test_table", "network1", "test_key"))
+	dbs[0].verifyEntryExistence(t, "test_table", "network1", "test_key", "", false)
+
+	// a missing entry is created
+	assert.NilError(t, dbs[1].TakeEntry("test_table", "network1", "test_key2", []byte("test_value2")))
+	dbs[0].verifyEntryExistence(t, "test_table", "network1", "test_key2", "test_value2", true)
+
+	closeNetworkDBInstances(dbs)
+}
+
 func TestNetworkDBCRUDTableEntries(t *testing.T) {
 	dbs := createNetworkDBInstances(t, 2, "node", DefaultConfig())
 
@@ -862,3 +896,433 @@ func TestNetworkDBIslands(t *testing.T) {
 		}
 	}
 }
+
+func TestNetworkDBMetrics(t *testing.T) {
+	dbs := createNetworkDBInstances(t, 2, "node", DefaultConfig())
+	defer closeNetworkDBInstances(dbs)
+
+	assert.NilError(t, dbs[0].JoinNetwork("network1"))
+	dbs[1].verifyNetworkExistence(t, dbs[0].config.NodeID, "network1", true)
+	assert.NilError(t, dbs[1].JoinNetwork("network1"))
+
+	assert.NilError(t, dbs[0].CreateEntry("table1", "network1", "key1", []byte("value")))
+	assert.NilError(t, dbs[0].CreateEntry("table1", "network1", "key2", []byte("value")))
+	assert.NilError(t, dbs[0].CreateEntry("table2", "network1", "key1", []byte("value")))
+	dbs[1].verifyEntryExistence(t, "table2", "network1", "key1", "value", true)
+
+	m := dbs[0].Metrics()
+	assert.Check(t, is.Equal(m.Nodes, 2))
+	assert.Check(t, m.Broadcasts >= 4)
+	assert.Check(t, m.Transmits > 0)
+	nm, ok := m.Networks["network1"]
+	assert.Assert(t, ok)
+	assert.Check(t, is.Equal(nm.Peers, 2))
+	assert.Check(t, is.Equal(nm.Entries, 3))
+	assert.Check(t, is.Equal(nm.Tables["table1"], 2))
+	assert.Check(t, is.Equal(nm.Tables["table2"], 1))
+	assert.Check(t, nm.InSync)
+	assert.Check(t, nm.Convergence > 0)
+
+	// the node joining second bulk syncs with the first one
+	assert.Check(t, dbs[1].Metrics().BulkSyncs > 0)
+}
+
+func TestNetworkDBSnapshot(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "networkdb-snapshot")
+	assert.NilError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	dbs := createNetworkDBInstances(t, 2, "node", DefaultConfig())
+	defer closeNetworkDBInstances(dbs)
+
+	assert.NilError(t, dbs[0].JoinNetwork("network1"))
+	dbs[1].verifyNetworkExistence(t, dbs[0].config.NodeID, "network1", true)
+	assert.NilError(t, dbs[1].JoinNetwork("network1"))
+	assert.NilError(t, dbs[0].CreateEntry("table1", "network1", "key1", []byte("value")))
+	assert.NilError(t, dbs[1].CreateEntry("table1", "network1", "key2", []byte("value")))
+	dbs[1].verifyEntryExistence(t, "table1", "network1", "key1", "value", true)
+
+	dbs[1].config.SnapshotPath = filepath.Join(tmpDir, "snapshot.json")
+	dbs[1].saveSnapshot()
+
+	// a restarting node serves the entries of the other nodes right away
+	conf := *dbs[1].config
+	conf.BindPort = int(atomic.AddInt32(&dbPort, 1))
+	conf.SnapshotValidity = 2 * reapPeriod
+	db := launchNode(t, conf)
+	defer db.Close()
+
+	v, err := db.GetEntry("table1", "network1", "key1")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(string(v), "value"))
+	// its own entries are not restored
+	_, err = db.GetEntry("table1", "network1", "key2")
+	assert.Check(t, err != nil)
+
+	// entries not confirmed by the cluster are removed once expired
+	ch, cancel := db.Watch("table1", "network1", "")
+	defer cancel()
+	db.reapRestoredEntries()
+	_, err = db.GetEntry("table1", "network1", "key1")
+	assert.Check(t, err)
+	db.reapRestoredEntries()
+	_, err = db.GetEntry("table1", "network1", "key1")
+	assert.Check(t, err != nil)
+	testWatch(t, ch.C, DeleteEvent{}, "table1", "network1", "key1", "value")
+}
+
+func TestNetworkDBGossipParams(t *testing.T) {
+	dbs := createNetworkDBInstances(t, 2, "node", DefaultConfig())
+	defer closeNetworkDBInstances(dbs)
+
+	p := dbs[0].GossipParams()
+	assert.Check(t, is.Equal(p.GossipInterval, 200*time.Millisecond))
+	assert.Check(t, is.Equal(p.PushPullInterval, 30*time.Second))
+	assert.Check(t, is.Equal(p.GossipNodes, 3))
+	assert.Check(t, is.Equal(p.RetransmitMult, 4))
+
+	assert.Check(t, dbs[0].SetGossipParams(GossipParams{GossipInterval: time.Millisecond}) != nil)
+	assert.Check(t, dbs[0].SetGossipParams(GossipParams{GossipNodes: 100}) != nil)
+
+	assert.NilError(t, dbs[0].JoinNetwork("network1"))
+	assert.NilError(t, dbs[0].SetGossipParams(GossipParams{GossipInterval: 50 * time.Millisecond, RetransmitMult: 2}))
+	p = dbs[0].GossipParams()
+	assert.Check(t, is.Equal(p.GossipInterval, 50*time.Millisecond))
+	assert.Check(t, is.Equal(p.PushPullInterval, 30*time.Second))
+	assert.Check(t, is.Equal(p.RetransmitMult, 2))
+	dbs[0].RLock()
+	assert.Check(t, is.Equal(dbs[0].networks[dbs[0].config.NodeID]["network1"].tableBroadcasts.RetransmitMult, 2))
+	dbs[0].RUnlock()
+
+	// the table events keep being gossiped
+	dbs[1].verifyNetworkExistence(t, dbs[0].config.NodeID, "network1", true)
+	assert.NilError(t, dbs[1].JoinNetwork("network1"))
+	dbs[0].verifyNetworkExistence(t, dbs[1].config.NodeID, "network1", true)
+	assert.NilError(t, dbs[0].CreateEntry("table1", "network1", "key1", []byte("value")))
+	dbs[1].verifyEntryExistence(t, "table1", "network1", "key1", "value", true)
+}
+
+func TestNetworkDBWatchWithOptions(t *testing.T) {
+	dbs := createNetworkDBInstances(t, 2, "node", DefaultConfig())
+	defer closeNetworkDBInstances(dbs)
+
+	for _, nid := range []string{"network1", "network2"} {
+		assert.NilError(t, dbs[0].JoinNetwork(nid))
+		dbs[1].verifyNetworkExistence(t, dbs[0].config.NodeID, nid, true)
+		assert.NilError(t, dbs[1].JoinNetwork(nid))
+		dbs[0].verifyNetworkExistence(t, dbs[1].config.NodeID, nid, true)
+	}
+	assert.NilError(t, dbs[1].CreateEntry("table1", "network1", "svc-a", []byte("a")))
+	assert.NilError(t, dbs[1].CreateEntry("table1", "network1", "ep-a", []byte("a")))
+	dbs[0].verifyEntryExistence(t, "table1", "network1", "svc-a", "a", true)
+	dbs[0].verifyEntryExistence(t, "table1", "network1", "ep-a", "a", true)
+
+	w := dbs[0].WatchWithOptions(WatchOptions{
+		Table:      "table1",
+		Networks:   []string{"network1"},
+		KeyPrefix:  "svc-",
+		BufferSize: 2,
+		Replay:     true,
+	})
+	defer w.Close()
+
+	testWatch(t, w.C, CreateEvent{}, "table1", "network1", "svc-a", "a")
+	select {
+	case ev := <-w.C:
+		assert.Check(t, is.DeepEqual(ev, SyncEvent{}))
+	case <-time.After(time.Second):
+		t.Fatal("no sync event")
+	}
+
+	// filtered out
+	assert.NilError(t, dbs[1].CreateEntry("table1", "network2", "svc-b", []byte("b")))
+	assert.NilError(t, dbs[1].CreateEntry("table1", "network1", "ep-b", []byte("b")))
+	assert.NilError(t, dbs[1].CreateEntry("table2", "network1", "svc-b", []byte("b")))
+	assert.NilError(t, dbs[1].CreateEntry("table1", "network1", "svc-b", []byte("b")))
+	testWatch(t, w.C, CreateEvent{}, "table1", "network1", "svc-b", "b")
+
+	// overflow the buffer without consuming
+	keys := []string{"svc-a", "svc-b", "svc-c", "svc-d", "svc-e", "svc-f", "svc-g"}
+	for _, key := range keys[2:] {
+		assert.NilError(t, dbs[1].CreateEntry("table1", "network1", key, []byte("v")))
+	}
+	select {
+	case <-w.Overflow:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no overflow signaled")
+	}
+	assert.Check(t, w.Dropped() > 0)
+	for _, key := range keys[2:] {
+		dbs[0].verifyEntryExistence(t, "table1", "network1", key, "v", true)
+	}
+
+	// resuming replays the current state
+	w.Resume()
+	replayed := make(map[string]bool)
+	for ev := range w.C {
+		if _, ok := ev.(SyncEvent); ok {
+			break
+		}
+		c, ok := ev.(CreateEvent)
+		assert.Assert(t, ok)
+		replayed[c.Key] = true
+	}
+	for _, key := range keys {
+		assert.Check(t, replayed[key], key)
+	}
+	assert.Check(t, is.Len(replayed, len(keys)))
+}
+
+func TestNetworkDBConflictResolver(t *testing.T) {
+	dbs := createNetworkDBInstances(t, 2, "node", DefaultConfig())
+	defer closeNetworkDBInstances(dbs)
+
+	// the value is a set of comma separated members
+	union := func(tname, nid, key string, local, remote EntryVersion) []byte {
+		members := make(map[string]bool)
+		for _, v := range [][]byte{local.Value, remote.Value} {
+			for _, m := range strings.Split(string(v), ",") {
+				members[m] = true
+			}
+		}
+		var set []string
+		for m := range members {
+			set = append(set, m)
+		}
+		sort.Strings(set)
+		return []byte(strings.Join(set, ","))
+	}
+	for _, db := range dbs {
+		db.SetConflictResolver("table1", union)
+	}
+
+	assert.NilError(t, dbs[0].JoinNetwork("network1"))
+	dbs[1].verifyNetworkExistence(t, dbs[0].config.NodeID, "network1", true)
+	assert.NilError(t, dbs[1].JoinNetwork("network1"))
+	dbs[0].verifyNetworkExistence(t, dbs[1].config.NodeID, "network1", true)
+
+	assert.NilError(t, dbs[0].CreateEntry("table1", "network1", "name", []byte("10.0.0.1")))
+	assert.NilError(t, dbs[1].CreateEntry("table1", "network1", "name", []byte("10.0.0.2")))
+	// last writer wins for the other tables
+	assert.NilError(t, dbs[0].CreateEntry("table2", "network1", "name", []byte("10.0.0.1")))
+	assert.NilError(t, dbs[1].CreateEntry("table2", "network1", "name", []byte("10.0.0.2")))
+
+	for _, db := range dbs {
+		db.verifyEntryExistence(t, "table1", "network1", "name", "10.0.0.1,10.0.0.2", true)
+	}
+	v0, err := dbs[0].GetEntry("table2", "network1", "name")
+	assert.NilError(t, err)
+	assert.Check(t, !strings.Contains(string(v0), ","))
+}
+
+func TestNetworkDBTCPOnly(t *testing.T) {
+	conf := DefaultConfig()
+	conf.TCPOnly = true
+	dbs := createNetworkDBInstances(t, 2, "node", conf)
+	defer closeNetworkDBInstances(dbs)
+
+	assert.NilError(t, dbs[0].JoinNetwork("network1"))
+	dbs[1].verifyNetworkExistence(t, dbs[0].config.NodeID, "network1", true)
+	assert.NilError(t, dbs[1].JoinNetwork("network1"))
+	dbs[0].verifyNetworkExistence(t, dbs[1].config.NodeID, "network1", true)
+
+	assert.NilError(t, dbs[0].CreateEntry("table1", "network1", "key1", []byte("value")))
+	dbs[1].verifyEntryExistence(t, "table1", "network1", "key1", "value", true)
+
+	// the nodes keep probing each other without UDP
+	time.Sleep(3 * tcpOnlyProbeInterval)
+	for _, db := range dbs {
+		assert.Check(t, is.Len(db.ClusterPeers(), 2))
+		assert.Check(t, is.Equal(db.memberlist.GetHealthScore(), 0))
+	}
+}
+
+func TestNetworkDBTombstones(t *testing.T) {
+	dbs := createNetworkDBInstances(t, 1, "node", DefaultConfig())
+	defer closeNetworkDBInstances(dbs)
+	db := dbs[0]
+
+	assert.Check(t, db.SetTableReapInterval("table1", time.Second) != nil)
+	assert.Check(t, db.SetTableReapInterval("table1", time.Hour) != nil)
+	assert.NilError(t, db.SetTableReapInterval("table1", time.Minute))
+
+	assert.NilError(t, db.JoinNetwork("network1"))
+	for _, tname := range []string{"table1", "table2"} {
+		assert.NilError(t, db.CreateEntry(tname, "network1", "key1", []byte("value")))
+		assert.NilError(t, db.DeleteEntry(tname, "network1", "key1"))
+	}
+	db.RLock()
+	e1, err := db.getEntry("table1", "network1", "key1")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(e1.reapTime, time.Minute))
+	e2, err := db.getEntry("table2", "network1", "key1")
+	assert.NilError(t, err)
+	assert.Check(t, is.Equal(e2.reapTime, db.config.reapEntryInterval))
+	db.RUnlock()
+	assert.Check(t, is.Equal(db.Metrics().Networks["network1"].Tombstones, 2))
+
+	// too recent
+	assert.Check(t, is.Equal(db.CompactTombstones("", time.Second), 0))
+	assert.Check(t, is.Equal(db.CompactTombstones("table1", 0), 1))
+	assert.Check(t, is.Equal(db.Metrics().Networks["network1"].Tombstones, 1))
+	assert.Check(t, is.Equal(db.CompactTombstones("", 0), 1))
+	assert.Check(t, is.Equal(db.Metrics().Networks["network1"].Tombstones, 0))
+}
+
+func TestNetworkDBQuarantine(t *testing.T) {
+	dbs := createNetworkDBInstances(t, 2, "node", DefaultConfig())
+	defer closeNetworkDBInstances(dbs)
+
+	assert.Check(t, dbs[0].QuarantineNode("") != nil)
+	assert.Check(t, dbs[0].QuarantineNode(dbs[0].config.NodeID) != nil)
+	assert.Check(t, dbs[0].EvictNode(dbs[0].config.NodeID) != nil)
+	assert.Check(t, dbs[0].ReleaseNode(dbs[1].config.NodeID) != nil)
+
+	assert.NilError(t, dbs[0].JoinNetwork("network1"))
+	assert.NilError(t, dbs[1].JoinNetwork("network1"))
+	dbs[0].verifyNetworkExistence(t, dbs[1].config.NodeID, "network1", true)
+	dbs[1].verifyNetworkExistence(t, dbs[0].config.NodeID, "network1", true)
+
+	assert.NilError(t, dbs[0].QuarantineNode(dbs[1].config.NodeID))
+	assert.DeepEqual(t, dbs[0].QuarantinedNodes(), []string{dbs[1].config.NodeID})
+
+	// the entries of the quarantined node are ignored
+	assert.NilError(t, dbs[1].CreateEntry("table1", "network1", "key1", []byte("value")))
+	time.Sleep(2 * time.Second)
+	_, err := dbs[0].GetEntry("table1", "network1", "key1")
+	assert.Check(t, err != nil)
+
+	assert.NilError(t, dbs[0].ReleaseNode(dbs[1].config.NodeID))
+	assert.Check(t, is.Len(dbs[0].QuarantinedNodes(), 0))
+	assert.NilError(t, dbs[1].UpdateEntry("table1", "network1", "key1", []byte("value2")))
+	dbs[0].verifyEntryExistence(t, "table1", "network1", "key1", "value2", true)
+
+	assert.NilError(t, dbs[0].EvictNode(dbs[1].config.NodeID))
+	dbs[0].RLock()
+	nodes := dbs[0].networkNodes["network1"]
+	_, left := dbs[0].leftNodes[dbs[1].config.NodeID]
+	dbs[0].RUnlock()
+	assert.Check(t, !containsString(nodes, dbs[1].config.NodeID))
+	assert.Check(t, !left)
+	assert.Check(t, dbs[0].EvictNode("unknown") != nil)
+}
+
+func TestNetworkDBTableQuota(t *testing.T) {
+	dbs := createNetworkDBInstances(t, 2, "node", DefaultConfig())
+	defer closeNetworkDBInstances(dbs)
+
+	assert.Check(t, dbs[0].SetTableQuota("", "", TableQuota{MaxEntries: 1}) != nil)
+	assert.Check(t, dbs[0].SetTableQuota("table1", "", TableQuota{MaxEntries: -1}) != nil)
+	assert.NilError(t, dbs[0].SetTableQuota("table1", "", TableQuota{MaxEntries: 1, MaxEntrySize: 4}))
+	assert.NilError(t, dbs[0].SetTableQuota("table1", "network2", TableQuota{MaxEntries: 2}))
+
+	assert.NilError(t, dbs[0].JoinNetwork("network1"))
+	dbs[1].verifyNetworkExistence(t, dbs[0].config.NodeID, "network1", true)
+	assert.NilError(t, dbs[1].JoinNetwork("network1"))
+	dbs[0].verifyNetworkExistence(t, dbs[1].config.NodeID, "network1", true)
+
+	// local entries
+	assert.Check(t, dbs[0].CreateEntry("table1", "network1", "key1", []byte("too large")) != nil)
+	assert.NilError(t, dbs[0].CreateEntry("table1", "network1", "key1", []byte("v")))
+	assert.Check(t, dbs[0].CreateEntry("table1", "network1", "key2", []byte("v")) != nil)
+	assert.Check(t, dbs[0].UpdateEntry("table1", "network1", "key1", []byte("too large")) != nil)
+	assert.NilError(t, dbs[0].CreateEntry("table2", "network1", "key2", []byte("no quota")))
+	assert.NilError(t, dbs[0].CreateEntry("table1", "network2", "key1", []byte("no size limit")))
+	assert.NilError(t, dbs[0].CreateEntry("table1", "network2", "key2", []byte("v")))
+	// a tombstone doesn't count
+	assert.NilError(t, dbs[0].DeleteEntry("table1", "network1", "key1"))
+	assert.NilError(t, dbs[0].CreateEntry("table1", "network1", "key2", []byte("v")))
+
+	// remote entries
+	w := dbs[0].WatchWithOptions(WatchOptions{Table: "table1", Rejections: true})
+	defer w.Close()
+	assert.NilError(t, dbs[1].CreateEntry("table1", "network1", "key3", []byte("v")))
+	select {
+	case ev := <-w.C:
+		r, ok := ev.(RejectEvent)
+		assert.Assert(t, ok, "unexpected event %#v", ev)
+		assert.Check(t, is.Equal(r.Key, "key3"))
+		assert.Check(t, is.Equal(r.Node, dbs[1].config.NodeID))
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reject event")
+	}
+	_, err := dbs[0].GetEntry("table1", "network1", "key3")
+	assert.Check(t, err != nil)
+	assert.Check(t, is.Equal(dbs[0].Metrics().Rejected, uint64(4)))
+
+	// the quota removed
+	assert.NilError(t, dbs[0].SetTableQuota("table1", "", TableQuota{}))
+	assert.NilError(t, dbs[1].UpdateEntry("table1", "network1", "key3", []byte("value")))
+	dbs[0].verifyEntryExistence(t, "table1", "network1", "key3", "value", true)
+}
+
+func TestNetworkDBPartitionDetection(t *testing.T) {
+	dbs := createNetworkDBInstances(t, 1, "node", DefaultConfig())
+	defer closeNetworkDBInstances(dbs)
+	db := dbs[0]
+
+	ch, cancel := db.Watch(PartitionTable, "", "")
+	defer cancel()
+	expect := func(evs ...events.Event) {
+		t.Helper()
+		got := make(map[string]bool)
+		for range evs {
+			select {
+			case ev := <-ch.C:
+				var key string
+				switch ev := ev.(type) {
+				case CreateEvent:
+					key = "create " + ev.Key
+				case DeleteEvent:
+					key = "delete " + ev.Key
+				}
+				got[key] = true
+			case <-time.After(5 * time.Second):
+				t.Fatalf("missing partition events, got %v", got)
+			}
+		}
+		for _, ev := range evs {
+			switch ev := ev.(type) {
+			case CreateEvent:
+				assert.Check(t, got["create "+ev.Key], "missing create %s in %v", ev.Key, got)
+			case DeleteEvent:
+				assert.Check(t, got["delete "+ev.Key], "missing delete %s in %v", ev.Key, got)
+			}
+		}
+	}
+
+	db.Lock()
+	db.nodes["node2"] = &node{Node: memberlist.Node{Name: "node2"}}
+	_, err := db.changeNodeState("node2", nodeFailedState)
+	db.Unlock()
+	assert.NilError(t, err)
+
+	now := time.Now()
+	db.detectPartitions(now)
+	assert.Check(t, is.Len(db.Partitions(), 0))
+	db.detectPartitions(now.Add(partitionThreshold))
+	expect(CreateEvent{Key: "failed_node:node2"})
+
+	// the failed node bulk syncs with this node
+	db.Lock()
+	db.notMember("node2", 0, false)
+	db.notMember("node3", 0, false)
+	db.Unlock()
+	db.detectPartitions(now.Add(partitionThreshold))
+	expect(CreateEvent{Key: "one_way:node2"}, DeleteEvent{Key: "failed_node:node2"})
+	db.detectPartitions(now.Add(2 * partitionThreshold))
+	expect(CreateEvent{Key: "diverging_nodes:node3"})
+	partitions := db.Partitions()
+	assert.Assert(t, is.Len(partitions, 2))
+	assert.Check(t, is.Equal(partitions[0].Kind, PartitionDivergingNodes))
+	assert.Check(t, is.Equal(partitions[1].Kind, PartitionOneWay))
+
+	db.Lock()
+	_, err = db.changeNodeState("node2", nodeActiveState)
+	db.Unlock()
+	assert.NilError(t, err)
+	// node3 is not gossiped anymore
+	db.detectPartitions(now.Add(5 * partitionThreshold))
+	expect(DeleteEvent{Key: "one_way:node2"}, DeleteEvent{Key: "diverging_nodes:node3"})
+	assert.Check(t, is.Len(db.Partitions(), 0))
+}
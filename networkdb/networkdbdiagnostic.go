@@ -29,6 +29,13 @@ var NetDbPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
 	"/getentry":     dbGetEntry,
 	"/gettable":     dbGetTable,
 	"/networkstats": dbNetworkStats,
+	"/metrics":      dbMetrics,
+
+	"/quarantinenode":   dbQuarantineNode,
+	"/releasenode":      dbReleaseNode,
+	"/evictnode":        dbEvictNode,
+	"/quarantinednodes": dbQuarantinedNodes,
+	"/partitions":       dbPartitions,
 }
 
 func dbJoin(ctx interface{}, w http.ResponseWriter, r *http.Request) {
@@ -450,3 +457,156 @@ func dbNetworkStats(ctx interface{}, w http.ResponseWriter, r *http.Request) {
 	}
 	diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("%s", dbNotAvailable)), json)
 }
+
+func dbMetrics(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("metrics")
+
+	nDB, ok := ctx.(*NetworkDB)
+	if ok {
+		rsp := diagnostic.CommandSucceed(nDB.Metrics())
+		log.WithField("response", fmt.Sprintf("%+v", rsp)).Info("metrics done")
+		diagnostic.HTTPReply(w, rsp, json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("%s", dbNotAvailable)), json)
+}
+
+func dbQuarantineNode(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("quarantine node")
+
+	if len(r.Form["node"]) < 1 {
+		rsp := diagnostic.WrongCommand(missingParameter, fmt.Sprintf("%s?node=node_id", r.URL.Path))
+		log.Error("quarantine node failed, wrong input")
+		diagnostic.HTTPReply(w, rsp, json)
+		return
+	}
+
+	node := r.Form["node"][0]
+
+	nDB, ok := ctx.(*NetworkDB)
+	if ok {
+		if err := nDB.QuarantineNode(node); err != nil {
+			log.WithError(err).Error("quarantine node failed")
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+			return
+		}
+		log.Info("quarantine node done")
+		diagnostic.HTTPReply(w, diagnostic.CommandSucceed(nil), json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("%s", dbNotAvailable)), json)
+}
+
+func dbReleaseNode(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("release node")
+
+	if len(r.Form["node"]) < 1 {
+		rsp := diagnostic.WrongCommand(missingParameter, fmt.Sprintf("%s?node=node_id", r.URL.Path))
+		log.Error("release node failed, wrong input")
+		diagnostic.HTTPReply(w, rsp, json)
+		return
+	}
+
+	node := r.Form["node"][0]
+
+	nDB, ok := ctx.(*NetworkDB)
+	if ok {
+		if err := nDB.ReleaseNode(node); err != nil {
+			log.WithError(err).Error("release node failed")
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+			return
+		}
+		log.Info("release node done")
+		diagnostic.HTTPReply(w, diagnostic.CommandSucceed(nil), json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("%s", dbNotAvailable)), json)
+}
+
+func dbEvictNode(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("evict node")
+
+	if len(r.Form["node"]) < 1 {
+		rsp := diagnostic.WrongCommand(missingParameter, fmt.Sprintf("%s?node=node_id", r.URL.Path))
+		log.Error("evict node failed, wrong input")
+		diagnostic.HTTPReply(w, rsp, json)
+		return
+	}
+
+	node := r.Form["node"][0]
+
+	nDB, ok := ctx.(*NetworkDB)
+	if ok {
+		if err := nDB.EvictNode(node); err != nil {
+			log.WithError(err).Error("evict node failed")
+			diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+			return
+		}
+		log.Info("evict node done")
+		diagnostic.HTTPReply(w, diagnostic.CommandSucceed(nil), json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("%s", dbNotAvailable)), json)
+}
+
+func dbQuarantinedNodes(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("quarantined nodes")
+
+	nDB, ok := ctx.(*NetworkDB)
+	if ok {
+		rsp := diagnostic.CommandSucceed(&diagnostic.StringCmd{Info: strings.Join(nDB.QuarantinedNodes(), ",")})
+		log.WithField("response", fmt.Sprintf("%+v", rsp)).Info("quarantined nodes done")
+		diagnostic.HTTPReply(w, rsp, json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("%s", dbNotAvailable)), json)
+}
+
+func dbPartitions(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("partitions")
+
+	nDB, ok := ctx.(*NetworkDB)
+	if ok {
+		rsp := diagnostic.CommandSucceed(&PartitionsResult{Partitions: nDB.Partitions()})
+		log.WithField("response", fmt.Sprintf("%+v", rsp)).Info("partitions done")
+		diagnostic.HTTPReply(w, rsp, json)
+		return
+	}
+	diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("%s", dbNotAvailable)), json)
+}
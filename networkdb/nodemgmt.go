@@ -75,6 +75,12 @@ func (nDB *NetworkDB) changeNodeState(nodeName string, newState nodeState) (bool
 
 	logrus.Infof("Node %s change state %s --> %s", nodeName, nodeStateName[currState], nodeStateName[newState])
 
+	if newState == nodeFailedState {
+		nDB.nodeFailed(nodeName)
+	} else {
+		nDB.nodeRecovered(nodeName)
+	}
+
 	if newState == nodeLeftState || newState == nodeFailedState {
 		// set the node reap time, if not already set
 		// It is possible that a node passes from failed to left and the reaptime was already set so keep that value
@@ -0,0 +1,279 @@
+package networkdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/sirupsen/logrus"
+)
+
+// PartitionTable represents table event for the partitions suspected by
+// this node. A CreateEvent is sent when a suspicion is raised, a
+// DeleteEvent when it is cleared, the value is a JSON encoded Partition.
+const PartitionTable = "PartitionTable"
+
+const (
+	partitionCheckPeriod = 10 * time.Second
+	// partitionThreshold is how long a condition must last to be reported
+	partitionThreshold = time.Minute
+)
+
+// PartitionKind is the symptom of a suspected partition
+type PartitionKind string
+
+const (
+	// PartitionDivergingNodes is a node gossiped by the other nodes which
+	// is not a member of the cluster for this node
+	PartitionDivergingNodes PartitionKind = "diverging_nodes"
+	// PartitionFailedNode is a node failed for more than a minute
+	PartitionFailedNode PartitionKind = "failed_node"
+	// PartitionOneWay is a failed node which is still reaching the
+	// cluster: this node can't reach it but it is alive
+	PartitionOneWay PartitionKind = "one_way"
+)
+
+// Partition is a partition suspected by this node
+type Partition struct {
+	Kind PartitionKind `json:"kind"`
+	// Node is the node this node is partitioned from
+	Node string `json:"node"`
+	// Since is the time the condition started
+	Since  time.Time `json:"since"`
+	Detail string    `json:"detail"`
+}
+
+func (p *Partition) key() string {
+	return string(p.Kind) + ":" + p.Node
+}
+
+// nodeFailure is the state of the cluster when a node was found failed
+type nodeFailure struct {
+	since        time.Time
+	tableClock   serf.LamportTime
+	networkClock serf.LamportTime
+	// last time the node was found alive after the failure
+	aliveAt time.Time
+}
+
+// unknownNode is a node gossiped by the other nodes unknown to memberlist
+type unknownNode struct {
+	first time.Time
+	last  time.Time
+}
+
+// partitionDetector collects the symptoms of the partitions. It has its own
+// lock, taken after the NetworkDB one when both are held.
+type partitionDetector struct {
+	sync.Mutex
+	failed     map[string]*nodeFailure
+	unknown    map[string]*unknownNode
+	suspicions map[string]*Partition
+}
+
+// nodeFailed records the failure of a node, nDB must be locked
+func (nDB *NetworkDB) nodeFailed(nodeName string) {
+	p := &nDB.partitions
+	p.Lock()
+	defer p.Unlock()
+	if p.failed == nil {
+		p.failed = make(map[string]*nodeFailure)
+	}
+	p.failed[nodeName] = &nodeFailure{
+		since:        time.Now(),
+		tableClock:   nDB.tableClock.Time(),
+		networkClock: nDB.networkClock.Time(),
+	}
+}
+
+// nodeRecovered forgets the failure of a node back active or gone
+func (nDB *NetworkDB) nodeRecovered(nodeName string) {
+	p := &nDB.partitions
+	p.Lock()
+	defer p.Unlock()
+	delete(p.failed, nodeName)
+}
+
+// notMember records a message originated by a node which is not an active
+// member of the cluster for this node, nDB must be locked
+func (nDB *NetworkDB) notMember(nodeName string, ltime serf.LamportTime, table bool) {
+	switch _, state, _ := nDB.findNode(nodeName); state {
+	case nodeFailedState:
+		nDB.heardFrom(nodeName, ltime, table)
+	case nodeNotFound:
+		nDB.heardOfUnknown(nodeName)
+	}
+}
+
+// heardFrom records a message originated by a node. The events older than
+// the failure of the node are relayed by the other nodes and don't tell
+// anything: only the fresher ones, or a direct contact when ltime is zero,
+// show that the node is alive.
+func (nDB *NetworkDB) heardFrom(nodeName string, ltime serf.LamportTime, table bool) {
+	p := &nDB.partitions
+	p.Lock()
+	defer p.Unlock()
+	f, ok := p.failed[nodeName]
+	if !ok {
+		return
+	}
+	clock := f.networkClock
+	if table {
+		clock = f.tableClock
+	}
+	if ltime == 0 || ltime > clock {
+		f.aliveAt = time.Now()
+	}
+}
+
+// heardOfUnknown records a node gossiped by the other nodes which is not a
+// member of the cluster for this node
+func (nDB *NetworkDB) heardOfUnknown(nodeName string) {
+	p := &nDB.partitions
+	p.Lock()
+	defer p.Unlock()
+	if p.unknown == nil {
+		p.unknown = make(map[string]*unknownNode)
+	}
+	now := time.Now()
+	u, ok := p.unknown[nodeName]
+	if !ok {
+		u = &unknownNode{first: now}
+		p.unknown[nodeName] = u
+	}
+	u.last = now
+}
+
+func (nDB *NetworkDB) checkPartitions() {
+	nDB.detectPartitions(time.Now())
+}
+
+// detectPartitions updates the suspected partitions and notifies the
+// watchers of the changes
+func (nDB *NetworkDB) detectPartitions(now time.Time) {
+	nDB.RLock()
+	known := make(map[string]nodeState)
+	for state, nodes := range map[nodeState]map[string]*node{
+		nodeActiveState: nDB.nodes,
+		nodeLeftState:   nDB.leftNodes,
+		nodeFailedState: nDB.failedNodes,
+	} {
+		for name := range nodes {
+			known[name] = state
+		}
+	}
+	nDB.RUnlock()
+
+	p := &nDB.partitions
+	p.Lock()
+	current := make(map[string]*Partition)
+	for name, f := range p.failed {
+		if known[name] != nodeFailedState {
+			// the node is back or gone for good
+			delete(p.failed, name)
+			continue
+		}
+		var s *Partition
+		switch {
+		case !f.aliveAt.IsZero():
+			s = &Partition{
+				Kind:   PartitionOneWay,
+				Node:   name,
+				Since:  f.since,
+				Detail: fmt.Sprintf("node failed since %v but seen alive at %v", f.since.Format(time.RFC3339), f.aliveAt.Format(time.RFC3339)),
+			}
+		case now.Sub(f.since) >= partitionThreshold:
+			s = &Partition{
+				Kind:   PartitionFailedNode,
+				Node:   name,
+				Since:  f.since,
+				Detail: fmt.Sprintf("node failed for %v", now.Sub(f.since).Truncate(time.Second)),
+			}
+		}
+		if s != nil {
+			current[s.key()] = s
+		}
+	}
+	for name, u := range p.unknown {
+		if _, ok := known[name]; ok || now.Sub(u.last) > 2*partitionThreshold {
+			delete(p.unknown, name)
+			continue
+		}
+		if now.Sub(u.first) >= partitionThreshold {
+			s := &Partition{
+				Kind:   PartitionDivergingNodes,
+				Node:   name,
+				Since:  u.first,
+				Detail: "node gossiped by the cluster but not a member for this node",
+			}
+			current[s.key()] = s
+		}
+	}
+
+	var raised, cleared []*Partition
+	for k, s := range current {
+		if old, ok := p.suspicions[k]; ok {
+			// keep the detail of the notified suspicion
+			current[k] = old
+			continue
+		}
+		raised = append(raised, s)
+	}
+	for k, s := range p.suspicions {
+		if _, ok := current[k]; !ok {
+			cleared = append(cleared, s)
+		}
+	}
+	p.suspicions = current
+	p.Unlock()
+
+	for _, s := range raised {
+		logrus.Warnf("%v(%v): suspected partition %s from node %s: %s", nDB.config.Hostname, nDB.config.NodeID, s.Kind, s.Node, s.Detail)
+		nDB.broadcastPartition(opCreate, s)
+	}
+	for _, s := range cleared {
+		logrus.Infof("%v(%v): partition %s from node %s cleared", nDB.config.Hostname, nDB.config.NodeID, s.Kind, s.Node)
+		nDB.broadcastPartition(opDelete, s)
+	}
+}
+
+func (nDB *NetworkDB) broadcastPartition(op opType, s *Partition) {
+	value, err := json.Marshal(s)
+	if err != nil {
+		logrus.Errorf("Error marshalling partition event %+v: %v", s, err)
+		return
+	}
+	nDB.broadcaster.Write(makeEvent(op, PartitionTable, "", s.key(), value))
+}
+
+// Partitions returns the partitions currently suspected by this node
+func (nDB *NetworkDB) Partitions() []Partition {
+	p := &nDB.partitions
+	p.Lock()
+	defer p.Unlock()
+	partitions := make([]Partition, 0, len(p.suspicions))
+	for _, s := range p.suspicions {
+		partitions = append(partitions, *s)
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].key() < partitions[j].key()
+	})
+	return partitions
+}
+
+// PartitionsResult is the diagnostic result listing the suspected partitions
+type PartitionsResult struct {
+	Partitions []Partition `json:"partitions"`
+}
+
+func (r *PartitionsResult) String() string {
+	var b strings.Builder
+	for _, p := range r.Partitions {
+		fmt.Fprintf(&b, "%s node:%s since:%s %s\n", p.Kind, p.Node, p.Since.Format(time.RFC3339), p.Detail)
+	}
+	return b.String()
+}
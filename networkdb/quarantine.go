@@ -0,0 +1,94 @@
+package networkdb
+
+import (
+	"sort"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// QuarantineNode stops accepting the table and network events originated by
+// a misbehaving node and its bulk syncs. The quarantined node is not gossiped
+// nor bulk synced with anymore, its events are not rebroadcast.
+func (nDB *NetworkDB) QuarantineNode(nodeName string) error {
+	if nodeName == "" {
+		return types.BadRequestErrorf("missing node name")
+	}
+	if nodeName == nDB.config.NodeID {
+		return types.ForbiddenErrorf("cannot quarantine the local node")
+	}
+	nDB.Lock()
+	defer nDB.Unlock()
+	if nDB.quarantinedNodes == nil {
+		nDB.quarantinedNodes = make(map[string]struct{})
+	}
+	nDB.quarantinedNodes[nodeName] = struct{}{}
+	logrus.Warnf("%v(%v): node %s quarantined", nDB.config.Hostname, nDB.config.NodeID, nodeName)
+	return nil
+}
+
+// ReleaseNode lifts the quarantine of a node. Its state is learned again
+// with the next bulk syncs.
+func (nDB *NetworkDB) ReleaseNode(nodeName string) error {
+	nDB.Lock()
+	defer nDB.Unlock()
+	if _, ok := nDB.quarantinedNodes[nodeName]; !ok {
+		return types.NotFoundErrorf("node %s is not quarantined", nodeName)
+	}
+	delete(nDB.quarantinedNodes, nodeName)
+	logrus.Infof("%v(%v): node %s released from quarantine", nDB.config.Hostname, nDB.config.NodeID, nodeName)
+	return nil
+}
+
+// QuarantinedNodes returns the names of the quarantined nodes
+func (nDB *NetworkDB) QuarantinedNodes() []string {
+	nDB.RLock()
+	defer nDB.RUnlock()
+	nodes := make([]string, 0, len(nDB.quarantinedNodes))
+	for n := range nDB.quarantinedNodes {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// isQuarantined returns true when the node is quarantined, nDB must be
+// locked
+func (nDB *NetworkDB) isQuarantined(nodeName string) bool {
+	_, ok := nDB.quarantinedNodes[nodeName]
+	return ok
+}
+
+// withoutQuarantined filters the quarantined nodes out of a node list
+func (nDB *NetworkDB) withoutQuarantined(nodes []string) []string {
+	nDB.RLock()
+	defer nDB.RUnlock()
+	if len(nDB.quarantinedNodes) == 0 {
+		return nodes
+	}
+	filtered := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		if !nDB.isQuarantined(n) {
+			filtered = append(filtered, n)
+		}
+	}
+	return filtered
+}
+
+// EvictNode forcibly removes a stale node: its network attachments and its
+// entries are deleted right away instead of after the node reap interval.
+// A live node evicted joins back with its next node event, unless it is
+// quarantined.
+func (nDB *NetworkDB) EvictNode(nodeName string) error {
+	if nodeName == nDB.config.NodeID {
+		return types.ForbiddenErrorf("cannot evict the local node")
+	}
+	nDB.Lock()
+	defer nDB.Unlock()
+	if _, err := nDB.changeNodeState(nodeName, nodeLeftState); err != nil {
+		return types.NotFoundErrorf("%v", err)
+	}
+	delete(nDB.leftNodes, nodeName)
+	logrus.Warnf("%v(%v): node %s evicted", nDB.config.Hostname, nDB.config.NodeID, nodeName)
+	return nil
+}
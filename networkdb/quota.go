@@ -0,0 +1,107 @@
+package networkdb
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// TableQuota limits the entries of a table in a network. The zero values
+// mean no limit.
+type TableQuota struct {
+	// MaxEntries is the maximum number of live entries
+	MaxEntries int `json:"max_entries"`
+	// MaxEntrySize is the maximum size of the value of an entry, in bytes
+	MaxEntrySize int `json:"max_entry_size"`
+}
+
+// RejectEvent is sent to the watchers asking for it when an entry exceeding
+// the quota of its table is rejected
+type RejectEvent struct {
+	Table     string
+	NetworkID string
+	Key       string
+	// Node is the owner of the rejected entry
+	Node   string
+	Reason string
+}
+
+// SetTableQuota sets the quota of a table in a network, or in all the
+// networks when nid is empty. The quota of a network takes precedence. A
+// zero quota removes it. The entries already stored are kept, the quota
+// applies to the following creations and updates.
+func (nDB *NetworkDB) SetTableQuota(tname, nid string, q TableQuota) error {
+	if tname == "" {
+		return types.BadRequestErrorf("missing table name")
+	}
+	if q.MaxEntries < 0 || q.MaxEntrySize < 0 {
+		return types.BadRequestErrorf("invalid quota %+v of table %s", q, tname)
+	}
+	qkey := quotaKey(tname, nid)
+	nDB.Lock()
+	defer nDB.Unlock()
+	if q == (TableQuota{}) {
+		delete(nDB.tableQuotas, qkey)
+		return nil
+	}
+	if nDB.tableQuotas == nil {
+		nDB.tableQuotas = make(map[string]TableQuota)
+	}
+	nDB.tableQuotas[qkey] = q
+	return nil
+}
+
+func quotaKey(tname, nid string) string {
+	return tname + "/" + nid
+}
+
+// checkQuota returns the reason an entry is over the quota of its table,
+// an empty string when it fits. isNew is true when the entry doesn't exist
+// yet or is a tombstone. nDB must be locked.
+func (nDB *NetworkDB) checkQuota(tname, nid string, size int, isNew bool) string {
+	q, ok := nDB.tableQuotas[quotaKey(tname, nid)]
+	if !ok {
+		q, ok = nDB.tableQuotas[quotaKey(tname, "")]
+	}
+	if !ok {
+		return ""
+	}
+	if q.MaxEntrySize > 0 && size > q.MaxEntrySize {
+		return fmt.Sprintf("entry of %d bytes exceeds the %d bytes limit", size, q.MaxEntrySize)
+	}
+	if q.MaxEntries > 0 && isNew && nDB.liveEntries(tname, nid) >= q.MaxEntries {
+		return fmt.Sprintf("table is at its limit of %d entries", q.MaxEntries)
+	}
+	return ""
+}
+
+// liveEntries returns the number of entries of a table in a network which
+// are not being deleted, nDB must be locked
+func (nDB *NetworkDB) liveEntries(tname, nid string) int {
+	var n int
+	nDB.indexes[byNetwork].WalkPrefix(fmt.Sprintf("/%s/%s/", nid, tname), func(path string, v interface{}) bool {
+		if e, ok := v.(*entry); ok && !e.deleting {
+			n++
+		}
+		return false
+	})
+	return n
+}
+
+// rejected accounts for an entry over quota. nDB must not be locked: the
+// watchers are notified.
+func (nDB *NetworkDB) rejected(tname, nid, key, node, reason string) {
+	atomic.AddUint64(&nDB.counters.rejected, 1)
+	rejectedCounter.Inc(tname)
+	logrus.Warnf("%v(%v): rejected entry %s of table %s in network %s from node %s: %s",
+		nDB.config.Hostname, nDB.config.NodeID, key, tname, nid, node, reason)
+	nDB.broadcaster.Write(RejectEvent{
+		Table:     tname,
+		NetworkID: nid,
+		Key:       key,
+		Node:      node,
+		Reason:    reason,
+	})
+}
@@ -0,0 +1,171 @@
+package networkdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultSnapshotInterval = time.Minute
+	defaultSnapshotValidity = 5 * time.Minute
+)
+
+// snapshot is the content of the snapshot file. NodeID is the node the
+// snapshot was taken by, its ID changes at every restart
+type snapshot struct {
+	NodeID  string          `json:"node_id"`
+	Time    time.Time       `json:"time"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+type snapshotEntry struct {
+	Network string `json:"network"`
+	Table   string `json:"table"`
+	Key     string `json:"key"`
+	Node    string `json:"node"`
+	LTime   uint64 `json:"ltime"`
+	Value   []byte `json:"value"`
+}
+
+// saveSnapshot writes the live entries learned from the other nodes to the
+// snapshot file. The entries of this node are left out: they are created
+// again after a restart.
+func (nDB *NetworkDB) saveSnapshot() {
+	path := nDB.config.SnapshotPath
+	s := snapshot{NodeID: nDB.config.NodeID, Time: time.Now().UTC()}
+
+	nDB.RLock()
+	nDB.indexes[byNetwork].Walk(func(p string, v interface{}) bool {
+		e, ok := v.(*entry)
+		if !ok || e.deleting || e.node == nDB.config.NodeID {
+			return false
+		}
+		// p is /<nid>/<table>/<key>
+		params := strings.SplitN(p[1:], "/", 3)
+		if len(params) != 3 {
+			return false
+		}
+		s.Entries = append(s.Entries, snapshotEntry{
+			Network: params[0],
+			Table:   params[1],
+			Key:     params[2],
+			Node:    e.node,
+			LTime:   uint64(e.ltime),
+			Value:   e.value,
+		})
+		return false
+	})
+	nDB.RUnlock()
+
+	if err := writeSnapshot(path, &s); err != nil {
+		logrus.Warnf("%v(%v): failed to save the NetworkDB snapshot %s: %v", nDB.config.Hostname, nDB.config.NodeID, path, err)
+	}
+}
+
+func writeSnapshot(path string, s *snapshot) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// write to a temporary file first so that a crash never leaves a
+	// partial snapshot behind
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// restoreSnapshot loads the entries of the snapshot file, so that they can
+// be served before the bulk syncs with the other nodes complete. The restored
+// entries are replaced by the state received from the cluster, the ones not
+// confirmed within SnapshotValidity are removed.
+func (nDB *NetworkDB) restoreSnapshot() error {
+	path := nDB.config.SnapshotPath
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var s snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid NetworkDB snapshot %s: %v", path, err)
+	}
+	if age := time.Since(s.Time); age > nDB.config.SnapshotValidity {
+		logrus.Infof("%v(%v): ignoring the NetworkDB snapshot %s taken %v ago", nDB.config.Hostname, nDB.config.NodeID, path, age)
+		return nil
+	}
+
+	nDB.Lock()
+	defer nDB.Unlock()
+	for _, se := range s.Entries {
+		// the entries of the previous run of this node are stale
+		if se.Node == nDB.config.NodeID || se.Node == s.NodeID {
+			continue
+		}
+		nDB.tableClock.Witness(serf.LamportTime(se.LTime))
+		nDB.createOrUpdateEntry(se.Network, se.Table, se.Key, &entry{
+			node:     se.Node,
+			ltime:    serf.LamportTime(se.LTime),
+			value:    se.Value,
+			restored: true,
+			reapTime: nDB.config.SnapshotValidity,
+		})
+	}
+	logrus.Infof("%v(%v): restored %d entries from the NetworkDB snapshot %s", nDB.config.Hostname, nDB.config.NodeID, len(s.Entries), path)
+	return nil
+}
+
+// reapRestoredEntries removes the restored entries which were not confirmed
+// by the cluster in time, notifying the watchers
+func (nDB *NetworkDB) reapRestoredEntries() {
+	var expired []event
+	nDB.Lock()
+	nDB.indexes[byNetwork].Walk(func(path string, v interface{}) bool {
+		e, ok := v.(*entry)
+		if !ok || !e.restored {
+			return false
+		}
+		if e.reapTime > reapPeriod {
+			e.reapTime -= reapPeriod
+			return false
+		}
+		params := strings.SplitN(path[1:], "/", 3)
+		if len(params) != 3 {
+			return false
+		}
+		// the tree can't be modified while walking it
+		expired = append(expired, event{NetworkID: params[0], Table: params[1], Key: params[2], Value: e.value})
+		return false
+	})
+	for _, ev := range expired {
+		nDB.deleteEntry(ev.NetworkID, ev.Table, ev.Key)
+	}
+	nDB.Unlock()
+
+	for _, ev := range expired {
+		nDB.broadcaster.Write(makeEvent(opDelete, ev.Table, ev.NetworkID, ev.Key, ev.Value))
+	}
+}
@@ -0,0 +1,83 @@
+package networkdb
+
+import (
+	"strings"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// minTableReapInterval leaves the time to gossip a deletion before the
+// tombstone is garbage collected
+const minTableReapInterval = 6 * reapPeriod
+
+// SetTableReapInterval sets how long the deleted entries of a table are
+// kept as tombstones before being garbage collected. The interval can only
+// be shorter than the default one, the networks are garbage collected
+// after it. A zero interval restores the default.
+func (nDB *NetworkDB) SetTableReapInterval(tname string, interval time.Duration) error {
+	if interval != 0 && (interval < minTableReapInterval || interval > nDB.config.reapEntryInterval) {
+		return types.BadRequestErrorf("reap interval %v of table %s out of the [%v, %v] range", interval, tname, minTableReapInterval, nDB.config.reapEntryInterval)
+	}
+	nDB.Lock()
+	defer nDB.Unlock()
+	if interval == 0 {
+		delete(nDB.tableReapIntervals, tname)
+		return nil
+	}
+	if nDB.tableReapIntervals == nil {
+		nDB.tableReapIntervals = make(map[string]time.Duration)
+	}
+	nDB.tableReapIntervals[tname] = interval
+	return nil
+}
+
+// reapInterval returns the reap interval of the entries of a table, nDB
+// must be locked
+func (nDB *NetworkDB) reapInterval(tname string) time.Duration {
+	if interval, ok := nDB.tableReapIntervals[tname]; ok {
+		return interval
+	}
+	return nDB.config.reapEntryInterval
+}
+
+// CompactTombstones garbage collects the tombstones of a table, of all the
+// tables when tname is empty, deleted for at least minAge. The tombstones
+// prevent stale creations from resurrecting deleted entries: minAge should
+// be long enough for the deletions to have reached all the nodes. It
+// returns the number of tombstones removed.
+func (nDB *NetworkDB) CompactTombstones(tname string, minAge time.Duration) int {
+	prefix := "/"
+	if tname != "" {
+		prefix = "/" + tname + "/"
+	}
+
+	nDB.Lock()
+	defer nDB.Unlock()
+	var expired []string
+	nDB.indexes[byTable].WalkPrefix(prefix, func(path string, v interface{}) bool {
+		e, ok := v.(*entry)
+		if !ok || !e.deleting {
+			return false
+		}
+		// path is /<table>/<nid>/<key>
+		params := strings.SplitN(path[1:], "/", 3)
+		if len(params) != 3 {
+			return false
+		}
+		if age := nDB.reapInterval(params[0]) - e.reapTime; age >= minAge {
+			expired = append(expired, path)
+		}
+		return false
+	})
+	// the tree can't be modified while walking it
+	for _, path := range expired {
+		params := strings.SplitN(path[1:], "/", 3)
+		nDB.deleteEntry(params[1], params[0], params[2])
+	}
+	if len(expired) > 0 {
+		logrus.Infof("%v(%v): compacted %d tombstones", nDB.config.Hostname, nDB.config.NodeID, len(expired))
+	}
+	return len(expired)
+}
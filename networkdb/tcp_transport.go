@@ -0,0 +1,292 @@
+package networkdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-sockaddr"
+	"github.com/hashicorp/memberlist"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// first byte sent on a connection of the TCP transport
+	tcpPacketConn byte = 'p'
+	tcpStreamConn byte = 's'
+
+	tcpPacketTimeout = 5 * time.Second
+	maxTCPPacketSize = 1 << 20
+
+	tcpOnlyProbeInterval = 2 * time.Second
+)
+
+// tcpTransport is a memberlist transport running over TCP only, for the
+// environments where UDP between the nodes is blocked. The memberlist
+// packets are framed on a long lived connection per peer. As memberlist
+// answers a packet to the address it came from, the answers to an
+// incoming connection are sent on the same connection.
+type tcpTransport struct {
+	listener *net.TCPListener
+	bindAddr string
+	packetCh chan *memberlist.Packet
+	streamCh chan net.Conn
+
+	sync.Mutex
+	// connections carrying the packets, keyed by the address of the peer,
+	// the listen address for the dialed ones and the remote address for
+	// the accepted ones
+	conns map[string]*tcpPacketConnection
+	done  chan struct{}
+}
+
+type tcpPacketConnection struct {
+	sync.Mutex
+	conn net.Conn
+}
+
+func newTCPTransport(bindAddr string, bindPort int) (*tcpTransport, error) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP(bindAddr), Port: bindPort})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start the TCP listener on %s:%d: %v", bindAddr, bindPort, err)
+	}
+	t := &tcpTransport{
+		listener: l,
+		bindAddr: bindAddr,
+		packetCh: make(chan *memberlist.Packet),
+		streamCh: make(chan net.Conn),
+		conns:    make(map[string]*tcpPacketConnection),
+		done:     make(chan struct{}),
+	}
+	go t.accept()
+	return t, nil
+}
+
+// FinalAdvertiseAddr follows the logic of the memberlist NetTransport
+func (t *tcpTransport) FinalAdvertiseAddr(ip string, port int) (net.IP, int, error) {
+	if ip != "" {
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return nil, 0, fmt.Errorf("failed to parse advertise address %q", ip)
+		}
+		if ip4 := addr.To4(); ip4 != nil {
+			addr = ip4
+		}
+		return addr, port, nil
+	}
+
+	addr := t.listener.Addr().(*net.TCPAddr)
+	if t.bindAddr != "" && t.bindAddr != "0.0.0.0" {
+		return addr.IP, addr.Port, nil
+	}
+	ip, err := sockaddr.GetPrivateIP()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get interface addresses: %v", err)
+	}
+	if ip == "" {
+		return nil, 0, fmt.Errorf("no private IP address found, and explicit IP not provided")
+	}
+	return net.ParseIP(ip), addr.Port, nil
+}
+
+func (t *tcpTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	if len(b) > maxTCPPacketSize {
+		return time.Time{}, fmt.Errorf("packet of %d bytes too large", len(b))
+	}
+	frame := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(frame, uint32(len(b)))
+	copy(frame[4:], b)
+
+	// a broken cached connection is dialed again once
+	for i := 0; i < 2; i++ {
+		pc, err := t.packetConn(addr)
+		if err != nil {
+			return time.Time{}, err
+		}
+		pc.Lock()
+		pc.conn.SetWriteDeadline(time.Now().Add(tcpPacketTimeout))
+		_, err = pc.conn.Write(frame)
+		pc.Unlock()
+		if err == nil {
+			return time.Now(), nil
+		}
+		t.dropPacketConn(addr, pc)
+		if i == 1 {
+			return time.Time{}, err
+		}
+	}
+	return time.Time{}, nil
+}
+
+// packetConn returns the connection carrying the packets to addr, dialing
+// it if needed
+func (t *tcpTransport) packetConn(addr string) (*tcpPacketConnection, error) {
+	t.Lock()
+	pc, ok := t.conns[addr]
+	t.Unlock()
+	if ok {
+		return pc, nil
+	}
+
+	c, err := t.dial(addr, tcpPacketConn, tcpPacketTimeout)
+	if err != nil {
+		return nil, err
+	}
+	t.Lock()
+	select {
+	case <-t.done:
+		t.Unlock()
+		c.Close()
+		return nil, fmt.Errorf("transport shut down")
+	default:
+	}
+	if existing, ok := t.conns[addr]; ok {
+		t.Unlock()
+		c.Close()
+		return existing, nil
+	}
+	pc = &tcpPacketConnection{conn: c}
+	t.conns[addr] = pc
+	t.Unlock()
+	go t.readPackets(addr, pc)
+	return pc, nil
+}
+
+func (t *tcpTransport) dropPacketConn(addr string, pc *tcpPacketConnection) {
+	t.Lock()
+	if t.conns[addr] == pc {
+		delete(t.conns, addr)
+	}
+	t.Unlock()
+	pc.conn.Close()
+}
+
+func (t *tcpTransport) dial(addr string, kind byte, timeout time.Duration) (net.Conn, error) {
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := c.Write([]byte{kind}); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.SetWriteDeadline(time.Time{})
+	return c, nil
+}
+
+func (t *tcpTransport) PacketCh() <-chan *memberlist.Packet {
+	return t.packetCh
+}
+
+func (t *tcpTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	return t.dial(addr, tcpStreamConn, timeout)
+}
+
+func (t *tcpTransport) StreamCh() <-chan net.Conn {
+	return t.streamCh
+}
+
+func (t *tcpTransport) Shutdown() error {
+	t.Lock()
+	select {
+	case <-t.done:
+		t.Unlock()
+		return nil
+	default:
+	}
+	close(t.done)
+	for addr, pc := range t.conns {
+		pc.conn.Close()
+		delete(t.conns, addr)
+	}
+	t.Unlock()
+	return t.listener.Close()
+}
+
+func (t *tcpTransport) accept() {
+	for {
+		c, err := t.listener.AcceptTCP()
+		if err != nil {
+			select {
+			case <-t.done:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			logrus.Errorf("NetworkDB TCP transport failed to accept connections: %v", err)
+			return
+		}
+		go t.handleConn(c)
+	}
+}
+
+func (t *tcpTransport) handleConn(c net.Conn) {
+	var kind [1]byte
+	c.SetReadDeadline(time.Now().Add(tcpPacketTimeout))
+	if _, err := io.ReadFull(c, kind[:]); err != nil {
+		c.Close()
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+
+	switch kind[0] {
+	case tcpStreamConn:
+		select {
+		case t.streamCh <- c:
+		case <-t.done:
+			c.Close()
+		}
+	case tcpPacketConn:
+		addr := c.RemoteAddr().String()
+		pc := &tcpPacketConnection{conn: c}
+		t.Lock()
+		t.conns[addr] = pc
+		t.Unlock()
+		t.readPackets(addr, pc)
+	default:
+		logrus.Warnf("NetworkDB TCP transport: unknown connection type %d from %s", kind[0], c.RemoteAddr())
+		c.Close()
+	}
+}
+
+// readPackets reads the packets framed on a connection till it fails
+func (t *tcpTransport) readPackets(addr string, pc *tcpPacketConnection) {
+	defer t.dropPacketConn(addr, pc)
+	var l [4]byte
+	for {
+		if _, err := io.ReadFull(pc.conn, l[:]); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(l[:])
+		if n > maxTCPPacketSize {
+			logrus.Warnf("NetworkDB TCP transport: packet of %d bytes from %s too large", n, addr)
+			return
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(pc.conn, buf); err != nil {
+			return
+		}
+		select {
+		case t.packetCh <- &memberlist.Packet{Buf: buf, From: pc.conn.RemoteAddr(), Timestamp: time.Now()}:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// tcpOnlyTimings adjusts the failure detection of memberlist to the TCP
+// transport: the probes are slower over TCP and the TCP fallback ping is
+// pointless
+func tcpOnlyTimings(config *memberlist.Config) {
+	config.ProbeTimeout = time.Second
+	config.ProbeInterval = tcpOnlyProbeInterval
+	config.SuspicionMult = 5
+	config.DisableTcpPings = true
+}
@@ -48,15 +48,7 @@ func (nDB *NetworkDB) Watch(tname, nid, key string) (*events.Channel, func()) {
 
 	if tname != "" || nid != "" || key != "" {
 		matcher = events.MatcherFunc(func(ev events.Event) bool {
-			var evt event
-			switch ev := ev.(type) {
-			case CreateEvent:
-				evt = event(ev)
-			case UpdateEvent:
-				evt = event(ev)
-			case DeleteEvent:
-				evt = event(ev)
-			}
+			evt, _ := eventOf(ev)
 
 			if tname != "" && evt.Table != tname {
 				return false
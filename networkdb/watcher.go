@@ -0,0 +1,312 @@
+package networkdb
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/docker/go-events"
+)
+
+const defaultWatchBufferSize = 1024
+
+// SyncEvent is sent to a watcher after the replay of the matching entries.
+// The entries known to the consumer which were not replayed were deleted.
+type SyncEvent struct{}
+
+// WatchOptions are the filters and the buffering of a watcher. The empty
+// filters act as a wildcard.
+type WatchOptions struct {
+	// Table the entries belong to
+	Table string
+	// Networks the entries belong to
+	Networks []string
+	// KeyPrefix the keys of the entries start with
+	KeyPrefix string
+	// BufferSize is the number of events buffered for a slow consumer,
+	// defaults to 1024. Events are dropped once the buffer is full.
+	BufferSize int
+	// Replay sends the current matching entries as CreateEvents, followed
+	// by a SyncEvent, before the live events
+	Replay bool
+	// Rejections sends the RejectEvents of the matching entries
+	Rejections bool
+}
+
+func (o *WatchOptions) match(tname, nid, key string) bool {
+	if o.Table != "" && tname != o.Table {
+		return false
+	}
+	if len(o.Networks) > 0 && !containsString(o.Networks, nid) {
+		return false
+	}
+	return strings.HasPrefix(key, o.KeyPrefix)
+}
+
+// Watcher receives the table events matching its filters. Unlike the
+// channel returned by Watch, it never blocks NetworkDB: when the consumer
+// falls behind, the events are dropped and Overflow is signaled so that the
+// consumer can Resume the watch.
+type Watcher struct {
+	// C receives the CreateEvent, UpdateEvent, DeleteEvent and SyncEvent
+	// events, and the RejectEvent ones when asked for
+	C <-chan events.Event
+	// Overflow receives a value when events were dropped
+	Overflow <-chan struct{}
+
+	nDB        *NetworkDB
+	opts       WatchOptions
+	sink       events.Sink
+	ch         chan events.Event
+	overflowCh chan struct{}
+	done       chan struct{}
+
+	sync.Mutex
+	cond *sync.Cond
+	// queue holds the events not delivered yet. Its first replayLeft
+	// events are replayed ones, which don't count against the buffer size
+	queue      []events.Event
+	replayLeft int
+	// the live events received during a replay, queued after it
+	replaying  bool
+	pending    []events.Event
+	overflowed bool
+	dropped    uint64
+	closed     bool
+}
+
+// watchSink is the sink of a watcher in the broadcaster
+type watchSink struct {
+	w *Watcher
+}
+
+func (s *watchSink) Write(ev events.Event) error {
+	return s.w.write(ev)
+}
+
+func (s *watchSink) Close() error {
+	s.w.shutdown()
+	return nil
+}
+
+// WatchWithOptions creates a watcher for the entries matching the passed
+// options
+func (nDB *NetworkDB) WatchWithOptions(opts WatchOptions) *Watcher {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultWatchBufferSize
+	}
+	w := &Watcher{
+		nDB:        nDB,
+		opts:       opts,
+		ch:         make(chan events.Event),
+		overflowCh: make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		replaying:  opts.Replay,
+	}
+	w.cond = sync.NewCond(&w.Mutex)
+	w.C = w.ch
+	w.Overflow = w.overflowCh
+	w.sink = events.NewFilter(&watchSink{w: w}, events.MatcherFunc(func(ev events.Event) bool {
+		if r, ok := ev.(RejectEvent); ok {
+			return w.opts.Rejections && w.opts.match(r.Table, r.NetworkID, r.Key)
+		}
+		evt, ok := eventOf(ev)
+		return ok && w.opts.match(evt.Table, evt.NetworkID, evt.Key)
+	}))
+	go w.deliver()
+
+	// the sink is added before walking the tables, so that no event is
+	// missed between the replay and the live events
+	nDB.broadcaster.Add(w.sink)
+	if opts.Replay {
+		w.replay()
+	}
+	return w
+}
+
+// deliver sends the queued events to the consumer
+func (w *Watcher) deliver() {
+	defer close(w.ch)
+	for {
+		w.Lock()
+		for len(w.queue) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if w.closed {
+			w.Unlock()
+			return
+		}
+		ev := w.queue[0]
+		w.queue = w.queue[1:]
+		if w.replayLeft > 0 {
+			w.replayLeft--
+		}
+		w.Unlock()
+
+		select {
+		case w.ch <- ev:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *Watcher) write(ev events.Event) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return events.ErrSinkClosed
+	}
+	if w.replaying {
+		if len(w.pending) >= w.opts.BufferSize {
+			w.overflow()
+			return nil
+		}
+		w.pending = append(w.pending, ev)
+		return nil
+	}
+	// the consumer missed events, the following ones are meaningless
+	// till the watch is resumed
+	if w.overflowed {
+		w.dropped++
+		return nil
+	}
+	w.push(ev)
+	return nil
+}
+
+// push queues a live event, w must be locked
+func (w *Watcher) push(ev events.Event) {
+	if len(w.queue)-w.replayLeft >= w.opts.BufferSize {
+		w.overflow()
+		return
+	}
+	w.queue = append(w.queue, ev)
+	w.cond.Signal()
+}
+
+// overflow drops an event, w must be locked
+func (w *Watcher) overflow() {
+	w.dropped++
+	w.overflowed = true
+	select {
+	case w.overflowCh <- struct{}{}:
+	default:
+	}
+}
+
+// replay queues the current matching entries, a SyncEvent and the live
+// events received in the meantime. The tables are walked without holding
+// the watcher lock: NetworkDB writes some events with its lock held.
+func (w *Watcher) replay() {
+	prefix := "/"
+	if w.opts.Table != "" {
+		prefix = "/" + w.opts.Table + "/"
+	}
+	var evs []events.Event
+	w.nDB.RLock()
+	w.nDB.indexes[byTable].WalkPrefix(prefix, func(path string, v interface{}) bool {
+		e, ok := v.(*entry)
+		if !ok || e.deleting {
+			return false
+		}
+		// path is /<table>/<nid>/<key>
+		params := strings.SplitN(path[1:], "/", 3)
+		if len(params) != 3 || !w.opts.match(params[0], params[1], params[2]) {
+			return false
+		}
+		evs = append(evs, makeEvent(opCreate, params[0], params[1], params[2], e.value))
+		return false
+	})
+	w.nDB.RUnlock()
+	evs = append(evs, SyncEvent{})
+
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return
+	}
+	w.queue = append(w.queue, evs...)
+	w.replayLeft += len(evs)
+	w.replaying = false
+	for _, ev := range w.pending {
+		if w.overflowed {
+			w.dropped++
+			continue
+		}
+		w.push(ev)
+	}
+	w.pending = nil
+	w.cond.Signal()
+}
+
+// Resume clears the overflow and replays the current matching entries, so
+// that the consumer can rebuild its state before the live events
+func (w *Watcher) Resume() {
+	w.Lock()
+	if w.closed || w.replaying {
+		w.Unlock()
+		return
+	}
+	// the replay supersedes the events not delivered yet
+	w.queue = nil
+	w.replayLeft = 0
+	select {
+	case <-w.overflowCh:
+	default:
+	}
+	w.overflowed = false
+	w.replaying = true
+	w.Unlock()
+
+	w.replay()
+}
+
+// Dropped returns the number of events dropped since the watcher was
+// created
+func (w *Watcher) Dropped() uint64 {
+	w.Lock()
+	defer w.Unlock()
+	return w.dropped
+}
+
+// Close removes the watcher and closes C
+func (w *Watcher) Close() error {
+	w.nDB.broadcaster.Remove(w.sink)
+	w.shutdown()
+	return nil
+}
+
+func (w *Watcher) shutdown() {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.queue = nil
+	w.pending = nil
+	close(w.done)
+	w.cond.Broadcast()
+}
+
+// eventOf returns the entry of a table event
+func eventOf(ev events.Event) (event, bool) {
+	switch ev := ev.(type) {
+	case CreateEvent:
+		return event(ev), true
+	case UpdateEvent:
+		return event(ev), true
+	case DeleteEvent:
+		return event(ev), true
+	}
+	return event{}, false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package osl
+
+// CheckpointVersion is the version of the checkpoint format
+const CheckpointVersion = 1
+
+// Checkpoint is the network state of a sandbox, serializable to JSON, from
+// which the state is restored into a new namespace, as needed to checkpoint
+// and restore a container.
+type Checkpoint struct {
+	Version      int                    `json:"version"`
+	Interfaces   []*InterfaceCheckpoint `json:"interfaces,omitempty"`
+	Gateway      string                 `json:"gateway,omitempty"`
+	GatewayIPv6  string                 `json:"gatewayIPv6,omitempty"`
+	StaticRoutes []*RouteCheckpoint     `json:"staticRoutes,omitempty"`
+	Neighbors    []*NeighborCheckpoint  `json:"neighbors,omitempty"`
+	// Iptables and Ip6tables are the iptables-save and ip6tables-save
+	// outputs in the namespace
+	Iptables  string `json:"iptables,omitempty"`
+	Ip6tables string `json:"ip6tables,omitempty"`
+}
+
+// InterfaceCheckpoint is the state of an interface of the sandbox
+type InterfaceCheckpoint struct {
+	SrcName            string   `json:"srcName"`
+	DstName            string   `json:"dstName"`
+	MacAddress         string   `json:"macAddress,omitempty"`
+	Address            string   `json:"address,omitempty"`
+	AddressIPv6        string   `json:"addressIPv6,omitempty"`
+	LinkLocalAddresses []string `json:"linkLocalAddresses,omitempty"`
+	Routes             []string `json:"routes,omitempty"`
+	Bridge             bool     `json:"bridge,omitempty"`
+	Master             string   `json:"master,omitempty"`
+}
+
+// RouteCheckpoint is a static route of the sandbox
+type RouteCheckpoint struct {
+	Destination string `json:"destination"`
+	RouteType   int    `json:"routeType"`
+	NextHop     string `json:"nextHop,omitempty"`
+}
+
+// NeighborCheckpoint is a neighbor entry added to the sandbox
+type NeighborCheckpoint struct {
+	IP       string `json:"ip"`
+	MAC      string `json:"mac"`
+	LinkName string `json:"linkName,omitempty"`
+	Family   int    `json:"family,omitempty"`
+}
@@ -0,0 +1,273 @@
+package osl
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Checkpoint returns the network state of the sandbox: the interfaces with
+// their addresses and routes, the gateways, the static routes, the neighbor
+// entries added to the sandbox and the iptables rules of the namespace
+func (n *networkNamespace) Checkpoint() (*Checkpoint, error) {
+	cp := &Checkpoint{Version: CheckpointVersion}
+
+	n.Lock()
+	ifaces := append([]*nwIface(nil), n.iFaces...)
+	neighbors := append([]*neigh(nil), n.neighbors...)
+	n.Unlock()
+
+	for _, i := range ifaces {
+		ic := &InterfaceCheckpoint{
+			SrcName: i.SrcName(),
+			DstName: i.DstName(),
+			Bridge:  i.Bridge(),
+			Master:  i.Master(),
+		}
+		if mac := i.MacAddress(); mac != nil {
+			ic.MacAddress = mac.String()
+		}
+		if addr := i.Address(); addr != nil {
+			ic.Address = addr.String()
+		}
+		if addr := i.AddressIPv6(); addr != nil {
+			ic.AddressIPv6 = addr.String()
+		}
+		for _, addr := range i.LinkLocalAddresses() {
+			ic.LinkLocalAddresses = append(ic.LinkLocalAddresses, addr.String())
+		}
+		for _, r := range i.Routes() {
+			ic.Routes = append(ic.Routes, r.String())
+		}
+		cp.Interfaces = append(cp.Interfaces, ic)
+	}
+
+	if gw := n.Gateway(); len(gw) > 0 {
+		cp.Gateway = gw.String()
+	}
+	if gw := n.GatewayIPv6(); len(gw) > 0 {
+		cp.GatewayIPv6 = gw.String()
+	}
+
+	for _, r := range n.StaticRoutes() {
+		rc := &RouteCheckpoint{Destination: r.Destination.String(), RouteType: r.RouteType}
+		if r.NextHop != nil {
+			rc.NextHop = r.NextHop.String()
+		}
+		cp.StaticRoutes = append(cp.StaticRoutes, rc)
+	}
+
+	for _, nh := range neighbors {
+		cp.Neighbors = append(cp.Neighbors, &NeighborCheckpoint{
+			IP:       nh.dstIP.String(),
+			MAC:      nh.dstMac.String(),
+			LinkName: nh.linkName,
+			Family:   nh.family,
+		})
+	}
+
+	var err error
+	if cp.Iptables, err = n.saveIptables("iptables-save"); err != nil {
+		return nil, err
+	}
+	if cp.Ip6tables, err = n.saveIptables("ip6tables-save"); err != nil {
+		return nil, err
+	}
+
+	return cp, nil
+}
+
+// RestoreCheckpoint restores the network state of a checkpoint into the
+// sandbox, a new namespace. The interfaces are added back from the host
+// interfaces named as their source, or created for the bridges, with the
+// names they had in the checkpointed sandbox.
+func (n *networkNamespace) RestoreCheckpoint(cp *Checkpoint) error {
+	if cp == nil {
+		return types.BadRequestErrorf("invalid nil checkpoint")
+	}
+	if cp.Version != CheckpointVersion {
+		return types.BadRequestErrorf("unsupported sandbox checkpoint version %d", cp.Version)
+	}
+
+	if err := n.restoreInterfaces(cp.Interfaces); err != nil {
+		return err
+	}
+
+	if cp.Gateway != "" {
+		if err := n.SetGateway(net.ParseIP(cp.Gateway)); err != nil {
+			return fmt.Errorf("failed to restore the gateway %s: %v", cp.Gateway, err)
+		}
+	}
+	if cp.GatewayIPv6 != "" {
+		if err := n.SetGatewayIPv6(net.ParseIP(cp.GatewayIPv6)); err != nil {
+			return fmt.Errorf("failed to restore the IPv6 gateway %s: %v", cp.GatewayIPv6, err)
+		}
+	}
+
+	for _, rc := range cp.StaticRoutes {
+		dst, err := types.ParseCIDR(rc.Destination)
+		if err != nil {
+			return err
+		}
+		r := &types.StaticRoute{Destination: dst, RouteType: rc.RouteType}
+		if rc.NextHop != "" {
+			r.NextHop = net.ParseIP(rc.NextHop)
+		}
+		if err := n.AddStaticRoute(r); err != nil {
+			return fmt.Errorf("failed to restore the route to %s: %v", rc.Destination, err)
+		}
+	}
+
+	for _, nc := range cp.Neighbors {
+		mac, err := net.ParseMAC(nc.MAC)
+		if err != nil {
+			return err
+		}
+		var options []NeighOption
+		if nc.LinkName != "" {
+			options = append(options, n.LinkName(nc.LinkName))
+		}
+		if nc.Family != 0 {
+			options = append(options, n.Family(nc.Family))
+		}
+		if err := n.AddNeighbor(net.ParseIP(nc.IP), mac, false, options...); err != nil {
+			return fmt.Errorf("failed to restore the neighbor %s: %v", nc.IP, err)
+		}
+	}
+
+	if err := n.restoreIptables("iptables-restore", cp.Iptables); err != nil {
+		return err
+	}
+	return n.restoreIptables("ip6tables-restore", cp.Ip6tables)
+}
+
+// restoreInterfaces adds the interfaces back with the names they had
+func (n *networkNamespace) restoreInterfaces(ics []*InterfaceCheckpoint) error {
+	batch := make([]*nwIface, 0, len(ics))
+	names := make(map[*nwIface]string)
+	for _, ic := range ics {
+		options, err := n.interfaceOptions(ic)
+		if err != nil {
+			return fmt.Errorf("failed to restore interface %s: %v", ic.DstName, err)
+		}
+		i := &nwIface{srcName: ic.SrcName, ns: n}
+		i.processInterfaceOptions(options...)
+		batch = append(batch, i)
+		names[i] = ic.DstName
+	}
+
+	return n.addInterfaces(batch, func(i *nwIface) {
+		n.Lock()
+		defer n.Unlock()
+		if n.isDefault {
+			i.dstName = i.srcName
+			return
+		}
+		i.dstName = names[i]
+		// the interfaces added later are not given the restored names
+		prefix, index := splitDstName(i.dstName)
+		if index >= n.nextIfIndex[prefix] {
+			n.nextIfIndex[prefix] = index + 1
+		}
+	})
+}
+
+func (n *networkNamespace) interfaceOptions(ic *InterfaceCheckpoint) ([]IfaceOption, error) {
+	options := []IfaceOption{n.Bridge(ic.Bridge), n.Master(ic.Master)}
+	if ic.MacAddress != "" {
+		mac, err := net.ParseMAC(ic.MacAddress)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, n.MacAddress(mac))
+	}
+	if ic.Address != "" {
+		addr, err := types.ParseCIDR(ic.Address)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, n.Address(addr))
+	}
+	if ic.AddressIPv6 != "" {
+		addr, err := types.ParseCIDR(ic.AddressIPv6)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, n.AddressIPv6(addr))
+	}
+	var llAddrs, routes []*net.IPNet
+	for _, a := range ic.LinkLocalAddresses {
+		addr, err := types.ParseCIDR(a)
+		if err != nil {
+			return nil, err
+		}
+		llAddrs = append(llAddrs, addr)
+	}
+	for _, r := range ic.Routes {
+		route, err := types.ParseCIDR(r)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	return append(options, n.LinkLocalAddresses(llAddrs), n.Routes(routes)), nil
+}
+
+// splitDstName splits the name of an interface in the sandbox into the
+// prefix and the index it was given
+func splitDstName(name string) (string, int) {
+	prefix := strings.TrimRight(name, "0123456789")
+	index, err := strconv.Atoi(name[len(prefix):])
+	if err != nil {
+		return name, 0
+	}
+	return prefix, index
+}
+
+// saveIptables returns the output of the save command in the namespace,
+// nothing when the command is not installed
+func (n *networkNamespace) saveIptables(cmd string) (string, error) {
+	path, err := exec.LookPath(cmd)
+	if err != nil {
+		logrus.Debugf("Not saving the rules of sandbox %s, %s not found", n.nsPath(), cmd)
+		return "", nil
+	}
+	var out []byte
+	if ierr := n.InvokeFunc(func() {
+		out, err = exec.Command(path).Output()
+	}); ierr != nil {
+		return "", ierr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to save the rules of sandbox %s with %s: %v", n.nsPath(), cmd, err)
+	}
+	return string(out), nil
+}
+
+// restoreIptables loads the rules with the restore command in the namespace
+func (n *networkNamespace) restoreIptables(cmd, rules string) error {
+	if rules == "" {
+		return nil
+	}
+	path, err := exec.LookPath(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to restore the rules of sandbox %s: %v", n.nsPath(), err)
+	}
+	var out []byte
+	if ierr := n.InvokeFunc(func() {
+		c := exec.Command(path)
+		c.Stdin = strings.NewReader(rules)
+		out, err = c.CombinedOutput()
+	}); ierr != nil {
+		return ierr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore the rules of sandbox %s with %s: %v: %s", n.nsPath(), cmd, err, out)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"regexp"
+	"runtime"
 	"sync"
 	"syscall"
 	"time"
@@ -197,6 +198,8 @@ func (i *nwIface) Statistics() (*types.InterfaceStatistics, error) {
 		TxBytes:   uint64(stats.TxBytes),
 		RxPackets: uint64(stats.RxPackets),
 		TxPackets: uint64(stats.TxPackets),
+		RxErrors:  uint64(stats.RxErrors),
+		TxErrors:  uint64(stats.TxErrors),
 		RxDropped: uint64(stats.RxDropped),
 		TxDropped: uint64(stats.TxDropped),
 	}, nil
@@ -229,14 +232,139 @@ func (n *networkNamespace) AddInterface(srcName, dstPrefix string, options ...If
 		}
 	}
 
+	n.setDstName(i, dstPrefix)
+	if err := n.setupInterface(i); err != nil {
+		return err
+	}
+
+	n.Lock()
+	n.iFaces = append(n.iFaces, i)
+	n.Unlock()
+
+	n.checkLoV6()
+
+	return nil
+}
+
+// AddInterfaces adds the interfaces like successive AddInterface calls,
+// with the same names, but sets them up in parallel: the bridges first,
+// then the other interfaces, which may have the bridges as master. The
+// interfaces set up before an error are kept in the sandbox.
+func (n *networkNamespace) AddInterfaces(ifaces []InterfaceConfig) error {
+	batch := make([]*nwIface, 0, len(ifaces))
+	for _, cfg := range ifaces {
+		i := &nwIface{srcName: cfg.SrcName, dstName: cfg.DstPrefix, ns: n}
+		i.processInterfaceOptions(cfg.Options...)
+		batch = append(batch, i)
+	}
+	return n.addInterfaces(batch, func(i *nwIface) { n.setDstName(i, i.dstName) })
+}
+
+// addInterfaces sets up the batch of interfaces, named by setName once
+// their masters are found
+func (n *networkNamespace) addInterfaces(batch []*nwIface, setName func(*nwIface)) error {
+	masters := make(map[string]*nwIface)
+	for _, i := range batch {
+		if i.bridge {
+			masters[i.srcName] = i
+		}
+	}
+
+	for _, i := range batch {
+		if i.master == "" || masters[i.master] != nil {
+			continue
+		}
+		i.dstMaster = n.findDst(i.master, true)
+		if i.dstMaster == "" {
+			return fmt.Errorf("could not find an appropriate master %q for %q",
+				i.master, i.srcName)
+		}
+	}
+
+	for _, i := range batch {
+		setName(i)
+	}
+	for _, i := range batch {
+		if m := masters[i.master]; m != nil {
+			i.dstMaster = m.dstName
+		}
+	}
+
+	done := make([]bool, len(batch))
+	err := n.setupInterfaces(batch, done, true)
+	if err == nil {
+		err = n.setupInterfaces(batch, done, false)
+	}
+
+	// the interfaces are listed in the order they were passed
+	n.Lock()
+	for idx, i := range batch {
+		if done[idx] {
+			n.iFaces = append(n.iFaces, i)
+		}
+	}
+	n.Unlock()
+
+	n.checkLoV6()
+
+	return err
+}
+
+// setupInterfaces sets up in parallel the bridges, or the other
+// interfaces, of the batch, and returns the first error. With a single
+// CPU the interfaces are set up in turn, the kernel work of the moves
+// not overlapping anyway.
+func (n *networkNamespace) setupInterfaces(batch []*nwIface, done []bool, bridges bool) error {
+	var (
+		wg       sync.WaitGroup
+		errs     = make([]error, len(batch))
+		parallel = runtime.GOMAXPROCS(0) > 1
+	)
+	for idx, i := range batch {
+		if i.bridge != bridges {
+			continue
+		}
+		if !parallel {
+			if err := n.setupInterface(i); err != nil {
+				return err
+			}
+			done[idx] = true
+			continue
+		}
+		wg.Add(1)
+		go func(idx int, i *nwIface) {
+			defer wg.Done()
+			if errs[idx] = n.setupInterface(i); errs[idx] == nil {
+				done[idx] = true
+			}
+		}(idx, i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setDstName gives the interface its name in the sandbox, the prefix
+// followed by the next index of the prefix
+func (n *networkNamespace) setDstName(i *nwIface, dstPrefix string) {
 	n.Lock()
+	defer n.Unlock()
 	if n.isDefault {
 		i.dstName = i.srcName
 	} else {
 		i.dstName = fmt.Sprintf("%s%d", dstPrefix, n.nextIfIndex[dstPrefix])
 		n.nextIfIndex[dstPrefix]++
 	}
+}
 
+// setupInterface moves the interface into the sandbox, or creates it for a
+// bridge, then configures it and brings it up
+func (n *networkNamespace) setupInterface(i *nwIface) error {
+	n.Lock()
 	path := n.path
 	isDefault := n.isDefault
 	nlh := n.nlHandle
@@ -318,12 +446,6 @@ func (n *networkNamespace) AddInterface(srcName, dstPrefix string, options ...If
 		return fmt.Errorf("error setting interface %q routes to %q: %v", iface.Attrs().Name, i.Routes(), err)
 	}
 
-	n.Lock()
-	n.iFaces = append(n.iFaces, i)
-	n.Unlock()
-
-	n.checkLoV6()
-
 	return nil
 }
 
@@ -335,8 +457,7 @@ func configureInterface(nlh *netlink.Handle, iface netlink.Link, i *nwIface) err
 	}{
 		{setInterfaceName, fmt.Sprintf("error renaming interface %q to %q", ifaceName, i.DstName())},
 		{setInterfaceMAC, fmt.Sprintf("error setting interface %q MAC to %q", ifaceName, i.MacAddress())},
-		{setInterfaceIP, fmt.Sprintf("error setting interface %q IP to %v", ifaceName, i.Address())},
-		{setInterfaceIPv6, fmt.Sprintf("error setting interface %q IPv6 to %v", ifaceName, i.AddressIPv6())},
+		{setInterfaceIPs, fmt.Sprintf("error setting interface %q IP to %v and IPv6 to %v", ifaceName, i.Address(), i.AddressIPv6())},
 		{setInterfaceMaster, fmt.Sprintf("error setting interface %q master to %q", ifaceName, i.DstMaster())},
 		{setInterfaceLinkLocalIPs, fmt.Sprintf("error setting interface %q link local IPs to %v", ifaceName, i.LinkLocalAddresses())},
 	}
@@ -366,28 +487,53 @@ func setInterfaceMAC(nlh *netlink.Handle, iface netlink.Link, i *nwIface) error
 }
 
 func setInterfaceIP(nlh *netlink.Handle, iface netlink.Link, i *nwIface) error {
-	if i.Address() == nil {
-		return nil
-	}
-	if err := checkRouteConflict(nlh, i.Address(), netlink.FAMILY_V4); err != nil {
-		return err
-	}
-	ipAddr := &netlink.Addr{IPNet: i.Address(), Label: ""}
-	return nlh.AddrAdd(iface, ipAddr)
+	return setInterfaceAddresses(nlh, iface, i.Address(), nil, i.ns.path, i.DstName())
 }
 
 func setInterfaceIPv6(nlh *netlink.Handle, iface netlink.Link, i *nwIface) error {
-	if i.AddressIPv6() == nil {
+	return setInterfaceAddresses(nlh, iface, nil, i.AddressIPv6(), i.ns.path, i.DstName())
+}
+
+// setInterfaceIPs programs the IPv4 and IPv6 addresses of the interface,
+// the routes are listed once for the conflict checks of both
+func setInterfaceIPs(nlh *netlink.Handle, iface netlink.Link, i *nwIface) error {
+	return setInterfaceAddresses(nlh, iface, i.Address(), i.AddressIPv6(), i.ns.path, i.DstName())
+}
+
+func setInterfaceAddresses(nlh *netlink.Handle, iface netlink.Link, addr, addrv6 *net.IPNet, path, dstName string) error {
+	if addr == nil && addrv6 == nil {
 		return nil
 	}
-	if err := checkRouteConflict(nlh, i.AddressIPv6(), netlink.FAMILY_V6); err != nil {
+	family := netlink.FAMILY_ALL
+	if addrv6 == nil {
+		family = netlink.FAMILY_V4
+	} else if addr == nil {
+		family = netlink.FAMILY_V6
+	}
+	routes, err := nlh.RouteList(nil, family)
+	if err != nil {
 		return err
 	}
-	if err := setIPv6(i.ns.path, i.DstName(), true); err != nil {
-		return fmt.Errorf("failed to enable ipv6: %v", err)
+	for _, a := range []*net.IPNet{addr, addrv6} {
+		if a == nil {
+			continue
+		}
+		if err := checkRouteConflict(routes, a); err != nil {
+			return err
+		}
+	}
+	if addr != nil {
+		if err := nlh.AddrAdd(iface, &netlink.Addr{IPNet: addr, Label: ""}); err != nil {
+			return err
+		}
+	}
+	if addrv6 != nil {
+		if err := setIPv6(path, dstName, true); err != nil {
+			return fmt.Errorf("failed to enable ipv6: %v", err)
+		}
+		return nlh.AddrAdd(iface, &netlink.Addr{IPNet: addrv6, Label: "", Flags: syscall.IFA_F_NODAD})
 	}
-	ipAddr := &netlink.Addr{IPNet: i.AddressIPv6(), Label: "", Flags: syscall.IFA_F_NODAD}
-	return nlh.AddrAdd(iface, ipAddr)
+	return nil
 }
 
 func setInterfaceLinkLocalIPs(nlh *netlink.Handle, iface netlink.Link, i *nwIface) error {
@@ -443,11 +589,7 @@ func scanInterfaceStats(data, ifName string, i *types.InterfaceStatistics) error
 	return err
 }
 
-func checkRouteConflict(nlh *netlink.Handle, address *net.IPNet, family int) error {
-	routes, err := nlh.RouteList(nil, family)
-	if err != nil {
-		return err
-	}
+func checkRouteConflict(routes []netlink.Route, address *net.IPNet) error {
 	for _, route := range routes {
 		if route.Dst != nil {
 			if route.Dst.Contains(address.IP) || address.Contains(route.Dst.IP) {
@@ -58,6 +58,7 @@ type networkNamespace struct {
 	isDefault    bool
 	nlHandle     *netlink.Handle
 	loV6Enabled  bool
+	sysctls      map[string]string // previous values of the sysctls set
 	sync.Mutex
 }
 
@@ -414,6 +415,40 @@ func (n *networkNamespace) DisableARPForVIP(srcName string) (Err error) {
 	return
 }
 
+// ipipTunnelName is the fallback IPIP tunnel of the namespace, receiving the
+// packets from any tunnel endpoint
+const ipipTunnelName = "tunl0"
+
+func (n *networkNamespace) EnableIPIPForVIP() (Err error) {
+	link, err := n.nlHandle.LinkByName(ipipTunnelName)
+	if err != nil {
+		// Adding an IPIP link loads the ipip module, which creates the
+		// fallback tunnel in every namespace
+		if err := n.nlHandle.LinkAdd(&netlink.Iptun{LinkAttrs: netlink.LinkAttrs{Name: ipipTunnelName}}); err != nil && err != syscall.EEXIST {
+			return fmt.Errorf("failed to create the IPIP tunnel: %v", err)
+		}
+		if link, err = n.nlHandle.LinkByName(ipipTunnelName); err != nil {
+			return fmt.Errorf("failed to find the IPIP tunnel: %v", err)
+		}
+	}
+	if err := n.nlHandle.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring up the IPIP tunnel: %v", err)
+	}
+
+	// The decapsulated packets come from clients not routed through the
+	// tunnel, loose reverse path filtering lets them in
+	err = n.InvokeFunc(func() {
+		path := filepath.Join("/proc/sys/net/ipv4/conf", ipipTunnelName, "rp_filter")
+		if err := ioutil.WriteFile(path, []byte{'2', '\n'}, 0644); err != nil {
+			Err = fmt.Errorf("Failed to set %s to 2: %v", path, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return
+}
+
 func (n *networkNamespace) InvokeFunc(f func()) error {
 	return nsInvoke(n.nsPath(), func(nsFD int) error { return nil }, func(callerFD int) error {
 		f()
@@ -470,6 +505,10 @@ func (n *networkNamespace) Key() string {
 }
 
 func (n *networkNamespace) Destroy() error {
+	// The namespace of an external key outlives the sandbox
+	if err := n.resetAllSysctls(); err != nil {
+		logrus.Warnf("Failed to reset the sysctls of network namespace %q: %v", n.path, err)
+	}
 	if n.nlHandle != nil {
 		n.nlHandle.Delete()
 	}
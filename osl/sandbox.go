@@ -30,6 +30,10 @@ type Sandbox interface {
 	// an appropriate suffix for the DstName to disambiguate.
 	AddInterface(SrcName string, DstPrefix string, options ...IfaceOption) error
 
+	// AddInterfaces adds the interfaces like successive AddInterface calls,
+	// setting up in parallel the interfaces which don't depend on each other
+	AddInterfaces(ifaces []InterfaceConfig) error
+
 	// Set default IPv4 gateway for the sandbox
 	SetGateway(gw net.IP) error
 
@@ -55,6 +59,10 @@ type Sandbox interface {
 	// on a particular interface
 	DisableARPForVIP(ifName string) error
 
+	// EnableIPIPForVIP brings up the IPIP tunnel receiving the traffic for
+	// VIP addresses encapsulated by the load balancers
+	EnableIPIPForVIP() error
+
 	// Add a static route to the sandbox.
 	AddStaticRoute(*types.StaticRoute) error
 
@@ -87,6 +95,29 @@ type Sandbox interface {
 
 	// ApplyOSTweaks applies operating system specific knobs on the sandbox
 	ApplyOSTweaks([]SandboxType)
+
+	// SetSysctls sets network sysctls in the sandbox, and returns the values
+	// they had before it first set them
+	SetSysctls(map[string]string) (map[string]string, error)
+
+	// ResetSysctls sets the sysctls back to their previous values, as
+	// returned by SetSysctls. Destroy resets all the sysctls set.
+	ResetSysctls(prev map[string]string) error
+
+	// Checkpoint returns the network state of the sandbox, to be restored
+	// with RestoreCheckpoint for the checkpoint/restore of a container
+	Checkpoint() (*Checkpoint, error)
+
+	// RestoreCheckpoint restores the network state of a checkpoint into a
+	// new sandbox
+	RestoreCheckpoint(*Checkpoint) error
+}
+
+// InterfaceConfig is an interface added with AddInterfaces
+type InterfaceConfig struct {
+	SrcName   string
+	DstPrefix string
+	Options   []IfaceOption
 }
 
 // NeighborOptionSetter interface defines the option setter methods for interface options
@@ -3,16 +3,21 @@ package osl
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/docker/libnetwork/ns"
 	"github.com/docker/libnetwork/testutils"
 	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
@@ -36,7 +41,7 @@ func generateRandomName(prefix string, size int) (string, error) {
 	return prefix + hex.EncodeToString(id)[:size], nil
 }
 
-func newKey(t *testing.T) (string, error) {
+func newKey(t testing.TB) (string, error) {
 	name, err := generateRandomName("netns", 12)
 	if err != nil {
 		return "", err
@@ -407,3 +412,269 @@ func TestLiveRestore(t *testing.T) {
 		t.Fatalf("Expected route conflict error, but succeeded for IPV4 ")
 	}
 }
+
+func TestCheckpointRestore(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+	s, err := NewSandbox(key, true, false)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	runtime.LockOSThread()
+
+	// the restored interfaces keep their names
+	if err := s.AddInterface("scratchbridge", sboxIfaceName, s.InterfaceOptions().Bridge(true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Info().Interfaces()[0].Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	tbox, err := newInfo(ns.NlHandle(), t)
+	if err != nil {
+		t.Fatalf("Failed to generate new sandbox info: %v", err)
+	}
+	for _, i := range tbox.Info().Interfaces() {
+		err = s.AddInterface(i.SrcName(), i.DstName(),
+			tbox.InterfaceOptions().Bridge(i.Bridge()),
+			tbox.InterfaceOptions().Master(i.Master()),
+			tbox.InterfaceOptions().Address(i.Address()),
+			tbox.InterfaceOptions().AddressIPv6(i.AddressIPv6()))
+		if err != nil {
+			t.Fatalf("Failed to add interfaces to sandbox: %v", err)
+		}
+	}
+	if err := s.SetGateway(net.ParseIP("192.168.1.1")); err != nil {
+		t.Fatal(err)
+	}
+	dst, _ := types.ParseCIDR("10.10.0.0/16")
+	if err := s.AddStaticRoute(&types.StaticRoute{Destination: dst, RouteType: types.NEXTHOP, NextHop: net.ParseIP("192.168.1.1")}); err != nil {
+		t.Fatal(err)
+	}
+	mac, _ := net.ParseMAC("02:42:c0:a8:01:05")
+	if err := s.AddNeighbor(net.ParseIP("192.168.1.5"), mac, false, s.NeighborOptions().LinkName(vethName2)); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err := s.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the checkpointed container is gone with its sandbox
+	for _, i := range s.Info().Interfaces() {
+		if err := i.Remove(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+
+	var restored Checkpoint
+	if err := json.Unmarshal(b, &restored); err != nil {
+		t.Fatal(err)
+	}
+	key, err = newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+	s, err = NewSandbox(key, true, false)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+	if err := s.RestoreCheckpoint(&restored); err != nil {
+		t.Fatal(err)
+	}
+	verifySandbox(t, s, []string{"1", "2", "3"})
+
+	cp2, err := s.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cp, cp2) {
+		t.Fatalf("the restored sandbox state differs:\n%+v\n%+v", cp, cp2)
+	}
+
+	// the interfaces added later are not given the restored names
+	if err := s.AddInterface("testbridge3", sboxIfaceName, s.InterfaceOptions().Bridge(true)); err != nil {
+		t.Fatal(err)
+	}
+	verifySandbox(t, s, []string{"4"})
+
+	if err := s.RestoreCheckpoint(&Checkpoint{Version: CheckpointVersion + 1}); err == nil {
+		t.Fatal("expected an unsupported checkpoint version error")
+	}
+}
+
+func TestAddInterfaces(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+	s, err := NewSandbox(key, true, false)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+	runtime.LockOSThread()
+
+	nlh := ns.NlHandle()
+	for _, names := range [][2]string{{vethName1, vethName2}, {vethName3, vethName4}} {
+		veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: names[0]}, PeerName: names[1]}
+		if err := nlh.LinkAdd(veth); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := s.InterfaceOptions()
+	addr, _ := types.ParseCIDR("192.168.1.100/24")
+	err = s.AddInterfaces([]InterfaceConfig{
+		{SrcName: vethName2, DstPrefix: sboxIfaceName, Options: []IfaceOption{opts.Address(addr), opts.Master("testbridge")}},
+		{SrcName: "testbridge", DstPrefix: sboxIfaceName, Options: []IfaceOption{opts.Bridge(true)}},
+		{SrcName: vethName4, DstPrefix: sboxIfaceName},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifySandbox(t, s, []string{"0", "1", "2"})
+
+	ifaces := s.Info().Interfaces()
+	if ifaces[0].SrcName() != vethName2 || ifaces[1].SrcName() != "testbridge" || ifaces[2].SrcName() != vethName4 {
+		t.Fatalf("the interfaces are not listed in the order they were passed: %v", ifaces)
+	}
+	n := s.(*networkNamespace)
+	if dstMaster := n.iFaces[0].dstMaster; dstMaster != sboxIfaceName+"1" {
+		t.Fatalf("unexpected master %q of %s", dstMaster, vethName2)
+	}
+
+	err = s.AddInterfaces([]InterfaceConfig{
+		{SrcName: vethName1, DstPrefix: sboxIfaceName, Options: []IfaceOption{opts.Master("nobridge")}},
+	})
+	if err == nil {
+		t.Fatal("expected an error for the missing master")
+	}
+}
+
+func benchmarkAddInterfaces(b *testing.B, batch bool) {
+	defer testutils.SetupTestOSContext(b)()
+
+	const count = 8
+	nlh := ns.NlHandle()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		key, err := newKey(b)
+		if err != nil {
+			b.Fatalf("Failed to obtain a key: %v", err)
+		}
+		s, err := NewSandbox(key, true, false)
+		if err != nil {
+			b.Fatalf("Failed to create a new sandbox: %v", err)
+		}
+		runtime.LockOSThread()
+
+		opts := s.InterfaceOptions()
+		ifaces := make([]InterfaceConfig, 0, count)
+		for i := 0; i < count; i++ {
+			name := fmt.Sprintf("bv%d-%d", n, i)
+			veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name + "a"}, PeerName: name + "b"}
+			if err := nlh.LinkAdd(veth); err != nil {
+				b.Fatal(err)
+			}
+			addr, _ := types.ParseCIDR(fmt.Sprintf("192.168.%d.2/24", i))
+			addrv6, _ := types.ParseCIDR(fmt.Sprintf("fdcc:%d::2/64", i))
+			ifaces = append(ifaces, InterfaceConfig{
+				SrcName:   name + "b",
+				DstPrefix: sboxIfaceName,
+				Options:   []IfaceOption{opts.Address(addr), opts.AddressIPv6(addrv6)},
+			})
+		}
+		b.StartTimer()
+
+		if batch {
+			err = s.AddInterfaces(ifaces)
+		} else {
+			for _, i := range ifaces {
+				if err = s.AddInterface(i.SrcName, i.DstPrefix, i.Options...); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		if err := s.Destroy(); err != nil {
+			b.Fatal(err)
+		}
+		os.Remove(key)
+		b.StartTimer()
+	}
+}
+
+func BenchmarkAddInterface(b *testing.B) {
+	benchmarkAddInterfaces(b, false)
+}
+
+func BenchmarkAddInterfaces(b *testing.B) {
+	benchmarkAddInterfaces(b, true)
+}
+
+func TestSetSysctls(t *testing.T) {
+	defer testutils.SetupTestOSContext(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+	s, err := NewSandbox(key, true, false)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	defer s.Destroy()
+
+	readSysctl := func(key string) string {
+		var value []byte
+		if err := s.InvokeFunc(func() { value, err = ioutil.ReadFile(sysctlPath(key)) }); err != nil {
+			t.Fatal(err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(value))
+	}
+
+	const somaxconn = "net.core.somaxconn"
+	old := readSysctl(somaxconn)
+	prev, err := s.SetSysctls(map[string]string{somaxconn: "1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev[somaxconn] != old {
+		t.Fatalf("expected the previous value of %s to be %s, got %s", somaxconn, old, prev[somaxconn])
+	}
+	if v := readSysctl(somaxconn); v != "1234" {
+		t.Fatalf("expected %s to be 1234, got %s", somaxconn, v)
+	}
+	if _, err := s.SetSysctls(map[string]string{"kernel.pid_max": "1"}); err == nil {
+		t.Fatal("expected an error for a sysctl outside of the network sysctls")
+	}
+
+	if err := s.ResetSysctls(prev); err != nil {
+		t.Fatal(err)
+	}
+	if v := readSysctl(somaxconn); v != old {
+		t.Fatalf("expected %s to be reset to %s, got %s", somaxconn, old, v)
+	}
+}
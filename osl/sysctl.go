@@ -0,0 +1,50 @@
+package osl
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SysctlIfname is the placeholder for the name of the sandbox interface of
+// an endpoint in the sysctls of the endpoint, as in
+// "net.ipv4.conf.IFNAME.rp_filter"
+const SysctlIfname = "IFNAME"
+
+var sysctlKeyRe = regexp.MustCompile(`^net(\.[a-zA-Z0-9_-]+)+$`)
+
+// ValidateSysctl returns an error when the key is not a network sysctl or
+// the value is not a single line. The key of an endpoint sysctl may name
+// the sandbox interface of the endpoint with SysctlIfname.
+func ValidateSysctl(key, value string, endpoint bool) error {
+	if !sysctlKeyRe.MatchString(key) {
+		return fmt.Errorf("sysctl %q is not a network sysctl", key)
+	}
+	if !endpoint && hasSysctlIfname(key) {
+		return fmt.Errorf("sysctl %q names the interface of an endpoint", key)
+	}
+	if value == "" || strings.ContainsAny(value, "\n\x00") {
+		return fmt.Errorf("invalid value %q of sysctl %s", value, key)
+	}
+	return nil
+}
+
+// ExpandSysctlIfname replaces SysctlIfname in the key with the interface name
+func ExpandSysctlIfname(key, ifName string) string {
+	parts := strings.Split(key, ".")
+	for i, p := range parts {
+		if p == SysctlIfname {
+			parts[i] = ifName
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func hasSysctlIfname(key string) bool {
+	for _, p := range strings.Split(key, ".") {
+		if p == SysctlIfname {
+			return true
+		}
+	}
+	return false
+}
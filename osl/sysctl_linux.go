@@ -0,0 +1,100 @@
+package osl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func sysctlPath(key string) string {
+	return filepath.Join("/proc/sys", strings.Replace(key, ".", "/", -1))
+}
+
+// SetSysctls sets the sysctls in the namespace, and returns the values they
+// had before it first set them, which Destroy restores
+func (n *networkNamespace) SetSysctls(sysctls map[string]string) (_ map[string]string, Err error) {
+	keys := make([]string, 0, len(sysctls))
+	for k, v := range sysctls {
+		if err := ValidateSysctl(k, v, false); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	prev := make(map[string]string, len(keys))
+	err := n.InvokeFunc(func() {
+		for _, k := range keys {
+			path := sysctlPath(k)
+			old, err := ioutil.ReadFile(path)
+			if err != nil {
+				Err = fmt.Errorf("failed to read sysctl %s: %v", k, err)
+				return
+			}
+			if err := ioutil.WriteFile(path, []byte(sysctls[k]+"\n"), 0644); err != nil {
+				Err = fmt.Errorf("failed to set sysctl %s to %s: %v", k, sysctls[k], err)
+				return
+			}
+			n.Lock()
+			if n.sysctls == nil {
+				n.sysctls = make(map[string]string)
+			}
+			if _, ok := n.sysctls[k]; !ok {
+				n.sysctls[k] = strings.TrimSpace(string(old))
+			}
+			prev[k] = n.sysctls[k]
+			n.Unlock()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prev, Err
+}
+
+// ResetSysctls sets the sysctls back to their previous values, which need
+// not have been set by this namespace, as for a restored sandbox
+func (n *networkNamespace) ResetSysctls(prev map[string]string) (Err error) {
+	if len(prev) == 0 {
+		return nil
+	}
+	for k, v := range prev {
+		if err := ValidateSysctl(k, v, false); err != nil {
+			return err
+		}
+	}
+	n.Lock()
+	for k := range prev {
+		delete(n.sysctls, k)
+	}
+	n.Unlock()
+
+	err := n.InvokeFunc(func() {
+		var failed []string
+		for k, v := range prev {
+			if err := ioutil.WriteFile(sysctlPath(k), []byte(v+"\n"), 0644); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", k, err))
+			}
+		}
+		if len(failed) > 0 {
+			sort.Strings(failed)
+			Err = fmt.Errorf("failed to reset sysctls %v", failed)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return
+}
+
+func (n *networkNamespace) resetAllSysctls() error {
+	n.Lock()
+	prev := make(map[string]string, len(n.sysctls))
+	for k, v := range n.sysctls {
+		prev[k] = v
+	}
+	n.Unlock()
+	return n.ResetSysctls(prev)
+}
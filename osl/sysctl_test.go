@@ -0,0 +1,40 @@
+package osl
+
+import "testing"
+
+func TestValidateSysctl(t *testing.T) {
+	for _, tc := range []struct {
+		key, value string
+		endpoint   bool
+		valid      bool
+	}{
+		{key: "net.core.somaxconn", value: "1024", valid: true},
+		{key: "net.ipv4.ip_forward", value: "1", valid: true},
+		{key: "net.ipv4.conf.IFNAME.rp_filter", value: "2", endpoint: true, valid: true},
+		{key: "net.ipv6.conf.IFNAME.accept_ra", value: "0", endpoint: true, valid: true},
+		{key: "net.ipv4.conf.IFNAME.rp_filter", value: "2"},
+		{key: "kernel.shmmax", value: "1"},
+		{key: "net", value: "1"},
+		{key: "net.ipv4..ip_forward", value: "1"},
+		{key: "net.ipv4/../../kernel", value: "1"},
+		{key: "net.core.somaxconn", value: ""},
+		{key: "net.core.somaxconn", value: "1\n2"},
+	} {
+		err := ValidateSysctl(tc.key, tc.value, tc.endpoint)
+		if tc.valid && err != nil {
+			t.Errorf("expected %s=%q to be valid: %v", tc.key, tc.value, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("expected %s=%q to be invalid", tc.key, tc.value)
+		}
+	}
+}
+
+func TestExpandSysctlIfname(t *testing.T) {
+	if k := ExpandSysctlIfname("net.ipv4.conf.IFNAME.rp_filter", "eth0"); k != "net.ipv4.conf.eth0.rp_filter" {
+		t.Fatalf("unexpected key %s", k)
+	}
+	if k := ExpandSysctlIfname("net.core.somaxconn", "eth0"); k != "net.core.somaxconn" {
+		t.Fatalf("unexpected key %s", k)
+	}
+}
@@ -0,0 +1,304 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// PolicyAction is the action a network policy rule takes on the traffic it
+// matches
+type PolicyAction string
+
+const (
+	// PolicyAllow lets the matched traffic through the policy, to the
+	// regular filtering of the networks
+	PolicyAllow PolicyAction = "allow"
+	// PolicyDeny drops the matched traffic
+	PolicyDeny PolicyAction = "deny"
+)
+
+// PolicySelector selects endpoints. An endpoint is selected when it matches
+// all the set fields, the empty selector selects any address.
+type PolicySelector struct {
+	// Network, by name or id, the endpoints are attached to
+	Network string
+	// Endpoint, by name or id
+	Endpoint string
+	// Labels the sandbox of the endpoints must carry with the same values.
+	// Only the endpoints of the local sandboxes are selected by labels
+	Labels map[string]string
+}
+
+func (s *PolicySelector) empty() bool {
+	return s.Network == "" && s.Endpoint == "" && len(s.Labels) == 0
+}
+
+// PolicyRule allows or denies the traffic from the endpoints of From to the
+// endpoints of To. The rules are evaluated in order, the first rule
+// matching a connection applies.
+type PolicyRule struct {
+	Name   string
+	Action PolicyAction
+	From   PolicySelector
+	To     PolicySelector
+	// Proto and Port restrict the rule to the traffic to the port. Zero
+	// matches all the ports
+	Proto string
+	Port  uint16
+}
+
+// Validate checks whether the rule is valid
+func (r *PolicyRule) Validate() error {
+	if r.Action != PolicyAllow && r.Action != PolicyDeny {
+		return types.BadRequestErrorf("invalid action %q in network policy rule %q", r.Action, r.Name)
+	}
+	switch r.Proto {
+	case "", "tcp", "udp", "sctp":
+	default:
+		return types.BadRequestErrorf("invalid protocol %q in network policy rule %q", r.Proto, r.Name)
+	}
+	if r.Port != 0 && r.Proto == "" {
+		return types.BadRequestErrorf("network policy rule %q has a port but no protocol", r.Name)
+	}
+	return nil
+}
+
+// SetNetworkPolicy replaces the network policy rules of the controller.
+// The rules are compiled to the firewall rules of the host and kept up to
+// date as the endpoints come and go.
+func (c *controller) SetNetworkPolicy(rules []*PolicyRule) error {
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+
+	c.Lock()
+	c.policy = append([]*PolicyRule(nil), rules...)
+	c.Unlock()
+	c.policyMu.Lock()
+	c.policySynced = false
+	c.policyMu.Unlock()
+
+	if err := c.programPolicy(); err != nil {
+		return err
+	}
+	c.startPolicyReconciler()
+	return nil
+}
+
+// NetworkPolicy returns the network policy rules of the controller
+func (c *controller) NetworkPolicy() []*PolicyRule {
+	c.Lock()
+	defer c.Unlock()
+	return append([]*PolicyRule(nil), c.policy...)
+}
+
+// startPolicyReconciler reprograms the policy on the lifecycle events
+// changing the addresses the selectors resolve to
+func (c *controller) startPolicyReconciler() {
+	c.Lock()
+	if c.policyEvents != nil {
+		c.Unlock()
+		return
+	}
+	sub := c.Subscribe()
+	c.policyEvents = sub
+	c.Unlock()
+
+	go func() {
+		for ev := range sub.C {
+			switch ev.Type {
+			case EventNetworkDelete, EventEndpointCreate, EventEndpointDelete,
+				EventEndpointJoin, EventEndpointLeave:
+				if err := c.programPolicy(); err != nil {
+					logrus.Warnf("Failed to reconcile the network policy on %s: %v", ev.Type, err)
+				}
+			}
+		}
+	}()
+}
+
+func (c *controller) stopPolicyReconciler() {
+	c.Lock()
+	sub := c.policyEvents
+	c.policyEvents = nil
+	c.Unlock()
+	if sub != nil {
+		sub.Close()
+	}
+}
+
+// policySetPrefix prefixes the names of the ipsets of the addresses the
+// selectors of the policy rules select
+const policySetPrefix = "DOCKER-POLICY-"
+
+// policyFamilies are the address families the policy is compiled for
+var policyFamilies = []int{types.IPv4, types.IPv6}
+
+// compiledPolicy is the network policy compiled for an address family: the
+// rules of the policy chain, one per policy rule, matching the ipsets of
+// the addresses of its selectors, and the addresses of the ipsets by name
+type compiledPolicy struct {
+	rules [][]string
+	sets  map[string][]string
+}
+
+func (p *compiledPolicy) empty() bool {
+	return p == nil || len(p.rules) == 0
+}
+
+// compilePolicy compiles the policy to the firewall rules implementing it
+// for each address family. A rule whose selectors select no endpoint with
+// an address of the family has no rule in the family.
+func (c *controller) compilePolicy() map[int]*compiledPolicy {
+	c.Lock()
+	policy := c.policy
+	c.Unlock()
+	if len(policy) == 0 {
+		return nil
+	}
+
+	eps := c.policyEndpoints()
+	compiled := make(map[int]*compiledPolicy, len(policyFamilies))
+	for _, ipType := range policyFamilies {
+		p := &compiledPolicy{sets: map[string][]string{}}
+		for i, r := range policy {
+			src, ok := selectPolicyAddrs(&r.From, eps, ipType)
+			if !ok {
+				continue
+			}
+			dst, ok := selectPolicyAddrs(&r.To, eps, ipType)
+			if !ok {
+				continue
+			}
+			name := fmt.Sprintf("%s%s-%d", policySetPrefix, policyFamilyName(ipType), i+1)
+			p.rules = append(p.rules, compilePolicyRule(r, name, src, dst, p.sets))
+		}
+		compiled[ipType] = p
+	}
+	return compiled
+}
+
+// policyFamilyName returns the name of the family in the names of the
+// ipsets of the policy
+func policyFamilyName(ipType int) string {
+	if ipType == types.IPv6 {
+		return "6"
+	}
+	return "4"
+}
+
+// policyEndpoint is an endpoint as the policy selectors see it
+type policyEndpoint struct {
+	networkID, networkName string
+	id, name               string
+	addrs                  []net.IP
+	labels                 map[string]interface{}
+}
+
+func (c *controller) policyEndpoints() []policyEndpoint {
+	var eps []policyEndpoint
+	for _, nw := range c.Networks() {
+		n := nw.(*network)
+		for _, e := range n.Endpoints() {
+			ep := e.(*endpoint)
+			pe := policyEndpoint{networkID: n.ID(), networkName: n.Name(), id: ep.ID(), name: ep.Name()}
+			ep.Lock()
+			if ep.iface != nil && ep.iface.addr != nil {
+				pe.addrs = append(pe.addrs, ep.iface.addr.IP)
+			}
+			if ep.iface != nil && ep.iface.addrv6 != nil {
+				pe.addrs = append(pe.addrs, ep.iface.addrv6.IP)
+			}
+			ep.Unlock()
+			if len(pe.addrs) == 0 {
+				continue
+			}
+			if sb, ok := ep.getSandbox(); ok {
+				pe.labels = sb.Labels()
+			}
+			eps = append(eps, pe)
+		}
+	}
+	return eps
+}
+
+// selectPolicyAddrs returns the addresses of the family of the endpoints
+// the selector selects, nil for the empty selector matching any address.
+// The second return value is false when a non empty selector selects no
+// address of the family.
+func selectPolicyAddrs(s *PolicySelector, eps []policyEndpoint, ipType int) ([]net.IP, bool) {
+	if s.empty() {
+		return nil, true
+	}
+	var addrs []net.IP
+	for _, ep := range eps {
+		if s.Network != "" && s.Network != ep.networkID && s.Network != ep.networkName {
+			continue
+		}
+		if s.Endpoint != "" && s.Endpoint != ep.id && s.Endpoint != ep.name {
+			continue
+		}
+		if !matchPolicyLabels(s.Labels, ep.labels) {
+			continue
+		}
+		for _, a := range ep.addrs {
+			if (a.To4() != nil) == (ipType == types.IPv4) {
+				addrs = append(addrs, a)
+			}
+		}
+	}
+	return addrs, len(addrs) > 0
+}
+
+func matchPolicyLabels(want map[string]string, labels map[string]interface{}) bool {
+	for k, v := range want {
+		l, ok := labels[k]
+		if !ok || fmt.Sprint(l) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// compilePolicyRule returns the firewall rule matching the traffic of the
+// policy rule from the src to the dst addresses, where nil matches any
+// address. The addresses are matched with the ipsets named after the rule,
+// added to sets.
+func compilePolicyRule(r *PolicyRule, name string, src, dst []net.IP, sets map[string][]string) []string {
+	var args []string
+	for _, m := range []struct {
+		addrs []net.IP
+		dir   string
+	}{{src, "src"}, {dst, "dst"}} {
+		if m.addrs == nil {
+			continue
+		}
+		set := name + "-" + strings.ToUpper(m.dir)
+		entries := make([]string, 0, len(m.addrs))
+		for _, a := range m.addrs {
+			entries = append(entries, a.String())
+		}
+		sort.Strings(entries)
+		sets[set] = entries
+		args = append(args, "-m", "set", "--match-set", set, m.dir)
+	}
+	if r.Proto != "" {
+		args = append(args, "-p", r.Proto)
+		if r.Port != 0 {
+			args = append(args, "--dport", strconv.Itoa(int(r.Port)))
+		}
+	}
+	target := "RETURN"
+	if r.Action == PolicyDeny {
+		target = "DROP"
+	}
+	return append(args, "-j", target)
+}
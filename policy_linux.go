@@ -0,0 +1,254 @@
+package libnetwork
+
+import (
+	"fmt"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/libnetwork/firewallapi"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// policyChain holds the rules of the network policy, jumped to from the
+// FORWARD chain right after the DOCKER-USER chain
+const policyChain = "DOCKER-POLICY"
+
+// policyNewChain is the chain the new rules of the policy are programmed in,
+// before it takes the place of the policy chain
+const policyNewChain = policyChain + "-NEW"
+
+// policyOwner is the owner the rules of the policy are tagged with, when the
+// rules are tagged
+const policyOwner = "policy"
+
+// getPolicyFirewall returns the Firewall of the rules of the policy
+var getPolicyFirewall = firewallapi.Get
+
+// policyFirewallFamilies are the families of the firewalls of the address
+// families of the policy
+var policyFirewallFamilies = map[int]firewallapi.Family{types.IPv4: firewallapi.IPv4, types.IPv6: firewallapi.IPv6}
+
+// programPolicy programs the compiled network policy in the policy chain of
+// each family, and the addresses of its selectors in its ipsets. The chain
+// is only rewritten when the compiled rules changed, or were lost to a
+// firewall reload, the ipsets are swapped with their new addresses. The new
+// rules are programmed in a new chain, which the jump of the FORWARD chain
+// is then swapped to, so that the traffic never sees a partial policy.
+func (c *controller) programPolicy() error {
+	compiled := c.compilePolicy()
+
+	c.policyMu.Lock()
+	defer c.policyMu.Unlock()
+	if c.policySynced && reflect.DeepEqual(compiled, c.policyProgrammed) {
+		return nil
+	}
+	c.policyReloadOnce.Do(func() {
+		iptables.OnReloaded(func() {
+			c.policyMu.Lock()
+			c.policySynced = false
+			c.policyMu.Unlock()
+			c.programPolicy()
+		})
+	})
+
+	for _, ipType := range policyFamilies {
+		p, prev := compiled[ipType], c.policyProgrammed[ipType]
+		enabled, option := c.iptablesEnabled(), "iptables"
+		if ipType == types.IPv6 {
+			enabled, option = c.ip6tablesEnabled(), "ip6tables"
+		}
+		if !enabled {
+			if !p.empty() {
+				return types.ForbiddenErrorf("the network policy of the IPv%s endpoints needs the %s rules of the bridge driver, which are disabled", policyFamilyName(ipType), option)
+			}
+			continue
+		}
+		fw, err := getPolicyFirewall(policyFirewallFamilies[ipType])
+		if err != nil {
+			return err
+		}
+
+		if p.empty() {
+			if err := removePolicyChain(fw); err != nil {
+				return err
+			}
+			destroyPolicySets(ipType, nil)
+			continue
+		}
+		if err := syncPolicySets(ipType, p.sets); err != nil {
+			return err
+		}
+		if !c.policySynced || prev.empty() || !reflect.DeepEqual(p.rules, prev.rules) {
+			if err := swapPolicyChain(fw, p.rules); err != nil {
+				return err
+			}
+		}
+		// the ipsets of the rules gone are no longer referenced
+		destroyPolicySets(ipType, p.sets)
+	}
+	c.policyProgrammed, c.policySynced = compiled, true
+	return nil
+}
+
+// swapPolicyChain programs the rules in a new chain and swaps the jump of
+// the FORWARD chain to it, in place of the policy chain it then replaces
+func swapPolicyChain(fw firewallapi.Firewall, rules [][]string) error {
+	if fw.ExistChain(string(iptables.Filter), policyNewChain) {
+		// left over by a failed swap
+		if err := dropPolicyChain(fw, policyNewChain); err != nil {
+			return err
+		}
+	}
+	if err := firewallapi.Run(fw, "-t", string(iptables.Filter), "-N", policyNewChain); err != nil {
+		return fmt.Errorf("failed to create the %s chain: %v", policyNewChain, err)
+	}
+
+	// The policy applies to the connections, the replies are let through
+	established := []string{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "RETURN"}
+	chainRules := make([]firewallapi.Rule, 0, len(rules)+2)
+	for _, args := range append(append([][]string{established}, rules...), []string{"-j", "RETURN"}) {
+		chainRules = append(chainRules, firewallapi.Rule{Table: string(iptables.Filter), Chain: policyNewChain, Args: iptables.OwnedArgs(policyOwner, args)})
+	}
+	if err := fw.ProgramRules(string(iptables.Append), chainRules); err != nil {
+		dropPolicyChain(fw, policyNewChain)
+		return fmt.Errorf("failed to program the network policy: %v", err)
+	}
+
+	if err := swapPolicyJump(fw); err != nil {
+		dropPolicyChain(fw, policyNewChain)
+		return err
+	}
+	if fw.ExistChain(string(iptables.Filter), policyChain) {
+		if err := dropPolicyChain(fw, policyChain); err != nil {
+			return err
+		}
+	}
+	// the jump follows the chain it refers to
+	if err := firewallapi.Run(fw, "-t", string(iptables.Filter), "-E", policyNewChain, policyChain); err != nil {
+		return fmt.Errorf("failed to rename the %s chain: %v", policyNewChain, err)
+	}
+	return nil
+}
+
+// swapPolicyJump replaces the jump of the FORWARD chain to the policy chain
+// with a jump to the new chain, in one command. Without a jump to replace,
+// the jump is inserted after the one to the user chain.
+func swapPolicyJump(fw firewallapi.Firewall) error {
+	if pos := firewallapi.RulePosition(fw, string(iptables.Filter), "FORWARD", []string{"-j", policyChain}); pos > 0 {
+		if err := firewallapi.Run(fw, "-t", string(iptables.Filter), "-R", "FORWARD", strconv.Itoa(pos), "-j", policyNewChain); err != nil {
+			return fmt.Errorf("failed to swap the jump to the %s chain: %v", policyChain, err)
+		}
+		return nil
+	}
+	if err := fw.ProgramRule(string(iptables.Filter), "FORWARD", string(iptables.Insert), []string{"-j", policyNewChain}); err != nil {
+		return fmt.Errorf("failed to add the jump to the %s chain: %v", policyChain, err)
+	}
+	// the rules of the users keep the precedence
+	if fw.Family() == firewallapi.IPv4 {
+		arrangeUserFilterRule()
+	}
+	return nil
+}
+
+// removePolicyChain removes the jump to the policy chain and the chain,
+// when the policy has no rules
+func removePolicyChain(fw firewallapi.Firewall) error {
+	if !fw.ExistChain(string(iptables.Filter), policyChain) {
+		return nil
+	}
+	if err := fw.ProgramRule(string(iptables.Filter), "FORWARD", string(iptables.Delete), []string{"-j", policyChain}); err != nil {
+		return fmt.Errorf("failed to remove the jump to the %s chain: %v", policyChain, err)
+	}
+	return dropPolicyChain(fw, policyChain)
+}
+
+// dropPolicyChain flushes and deletes the chain
+func dropPolicyChain(fw firewallapi.Firewall, chain string) error {
+	if err := firewallapi.Run(fw, "-t", string(iptables.Filter), "-F", chain); err != nil {
+		return fmt.Errorf("failed to flush the %s chain: %v", chain, err)
+	}
+	if err := firewallapi.Run(fw, "-t", string(iptables.Filter), "-X", chain); err != nil {
+		return fmt.Errorf("failed to delete the %s chain: %v", chain, err)
+	}
+	return nil
+}
+
+// ipsetRestore runs the ipset commands of the input in one restore
+var ipsetRestore = func(input string) error {
+	cmd := exec.Command("ipset", "restore", "-exist")
+	cmd.Stdin = strings.NewReader(input)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ipset restore failed: %s (%v)", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// ipsetNames returns the names of the ipsets
+var ipsetNames = func() ([]string, error) {
+	out, err := exec.Command("ipset", "list", "-n").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ipset list failed: %s (%v)", strings.TrimSpace(string(out)), err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// syncPolicySets creates the hash:net ipsets of the family with their
+// addresses. The addresses of an existing ipset are replaced in one swap
+// with a temporary ipset holding the new ones.
+func syncPolicySets(ipType int, sets map[string][]string) error {
+	family := "inet"
+	if ipType == types.IPv6 {
+		family = "inet6"
+	}
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		tmp := name + "-TMP"
+		fmt.Fprintf(&b, "create %s hash:net family %s\n", name, family)
+		fmt.Fprintf(&b, "create %s hash:net family %s\n", tmp, family)
+		fmt.Fprintf(&b, "flush %s\n", tmp)
+		for _, addr := range sets[name] {
+			fmt.Fprintf(&b, "add %s %s\n", tmp, addr)
+		}
+		fmt.Fprintf(&b, "swap %s %s\n", tmp, name)
+		fmt.Fprintf(&b, "destroy %s\n", tmp)
+	}
+	if err := ipsetRestore(b.String()); err != nil {
+		return fmt.Errorf("failed to program the ipsets of the network policy: %v", err)
+	}
+	return nil
+}
+
+// destroyPolicySets destroys the ipsets of the policy of the family which
+// are not in keep. The rules referencing them are gone.
+func destroyPolicySets(ipType int, keep map[string][]string) {
+	names, err := ipsetNames()
+	if err != nil {
+		logrus.Debugf("Failed to list the ipsets of the network policy: %v", err)
+		return
+	}
+	prefix := policySetPrefix + policyFamilyName(ipType) + "-"
+	var b strings.Builder
+	for _, name := range names {
+		if _, ok := keep[name]; ok || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		fmt.Fprintf(&b, "destroy %s\n", name)
+	}
+	if b.Len() == 0 {
+		return
+	}
+	if err := ipsetRestore(b.String()); err != nil {
+		logrus.Warnf("Failed to destroy the stale ipsets of the network policy: %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package libnetwork
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/firewallapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/types"
+)
+
+// policyFirewall is a filter table recording the commands changing it
+type policyFirewall struct {
+	chains map[string][]string
+	cmds   []string
+}
+
+func newPolicyFirewall() *policyFirewall {
+	return &policyFirewall{chains: map[string][]string{"FORWARD": nil}}
+}
+
+func (f *policyFirewall) Family() firewallapi.Family { return firewallapi.IPv4 }
+
+func (f *policyFirewall) Backend() string { return firewallapi.Default }
+
+func (f *policyFirewall) ProgramRule(table, chain, action string, args []string) error {
+	if f.Exists(table, chain, args...) != (action == "-D") {
+		return nil
+	}
+	_, err := f.Raw(append([]string{"-t", table, action, chain}, args...)...)
+	return err
+}
+
+func (f *policyFirewall) ProgramRules(action string, rules []firewallapi.Rule) error {
+	for _, r := range rules {
+		if _, err := f.Raw(append([]string{"-t", r.Table, action, r.Chain}, r.Args...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *policyFirewall) Exists(table, chain string, args ...string) bool {
+	for _, r := range f.chains[chain] {
+		if r == strings.Join(args, " ") {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *policyFirewall) ExistChain(table, chain string) bool {
+	_, ok := f.chains[chain]
+	return ok
+}
+
+func (f *policyFirewall) Raw(args ...string) ([]byte, error) {
+	if args[0] == "-t" {
+		args = args[2:]
+	}
+	if args[0] == "-S" {
+		var b strings.Builder
+		b.WriteString("-P FORWARD ACCEPT\n")
+		for _, r := range f.chains[args[1]] {
+			fmt.Fprintf(&b, "-A %s %s\n", args[1], r)
+		}
+		return []byte(b.String()), nil
+	}
+	f.cmds = append(f.cmds, strings.Join(args, " "))
+	chain := args[1]
+	switch args[0] {
+	case "-N":
+		f.chains[chain] = nil
+	case "-F":
+		f.chains[chain] = nil
+	case "-X":
+		delete(f.chains, chain)
+	case "-E":
+		f.chains[args[2]] = f.chains[chain]
+		delete(f.chains, chain)
+		for c, rules := range f.chains {
+			for i, r := range rules {
+				if r == "-j "+chain {
+					f.chains[c][i] = "-j " + args[2]
+				}
+			}
+		}
+	case "-A":
+		f.chains[chain] = append(f.chains[chain], strings.Join(args[2:], " "))
+	case "-I":
+		f.chains[chain] = append([]string{strings.Join(args[2:], " ")}, f.chains[chain]...)
+	case "-R":
+		var pos int
+		fmt.Sscan(args[2], &pos)
+		f.chains[chain][pos-1] = strings.Join(args[3:], " ")
+	case "-D":
+		rule := strings.Join(args[2:], " ")
+		for i, r := range f.chains[chain] {
+			if r == rule {
+				f.chains[chain] = append(f.chains[chain][:i], f.chains[chain][i+1:]...)
+				break
+			}
+		}
+	}
+	return nil, nil
+}
+
+func TestSwapPolicyChain(t *testing.T) {
+	fw := newPolicyFirewall()
+	fw.chains[policyChain] = []string{"-s 10.0.0.2 -j DROP", "-j RETURN"}
+	fw.chains["FORWARD"] = []string{"-j DOCKER-USER", "-j " + policyChain, "-j DOCKER-ISOLATION-STAGE-1"}
+
+	if err := swapPolicyChain(fw, [][]string{{"-s", "10.0.0.3", "-j", "DROP"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"-j DOCKER-USER", "-j " + policyChain, "-j DOCKER-ISOLATION-STAGE-1"}
+	if !reflect.DeepEqual(fw.chains["FORWARD"], expected) {
+		t.Fatalf("expected the jump to keep its position, got %q", fw.chains["FORWARD"])
+	}
+	expected = []string{"-m conntrack --ctstate RELATED,ESTABLISHED -j RETURN", "-s 10.0.0.3 -j DROP", "-j RETURN"}
+	if !reflect.DeepEqual(fw.chains[policyChain], expected) {
+		t.Fatalf("unexpected policy chain %q", fw.chains[policyChain])
+	}
+	if fw.ExistChain("filter", policyNewChain) {
+		t.Fatal("expected the new chain to replace the policy chain")
+	}
+	// the jump is swapped in one command, the old chain is only deleted
+	// once no longer referenced
+	var swapped bool
+	for _, cmd := range fw.cmds {
+		switch {
+		case cmd == "-R FORWARD 2 -j "+policyNewChain:
+			swapped = true
+		case cmd == "-X "+policyChain && !swapped:
+			t.Fatalf("expected the policy chain to be deleted after the swap, got %q", fw.cmds)
+		case strings.HasPrefix(cmd, "-D FORWARD"):
+			t.Fatalf("expected the jump not to be removed, got %q", fw.cmds)
+		}
+	}
+	if !swapped {
+		t.Fatalf("expected the jump to be swapped, got %q", fw.cmds)
+	}
+
+	if err := removePolicyChain(fw); err != nil {
+		t.Fatal(err)
+	}
+	if fw.ExistChain("filter", policyChain) || fw.Exists("filter", "FORWARD", "-j", policyChain) {
+		t.Fatalf("expected the policy chain and its jump to be removed, got %v", fw.chains)
+	}
+}
+
+func TestIptablesEnabled(t *testing.T) {
+	c := &controller{cfg: &config.Config{Daemon: config.DaemonCfg{DriverCfg: map[string]interface{}{}}}}
+	if !c.iptablesEnabled() {
+		t.Fatal("expected the iptables rules to be enabled by default")
+	}
+	config.OptionDriverConfig("bridge", options.Generic{
+		netlabel.GenericData: options.Generic{"EnableIPTables": false},
+	})(c.cfg)
+	if c.iptablesEnabled() {
+		t.Fatal("expected the iptables rules of the bridge driver to be disabled")
+	}
+	if c.ip6tablesEnabled() {
+		t.Fatal("expected the ip6tables rules to be disabled by default")
+	}
+	config.OptionDriverConfig("bridge", options.Generic{
+		netlabel.GenericData: options.Generic{"EnableIP6Tables": true},
+	})(c.cfg)
+	if !c.ip6tablesEnabled() {
+		t.Fatal("expected the ip6tables rules of the bridge driver to be enabled")
+	}
+}
+
+func TestSyncPolicySets(t *testing.T) {
+	var inputs []string
+	defer func(restore func(string) error, names func() ([]string, error)) {
+		ipsetRestore, ipsetNames = restore, names
+	}(ipsetRestore, ipsetNames)
+	ipsetRestore = func(input string) error {
+		inputs = append(inputs, input)
+		return nil
+	}
+	ipsetNames = func() ([]string, error) {
+		return []string{"DOCKER-POLICY-6-1-SRC", "DOCKER-POLICY-6-2-DST", "DOCKER-POLICY-4-1-SRC", "other"}, nil
+	}
+
+	sets := map[string][]string{"DOCKER-POLICY-6-1-SRC": {"fd00::2", "fd00::3"}}
+	if err := syncPolicySets(types.IPv6, sets); err != nil {
+		t.Fatal(err)
+	}
+	expected := "create DOCKER-POLICY-6-1-SRC hash:net family inet6\n" +
+		"create DOCKER-POLICY-6-1-SRC-TMP hash:net family inet6\n" +
+		"flush DOCKER-POLICY-6-1-SRC-TMP\n" +
+		"add DOCKER-POLICY-6-1-SRC-TMP fd00::2\n" +
+		"add DOCKER-POLICY-6-1-SRC-TMP fd00::3\n" +
+		"swap DOCKER-POLICY-6-1-SRC-TMP DOCKER-POLICY-6-1-SRC\n" +
+		"destroy DOCKER-POLICY-6-1-SRC-TMP\n"
+	if len(inputs) != 1 || inputs[0] != expected {
+		t.Fatalf("unexpected ipset input %q", inputs)
+	}
+
+	// only the stale ipsets of the family are destroyed
+	inputs = nil
+	destroyPolicySets(types.IPv6, sets)
+	if len(inputs) != 1 || inputs[0] != "destroy DOCKER-POLICY-6-2-DST\n" {
+		t.Fatalf("unexpected ipset input %q", inputs)
+	}
+
+	inputs = nil
+	destroyPolicySets(types.IPv4, sets)
+	if len(inputs) != 1 || inputs[0] != "destroy DOCKER-POLICY-4-1-SRC\n" {
+		t.Fatalf("unexpected ipset input %q", inputs)
+	}
+}
@@ -0,0 +1,14 @@
+// +build !linux
+
+package libnetwork
+
+import "github.com/docker/libnetwork/types"
+
+func (c *controller) programPolicy() error {
+	for _, p := range c.compilePolicy() {
+		if !p.empty() {
+			return types.NotImplementedErrorf("network policies are not supported on this platform")
+		}
+	}
+	return nil
+}
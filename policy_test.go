@@ -0,0 +1,77 @@
+package libnetwork
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestPolicyRuleValidate(t *testing.T) {
+	for _, r := range []*PolicyRule{
+		{Name: "action", Action: "reject"},
+		{Name: "proto", Action: PolicyDeny, Proto: "icmp"},
+		{Name: "port", Action: PolicyDeny, Port: 80},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Fatalf("expected rule %q to be invalid", r.Name)
+		}
+	}
+	r := &PolicyRule{Name: "web", Action: PolicyAllow, Proto: "tcp", Port: 80}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompilePolicy(t *testing.T) {
+	eps := []policyEndpoint{
+		{networkID: "n1", networkName: "front", id: "e1", name: "web", addrs: []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("fd00::2")}, labels: map[string]interface{}{"app": "web"}},
+		{networkID: "n1", networkName: "front", id: "e2", name: "cache", addrs: []net.IP{net.ParseIP("10.0.0.3")}},
+		{networkID: "n2", networkName: "back", id: "e3", name: "db", addrs: []net.IP{net.ParseIP("10.1.0.2")}, labels: map[string]interface{}{"app": "db"}},
+	}
+
+	src, ok := selectPolicyAddrs(&PolicySelector{Labels: map[string]string{"app": "web"}}, eps, types.IPv4)
+	if !ok || !reflect.DeepEqual(src, eps[0].addrs[:1]) {
+		t.Fatalf("unexpected source addresses %v", src)
+	}
+	src6, ok := selectPolicyAddrs(&PolicySelector{Labels: map[string]string{"app": "web"}}, eps, types.IPv6)
+	if !ok || !reflect.DeepEqual(src6, eps[0].addrs[1:]) {
+		t.Fatalf("unexpected IPv6 source addresses %v", src6)
+	}
+	dst, ok := selectPolicyAddrs(&PolicySelector{Network: "back"}, eps, types.IPv4)
+	if !ok || !reflect.DeepEqual(dst, eps[2].addrs) {
+		t.Fatalf("unexpected destination addresses %v", dst)
+	}
+	if _, ok := selectPolicyAddrs(&PolicySelector{Network: "back"}, eps, types.IPv6); ok {
+		t.Fatal("expected the selector to select no IPv6 address")
+	}
+	if _, ok := selectPolicyAddrs(&PolicySelector{Network: "front", Endpoint: "db"}, eps, types.IPv4); ok {
+		t.Fatal("expected the selector to select nothing")
+	}
+	if addrs, ok := selectPolicyAddrs(&PolicySelector{}, eps, types.IPv4); !ok || addrs != nil {
+		t.Fatalf("expected the empty selector to match any address, got %v", addrs)
+	}
+
+	sets := map[string][]string{}
+	r := &PolicyRule{Action: PolicyAllow, Proto: "tcp", Port: 5432}
+	expected := []string{"-m", "set", "--match-set", "DOCKER-POLICY-4-1-SRC", "src", "-m", "set", "--match-set", "DOCKER-POLICY-4-1-DST", "dst", "-p", "tcp", "--dport", "5432", "-j", "RETURN"}
+	if rule := compilePolicyRule(r, "DOCKER-POLICY-4-1", src, dst, sets); !reflect.DeepEqual(rule, expected) {
+		t.Fatalf("unexpected rule %q", rule)
+	}
+
+	// one rule matches all the addresses of a selector
+	r = &PolicyRule{Action: PolicyDeny}
+	expected = []string{"-m", "set", "--match-set", "DOCKER-POLICY-4-2-DST", "dst", "-j", "DROP"}
+	if rule := compilePolicyRule(r, "DOCKER-POLICY-4-2", nil, []net.IP{net.ParseIP("10.1.0.3"), net.ParseIP("10.1.0.2")}, sets); !reflect.DeepEqual(rule, expected) {
+		t.Fatalf("unexpected rule %q", rule)
+	}
+	expectedSets := map[string][]string{
+		"DOCKER-POLICY-4-1-SRC": {"10.0.0.2"},
+		"DOCKER-POLICY-4-1-DST": {"10.1.0.2"},
+		"DOCKER-POLICY-4-2-DST": {"10.1.0.2", "10.1.0.3"},
+	}
+	if !reflect.DeepEqual(sets, expectedSets) {
+		t.Fatalf("unexpected ipsets %v", sets)
+	}
+}
@@ -0,0 +1,77 @@
+package portallocator
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ErrPortExcluded is returned when a requested port is in the excluded ports
+type ErrPortExcluded struct {
+	port int
+}
+
+// Port returns the value of the excluded port
+func (e ErrPortExcluded) Port() int {
+	return e.port
+}
+
+// Error is the implementation of error.Error interface
+func (e ErrPortExcluded) Error() string {
+	return fmt.Sprintf("port %d is excluded from the allocation", e.port)
+}
+
+// WithExcludedPorts excludes the ports of the spans from the allocation
+func WithExcludedPorts(spans ...PortSpan) Option {
+	return func(p *PortAllocator) error {
+		return p.setExcludedPorts(spans)
+	}
+}
+
+// SetExcludedPorts replaces the ports excluded from the allocation, for all
+// IPs and protocols. The excluded ports are never allocated, even in the
+// dynamic ranges, and the requests for them fail. The ports already
+// allocated stay allocated.
+func (p *PortAllocator) SetExcludedPorts(spans ...PortSpan) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.setExcludedPorts(spans)
+}
+
+func (p *PortAllocator) setExcludedPorts(spans []PortSpan) error {
+	excluded := make([]PortSpan, 0, len(spans))
+	for _, s := range spans {
+		if s.Begin <= 0 || s.End < s.Begin || s.End > 65535 {
+			return fmt.Errorf("invalid excluded port range %s", s)
+		}
+		excluded = append(excluded, s)
+	}
+	sort.Slice(excluded, func(i, j int) bool { return excluded[i].Begin < excluded[j].Begin })
+
+	// merge the overlapping and adjacent spans
+	merged := excluded[:0]
+	for _, s := range excluded {
+		if n := len(merged); n > 0 && s.Begin <= merged[n-1].End+1 {
+			if s.End > merged[n-1].End {
+				merged[n-1].End = s.End
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	p.excluded = merged
+	return nil
+}
+
+// ExcludedPorts returns the ports excluded from the allocation, as sorted
+// ranges
+func (p *PortAllocator) ExcludedPorts() []PortSpan {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]PortSpan{}, p.excluded...)
+}
+
+// isExcluded returns whether the port is excluded from the allocation
+func (p *PortAllocator) isExcluded(port int) bool {
+	i := sort.Search(len(p.excluded), func(i int) bool { return p.excluded[i].End >= port })
+	return i < len(p.excluded) && p.excluded[i].Begin <= port
+}
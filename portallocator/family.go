@@ -0,0 +1,87 @@
+package portallocator
+
+import (
+	"errors"
+	"net"
+)
+
+// AddrKind is the kind of an address the ports are allocated on
+type AddrKind int
+
+const (
+	// SpecificAddr is an address of an interface
+	SpecificAddr AddrKind = iota
+	// IPv4Any is the IPv4 unspecified address, 0.0.0.0
+	IPv4Any
+	// IPv6Any is the IPv6 unspecified address, ::
+	IPv6Any
+)
+
+func (k AddrKind) String() string {
+	switch k {
+	case IPv4Any:
+		return "v4-any"
+	case IPv6Any:
+		return "v6-any"
+	}
+	return "specific"
+}
+
+// ErrWildcardInUse is returned when the sharing of the port space of the
+// unspecified addresses changes while they have allocated ports
+var ErrWildcardInUse = errors.New("the unspecified addresses have allocated ports")
+
+// KindOf returns the kind of the address, nil being 0.0.0.0. The IPv4-mapped
+// IPv6 addresses are IPv4 addresses.
+func KindOf(ip net.IP) AddrKind {
+	switch {
+	case ip == nil || ip.Equal(net.IPv4zero):
+		return IPv4Any
+	case ip.Equal(net.IPv6unspecified):
+		return IPv6Any
+	}
+	return SpecificAddr
+}
+
+// WithSharedWildcard makes 0.0.0.0 and :: share their ports, see
+// SetSharedWildcard
+func WithSharedWildcard() Option {
+	return func(p *PortAllocator) error {
+		p.sharedWildcard = true
+		return nil
+	}
+}
+
+// SetSharedWildcard sets whether 0.0.0.0 and :: share their ports, as the
+// sockets bound to :: accepting the IPv4 connections do. The shared ports
+// are the ones of 0.0.0.0, with its dynamic ranges, :: has no ports of its
+// own. The sharing can only change while none of the ports of the
+// unspecified addresses is allocated.
+func (p *PortAllocator) SetSharedWildcard(shared bool) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.sharedWildcard == shared {
+		return nil
+	}
+	for _, ipstr := range []string{net.IPv4zero.String(), net.IPv6unspecified.String()} {
+		for _, pm := range p.ipMap[ipstr] {
+			if len(pm.p) > 0 {
+				return ErrWildcardInUse
+			}
+		}
+	}
+	p.sharedWildcard = shared
+	return nil
+}
+
+// poolIP returns the address whose ports are allocated for ip
+func (p *PortAllocator) poolIP(ip net.IP) net.IP {
+	if ip == nil {
+		return defaultIP
+	}
+	if p.sharedWildcard && KindOf(ip) == IPv6Any {
+		return defaultIP
+	}
+	return ip
+}
@@ -1,12 +1,13 @@
-// +build !windows
-
 package portallocator
 
 import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 )
 
 const (
@@ -69,6 +70,25 @@ type (
 		ipMap ipMapping
 		Begin int
 		End   int
+		// state is the record of the ports in the store, restored the
+		// ports loaded from it and not requested yet
+		state    *allocState
+		restored map[AllocatedPort]struct{}
+		// dirty is whether the allocations changed since they were last
+		// written to the store, by the flush armed in flushTimer. storeMu
+		// orders the writes, it is locked before mutex.
+		dirty      bool
+		flushTimer *time.Timer
+		storeMu    sync.Mutex
+		// ranges are the dynamic ranges set for IPs and protocols
+		ranges map[rangeKey]dynamicRange
+		// excluded are the sorted ranges of the ports never allocated
+		excluded []PortSpan
+		strategy Strategy
+		// probe is whether the ports are probed before they are allocated
+		probe bool
+		// sharedWildcard is whether 0.0.0.0 and :: share their ports
+		sharedWildcard bool
 	}
 	portRange struct {
 		begin int
@@ -79,6 +99,10 @@ type (
 		p            map[int]struct{}
 		defaultRange string
 		portRanges   map[string]*portRange
+		// conflicts and exhausted count the requests failed on an
+		// allocated port and on a range without free ports
+		conflicts uint64
+		exhausted uint64
 	}
 	protoMap map[string]*portMap
 )
@@ -100,10 +124,26 @@ func newInstance() *PortAllocator {
 		start, end = DefaultPortRangeStart, DefaultPortRangeEnd
 	}
 	return &PortAllocator{
-		ipMap: ipMapping{},
-		Begin: start,
-		End:   end,
+		ipMap:    ipMapping{},
+		Begin:    start,
+		End:      end,
+		strategy: NewSequential(),
+	}
+}
+
+// Option is a construction option of a PortAllocator
+type Option func(p *PortAllocator) error
+
+// New returns a PortAllocator configured with the options, independent of
+// the default instance returned by Get
+func New(options ...Option) (*PortAllocator, error) {
+	p := newInstance()
+	for _, o := range options {
+		if err := o(p); err != nil {
+			return nil, err
+		}
 	}
+	return p, nil
 }
 
 // RequestPort requests new port from global ports pool for specified ip and proto.
@@ -122,76 +162,260 @@ func (p *PortAllocator) RequestPortInRange(ip net.IP, proto string, portStart, p
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	if proto != "tcp" && proto != "udp" && proto != "sctp" {
+	if !validProto(proto) {
 		return 0, ErrUnknownProtocol
 	}
 
-	if ip == nil {
-		ip = defaultIP
-	}
-	ipstr := ip.String()
-	protomap, ok := p.ipMap[ipstr]
-	if !ok {
-		protomap = protoMap{
-			"tcp":  p.newPortMap(),
-			"udp":  p.newPortMap(),
-			"sctp": p.newPortMap(),
-		}
-
-		p.ipMap[ipstr] = protomap
-	}
-	mapping := protomap[proto]
+	ipstr, mapping := p.portMap(ip, proto)
 	if portStart > 0 && portStart == portEnd {
+		if p.isExcluded(portStart) {
+			mapping.conflicts++
+			return 0, ErrPortExcluded{port: portStart}
+		}
 		if _, ok := mapping.p[portStart]; !ok {
+			if err := p.probeFree(ipstr, proto, portStart); err != nil {
+				mapping.conflicts++
+				return 0, err
+			}
 			mapping.p[portStart] = struct{}{}
+			p.persist()
 			return portStart, nil
 		}
+		if p.claimRestored(ipstr, proto, portStart) {
+			return portStart, nil
+		}
+		mapping.conflicts++
 		return 0, newErrPortAlreadyAllocated(ipstr, portStart)
 	}
 
-	port, err := mapping.findPort(portStart, portEnd)
+	// The owner of a restored port of the range requests it back with the
+	// range it was allocated from
+	if portStart > 0 {
+		if port, ok := p.claimRestoredInRange(ipstr, proto, portStart, portEnd); ok {
+			return port, nil
+		}
+	}
+
+	port, err := p.findPort(ipstr, proto, mapping, portStart, portEnd)
 	if err != nil {
+		if err == ErrAllPortsAllocated {
+			mapping.exhausted++
+		}
 		return 0, err
 	}
+	p.persist()
 	return port, nil
 }
 
+// RequestPortBlock requests count consecutive ports from global ports pool
+// for specified ip and proto, in the range from portStart to portEnd. If
+// portStart and portEnd are 0 the block is in the default ephemeral range.
+// It returns the first port of the block.
+func (p *PortAllocator) RequestPortBlock(ip net.IP, proto string, count, portStart, portEnd int) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !validProto(proto) {
+		return 0, ErrUnknownProtocol
+	}
+	ipstr, mapping := p.portMap(ip, proto)
+	// The restored ports are claimed by the requests of an explicit range,
+	// the dynamic allocations skip them
+	explicit := portStart != 0 || portEnd != 0
+	if !explicit {
+		portStart, portEnd = p.dynamicRange(ipstr, proto)
+	}
+	if count < 1 || portStart <= 0 || portEnd < portStart+count-1 {
+		return 0, fmt.Errorf("invalid port range %d-%d for %d ports", portStart, portEnd, count)
+	}
+
+	for start := portStart; start+count-1 <= portEnd; {
+		busy := 0
+		var err error
+		for port := start; port < start+count; port++ {
+			if _, ok := mapping.p[port]; ok && !(explicit && p.isRestored(ipstr, proto, port)) || p.isExcluded(port) {
+				busy = port
+				break
+			}
+		}
+		for port := start; busy == 0 && port < start+count; port++ {
+			if _, ok := mapping.p[port]; ok {
+				continue
+			}
+			if err = p.probeFree(ipstr, proto, port); err != nil {
+				busy = port
+			}
+		}
+		if busy == 0 {
+			for port := start; port < start+count; port++ {
+				p.claimRestored(ipstr, proto, port)
+				mapping.p[port] = struct{}{}
+			}
+			p.persist()
+			return start, nil
+		}
+		if portEnd == portStart+count-1 {
+			mapping.conflicts++
+			if err != nil {
+				return 0, err
+			}
+			if p.isExcluded(busy) {
+				return 0, ErrPortExcluded{port: busy}
+			}
+			return 0, newErrPortAlreadyAllocated(ipstr, busy)
+		}
+		start = busy + 1
+	}
+	mapping.exhausted++
+	return 0, ErrAllPortsAllocated
+}
+
+// portMap returns the ports of ip and proto
+func (p *PortAllocator) portMap(ip net.IP, proto string) (string, *portMap) {
+	ipstr := p.poolIP(ip).String()
+	protomap, ok := p.ipMap[ipstr]
+	if !ok {
+		protomap = protoMap{}
+		p.ipMap[ipstr] = protomap
+	}
+	mapping, ok := protomap[proto]
+	if !ok {
+		mapping = newPortMap()
+		protomap[proto] = mapping
+	}
+	// the dynamic range may have been changed since the last request
+	mapping.setDefaultRange(p.dynamicRange(ipstr, proto))
+	return ipstr, mapping
+}
+
 // ReleasePort releases port from global ports pool for specified ip and proto.
 func (p *PortAllocator) ReleasePort(ip net.IP, proto string, port int) error {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	if ip == nil {
-		ip = defaultIP
-	}
-	protomap, ok := p.ipMap[ip.String()]
+	ip = p.poolIP(ip)
+	mapping, ok := p.ipMap[ip.String()][proto]
 	if !ok {
 		return nil
 	}
-	delete(protomap[proto].p, port)
+	if _, ok := mapping.p[port]; ok {
+		delete(mapping.p, port)
+		p.strategy.Released(strategyKey(ip.String(), proto), port)
+	}
+	delete(p.restored, AllocatedPort{IP: ip.String(), Proto: proto, Port: port})
+	p.persist()
 	return nil
 }
 
-func (p *PortAllocator) newPortMap() *portMap {
-	defaultKey := getRangeKey(p.Begin, p.End)
-	pm := &portMap{
-		p:            map[int]struct{}{},
-		defaultRange: defaultKey,
-		portRanges: map[string]*portRange{
-			defaultKey: newPortRange(p.Begin, p.End),
-		},
+// Utilization is the use of the ports of an IP and protocol
+type Utilization struct {
+	IP    string `json:"ip"`
+	Proto string `json:"proto"`
+	// Allocated is the number of ports allocated, Dynamic the number of
+	// them in the dynamic range, of DynamicSize ports, and Remaining the
+	// number of free ports of the dynamic range
+	Allocated   int `json:"allocated"`
+	Dynamic     int `json:"dynamic"`
+	DynamicSize int `json:"dynamic_size"`
+	Remaining   int `json:"remaining"`
+	// Ranges are the allocated ports, as the ranges of consecutive ports
+	Ranges []PortSpan `json:"ranges"`
+	// Conflicts is the number of the requests failed on an allocated port,
+	// Exhausted the number of them failed on a range without free ports
+	Conflicts uint64 `json:"conflicts"`
+	Exhausted uint64 `json:"exhausted"`
+}
+
+// PortSpan is a range of ports, from Begin to End
+type PortSpan struct {
+	Begin int `json:"begin"`
+	End   int `json:"end"`
+}
+
+func (s PortSpan) String() string {
+	if s.Begin == s.End {
+		return strconv.Itoa(s.Begin)
 	}
-	return pm
+	return fmt.Sprintf("%d-%d", s.Begin, s.End)
+}
+
+// Utilization returns the use of the ports of the IP and protocol pairs
+// with allocated ports or failed requests, sorted by IP and protocol
+func (p *PortAllocator) Utilization() []Utilization {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	u := []Utilization{}
+	for ip, protomap := range p.ipMap {
+		for proto, pm := range protomap {
+			if len(pm.p) == 0 && pm.conflicts == 0 && pm.exhausted == 0 {
+				continue
+			}
+			begin, end := p.dynamicRange(ip, proto)
+			pu := Utilization{
+				IP:          ip,
+				Proto:       proto,
+				Allocated:   len(pm.p),
+				DynamicSize: end - begin + 1,
+				Ranges:      pm.spans(),
+				Conflicts:   pm.conflicts,
+				Exhausted:   pm.exhausted,
+			}
+			for port := range pm.p {
+				if port >= begin && port <= end {
+					pu.Dynamic++
+				}
+			}
+			pu.Remaining = pu.DynamicSize - pu.Dynamic
+			u = append(u, pu)
+		}
+	}
+	sort.Slice(u, func(i, j int) bool {
+		if u[i].IP != u[j].IP {
+			return u[i].IP < u[j].IP
+		}
+		return u[i].Proto < u[j].Proto
+	})
+	return u
 }
 
 // ReleaseAll releases all ports for all ips.
 func (p *PortAllocator) ReleaseAll() error {
 	p.mutex.Lock()
 	p.ipMap = ipMapping{}
+	p.restored = nil
+	p.persist()
 	p.mutex.Unlock()
 	return nil
 }
 
+// spans returns the allocated ports as the sorted ranges of consecutive
+// ports
+func (pm *portMap) spans() []PortSpan {
+	ports := make([]int, 0, len(pm.p))
+	for port := range pm.p {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	spans := []PortSpan{}
+	for _, port := range ports {
+		if n := len(spans); n > 0 && spans[n-1].End == port-1 {
+			spans[n-1].End = port
+			continue
+		}
+		spans = append(spans, PortSpan{Begin: port, End: port})
+	}
+	return spans
+}
+
+func newPortMap() *portMap {
+	return &portMap{
+		p:          map[int]struct{}{},
+		portRanges: map[string]*portRange{},
+	}
+}
+
 func getRangeKey(portStart, portEnd int) string {
 	return fmt.Sprintf("%d-%d", portStart, portEnd)
 }
@@ -228,24 +452,41 @@ func (pm *portMap) getPortRange(portStart, portEnd int) (*portRange, error) {
 	return pr, nil
 }
 
-func (pm *portMap) findPort(portStart, portEnd int) (int, error) {
+// findPort allocates the free port of the range of the ports of ipstr and
+// proto picked by the strategy, skipping the excluded ports and the ports
+// failing the bind probe
+func (p *PortAllocator) findPort(ipstr, proto string, pm *portMap, portStart, portEnd int) (int, error) {
 	pr, err := pm.getPortRange(portStart, portEnd)
 	if err != nil {
 		return 0, err
 	}
-	port := pr.last
-
-	for i := 0; i <= pr.end-pr.begin; i++ {
-		port++
-		if port > pr.end {
-			port = pr.begin
+	key := strategyKey(ipstr, proto)
+	probed := map[int]struct{}{}
+	free := func(port int) bool {
+		_, ok := pm.p[port]
+		_, failed := probed[port]
+		return !ok && !failed && !p.isExcluded(port)
+	}
+	for {
+		port := p.strategy.Pick(key, pr.begin, pr.end, pr.last, free)
+		if port == 0 {
+			return 0, ErrAllPortsAllocated
 		}
-
-		if _, ok := pm.p[port]; !ok {
-			pm.p[port] = struct{}{}
-			pr.last = port
-			return port, nil
+		pr.last = port
+		if err := p.probeFree(ipstr, proto, port); err != nil {
+			// the port goes back to the strategy, picked last
+			p.strategy.Released(key, port)
+			probed[port] = struct{}{}
+			if len(probed) == maxProbeAttempts {
+				return 0, err
+			}
+			continue
 		}
+		pm.p[port] = struct{}{}
+		return port, nil
 	}
-	return 0, ErrAllPortsAllocated
+}
+
+func strategyKey(ipstr, proto string) string {
+	return ipstr + "/" + proto
 }
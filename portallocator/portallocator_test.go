@@ -2,6 +2,7 @@ package portallocator
 
 import (
 	"net"
+	"reflect"
 	"testing"
 
 	_ "github.com/docker/libnetwork/testutils"
@@ -321,3 +322,379 @@ func TestNoDuplicateBPR(t *testing.T) {
 		t.Fatalf("Acquire(0) allocated the same port twice: %d", port)
 	}
 }
+
+func TestUtilization(t *testing.T) {
+	p := Get()
+	defer resetPortAllocator()
+
+	if u := p.Utilization(); len(u) != 0 {
+		t.Fatalf("Expected no utilization, got %v", u)
+	}
+	for _, port := range []int{0, 0, 5000} {
+		if _, err := p.RequestPort(defaultIP, "tcp", port); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := p.RequestPort(defaultIP, "tcp", 5000); err == nil {
+		t.Fatal("Expected the port to be allocated")
+	}
+	if _, err := p.RequestPort(net.ParseIP("127.0.0.1"), "udp", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetDynamicRange(nil, "sctp", 6000, 6000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(defaultIP, "sctp", 6000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(defaultIP, "sctp", 0); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected the range to be exhausted, got %v", err)
+	}
+	if err := p.ReleasePort(defaultIP, "sctp", 6000); err != nil {
+		t.Fatal(err)
+	}
+
+	u := p.Utilization()
+	if len(u) != 3 {
+		t.Fatalf("Expected the utilization of 3 ip and protocol pairs, got %v", u)
+	}
+	size := p.End - p.Begin + 1
+	expected := []Utilization{
+		{
+			IP: "0.0.0.0", Proto: "sctp", DynamicSize: 1, Remaining: 1,
+			Ranges: []PortSpan{}, Exhausted: 1,
+		},
+		{
+			IP: "0.0.0.0", Proto: "tcp", Allocated: 3, Dynamic: 2, DynamicSize: size, Remaining: size - 2,
+			Ranges: []PortSpan{{5000, 5000}, {p.Begin, p.Begin + 1}}, Conflicts: 1,
+		},
+		{
+			IP: "127.0.0.1", Proto: "udp", Allocated: 1, Dynamic: 1, DynamicSize: size, Remaining: size - 1,
+			Ranges: []PortSpan{{p.Begin, p.Begin}},
+		},
+	}
+	if !reflect.DeepEqual(u, expected) {
+		t.Fatalf("Expected %v got %v", expected, u)
+	}
+}
+
+func TestRequestPortBlock(t *testing.T) {
+	p := Get()
+	defer resetPortAllocator()
+
+	if _, err := p.RequestPort(defaultIP, "udp", 10002); err != nil {
+		t.Fatal(err)
+	}
+	// the block skips the allocated port
+	port, err := p.RequestPortBlock(defaultIP, "udp", 4, 10000, 10010)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 10003 {
+		t.Fatalf("Expected the block to start at 10003, got %d", port)
+	}
+	for i := port; i < port+4; i++ {
+		if _, err := p.RequestPort(defaultIP, "udp", i); err == nil {
+			t.Fatalf("Expected port %d to be allocated", i)
+		}
+	}
+
+	if _, err := p.RequestPortBlock(defaultIP, "udp", 3, 10000, 10002); err == nil {
+		t.Fatal("Expected the exact block to be already allocated")
+	} else if _, ok := err.(ErrPortAlreadyAllocated); !ok {
+		t.Fatalf("Expected ErrPortAlreadyAllocated, got %v", err)
+	}
+	if _, err := p.RequestPortBlock(defaultIP, "udp", 5, 10000, 10010); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected ErrAllPortsAllocated, got %v", err)
+	}
+	if _, err := p.RequestPortBlock(defaultIP, "udp", 5, 10000, 10002); err == nil {
+		t.Fatal("Expected the range to be too small")
+	}
+
+	port, err = p.RequestPortBlock(defaultIP, "tcp", 10, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != p.Begin {
+		t.Fatalf("Expected the block to start at %d, got %d", p.Begin, port)
+	}
+}
+
+func TestRequestPortIPv6(t *testing.T) {
+	p := Get()
+	defer resetPortAllocator()
+
+	if _, err := p.RequestPort(net.ParseIP("2001:db8::1"), "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	// the pools are keyed by address, whatever its notation
+	if _, err := p.RequestPort(net.ParseIP("2001:DB8:0::1"), "tcp", 5000); err == nil {
+		t.Fatal("Expected the port of the IPv6 address to be allocated")
+	}
+	if _, err := p.RequestPort(net.IPv6unspecified, "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(defaultIP, "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.RequestPort(net.ParseIP("127.0.0.1"), "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(net.ParseIP("::ffff:127.0.0.1"), "tcp", 5000); err == nil {
+		t.Fatal("Expected the IPv4-mapped address to share the pool of the IPv4 address")
+	}
+}
+
+func TestRequestPortDCCP(t *testing.T) {
+	p := Get()
+	defer resetPortAllocator()
+
+	port, err := p.RequestPort(defaultIP, "dccp", 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 5000 {
+		t.Fatalf("Expected port 5000 got %d", port)
+	}
+	if _, err := p.RequestPort(defaultIP, "dccp", 5000); err == nil {
+		t.Fatal("Expected the dccp port to be allocated")
+	}
+	// the protocols have their own ports
+	if _, err := p.RequestPort(defaultIP, "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReleasePort(defaultIP, "dccp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(defaultIP, "dccp", 5000); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetDynamicRange(t *testing.T) {
+	p := Get()
+	defer resetPortAllocator()
+
+	ip := net.ParseIP("192.168.0.1")
+	if err := p.SetDynamicRange(nil, "udp", 10000, 10999); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetDynamicRange(ip, "", 20000, 20001); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetDynamicRange(ip, "udp", 30000, 30000); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetDynamicRange(nil, "icmp", 10000, 10999); err != ErrUnknownProtocol {
+		t.Fatalf("Expected the unknown protocol error, got %v", err)
+	}
+	if err := p.SetDynamicRange(nil, "tcp", 2000, 1000); err == nil {
+		t.Fatal("Expected the invalid range to be rejected")
+	}
+
+	for _, c := range []struct {
+		ip    net.IP
+		proto string
+		port  int
+	}{
+		{defaultIP, "tcp", p.Begin},
+		{defaultIP, "udp", 10000},
+		{ip, "tcp", 20000},
+		{ip, "tcp", 20001},
+		{ip, "udp", 30000},
+	} {
+		port, err := p.RequestPort(c.ip, c.proto, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != c.port {
+			t.Fatalf("Expected the %s port %d of %s, got %d", c.proto, c.port, c.ip, port)
+		}
+	}
+	if _, err := p.RequestPort(ip, "udp", 0); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected the range of %s to be exhausted, got %v", ip, err)
+	}
+
+	// removing the range of the IP and protocol falls back on the range of
+	// the IP
+	if err := p.SetDynamicRange(ip, "udp", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if begin, end := p.DynamicRange(ip, "udp"); begin != 20000 || end != 20001 {
+		t.Fatalf("Expected the range 20000-20001, got %d-%d", begin, end)
+	}
+	port, err := p.RequestPort(ip, "udp", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 20000 {
+		t.Fatalf("Expected the udp port 20000, got %d", port)
+	}
+
+	// the range of all IPs and protocols applies when no other one does
+	if err := p.SetDynamicRange(nil, "", 40000, 40999); err != nil {
+		t.Fatal(err)
+	}
+	if begin, end := p.DynamicRange(defaultIP, "tcp"); begin != 40000 || end != 40999 {
+		t.Fatalf("Expected the range 40000-40999, got %d-%d", begin, end)
+	}
+	if begin, end := p.DynamicRange(defaultIP, "udp"); begin != 10000 || end != 10999 {
+		t.Fatalf("Expected the range 10000-10999, got %d-%d", begin, end)
+	}
+	port, err = p.RequestPort(defaultIP, "tcp", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 40000 {
+		t.Fatalf("Expected the tcp port 40000, got %d", port)
+	}
+}
+
+func TestExcludedPorts(t *testing.T) {
+	p, err := New(WithExcludedPorts(PortSpan{10250, 10250}, PortSpan{2380, 2381}, PortSpan{2379, 2379}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []PortSpan{{2379, 2381}, {10250, 10250}}; !reflect.DeepEqual(p.ExcludedPorts(), expected) {
+		t.Fatalf("Expected the excluded ports %v, got %v", expected, p.ExcludedPorts())
+	}
+	if _, err := New(WithExcludedPorts(PortSpan{2380, 2379})); err == nil {
+		t.Fatal("Expected the invalid range to be rejected")
+	}
+
+	if _, err := p.RequestPort(defaultIP, "tcp", 10250); err != (ErrPortExcluded{port: 10250}) {
+		t.Fatalf("Expected the excluded port error, got %v", err)
+	}
+	port, err := p.RequestPortInRange(defaultIP, "udp", 2379, 2383)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 2382 {
+		t.Fatalf("Expected the port 2382 got %d", port)
+	}
+	if port, err = p.RequestPortBlock(defaultIP, "sctp", 2, 2379, 2390); err != nil {
+		t.Fatal(err)
+	}
+	if port != 2382 {
+		t.Fatalf("Expected the block at 2382 got %d", port)
+	}
+	if _, err := p.RequestPortBlock(defaultIP, "sctp", 2, 2380, 2381); err != (ErrPortExcluded{port: 2380}) {
+		t.Fatalf("Expected the excluded port error, got %v", err)
+	}
+
+	// the dynamic range skips the excluded ports
+	if err := p.SetDynamicRange(nil, "tcp", 5000, 5002); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetExcludedPorts(PortSpan{5001, 5002}); err != nil {
+		t.Fatal(err)
+	}
+	if port, err = p.RequestPort(defaultIP, "tcp", 0); err != nil {
+		t.Fatal(err)
+	}
+	if port != 5000 {
+		t.Fatalf("Expected the port 5000 got %d", port)
+	}
+	if _, err := p.RequestPort(defaultIP, "tcp", 0); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected the range to be exhausted, got %v", err)
+	}
+	if _, err := p.RequestPort(defaultIP, "tcp", 10250); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterProtocol(t *testing.T) {
+	p := Get()
+	defer resetPortAllocator()
+
+	if _, err := p.RequestPort(defaultIP, "quic", 5000); err != ErrUnknownProtocol {
+		t.Fatalf("Expected the unknown protocol error, got %v", err)
+	}
+	if err := RegisterProtocol("quic", "udp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterProtocol("quic", ""); err == nil {
+		t.Fatal("Expected the protocol to be registered once")
+	}
+	if err := RegisterProtocol("tcp", "tcp"); err == nil {
+		t.Fatal("Expected the builtin protocol to be registered")
+	}
+	if err := RegisterProtocol("custom", "ip"); err == nil {
+		t.Fatal("Expected the invalid probe network to be rejected")
+	}
+	if expected := []string{"dccp", "quic", "sctp", "tcp", "udp"}; !reflect.DeepEqual(Protocols(), expected) {
+		t.Fatalf("Expected the protocols %v, got %v", expected, Protocols())
+	}
+
+	// the registered protocol has its own ports
+	if _, err := p.RequestPort(defaultIP, "udp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(defaultIP, "quic", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(defaultIP, "quic", 5000); err == nil {
+		t.Fatal("Expected the quic port to be allocated")
+	}
+	if err := p.ReleasePort(defaultIP, "quic", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(defaultIP, "quic", 5000); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSharedWildcard(t *testing.T) {
+	for ip, kind := range map[string]AddrKind{
+		"0.0.0.0":        IPv4Any,
+		"::ffff:0.0.0.0": IPv4Any,
+		"::":             IPv6Any,
+		"127.0.0.1":      SpecificAddr,
+		"2001:db8::1":    SpecificAddr,
+	} {
+		if k := KindOf(net.ParseIP(ip)); k != kind {
+			t.Fatalf("Expected %s to be %s, got %s", ip, kind, k)
+		}
+	}
+
+	p, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the unspecified addresses have their own ports by default
+	if _, err := p.RequestPort(net.IPv4zero, "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(net.IPv6unspecified, "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetSharedWildcard(true); err != ErrWildcardInUse {
+		t.Fatalf("Expected the wildcard in use error, got %v", err)
+	}
+	for _, ip := range []net.IP{net.IPv4zero, net.IPv6unspecified} {
+		if err := p.ReleasePort(ip, "tcp", 5000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := p.SetSharedWildcard(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(net.IPv6unspecified, "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(nil, "tcp", 5000); err == nil {
+		t.Fatal("Expected the port of :: to be allocated on 0.0.0.0")
+	}
+	// the specific addresses keep their own ports
+	if _, err := p.RequestPort(net.ParseIP("::1"), "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReleasePort(net.IPv6unspecified, "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(net.IPv4zero, "tcp", 5000); err != nil {
+		t.Fatal(err)
+	}
+}
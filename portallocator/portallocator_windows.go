@@ -1 +1,7 @@
 package portallocator
+
+// getDynamicPortRange returns the range of the ephemeral host ports, a part
+// of the Windows dynamic port range, which the host services also use
+func getDynamicPortRange() (start int, end int, err error) {
+	return 60000, 65000, nil
+}
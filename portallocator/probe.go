@@ -0,0 +1,87 @@
+package portallocator
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxProbeAttempts is the number of the free ports probed by a dynamic
+// allocation before it gives up
+const maxProbeAttempts = 16
+
+// probePort binds the port of ipstr and proto and closes it, returning the
+// error of the bind. The protocols without a bind probe are not probed.
+var probePort = func(ipstr, proto string, port int) error {
+	addr := net.JoinHostPort(ipstr, strconv.Itoa(port))
+	switch network := probeNetwork(proto); network {
+	case "tcp", "tcp4", "tcp6":
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return err
+		}
+		return l.Close()
+	case "udp", "udp4", "udp6":
+		c, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	}
+	return nil
+}
+
+// ErrPortInUse is returned by the bind probe when a requested port is in
+// use on the host
+type ErrPortInUse struct {
+	ip   string
+	port int
+	err  error
+}
+
+// IP returns the address to which the used port is associated
+func (e ErrPortInUse) IP() string {
+	return e.ip
+}
+
+// Port returns the value of the used port
+func (e ErrPortInUse) Port() int {
+	return e.port
+}
+
+// Error is the implementation of error.Error interface
+func (e ErrPortInUse) Error() string {
+	return fmt.Sprintf("Bind for %s:%d failed: port is in use on the host: %v", e.ip, e.port, e.err)
+}
+
+// WithBindProbe enables the bind probe of the ports, see SetBindProbe
+func WithBindProbe() Option {
+	return func(p *PortAllocator) error {
+		p.probe = true
+		return nil
+	}
+}
+
+// SetBindProbe sets whether the ports of the protocols with a bind probe,
+// such as tcp and udp, are probed before they are allocated, by binding them on their IP and closing them. A requested
+// port or block failing the probe is not allocated, and a dynamic
+// allocation moves on to the next free port, up to a number of attempts.
+func (p *PortAllocator) SetBindProbe(enabled bool) {
+	p.mutex.Lock()
+	p.probe = enabled
+	p.mutex.Unlock()
+}
+
+// probeFree probes the port if the bind probe is enabled
+func (p *PortAllocator) probeFree(ipstr, proto string, port int) error {
+	if !p.probe {
+		return nil
+	}
+	if err := probePort(ipstr, proto, port); err != nil {
+		logrus.Debugf("Port %s/%s:%d failed the bind probe: %v", proto, ipstr, port, err)
+		return ErrPortInUse{ip: ipstr, port: port, err: err}
+	}
+	return nil
+}
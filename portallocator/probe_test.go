@@ -0,0 +1,72 @@
+package portallocator
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestBindProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	used := l.Addr().(*net.TCPAddr).Port
+
+	p, err := New(WithBindProbe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lo := net.ParseIP("127.0.0.1")
+	if _, err := p.RequestPort(lo, "tcp", used); err == nil {
+		t.Fatalf("Expected the port %d in use to fail the probe", used)
+	} else if _, ok := err.(ErrPortInUse); !ok {
+		t.Fatalf("Expected the port in use error, got %v", err)
+	}
+	// the probe is for the protocol of the port
+	if _, err := p.RequestPort(lo, "sctp", used); err != nil {
+		t.Fatal(err)
+	}
+	p.SetBindProbe(false)
+	if _, err := p.RequestPort(lo, "tcp", used); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBindProbeRetry(t *testing.T) {
+	defer func(probe func(string, string, int) error) { probePort = probe }(probePort)
+	probePort = func(ipstr, proto string, port int) error {
+		if port < 5003 {
+			return errors.New("address already in use")
+		}
+		return nil
+	}
+
+	p, err := New(WithBindProbe())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := p.RequestPortInRange(defaultIP, "udp", 5000, 5009)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 5003 {
+		t.Fatalf("Expected the port 5003 got %d", port)
+	}
+	if port, err = p.RequestPortBlock(defaultIP, "udp", 2, 5001, 5009); err != nil {
+		t.Fatal(err)
+	}
+	if port != 5004 {
+		t.Fatalf("Expected the block at 5004 got %d", port)
+	}
+
+	if _, err := p.RequestPortInRange(defaultIP, "udp", 5000, 5002); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected no port to pass the probe, got %v", err)
+	}
+	if _, err := p.RequestPortInRange(defaultIP, "tcp", 1000, 1100); err == nil {
+		t.Fatal("Expected the probe to give up")
+	} else if _, ok := err.(ErrPortInUse); !ok {
+		t.Fatalf("Expected the port in use error, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package portallocator
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	protocolsMu sync.RWMutex
+	// protocols are the registered protocols, with the network the bind
+	// probe binds their ports on
+	protocols = map[string]string{
+		"tcp":  "tcp",
+		"udp":  "udp",
+		"sctp": "",
+		"dccp": "",
+	}
+)
+
+// RegisterProtocol registers the protocol name, whose ports are allocated
+// apart from the ones of the other protocols. probe is the network of the
+// net package the bind probe binds the ports on, such as "udp" for a
+// protocol over UDP, or empty for the ports not to be probed.
+func RegisterProtocol(name, probe string) error {
+	if name == "" {
+		return fmt.Errorf("invalid protocol name")
+	}
+	switch probe {
+	case "", "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+	default:
+		return fmt.Errorf("invalid bind probe network %q of protocol %s", probe, name)
+	}
+
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	if _, ok := protocols[name]; ok {
+		return fmt.Errorf("protocol %s is already registered", name)
+	}
+	protocols[name] = probe
+	return nil
+}
+
+// Protocols returns the sorted names of the registered protocols
+func Protocols() []string {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validProto(proto string) bool {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	_, ok := protocols[proto]
+	return ok
+}
+
+// probeNetwork returns the network the ports of proto are probed on
+func probeNetwork(proto string) string {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	return protocols[proto]
+}
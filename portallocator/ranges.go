@@ -0,0 +1,82 @@
+package portallocator
+
+import (
+	"fmt"
+	"net"
+)
+
+// rangeKey is the IP and protocol a dynamic range applies to, the empty
+// strings standing for all of them
+type rangeKey struct {
+	ip    string
+	proto string
+}
+
+type dynamicRange struct {
+	begin int
+	end   int
+}
+
+// SetDynamicRange sets the range of the ports allocated dynamically for the
+// IP and protocol, instead of the range from Begin to End. A nil IP sets the
+// range of all IPs, and an empty protocol the range of all protocols: the
+// range of the IP and protocol takes precedence over the range of the IP,
+// then over the one of the protocol, then over the one of all IPs and
+// protocols. The allocated ports are kept, and a
+// begin and end of 0 remove the range.
+func (p *PortAllocator) SetDynamicRange(ip net.IP, proto string, begin, end int) error {
+	if proto != "" && !validProto(proto) {
+		return ErrUnknownProtocol
+	}
+	k := rangeKey{proto: proto}
+	if ip != nil {
+		k.ip = ip.String()
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if begin == 0 && end == 0 {
+		delete(p.ranges, k)
+		return nil
+	}
+	if begin <= 0 || end < begin || end > 65535 {
+		return fmt.Errorf("invalid dynamic port range %d-%d", begin, end)
+	}
+	if p.ranges == nil {
+		p.ranges = map[rangeKey]dynamicRange{}
+	}
+	p.ranges[k] = dynamicRange{begin: begin, end: end}
+	return nil
+}
+
+// DynamicRange returns the range of the ports allocated dynamically for the
+// IP and protocol
+func (p *PortAllocator) DynamicRange(ip net.IP, proto string) (int, int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.dynamicRange(p.poolIP(ip).String(), proto)
+}
+
+// dynamicRange returns the dynamic range of ipstr and proto
+func (p *PortAllocator) dynamicRange(ipstr, proto string) (int, int) {
+	for _, k := range []rangeKey{{ipstr, proto}, {ipstr, ""}, {"", proto}, {"", ""}} {
+		if r, ok := p.ranges[k]; ok {
+			return r.begin, r.end
+		}
+	}
+	return p.Begin, p.End
+}
+
+// setDefaultRange makes the range from begin to end the dynamic range of the
+// ports, resuming from its last allocated port if it was in use before
+func (pm *portMap) setDefaultRange(begin, end int) {
+	key := getRangeKey(begin, end)
+	if pm.defaultRange == key {
+		return
+	}
+	if _, ok := pm.portRanges[key]; !ok {
+		pm.portRanges[key] = newPortRange(begin, end)
+	}
+	pm.defaultRange = key
+}
@@ -0,0 +1,290 @@
+package portallocator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/sirupsen/logrus"
+)
+
+const allocStateKey = "portallocator"
+
+// allocState is the record of the allocated ports in the datastore
+type allocState struct {
+	// Ports are the allocated ports by IP and protocol
+	Ports    map[string]map[string][]int
+	dbIndex  uint64
+	dbExists bool
+	store    datastore.DataStore
+}
+
+// Key provides the Key to be used in KV Store
+func (s *allocState) Key() []string {
+	return []string{allocStateKey}
+}
+
+// KeyPrefix returns the immediate parent key that can be used for tree walk
+func (s *allocState) KeyPrefix() []string {
+	return []string{allocStateKey}
+}
+
+// Value marshals the data to be stored in the KV store
+func (s *allocState) Value() []byte {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// SetValue unmarshals the data from the KV store
+func (s *allocState) SetValue(value []byte) error {
+	return json.Unmarshal(value, s)
+}
+
+// Index returns the latest DB Index as seen by this object
+func (s *allocState) Index() uint64 {
+	return s.dbIndex
+}
+
+// SetIndex method allows the datastore to store the latest DB Index into this object
+func (s *allocState) SetIndex(index uint64) {
+	s.dbIndex = index
+	s.dbExists = true
+}
+
+// Exists method is true if this object has been stored in the DB.
+func (s *allocState) Exists() bool {
+	return s.dbExists
+}
+
+// New method returns an allocation state based on the receiver
+func (s *allocState) New() datastore.KVObject {
+	return &allocState{store: s.store}
+}
+
+// CopyTo deep copies the allocation state into the passed destination object
+func (s *allocState) CopyTo(o datastore.KVObject) error {
+	dst := o.(*allocState)
+	dst.Ports = make(map[string]map[string][]int, len(s.Ports))
+	for ip, protos := range s.Ports {
+		dst.Ports[ip] = make(map[string][]int, len(protos))
+		for proto, ports := range protos {
+			dst.Ports[ip][proto] = append([]int(nil), ports...)
+		}
+	}
+	dst.dbIndex = s.dbIndex
+	dst.dbExists = s.dbExists
+	dst.store = s.store
+	return nil
+}
+
+// Skip provides a way for a KV Object to avoid persisting it in the KV Store
+func (s *allocState) Skip() bool {
+	return false
+}
+
+// DataScope method returns the storage scope of the datastore
+func (s *allocState) DataScope() string {
+	return s.store.Scope()
+}
+
+// AllocatedPort is a port of an IP and protocol
+type AllocatedPort struct {
+	IP    string `json:"ip"`
+	Proto string `json:"proto"`
+	Port  int    `json:"port"`
+}
+
+// SetStore persists the allocated ports to the datastore, which is
+// expected to be the local scope store. The ports persisted by a previous
+// run are loaded and held as restored: the dynamic allocations skip them,
+// and a request for one of them, or for a range or a block including it,
+// claims it. Once the owners of the ports requested them back, Reconcile
+// releases the ones left unclaimed. The changes are written shortly after
+// they are made, batching the ones of a burst of allocations, Flush writes
+// them at once. A nil store stops the persistence.
+func (p *PortAllocator) SetStore(ds datastore.DataStore) error {
+	p.mutex.Lock()
+	p.restored = nil
+	if ds == nil {
+		p.state = nil
+		p.mutex.Unlock()
+		return nil
+	}
+
+	s := &allocState{store: ds}
+	err := ds.GetObject(datastore.Key(s.Key()...), s)
+	if err != nil && err != datastore.ErrKeyNotFound {
+		p.mutex.Unlock()
+		return fmt.Errorf("failed to get the port allocations from the store: %v", err)
+	}
+	p.state = s
+
+	p.restored = map[AllocatedPort]struct{}{}
+	for ipstr, protos := range s.Ports {
+		ip := net.ParseIP(ipstr)
+		if ip == nil {
+			logrus.Warnf("Discarding the port allocations of the invalid address %q in the store", ipstr)
+			continue
+		}
+		for proto, ports := range protos {
+			if !validProto(proto) {
+				continue
+			}
+			_, mapping := p.portMap(ip, proto)
+			for _, port := range ports {
+				if _, ok := mapping.p[port]; ok {
+					continue
+				}
+				mapping.p[port] = struct{}{}
+				p.restored[AllocatedPort{IP: ipstr, Proto: proto, Port: port}] = struct{}{}
+			}
+		}
+	}
+	if len(p.restored) > 0 {
+		logrus.Debugf("Restored %d port allocations from the store", len(p.restored))
+	}
+	p.dirty = true
+	p.mutex.Unlock()
+	return p.Flush()
+}
+
+// Reconcile releases the restored ports which were not requested since
+// SetStore, whose owners are gone, and returns them sorted by IP, protocol
+// and port.
+func (p *PortAllocator) Reconcile() ([]AllocatedPort, error) {
+	p.mutex.Lock()
+	stale := make([]AllocatedPort, 0, len(p.restored))
+	for ap := range p.restored {
+		delete(p.ipMap[ap.IP][ap.Proto].p, ap.Port)
+		stale = append(stale, ap)
+	}
+	p.restored = nil
+	p.dirty = true
+	p.mutex.Unlock()
+
+	sort.Slice(stale, func(i, j int) bool {
+		if stale[i].IP != stale[j].IP {
+			return stale[i].IP < stale[j].IP
+		}
+		if stale[i].Proto != stale[j].Proto {
+			return stale[i].Proto < stale[j].Proto
+		}
+		return stale[i].Port < stale[j].Port
+	})
+	for _, ap := range stale {
+		logrus.Debugf("Released the stale port allocation %s/%s:%d", ap.Proto, ap.IP, ap.Port)
+	}
+	return stale, p.Flush()
+}
+
+// isRestored returns whether the port of ip and proto is restored
+func (p *PortAllocator) isRestored(ipstr, proto string, port int) bool {
+	_, ok := p.restored[AllocatedPort{IP: ipstr, Proto: proto, Port: port}]
+	return ok
+}
+
+// claimRestored claims the restored port of ip and proto, and returns
+// whether it was restored
+func (p *PortAllocator) claimRestored(ipstr, proto string, port int) bool {
+	ap := AllocatedPort{IP: ipstr, Proto: proto, Port: port}
+	if _, ok := p.restored[ap]; !ok {
+		return false
+	}
+	delete(p.restored, ap)
+	return true
+}
+
+// claimRestoredInRange claims the lowest restored port of ip and proto in
+// the range, and returns it
+func (p *PortAllocator) claimRestoredInRange(ipstr, proto string, portStart, portEnd int) (int, bool) {
+	port := 0
+	for ap := range p.restored {
+		if ap.IP == ipstr && ap.Proto == proto && ap.Port >= portStart && ap.Port <= portEnd && (port == 0 || ap.Port < port) {
+			port = ap.Port
+		}
+	}
+	if port == 0 {
+		return 0, false
+	}
+	return port, p.claimRestored(ipstr, proto, port)
+}
+
+// persistDelay is the delay of the writes of the allocated ports to the
+// store after a change
+const persistDelay = 100 * time.Millisecond
+
+// persist schedules the write of the allocated ports after a change, if a
+// store is set. It is called with the mutex held.
+func (p *PortAllocator) persist() {
+	if p.state == nil {
+		return
+	}
+	p.dirty = true
+	if p.flushTimer == nil {
+		p.flushTimer = time.AfterFunc(persistDelay, func() {
+			if err := p.Flush(); err != nil {
+				logrus.Warn(err)
+			}
+		})
+	}
+}
+
+// Flush writes the changes of the allocated ports not written yet to the
+// store
+func (p *PortAllocator) Flush() error {
+	p.storeMu.Lock()
+	defer p.storeMu.Unlock()
+
+	p.mutex.Lock()
+	if p.flushTimer != nil {
+		p.flushTimer.Stop()
+		p.flushTimer = nil
+	}
+	state := p.state
+	if state == nil || !p.dirty {
+		p.mutex.Unlock()
+		return nil
+	}
+	ports := p.allocatedPorts()
+	p.dirty = false
+	p.mutex.Unlock()
+
+	state.Ports = ports
+	if err := state.store.PutObject(state); err != nil {
+		p.mutex.Lock()
+		if p.state == state {
+			p.dirty = true
+		}
+		p.mutex.Unlock()
+		return fmt.Errorf("failed to persist the port allocations: %v", err)
+	}
+	return nil
+}
+
+// allocatedPorts returns the allocated ports by IP and protocol, sorted
+func (p *PortAllocator) allocatedPorts() map[string]map[string][]int {
+	ports := map[string]map[string][]int{}
+	for ipstr, protomap := range p.ipMap {
+		for proto, pm := range protomap {
+			if len(pm.p) == 0 {
+				continue
+			}
+			if ports[ipstr] == nil {
+				ports[ipstr] = map[string][]int{}
+			}
+			list := make([]int, 0, len(pm.p))
+			for port := range pm.p {
+				list = append(list, port)
+			}
+			sort.Ints(list)
+			ports[ipstr][proto] = list
+		}
+	}
+	return ports
+}
@@ -0,0 +1,158 @@
+package portallocator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libkv/store/boltdb"
+	"github.com/docker/libnetwork/datastore"
+)
+
+func newTestStore(t *testing.T, dir string) datastore.DataStore {
+	ds, err := datastore.NewDataStore(datastore.LocalScope, &datastore.ScopeCfg{
+		Client: datastore.ScopeClientCfg{
+			Provider: string(store.BOLTDB),
+			Address:  filepath.Join(dir, "local-kv.db"),
+			Config:   &store.Config{Bucket: "libnetwork"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds
+}
+
+func TestPersistAllocations(t *testing.T) {
+	boltdb.Register()
+	dir, err := ioutil.TempDir("", "portallocator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ds := newTestStore(t, dir)
+	p := newInstance()
+	if err := p.SetStore(ds); err != nil {
+		t.Fatal(err)
+	}
+	dynamic, err := p.RequestPort(defaultIP, "tcp", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(defaultIP, "udp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(defaultIP, "tcp", 5001); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ReleasePort(defaultIP, "tcp", 5001); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPortInRange(defaultIP, "tcp", 6000, 6010); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPortBlock(defaultIP, "udp", 3, 7000, 7000+2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	ds.Close()
+
+	// a restart reloads the allocated ports
+	ds = newTestStore(t, dir)
+	defer ds.Close()
+	p = newInstance()
+	if err := p.SetStore(ds); err != nil {
+		t.Fatal(err)
+	}
+	port, err := p.RequestPort(defaultIP, "tcp", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port == dynamic {
+		t.Fatalf("Expected the restored port %d to be skipped", dynamic)
+	}
+	if _, err := p.RequestPort(defaultIP, "tcp", 5001); err != nil {
+		t.Fatal(err)
+	}
+
+	// the owner of a restored port requests it back, once
+	if _, err := p.RequestPort(defaultIP, "tcp", dynamic); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(defaultIP, "tcp", dynamic); err == nil {
+		t.Fatal("Expected the claimed port to be allocated")
+	}
+
+	// the restored ports of a range and of a block are claimed by the
+	// requests of the same range
+	if port, err := p.RequestPortInRange(defaultIP, "tcp", 6000, 6010); err != nil || port != 6000 {
+		t.Fatalf("Expected the restored port 6000 of the range, got %d (%v)", port, err)
+	}
+	if port, err := p.RequestPortInRange(defaultIP, "tcp", 6000, 6010); err != nil || port == 6000 {
+		t.Fatalf("Expected a port of the range other than the claimed one, got %d (%v)", port, err)
+	}
+	if port, err := p.RequestPortBlock(defaultIP, "udp", 3, 7000, 7000+2); err != nil || port != 7000 {
+		t.Fatalf("Expected the restored block at 7000, got %d (%v)", port, err)
+	}
+
+	stale, err := p.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []AllocatedPort{{IP: "0.0.0.0", Proto: "udp", Port: 5000}}; !reflect.DeepEqual(stale, expected) {
+		t.Fatalf("Expected the stale ports %v, got %v", expected, stale)
+	}
+	if _, err := p.RequestPort(defaultIP, "udp", 5000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.RequestPort(defaultIP, "udp", 5000); err == nil {
+		t.Fatal("Expected the reallocated port to be allocated")
+	}
+}
+
+func TestPersistBatched(t *testing.T) {
+	boltdb.Register()
+	dir, err := ioutil.TempDir("", "portallocator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ds := newTestStore(t, dir)
+	defer ds.Close()
+	p := newInstance()
+	if err := p.SetStore(ds); err != nil {
+		t.Fatal(err)
+	}
+	for port := 5000; port < 5010; port++ {
+		if _, err := p.RequestPort(defaultIP, "tcp", port); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the allocations are written once, after the delay
+	read := func() []int {
+		s := &allocState{store: ds}
+		if err := ds.GetObject(datastore.Key(s.Key()...), s); err != nil {
+			t.Fatal(err)
+		}
+		return s.Ports["0.0.0.0"]["tcp"]
+	}
+	if ports := read(); len(ports) != 0 {
+		t.Fatalf("Expected the allocations not to be written yet, got %v", ports)
+	}
+	deadline := time.Now().Add(10 * persistDelay)
+	for len(read()) != 10 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the 10 allocations to be written, got %v", read())
+		}
+		time.Sleep(persistDelay / 10)
+	}
+}
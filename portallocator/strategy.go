@@ -0,0 +1,141 @@
+package portallocator
+
+import (
+	"crypto/rand"
+	"math/big"
+	"sync"
+)
+
+// Strategy chooses the ports allocated dynamically. The ports of the IPs
+// and protocols are told apart by key, as in "0.0.0.0/tcp". The strategy is
+// called with the lock of the PortAllocator held.
+type Strategy interface {
+	// Pick returns a free port from begin to end, or 0 when none of them is
+	// free. last is the port allocated last from the range.
+	Pick(key string, begin, end, last int, free func(port int) bool) int
+	// Released records the release of the port
+	Released(key string, port int)
+}
+
+// WithStrategy makes the PortAllocator allocate the ports with the
+// strategy, a nil strategy being the sequential one
+func WithStrategy(s Strategy) Option {
+	return func(p *PortAllocator) error {
+		p.SetStrategy(s)
+		return nil
+	}
+}
+
+// SetStrategy makes the PortAllocator allocate the ports with the strategy
+// from now on, a nil strategy being the sequential one
+func (p *PortAllocator) SetStrategy(s Strategy) {
+	if s == nil {
+		s = NewSequential()
+	}
+	p.mutex.Lock()
+	p.strategy = s
+	p.mutex.Unlock()
+}
+
+type sequential struct{}
+
+// NewSequential returns the default strategy, allocating the ports after the
+// last allocated one in turn
+func NewSequential() Strategy {
+	return sequential{}
+}
+
+func (sequential) Pick(key string, begin, end, last int, free func(port int) bool) int {
+	return scan(begin, end, last+1, free)
+}
+
+func (sequential) Released(key string, port int) {}
+
+type random struct{}
+
+// NewRandom returns the strategy allocating the free port found from a
+// cryptographically random port, so that the allocated ports cannot be
+// predicted
+func NewRandom() Strategy {
+	return random{}
+}
+
+func (random) Pick(key string, begin, end, last int, free func(port int) bool) int {
+	start := last + 1
+	if n, err := rand.Int(rand.Reader, big.NewInt(int64(end-begin+1))); err == nil {
+		start = begin + int(n.Int64())
+	}
+	return scan(begin, end, start, free)
+}
+
+func (random) Released(key string, port int) {}
+
+type lru struct {
+	sync.Mutex
+	seq uint64
+	// released are the release sequence numbers of the free ports
+	released map[string]map[int]uint64
+}
+
+// NewLRU returns the strategy allocating the ports never allocated first,
+// then the port released the longest ago, delaying the reuse of the ports
+func NewLRU() Strategy {
+	return &lru{released: map[string]map[int]uint64{}}
+}
+
+func (s *lru) Pick(key string, begin, end, last int, free func(port int) bool) int {
+	s.Lock()
+	defer s.Unlock()
+
+	released := s.released[key]
+	port, oldest := 0, uint64(0)
+	for i, p := 0, last; i <= end-begin; i++ {
+		if p++; p > end || p < begin {
+			p = begin
+		}
+		if !free(p) {
+			continue
+		}
+		seq, ok := released[p]
+		if !ok {
+			port = p
+			break
+		}
+		if port == 0 || seq < oldest {
+			port, oldest = p, seq
+		}
+	}
+	if port != 0 {
+		delete(released, port)
+	}
+	return port
+}
+
+func (s *lru) Released(key string, port int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.seq++
+	if s.released[key] == nil {
+		s.released[key] = map[int]uint64{}
+	}
+	s.released[key][port] = s.seq
+}
+
+// scan returns the first free port from start, wrapping around from end to
+// begin, or 0
+func scan(begin, end, start int, free func(port int) bool) int {
+	if start > end || start < begin {
+		start = begin
+	}
+	port := start
+	for i := 0; i <= end-begin; i++ {
+		if free(port) {
+			return port
+		}
+		if port++; port > end {
+			port = begin
+		}
+	}
+	return 0
+}
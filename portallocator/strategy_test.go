@@ -0,0 +1,67 @@
+package portallocator
+
+import (
+	"testing"
+)
+
+func TestRandomStrategy(t *testing.T) {
+	p, err := New(WithStrategy(NewRandom()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocated := map[int]bool{}
+	for i := 0; i < 10; i++ {
+		port, err := p.RequestPortInRange(defaultIP, "tcp", 5000, 5009)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port < 5000 || port > 5009 || allocated[port] {
+			t.Fatalf("Unexpected port %d, allocated %v", port, allocated)
+		}
+		allocated[port] = true
+	}
+	if _, err := p.RequestPortInRange(defaultIP, "tcp", 5000, 5009); err != ErrAllPortsAllocated {
+		t.Fatalf("Expected the range to be exhausted, got %v", err)
+	}
+}
+
+func TestLRUStrategy(t *testing.T) {
+	p, err := New(WithStrategy(NewLRU()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(expected int) {
+		t.Helper()
+		port, err := p.RequestPortInRange(defaultIP, "udp", 5000, 5003)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if port != expected {
+			t.Fatalf("Expected the port %d got %d", expected, port)
+		}
+	}
+	for _, port := range []int{5000, 5001, 5002} {
+		request(port)
+	}
+	for _, port := range []int{5001, 5000} {
+		if err := p.ReleasePort(defaultIP, "udp", port); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the port never allocated, then the ports in the order of their release
+	request(5003)
+	request(5001)
+	request(5000)
+
+	// the ports of the other protocols are apart
+	if err := p.ReleasePort(defaultIP, "udp", 5002); err != nil {
+		t.Fatal(err)
+	}
+	port, err := p.RequestPortInRange(defaultIP, "tcp", 5000, 5003)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 5000 {
+		t.Fatalf("Expected the port 5000 got %d", port)
+	}
+}
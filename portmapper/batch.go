@@ -0,0 +1,433 @@
+package portmapper
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/docker/libnetwork/ip6tables"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/sirupsen/logrus"
+)
+
+// MapRequest is a port mapping to create with MapMany
+type MapRequest struct {
+	Container   net.Addr
+	ContainerV6 net.Addr
+	HostIP      net.IP
+	// Ports is the number of consecutive ports mapped, one when zero
+	Ports         int
+	HostPortStart int
+	HostPortEnd   int
+	UseProxy      bool
+	Options       []MapOption
+}
+
+// MapMany maps the container transport addresses of the requests as
+// MapRange does, programming the rules of all the mappings in one
+// iptables-restore and one ip6tables-restore transaction. Either all the
+// mappings are created or none is. It returns the host addresses of the
+// mappings in the order of the requests.
+func (pm *PortMapper) MapMany(reqs []MapRequest) ([]net.Addr, error) {
+	defer pm.dispatchEvents()
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	batch, err := pm.mapMany(reqs, false)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]net.Addr, 0, len(batch))
+	for _, m := range batch {
+		hosts = append(hosts, m.host)
+		pm.queueEvent(event{kind: eventMapped, mapping: m.record()})
+	}
+	return hosts, nil
+}
+
+// mapMany creates the mappings of the requests, all or none. With
+// samePort, the mappings after the first one get the host port of the
+// first one.
+func (pm *PortMapper) mapMany(reqs []MapRequest, samePort bool) (_ []*mapping, err error) {
+	var batch []*mapping
+	defer func() {
+		if err != nil {
+			for _, r := range reqs {
+				mapFailuresCounter.Inc(protoOf(r.Container))
+			}
+			for _, m := range batch {
+				pm.releasePorts(m)
+			}
+		}
+	}()
+
+	for i, r := range reqs {
+		if samePort && i > 0 {
+			_, hostPort := getIPAndPort(batch[0].host)
+			r.HostPortStart, r.HostPortEnd = hostPort, hostPort
+		}
+		ports := r.Ports
+		if ports == 0 {
+			ports = 1
+		}
+		m, err := pm.newMapping(r.Container, r.ContainerV6, r.HostIP, ports, r.HostPortStart, r.HostPortEnd, r.UseProxy, r.Options)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, m)
+		if _, exists := pm.currentMappings[getKey(m.host)]; exists {
+			return nil, ErrPortMappedForIP
+		}
+	}
+
+	rules, ip6tRules := pm.batchRules(batch, pm.mappingRules)
+	hpRules, hpIP6tRules := pm.batchRules(batch, pm.insertedRules)
+	// the hairpin and rate limit rules go ahead of the rules of the chains,
+	// the iptables rules are programmed in a single transaction
+	if err := iptables.Begin().Add(iptables.Append, rules...).Add(iptables.Insert, hpRules...).Commit(); err != nil {
+		return nil, err
+	}
+	rules = append(rules, hpRules...)
+	if err := ip6tables.ProgramRules(ip6tables.Append, ip6tRules); err != nil {
+		pm.deleteRules(rules, nil)
+		return nil, err
+	}
+	if err := ip6tables.ProgramRules(ip6tables.Insert, hpIP6tRules); err != nil {
+		pm.deleteRules(rules, ip6tRules)
+		return nil, err
+	}
+	ip6tRules = append(ip6tRules, hpIP6tRules...)
+
+	var started, exposed []*mapping
+	cleanup := func() {
+		for _, m := range exposed {
+			if err := pm.unexposePorts(m); err != nil {
+				logrus.Errorf("Error on host port removal: %s", err)
+			}
+		}
+		for _, m := range started {
+			m.userlandProxy.Stop()
+		}
+		pm.deleteRules(rules, ip6tRules)
+	}
+	for _, m := range batch {
+		if err := m.userlandProxy.Start(); err != nil {
+			cleanup()
+			return nil, err
+		}
+		started = append(started, m)
+		if pm.portDriver != nil {
+			if err := pm.exposePorts(m); err != nil {
+				cleanup()
+				return nil, err
+			}
+			exposed = append(exposed, m)
+		}
+	}
+
+	for _, m := range batch {
+		pm.currentMappings[getKey(m.host)] = m
+		mappingsGauge.Inc(m.proto)
+	}
+	return batch, nil
+}
+
+// UnmapMany removes the mappings of the host transport addresses as Unmap
+// does, deleting the rules of all the mappings in one iptables-restore and
+// one ip6tables-restore transaction. No mapping is removed if one of the
+// addresses is not mapped. The mappings of a group created by
+// MapMultiHost are all removed with any of them.
+func (pm *PortMapper) UnmapMany(hosts []net.Addr) error {
+	defer pm.dispatchEvents()
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	return pm.unmapMany(hosts)
+}
+
+// UnmapErrors are the errors releasing the host ports of the mappings
+// removed by UnmapAllFor or UnmapAll
+type UnmapErrors []error
+
+func (e UnmapErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// UnmapAllFor removes the mappings forwarding to the container transport
+// address, in one batch as UnmapMany does. A port 0 matches all the ports
+// of the container IP address, IPv4 or IPv6. Unlike UnmapMany, the
+// mappings are all removed whatever the errors, returned in UnmapErrors.
+func (pm *PortMapper) UnmapAllFor(container net.Addr) error {
+	defer pm.dispatchEvents()
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	var batch []*mapping
+	for _, m := range pm.currentMappings {
+		if m.forwardsTo(container) {
+			batch = append(batch, m)
+		}
+	}
+	return pm.removeAll(batch)
+}
+
+// UnmapAll removes all the mappings as UnmapAllFor does
+func (pm *PortMapper) UnmapAll() error {
+	defer pm.dispatchEvents()
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	batch := make([]*mapping, 0, len(pm.currentMappings))
+	for _, m := range pm.currentMappings {
+		batch = append(batch, m)
+	}
+	return pm.removeAll(batch)
+}
+
+// removeAll removes the batch of mappings with the other members of their
+// groups, sorted by host address for the events to come in order
+func (pm *PortMapper) removeAll(batch []*mapping) error {
+	seen := map[string]bool{}
+	var all []*mapping
+	for _, m := range batch {
+		members := []net.Addr{m.host}
+		if len(m.group) > 0 {
+			members = m.group
+		}
+		for _, member := range members {
+			key := getKey(member)
+			if gm, ok := pm.currentMappings[key]; ok && !seen[key] {
+				seen[key] = true
+				all = append(all, gm)
+			}
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return getKey(all[i].host) < getKey(all[j].host) })
+	if errs := pm.removeMappings(all); len(errs) > 0 {
+		return UnmapErrors(errs)
+	}
+	return nil
+}
+
+// forwardsTo returns whether the mapping forwards to the container
+// address, or to any of the ports of its IP address when its port is 0
+func (m *mapping) forwardsTo(container net.Addr) bool {
+	if protoOf(container) != m.proto {
+		return false
+	}
+	ip, port := getIPAndPort(container)
+	for _, c := range []net.Addr{m.container, m.containerv6} {
+		cip, cport := getIPAndPort(c)
+		if cip != nil && cip.Equal(ip) && (port == 0 || port >= cport && port < cport+m.ports) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pm *PortMapper) unmapMany(hosts []net.Addr) error {
+	var batch []*mapping
+	seen := map[string]bool{}
+	for _, host := range hosts {
+		m, exists := pm.currentMappings[getKey(host)]
+		if !exists {
+			return ErrPortNotMapped
+		}
+		members := []net.Addr{host}
+		if len(m.group) > 0 {
+			members = m.group
+		}
+		for _, member := range members {
+			key := getKey(member)
+			if !seen[key] {
+				seen[key] = true
+				batch = append(batch, pm.currentMappings[key])
+			}
+		}
+	}
+	if errs := pm.removeMappings(batch); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// removeMappings removes the batch of mappings, deleting their rules in a
+// transaction, and returns the errors releasing their host ports
+func (pm *PortMapper) removeMappings(batch []*mapping) []error {
+	for _, m := range batch {
+		if m.userlandProxy != nil {
+			m.userlandProxy.Stop()
+		}
+		delete(pm.currentMappings, getKey(m.host))
+		mappingsGauge.Dec(m.proto)
+		pm.queueEvent(event{kind: eventUnmapped, mapping: m.record()})
+
+		if pm.portDriver != nil {
+			if err := pm.unexposePorts(m); err != nil {
+				logrus.Errorf("Error on host port removal: %s", err)
+				unmapFailuresCounter.Inc(m.proto)
+			}
+		}
+	}
+
+	// the rules still used by the other mappings are kept
+	rules, ip6tRules := pm.batchRules(batch, pm.mappingRules)
+	hpRules, hpIP6tRules := pm.batchRules(batch, pm.insertedRules)
+	rules, ip6tRules = append(rules, hpRules...), append(ip6tRules, hpIP6tRules...)
+	if err := iptables.ProgramRules(iptables.Delete, rules); err != nil {
+		logrus.Warnf("Failed to delete the iptables rules of the mappings in a batch, deleting them one at a time: %v", err)
+		pm.deleteRules(rules, nil)
+	}
+	if err := ip6tables.ProgramRules(ip6tables.Delete, ip6tRules); err != nil {
+		logrus.Warnf("Failed to delete the ip6tables rules of the mappings in a batch, deleting them one at a time: %v", err)
+		pm.deleteRules(nil, ip6tRules)
+	}
+
+	var errs []error
+	for _, m := range batch {
+		pm.flushConntrack(m)
+		if err := pm.releasePorts(m); err != nil {
+			unmapFailuresCounter.Inc(m.proto)
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// mappingRules returns the iptables and ip6tables rules of the mapping,
+// for each of its host IP addresses
+func (pm *PortMapper) mappingRules(m *mapping) ([]iptables.Rule, []ip6tables.Rule) {
+	return pm.pairRules(m, func(hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) []iptables.Rule {
+		return pm.mappingChain(m).ForwardRangeRules(hostIP, hostPort, hostPort+m.ports-1, m.proto, containerIP.String(), containerPort, pm.bridgeName)
+	}, func(hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) []ip6tables.Rule {
+		return pm.mappingIP6tChain(m).ForwardRangeRules(hostIP, hostPort, hostPort+m.ports-1, m.proto, containerIP.String(), containerPort, pm.bridgeName)
+	})
+}
+
+// hairpinRules returns the iptables and ip6tables hairpin rules of the
+// mapping, when its hairpin NAT is enabled
+func (pm *PortMapper) hairpinRules(m *mapping) ([]iptables.Rule, []ip6tables.Rule) {
+	if m.hairpin == nil || !*m.hairpin {
+		return nil, nil
+	}
+	return pm.pairRules(m, func(hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) []iptables.Rule {
+		return pm.chain.HairpinRules(hostIP, hostPort, hostPort+m.ports-1, m.proto, containerIP.String(), containerPort, pm.bridgeName)
+	}, func(hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) []ip6tables.Rule {
+		return pm.ip6tChain.HairpinRules(hostIP, hostPort, hostPort+m.ports-1, m.proto, containerIP.String(), containerPort, pm.bridgeName)
+	})
+}
+
+// limitRules returns the iptables and ip6tables rules capping the new
+// connections to the mapping, when it has a rate limit
+func (pm *PortMapper) limitRules(m *mapping) ([]iptables.Rule, []ip6tables.Rule) {
+	if m.rateLimit == nil {
+		return nil, nil
+	}
+	return pm.pairRules(m, func(_ net.IP, _ int, containerIP net.IP, containerPort int) []iptables.Rule {
+		return pm.chain.RateLimitRules(m.proto, containerIP.String(), containerPort, containerPort+m.ports-1, m.rateLimit.Rate, m.rateLimit.Burst, pm.bridgeName)
+	}, func(_ net.IP, _ int, containerIP net.IP, containerPort int) []ip6tables.Rule {
+		return pm.ip6tChain.RateLimitRules(m.proto, containerIP.String(), containerPort, containerPort+m.ports-1, m.rateLimit.Rate, m.rateLimit.Burst, pm.bridgeName)
+	})
+}
+
+// pairRules returns the iptables and ip6tables rules of each host IP
+// address of the mapping and of the container address it is forwarded to,
+// each once, a multihomed SCTP mapping having several
+func (pm *PortMapper) pairRules(m *mapping,
+	pairRules func(hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) []iptables.Rule,
+	pairIP6tRules func(hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) []ip6tables.Rule) ([]iptables.Rule, []ip6tables.Rule) {
+	var (
+		rules     []iptables.Rule
+		ip6tRules []ip6tables.Rule
+		seen      = map[string]bool{}
+	)
+	_, hostPort := getIPAndPort(m.host)
+	if _, containerPort := getIPAndPort(m.container); pm.chain != nil && m.forwardsIPv4() {
+		hostIPs, containerIPs := m.forwardPairs(m.container)
+		for i, hostIP := range hostIPs {
+			for _, r := range pairRules(pm.childIP(hostIP), hostPort, containerIPs[i], containerPort) {
+				r.Args = iptables.OwnedArgs(pm.owner, r.Args)
+				if k := r.String(); !seen[k] {
+					seen[k] = true
+					rules = append(rules, r)
+				}
+			}
+		}
+	}
+	if _, containerPort := getIPAndPort(m.containerv6); pm.ip6tChain != nil && m.forwardsIPv6() {
+		hostIPs, containerIPs := m.forwardPairs(m.containerv6)
+		for i, hostIP := range hostIPs {
+			for _, r := range pairIP6tRules(pm.childIP(hostIP), hostPort, containerIPs[i], containerPort) {
+				r.Args = ip6tables.OwnedArgs(pm.owner, r.Args)
+				if k := r.String(); !seen[k] {
+					seen[k] = true
+					ip6tRules = append(ip6tRules, r)
+				}
+			}
+		}
+	}
+	return rules, ip6tRules
+}
+
+// insertedRules returns the rules of the mapping inserted ahead of the
+// rules of the chains, its hairpin and rate limit rules
+func (pm *PortMapper) insertedRules(m *mapping) ([]iptables.Rule, []ip6tables.Rule) {
+	rules, ip6tRules := pm.hairpinRules(m)
+	limitRules, limitIP6tRules := pm.limitRules(m)
+	return append(rules, limitRules...), append(ip6tRules, limitIP6tRules...)
+}
+
+// batchRules returns the rules of the batch of mappings, each once, less
+// the rules of the current mappings, which the mappings sharing a
+// container port have in common
+func (pm *PortMapper) batchRules(batch []*mapping, mappingRules func(*mapping) ([]iptables.Rule, []ip6tables.Rule)) ([]iptables.Rule, []ip6tables.Rule) {
+	programmed := map[string]bool{}
+	for _, m := range pm.currentMappings {
+		rules, ip6tRules := mappingRules(m)
+		for _, r := range rules {
+			programmed["4 "+r.String()] = true
+		}
+		for _, r := range ip6tRules {
+			programmed["6 "+r.String()] = true
+		}
+	}
+
+	var (
+		rules     []iptables.Rule
+		ip6tRules []ip6tables.Rule
+	)
+	for _, m := range batch {
+		mrules, mip6tRules := mappingRules(m)
+		for _, r := range mrules {
+			if k := "4 " + r.String(); !programmed[k] {
+				programmed[k] = true
+				rules = append(rules, r)
+			}
+		}
+		for _, r := range mip6tRules {
+			if k := "6 " + r.String(); !programmed[k] {
+				programmed[k] = true
+				ip6tRules = append(ip6tRules, r)
+			}
+		}
+	}
+	return rules, ip6tRules
+}
+
+// deleteRules deletes the rules one at a time, logging the failures
+func (pm *PortMapper) deleteRules(rules []iptables.Rule, ip6tRules []ip6tables.Rule) {
+	for _, r := range rules {
+		if err := iptables.ProgramRule(r.Table, r.Chain, iptables.Delete, r.Args); err != nil {
+			logrus.Errorf("Error on iptables delete: %s", err)
+		}
+	}
+	for _, r := range ip6tRules {
+		if err := ip6tables.ProgramRule(r.Table, r.Chain, ip6tables.Delete, r.Args); err != nil {
+			logrus.Errorf("Error on ip6tables delete: %s", err)
+		}
+	}
+}
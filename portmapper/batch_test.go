@@ -0,0 +1,225 @@
+package portmapper
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+)
+
+func TestMapMany(t *testing.T) {
+	pm := New("")
+	d := &mockPortDriver{exposed: make(map[string]bool)}
+	pm.SetPortDriver(d)
+
+	hostIP := net.ParseIP("192.168.0.1")
+	reqs := []MapRequest{
+		{Container: &net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 80}, HostIP: hostIP, HostPortStart: 8080, HostPortEnd: 8080, UseProxy: true},
+		{Container: &net.UDPAddr{IP: net.ParseIP("172.16.0.2"), Port: 53}, HostIP: hostIP, HostPortStart: 5353, HostPortEnd: 5353, UseProxy: true},
+	}
+	hosts, err := pm.MapMany(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 || hosts[0].String() != "192.168.0.1:8080" || hosts[1].String() != "192.168.0.1:5353" {
+		t.Fatalf("unexpected host addresses %v", hosts)
+	}
+	if len(pm.ListMappings()) != 2 || len(d.exposed) != 2 {
+		t.Fatalf("expected 2 mappings exposed, got %v", d.exposed)
+	}
+
+	// a failing request leaves no mapping of the batch
+	failing := []MapRequest{
+		{Container: &net.TCPAddr{IP: net.ParseIP("172.16.0.3"), Port: 80}, HostIP: hostIP, HostPortStart: 9090, HostPortEnd: 9090},
+		{Container: &net.TCPAddr{IP: net.ParseIP("172.16.0.3"), Port: 81}, HostIP: hostIP, HostPortStart: 8080, HostPortEnd: 8080},
+	}
+	if _, err := pm.MapMany(failing); err == nil {
+		t.Fatal("expected the batch to fail on the mapped port")
+	}
+	if len(pm.ListMappings()) != 2 {
+		t.Fatalf("expected the failed batch to be rolled back, got %v", pm.ListMappings())
+	}
+	if _, err := pm.Map(failing[0].Container, nil, hostIP, 9090, true); err != nil {
+		t.Fatalf("expected the port of the failed batch to be released: %v", err)
+	}
+
+	if err := pm.UnmapMany([]net.Addr{hosts[0], &net.TCPAddr{IP: hostIP, Port: 7070}}); err != ErrPortNotMapped {
+		t.Fatalf("expected %v, got %v", ErrPortNotMapped, err)
+	}
+	if len(pm.ListMappings()) != 3 {
+		t.Fatal("expected no mapping to be removed")
+	}
+	if err := pm.UnmapMany(hosts); err != nil {
+		t.Fatal(err)
+	}
+	if len(pm.ListMappings()) != 1 || len(d.exposed) != 1 {
+		t.Fatalf("expected the batch to be unmapped, got %v", pm.ListMappings())
+	}
+	if hosts, err = pm.MapMany(reqs); err != nil {
+		t.Fatalf("expected the ports to be released: %v", err)
+	}
+	if err := pm.UnmapMany(append(hosts, &net.TCPAddr{IP: hostIP, Port: 9090})); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchRules(t *testing.T) {
+	pm := New("")
+	hostIP := net.ParseIP("192.168.0.1")
+	container := &net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 80}
+	host, err := pm.Map(container, nil, hostIP, 8080, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.SetIptablesChain(&iptables.ChainInfo{Name: "DOCKER", Table: iptables.Nat}, "docker0")
+
+	m, err := pm.newMapping(container, nil, hostIP, 1, 8081, 8081, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		pm.Allocator.ReleasePort(hostIP, "tcp", 8081)
+		pm.SetIptablesChain(nil, "")
+		pm.Unmap(host)
+	}()
+
+	// the filter and masquerading rules are shared with the mapping of 8080
+	rules, ip6tRules := pm.batchRules([]*mapping{m, m}, pm.mappingRules)
+	if len(rules) != 1 || rules[0].Table != iptables.Nat || rules[0].Chain != "DOCKER" || len(ip6tRules) != 0 {
+		t.Fatalf("expected the DNAT rule only, got %v", rules)
+	}
+}
+
+func TestHairpinRules(t *testing.T) {
+	pm := New("")
+	hostIP := net.ParseIP("192.168.0.1")
+	container := &net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 80}
+	pm.SetIptablesChain(&iptables.ChainInfo{Name: "DOCKER", Table: iptables.Nat, HairpinMode: true}, "docker0")
+	defer pm.SetIptablesChain(nil, "")
+
+	for _, tc := range []struct {
+		opts      []MapOption
+		skipsDNAT bool
+		hairpin   int
+	}{
+		{nil, false, 0},
+		{[]MapOption{WithHairpinNAT(false)}, true, 0},
+		{[]MapOption{WithHairpinNAT(true)}, true, 4},
+	} {
+		m, err := pm.newMapping(container, nil, hostIP, 1, 8081, 8081, false, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pm.Allocator.ReleasePort(hostIP, "tcp", 8081)
+
+		rules, _ := pm.mappingRules(m)
+		dnat := strings.Join(rules[0].Args, " ")
+		if skipsDNAT := strings.Contains(dnat, "! -i docker0"); skipsDNAT != tc.skipsDNAT {
+			t.Fatalf("expected the DNAT rule to skip the bridge traffic %v, got %s", tc.skipsDNAT, dnat)
+		}
+		if hpRules, _ := pm.hairpinRules(m); len(hpRules) != tc.hairpin {
+			t.Fatalf("expected %d hairpin rules, got %v", tc.hairpin, hpRules)
+		}
+	}
+}
+
+func TestRateLimitRules(t *testing.T) {
+	pm := New("")
+	hostIP := net.ParseIP("192.168.0.1")
+	container := &net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 80}
+	pm.SetIptablesChain(&iptables.ChainInfo{Name: "DOCKER", Table: iptables.Nat}, "docker0")
+	defer pm.SetIptablesChain(nil, "")
+
+	if _, err := pm.newMapping(container, nil, hostIP, 1, 8082, 8082, false, []MapOption{WithRateLimit(0, 0)}); err == nil {
+		t.Fatal("expected the rate limit of no connection to be rejected")
+	}
+
+	m, err := pm.newMapping(container, nil, hostIP, 1, 8082, 8082, false, []MapOption{WithRateLimit(100, 20), WithHairpinNAT(true)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.Allocator.ReleasePort(hostIP, "tcp", 8082)
+	if r := m.record().RateLimit; r == nil || r.Rate != 100 || r.Burst != 20 {
+		t.Fatalf("unexpected rate limit %v", r)
+	}
+	rules, _ := pm.insertedRules(m)
+	if len(rules) != 5 {
+		t.Fatalf("expected the hairpin and rate limit rules, got %v", rules)
+	}
+	if limit := strings.Join(rules[4].Args, " "); !strings.Contains(limit, "-d 172.16.0.2 --dport 80 ") || !strings.Contains(limit, "--hashlimit-above 100/sec") {
+		t.Fatalf("unexpected rate limit rule %s", limit)
+	}
+}
+
+func TestOwnerRules(t *testing.T) {
+	defer iptables.SetOwnerTagging(iptables.OwnerTagging())
+	iptables.SetOwnerTagging(true)
+
+	pm := New("")
+	pm.SetOwner("network:n1")
+	hostIP := net.ParseIP("192.168.0.1")
+	container := &net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 80}
+	pm.SetIptablesChain(&iptables.ChainInfo{Name: "DOCKER", Table: iptables.Nat}, "docker0")
+	defer pm.SetIptablesChain(nil, "")
+
+	m, err := pm.newMapping(container, nil, hostIP, 1, 8083, 8083, false, []MapOption{WithRateLimit(100, 20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.Allocator.ReleasePort(hostIP, "tcp", 8083)
+
+	rules, _ := pm.mappingRules(m)
+	limitRules, _ := pm.limitRules(m)
+	for _, r := range append(rules, limitRules...) {
+		args := strings.Join(r.Args, " ")
+		if !strings.Contains(args, "-m comment --comment libnetwork:network:n1 -j ") {
+			t.Fatalf("expected the rule to be tagged with its owner ahead of its target, got %s", args)
+		}
+	}
+}
+
+func TestUnmapAllFor(t *testing.T) {
+	pm := New("")
+	hostIP := net.ParseIP("127.0.0.1")
+	container := net.ParseIP("172.16.0.2")
+	for _, c := range []net.Addr{
+		&net.TCPAddr{IP: container, Port: 80},
+		&net.TCPAddr{IP: container, Port: 443},
+		&net.UDPAddr{IP: container, Port: 53},
+		&net.TCPAddr{IP: net.ParseIP("172.16.0.3"), Port: 80},
+	} {
+		if _, err := pm.Map(c, nil, hostIP, 0, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := pm.MapMultiHost(&net.TCPAddr{IP: container, Port: 22}, nil, []net.IP{hostIP, net.ParseIP("127.0.0.2")}, 0, 0, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pm.UnmapAllFor(&net.TCPAddr{IP: container, Port: 443}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(pm.ListMappings()); n != 5 {
+		t.Fatalf("expected the mapping of the container port to be removed, got %d mappings", n)
+	}
+	if err := pm.UnmapAllFor(&net.TCPAddr{IP: container}); err != nil {
+		t.Fatal(err)
+	}
+	mappings := pm.ListMappings()
+	if len(mappings) != 2 {
+		t.Fatalf("expected the tcp mappings of the container to be removed, got %v", mappings)
+	}
+	for _, m := range mappings {
+		if m.Proto == "tcp" && !m.Container.(*net.TCPAddr).IP.Equal(net.ParseIP("172.16.0.3")) {
+			t.Fatalf("unexpected mapping left %v", m)
+		}
+	}
+
+	if err := pm.UnmapAll(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(pm.ListMappings()); n != 0 {
+		t.Fatalf("expected no mapping left, got %d", n)
+	}
+}
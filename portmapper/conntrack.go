@@ -0,0 +1,46 @@
+package portmapper
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/ip6tables"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/ns"
+	"github.com/sirupsen/logrus"
+)
+
+// deleteConntrackEntries deletes the conntrack entries of the flows
+// translated from the host ports to destIP, overridden in the tests
+var deleteConntrackEntries = func(proto string, hostIP net.IP, hostPort, hostPortEnd int, destIP net.IP) (uint, error) {
+	if destIP.To4() != nil {
+		return iptables.DeleteConntrackMappingEntries(ns.NlHandle(), proto, hostIP, hostPort, hostPortEnd, destIP)
+	}
+	return ip6tables.DeleteConntrackMappingEntries(ns.NlHandle(), proto, hostIP, hostPort, hostPortEnd, destIP)
+}
+
+// flushConntrack deletes the conntrack entries of the removed mapping,
+// when enabled, once its rules are deleted for no new entry to be created
+func (pm *PortMapper) flushConntrack(m *mapping) {
+	enabled := pm.conntrackFlush
+	if m.conntrackFlush != nil {
+		enabled = *m.conntrackFlush
+	}
+	if !enabled {
+		return
+	}
+
+	_, hostPort := getIPAndPort(m.host)
+	var hostIPs, destIPs []net.IP
+	if m.forwardsIPv4() {
+		hostIPs, destIPs = m.forwardPairs(m.container)
+	}
+	if m.forwardsIPv6() {
+		hostIPs6, destIPs6 := m.forwardPairs(m.containerv6)
+		hostIPs, destIPs = append(hostIPs, hostIPs6...), append(destIPs, destIPs6...)
+	}
+	for i, destIP := range destIPs {
+		if _, err := deleteConntrackEntries(m.proto, pm.childIP(hostIPs[i]), hostPort, hostPort+m.ports-1, destIP); err != nil {
+			logrus.Warnf("Failed to delete the conntrack entries of %s to %s: %v", getKey(m.host), destIP, err)
+		}
+	}
+}
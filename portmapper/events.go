@@ -0,0 +1,127 @@
+package portmapper
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+type eventKind int
+
+const (
+	eventMapped eventKind = iota
+	eventUnmapped
+	eventProxyExit
+)
+
+// event is a lifecycle event of a mapping
+type event struct {
+	kind    eventKind
+	mapping Mapping
+	err     error
+}
+
+type hooks struct {
+	onMapped    []func(Mapping)
+	onUnmapped  []func(Mapping)
+	onProxyExit []func(Mapping, error)
+	onReMapped  []func(ipv4, ipv6 bool)
+}
+
+// OnMapped registers a callback called with the record of each mapping
+// created. The callbacks of the lifecycle events are called in the order
+// of the events, once the port mapper is unlocked, and may call it.
+func (pm *PortMapper) OnMapped(callback func(Mapping)) {
+	pm.lock.Lock()
+	pm.hooks.onMapped = append(pm.hooks.onMapped, callback)
+	pm.lock.Unlock()
+}
+
+// OnUnmapped registers a callback called with the record of each mapping
+// removed
+func (pm *PortMapper) OnUnmapped(callback func(Mapping)) {
+	pm.lock.Lock()
+	pm.hooks.onUnmapped = append(pm.hooks.onUnmapped, callback)
+	pm.lock.Unlock()
+}
+
+// OnProxyExit registers a callback called with the record of the mapping
+// and the exit error when the userland proxy of a mapping exits without
+// being stopped. The mapping is kept, with the ProxyExited proxy state,
+// until it is unmapped.
+func (pm *PortMapper) OnProxyExit(callback func(Mapping, error)) {
+	pm.lock.Lock()
+	pm.hooks.onProxyExit = append(pm.hooks.onProxyExit, callback)
+	pm.lock.Unlock()
+}
+
+// OnReMapped registers a callback called once the rules of the mappings are
+// re-applied, by ReMapAll, ReMapIPv4 or ReMapIPv6, with the families
+// re-applied, for the rules programmed along the ones of the mappings to be
+// re-applied too. It is called with the port mapper unlocked.
+func (pm *PortMapper) OnReMapped(callback func(ipv4, ipv6 bool)) {
+	pm.lock.Lock()
+	pm.hooks.onReMapped = append(pm.hooks.onReMapped, callback)
+	pm.lock.Unlock()
+}
+
+// queueEvent queues the event for dispatchEvents, with the port mapper
+// locked
+func (pm *PortMapper) queueEvent(e event) {
+	if len(pm.hooks.onMapped) == 0 && len(pm.hooks.onUnmapped) == 0 && len(pm.hooks.onProxyExit) == 0 {
+		return
+	}
+	pm.events = append(pm.events, e)
+}
+
+// dispatchEvents calls the callbacks of the queued events, with the port
+// mapper unlocked. The events queued meanwhile, by the callbacks or by
+// other goroutines, are dispatched by the goroutine already dispatching.
+func (pm *PortMapper) dispatchEvents() {
+	pm.lock.Lock()
+	if pm.dispatching {
+		pm.lock.Unlock()
+		return
+	}
+	pm.dispatching = true
+	for len(pm.events) > 0 {
+		events, h := pm.events, pm.hooks
+		pm.events = nil
+		pm.lock.Unlock()
+
+		for _, e := range events {
+			switch e.kind {
+			case eventMapped:
+				for _, cb := range h.onMapped {
+					cb(e.mapping)
+				}
+			case eventUnmapped:
+				for _, cb := range h.onUnmapped {
+					cb(e.mapping)
+				}
+			case eventProxyExit:
+				for _, cb := range h.onProxyExit {
+					cb(e.mapping, e.err)
+				}
+			}
+		}
+
+		pm.lock.Lock()
+	}
+	pm.dispatching = false
+	pm.lock.Unlock()
+}
+
+// proxyExited records the unexpected exit of the userland proxy of the
+// mapping
+func (pm *PortMapper) proxyExited(m *mapping, err error) {
+	defer pm.dispatchEvents()
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	if pm.currentMappings[getKey(m.host)] != m {
+		// the mapping failed or was removed
+		return
+	}
+	logrus.Warnf("Userland proxy of %s exited unexpectedly: %v", getKey(m.host), err)
+	m.proxyState = ProxyExited
+	pm.queueEvent(event{kind: eventProxyExit, mapping: m.record(), err: err})
+}
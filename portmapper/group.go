@@ -0,0 +1,48 @@
+package portmapper
+
+import (
+	"net"
+)
+
+// MapMultiHost maps the container transport address to the same host port
+// on each of the host IPs, allocated in the host port range, as a group of
+// mappings. The rules of the group are programmed in one transaction and
+// either all the mappings are created or none is. Unmapping any host
+// address of the group removes all of them. It returns the host addresses
+// in the order of the host IPs.
+func (pm *PortMapper) MapMultiHost(container net.Addr, containerv6 net.Addr, hostIPs []net.IP, hostPortStart, hostPortEnd int, useProxy bool, opts ...MapOption) ([]net.Addr, error) {
+	if len(hostIPs) == 0 {
+		return nil, ErrNoHostIP
+	}
+
+	defer pm.dispatchEvents()
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	reqs := make([]MapRequest, 0, len(hostIPs))
+	for _, hostIP := range hostIPs {
+		reqs = append(reqs, MapRequest{
+			Container:     container,
+			ContainerV6:   containerv6,
+			HostIP:        hostIP,
+			HostPortStart: hostPortStart,
+			HostPortEnd:   hostPortEnd,
+			UseProxy:      useProxy,
+			Options:       opts,
+		})
+	}
+	group, err := pm.mapMany(reqs, true)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]net.Addr, 0, len(group))
+	for _, m := range group {
+		hosts = append(hosts, m.host)
+	}
+	for _, m := range group {
+		m.group = hosts
+		pm.queueEvent(event{kind: eventMapped, mapping: m.record()})
+	}
+	return hosts, nil
+}
@@ -4,11 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"strconv"
 	"sync"
 
 	"github.com/docker/libnetwork/ip6tables"
 	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/metrics"
 	"github.com/docker/libnetwork/portallocator"
+	"github.com/docker/libnetwork/types"
 	"github.com/ishidawataru/sctp"
 	"github.com/sirupsen/logrus"
 )
@@ -19,10 +23,146 @@ type mapping struct {
 	host          net.Addr
 	container     net.Addr
 	containerv6   net.Addr
+	// ports is the number of consecutive ports mapped from the host and
+	// container ports
+	ports         int
+	proxyState    ProxyState
+	proxyProtocol int
+	// hairpin is the hairpin NAT of the mapping, the one of the chain when
+	// nil
+	hairpin *bool
+	// conntrackFlush is whether the conntrack entries of the mapping are
+	// deleted, the default of the port mapper when nil
+	conntrackFlush *bool
+	// rateLimit is the cap on the new connections to the mapping, none
+	// when nil
+	rateLimit *RateLimit
+	// group are the host addresses of the mappings created with this one
+	// by MapMultiHost, this one included
+	group []net.Addr
+}
+
+// ProxyState is the state of the userland proxy of a mapping
+type ProxyState string
+
+const (
+	// ProxyRunning is a mapping served by a running userland proxy
+	ProxyRunning ProxyState = "running"
+	// ProxyDisabled is a mapping programmed with the NAT rules only, the
+	// host port being held by a listener
+	ProxyDisabled ProxyState = "disabled"
+	// ProxyExited is a mapping whose userland proxy exited unexpectedly
+	ProxyExited ProxyState = "exited"
+)
+
+// Mapping is the record of a port mapping of the PortMapper
+type Mapping struct {
+	Proto       string
+	Host        net.Addr
+	Container   net.Addr
+	ContainerV6 net.Addr `json:",omitempty"`
+	// Ports is the number of consecutive ports mapped from the host and
+	// container ports
+	Ports int
+	Proxy ProxyState
+	// ProxyProtocol is the version of the PROXY protocol header the
+	// userland proxy sends to the container
+	ProxyProtocol int `json:",omitempty"`
+	// Group are the host addresses of the mappings created with this one
+	// by MapMultiHost
+	Group []net.Addr `json:",omitempty"`
+	// HairpinNAT is the hairpin NAT of the mapping when set with
+	// WithHairpinNAT
+	HairpinNAT *bool `json:",omitempty"`
+	// RateLimit is the cap on the new connections set with WithRateLimit
+	RateLimit *RateLimit `json:",omitempty"`
+}
+
+// RateLimit is the cap on the new connections to the container ports of a
+// mapping
+type RateLimit struct {
+	// Rate is the number of new connections per second
+	Rate int
+	// Burst is the number of connections accepted in a burst above the
+	// rate, the iptables default when 0
+	Burst int `json:",omitempty"`
+}
+
+// MapOption is an option of a port mapping
+type MapOption func(*mapOptions)
+
+type mapOptions struct {
+	proxyProtocol  int
+	hairpin        *bool
+	conntrackFlush *bool
+	rateLimit      *RateLimit
+	sctpHostIPs    []net.IP
+}
+
+// WithProxyProtocol makes the userland proxy of the mapping send the
+// client address to the container in a PROXY protocol header of the
+// version 1 or 2, for tcp mappings. The mapping is programmed without the
+// iptables rules translating the traffic to the container, so that all the
+// connections are relayed by the userland proxy and carry the header. It
+// requires the userland proxy, and excludes the rate limit and the hairpin
+// NAT, which are iptables rules.
+func WithProxyProtocol(version int) MapOption {
+	return func(o *mapOptions) {
+		o.proxyProtocol = version
+	}
+}
+
+// WithHairpinNAT enables or disables the hairpin NAT of the mapping,
+// whatever the hairpin mode of the bridge. With the hairpin NAT enabled,
+// the traffic from the containers of the bridge to the published address
+// is translated to the container and masqueraded. With it disabled, the
+// traffic from the bridge is not translated and reaches the userland
+// proxy, if any.
+func WithHairpinNAT(enable bool) MapOption {
+	return func(o *mapOptions) {
+		o.hairpin = &enable
+	}
+}
+
+// WithConntrackFlush sets whether the conntrack entries of the mapping are
+// deleted when it is unmapped, whatever the SetConntrackFlush default of
+// the port mapper
+func WithConntrackFlush(enable bool) MapOption {
+	return func(o *mapOptions) {
+		o.conntrackFlush = &enable
+	}
+}
+
+// WithRateLimit caps the new connections to the container ports of the
+// mapping at rate per second, with bursts of burst connections, with an
+// iptables hashlimit rule dropping the connections above the limit. The
+// mappings to the same container ports share the limit. The connections
+// relayed by the userland proxy from the host itself are not limited.
+func WithRateLimit(rate, burst int) MapOption {
+	return func(o *mapOptions) {
+		o.rateLimit = &RateLimit{Rate: rate, Burst: burst}
+	}
+}
+
+// WithSCTPHostIPs publishes the sctp mapping on the other host IP
+// addresses of a multihomed host, with the same ports as on the host IP of
+// the mapping. The host address of the mapping has all the IP addresses,
+// each forwarded to the container address of the same rank, or to the
+// first one, and the userland proxy binds all of them.
+func WithSCTPHostIPs(ips ...net.IP) MapOption {
+	return func(o *mapOptions) {
+		o.sctpHostIPs = ips
+	}
 }
 
 var newProxy = newProxyCommand
 
+var (
+	mappingsGauge        = metrics.NewGauge("portmapper", "mappings", "Host ports mapped to the containers", "proto")
+	mapFailuresCounter   = metrics.NewCounter("portmapper", "map_failures_total", "Host port mappings which failed", "proto")
+	unmapFailuresCounter = metrics.NewCounter("portmapper", "unmap_failures_total", "Host port unmappings which failed to remove a rule or release the port", "proto")
+)
+
 var (
 	// ErrUnknownBackendAddressType refers to an unknown container or unsupported address type
 	ErrUnknownBackendAddressType = errors.New("unknown container address type not supported")
@@ -32,6 +172,11 @@ var (
 	ErrPortNotMapped = errors.New("port is not mapped")
 	// ErrSCTPAddrNoIP refers to a SCTP address without IP address.
 	ErrSCTPAddrNoIP = errors.New("sctp address does not contain any IP address")
+	// ErrNoHostIP refers to a multi host mapping without host IP address
+	ErrNoHostIP = errors.New("no host IP address to map the port to")
+	// ErrNoContainerIPv6 refers to an IPv6 host address mapped without
+	// userland proxy to a container without IPv6 address
+	ErrNoContainerIPv6 = errors.New("IPv6 host address requires an IPv6 container address or the userland proxy")
 )
 
 // PortMapper manages the network address translation
@@ -45,10 +190,38 @@ type PortMapper struct {
 	lock            sync.Mutex
 
 	proxyPath string
+	// inProcessProxy runs the userland proxies in the process rather than
+	// as docker-proxy processes
+	inProcessProxy bool
+	// conntrackFlush deletes the conntrack entries of the mappings when
+	// they are unmapped, for the mappings not setting WithConntrackFlush
+	conntrackFlush bool
+
+	// portDriver, when set, exposes the mapped ports on the host from the
+	// network namespace of the daemon, in rootless mode
+	portDriver PortDriver
+
+	// hooks are the callbacks of the lifecycle events of the mappings,
+	// events the events to dispatch to them and dispatching whether they
+	// are being dispatched
+	hooks       hooks
+	events      []event
+	dispatching bool
+
+	// owner names the owner the rules of the mappings are tagged with,
+	// when the iptables rules are tagged
+	owner string
 
 	Allocator *portallocator.PortAllocator
 }
 
+// PortDriver exposes on the host the ports of a network namespace the
+// process cannot program the host from, as RootlessKit does
+type PortDriver interface {
+	ExposePort(proto string, hostIP net.IP, port int) error
+	UnexposePort(proto string, hostIP net.IP, port int) error
+}
+
 // New returns a new instance of PortMapper
 func New(proxyPath string) *PortMapper {
 	return NewWithPortAllocator(portallocator.Get(), proxyPath)
@@ -75,107 +248,91 @@ func (pm *PortMapper) SetIP6tablesChain(c *ip6tables.ChainInfo, bridgeName strin
 	pm.bridgeName = bridgeName
 }
 
-// Map maps the specified container transport address to the host's network address and transport port
-func (pm *PortMapper) Map(container net.Addr, containerv6 net.Addr, hostIP net.IP, hostPort int, useProxy bool) (host net.Addr, err error) {
-	return pm.MapRange(container, containerv6, hostIP, hostPort, hostPort, useProxy)
+// SetOwner sets the owner the iptables and ip6tables rules of the mappings
+// are tagged with, when the rules are tagged, for the rules left behind by
+// the owners gone to be cleaned. It is set before the first mapping.
+func (pm *PortMapper) SetOwner(owner string) {
+	pm.lock.Lock()
+	pm.owner = owner
+	pm.lock.Unlock()
 }
 
-// MapRange maps the specified container transport address to the host's network address and transport port range
-func (pm *PortMapper) MapRange(container net.Addr, containerv6 net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int, useProxy bool) (host net.Addr, err error) {
+// SetInProcessProxy sets whether the userland proxies of the mappings
+// created from now on relay the traffic from goroutines of the process,
+// rather than from a docker-proxy process per mapping
+func (pm *PortMapper) SetInProcessProxy(enabled bool) {
 	pm.lock.Lock()
-	defer pm.lock.Unlock()
+	pm.inProcessProxy = enabled
+	pm.lock.Unlock()
+}
 
-	var (
-		m                 *mapping
-		proto             string
-		allocatedHostPort int
-	)
+// SetConntrackFlush sets whether the conntrack entries of the mappings are
+// deleted when they are unmapped, for the established flows, as the UDP
+// ones, to stop reaching the removed container. The mappings set with
+// WithConntrackFlush keep their own setting.
+func (pm *PortMapper) SetConntrackFlush(enabled bool) {
+	pm.lock.Lock()
+	pm.conntrackFlush = enabled
+	pm.lock.Unlock()
+}
 
-	switch container.(type) {
-	case *net.TCPAddr:
-		proto = "tcp"
-		if allocatedHostPort, err = pm.Allocator.RequestPortInRange(hostIP, proto, hostPortStart, hostPortEnd); err != nil {
-			return nil, err
-		}
+// SetPortDriver sets the driver the mapped ports are exposed on the host
+// through. The mappings are then programmed in the namespace of the
+// process on the unspecified address.
+func (pm *PortMapper) SetPortDriver(d PortDriver) {
+	pm.portDriver = d
+}
 
-		m = &mapping{
-			proto:       proto,
-			host:        &net.TCPAddr{IP: hostIP, Port: allocatedHostPort},
-			container:   container,
-			containerv6: containerv6,
-		}
+// childIP returns the address the mappings of hostIP are programmed on in
+// the namespace of the process
+func (pm *PortMapper) childIP(hostIP net.IP) net.IP {
+	if pm.portDriver == nil {
+		return hostIP
+	}
+	if hostIP.To4() == nil {
+		return net.IPv6unspecified
+	}
+	return net.IPv4zero
+}
 
-		if useProxy {
-			m.userlandProxy, err = newProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port, pm.proxyPath)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort)
-			if err != nil {
-				return nil, err
-			}
-		}
-	case *net.UDPAddr:
-		proto = "udp"
-		if allocatedHostPort, err = pm.Allocator.RequestPortInRange(hostIP, proto, hostPortStart, hostPortEnd); err != nil {
-			return nil, err
-		}
+// Map maps the specified container transport address to the host's network address and transport port
+func (pm *PortMapper) Map(container net.Addr, containerv6 net.Addr, hostIP net.IP, hostPort int, useProxy bool, opts ...MapOption) (host net.Addr, err error) {
+	return pm.MapRange(container, containerv6, hostIP, hostPort, hostPort, useProxy, opts...)
+}
 
-		m = &mapping{
-			proto:       proto,
-			host:        &net.UDPAddr{IP: hostIP, Port: allocatedHostPort},
-			container:   container,
-			containerv6: containerv6,
-		}
+// MapRange maps the specified container transport address to the host's network address and transport port range
+func (pm *PortMapper) MapRange(container net.Addr, containerv6 net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int, useProxy bool, opts ...MapOption) (host net.Addr, err error) {
+	return pm.mapPorts(container, containerv6, hostIP, 1, hostPortStart, hostPortEnd, useProxy, opts)
+}
 
-		if useProxy {
-			m.userlandProxy, err = newProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port, pm.proxyPath)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort)
-			if err != nil {
-				return nil, err
-			}
-		}
-	case *sctp.SCTPAddr:
-		proto = "sctp"
-		if allocatedHostPort, err = pm.Allocator.RequestPortInRange(hostIP, proto, hostPortStart, hostPortEnd); err != nil {
-			return nil, err
-		}
+// MapPortRange maps the count consecutive ports from the container
+// transport address to as many consecutive host ports, allocated in the
+// host port range, with a single rule for all the ports. It returns the
+// address of the first host port, which unmaps all of them.
+func (pm *PortMapper) MapPortRange(container net.Addr, containerv6 net.Addr, hostIP net.IP, count, hostPortStart, hostPortEnd int, useProxy bool, opts ...MapOption) (host net.Addr, err error) {
+	return pm.mapPorts(container, containerv6, hostIP, count, hostPortStart, hostPortEnd, useProxy, opts)
+}
 
-		m = &mapping{
-			proto:       proto,
-			host:        &sctp.SCTPAddr{IP: []net.IP{hostIP}, Port: allocatedHostPort},
-			container:   container,
-			containerv6: containerv6,
-		}
+func (pm *PortMapper) mapPorts(container net.Addr, containerv6 net.Addr, hostIP net.IP, count, hostPortStart, hostPortEnd int, useProxy bool, opts []MapOption) (host net.Addr, err error) {
+	defer pm.dispatchEvents()
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
 
-		if useProxy {
-			sctpAddr := container.(*sctp.SCTPAddr)
-			if len(sctpAddr.IP) == 0 {
-				return nil, ErrSCTPAddrNoIP
-			}
-			m.userlandProxy, err = newProxy(proto, hostIP, allocatedHostPort, sctpAddr.IP[0], sctpAddr.Port, pm.proxyPath)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			m.userlandProxy, err = newDummyProxy(proto, hostIP, allocatedHostPort)
-			if err != nil {
-				return nil, err
-			}
+	proto := protoOf(container)
+	defer func() {
+		if err != nil {
+			mapFailuresCounter.Inc(proto)
 		}
-	default:
-		return nil, ErrUnknownBackendAddressType
-	}
+	}()
 
+	m, err := pm.newMapping(container, containerv6, hostIP, count, hostPortStart, hostPortEnd, useProxy, opts)
+	if err != nil {
+		return nil, err
+	}
 	// release the allocated port on any further error during return.
 	defer func() {
 		if err != nil {
-			pm.Allocator.ReleasePort(hostIP, proto, allocatedHostPort)
+			pm.releasePorts(m)
 		}
 	}()
 
@@ -184,15 +341,13 @@ func (pm *PortMapper) MapRange(container net.Addr, containerv6 net.Addr, hostIP
 		return nil, ErrPortMappedForIP
 	}
 
-	containerIP, containerPort := getIPAndPort(m.container)
-	if containerIP.To4() != nil {
-		if err := pm.forward(iptables.Append, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort); err != nil {
+	if m.forwardsIPv4() {
+		if err := pm.forward(iptables.Append, m); err != nil {
 			return nil, err
 		}
 	}
-	containerIPv6, containerPort := getIPAndPort(m.containerv6)
-	if containerIPv6 != nil {
-		if err := pm.ip6tForward(ip6tables.Append, m.proto, hostIP, allocatedHostPort, containerIPv6.String(), containerPort); err != nil {
+	if m.forwardsIPv6() {
+		if err := pm.ip6tForward(ip6tables.Append, m); err != nil {
 			return nil, err
 		}
 	}
@@ -200,15 +355,15 @@ func (pm *PortMapper) MapRange(container net.Addr, containerv6 net.Addr, hostIP
 	cleanup := func() error {
 		// need to undo the iptables rules before we return
 		m.userlandProxy.Stop()
-		if containerIP.To4() != nil {
-			pm.forward(iptables.Delete, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort)
-			if err := pm.Allocator.ReleasePort(hostIP, m.proto, allocatedHostPort); err != nil {
+		if m.forwardsIPv4() {
+			pm.forward(iptables.Delete, m)
+			if err := pm.releasePorts(m); err != nil {
 				return err
 			}
 		}
-		if containerIPv6 != nil {
-			pm.ip6tForward(ip6tables.Delete, m.proto, hostIP, allocatedHostPort, containerIPv6.String(), containerPort)
-			if err := pm.Allocator.ReleasePort(hostIP, m.proto, allocatedHostPort); err != nil {
+		if m.forwardsIPv6() {
+			pm.ip6tForward(ip6tables.Delete, m)
+			if err := pm.releasePorts(m); err != nil {
 				return err
 			}
 		}
@@ -223,12 +378,26 @@ func (pm *PortMapper) MapRange(container net.Addr, containerv6 net.Addr, hostIP
 		return nil, err
 	}
 
+	if pm.portDriver != nil {
+		if err := pm.exposePorts(m); err != nil {
+			if err := cleanup(); err != nil {
+				return nil, fmt.Errorf("Error during port allocation cleanup: %v", err)
+			}
+			return nil, err
+		}
+	}
+
 	pm.currentMappings[key] = m
+	mappingsGauge.Inc(m.proto)
+	pm.queueEvent(event{kind: eventMapped, mapping: m.record()})
 	return m.host, nil
 }
 
-// Unmap removes stored mapping for the specified host transport address
+// Unmap removes stored mapping for the specified host transport address.
+// The mappings of a group created by MapMultiHost are all removed with any
+// of them.
 func (pm *PortMapper) Unmap(host net.Addr) error {
+	defer pm.dispatchEvents()
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 
@@ -237,53 +406,274 @@ func (pm *PortMapper) Unmap(host net.Addr) error {
 	if !exists {
 		return ErrPortNotMapped
 	}
+	if len(data.group) > 0 {
+		return pm.unmapMany(data.group)
+	}
 
 	if data.userlandProxy != nil {
 		data.userlandProxy.Stop()
 	}
 
 	delete(pm.currentMappings, key)
+	mappingsGauge.Dec(data.proto)
+	pm.queueEvent(event{kind: eventUnmapped, mapping: data.record()})
 
-	containerIP, containerPort := getIPAndPort(data.container)
-	hostIP, hostPort := getIPAndPort(data.host)
-	if err := pm.forward(iptables.Delete, data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
-		logrus.Errorf("Error on iptables delete: %s", err)
+	if pm.portDriver != nil {
+		if err := pm.unexposePorts(data); err != nil {
+			logrus.Errorf("Error on host port removal: %s", err)
+			unmapFailuresCounter.Inc(data.proto)
+		}
 	}
-	containerIPv6, containerPort := getIPAndPort(data.containerv6)
-	if containerIPv6 != nil {
-		if err := pm.ip6tForward(ip6tables.Delete, data.proto, hostIP, hostPort, containerIPv6.String(), containerPort); err != nil {
+	if data.forwardsIPv4() {
+		if err := pm.forward(iptables.Delete, data); err != nil {
+			logrus.Errorf("Error on iptables delete: %s", err)
+			unmapFailuresCounter.Inc(data.proto)
+		}
+	}
+	if data.forwardsIPv6() {
+		if err := pm.ip6tForward(ip6tables.Delete, data); err != nil {
 			logrus.Errorf("Error on ip6tables delete: %s", err)
+			unmapFailuresCounter.Inc(data.proto)
 		}
 	}
 
-	switch a := host.(type) {
+	pm.flushConntrack(data)
+
+	return pm.releasePorts(data)
+}
+
+// newMapping allocates the host ports of the mapping and creates its
+// userland proxy
+func (pm *PortMapper) newMapping(container net.Addr, containerv6 net.Addr, hostIP net.IP, count, hostPortStart, hostPortEnd int, useProxy bool, opts []MapOption) (m *mapping, err error) {
+	var (
+		o                 mapOptions
+		proto             = protoOf(container)
+		allocatedHostPort int
+	)
+	if proto == "" {
+		return nil, ErrUnknownBackendAddressType
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.proxyProtocol != 0 {
+		if o.proxyProtocol != 1 && o.proxyProtocol != 2 {
+			return nil, fmt.Errorf("invalid PROXY protocol version %d", o.proxyProtocol)
+		}
+		if proto != "tcp" {
+			return nil, fmt.Errorf("the PROXY protocol is only supported for tcp mappings")
+		}
+		if !useProxy {
+			return nil, fmt.Errorf("the PROXY protocol requires the userland proxy")
+		}
+		if o.rateLimit != nil || (o.hairpin != nil && *o.hairpin) {
+			return nil, fmt.Errorf("the PROXY protocol mappings have no iptables rules to rate limit or hairpin NAT the connections")
+		}
+	}
+	if o.rateLimit != nil && (o.rateLimit.Rate < 1 || o.rateLimit.Burst < 0) {
+		return nil, fmt.Errorf("invalid rate limit of %d connections per second with a burst of %d", o.rateLimit.Rate, o.rateLimit.Burst)
+	}
+	if sctpAddr, ok := container.(*sctp.SCTPAddr); ok && useProxy && len(sctpAddr.IP) == 0 {
+		return nil, ErrSCTPAddrNoIP
+	}
+	if err := pm.checkSCTPHostIPs(proto, hostIP, o.sctpHostIPs); err != nil {
+		return nil, err
+	}
+	_, containerPort := getIPAndPort(container)
+	if count < 1 || containerPort+count-1 > 65535 {
+		return nil, fmt.Errorf("invalid range of %d ports from container port %d", count, containerPort)
+	}
+	// An IPv6 host address is forwarded to the IPv6 container address, only
+	// the userland proxy can forward it to an IPv4 one
+	proxied := container
+	if isIPv6Host(hostIP) {
+		if containerv6 != nil {
+			proxied = containerv6
+		} else if !useProxy {
+			return nil, ErrNoContainerIPv6
+		}
+	}
+
+	if count == 1 {
+		allocatedHostPort, err = pm.Allocator.RequestPortInRange(hostIP, proto, hostPortStart, hostPortEnd)
+	} else {
+		allocatedHostPort, err = pm.Allocator.RequestPortBlock(hostIP, proto, count, hostPortStart, hostPortEnd)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hostIPs := []net.IP{hostIP}
+	defer func() {
+		if err != nil {
+			for _, ip := range hostIPs {
+				pm.releaseRange(ip, proto, allocatedHostPort, count)
+			}
+		}
+	}()
+	// the other addresses of a multihomed SCTP mapping have the same ports
+	for _, ip := range o.sctpHostIPs {
+		for i := 0; i < count; i++ {
+			if _, err = pm.Allocator.RequestPort(ip, proto, allocatedHostPort+i); err != nil {
+				pm.releaseRange(ip, proto, allocatedHostPort, i)
+				return nil, err
+			}
+		}
+		hostIPs = append(hostIPs, ip)
+	}
+
+	m = &mapping{
+		proto:       proto,
+		host:        newMultiAddr(proto, hostIPs, allocatedHostPort),
+		container:   container,
+		containerv6: containerv6,
+		ports:       count,
+		proxyState:  ProxyDisabled,
+	}
+	if useProxy {
+		m.proxyState = ProxyRunning
+		m.proxyProtocol = o.proxyProtocol
+	}
+	m.hairpin = o.hairpin
+	m.conntrackFlush = o.conntrackFlush
+	m.rateLimit = o.rateLimit
+
+	childIPs := make([]net.IP, 0, len(hostIPs))
+	for _, ip := range hostIPs {
+		childIPs = append(childIPs, pm.childIP(ip))
+	}
+	proxies := make(multiProxy, 0, count)
+	for i := 0; i < count; i++ {
+		var p userlandProxy
+		host := newMultiAddr(proto, childIPs, allocatedHostPort+i)
+		if useProxy {
+			p, err = pm.newUserlandProxy(host, newMultiAddr(proto, addrIPs(proxied), containerPort+i), m.proxyProtocol)
+		} else {
+			p, err = newDummyProxy(host)
+		}
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, p)
+	}
+	m.userlandProxy = proxies
+	if count == 1 {
+		m.userlandProxy = proxies[0]
+	}
+	if n, ok := m.userlandProxy.(exitNotifier); ok {
+		n.notifyExit(func(err error) { pm.proxyExited(m, err) })
+	}
+
+	return m, nil
+}
+
+// newUserlandProxy returns the userland proxy of a mapping
+func (pm *PortMapper) newUserlandProxy(host, container net.Addr, proxyProtocol int) (userlandProxy, error) {
+	if pm.inProcessProxy {
+		return newInProcessProxy(host, container, proxyProtocol)
+	}
+	return newProxy(host, container, pm.proxyPath, proxyProtocol)
+}
+
+// newAddr returns the transport address of proto
+func newAddr(proto string, ip net.IP, port int) net.Addr {
+	switch proto {
+	case "tcp":
+		return &net.TCPAddr{IP: ip, Port: port}
+	case "udp":
+		return &net.UDPAddr{IP: ip, Port: port}
+	case "sctp":
+		return &sctp.SCTPAddr{IP: []net.IP{ip}, Port: port}
+	case "dccp":
+		return &types.DCCPAddr{IP: ip, Port: port}
+	}
+	return nil
+}
+
+// protoOf returns the protocol of the container address
+func protoOf(container net.Addr) string {
+	switch container.(type) {
 	case *net.TCPAddr:
-		return pm.Allocator.ReleasePort(a.IP, "tcp", a.Port)
+		return "tcp"
 	case *net.UDPAddr:
-		return pm.Allocator.ReleasePort(a.IP, "udp", a.Port)
+		return "udp"
 	case *sctp.SCTPAddr:
-		if len(a.IP) == 0 {
-			return ErrSCTPAddrNoIP
-		}
-		return pm.Allocator.ReleasePort(a.IP[0], "sctp", a.Port)
+		return "sctp"
+	case *types.DCCPAddr:
+		return "dccp"
+	}
+	return ""
+}
+
+// ListMappings returns the records of the current port mappings, sorted by
+// host address
+func (pm *PortMapper) ListMappings() []Mapping {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	keys := make([]string, 0, len(pm.currentMappings))
+	for k := range pm.currentMappings {
+		keys = append(keys, k)
 	}
-	return ErrUnknownBackendAddressType
+	sort.Strings(keys)
+
+	mappings := make([]Mapping, 0, len(keys))
+	for _, k := range keys {
+		mappings = append(mappings, pm.currentMappings[k].record())
+	}
+	return mappings
 }
 
-//ReMapAll will re-apply all port mappings
+// record returns the record of the mapping
+func (m *mapping) record() Mapping {
+	return Mapping{
+		Proto:         m.proto,
+		Host:          m.host,
+		Container:     m.container,
+		ContainerV6:   m.containerv6,
+		Ports:         m.ports,
+		Proxy:         m.proxyState,
+		ProxyProtocol: m.proxyProtocol,
+		Group:         m.group,
+		HairpinNAT:    m.hairpin,
+		RateLimit:     m.rateLimit,
+	}
+}
+
+// ReMapAll will re-apply all port mappings
 func (pm *PortMapper) ReMapAll() {
+	pm.reMap(true, true)
+}
+
+// ReMapIPv4 re-applies the iptables rules of all the port mappings, as on a
+// reload of the iptables rules by firewalld
+func (pm *PortMapper) ReMapIPv4() {
+	pm.reMap(true, false)
+}
+
+// ReMapIPv6 re-applies the ip6tables rules of all the port mappings, as on
+// a reload of the ip6tables rules by firewalld
+func (pm *PortMapper) ReMapIPv6() {
+	pm.reMap(false, true)
+}
+
+func (pm *PortMapper) reMap(ipv4, ipv6 bool) {
 	pm.lock.Lock()
+	onReMapped := pm.hooks.onReMapped
+	defer func() {
+		for _, cb := range onReMapped {
+			cb(ipv4, ipv6)
+		}
+	}()
 	defer pm.lock.Unlock()
-	logrus.Debugln("Re-applying all port mappings.")
+	logrus.Debugf("Re-applying all port mappings, IPv4: %v, IPv6: %v.", ipv4, ipv6)
 	for _, data := range pm.currentMappings {
-		containerIP, containerPort := getIPAndPort(data.container)
-		hostIP, hostPort := getIPAndPort(data.host)
-		if err := pm.forward(iptables.Append, data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
-			logrus.Errorf("Error on iptables add: %s", err)
+		if ipv4 && data.forwardsIPv4() {
+			if err := pm.forward(iptables.Append, data); err != nil {
+				logrus.Errorf("Error on iptables add: %s", err)
+			}
 		}
-		if data.containerv6 != nil {
-			containerIPv6, containerPort := getIPAndPort(data.containerv6)
-			if err := pm.ip6tForward(ip6tables.Append, data.proto, hostIP, hostPort, containerIPv6.String(), containerPort); err != nil {
+		if ipv6 && data.forwardsIPv6() {
+			if err := pm.ip6tForward(ip6tables.Append, data); err != nil {
 				logrus.Errorf("Error on ip6tables add: %s", err)
 			}
 		}
@@ -293,19 +683,51 @@ func (pm *PortMapper) ReMapAll() {
 func getKey(a net.Addr) string {
 	switch t := a.(type) {
 	case *net.TCPAddr:
-		return fmt.Sprintf("%s:%d/%s", t.IP.String(), t.Port, "tcp")
+		return fmt.Sprintf("%s/%s", net.JoinHostPort(t.IP.String(), strconv.Itoa(t.Port)), "tcp")
 	case *net.UDPAddr:
-		return fmt.Sprintf("%s:%d/%s", t.IP.String(), t.Port, "udp")
+		return fmt.Sprintf("%s/%s", net.JoinHostPort(t.IP.String(), strconv.Itoa(t.Port)), "udp")
 	case *sctp.SCTPAddr:
 		if len(t.IP) == 0 {
 			logrus.Error(ErrSCTPAddrNoIP)
 			return ""
 		}
-		return fmt.Sprintf("%s:%d/%s", t.IP[0].String(), t.Port, "sctp")
+		// the addresses of a multihomed address are all part of its key
+		return fmt.Sprintf("%s/%s", net.JoinHostPort(joinIPs(t.IP), strconv.Itoa(t.Port)), "sctp")
+	case *types.DCCPAddr:
+		return fmt.Sprintf("%s/%s", net.JoinHostPort(t.IP.String(), strconv.Itoa(t.Port)), "dccp")
 	}
 	return ""
 }
 
+// forwardsIPv4 returns whether the host IP of the mapping is forwarded to
+// the IPv4 container address with iptables. The unspecified host addresses
+// are forwarded to both container addresses. The mappings sending the
+// PROXY protocol header are relayed by their userland proxy only.
+func (m *mapping) forwardsIPv4() bool {
+	if m.proxyProtocol != 0 {
+		return false
+	}
+	hostIP, _ := getIPAndPort(m.host)
+	containerIP, _ := getIPAndPort(m.container)
+	return containerIP.To4() != nil && (hostIP.IsUnspecified() || hostIP.To4() != nil)
+}
+
+// forwardsIPv6 returns whether the host IP of the mapping is forwarded to
+// the IPv6 container address with ip6tables
+func (m *mapping) forwardsIPv6() bool {
+	if m.proxyProtocol != 0 {
+		return false
+	}
+	hostIP, _ := getIPAndPort(m.host)
+	containerIPv6, _ := getIPAndPort(m.containerv6)
+	return containerIPv6 != nil && (hostIP.IsUnspecified() || hostIP.To4() == nil)
+}
+
+// isIPv6Host returns whether the host IP is a specified IPv6 address
+func isIPv6Host(hostIP net.IP) bool {
+	return hostIP != nil && hostIP.To4() == nil && !hostIP.IsUnspecified()
+}
+
 func getIPAndPort(a net.Addr) (net.IP, int) {
 	switch t := a.(type) {
 	case *net.TCPAddr:
@@ -318,20 +740,126 @@ func getIPAndPort(a net.Addr) (net.IP, int) {
 			return nil, 0
 		}
 		return t.IP[0], t.Port
+	case *types.DCCPAddr:
+		return t.IP, t.Port
 	}
 	return nil, 0
 }
 
-func (pm *PortMapper) forward(action iptables.Action, proto string, sourceIP net.IP, sourcePort int, containerIP string, containerPort int) error {
+func (pm *PortMapper) forward(action iptables.Action, m *mapping) error {
 	if pm.chain == nil {
 		return nil
 	}
-	return pm.chain.Forward(action, sourceIP, sourcePort, proto, containerIP, containerPort, pm.bridgeName)
+	rules, _ := pm.mappingRules(m)
+	for _, r := range rules {
+		if err := iptables.ProgramRule(r.Table, r.Chain, action, r.Args); err != nil {
+			return err
+		}
+	}
+	if action == iptables.Append {
+		action = iptables.Insert
+	}
+	rules, _ = pm.insertedRules(m)
+	return iptables.ProgramRules(action, rules)
 }
 
-func (pm *PortMapper) ip6tForward(action ip6tables.Action, proto string, sourceIP net.IP, sourcePort int, containerIPv6 string, containerPort int) error {
+func (pm *PortMapper) ip6tForward(action ip6tables.Action, m *mapping) error {
 	if pm.ip6tChain == nil {
 		return nil
 	}
-	return pm.ip6tChain.Forward(action, sourceIP, sourcePort, proto, containerIPv6, containerPort, pm.bridgeName)
+	_, ip6tRules := pm.mappingRules(m)
+	for _, r := range ip6tRules {
+		if err := ip6tables.ProgramRule(r.Table, r.Chain, action, r.Args); err != nil {
+			return err
+		}
+	}
+	if action == ip6tables.Append {
+		action = ip6tables.Insert
+	}
+	_, ip6tRules = pm.insertedRules(m)
+	return ip6tables.ProgramRules(action, ip6tRules)
+}
+
+// mappingChain returns the iptables chain programming the forwarding
+// rules of the mapping. With its hairpin NAT set, the mapping does not
+// translate the traffic from the bridge with the rules of the chain, the
+// traffic is translated by the hairpin rules when enabled.
+func (pm *PortMapper) mappingChain(m *mapping) *iptables.ChainInfo {
+	if m.hairpin == nil {
+		return pm.chain
+	}
+	c := *pm.chain
+	c.HairpinMode = false
+	return &c
+}
+
+// mappingIP6tChain returns the ip6tables chain programming the forwarding
+// rules of the mapping, as mappingChain does
+func (pm *PortMapper) mappingIP6tChain(m *mapping) *ip6tables.ChainInfo {
+	if m.hairpin == nil {
+		return pm.ip6tChain
+	}
+	c := *pm.ip6tChain
+	c.HairpinMode = false
+	return &c
+}
+
+// releasePorts releases the host ports of a mapping on all its host IP
+// addresses
+func (pm *PortMapper) releasePorts(m *mapping) error {
+	var err error
+	_, hostPort := getIPAndPort(m.host)
+	for _, hostIP := range addrIPs(m.host) {
+		if rerr := pm.releaseRange(hostIP, m.proto, hostPort, m.ports); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// releaseRange releases the ports of the host IP from hostPort
+func (pm *PortMapper) releaseRange(hostIP net.IP, proto string, hostPort, ports int) error {
+	var err error
+	for port := hostPort; port < hostPort+ports; port++ {
+		if rerr := pm.Allocator.ReleasePort(hostIP, proto, port); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// exposePorts exposes the ports of the mapping with the port driver
+func (pm *PortMapper) exposePorts(m *mapping) error {
+	_, hostPort := getIPAndPort(m.host)
+	hostIPs := addrIPs(m.host)
+	for i, hostIP := range hostIPs {
+		for port := hostPort; port < hostPort+m.ports; port++ {
+			if err := pm.portDriver.ExposePort(m.proto, hostIP, port); err != nil {
+				for p := hostPort; p < port; p++ {
+					pm.portDriver.UnexposePort(m.proto, hostIP, p)
+				}
+				for _, ip := range hostIPs[:i] {
+					for p := hostPort; p < hostPort+m.ports; p++ {
+						pm.portDriver.UnexposePort(m.proto, ip, p)
+					}
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unexposePorts removes the ports of the mapping from the port driver
+func (pm *PortMapper) unexposePorts(m *mapping) error {
+	var err error
+	_, hostPort := getIPAndPort(m.host)
+	for _, hostIP := range addrIPs(m.host) {
+		for port := hostPort; port < hostPort+m.ports; port++ {
+			if uerr := pm.portDriver.UnexposePort(m.proto, hostIP, port); uerr != nil && err == nil {
+				err = uerr
+			}
+		}
+	}
+	return err
 }
@@ -1,13 +1,20 @@
 package portmapper
 
 import (
+	"errors"
+	"fmt"
 	"net"
+	"os/exec"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/libnetwork/ip6tables"
 	"github.com/docker/libnetwork/iptables"
 	_ "github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/ishidawataru/sctp"
 )
 
 func init() {
@@ -113,6 +120,21 @@ func TestGetTCPKey(t *testing.T) {
 	if expected := "192.168.1.5:80/tcp"; key != expected {
 		t.Fatalf("expected key %s got %s", expected, key)
 	}
+
+	key = getKey(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 80})
+	if expected := "[2001:db8::1]:80/tcp"; key != expected {
+		t.Fatalf("expected key %s got %s", expected, key)
+	}
+}
+
+func TestGetDCCPKey(t *testing.T) {
+	addr := &types.DCCPAddr{IP: net.ParseIP("192.168.1.5"), Port: 5004}
+
+	key := getKey(addr)
+
+	if expected := "192.168.1.5:5004/dccp"; key != expected {
+		t.Fatalf("expected key %s got %s", expected, key)
+	}
 }
 
 func TestGetUDPIPAndPort(t *testing.T) {
@@ -286,3 +308,581 @@ func TestMapUDPDummyListen(t *testing.T) {
 		}
 	}
 }
+
+type mockPortDriver struct {
+	exposed map[string]bool
+	fail    bool
+}
+
+func (d *mockPortDriver) ExposePort(proto string, hostIP net.IP, port int) error {
+	if d.fail {
+		return errors.New("port driver failure")
+	}
+	d.exposed[getKey(&net.TCPAddr{IP: hostIP, Port: port})] = true
+	return nil
+}
+
+func (d *mockPortDriver) UnexposePort(proto string, hostIP net.IP, port int) error {
+	delete(d.exposed, getKey(&net.TCPAddr{IP: hostIP, Port: port}))
+	return nil
+}
+
+func TestMapPortDriver(t *testing.T) {
+	pm := New("")
+	d := &mockPortDriver{exposed: make(map[string]bool)}
+	pm.SetPortDriver(d)
+
+	hostIP := net.ParseIP("192.168.0.1")
+	hostAddr := &net.TCPAddr{IP: hostIP, Port: 8080}
+	if host, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 80}, nil, hostIP, 8080, true); err != nil {
+		t.Fatal(err)
+	} else if host.String() != hostAddr.String() {
+		t.Fatalf("unexpected host address %s", host)
+	}
+	if !d.exposed[getKey(hostAddr)] {
+		t.Fatalf("expected %s to be exposed, got %v", hostAddr, d.exposed)
+	}
+	if err := pm.Unmap(hostAddr); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.exposed) != 0 {
+		t.Fatalf("expected no exposed ports, got %v", d.exposed)
+	}
+
+	// the port is released when it cannot be exposed
+	d.fail = true
+	if _, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 80}, nil, hostIP, 8080, true); err == nil {
+		t.Fatal("expected the mapping to fail")
+	}
+	d.fail = false
+	if _, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 80}, nil, hostIP, 8080, true); err != nil {
+		t.Fatalf("expected the port to be released on failure: %v", err)
+	}
+}
+
+func TestListMappings(t *testing.T) {
+	pm := New("")
+	hostIP := net.ParseIP("127.0.0.1")
+	tcpAddr := &net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}
+	tcpAddrv6 := &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 80}
+	udpAddr := &net.UDPAddr{IP: net.ParseIP("172.16.0.2"), Port: 53}
+
+	if mappings := pm.ListMappings(); len(mappings) != 0 {
+		t.Fatalf("expected no mappings, got %v", mappings)
+	}
+
+	tcpHost, err := pm.Map(tcpAddr, tcpAddrv6, hostIP, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Unmap(tcpHost)
+	udpHost, err := pm.Map(udpAddr, nil, hostIP, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mappings := pm.ListMappings()
+	if len(mappings) != 2 {
+		t.Fatalf("expected 2 mappings, got %v", mappings)
+	}
+	for _, m := range mappings {
+		switch m.Proto {
+		case "tcp":
+			if m.Host != tcpHost || m.Container != tcpAddr || m.ContainerV6 != tcpAddrv6 || m.Proxy != ProxyRunning {
+				t.Fatalf("unexpected tcp mapping %+v", m)
+			}
+		case "udp":
+			if m.Host != udpHost || m.Container != udpAddr || m.ContainerV6 != nil || m.Proxy != ProxyDisabled {
+				t.Fatalf("unexpected udp mapping %+v", m)
+			}
+		default:
+			t.Fatalf("unexpected mapping %+v", m)
+		}
+	}
+
+	if err := pm.Unmap(udpHost); err != nil {
+		t.Fatal(err)
+	}
+	if mappings := pm.ListMappings(); len(mappings) != 1 || mappings[0].Proto != "tcp" {
+		t.Fatalf("expected the tcp mapping only, got %v", mappings)
+	}
+}
+
+func TestStatsNotMapped(t *testing.T) {
+	pm := New("")
+	if _, err := pm.Stats(&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080}); err != ErrPortNotMapped {
+		t.Fatalf("expected %v, got %v", ErrPortNotMapped, err)
+	}
+
+	// the mappings without iptables rules have no counters
+	host, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}, nil, net.ParseIP("127.0.0.1"), 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Unmap(host)
+	if stats, err := pm.Stats(host); err != nil || stats != (MappingStats{}) {
+		t.Fatalf("expected no counters, got %+v, %v", stats, err)
+	}
+}
+
+func TestMapInProcessProxy(t *testing.T) {
+	backend, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 64)
+				n, _ := conn.Read(buf)
+				conn.Write(buf[:n])
+			}()
+		}
+	}()
+
+	pm := New("")
+	pm.SetInProcessProxy(true)
+	host, err := pm.Map(backend.Addr(), nil, net.ParseIP("127.0.0.1"), 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", host.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := conn.Read(buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("expected the traffic to be relayed, got %q, %v", buf, err)
+	}
+
+	if err := pm.Unmap(host); err != nil {
+		t.Fatal(err)
+	}
+	// the host port is released by the proxy
+	l, err := net.Listen("tcp", host.String())
+	if err != nil {
+		t.Fatalf("expected the proxy to stop listening: %v", err)
+	}
+	l.Close()
+}
+
+func TestMapProxyProtocol(t *testing.T) {
+	pm := New("")
+	hostIP := net.ParseIP("127.0.0.1")
+	if _, err := pm.Map(&net.UDPAddr{IP: net.ParseIP("172.16.0.1"), Port: 53}, nil, hostIP, 0, true, WithProxyProtocol(2)); err == nil {
+		t.Fatal("expected the PROXY protocol to be rejected for udp")
+	}
+	if _, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}, nil, hostIP, 0, true, WithProxyProtocol(3)); err == nil {
+		t.Fatal("expected the PROXY protocol version 3 to be rejected")
+	}
+	if _, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}, nil, hostIP, 0, false, WithProxyProtocol(2)); err == nil {
+		t.Fatal("expected the PROXY protocol to be rejected without the userland proxy")
+	}
+	if _, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}, nil, hostIP, 0, true, WithProxyProtocol(2), WithRateLimit(10, 0)); err == nil {
+		t.Fatal("expected the PROXY protocol to be rejected with a rate limit")
+	}
+
+	container := &net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}
+	host, err := pm.Map(container, nil, hostIP, 0, true, WithProxyProtocol(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Unmap(host)
+	if mappings := pm.ListMappings(); len(mappings) != 1 || mappings[0].ProxyProtocol != 2 {
+		t.Fatalf("expected the mapping to send PROXY protocol v2 headers, got %v", mappings)
+	}
+
+	// all the connections go through the userland proxy
+	pm.SetIptablesChain(&iptables.ChainInfo{Name: "DOCKER", Table: iptables.Nat}, "docker0")
+	defer pm.SetIptablesChain(nil, "")
+	m, err := pm.newMapping(container, nil, hostIP, 1, 8081, 8081, true, []MapOption{WithProxyProtocol(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.Allocator.ReleasePort(hostIP, "tcp", 8081)
+	if rules, _ := pm.mappingRules(m); len(rules) != 0 {
+		t.Fatalf("expected no NAT rules for the PROXY protocol mapping, got %v", rules)
+	}
+}
+
+func TestMapPortRange(t *testing.T) {
+	pm := New("")
+	hostIP := net.ParseIP("127.0.0.1")
+	container := &net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 8000}
+
+	if _, err := pm.MapPortRange(container, nil, hostIP, 10, 9000, 9005, true); err == nil {
+		t.Fatal("expected a range larger than the host port range to fail")
+	}
+	if _, err := pm.MapPortRange(&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 65530}, nil, hostIP, 10, 9000, 9100, true); err == nil {
+		t.Fatal("expected a range past the last container port to fail")
+	}
+
+	host, err := pm.MapPortRange(container, nil, hostIP, 10, 9000, 9100, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, port := getIPAndPort(host); port != 9000 {
+		t.Fatalf("expected the range to start at host port 9000, got %d", port)
+	}
+	if mappings := pm.ListMappings(); len(mappings) != 1 || mappings[0].Ports != 10 {
+		t.Fatalf("expected one mapping of 10 ports, got %v", mappings)
+	}
+	if _, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 80}, nil, hostIP, 9009, true); err == nil {
+		t.Fatal("expected the last host port of the range to be allocated")
+	}
+
+	if err := pm.Unmap(host); err != nil {
+		t.Fatal(err)
+	}
+	for port := 9000; port < 9010; port++ {
+		if _, err := pm.Allocator.RequestPort(hostIP, "tcp", port); err != nil {
+			t.Fatalf("expected host port %d to be released: %v", port, err)
+		}
+		pm.Allocator.ReleasePort(hostIP, "tcp", port)
+	}
+}
+
+func TestMapMultiHost(t *testing.T) {
+	pm := New("")
+	container := &net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}
+	hostIPs := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2")}
+
+	if _, err := pm.MapMultiHost(container, nil, nil, 0, 0, true); err != ErrNoHostIP {
+		t.Fatalf("expected ErrNoHostIP, got %v", err)
+	}
+
+	// the group is not created when one of the host IPs has the port
+	// mapped, and the ports allocated for the other host IPs are released
+	taken, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.2"), Port: 80}, nil, hostIPs[1], 9200, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.MapMultiHost(container, nil, hostIPs, 9200, 9200, true); err == nil {
+		t.Fatal("expected the group to fail on the mapped host port")
+	}
+	if len(pm.ListMappings()) != 1 {
+		t.Fatalf("expected the failed group to be rolled back, got %v", pm.ListMappings())
+	}
+	if err := pm.Unmap(taken); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := pm.MapMultiHost(container, nil, hostIPs, 9200, 9210, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("expected 2 host addresses, got %v", hosts)
+	}
+	for i, host := range hosts {
+		ip, port := getIPAndPort(host)
+		if !ip.Equal(hostIPs[i]) || port != 9200 {
+			t.Fatalf("expected host address %s:9200, got %s", hostIPs[i], host)
+		}
+	}
+	if mappings := pm.ListMappings(); len(mappings) != 2 || len(mappings[0].Group) != 2 {
+		t.Fatalf("expected a group of 2 mappings, got %v", mappings)
+	}
+
+	if err := pm.Unmap(hosts[1]); err != nil {
+		t.Fatal(err)
+	}
+	if mappings := pm.ListMappings(); len(mappings) != 0 {
+		t.Fatalf("expected the group to be unmapped, got %v", mappings)
+	}
+	if err := pm.Unmap(hosts[0]); err != ErrPortNotMapped {
+		t.Fatalf("expected ErrPortNotMapped, got %v", err)
+	}
+}
+
+func TestMapIPv6HostIP(t *testing.T) {
+	pm := New("")
+	hostIP := net.ParseIP("::1")
+	container := &net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}
+	containerv6 := &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 80}
+
+	if _, err := pm.Map(container, nil, hostIP, 0, false); err != ErrNoContainerIPv6 {
+		t.Fatalf("expected ErrNoContainerIPv6, got %v", err)
+	}
+
+	host, err := pm.Map(container, containerv6, hostIP, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Unmap(host)
+	if ip, _ := getIPAndPort(host); !ip.Equal(hostIP) {
+		t.Fatalf("expected the mapping to be published on %s, got %s", hostIP, host)
+	}
+	m := pm.currentMappings[getKey(host)]
+	if m.forwardsIPv4() || !m.forwardsIPv6() {
+		t.Fatal("expected the IPv6 host address to be forwarded with ip6tables only")
+	}
+
+	// the IPv4 host address of the same port is allocated apart
+	_, port := getIPAndPort(host)
+	host4, err := pm.Map(container, containerv6, net.ParseIP("127.0.0.1"), port, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Unmap(host4)
+	if m := pm.currentMappings[getKey(host4)]; !m.forwardsIPv4() || m.forwardsIPv6() {
+		t.Fatal("expected the IPv4 host address to be forwarded with iptables only")
+	}
+}
+
+func TestMapEvents(t *testing.T) {
+	pm := New("")
+	var events []string
+	pm.OnMapped(func(m Mapping) {
+		events = append(events, "mapped "+m.Host.String())
+		// the callbacks may call the port mapper
+		if len(pm.ListMappings()) != 1 {
+			t.Error("expected the mapping to be listed")
+		}
+	})
+	pm.OnUnmapped(func(m Mapping) { events = append(events, "unmapped "+m.Host.String()) })
+	pm.OnProxyExit(func(m Mapping, err error) {
+		events = append(events, "exited "+m.Host.String()+" "+err.Error())
+	})
+
+	hostIP := net.ParseIP("127.0.0.1")
+	host, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}, nil, hostIP, 9300, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.currentMappings[getKey(host)].userlandProxy.(*mockProxyCommand).onExit(errors.New("killed"))
+	if mappings := pm.ListMappings(); len(mappings) != 1 || mappings[0].Proxy != ProxyExited {
+		t.Fatalf("expected the proxy to be recorded as exited, got %v", mappings)
+	}
+	if err := pm.Unmap(host); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"mapped 127.0.0.1:9300", "exited 127.0.0.1:9300 killed", "unmapped 127.0.0.1:9300"}
+	if strings.Join(events, ", ") != strings.Join(expected, ", ") {
+		t.Fatalf("expected the events %v, got %v", expected, events)
+	}
+}
+
+func TestReMapEvents(t *testing.T) {
+	pm := New("")
+	var events []string
+	pm.OnReMapped(func(ipv4, ipv6 bool) {
+		events = append(events, fmt.Sprintf("%v %v", ipv4, ipv6))
+		// the callbacks may call the port mapper
+		pm.ListMappings()
+	})
+
+	pm.ReMapAll()
+	pm.ReMapIPv4()
+	pm.ReMapIPv6()
+	expected := []string{"true true", "true false", "false true"}
+	if strings.Join(events, ", ") != strings.Join(expected, ", ") {
+		t.Fatalf("expected the events %v, got %v", expected, events)
+	}
+}
+
+func TestProxyCommandExit(t *testing.T) {
+	p := &proxyCommand{cmd: exec.Command("/bin/sh", "-c", "echo 0 >&3")}
+	exited := make(chan error, 1)
+	p.notifyExit(func(err error) { exited <- err })
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the exit of the proxy to be reported")
+	}
+
+	p = &proxyCommand{cmd: exec.Command("/bin/sh", "-c", "echo 0 >&3; exec sleep 10")}
+	p.notifyExit(func(err error) { exited <- err })
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop()
+	select {
+	case err := <-exited:
+		t.Fatalf("expected the stopped proxy exit not to be reported, got %v", err)
+	default:
+	}
+}
+
+func TestUnmapConntrackFlush(t *testing.T) {
+	var flushed []string
+	defer func(f func(string, net.IP, int, int, net.IP) (uint, error)) { deleteConntrackEntries = f }(deleteConntrackEntries)
+	deleteConntrackEntries = func(proto string, hostIP net.IP, hostPort, hostPortEnd int, destIP net.IP) (uint, error) {
+		flushed = append(flushed, getKey(&net.UDPAddr{IP: hostIP, Port: hostPort})+" "+destIP.String())
+		return 1, nil
+	}
+
+	pm := New("")
+	hostIP := net.ParseIP("127.0.0.1")
+	container := &net.UDPAddr{IP: net.ParseIP("172.16.0.1"), Port: 53}
+	for _, tc := range []struct {
+		flush    bool
+		opts     []MapOption
+		expected int
+	}{
+		{false, nil, 0},
+		{true, nil, 1},
+		{true, []MapOption{WithConntrackFlush(false)}, 0},
+		{false, []MapOption{WithConntrackFlush(true)}, 1},
+	} {
+		flushed = nil
+		pm.SetConntrackFlush(tc.flush)
+		host, err := pm.Map(container, nil, hostIP, 9400, true, tc.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pm.Unmap(host); err != nil {
+			t.Fatal(err)
+		}
+		if len(flushed) != tc.expected {
+			t.Fatalf("expected %d conntrack flushes, got %v", tc.expected, flushed)
+		}
+		if tc.expected == 1 && flushed[0] != "127.0.0.1:9400/udp 172.16.0.1" {
+			t.Fatalf("unexpected conntrack flush %s", flushed[0])
+		}
+	}
+}
+
+func TestMapDCCPPorts(t *testing.T) {
+	pm := New("")
+	dstIP := net.ParseIP("127.0.0.1")
+	container := &types.DCCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 5004}
+
+	host, err := pm.Map(container, nil, dstIP, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := host.(*types.DCCPAddr); !ok {
+		t.Fatalf("expected a dccp host address, got %T", host)
+	}
+	if _, err := pm.Map(container, nil, dstIP, host.(*types.DCCPAddr).Port, true); err == nil {
+		t.Fatal("expected the dccp host port to be allocated")
+	}
+	// the tcp port of the same number is allocated apart
+	tcpHost, err := pm.Map(&net.TCPAddr{IP: container.IP, Port: 5004}, nil, dstIP, host.(*types.DCCPAddr).Port, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Unmap(tcpHost); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Unmap(host); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyMappings(t *testing.T) {
+	defer func(e func(iptables.Table, string, ...string) bool, p func(iptables.Table, string, iptables.Action, []string) error) {
+		ruleExists, programRule = e, p
+	}(ruleExists, programRule)
+	missing := map[string]bool{}
+	var programmed []string
+	ruleExists = func(table iptables.Table, chain string, args ...string) bool {
+		return !missing[iptables.Rule{Table: table, Chain: chain, Args: args}.String()]
+	}
+	programRule = func(table iptables.Table, chain string, action iptables.Action, args []string) error {
+		r := iptables.Rule{Table: table, Chain: chain, Args: args}.String()
+		delete(missing, r)
+		programmed = append(programmed, string(action)+" "+r)
+		return nil
+	}
+
+	pm := New("")
+	hostIP := net.ParseIP("127.0.0.1")
+	host, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}, nil, hostIP, 9500, true, WithHairpinNAT(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Unmap(host)
+	pm.SetIptablesChain(&iptables.ChainInfo{Name: "DOCKER", Table: iptables.Nat}, "br0")
+	defer pm.SetIptablesChain(nil, "")
+
+	if drift := pm.VerifyMappings(); len(drift) != 0 {
+		t.Fatalf("expected no drift, got %v", drift)
+	}
+
+	m := pm.currentMappings[getKey(host)]
+	rules, _ := pm.mappingRules(m)
+	hpRules, _ := pm.hairpinRules(m)
+	missing[rules[0].String()] = true
+	missing[hpRules[0].String()] = true
+	drift := pm.VerifyMappings()
+	if len(drift) != 2 {
+		t.Fatalf("expected 2 missing rules, got %v", drift)
+	}
+	for _, d := range drift {
+		if d.Host != host || d.IPv6 || d.Err != nil {
+			t.Fatalf("unexpected drift %v", d)
+		}
+	}
+	expected := []string{"-A " + rules[0].String(), "-I " + hpRules[0].String()}
+	if !reflect.DeepEqual(programmed, expected) {
+		t.Fatalf("expected the rules %v to be reinstalled, got %v", expected, programmed)
+	}
+	if drift := pm.VerifyMappings(); len(drift) != 0 {
+		t.Fatalf("expected no drift once reinstalled, got %v", drift)
+	}
+}
+
+func TestMapSCTPMultihoming(t *testing.T) {
+	pm := New("")
+	hostIPs := []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2")}
+	container := &sctp.SCTPAddr{IP: []net.IP{net.ParseIP("172.16.0.1"), net.ParseIP("172.16.1.1")}, Port: 9899}
+
+	if _, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 80}, nil, hostIPs[0], 0, true, WithSCTPHostIPs(hostIPs[1])); err == nil {
+		t.Fatal("expected a multihomed tcp mapping to fail")
+	}
+	if _, err := pm.Map(container, nil, hostIPs[0], 0, true, WithSCTPHostIPs(net.ParseIP("::1"))); err != ErrSCTPHostIPs {
+		t.Fatalf("expected ErrSCTPHostIPs, got %v", err)
+	}
+
+	host, err := pm.Map(container, nil, hostIPs[0], 9900, true, WithSCTPHostIPs(hostIPs[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "127.0.0.1,127.0.0.2:9900/sctp"; getKey(host) != expected {
+		t.Fatalf("expected the key %s, got %s", expected, getKey(host))
+	}
+	if _, err := pm.Allocator.RequestPort(hostIPs[1], "sctp", 9900); err == nil {
+		t.Fatal("expected the port of the other host address to be allocated")
+	}
+
+	pm.SetIptablesChain(&iptables.ChainInfo{Name: "DOCKER", Table: iptables.Nat}, "br0")
+	rules, _ := pm.mappingRules(pm.currentMappings[getKey(host)])
+	pm.SetIptablesChain(nil, "")
+	var dnats []string
+	for _, r := range rules {
+		if r.Table == iptables.Nat && r.Chain == "DOCKER" {
+			dnats = append(dnats, strings.Join(r.Args, " "))
+		}
+	}
+	if len(dnats) != 2 || !strings.Contains(dnats[0], "-d 127.0.0.1 --dport 9900 -j DNAT --to-destination 172.16.0.1:9899") ||
+		!strings.Contains(dnats[1], "-d 127.0.0.2 --dport 9900 -j DNAT --to-destination 172.16.1.1:9899") {
+		t.Fatalf("expected a DNAT rule for each host address, got %v", dnats)
+	}
+
+	if err := pm.Unmap(host); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range hostIPs {
+		if _, err := pm.Allocator.RequestPort(ip, "sctp", 9900); err != nil {
+			t.Fatalf("expected the port of %s to be released: %v", ip, err)
+		}
+		pm.Allocator.ReleasePort(ip, "sctp", 9900)
+	}
+}
@@ -2,11 +2,16 @@ package portmapper
 
 import "net"
 
-func newMockProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int, userlandProxyPath string) (userlandProxy, error) {
+func newMockProxyCommand(host, container net.Addr, userlandProxyPath string, proxyProtocol int) (userlandProxy, error) {
 	return &mockProxyCommand{}, nil
 }
 
 type mockProxyCommand struct {
+	onExit func(error)
+}
+
+func (p *mockProxyCommand) notifyExit(onExit func(error)) {
+	p.onExit = onExit
 }
 
 func (p *mockProxyCommand) Start() error {
@@ -7,9 +7,14 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"sync"
 	"time"
 
+	"github.com/docker/libnetwork/faults"
+	"github.com/docker/libnetwork/proxy"
+	"github.com/docker/libnetwork/types"
 	"github.com/ishidawataru/sctp"
+	"github.com/sirupsen/logrus"
 )
 
 var userlandProxyCommandName = "docker-proxy"
@@ -19,10 +24,45 @@ type userlandProxy interface {
 	Stop() error
 }
 
+// exitNotifier is a userland proxy reporting its unexpected exits
+type exitNotifier interface {
+	// notifyExit sets the function called with the exit error when the
+	// proxy exits without being stopped
+	notifyExit(onExit func(error))
+}
+
 // proxyCommand wraps an exec.Cmd to run the userland TCP and UDP
 // proxies as separate processes.
 type proxyCommand struct {
 	cmd *exec.Cmd
+	// done is closed when the process exited, with its exit error in err
+	done chan struct{}
+	err  error
+
+	mu      sync.Mutex
+	stopped bool
+	onExit  func(error)
+}
+
+var (
+	runningProxies   = map[*proxyCommand]struct{}{}
+	runningProxiesMu sync.Mutex
+)
+
+func init() {
+	faults.OnTrigger(faults.UserlandProxyKill, killProxies)
+}
+
+// killProxies kills the running userland proxy processes, as if they
+// crashed
+func killProxies() {
+	runningProxiesMu.Lock()
+	defer runningProxiesMu.Unlock()
+	for p := range runningProxies {
+		if err := p.cmd.Process.Kill(); err != nil {
+			logrus.Warnf("Failed to kill userland proxy %v: %v", p.cmd.Args, err)
+		}
+	}
 }
 
 func (p *proxyCommand) Start() error {
@@ -36,6 +76,8 @@ func (p *proxyCommand) Start() error {
 		return err
 	}
 	w.Close()
+	p.done = make(chan struct{})
+	go p.wait()
 
 	errchan := make(chan error, 1)
 	go func() {
@@ -57,22 +99,100 @@ func (p *proxyCommand) Start() error {
 
 	select {
 	case err := <-errchan:
+		if err == nil {
+			runningProxiesMu.Lock()
+			runningProxies[p] = struct{}{}
+			runningProxiesMu.Unlock()
+		} else {
+			p.setStopped()
+		}
 		return err
 	case <-time.After(16 * time.Second):
+		p.setStopped()
 		return fmt.Errorf("Timed out proxy starting the userland proxy")
 	}
 }
 
+// wait waits for the process to exit, reporting the exit when the proxy
+// was not stopped
+func (p *proxyCommand) wait() {
+	err := p.cmd.Wait()
+	p.mu.Lock()
+	p.err = err
+	stopped, onExit := p.stopped, p.onExit
+	p.mu.Unlock()
+	close(p.done)
+
+	runningProxiesMu.Lock()
+	delete(runningProxies, p)
+	runningProxiesMu.Unlock()
+	if !stopped && onExit != nil {
+		onExit(err)
+	}
+}
+
+func (p *proxyCommand) setStopped() {
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+}
+
+func (p *proxyCommand) notifyExit(onExit func(error)) {
+	p.mu.Lock()
+	p.onExit = onExit
+	p.mu.Unlock()
+}
+
 func (p *proxyCommand) Stop() error {
+	p.setStopped()
+	runningProxiesMu.Lock()
+	delete(runningProxies, p)
+	runningProxiesMu.Unlock()
+
 	if p.cmd.Process != nil {
 		if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
 			return err
 		}
-		return p.cmd.Wait()
+		<-p.done
+		return p.err
 	}
 	return nil
 }
 
+// multiProxy is the userland proxy of a range of ports, with a proxy per
+// port
+type multiProxy []userlandProxy
+
+func (p multiProxy) Start() error {
+	for i, proxy := range p {
+		if err := proxy.Start(); err != nil {
+			for _, started := range p[:i] {
+				started.Stop()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (p multiProxy) notifyExit(onExit func(error)) {
+	for _, proxy := range p {
+		if n, ok := proxy.(exitNotifier); ok {
+			n.notifyExit(onExit)
+		}
+	}
+}
+
+func (p multiProxy) Stop() error {
+	var err error
+	for _, proxy := range p {
+		if serr := proxy.Stop(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
 // dummyProxy just listen on some port, it is needed to prevent accidental
 // port allocations on bound port, because without userland proxy we using
 // iptables rules and not net.Listen
@@ -81,20 +201,11 @@ type dummyProxy struct {
 	addr     net.Addr
 }
 
-func newDummyProxy(proto string, hostIP net.IP, hostPort int) (userlandProxy, error) {
-	switch proto {
-	case "tcp":
-		addr := &net.TCPAddr{IP: hostIP, Port: hostPort}
-		return &dummyProxy{addr: addr}, nil
-	case "udp":
-		addr := &net.UDPAddr{IP: hostIP, Port: hostPort}
-		return &dummyProxy{addr: addr}, nil
-	case "sctp":
-		addr := &sctp.SCTPAddr{IP: []net.IP{hostIP}, Port: hostPort}
-		return &dummyProxy{addr: addr}, nil
-	default:
-		return nil, fmt.Errorf("Unknown addr type: %s", proto)
+func newDummyProxy(host net.Addr) (userlandProxy, error) {
+	if protoOf(host) == "" {
+		return nil, fmt.Errorf("Unknown addr type: %T", host)
 	}
+	return &dummyProxy{addr: host}, nil
 }
 
 func (p *dummyProxy) Start() error {
@@ -117,6 +228,12 @@ func (p *dummyProxy) Start() error {
 			return err
 		}
 		p.listener = l
+	case *types.DCCPAddr:
+		l, err := proxy.ListenDCCP(addr)
+		if err != nil {
+			return err
+		}
+		p.listener = l
 	default:
 		return fmt.Errorf("Unknown addr type: %T", p.addr)
 	}
@@ -0,0 +1,45 @@
+package portmapper
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/proxy"
+)
+
+// inProcessProxy relays the traffic of a mapping from goroutines of the
+// process, in place of a docker-proxy process
+type inProcessProxy struct {
+	frontendAddr net.Addr
+	backendAddr  net.Addr
+	opts         []proxy.Option
+	proxy        proxy.Proxy
+}
+
+func newInProcessProxy(host, container net.Addr, proxyProtocol int) (userlandProxy, error) {
+	if protoOf(host) == "" {
+		return nil, fmt.Errorf("Unknown addr type: %T", host)
+	}
+	p := &inProcessProxy{frontendAddr: host, backendAddr: container}
+	if proxyProtocol != 0 {
+		p.opts = append(p.opts, proxy.WithProxyProtocol(proxyProtocol))
+	}
+	return p, nil
+}
+
+func (p *inProcessProxy) Start() error {
+	np, err := proxy.NewProxy(p.frontendAddr, p.backendAddr, p.opts...)
+	if err != nil {
+		return err
+	}
+	p.proxy = np
+	go np.Run()
+	return nil
+}
+
+func (p *inProcessProxy) Stop() error {
+	if p.proxy != nil {
+		p.proxy.Close()
+	}
+	return nil
+}
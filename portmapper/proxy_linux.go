@@ -7,7 +7,7 @@ import (
 	"syscall"
 )
 
-func newProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int, proxyPath string) (userlandProxy, error) {
+func newProxyCommand(host, container net.Addr, proxyPath string, proxyProtocol int) (userlandProxy, error) {
 	path := proxyPath
 	if proxyPath == "" {
 		cmd, err := exec.LookPath(userlandProxyCommandName)
@@ -17,14 +17,19 @@ func newProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.
 		path = cmd
 	}
 
+	_, hostPort := getIPAndPort(host)
+	_, containerPort := getIPAndPort(container)
 	args := []string{
 		path,
-		"-proto", proto,
-		"-host-ip", hostIP.String(),
+		"-proto", protoOf(host),
+		"-host-ip", joinIPs(addrIPs(host)),
 		"-host-port", strconv.Itoa(hostPort),
-		"-container-ip", containerIP.String(),
+		"-container-ip", joinIPs(addrIPs(container)),
 		"-container-port", strconv.Itoa(containerPort),
 	}
+	if proxyProtocol != 0 {
+		args = append(args, "-proxy-protocol", strconv.Itoa(proxyProtocol))
+	}
 
 	return &proxyCommand{
 		cmd: &exec.Cmd{
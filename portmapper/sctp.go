@@ -0,0 +1,90 @@
+package portmapper
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ishidawataru/sctp"
+)
+
+// ErrSCTPHostIPs is returned when the host IP addresses of a multihomed
+// SCTP mapping are not of the same family, or unspecified
+var ErrSCTPHostIPs = errors.New("the host IP addresses of a multihomed sctp mapping must be specified addresses of the same family")
+
+// checkSCTPHostIPs checks the other host IP addresses of a multihomed SCTP
+// mapping on hostIP
+func (pm *PortMapper) checkSCTPHostIPs(proto string, hostIP net.IP, ips []net.IP) error {
+	if len(ips) == 0 {
+		return nil
+	}
+	if proto != "sctp" {
+		return fmt.Errorf("only sctp mappings can be published on several host IP addresses")
+	}
+	seen := map[string]bool{hostIP.String(): true}
+	for _, ip := range ips {
+		if hostIP == nil || hostIP.IsUnspecified() || ip == nil || ip.IsUnspecified() || (ip.To4() == nil) != (hostIP.To4() == nil) {
+			return ErrSCTPHostIPs
+		}
+		if seen[ip.String()] {
+			return fmt.Errorf("duplicate host IP address %s of the sctp mapping", ip)
+		}
+		seen[ip.String()] = true
+	}
+	return nil
+}
+
+// newMultiAddr returns the transport address of proto on the IPs, a
+// multihomed SCTP address with more than one
+func newMultiAddr(proto string, ips []net.IP, port int) net.Addr {
+	if proto == "sctp" && len(ips) > 1 {
+		return &sctp.SCTPAddr{IP: ips, Port: port}
+	}
+	var ip net.IP
+	if len(ips) > 0 {
+		ip = ips[0]
+	}
+	return newAddr(proto, ip, port)
+}
+
+// addrIPs returns the IP addresses of the transport address, all the
+// addresses of a multihomed SCTP address
+func addrIPs(a net.Addr) []net.IP {
+	if t, ok := a.(*sctp.SCTPAddr); ok {
+		return t.IP
+	}
+	if ip, _ := getIPAndPort(a); ip != nil {
+		return []net.IP{ip}
+	}
+	return nil
+}
+
+// joinIPs returns the IP addresses separated by commas
+func joinIPs(ips []net.IP) string {
+	s := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		s = append(s, ip.String())
+	}
+	return strings.Join(s, ",")
+}
+
+// forwardPairs returns the host IP addresses of the mapping and the IP
+// addresses of the container address they are forwarded to: the host
+// address of each rank to the container address of the same rank, the
+// host addresses beyond the container ones to the first container address
+func (m *mapping) forwardPairs(container net.Addr) (hostIPs, containerIPs []net.IP) {
+	hostIPs = addrIPs(m.host)
+	cIPs := addrIPs(container)
+	if len(cIPs) == 0 {
+		return nil, nil
+	}
+	for i := range hostIPs {
+		if i < len(cIPs) {
+			containerIPs = append(containerIPs, cIPs[i])
+		} else {
+			containerIPs = append(containerIPs, cIPs[0])
+		}
+	}
+	return hostIPs, containerIPs
+}
@@ -0,0 +1,68 @@
+package portmapper
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/ip6tables"
+	"github.com/docker/libnetwork/iptables"
+)
+
+// MappingStats are the traffic counters of a port mapping, read from the
+// counters of its iptables and ip6tables rules
+type MappingStats struct {
+	// Connections is the number of connections translated to the
+	// container, counted by the DNAT rule
+	Connections uint64
+	// Packets and Bytes are the traffic forwarded to the container port,
+	// counted by the ACCEPT rule. The rule, and its counters, are shared
+	// by the mappings of a same container port.
+	Packets uint64
+	Bytes   uint64
+}
+
+// Stats returns the traffic counters of the mapping of the host transport
+// address. The traffic relayed by the userland proxy, as from the
+// loopback address, does not go through the rules and is not counted.
+func (pm *PortMapper) Stats(host net.Addr) (MappingStats, error) {
+	pm.lock.Lock()
+	m, exists := pm.currentMappings[getKey(host)]
+	if !exists {
+		pm.lock.Unlock()
+		return MappingStats{}, ErrPortNotMapped
+	}
+	rules, ip6tRules := pm.mappingRules(m)
+	pm.lock.Unlock()
+
+	var stats MappingStats
+	for _, r := range rules {
+		packets, bytes, err := iptables.Counters(r.Table, r.Chain, r.Args...)
+		if err != nil {
+			return MappingStats{}, err
+		}
+		switch r.Table {
+		case iptables.Nat:
+			if r.Chain != "POSTROUTING" {
+				stats.Connections += packets
+			}
+		case iptables.Filter:
+			stats.Packets += packets
+			stats.Bytes += bytes
+		}
+	}
+	for _, r := range ip6tRules {
+		packets, bytes, err := ip6tables.Counters(r.Table, r.Chain, r.Args...)
+		if err != nil {
+			return MappingStats{}, err
+		}
+		switch r.Table {
+		case ip6tables.Nat:
+			if r.Chain != "POSTROUTING" {
+				stats.Connections += packets
+			}
+		case ip6tables.Filter:
+			stats.Packets += packets
+			stats.Bytes += bytes
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,96 @@
+package portmapper
+
+import (
+	"net"
+	"sort"
+
+	"github.com/docker/libnetwork/ip6tables"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/sirupsen/logrus"
+)
+
+// The checks and the programming of the rules, overridden in the tests
+var (
+	ruleExists      = iptables.Exists
+	programRule     = iptables.ProgramRule
+	ip6tRuleExists  = ip6tables.Exists
+	ip6tProgramRule = ip6tables.ProgramRule
+)
+
+// RuleDrift is a rule of a mapping found missing by VerifyMappings
+type RuleDrift struct {
+	// Host is the host address of the mapping
+	Host net.Addr
+	// Rule is the missing rule, as "-t table chain args"
+	Rule string
+	// IPv6 is whether the rule is an ip6tables rule
+	IPv6 bool
+	// Err is the error reinstalling the rule, nil once it is reinstalled
+	Err error
+}
+
+// VerifyMappings checks the iptables and ip6tables rules of the current
+// mappings are programmed, reinstalls the missing ones and returns them,
+// sorted by host address. Unlike ReMapAll, only the missing rules are
+// programmed. The hairpin and rate limit rules are inserted back ahead of
+// the rules of the chain, the other rules are appended.
+func (pm *PortMapper) VerifyMappings() []RuleDrift {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	keys := make([]string, 0, len(pm.currentMappings))
+	for k := range pm.currentMappings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var drift []RuleDrift
+	for _, k := range keys {
+		m := pm.currentMappings[k]
+		rules, ip6tRules := pm.mappingRules(m)
+		hpRules, hpIP6tRules := pm.insertedRules(m)
+		drift = append(drift, verifyRules(m.host, rules, iptables.Append)...)
+		drift = append(drift, verifyRules(m.host, hpRules, iptables.Insert)...)
+		drift = append(drift, verifyIP6tRules(m.host, ip6tRules, ip6tables.Append)...)
+		drift = append(drift, verifyIP6tRules(m.host, hpIP6tRules, ip6tables.Insert)...)
+	}
+	return drift
+}
+
+// verifyRules reinstalls the missing iptables rules of the mapping of host
+// with action
+func verifyRules(host net.Addr, rules []iptables.Rule, action iptables.Action) []RuleDrift {
+	var drift []RuleDrift
+	for _, r := range rules {
+		if ruleExists(r.Table, r.Chain, r.Args...) {
+			continue
+		}
+		err := programRule(r.Table, r.Chain, action, r.Args)
+		if err != nil {
+			logrus.Errorf("Failed to reinstall the missing iptables rule %s of %s: %v", r, host, err)
+		} else {
+			logrus.Warnf("Reinstalled the missing iptables rule %s of %s", r, host)
+		}
+		drift = append(drift, RuleDrift{Host: host, Rule: r.String(), Err: err})
+	}
+	return drift
+}
+
+// verifyIP6tRules reinstalls the missing ip6tables rules of the mapping of
+// host with action
+func verifyIP6tRules(host net.Addr, rules []ip6tables.Rule, action ip6tables.Action) []RuleDrift {
+	var drift []RuleDrift
+	for _, r := range rules {
+		if ip6tRuleExists(r.Table, r.Chain, r.Args...) {
+			continue
+		}
+		err := ip6tProgramRule(r.Table, r.Chain, action, r.Args)
+		if err != nil {
+			logrus.Errorf("Failed to reinstall the missing ip6tables rule %s of %s: %v", r, host, err)
+		} else {
+			logrus.Warnf("Reinstalled the missing ip6tables rule %s of %s", r, host)
+		}
+		drift = append(drift, RuleDrift{Host: host, Rule: r.String(), IPv6: true, Err: err})
+	}
+	return drift
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+// DCCPProxy is a proxy for DCCP connections. It implements the Proxy interface to
+// handle DCCP traffic forwarding between the frontend and backend addresses.
+type DCCPProxy struct {
+	listener     *DCCPListener
+	frontendAddr *types.DCCPAddr
+	backendAddr  *types.DCCPAddr
+}
+
+// NewDCCPProxy creates a new DCCPProxy.
+func NewDCCPProxy(frontendAddr, backendAddr *types.DCCPAddr) (*DCCPProxy, error) {
+	listener, err := ListenDCCP(frontendAddr)
+	if err != nil {
+		return nil, err
+	}
+	// If the port in frontendAddr was 0 then ListenDCCP will have a picked
+	// a port to listen on, hence the call to Addr to get that actual port:
+	return &DCCPProxy{
+		listener:     listener,
+		frontendAddr: listener.Addr().(*types.DCCPAddr),
+		backendAddr:  backendAddr,
+	}, nil
+}
+
+func (proxy *DCCPProxy) clientLoop(client *DCCPConn, quit chan bool) {
+	backend, err := DialDCCP(nil, proxy.backendAddr)
+	if err != nil {
+		logrus.Warnf("Can't forward traffic to backend dccp/%v: %s", proxy.backendAddr, err)
+		client.Close()
+		return
+	}
+
+	// DCCP has no half close, the first end closing closes the connection
+	var wg sync.WaitGroup
+	var broker = func(to, from *DCCPConn) {
+		io.Copy(to, from)
+		from.Close()
+		to.Close()
+		wg.Done()
+	}
+
+	wg.Add(2)
+	go broker(client, backend)
+	go broker(backend, client)
+
+	finish := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finish)
+	}()
+
+	select {
+	case <-quit:
+	case <-finish:
+	}
+	client.Close()
+	backend.Close()
+	<-finish
+}
+
+// Run starts forwarding the traffic using DCCP.
+func (proxy *DCCPProxy) Run() {
+	quit := make(chan bool)
+	defer close(quit)
+	for {
+		client, err := proxy.listener.AcceptDCCP()
+		if err != nil {
+			logrus.Infof("Stopping proxy on dccp/%v for dccp/%v (%s)", proxy.frontendAddr, proxy.backendAddr, err)
+			return
+		}
+		go proxy.clientLoop(client, quit)
+	}
+}
+
+// Close stops forwarding the traffic.
+func (proxy *DCCPProxy) Close() { proxy.listener.Close() }
+
+// FrontendAddr returns the DCCP address on which the proxy is listening.
+func (proxy *DCCPProxy) FrontendAddr() net.Addr { return proxy.frontendAddr }
+
+// BackendAddr returns the DCCP proxied address.
+func (proxy *DCCPProxy) BackendAddr() net.Addr { return proxy.backendAddr }
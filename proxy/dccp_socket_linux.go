@@ -0,0 +1,325 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// DCCPListener is a DCCP socket accepting connections. The net package does
+// not support DCCP, the socket is driven with blocking system calls, as the
+// SCTP sockets.
+type DCCPListener struct {
+	fd   dccpFD
+	addr *types.DCCPAddr
+}
+
+// DCCPConn is a DCCP connection. Only the service code 0 is supported.
+type DCCPConn struct {
+	fd    dccpFD
+	laddr *types.DCCPAddr
+	raddr *types.DCCPAddr
+}
+
+// dccpTimeoutError is the error of the calls past the deadline of the
+// connection
+type dccpTimeoutError struct{}
+
+func (dccpTimeoutError) Error() string   { return "i/o timeout" }
+func (dccpTimeoutError) Timeout() bool   { return true }
+func (dccpTimeoutError) Temporary() bool { return true }
+
+func dccpSockaddr(addr *types.DCCPAddr) (int, syscall.Sockaddr, error) {
+	if addr == nil {
+		return syscall.AF_INET, &syscall.SockaddrInet4{}, nil
+	}
+	if addr.Port < 0 || addr.Port > 0xFFFF {
+		return 0, nil, errors.New("invalid dccp port")
+	}
+	if ip4 := addr.IP.To4(); ip4 != nil || addr.IP == nil {
+		sa := &syscall.SockaddrInet4{Port: addr.Port}
+		copy(sa.Addr[:], ip4)
+		return syscall.AF_INET, sa, nil
+	}
+	if ip6 := addr.IP.To16(); ip6 != nil {
+		sa := &syscall.SockaddrInet6{Port: addr.Port}
+		copy(sa.Addr[:], ip6)
+		return syscall.AF_INET6, sa, nil
+	}
+	return 0, nil, errors.New("invalid dccp address")
+}
+
+func dccpAddr(sa syscall.Sockaddr) *types.DCCPAddr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return &types.DCCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+	case *syscall.SockaddrInet6:
+		return &types.DCCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+	}
+	return nil
+}
+
+// dccpSocket returns a DCCP socket of the family
+func dccpSocket(family int) (int, error) {
+	fd, err := syscall.Socket(family, syscall.SOCK_DCCP|syscall.SOCK_CLOEXEC, syscall.IPPROTO_DCCP)
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	return fd, nil
+}
+
+// dccpFD is the descriptor of a DCCP socket. The calls hold a reference to
+// it for their duration, so that closing the socket while they are pending
+// does not release its number to another socket before they return.
+type dccpFD struct {
+	mu     sync.Mutex
+	sysfd  int
+	refs   int
+	closed bool
+}
+
+// acquire returns the descriptor, to be released once the call using it
+// returns
+func (fd *dccpFD) acquire() (int, error) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if fd.closed {
+		return -1, syscall.EINVAL
+	}
+	fd.refs++
+	return fd.sysfd, nil
+}
+
+// release drops a reference to the descriptor, closing it after the last
+// one when the socket was closed
+func (fd *dccpFD) release() {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	fd.refs--
+	if fd.closed && fd.refs == 0 {
+		syscall.Close(fd.sysfd)
+	}
+}
+
+// close shuts down the socket, once, which unblocks the pending calls on
+// it. The descriptor is closed once they have all returned.
+func (fd *dccpFD) close() error {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if fd.closed {
+		return syscall.EBADF
+	}
+	fd.closed = true
+	syscall.Shutdown(fd.sysfd, syscall.SHUT_RDWR)
+	if fd.refs > 0 {
+		return nil
+	}
+	return os.NewSyscallError("close", syscall.Close(fd.sysfd))
+}
+
+// ListenDCCP listens for DCCP connections on the address. A port 0 picks a
+// free port, returned by the listener Addr.
+func ListenDCCP(addr *types.DCCPAddr) (*DCCPListener, error) {
+	family, sa, err := dccpSockaddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := dccpSocket(family)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := syscall.Listen(fd, syscall.SOMAXCONN); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("listen", err)
+	}
+	lsa, err := syscall.Getsockname(fd)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	return &DCCPListener{fd: dccpFD{sysfd: fd}, addr: dccpAddr(lsa)}, nil
+}
+
+// Accept waits for the next connection
+func (l *DCCPListener) Accept() (net.Conn, error) {
+	return l.AcceptDCCP()
+}
+
+// AcceptDCCP waits for the next connection
+func (l *DCCPListener) AcceptDCCP() (*DCCPConn, error) {
+	fd, err := l.fd.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer l.fd.release()
+	var (
+		nfd int
+		rsa syscall.Sockaddr
+	)
+	for {
+		nfd, rsa, err = syscall.Accept4(fd, syscall.SOCK_CLOEXEC)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		return nil, os.NewSyscallError("accept4", err)
+	}
+	lsa, err := syscall.Getsockname(nfd)
+	if err != nil {
+		syscall.Close(nfd)
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	return &DCCPConn{fd: dccpFD{sysfd: nfd}, laddr: dccpAddr(lsa), raddr: dccpAddr(rsa)}, nil
+}
+
+// Close stops listening, the pending Accept calls return an error
+func (l *DCCPListener) Close() error { return l.fd.close() }
+
+// Addr returns the address the listener is bound to
+func (l *DCCPListener) Addr() net.Addr { return l.addr }
+
+// DialDCCP connects to the DCCP address raddr from the local address laddr,
+// which may be nil
+func DialDCCP(laddr, raddr *types.DCCPAddr) (*DCCPConn, error) {
+	family, rsa, err := dccpSockaddr(raddr)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := dccpSocket(family)
+	if err != nil {
+		return nil, err
+	}
+	if laddr != nil {
+		_, lsa, err := dccpSockaddr(laddr)
+		if err == nil {
+			err = os.NewSyscallError("bind", syscall.Bind(fd, lsa))
+		}
+		if err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+	for {
+		err = syscall.Connect(fd, rsa)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("connect", err)
+	}
+	lsa, err := syscall.Getsockname(fd)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	return &DCCPConn{fd: dccpFD{sysfd: fd}, laddr: dccpAddr(lsa), raddr: raddr}, nil
+}
+
+// Read reads the next packet of the connection into b
+func (c *DCCPConn) Read(b []byte) (int, error) {
+	fd, err := c.fd.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer c.fd.release()
+	for {
+		n, err := syscall.Read(fd, b)
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err == syscall.EAGAIN:
+			return 0, dccpTimeoutError{}
+		case err != nil:
+			return 0, os.NewSyscallError("read", err)
+		case n == 0 && len(b) > 0:
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+}
+
+// Write sends b in a packet of the connection
+func (c *DCCPConn) Write(b []byte) (int, error) {
+	fd, err := c.fd.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer c.fd.release()
+	for {
+		n, err := syscall.Write(fd, b)
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err == syscall.EAGAIN:
+			return 0, dccpTimeoutError{}
+		case err != nil:
+			return 0, os.NewSyscallError("write", err)
+		}
+		return n, nil
+	}
+}
+
+// Close closes the connection
+func (c *DCCPConn) Close() error { return c.fd.close() }
+
+// LocalAddr returns the local address of the connection
+func (c *DCCPConn) LocalAddr() net.Addr { return c.laddr }
+
+// RemoteAddr returns the address of the peer
+func (c *DCCPConn) RemoteAddr() net.Addr { return c.raddr }
+
+// SetDeadline sets the read and write deadlines of the connection
+func (c *DCCPConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the connection
+func (c *DCCPConn) SetReadDeadline(t time.Time) error {
+	return c.setTimeout(syscall.SO_RCVTIMEO, t)
+}
+
+// SetWriteDeadline sets the write deadline of the connection
+func (c *DCCPConn) SetWriteDeadline(t time.Time) error {
+	return c.setTimeout(syscall.SO_SNDTIMEO, t)
+}
+
+// setTimeout sets the timeout of the socket option opt to expire at the
+// deadline t, none for a zero t. The timeout applies to each call, from
+// when it sets it.
+func (c *DCCPConn) setTimeout(opt int, t time.Time) error {
+	fd, err := c.fd.acquire()
+	if err != nil {
+		return err
+	}
+	defer c.fd.release()
+	var tv syscall.Timeval
+	if !t.IsZero() {
+		d := time.Until(t)
+		if d < time.Microsecond {
+			// A zero timeout disables it, the deadline is already past
+			d = time.Microsecond
+		}
+		tv = syscall.NsecToTimeval(d.Nanoseconds())
+	}
+	return os.NewSyscallError("setsockopt", syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, opt, &tv))
+}
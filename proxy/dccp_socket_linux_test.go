@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestDCCPFDCloseWhileInUse(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[1])
+	fd := &dccpFD{sysfd: fds[0]}
+
+	sysfd, err := fd.acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fd.close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fd.acquire(); err != syscall.EINVAL {
+		t.Fatalf("Expected the closed descriptor not to be acquired, got %v", err)
+	}
+	if err := fd.close(); err != syscall.EBADF {
+		t.Fatalf("Expected the second close to fail, got %v", err)
+	}
+
+	// the descriptor is kept open until the pending call releases it
+	if _, err := fcntl(sysfd, syscall.F_GETFD); err != nil {
+		t.Fatalf("Expected the descriptor in use to be open: %v", err)
+	}
+	fd.release()
+	if _, err := fcntl(sysfd, syscall.F_GETFD); err != syscall.EBADF {
+		t.Fatalf("Expected the released descriptor to be closed, got %v", err)
+	}
+}
+
+func fcntl(fd, cmd int) (int, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), uintptr(cmd), 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
@@ -0,0 +1,42 @@
+// +build !linux
+
+package proxy
+
+import (
+	"errors"
+	"net"
+
+	"github.com/docker/libnetwork/types"
+)
+
+var errDCCPUnsupported = errors.New("dccp is not supported on this platform")
+
+// DCCPListener is a DCCP socket accepting connections
+type DCCPListener struct{}
+
+// DCCPConn is a DCCP connection
+type DCCPConn struct {
+	net.Conn
+}
+
+// ListenDCCP listens for DCCP connections on the address
+func ListenDCCP(addr *types.DCCPAddr) (*DCCPListener, error) {
+	return nil, errDCCPUnsupported
+}
+
+// DialDCCP connects to the DCCP address raddr from the local address laddr
+func DialDCCP(laddr, raddr *types.DCCPAddr) (*DCCPConn, error) {
+	return nil, errDCCPUnsupported
+}
+
+// Accept waits for the next connection
+func (l *DCCPListener) Accept() (net.Conn, error) { return nil, errDCCPUnsupported }
+
+// AcceptDCCP waits for the next connection
+func (l *DCCPListener) AcceptDCCP() (*DCCPConn, error) { return nil, errDCCPUnsupported }
+
+// Close stops listening
+func (l *DCCPListener) Close() error { return nil }
+
+// Addr returns the address the listener is bound to
+func (l *DCCPListener) Addr() net.Addr { return nil }
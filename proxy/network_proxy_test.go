@@ -1,4 +1,4 @@
-package main
+package proxy
 
 import (
 	"bytes"
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/docker/libnetwork/types"
 	"github.com/ishidawataru/sctp"
 	// this takes care of the incontainer flag
 	_ "github.com/docker/libnetwork/testutils"
@@ -68,6 +69,16 @@ func NewEchoServer(t *testing.T, proto, address string, opts EchoServerOptions)
 			t.Fatal(err)
 		}
 		server = &StreamEchoServer{listener: listener, testCtx: t}
+	case proto == "dccp":
+		addr, err := net.ResolveTCPAddr("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener, err := ListenDCCP(&types.DCCPAddr{IP: addr.IP, Port: addr.Port})
+		if err != nil {
+			t.Fatal(err)
+		}
+		server = &StreamEchoServer{listener: listener, testCtx: t}
 	default:
 		t.Fatalf("unknown protocol: %s", proto)
 	}
@@ -139,6 +150,13 @@ func testProxyAt(t *testing.T, proto string, proxy Proxy, addr string, halfClose
 			t.Fatal(err)
 		}
 		client, err = sctp.DialSCTP(proto, nil, a)
+	} else if proto == "dccp" {
+		var a *net.TCPAddr
+		a, err = net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err = DialDCCP(nil, &types.DCCPAddr{IP: a.IP, Port: a.Port})
 	} else {
 		client, err = net.Dial(proto, addr)
 	}
@@ -305,3 +323,20 @@ func TestSCTP6Proxy(t *testing.T) {
 	}
 	testProxy(t, "sctp", proxy, false)
 }
+
+func TestDCCP4Proxy(t *testing.T) {
+	l, err := ListenDCCP(&types.DCCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skipf("DCCP is not supported: %v", err)
+	}
+	l.Close()
+	backend := NewEchoServer(t, "dccp", "127.0.0.1:0", EchoServerOptions{})
+	defer backend.Close()
+	backend.Run()
+	frontendAddr := &types.DCCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	proxy, err := NewProxy(frontendAddr, backend.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testProxy(t, "dccp", proxy, false)
+}
@@ -0,0 +1,78 @@
+// Package proxy provides a network Proxy interface and implementations for TCP,
+// UDP, SCTP and DCCP, run by docker-proxy or in the process of the port mapper.
+package proxy
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/ishidawataru/sctp"
+)
+
+// Proxy defines the behavior of a proxy. It forwards traffic back and forth
+// between two endpoints : the frontend and the backend.
+// It can be used to do software port-mapping between two addresses.
+// e.g. forward all traffic between the frontend (host) 127.0.0.1:3000
+// to the backend (container) at 172.17.42.108:4000.
+type Proxy interface {
+	// Run starts forwarding traffic back and forth between the front
+	// and back-end addresses.
+	Run()
+	// Close stops forwarding traffic and close both ends of the Proxy.
+	Close()
+	// FrontendAddr returns the address on which the proxy is listening.
+	FrontendAddr() net.Addr
+	// BackendAddr returns the proxied address.
+	BackendAddr() net.Addr
+}
+
+// Option is an option of the proxy
+type Option func(*options)
+
+type options struct {
+	proxyProtocol int
+}
+
+// WithProxyProtocol makes the proxy send the original source and
+// destination addresses of the connections to the backend, in a PROXY
+// protocol header of the version 1 or 2. Only the TCP proxy supports it.
+func WithProxyProtocol(version int) Option {
+	return func(o *options) {
+		o.proxyProtocol = version
+	}
+}
+
+// NewProxy creates a Proxy according to the specified frontendAddr and backendAddr.
+func NewProxy(frontendAddr, backendAddr net.Addr, opts ...Option) (Proxy, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.proxyProtocol != 0 {
+		if o.proxyProtocol != 1 && o.proxyProtocol != 2 {
+			return nil, fmt.Errorf("invalid PROXY protocol version %d", o.proxyProtocol)
+		}
+		if _, ok := frontendAddr.(*net.TCPAddr); !ok {
+			return nil, fmt.Errorf("the PROXY protocol is only supported by the TCP proxy")
+		}
+	}
+
+	switch frontendAddr.(type) {
+	case *net.UDPAddr:
+		return NewUDPProxy(frontendAddr.(*net.UDPAddr), backendAddr.(*net.UDPAddr))
+	case *net.TCPAddr:
+		p, err := NewTCPProxy(frontendAddr.(*net.TCPAddr), backendAddr.(*net.TCPAddr))
+		if err != nil {
+			return nil, err
+		}
+		p.proxyProtocol = o.proxyProtocol
+		return p, nil
+	case *sctp.SCTPAddr:
+		return NewSCTPProxy(frontendAddr.(*sctp.SCTPAddr), backendAddr.(*sctp.SCTPAddr))
+	case *types.DCCPAddr:
+		return NewDCCPProxy(frontendAddr.(*types.DCCPAddr), backendAddr.(*types.DCCPAddr))
+	default:
+		panic("Unsupported protocol")
+	}
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// proxyProtocolV2Signature starts the PROXY protocol version 2 headers
+var proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// proxyProtocolHeader returns the PROXY protocol header of the version
+// carrying the source and destination addresses of a TCP connection, see
+// https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+func proxyProtocolHeader(version int, src, dst *net.TCPAddr) ([]byte, error) {
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	v4 := srcIP != nil && dstIP != nil
+	if !v4 {
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+	}
+	if srcIP == nil || dstIP == nil {
+		return nil, fmt.Errorf("invalid addresses %v and %v for the PROXY protocol", src, dst)
+	}
+
+	switch version {
+	case 1:
+		family := "TCP6"
+		if v4 {
+			family = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, srcIP, dstIP, src.Port, dst.Port)), nil
+	case 2:
+		var b bytes.Buffer
+		b.Write(proxyProtocolV2Signature)
+		// version 2, PROXY command
+		b.WriteByte(0x21)
+		if v4 {
+			// TCP over IPv4
+			b.WriteByte(0x11)
+			binary.Write(&b, binary.BigEndian, uint16(12))
+		} else {
+			// TCP over IPv6
+			b.WriteByte(0x21)
+			binary.Write(&b, binary.BigEndian, uint16(36))
+		}
+		b.Write(srcIP)
+		b.Write(dstIP)
+		binary.Write(&b, binary.BigEndian, uint16(src.Port))
+		binary.Write(&b, binary.BigEndian, uint16(dst.Port))
+		return b.Bytes(), nil
+	}
+	return nil, fmt.Errorf("invalid PROXY protocol version %d", version)
+}
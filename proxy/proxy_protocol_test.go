@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestProxyProtocolHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.0.10"), Port: 45678}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 8080}
+
+	header, err := proxyProtocolHeader(1, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "PROXY TCP4 192.168.0.10 10.0.0.1 45678 8080\r\n"; string(header) != expected {
+		t.Fatalf("expected %q, got %q", expected, header)
+	}
+
+	header, err = proxyProtocolHeader(2, src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(append([]byte{}, proxyProtocolV2Signature...),
+		0x21, 0x11, 0x00, 0x0C,
+		192, 168, 0, 10,
+		10, 0, 0, 1,
+		0xB2, 0x6E,
+		0x1F, 0x90)
+	if !bytes.Equal(header, expected) {
+		t.Fatalf("expected %x, got %x", expected, header)
+	}
+
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::10"), Port: 45678}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 8080}
+	header, err = proxyProtocolHeader(1, src6, dst6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "PROXY TCP6 2001:db8::10 2001:db8::1 45678 8080\r\n"; string(header) != expected {
+		t.Fatalf("expected %q, got %q", expected, header)
+	}
+	header, err = proxyProtocolHeader(2, src6, dst6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(header) != 16+36 || header[13] != 0x21 || header[15] != 36 {
+		t.Fatalf("unexpected IPv6 header %x", header)
+	}
+
+	if _, err := proxyProtocolHeader(3, src, dst); err == nil {
+		t.Fatal("expected the version 3 to be rejected")
+	}
+}
+
+func TestTCPProxyProtocol(t *testing.T) {
+	backend := NewEchoServer(t, "tcp", "127.0.0.1:0", EchoServerOptions{})
+	defer backend.Close()
+	backend.Run()
+	frontendAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	proxy, err := NewProxy(frontendAddr, backend.LocalAddr(), WithProxyProtocol(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+	go proxy.Run()
+
+	client, err := net.Dial("tcp", proxy.FrontendAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// the echo server returns the header first
+	expected, _ := proxyProtocolHeader(1, client.LocalAddr().(*net.TCPAddr), client.RemoteAddr().(*net.TCPAddr))
+	buf := make([]byte, len(expected))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, expected) {
+		t.Fatalf("expected the header %q, got %q", expected, buf)
+	}
+
+	if _, err := NewProxy(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, backend.LocalAddr(), WithProxyProtocol(2)); err == nil {
+		t.Fatal("expected the PROXY protocol to be rejected for udp")
+	}
+}
@@ -1,10 +1,11 @@
-package main
+package proxy
 
 import (
 	"io"
-	"log"
 	"net"
 	"sync"
+
+	"github.com/sirupsen/logrus"
 )
 
 // TCPProxy is a proxy for TCP connections. It implements the Proxy interface to
@@ -13,6 +14,9 @@ type TCPProxy struct {
 	listener     *net.TCPListener
 	frontendAddr *net.TCPAddr
 	backendAddr  *net.TCPAddr
+	// proxyProtocol is the version of the PROXY protocol header sent to
+	// the backend, none when 0
+	proxyProtocol int
 }
 
 // NewTCPProxy creates a new TCPProxy.
@@ -33,10 +37,22 @@ func NewTCPProxy(frontendAddr, backendAddr *net.TCPAddr) (*TCPProxy, error) {
 func (proxy *TCPProxy) clientLoop(client *net.TCPConn, quit chan bool) {
 	backend, err := net.DialTCP("tcp", nil, proxy.backendAddr)
 	if err != nil {
-		log.Printf("Can't forward traffic to backend tcp/%v: %s\n", proxy.backendAddr, err)
+		logrus.Warnf("Can't forward traffic to backend tcp/%v: %s", proxy.backendAddr, err)
 		client.Close()
 		return
 	}
+	if proxy.proxyProtocol != 0 {
+		header, err := proxyProtocolHeader(proxy.proxyProtocol, client.RemoteAddr().(*net.TCPAddr), client.LocalAddr().(*net.TCPAddr))
+		if err == nil {
+			_, err = backend.Write(header)
+		}
+		if err != nil {
+			logrus.Warnf("Can't send the PROXY protocol header to backend tcp/%v: %s", proxy.backendAddr, err)
+			client.Close()
+			backend.Close()
+			return
+		}
+	}
 
 	var wg sync.WaitGroup
 	var broker = func(to, from *net.TCPConn) {
@@ -72,7 +88,7 @@ func (proxy *TCPProxy) Run() {
 	for {
 		client, err := proxy.listener.Accept()
 		if err != nil {
-			log.Printf("Stopping proxy on tcp/%v for tcp/%v (%s)", proxy.frontendAddr, proxy.backendAddr, err)
+			logrus.Infof("Stopping proxy on tcp/%v for tcp/%v (%s)", proxy.frontendAddr, proxy.backendAddr, err)
 			return
 		}
 		go proxy.clientLoop(client.(*net.TCPConn), quit)
@@ -221,31 +221,29 @@ func GetOptions(resolvConf []byte) []string {
 // for every element in dns, a "search" entry for every element in
 // dnsSearch, and an "options" entry for every element in dnsOptions.
 func Build(path string, dns, dnsSearch, dnsOptions []string) (*File, error) {
+	content := buildContent(dns, dnsSearch, dnsOptions)
+	hash, err := ioutils.HashData(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{Content: content, Hash: hash}, ioutil.WriteFile(path, content, 0644)
+}
+
+func buildContent(dns, dnsSearch, dnsOptions []string) []byte {
 	content := bytes.NewBuffer(nil)
 	if len(dnsSearch) > 0 {
 		if searchString := strings.Join(dnsSearch, " "); strings.Trim(searchString, " ") != "." {
-			if _, err := content.WriteString("search " + searchString + "\n"); err != nil {
-				return nil, err
-			}
+			content.WriteString("search " + searchString + "\n")
 		}
 	}
 	for _, dns := range dns {
-		if _, err := content.WriteString("nameserver " + dns + "\n"); err != nil {
-			return nil, err
-		}
+		content.WriteString("nameserver " + dns + "\n")
 	}
 	if len(dnsOptions) > 0 {
 		if optsString := strings.Join(dnsOptions, " "); strings.Trim(optsString, " ") != "" {
-			if _, err := content.WriteString("options " + optsString + "\n"); err != nil {
-				return nil, err
-			}
+			content.WriteString("options " + optsString + "\n")
 		}
 	}
-
-	hash, err := ioutils.HashData(bytes.NewReader(content.Bytes()))
-	if err != nil {
-		return nil, err
-	}
-
-	return &File{Content: content.Bytes(), Hash: hash}, ioutil.WriteFile(path, content.Bytes(), 0644)
+	return content.Bytes()
 }
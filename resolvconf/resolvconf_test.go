@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/docker/docker/pkg/ioutils"
@@ -306,3 +307,79 @@ func TestFilterResolvDns(t *testing.T) {
 		}
 	}
 }
+
+func TestEffectiveUpstreams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upstreams")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) string {
+		path := dir + "/" + name
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	stub := write("stub.conf", "nameserver 127.0.0.53\nsearch example.com\noptions edns0 trust-ad\n")
+	resolved := write("resolved.conf", "nameserver 10.0.0.1\nnameserver 2001:db8::1\n")
+	local := write("local.conf", "nameserver 127.0.0.1\nnameserver 10.0.0.2\n")
+
+	for _, tc := range []struct {
+		opts        UpstreamOptions
+		source      string
+		nameservers []string
+		loopback    bool
+		content     string
+	}{
+		{
+			opts:        UpstreamOptions{Path: stub, ResolvedPath: resolved},
+			source:      UpstreamsSystemdResolved,
+			nameservers: []string{"10.0.0.1", "2001:db8::1"},
+			content:     "search example.com\nnameserver 10.0.0.1\nnameserver 2001:db8::1\noptions edns0 trust-ad\n",
+		},
+		{
+			opts:        UpstreamOptions{Path: stub, ResolvedPath: dir + "/missing.conf"},
+			source:      UpstreamsResolvConf,
+			nameservers: []string{"127.0.0.53"},
+			loopback:    true,
+		},
+		{
+			opts:        UpstreamOptions{Path: local, ResolvedPath: resolved},
+			source:      UpstreamsResolvConf,
+			nameservers: []string{"127.0.0.1", "10.0.0.2"},
+			loopback:    true,
+		},
+		{
+			opts:        UpstreamOptions{Path: stub, ResolvedPath: resolved, Override: []string{"192.0.2.1"}},
+			source:      UpstreamsOverride,
+			nameservers: []string{"192.0.2.1"},
+			content:     "search example.com\nnameserver 192.0.2.1\noptions edns0 trust-ad\n",
+		},
+		{
+			opts:        UpstreamOptions{Path: dir + "/missing.conf", ResolvedPath: resolved},
+			source:      UpstreamsDefault,
+			nameservers: []string{"8.8.8.8", "8.8.4.4"},
+			content:     "nameserver 8.8.8.8\nnameserver 8.8.4.4\n",
+		},
+	} {
+		u, err := EffectiveUpstreams(tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Source != tc.source || u.HostLoopback != tc.loopback || u.Reason == "" {
+			t.Fatalf("unexpected choice for %+v: %+v", tc.opts, u)
+		}
+		if strings.Join(u.Nameservers, ",") != strings.Join(tc.nameservers, ",") {
+			t.Fatalf("expected nameservers %v for %+v, got %v", tc.nameservers, tc.opts, u.Nameservers)
+		}
+		if tc.content != "" && string(u.File.Content) != tc.content {
+			t.Fatalf("expected content %q for %+v, got %q", tc.content, tc.opts, u.File.Content)
+		}
+	}
+
+	if _, err := EffectiveUpstreams(UpstreamOptions{Path: stub, Override: []string{"not-an-ip"}}); err == nil {
+		t.Fatal("expected an error for an invalid override")
+	}
+}
@@ -0,0 +1,162 @@
+package resolvconf
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/pkg/ioutils"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	// ResolvedStubAddress is the address of the systemd-resolved stub listener
+	ResolvedStubAddress = "127.0.0.53"
+	// ResolvedUpstreamConf is the file where systemd-resolved lists the
+	// upstream nameservers it forwards the queries to
+	ResolvedUpstreamConf = "/run/systemd/resolve/resolv.conf"
+)
+
+// Sources of the upstream nameservers
+const (
+	// UpstreamsOverride is used when the nameservers are set explicitly
+	UpstreamsOverride = "override"
+	// UpstreamsResolvConf is used when the nameservers of the origin file are used as is
+	UpstreamsResolvConf = "resolv.conf"
+	// UpstreamsSystemdResolved is used when the origin file points to the
+	// systemd-resolved stub listener and its upstream nameservers are used instead
+	UpstreamsSystemdResolved = "systemd-resolved"
+	// UpstreamsDefault is used when no nameserver is configured on the host
+	UpstreamsDefault = "default"
+)
+
+// UpstreamOptions are the inputs of the choice of the upstream nameservers
+type UpstreamOptions struct {
+	// Path of the origin resolv.conf. Defaults to DefaultResolvConf
+	Path string
+	// ResolvedPath is the file listing the systemd-resolved upstream
+	// nameservers. Defaults to ResolvedUpstreamConf
+	ResolvedPath string
+	// Override, when not empty, are the nameservers to use regardless of
+	// the host configuration
+	Override []string
+}
+
+// Upstreams reports the upstream nameservers chosen for the containers and why
+type Upstreams struct {
+	// Source is one of the Upstreams* constants
+	Source string
+	// Path of the file the nameservers were read from, if any
+	Path string
+	// Nameservers are the chosen upstream nameservers
+	Nameservers []string
+	// HostLoopback is true when some of the nameservers listen on the host
+	// loopback and can only be reached from the host namespace
+	HostLoopback bool
+	// Reason explains the choice
+	Reason string
+	// File is the origin resolv.conf content with the chosen nameservers,
+	// keeping its search and options entries
+	File *File `json:"-"`
+}
+
+// EffectiveUpstreams chooses the nameservers the container queries are sent
+// to. When the host only uses the systemd-resolved stub listener the real
+// upstream nameservers are read from the systemd-resolved state, as the stub
+// is not reachable from the container namespaces.
+func EffectiveUpstreams(opts UpstreamOptions) (*Upstreams, error) {
+	if opts.Path == "" {
+		opts.Path = DefaultResolvConf
+	}
+	if opts.ResolvedPath == "" {
+		opts.ResolvedPath = ResolvedUpstreamConf
+	}
+
+	origin, err := GetSpecific(opts.Path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		origin = &File{}
+	}
+	search := GetSearchDomains(origin.Content)
+	options := GetOptions(origin.Content)
+	servers := GetNameservers(origin.Content, types.IP)
+
+	u := &Upstreams{Source: UpstreamsResolvConf, Path: opts.Path, Nameservers: servers}
+	switch {
+	case len(opts.Override) > 0:
+		for _, ns := range opts.Override {
+			if net.ParseIP(ns) == nil {
+				return nil, types.BadRequestErrorf("invalid upstream nameserver %q", ns)
+			}
+		}
+		u.Source = UpstreamsOverride
+		u.Path = ""
+		u.Nameservers = append([]string(nil), opts.Override...)
+		u.Reason = "nameservers set explicitly"
+	case len(servers) == 0:
+		u.Source = UpstreamsDefault
+		u.Path = ""
+		u.Nameservers = nil
+		for _, ns := range defaultIPv4Dns {
+			u.Nameservers = append(u.Nameservers, strings.TrimPrefix(ns, "nameserver "))
+		}
+		u.Reason = fmt.Sprintf("no nameserver found in %s", opts.Path)
+	case allLocalhost(servers) && contains(servers, ResolvedStubAddress):
+		if rc, err := GetSpecific(opts.ResolvedPath); err == nil {
+			if resolved := nonLocalhost(GetNameservers(rc.Content, types.IP)); len(resolved) > 0 {
+				u.Source = UpstreamsSystemdResolved
+				u.Path = opts.ResolvedPath
+				u.Nameservers = resolved
+				u.Reason = fmt.Sprintf("%s only lists the systemd-resolved stub listener", opts.Path)
+				if len(search) == 0 {
+					search = GetSearchDomains(rc.Content)
+				}
+				break
+			}
+		}
+		u.HostLoopback = true
+		u.Reason = fmt.Sprintf("no systemd-resolved upstream nameserver found in %s, using the host stub listener", opts.ResolvedPath)
+	default:
+		u.HostLoopback = len(nonLocalhost(servers)) < len(servers)
+		u.Reason = fmt.Sprintf("nameservers listed in %s", opts.Path)
+	}
+
+	if u.Source == UpstreamsResolvConf {
+		u.File = origin
+		return u, nil
+	}
+	content := buildContent(u.Nameservers, search, options)
+	hash, err := ioutils.HashData(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	u.File = &File{Content: content, Hash: hash}
+	return u, nil
+}
+
+func allLocalhost(servers []string) bool {
+	return len(nonLocalhost(servers)) == 0
+}
+
+func nonLocalhost(servers []string) []string {
+	var res []string
+	for _, ns := range servers {
+		if ip := net.ParseIP(strings.Split(ns, "%")[0]); ip == nil || !ip.IsLoopback() {
+			res = append(res, ns)
+		}
+	}
+	return res
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -30,8 +30,25 @@ type Resolver interface {
 	// SetExtServers configures the external nameservers the resolver
 	// should use to forward queries
 	SetExtServers([]extDNSEntry)
+	// SetForwardPolicy configures how queries are forwarded to the
+	// external nameservers
+	SetForwardPolicy(ForwardPolicy)
+	// SetQueryPolicy configures the rate limit and the domain lists
+	// applied to the queries of the sandbox
+	SetQueryPolicy(QueryPolicy)
+	// SetForwardRules configures the nameservers the queries for the
+	// names of specific domains are forwarded to
+	SetForwardRules([]*ForwardRule)
+	// SetMaxUDPResponseSize caps the size of the UDP responses sent to
+	// the EDNS0 clients. Larger responses are truncated
+	SetMaxUDPResponseSize(uint16)
+	// SetQueryLog enables the structured query log, tagging the entries
+	// with the passed source. A nil configuration disables it
+	SetQueryLog(*QueryLogConfig, string) error
 	// ResolverOptions returns resolv.conf options that should be set
 	ResolverOptions() []string
+	// Statistics returns a snapshot of the resolver query counters
+	Statistics() *ResolverStatistics
 }
 
 // DNSBackend represents a backend DNS resolver used for DNS name
@@ -57,6 +74,9 @@ type DNSBackend interface {
 	// HandleQueryResp passes the name & IP from a response to the backend. backend
 	// can use it to maintain any required state about the resolution
 	HandleQueryResp(name string, ip net.IP)
+	// NAT64Prefix returns the prefix AAAA answers are synthesized with for
+	// the names only having IPv4 addresses, nil when DNS64 is disabled
+	NAT64Prefix() *net.IPNet
 }
 
 const (
@@ -69,6 +89,10 @@ const (
 	defaultRespSize = 512
 	maxConcurrent   = 1024
 	logInterval     = 2 * time.Second
+
+	// maxUDPRespSize is the default cap of the UDP responses, whatever the
+	// payload size advertised by the clients
+	maxUDPRespSize = dns.DefaultMsgSize
 )
 
 type extDNSEntry struct {
@@ -82,7 +106,7 @@ type resolver struct {
 	extDNSList    [maxExtDNS]extDNSEntry
 	server        *dns.Server
 	conn          *net.UDPConn
-	tcpServer     *dns.Server
+	tcpServer     *tcpDNSServer
 	tcpListen     *net.TCPListener
 	err           error
 	count         int32
@@ -92,6 +116,14 @@ type resolver struct {
 	proxyDNS      bool
 	resolverKey   string
 	startCh       chan struct{}
+	stats         *resolverStats
+	policy        ForwardPolicy
+	acl           queryACL
+	qlog          *queryLog
+	qlogSource    string
+	upstreams     upstreamHealth
+	rules         []forwardRule
+	maxUDPSize    uint16
 }
 
 func init() {
@@ -107,6 +139,7 @@ func NewResolver(address string, proxyDNS bool, resolverKey string, backend DNSB
 		resolverKey:   resolverKey,
 		err:           fmt.Errorf("setup not done yet"),
 		startCh:       make(chan struct{}, 1),
+		stats:         newResolverStats(),
 	}
 }
 
@@ -154,16 +187,16 @@ func (r *resolver) Start() error {
 		return fmt.Errorf("setting up IP table rules failed: %v", err)
 	}
 
-	s := &dns.Server{Handler: r, PacketConn: r.conn}
+	s := &dns.Server{Handler: r, PacketConn: r.conn, UDPSize: maxUDPRespSize}
 	r.server = s
 	go func() {
 		s.ActivateAndServe()
 	}()
 
-	tcpServer := &dns.Server{Handler: r, Listener: r.tcpListen}
+	tcpServer := newTCPDNSServer(r.tcpListen, r)
 	r.tcpServer = tcpServer
 	go func() {
-		tcpServer.ActivateAndServe()
+		tcpServer.Serve()
 	}()
 	return nil
 }
@@ -196,6 +229,24 @@ func (r *resolver) SetExtServers(extDNS []extDNSEntry) {
 	}
 }
 
+// SetMaxUDPResponseSize caps the size of the UDP responses. Zero restores the
+// default cap
+func (r *resolver) SetMaxUDPResponseSize(size uint16) {
+	r.queryLock.Lock()
+	r.maxUDPSize = size
+	r.queryLock.Unlock()
+}
+
+// udpSizeLimit returns the largest UDP response the resolver sends
+func (r *resolver) udpSizeLimit() int {
+	r.queryLock.Lock()
+	defer r.queryLock.Unlock()
+	if r.maxUDPSize != 0 {
+		return int(r.maxUDPSize)
+	}
+	return maxUDPRespSize
+}
+
 func (r *resolver) NameServer() string {
 	return r.listenAddress
 }
@@ -204,6 +255,10 @@ func (r *resolver) ResolverOptions() []string {
 	return []string{"ndots:0"}
 }
 
+func (r *resolver) Statistics() *ResolverStatistics {
+	return r.stats.snapshot()
+}
+
 func setCommonFlags(msg *dns.Msg) {
 	msg.RecursionAvailable = true
 }
@@ -248,6 +303,9 @@ func (r *resolver) handleIPQuery(name string, query *dns.Msg, ipType int) (*dns.
 	if addr == nil && ipv6Miss {
 		// Send a reply without any Answer sections
 		logrus.Debugf("[resolver] lookup name %s present without IPv6 address", name)
+		if resp := r.internalDNS64(name, query); resp != nil {
+			return resp, nil
+		}
 		resp := createRespMsg(query)
 		return resp, nil
 	}
@@ -282,15 +340,21 @@ func (r *resolver) handleIPQuery(name string, query *dns.Msg, ipType int) (*dns.
 func (r *resolver) handlePTRQuery(ptr string, query *dns.Msg) (*dns.Msg, error) {
 	var parts []string
 
-	if strings.HasSuffix(ptr, ptrIPv4domain) {
-		parts = strings.Split(ptr, ptrIPv4domain)
-	} else if strings.HasSuffix(ptr, ptrIPv6domain) {
-		parts = strings.Split(ptr, ptrIPv6domain)
+	// The nibbles of ip6.arpa names are case insensitive, the records are
+	// stored in lower case
+	name := strings.ToLower(ptr)
+	if strings.HasSuffix(name, ptrIPv4domain) {
+		parts = strings.Split(name, ptrIPv4domain)
+	} else if strings.HasSuffix(name, ptrIPv6domain) {
+		parts = strings.Split(name, ptrIPv6domain)
 	} else {
 		return nil, fmt.Errorf("invalid PTR query, %v", ptr)
 	}
 
 	host := r.backend.ResolveIP(parts[0])
+	if len(host) == 0 {
+		host = r.dns64PTR(name)
+	}
 
 	if len(host) == 0 {
 		return nil, nil
@@ -339,34 +403,76 @@ func (r *resolver) handleSRVQuery(svc string, query *dns.Msg) (*dns.Msg, error)
 
 }
 
+// truncateResp trims the records of resp till it fits in maxSize: answers
+// first, along with the additional records of the SRV answers, then the
+// additional and the authority records. The OPT record is always kept so
+// that EDNS0 clients still know the size they can use.
 func truncateResp(resp *dns.Msg, maxSize int, isTCP bool) {
 	if !isTCP {
 		resp.Truncated = true
 	}
 
+	var opt dns.RR
+	extra := resp.Extra[:0]
+	for _, rr := range resp.Extra {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			opt = rr
+			continue
+		}
+		extra = append(extra, rr)
+	}
+	withOpt := func() {
+		resp.Extra = extra
+		if opt != nil {
+			resp.Extra = append(resp.Extra[:len(extra):len(extra)], opt)
+		}
+	}
+	withOpt()
+
 	srv := resp.Question[0].Qtype == dns.TypeSRV
-	// trim the Answer RRs one by one till the whole message fits
-	// within the reply size
 	for resp.Len() > maxSize {
-		resp.Answer = resp.Answer[:len(resp.Answer)-1]
-
-		if srv && len(resp.Extra) > 0 {
-			resp.Extra = resp.Extra[:len(resp.Extra)-1]
+		switch {
+		case len(resp.Answer) > 0:
+			resp.Answer = resp.Answer[:len(resp.Answer)-1]
+			if srv && len(extra) > 0 {
+				extra = extra[:len(extra)-1]
+			}
+		case len(extra) > 0:
+			extra = extra[:len(extra)-1]
+		case len(resp.Ns) > 0:
+			resp.Ns = resp.Ns[:len(resp.Ns)-1]
+		default:
+			return
 		}
+		withOpt()
 	}
 }
 
 func (r *resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 	var (
-		extConn net.Conn
-		resp    *dns.Msg
-		err     error
+		resp     *dns.Msg
+		err      error
+		upstream string
 	)
 
 	if query == nil || len(query.Question) == 0 {
 		return
 	}
 	name := query.Question[0].Name
+	r.stats.query(query.Question[0].Qtype)
+
+	if l, source := r.queryLog(); l != nil {
+		start := time.Now()
+		defer func() { r.logQuery(l, source, w, query, resp, upstream, start) }()
+	}
+
+	if !r.acl.allow(time.Now()) {
+		r.stats.rateLimit()
+		resp = new(dns.Msg)
+		resp.SetRcode(query, dns.RcodeRefused)
+		w.WriteMsg(resp)
+		return
+	}
 
 	switch query.Question[0].Qtype {
 	case dns.TypeA:
@@ -386,6 +492,18 @@ func (r *resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 		return
 	}
 
+	// Names not owned by the embedded server can be taken over by a
+	// registered name resolver before being forwarded
+	if resp == nil {
+		if nr := lookupNameResolver(name); nr != nil {
+			resp, err = nr.ResolveQuery(query)
+			if err != nil {
+				logrus.Errorf("[resolver] name resolver failed for %q: %v", name, err)
+				resp = nil
+			}
+		}
+	}
+
 	if resp == nil {
 		// If the backend doesn't support proxying dns request
 		// fail the response
@@ -396,6 +514,15 @@ func (r *resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 			return
 		}
 
+		if !r.acl.forwardAllowed(name) {
+			logrus.Debugf("[resolver] forwarding of %q denied by the query policy", name)
+			r.stats.deny()
+			resp = new(dns.Msg)
+			resp.SetRcode(query, dns.RcodeRefused)
+		}
+	}
+
+	if resp == nil {
 		// If the user sets ndots > 0 explicitly and the query is
 		// in the root domain don't forward it out. We will return
 		// failure and let the client retry with the search domain
@@ -419,132 +546,167 @@ func (r *resolver) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
 		if optRR != nil {
 			maxSize = int(optRR.UDPSize())
 		}
+		if limit := r.udpSizeLimit(); maxSize > limit {
+			maxSize = limit
+		}
 		if maxSize < defaultRespSize {
 			maxSize = defaultRespSize
 		}
 	}
 
 	if resp != nil {
+		// Responses to EDNS0 queries carry an OPT record too [RFC 6891 Section-7]
+		if query.IsEdns0() != nil && resp.IsEdns0() == nil && resp.Rcode != dns.RcodeFormatError {
+			resp.SetEdns0(uint16(r.udpSizeLimit()), false)
+		}
 		if resp.Len() > maxSize {
 			truncateResp(resp, maxSize, proto == "tcp")
 		}
+		r.stats.answered(true, resp.Rcode)
 	} else {
-		for i := 0; i < maxExtDNS; i++ {
-			extDNS := &r.extDNSList[i]
-			if extDNS.IPStr == "" {
-				break
-			}
-			extConnect := func() {
-				addr := fmt.Sprintf("%s:%d", extDNS.IPStr, 53)
-				extConn, err = net.DialTimeout(proto, addr, extIOTimeout)
-			}
+		resp, upstream = r.forwardExtDNS(proto, maxSize, query)
+		resp = r.externalDNS64(proto, maxSize, query, resp)
+		if resp == nil {
+			return
+		}
+		// the response may have been fetched over TCP after a truncation
+		if proto == "udp" && resp.Len() > maxSize {
+			truncateResp(resp, maxSize, false)
+		}
+		r.stats.answered(false, resp.Rcode)
+	}
 
-			if extDNS.HostLoopback {
-				extConnect()
-			} else {
-				execErr := r.backend.ExecFunc(extConnect)
-				if execErr != nil {
-					logrus.Warn(execErr)
-					continue
-				}
-			}
-			if err != nil {
-				logrus.Warnf("[resolver] connect failed: %s", err)
-				continue
-			}
-			queryType := dns.TypeToString[query.Question[0].Qtype]
-			logrus.Debugf("[resolver] query %s (%s) from %s, forwarding to %s:%s", name, queryType,
-				extConn.LocalAddr().String(), proto, extDNS.IPStr)
-
-			// Timeout has to be set for every IO operation.
-			extConn.SetDeadline(time.Now().Add(extIOTimeout))
-			co := &dns.Conn{
-				Conn:    extConn,
-				UDPSize: uint16(maxSize),
-			}
-			defer co.Close()
-
-			// limits the number of outstanding concurrent queries.
-			if !r.forwardQueryStart() {
-				old := r.tStamp
-				r.tStamp = time.Now()
-				if r.tStamp.Sub(old) > logInterval {
-					logrus.Errorf("[resolver] more than %v concurrent queries from %s", maxConcurrent, extConn.LocalAddr().String())
-				}
-				continue
-			}
+	if err = w.WriteMsg(resp); err != nil {
+		logrus.Errorf("[resolver] error writing resolver resp, %s", err)
+	}
+}
 
-			err = co.WriteMsg(query)
-			if err != nil {
-				r.forwardQueryEnd()
-				logrus.Debugf("[resolver] send to DNS server failed, %s", err)
-				continue
-			}
+// exchange forwards the query to a single external nameserver. The second
+// return value is true when the response is final and no other nameserver
+// has to be tried.
+func (r *resolver) exchange(proto string, maxSize int, query *dns.Msg, extDNS *extDNSEntry) (*dns.Msg, bool) {
+	var (
+		extConn net.Conn
+		resp    *dns.Msg
+		err     error
+	)
+	name := query.Question[0].Name
 
-			resp, err = co.ReadMsg()
-			// Truncated DNS replies should be sent to the client so that the
-			// client can retry over TCP
-			if err != nil && err != dns.ErrTruncated {
-				r.forwardQueryEnd()
-				logrus.Debugf("[resolver] read from DNS server failed, %s", err)
-				continue
-			}
-			r.forwardQueryEnd()
+	extConnect := func() {
+		addr := net.JoinHostPort(extDNS.IPStr, "53")
+		extConn, err = net.DialTimeout(proto, addr, extIOTimeout)
+	}
 
-			if resp == nil {
-				logrus.Debugf("[resolver] external DNS %s:%s returned empty response for %q", proto, extDNS.IPStr, name)
-				break
-			}
-			switch resp.Rcode {
-			case dns.RcodeServerFailure, dns.RcodeRefused:
-				// Server returned FAILURE: continue with the next external DNS server
-				// Server returned REFUSED: this can be a transitional status, so continue with the next external DNS server
-				logrus.Debugf("[resolver] external DNS %s:%s responded with %s for %q", proto, extDNS.IPStr, statusString(resp.Rcode), name)
-				continue
-			case dns.RcodeNameError:
-				// Server returned NXDOMAIN. Stop resolution if it's an authoritative answer (see RFC 8020: https://tools.ietf.org/html/rfc8020#section-2)
-				logrus.Debugf("[resolver] external DNS %s:%s responded with %s for %q", proto, extDNS.IPStr, statusString(resp.Rcode), name)
-				if resp.Authoritative {
-					break
-				}
-				continue
-			case dns.RcodeSuccess:
-				// All is well
-			default:
-				// Server gave some error. Log the error, and continue with the next external DNS server
-				logrus.Debugf("[resolver] external DNS %s:%s responded with %s (code %d) for %q", proto, extDNS.IPStr, statusString(resp.Rcode), resp.Rcode, name)
-				continue
-			}
-			answers := 0
-			for _, rr := range resp.Answer {
-				h := rr.Header()
-				switch h.Rrtype {
-				case dns.TypeA:
-					answers++
-					ip := rr.(*dns.A).A
-					logrus.Debugf("[resolver] received A record %q for %q from %s:%s", ip, h.Name, proto, extDNS.IPStr)
-					r.backend.HandleQueryResp(h.Name, ip)
-				case dns.TypeAAAA:
-					answers++
-					ip := rr.(*dns.AAAA).AAAA
-					logrus.Debugf("[resolver] received AAAA record %q for %q from %s:%s", ip, h.Name, proto, extDNS.IPStr)
-					r.backend.HandleQueryResp(h.Name, ip)
-				}
-			}
-			if resp.Answer == nil || answers == 0 {
-				logrus.Debugf("[resolver] external DNS %s:%s did not return any %s records for %q", proto, extDNS.IPStr, queryType, name)
-			}
-			resp.Compress = true
-			break
+	if extDNS.HostLoopback {
+		extConnect()
+	} else {
+		execErr := r.backend.ExecFunc(extConnect)
+		if execErr != nil {
+			logrus.Warn(execErr)
+			return nil, false
 		}
-		if resp == nil {
-			return
+	}
+	if err != nil {
+		logrus.Warnf("[resolver] connect failed: %s", err)
+		return nil, false
+	}
+	queryType := dns.TypeToString[query.Question[0].Qtype]
+	logrus.Debugf("[resolver] query %s (%s) from %s, forwarding to %s:%s", name, queryType,
+		extConn.LocalAddr().String(), proto, extDNS.IPStr)
+
+	// Timeout has to be set for every IO operation.
+	extConn.SetDeadline(time.Now().Add(extIOTimeout))
+	co := &dns.Conn{
+		Conn:    extConn,
+		UDPSize: uint16(maxSize),
+	}
+	defer co.Close()
+
+	// limits the number of outstanding concurrent queries.
+	if !r.forwardQueryStart() {
+		r.stats.limiterDrop()
+		r.queryLock.Lock()
+		old := r.tStamp
+		r.tStamp = time.Now()
+		logNow := r.tStamp.Sub(old) > logInterval
+		r.queryLock.Unlock()
+		if logNow {
+			logrus.Errorf("[resolver] more than %v concurrent queries from %s", maxConcurrent, extConn.LocalAddr().String())
 		}
+		return nil, false
 	}
 
-	if err = w.WriteMsg(resp); err != nil {
-		logrus.Errorf("[resolver] error writing resolver resp, %s", err)
+	start := time.Now()
+	err = co.WriteMsg(query)
+	if err != nil {
+		r.forwardQueryEnd()
+		logrus.Debugf("[resolver] send to DNS server failed, %s", err)
+		return nil, false
+	}
+
+	resp, err = co.ReadMsg()
+	// Truncated DNS replies should be sent to the client so that the
+	// client can retry over TCP
+	if err != nil && err != dns.ErrTruncated {
+		r.forwardQueryEnd()
+		logrus.Debugf("[resolver] read from DNS server failed, %s", err)
+		return nil, false
+	}
+	r.forwardQueryEnd()
+	r.stats.upstreamLatency(time.Since(start))
+
+	if resp == nil {
+		logrus.Debugf("[resolver] external DNS %s:%s returned empty response for %q", proto, extDNS.IPStr, name)
+		return nil, true
+	}
+	// Retry truncated answers over TCP, the client gets them truncated again
+	// only if they don't fit in its own UDP payload size
+	if proto == "udp" && resp.Truncated {
+		logrus.Debugf("[resolver] external DNS %s:%s returned a truncated response for %q, retrying over TCP", proto, extDNS.IPStr, name)
+		if tcpResp, done := r.exchange("tcp", dns.MaxMsgSize-1, query, extDNS); tcpResp != nil {
+			return tcpResp, done
+		}
+	}
+	switch resp.Rcode {
+	case dns.RcodeServerFailure, dns.RcodeRefused:
+		// Server returned FAILURE: continue with the next external DNS server
+		// Server returned REFUSED: this can be a transitional status, so continue with the next external DNS server
+		logrus.Debugf("[resolver] external DNS %s:%s responded with %s for %q", proto, extDNS.IPStr, statusString(resp.Rcode), name)
+		return resp, false
+	case dns.RcodeNameError:
+		// Server returned NXDOMAIN. Stop resolution if it's an authoritative answer (see RFC 8020: https://tools.ietf.org/html/rfc8020#section-2)
+		logrus.Debugf("[resolver] external DNS %s:%s responded with %s for %q", proto, extDNS.IPStr, statusString(resp.Rcode), name)
+		if !resp.Authoritative {
+			return resp, false
+		}
+	case dns.RcodeSuccess:
+		// All is well
+	default:
+		// Server gave some error. Log the error, and continue with the next external DNS server
+		logrus.Debugf("[resolver] external DNS %s:%s responded with %s (code %d) for %q", proto, extDNS.IPStr, statusString(resp.Rcode), resp.Rcode, name)
+		return resp, false
+	}
+	answers := 0
+	for _, rr := range resp.Answer {
+		h := rr.Header()
+		switch h.Rrtype {
+		case dns.TypeA:
+			answers++
+			ip := rr.(*dns.A).A
+			logrus.Debugf("[resolver] received A record %q for %q from %s:%s", ip, h.Name, proto, extDNS.IPStr)
+			r.backend.HandleQueryResp(h.Name, ip)
+		case dns.TypeAAAA:
+			answers++
+			ip := rr.(*dns.AAAA).AAAA
+			logrus.Debugf("[resolver] received AAAA record %q for %q from %s:%s", ip, h.Name, proto, extDNS.IPStr)
+			r.backend.HandleQueryResp(h.Name, ip)
+		}
+	}
+	if resp.Answer == nil || answers == 0 {
+		logrus.Debugf("[resolver] external DNS %s:%s did not return any %s records for %q", proto, extDNS.IPStr, queryType, name)
 	}
+	resp.Compress = true
+	return resp, true
 }
 
 func statusString(responseCode int) string {
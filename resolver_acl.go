@@ -0,0 +1,129 @@
+package libnetwork
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+)
+
+// QueryPolicy restricts the queries the containers of a sandbox can send
+// through the embedded DNS server, so that they can't use the host
+// nameservers for amplification or data exfiltration
+type QueryPolicy struct {
+	// RateLimit is the number of queries per second a sandbox can send.
+	// Queries above the limit are refused. Zero disables the limit
+	RateLimit int
+	// Burst is the number of queries accepted above the rate limit in a
+	// short amount of time. Defaults to RateLimit
+	Burst int
+	// AllowDomains, when not empty, are the only domains the names forwarded
+	// to the external nameservers can be part of
+	AllowDomains []string
+	// DenyDomains are the domains the names which are never forwarded to the
+	// external nameservers are part of. Takes precedence over AllowDomains
+	DenyDomains []string
+}
+
+// Validate checks whether the policy is valid
+func (p *QueryPolicy) Validate() error {
+	if p.RateLimit < 0 || p.Burst < 0 {
+		return types.BadRequestErrorf("invalid negative DNS query rate limit")
+	}
+	if p.Burst > 0 && p.RateLimit == 0 {
+		return types.BadRequestErrorf("DNS query burst %d configured without a rate limit", p.Burst)
+	}
+	for _, d := range append(append([]string(nil), p.AllowDomains...), p.DenyDomains...) {
+		if _, ok := dns.IsDomainName(d); !ok || d == "" {
+			return types.BadRequestErrorf("invalid domain %q in DNS query policy", d)
+		}
+	}
+	return nil
+}
+
+// CopyTo deep copies to the destination QueryPolicy
+func (p *QueryPolicy) CopyTo(dstP *QueryPolicy) error {
+	*dstP = *p
+	dstP.AllowDomains = append([]string(nil), p.AllowDomains...)
+	dstP.DenyDomains = append([]string(nil), p.DenyDomains...)
+	return nil
+}
+
+// queryACL enforces the query policy of a resolver. The rate limit is a
+// token bucket refilled at RateLimit tokens per second.
+type queryACL struct {
+	sync.Mutex
+	policy QueryPolicy
+	tokens float64
+	last   time.Time
+}
+
+func (a *queryACL) set(p QueryPolicy) {
+	a.Lock()
+	defer a.Unlock()
+	p.CopyTo(&a.policy)
+	a.tokens = float64(a.burst())
+	a.last = time.Time{}
+}
+
+func (a *queryACL) burst() int {
+	if a.policy.Burst > 0 {
+		return a.policy.Burst
+	}
+	return a.policy.RateLimit
+}
+
+// allow consumes a token for a query, returns false when the query is
+// above the rate limit
+func (a *queryACL) allow(now time.Time) bool {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.policy.RateLimit == 0 {
+		return true
+	}
+	if !a.last.IsZero() {
+		a.tokens += now.Sub(a.last).Seconds() * float64(a.policy.RateLimit)
+		if max := float64(a.burst()); a.tokens > max {
+			a.tokens = max
+		}
+	}
+	a.last = now
+	if a.tokens < 1 {
+		return false
+	}
+	a.tokens--
+	return true
+}
+
+// forwardAllowed checks whether name can be forwarded to the external nameservers
+func (a *queryACL) forwardAllowed(name string) bool {
+	a.Lock()
+	defer a.Unlock()
+
+	if inDomains(name, a.policy.DenyDomains) {
+		return false
+	}
+	return len(a.policy.AllowDomains) == 0 || inDomains(name, a.policy.AllowDomains)
+}
+
+// inDomains checks whether name is part of one of the domains
+func inDomains(name string, domains []string) bool {
+	if len(domains) == 0 {
+		return false
+	}
+	name = dns.Fqdn(strings.ToLower(name))
+	for _, d := range domains {
+		if dns.IsSubDomain(dns.Fqdn(strings.ToLower(d)), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetQueryPolicy sets the policy restricting the queries of the sandbox
+func (r *resolver) SetQueryPolicy(p QueryPolicy) {
+	r.acl.set(p)
+}
@@ -0,0 +1,90 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/sirupsen/logrus"
+)
+
+// resolverPaths2Func are the diagnostic handlers exposing the embedded DNS server state
+var resolverPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/resolverstats": resolverStats2Diag,
+}
+
+// ResolverStatsResult carries the per sandbox and the aggregated embedded DNS
+// server counters
+type ResolverStatsResult struct {
+	Aggregate *ResolverStatistics            `json:"aggregate"`
+	Sandboxes map[string]*ResolverStatistics `json:"sandboxes"`
+}
+
+func (r *ResolverStatsResult) String() string {
+	ids := make([]string, 0, len(r.Sandboxes))
+	for id := range r.Sandboxes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var b strings.Builder
+	b.WriteString("aggregate ")
+	b.WriteString(r.Aggregate.String())
+	for _, id := range ids {
+		fmt.Fprintf(&b, "sandbox %s ", id)
+		b.WriteString(r.Sandboxes[id].String())
+	}
+	return b.String()
+}
+
+// resolverStatistics collects the resolver counters of the sandboxes managed
+// by the controller. When sid is not empty only the matching sandbox is reported.
+func (c *controller) resolverStatistics(sid string) *ResolverStatsResult {
+	res := &ResolverStatsResult{
+		Aggregate: &ResolverStatistics{},
+		Sandboxes: make(map[string]*ResolverStatistics),
+	}
+	for _, s := range c.Sandboxes() {
+		sb := s.(*sandbox)
+		if sid != "" && sb.ID() != sid && sb.ContainerID() != sid {
+			continue
+		}
+		sb.Lock()
+		r := sb.resolver
+		sb.Unlock()
+		if r == nil {
+			continue
+		}
+		st := r.Statistics()
+		res.Sandboxes[sb.ID()] = st
+		res.Aggregate.Add(st)
+	}
+	return res
+}
+
+func resolverStats2Diag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("resolver stats")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	var sid string
+	if len(r.Form["sid"]) > 0 {
+		sid = r.Form["sid"][0]
+	}
+	rsp := c.resolverStatistics(sid)
+	log.Info("resolver stats done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(rsp), json)
+}
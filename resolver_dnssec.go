@@ -0,0 +1,94 @@
+package libnetwork
+
+import "github.com/miekg/dns"
+
+// defaultEDNSBufferSize is the UDP payload size advertised to the external
+// nameservers when EDNS0 is added to the forwarded queries. It avoids IP
+// fragmentation on most paths.
+const defaultEDNSBufferSize = 1232
+
+// prepareForward returns the query to send to the external nameservers and
+// the UDP payload size to read their responses with
+func prepareForward(query *dns.Msg, maxSize int, p *ForwardPolicy) (*dns.Msg, int) {
+	if !p.DNSSEC && !p.RequireAuthenticated && p.EDNSBufferSize == 0 {
+		return query, maxSize
+	}
+	size := p.EDNSBufferSize
+	if size == 0 {
+		size = defaultEDNSBufferSize
+	}
+	if int(size) < maxSize {
+		size = uint16(maxSize)
+	}
+
+	fwd := query.Copy()
+	opt := fwd.IsEdns0()
+	if opt == nil {
+		fwd.SetEdns0(size, false)
+		opt = fwd.IsEdns0()
+	} else if opt.UDPSize() < size {
+		opt.SetUDPSize(size)
+	}
+	if p.DNSSEC || p.RequireAuthenticated {
+		opt.SetDo()
+	}
+	if p.RequireAuthenticated {
+		// asks for the AD bit of the validated responses [RFC 6840 Section-5.7]
+		fwd.AuthenticatedData = true
+	}
+	return fwd, int(size)
+}
+
+// finishForward adapts the response of the external nameservers to what the
+// client asked for: DNSSEC records are only returned to the clients setting
+// the DO bit [RFC 3225 Section-3] and EDNS0 only to the clients using it.
+func finishForward(query, resp *dns.Msg, proto string, maxSize int) *dns.Msg {
+	opt := query.IsEdns0()
+	if opt == nil || !opt.Do() {
+		qtype := query.Question[0].Qtype
+		resp.Answer = stripDNSSEC(resp.Answer, qtype)
+		resp.Ns = stripDNSSEC(resp.Ns, qtype)
+		resp.Extra = stripDNSSEC(resp.Extra, qtype)
+	}
+	if opt == nil {
+		extra := resp.Extra[:0]
+		for _, rr := range resp.Extra {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+		resp.Extra = extra
+	}
+	if proto == "udp" && resp.Len() > maxSize {
+		truncateResp(resp, maxSize, false)
+	}
+	return resp
+}
+
+func stripDNSSEC(rrs []dns.RR, qtype uint16) []dns.RR {
+	res := rrs[:0]
+	for _, rr := range rrs {
+		switch t := rr.Header().Rrtype; t {
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			if t != qtype {
+				continue
+			}
+		}
+		res = append(res, rr)
+	}
+	return res
+}
+
+// authenticated returns whether the response of the external nameservers
+// to the forwarded query is one they validated, with the AD bit set
+// [RFC 4035 Section-3.2.3]. The data of the answers, or the proof of their
+// nonexistence, is authenticated when the nameservers are validating
+// resolvers reached over a trusted path.
+func authenticated(resp *dns.Msg) bool {
+	switch resp.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+		return resp.AuthenticatedData
+	}
+	// the failures carry no data to authenticate
+	return true
+}
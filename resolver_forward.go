@@ -0,0 +1,275 @@
+package libnetwork
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ForwardSequential tries the external nameservers one after the other,
+	// in the configured order. This is the default.
+	ForwardSequential = "sequential"
+	// ForwardParallel sends the query to all the external nameservers at
+	// once and answers with the first usable response
+	ForwardParallel = "parallel"
+	// ForwardFastest tries the external nameservers one after the other,
+	// starting from the one which has been answering the fastest
+	ForwardFastest = "fastest"
+
+	retryBackoff = 100 * time.Millisecond
+	// rttWeight is the weight of the last sample in the moving average of
+	// the upstream response times
+	rttWeight = 0.3
+)
+
+// ForwardPolicy controls how the embedded DNS server forwards the queries it
+// can't answer to the external nameservers
+type ForwardPolicy struct {
+	// Mode is one of ForwardSequential, ForwardParallel or ForwardFastest
+	Mode string
+	// Retries is the number of additional rounds over the nameservers when
+	// none of them answered. Rounds are separated by a jittered backoff
+	Retries int
+	// FailureThreshold is the number of consecutive failures after which a
+	// nameserver is not used anymore for BlacklistDuration. Zero disables
+	// the blacklisting
+	FailureThreshold  int
+	BlacklistDuration time.Duration
+	// DNSSEC sets the DO bit in the forwarded queries, so that the external
+	// nameservers return the DNSSEC records. The records are only passed to
+	// the clients setting the DO bit themselves
+	DNSSEC bool
+	// EDNSBufferSize is the UDP payload size advertised to the external
+	// nameservers. Defaults to 1232 when DNSSEC is enabled
+	EDNSBufferSize uint16
+	// RequireAuthenticated enables DNSSEC and replies with SERVFAIL to the
+	// queries whose response the external nameservers did not validate,
+	// setting the AD bit. The validation is left to the external
+	// nameservers, which are to be validating resolvers reached over a
+	// trusted path. The answers of the unsigned zones are refused as well.
+	RequireAuthenticated bool
+}
+
+// Validate checks whether the policy is valid
+func (p *ForwardPolicy) Validate() error {
+	switch p.Mode {
+	case "", ForwardSequential, ForwardParallel, ForwardFastest:
+	default:
+		return types.BadRequestErrorf("invalid DNS forwarding mode %q", p.Mode)
+	}
+	if p.Retries < 0 || p.FailureThreshold < 0 || p.BlacklistDuration < 0 {
+		return types.BadRequestErrorf("invalid negative value in DNS forwarding policy")
+	}
+	if p.EDNSBufferSize != 0 && p.EDNSBufferSize < defaultRespSize {
+		return types.BadRequestErrorf("invalid EDNS buffer size %d, must be at least %d", p.EDNSBufferSize, defaultRespSize)
+	}
+	if p.FailureThreshold > 0 && p.BlacklistDuration == 0 {
+		return types.BadRequestErrorf("missing blacklist duration for DNS forwarding failure threshold %d", p.FailureThreshold)
+	}
+	return nil
+}
+
+// upstreamScore tracks how an external nameserver has been answering
+type upstreamScore struct {
+	failures         int
+	rtt              time.Duration
+	blacklistedUntil time.Time
+}
+
+// upstreamHealth keeps the scores of the external nameservers of a resolver
+type upstreamHealth struct {
+	sync.Mutex
+	scores map[string]*upstreamScore
+}
+
+func (h *upstreamHealth) score(ip string) *upstreamScore {
+	if h.scores == nil {
+		h.scores = make(map[string]*upstreamScore)
+	}
+	s, ok := h.scores[ip]
+	if !ok {
+		s = &upstreamScore{}
+		h.scores[ip] = s
+	}
+	return s
+}
+
+func (h *upstreamHealth) record(ip string, ok bool, rtt time.Duration, p *ForwardPolicy) {
+	h.Lock()
+	defer h.Unlock()
+
+	s := h.score(ip)
+	if ok {
+		s.failures = 0
+		if s.rtt == 0 {
+			s.rtt = rtt
+		} else {
+			s.rtt = time.Duration(rttWeight*float64(rtt) + (1-rttWeight)*float64(s.rtt))
+		}
+		return
+	}
+	s.failures++
+	if p.FailureThreshold > 0 && s.failures >= p.FailureThreshold {
+		logrus.Debugf("[resolver] external DNS %s failed %d times, not used for %v", ip, s.failures, p.BlacklistDuration)
+		s.blacklistedUntil = time.Now().Add(p.BlacklistDuration)
+		s.failures = 0
+	}
+}
+
+// order returns the external nameservers to try, without the blacklisted
+// ones unless all of them are
+func (h *upstreamHealth) order(servers []*extDNSEntry, p *ForwardPolicy) []*extDNSEntry {
+	h.Lock()
+	defer h.Unlock()
+
+	now := time.Now()
+	var usable []*extDNSEntry
+	for _, s := range servers {
+		if h.score(s.IPStr).blacklistedUntil.Before(now) {
+			usable = append(usable, s)
+		}
+	}
+	if len(usable) == 0 {
+		usable = servers
+	}
+	if p.Mode == ForwardFastest {
+		// nameservers without samples yet come first, so that they get one
+		sort.SliceStable(usable, func(i, j int) bool {
+			return h.score(usable[i].IPStr).rtt < h.score(usable[j].IPStr).rtt
+		})
+	}
+	return usable
+}
+
+// SetForwardPolicy sets the policy used to forward the queries to the
+// external nameservers
+func (r *resolver) SetForwardPolicy(p ForwardPolicy) {
+	r.queryLock.Lock()
+	r.policy = p
+	r.queryLock.Unlock()
+}
+
+func (r *resolver) forwardPolicy() *ForwardPolicy {
+	r.queryLock.Lock()
+	defer r.queryLock.Unlock()
+	p := r.policy
+	return &p
+}
+
+// forwardExtDNS sends the query to the external nameservers according to
+// the forwarding policy, till one of them returns a usable response. The
+// address of the nameserver which answered is returned with the response.
+func (r *resolver) forwardExtDNS(proto string, maxSize int, query *dns.Msg) (*dns.Msg, string) {
+	p := r.forwardPolicy()
+	servers := r.serversFor(query.Question[0].Name)
+	if len(servers) == 0 {
+		return nil, ""
+	}
+	fwd, size := prepareForward(query, maxSize, p)
+
+	var (
+		resp     *dns.Msg
+		upstream string
+	)
+	for attempt := 0; ; attempt++ {
+		var done bool
+		order := r.upstreams.order(servers, p)
+		if p.Mode == ForwardParallel && len(order) > 1 {
+			resp, upstream, done = r.forwardParallel(proto, size, fwd, order, p)
+		} else {
+			resp, upstream, done = r.forwardSequential(proto, size, fwd, order, p)
+		}
+		if done || attempt >= p.Retries {
+			break
+		}
+		backoff := retryBackoff << uint(attempt)
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		logrus.Debugf("[resolver] no usable response for %q, retrying in %v", query.Question[0].Name, backoff)
+		time.Sleep(backoff)
+	}
+	if resp == nil || fwd == query {
+		return resp, upstream
+	}
+
+	// Clients disabling the checking do their own validation
+	if p.RequireAuthenticated && !query.CheckingDisabled && !authenticated(resp) {
+		logrus.Warnf("[resolver] response for %q not authenticated by the external DNS", query.Question[0].Name)
+		resp = new(dns.Msg)
+		resp.SetRcode(query, dns.RcodeServerFailure)
+		return resp, upstream
+	}
+	return finishForward(query, resp, proto, maxSize), upstream
+}
+
+// extServers returns the configured external nameservers
+func (r *resolver) extServers() []*extDNSEntry {
+	var servers []*extDNSEntry
+	for i := 0; i < maxExtDNS; i++ {
+		if r.extDNSList[i].IPStr == "" {
+			break
+		}
+		servers = append(servers, &r.extDNSList[i])
+	}
+	return servers
+}
+
+// exchangeScored forwards the query to a single nameserver, updating its score
+func (r *resolver) exchangeScored(proto string, maxSize int, query *dns.Msg, extDNS *extDNSEntry, p *ForwardPolicy) (*dns.Msg, bool) {
+	start := time.Now()
+	resp, done := r.exchange(proto, maxSize, query, extDNS)
+	ok := resp != nil && resp.Rcode != dns.RcodeServerFailure && resp.Rcode != dns.RcodeRefused
+	r.upstreams.record(extDNS.IPStr, ok, time.Since(start), p)
+	return resp, done
+}
+
+func (r *resolver) forwardSequential(proto string, maxSize int, query *dns.Msg, servers []*extDNSEntry, p *ForwardPolicy) (*dns.Msg, string, bool) {
+	var (
+		last         *dns.Msg
+		lastUpstream string
+	)
+	for _, extDNS := range servers {
+		resp, done := r.exchangeScored(proto, maxSize, query, extDNS, p)
+		if done {
+			return resp, extDNS.IPStr, true
+		}
+		if resp != nil {
+			last, lastUpstream = resp, extDNS.IPStr
+		}
+	}
+	return last, lastUpstream, false
+}
+
+func (r *resolver) forwardParallel(proto string, maxSize int, query *dns.Msg, servers []*extDNSEntry, p *ForwardPolicy) (*dns.Msg, string, bool) {
+	type result struct {
+		resp     *dns.Msg
+		upstream string
+		done     bool
+	}
+	// buffered so that the slower exchanges don't block once an answer was picked
+	ch := make(chan result, len(servers))
+	for _, extDNS := range servers {
+		go func(extDNS *extDNSEntry) {
+			// every exchange packs its own copy of the query
+			resp, done := r.exchangeScored(proto, maxSize, query.Copy(), extDNS, p)
+			ch <- result{resp, extDNS.IPStr, done}
+		}(extDNS)
+	}
+
+	var last result
+	for range servers {
+		res := <-ch
+		if res.done {
+			return res.resp, res.upstream, true
+		}
+		if res.resp != nil {
+			last = res
+		}
+	}
+	return last.resp, last.upstream, false
+}
@@ -0,0 +1,71 @@
+package libnetwork
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+)
+
+// NameResolver is the interface implemented by components taking over the
+// resolution of the names under a domain, like service mesh or corporate
+// split DNS integrations
+type NameResolver interface {
+	// ResolveQuery resolves the passed query. Returning a nil message lets the
+	// embedded DNS server carry on with the resolution, forwarding the query
+	// to the external nameservers.
+	ResolveQuery(query *dns.Msg) (*dns.Msg, error)
+}
+
+var nameResolvers = struct {
+	sync.RWMutex
+	m map[string]NameResolver
+}{m: make(map[string]NameResolver)}
+
+// RegisterNameResolver registers nr as the resolver of the names under domain
+// for all the embedded DNS servers. Names which are not resolved by the
+// embedded server itself are passed to the resolver registered for the
+// longest matching domain, before they get forwarded upstream.
+func RegisterNameResolver(domain string, nr NameResolver) error {
+	if nr == nil {
+		return types.BadRequestErrorf("invalid name resolver for domain %q", domain)
+	}
+	domain = dns.Fqdn(strings.ToLower(domain))
+	if _, ok := dns.IsDomainName(domain); !ok {
+		return types.BadRequestErrorf("invalid domain %q", domain)
+	}
+
+	nameResolvers.Lock()
+	defer nameResolvers.Unlock()
+	if _, ok := nameResolvers.m[domain]; ok {
+		return types.ForbiddenErrorf("a name resolver is already registered for domain %q", domain)
+	}
+	nameResolvers.m[domain] = nr
+	return nil
+}
+
+// UnregisterNameResolver removes the resolver registered for domain
+func UnregisterNameResolver(domain string) {
+	nameResolvers.Lock()
+	delete(nameResolvers.m, dns.Fqdn(strings.ToLower(domain)))
+	nameResolvers.Unlock()
+}
+
+// lookupNameResolver returns the resolver registered for the longest domain
+// name is part of
+func lookupNameResolver(name string) NameResolver {
+	nameResolvers.RLock()
+	defer nameResolvers.RUnlock()
+
+	if len(nameResolvers.m) == 0 {
+		return nil
+	}
+	name = dns.Fqdn(strings.ToLower(name))
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if nr, ok := nameResolvers.m[name[off:]]; ok {
+			return nr
+		}
+	}
+	return nil
+}
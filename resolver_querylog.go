@@ -0,0 +1,217 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultQueryLogMaxSize  = 10 * 1024 * 1024
+	defaultQueryLogMaxFiles = 3
+)
+
+// QueryLogConfig enables the structured log of the queries served by the
+// embedded DNS server. Entries are written as one JSON object per line.
+type QueryLogConfig struct {
+	// Path of the log file. Sandboxes configured with the same path share
+	// the file
+	Path string
+	// MaxSize is the size in bytes after which the file is rotated.
+	// Defaults to 10MB
+	MaxSize int64
+	// MaxFiles is the number of rotated files kept. Defaults to 3
+	MaxFiles int
+}
+
+// Validate checks whether the configuration is valid
+func (c *QueryLogConfig) Validate() error {
+	if !filepath.IsAbs(c.Path) {
+		return types.BadRequestErrorf("DNS query log path %q is not absolute", c.Path)
+	}
+	if c.MaxSize < 0 || c.MaxFiles < 0 {
+		return types.BadRequestErrorf("invalid negative DNS query log rotation setting")
+	}
+	return nil
+}
+
+// QueryLogEntry is a record of the DNS query log
+type QueryLogEntry struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	Client   string    `json:"client"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Result   string    `json:"result"`
+	Answers  int       `json:"answers"`
+	Upstream string    `json:"upstream,omitempty"`
+	Latency  float64   `json:"latency_ms"`
+}
+
+// queryLog is a size rotated log file, shared by the resolvers configured
+// with the same path
+type queryLog struct {
+	sync.Mutex
+	cfg  QueryLogConfig
+	f    *os.File
+	size int64
+	refs int
+}
+
+var queryLogs = struct {
+	sync.Mutex
+	m map[string]*queryLog
+}{m: make(map[string]*queryLog)}
+
+func openQueryLog(cfg QueryLogConfig) (*queryLog, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = defaultQueryLogMaxSize
+	}
+	if cfg.MaxFiles == 0 {
+		cfg.MaxFiles = defaultQueryLogMaxFiles
+	}
+
+	queryLogs.Lock()
+	defer queryLogs.Unlock()
+	if l, ok := queryLogs.m[cfg.Path]; ok {
+		l.refs++
+		return l, nil
+	}
+	l := &queryLog{cfg: cfg, refs: 1}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	queryLogs.m[cfg.Path] = l
+	return l, nil
+}
+
+func (l *queryLog) open() error {
+	if err := os.MkdirAll(filepath.Dir(l.cfg.Path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open DNS query log %s: %v", l.cfg.Path, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+func (l *queryLog) release() {
+	queryLogs.Lock()
+	defer queryLogs.Unlock()
+	if l.refs--; l.refs > 0 {
+		return
+	}
+	delete(queryLogs.m, l.cfg.Path)
+	l.Lock()
+	l.f.Close()
+	l.Unlock()
+}
+
+// rotate shifts the log files: path becomes path.1, path.1 becomes path.2
+// and so on, dropping the oldest one
+func (l *queryLog) rotate() error {
+	l.f.Close()
+	for i := l.cfg.MaxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.cfg.Path, i), fmt.Sprintf("%s.%d", l.cfg.Path, i+1))
+	}
+	if err := os.Rename(l.cfg.Path, l.cfg.Path+".1"); err != nil {
+		logrus.Warnf("Failed to rotate DNS query log %s: %v", l.cfg.Path, err)
+	}
+	return l.open()
+}
+
+func (l *queryLog) write(e *QueryLogEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	l.Lock()
+	defer l.Unlock()
+	if l.f == nil {
+		return
+	}
+	if l.size+int64(len(b)) > l.cfg.MaxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			logrus.Errorf("Failed to reopen DNS query log %s: %v", l.cfg.Path, err)
+			l.f = nil
+			return
+		}
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	if err != nil {
+		logrus.Debugf("Failed to write DNS query log %s: %v", l.cfg.Path, err)
+	}
+}
+
+// SetQueryLog enables the query log of the resolver, tagging the entries
+// with source. A nil configuration disables it.
+func (r *resolver) SetQueryLog(cfg *QueryLogConfig, source string) error {
+	var l *queryLog
+	if cfg != nil {
+		var err error
+		if l, err = openQueryLog(*cfg); err != nil {
+			return err
+		}
+	}
+
+	r.queryLock.Lock()
+	old := r.qlog
+	r.qlog = l
+	r.qlogSource = source
+	r.queryLock.Unlock()
+
+	if old != nil {
+		old.release()
+	}
+	return nil
+}
+
+func (r *resolver) queryLog() (*queryLog, string) {
+	r.queryLock.Lock()
+	defer r.queryLock.Unlock()
+	return r.qlog, r.qlogSource
+}
+
+func (r *resolver) logQuery(l *queryLog, source string, w dns.ResponseWriter, query, resp *dns.Msg, upstream string, start time.Time) {
+	e := &QueryLogEntry{
+		Time:     start.UTC(),
+		Source:   source,
+		Name:     query.Question[0].Name,
+		Type:     dnsTypeString(query.Question[0].Qtype),
+		Result:   "DROPPED",
+		Upstream: upstream,
+		Latency:  float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if addr := w.RemoteAddr(); addr != nil {
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			e.Client = host
+		}
+	}
+	if resp != nil {
+		e.Result = statusString(resp.Rcode)
+		e.Answers = len(resp.Answer)
+	}
+	l.write(e)
+}
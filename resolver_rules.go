@@ -0,0 +1,88 @@
+package libnetwork
+
+import (
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/miekg/dns"
+)
+
+// ForwardRule sends the queries for the names of a domain to dedicated
+// nameservers, in place of the external nameservers of the sandbox
+type ForwardRule struct {
+	// Domain the rule applies to, including its subdomains. A leading
+	// "*." is accepted: "*.corp.example" is the same as "corp.example"
+	Domain string
+	// Servers are the addresses of the nameservers of the domain
+	Servers []string
+}
+
+// Validate checks whether the rule is valid
+func (fr *ForwardRule) Validate() error {
+	if _, ok := dns.IsDomainName(fr.Domain); !ok || fr.zone() == "." {
+		return types.BadRequestErrorf("invalid domain %q in DNS forwarding rule", fr.Domain)
+	}
+	if len(fr.Servers) == 0 {
+		return types.BadRequestErrorf("missing nameservers in DNS forwarding rule for %s", fr.Domain)
+	}
+	if len(fr.Servers) > maxExtDNS {
+		return types.BadRequestErrorf("too many nameservers in DNS forwarding rule for %s, at most %d are supported", fr.Domain, maxExtDNS)
+	}
+	for _, s := range fr.Servers {
+		if net.ParseIP(s) == nil {
+			return types.BadRequestErrorf("invalid nameserver address %s in DNS forwarding rule for %s", s, fr.Domain)
+		}
+	}
+	return nil
+}
+
+// zone returns the fully qualified, lower case domain of the rule
+func (fr *ForwardRule) zone() string {
+	return dns.Fqdn(strings.ToLower(strings.TrimPrefix(fr.Domain, "*.")))
+}
+
+// forwardRule is a ForwardRule as used by the resolver
+type forwardRule struct {
+	zone    string
+	servers []*extDNSEntry
+}
+
+// SetForwardRules sets the per domain nameservers of the resolver, replacing
+// the previous ones
+func (r *resolver) SetForwardRules(rules []*ForwardRule) {
+	var fr []forwardRule
+	for _, rule := range rules {
+		f := forwardRule{zone: rule.zone()}
+		for _, s := range rule.Servers {
+			f.servers = append(f.servers, &extDNSEntry{IPStr: s})
+		}
+		fr = append(fr, f)
+	}
+
+	r.queryLock.Lock()
+	r.rules = fr
+	r.queryLock.Unlock()
+}
+
+// serversFor returns the nameservers to forward the queries for name to:
+// the ones of the most specific rule matching it, or the external
+// nameservers of the sandbox when no rule does
+func (r *resolver) serversFor(name string) []*extDNSEntry {
+	name = dns.Fqdn(strings.ToLower(name))
+
+	r.queryLock.Lock()
+	var best *forwardRule
+	for i := range r.rules {
+		fr := &r.rules[i]
+		if dns.IsSubDomain(fr.zone, name) && (best == nil || len(fr.zone) > len(best.zone)) {
+			best = fr
+		}
+	}
+	r.queryLock.Unlock()
+
+	if best != nil {
+		return best.servers
+	}
+	return r.extServers()
+}
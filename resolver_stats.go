@@ -0,0 +1,242 @@
+package libnetwork
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/libnetwork/metrics"
+	"github.com/miekg/dns"
+)
+
+// upstreamLatencyBuckets are the upper bounds of the upstream latency
+// histogram. Queries slower than the last bucket are counted in an
+// additional overflow bucket.
+var upstreamLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	extIOTimeout,
+}
+
+var (
+	dnsQueriesCounter = metrics.NewCounter("resolver", "queries_total", "DNS queries received by the embedded DNS servers", "type")
+	dnsAnswersCounter = metrics.NewCounter("resolver", "answers_total", "DNS answers of the embedded DNS servers, internal or forwarded", "source", "rcode")
+	dnsDropsCounter   = metrics.NewCounter("resolver", "drops_total", "DNS queries dropped or refused by the embedded DNS servers", "reason")
+	dnsLatencyHisto   = metrics.NewHistogram("resolver", "upstream_latency_seconds", "Latency of the queries forwarded to the upstream servers", durationBuckets(upstreamLatencyBuckets))
+)
+
+func durationBuckets(ds []time.Duration) []float64 {
+	b := make([]float64, len(ds))
+	for i, d := range ds {
+		b[i] = d.Seconds()
+	}
+	return b
+}
+
+// LatencyBucket is a single bucket of the upstream latency histogram.
+// A zero UpperBound marks the overflow bucket.
+type LatencyBucket struct {
+	UpperBound time.Duration `json:"le"`
+	Count      uint64        `json:"count"`
+}
+
+// ResolverStatistics is a point in time snapshot of the counters kept
+// by an embedded DNS server
+type ResolverStatistics struct {
+	Queries         map[string]uint64 `json:"queries"`
+	Internal        uint64            `json:"internal"`
+	Forwarded       uint64            `json:"forwarded"`
+	NXDomain        uint64            `json:"nxdomain"`
+	LimiterDrops    uint64            `json:"limiter_drops"`
+	RateLimited     uint64            `json:"rate_limited"`
+	Denied          uint64            `json:"denied"`
+	UpstreamLatency []LatencyBucket   `json:"upstream_latency"`
+}
+
+// Total returns the number of queries received, of all types
+func (s *ResolverStatistics) Total() uint64 {
+	var total uint64
+	for _, c := range s.Queries {
+		total += c
+	}
+	return total
+}
+
+// NXDomainRate returns the fraction of the received queries answered
+// with NXDOMAIN
+func (s *ResolverStatistics) NXDomainRate() float64 {
+	total := s.Total()
+	if total == 0 {
+		return 0
+	}
+	return float64(s.NXDomain) / float64(total)
+}
+
+// Add accumulates the counters of o into s
+func (s *ResolverStatistics) Add(o *ResolverStatistics) {
+	if s.Queries == nil {
+		s.Queries = make(map[string]uint64)
+	}
+	for t, c := range o.Queries {
+		s.Queries[t] += c
+	}
+	s.Internal += o.Internal
+	s.Forwarded += o.Forwarded
+	s.NXDomain += o.NXDomain
+	s.LimiterDrops += o.LimiterDrops
+	s.RateLimited += o.RateLimited
+	s.Denied += o.Denied
+	if s.UpstreamLatency == nil {
+		s.UpstreamLatency = newLatencyHistogram()
+	}
+	for i := range o.UpstreamLatency {
+		if i < len(s.UpstreamLatency) {
+			s.UpstreamLatency[i].Count += o.UpstreamLatency[i].Count
+		}
+	}
+}
+
+func (s *ResolverStatistics) String() string {
+	qtypes := make([]string, 0, len(s.Queries))
+	for t := range s.Queries {
+		qtypes = append(qtypes, t)
+	}
+	sort.Strings(qtypes)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "queries: %d, internal: %d, forwarded: %d, nxdomain: %d (%.2f%%), limiter drops: %d, rate limited: %d, denied: %d\n",
+		s.Total(), s.Internal, s.Forwarded, s.NXDomain, 100*s.NXDomainRate(), s.LimiterDrops, s.RateLimited, s.Denied)
+	for _, t := range qtypes {
+		fmt.Fprintf(&b, "  %s: %d\n", t, s.Queries[t])
+	}
+	b.WriteString("upstream latency:\n")
+	for _, l := range s.UpstreamLatency {
+		if l.UpperBound == 0 {
+			fmt.Fprintf(&b, "  > %s: %d\n", upstreamLatencyBuckets[len(upstreamLatencyBuckets)-1], l.Count)
+			continue
+		}
+		fmt.Fprintf(&b, "  <= %s: %d\n", l.UpperBound, l.Count)
+	}
+	return b.String()
+}
+
+func newLatencyHistogram() []LatencyBucket {
+	h := make([]LatencyBucket, len(upstreamLatencyBuckets)+1)
+	for i, ub := range upstreamLatencyBuckets {
+		h[i].UpperBound = ub
+	}
+	return h
+}
+
+// resolverStats holds the live counters of a resolver
+type resolverStats struct {
+	queries      map[uint16]uint64
+	internal     uint64
+	forwarded    uint64
+	nxdomain     uint64
+	limiterDrops uint64
+	rateLimited  uint64
+	denied       uint64
+	latency      []uint64
+	sync.Mutex
+}
+
+func newResolverStats() *resolverStats {
+	return &resolverStats{
+		queries: make(map[uint16]uint64),
+		latency: make([]uint64, len(upstreamLatencyBuckets)+1),
+	}
+}
+
+func (s *resolverStats) query(qtype uint16) {
+	s.Lock()
+	s.queries[qtype]++
+	s.Unlock()
+	dnsQueriesCounter.Inc(dnsTypeString(qtype))
+}
+
+func (s *resolverStats) answered(internal bool, rcode int) {
+	s.Lock()
+	if internal {
+		s.internal++
+	} else {
+		s.forwarded++
+	}
+	if rcode == dns.RcodeNameError {
+		s.nxdomain++
+	}
+	s.Unlock()
+	source := "forwarded"
+	if internal {
+		source = "internal"
+	}
+	dnsAnswersCounter.Inc(source, dns.RcodeToString[rcode])
+}
+
+func (s *resolverStats) limiterDrop() {
+	s.Lock()
+	s.limiterDrops++
+	s.Unlock()
+	dnsDropsCounter.Inc("limiter")
+}
+
+func (s *resolverStats) rateLimit() {
+	s.Lock()
+	s.rateLimited++
+	s.Unlock()
+	dnsDropsCounter.Inc("rate_limit")
+}
+
+func (s *resolverStats) deny() {
+	s.Lock()
+	s.denied++
+	s.Unlock()
+	dnsDropsCounter.Inc("denied")
+}
+
+func (s *resolverStats) upstreamLatency(d time.Duration) {
+	i := sort.Search(len(upstreamLatencyBuckets), func(i int) bool {
+		return d <= upstreamLatencyBuckets[i]
+	})
+	s.Lock()
+	s.latency[i]++
+	s.Unlock()
+	dnsLatencyHisto.Observe(d.Seconds())
+}
+
+func (s *resolverStats) snapshot() *ResolverStatistics {
+	s.Lock()
+	defer s.Unlock()
+
+	st := &ResolverStatistics{
+		Queries:         make(map[string]uint64, len(s.queries)),
+		Internal:        s.internal,
+		Forwarded:       s.forwarded,
+		NXDomain:        s.nxdomain,
+		LimiterDrops:    s.limiterDrops,
+		RateLimited:     s.rateLimited,
+		Denied:          s.denied,
+		UpstreamLatency: newLatencyHistogram(),
+	}
+	for t, c := range s.queries {
+		st.Queries[dnsTypeString(t)] = c
+	}
+	for i, c := range s.latency {
+		st.UpstreamLatency[i].Count = c
+	}
+	return st
+}
+
+func dnsTypeString(qtype uint16) string {
+	if s, ok := dns.TypeToString[qtype]; ok {
+		return s
+	}
+	return fmt.Sprintf("TYPE%d", qtype)
+}
@@ -0,0 +1,203 @@
+package libnetwork
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	tcpReadTimeout  = 2 * time.Second
+	tcpWriteTimeout = 2 * time.Second
+	// tcpIdleTimeout is how long a connection is kept open without
+	// queries once its first query was received [RFC 7766 Section-6.2.3]
+	tcpIdleTimeout = 10 * time.Second
+	// maxTCPPipelined is the number of queries of a single connection
+	// served concurrently. Reading stops till a response is written.
+	maxTCPPipelined = 32
+)
+
+// tcpDNSServer serves DNS over TCP. Unlike dns.Server, the queries pipelined
+// on a connection are served concurrently and their responses written as
+// soon as they are ready, possibly out of order [RFC 7766 Section-6.2.1.1],
+// so that a slow upstream doesn't hold up the other queries of the client.
+type tcpDNSServer struct {
+	listener net.Listener
+	handler  dns.Handler
+	wg       sync.WaitGroup
+	sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func newTCPDNSServer(l net.Listener, handler dns.Handler) *tcpDNSServer {
+	return &tcpDNSServer{
+		listener: l,
+		handler:  handler,
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts the connections till the server is shut down
+func (s *tcpDNSServer) Serve() error {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			s.Lock()
+			closed := s.closed
+			s.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.Lock()
+		if s.closed {
+			s.Unlock()
+			c.Close()
+			return nil
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.Unlock()
+		go s.serveConn(c)
+	}
+}
+
+// Shutdown closes the listener and the open connections, and waits for the
+// queries being served to complete
+func (s *tcpDNSServer) Shutdown() error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.listener.Close()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *tcpDNSServer) serveConn(c net.Conn) {
+	w := &tcpWriter{conn: c}
+	var inflight sync.WaitGroup
+	sem := make(chan struct{}, maxTCPPipelined)
+	defer func() {
+		inflight.Wait()
+		c.Close()
+		s.Lock()
+		delete(s.conns, c)
+		s.Unlock()
+		s.wg.Done()
+	}()
+
+	timeout := tcpReadTimeout
+	for {
+		sem <- struct{}{}
+		c.SetReadDeadline(time.Now().Add(timeout))
+		buf, err := readTCPMsg(c)
+		if err != nil {
+			if err != io.EOF {
+				logrus.Debugf("[resolver] closing TCP connection from %s: %v", c.RemoteAddr(), err)
+			}
+			return
+		}
+		timeout = tcpIdleTimeout
+
+		query := new(dns.Msg)
+		if err := query.Unpack(buf); err != nil {
+			<-sem
+			resp := new(dns.Msg)
+			resp.SetRcodeFormatError(query)
+			w.WriteMsg(resp)
+			continue
+		}
+		if query.Response {
+			<-sem
+			continue
+		}
+		inflight.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				inflight.Done()
+			}()
+			s.handler.ServeDNS(w, query)
+		}()
+	}
+}
+
+// readTCPMsg reads a length prefixed DNS message
+func readTCPMsg(c net.Conn) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(c, l[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint16(l[:])
+	if n == 0 {
+		return nil, dns.ErrShortRead
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// tcpWriter writes the responses of the queries pipelined on a connection.
+// Writes are serialized so that responses don't interleave.
+type tcpWriter struct {
+	sync.Mutex
+	conn net.Conn
+}
+
+func (w *tcpWriter) LocalAddr() net.Addr  { return w.conn.LocalAddr() }
+func (w *tcpWriter) RemoteAddr() net.Addr { return w.conn.RemoteAddr() }
+
+func (w *tcpWriter) WriteMsg(m *dns.Msg) error {
+	data, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (w *tcpWriter) Write(data []byte) (int, error) {
+	if len(data) > dns.MaxMsgSize {
+		return 0, dns.ErrBuf
+	}
+	msg := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(msg, uint16(len(data)))
+	copy(msg[2:], data)
+
+	w.Lock()
+	defer w.Unlock()
+	w.conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+	n, err := w.conn.Write(msg)
+	if n > 2 {
+		n -= 2
+	} else {
+		n = 0
+	}
+	return n, err
+}
+
+// Close is a no-op: the connection is closed by the server once the client
+// stops sending queries
+func (w *tcpWriter) Close() error        { return nil }
+func (w *tcpWriter) TsigStatus() error   { return nil }
+func (w *tcpWriter) TsigTimersOnly(bool) {}
+func (w *tcpWriter) Hijack()             {}
@@ -2,11 +2,16 @@ package libnetwork
 
 import (
 	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"syscall"
 	"testing"
 	"time"
 
+	"github.com/docker/libnetwork/types"
 	"github.com/miekg/dns"
 )
 
@@ -268,3 +273,525 @@ func TestDNSProxyServFail(t *testing.T) {
 	}
 	t.Logf("Expected number of DNS requests generated")
 }
+
+// a simple DNSBackend for unit testing which resolves names from a static map
+type tstbackend struct {
+	names map[string][]net.IP
+	ptrs  map[string]string
+	nat64 *net.IPNet
+	ndots bool
+}
+
+func (b *tstbackend) ResolveName(name string, iplen int) ([]net.IP, bool) {
+	ips, ok := b.names[name]
+	if !ok {
+		return nil, false
+	}
+	var res []net.IP
+	for _, ip := range ips {
+		if (iplen == types.IPv4) == (ip.To4() != nil) {
+			res = append(res, ip)
+		}
+	}
+	return res, res == nil
+}
+
+func (b *tstbackend) ResolveIP(name string) string { return b.ptrs[name] }
+
+func (b *tstbackend) ResolveService(name string) ([]*net.SRV, []net.IP) { return nil, nil }
+
+func (b *tstbackend) ExecFunc(f func()) error { f(); return nil }
+
+func (b *tstbackend) NdotsSet() bool { return b.ndots }
+
+func (b *tstbackend) HandleQueryResp(name string, ip net.IP) {}
+
+func (b *tstbackend) NAT64Prefix() *net.IPNet { return b.nat64 }
+
+func TestResolverStatistics(t *testing.T) {
+	b := &tstbackend{names: map[string][]net.IP{"name1.": {net.ParseIP("192.168.0.1")}}}
+	r := NewResolver(resolverIPSandbox, false, "", b)
+
+	w := new(tstwriter)
+	for _, qt := range []uint16{dns.TypeA, dns.TypeA, dns.TypeAAAA} {
+		q := new(dns.Msg)
+		q.SetQuestion("name1.", qt)
+		r.(*resolver).ServeDNS(w, q)
+		checkNonNullResponse(t, w.GetResponse())
+		w.ClearResponse()
+	}
+
+	st := r.Statistics()
+	if st.Total() != 3 {
+		t.Fatalf("Expected 3 queries. Found: %d", st.Total())
+	}
+	if st.Queries["A"] != 2 || st.Queries["AAAA"] != 1 {
+		t.Fatalf("Unexpected per type counters: %v", st.Queries)
+	}
+	if st.Internal != 3 || st.Forwarded != 0 {
+		t.Fatalf("Expected 3 internal and 0 forwarded answers. Found: %d, %d", st.Internal, st.Forwarded)
+	}
+	if len(st.UpstreamLatency) != len(upstreamLatencyBuckets)+1 {
+		t.Fatalf("Unexpected number of latency buckets: %d", len(st.UpstreamLatency))
+	}
+
+	agg := &ResolverStatistics{}
+	agg.Add(st)
+	agg.Add(st)
+	if agg.Total() != 6 || agg.Internal != 6 {
+		t.Fatalf("Unexpected aggregated counters: %s", agg)
+	}
+}
+
+type tstnameresolver struct {
+	ip net.IP
+}
+
+func (nr *tstnameresolver) ResolveQuery(query *dns.Msg) (*dns.Msg, error) {
+	if query.Question[0].Qtype != dns.TypeA {
+		return nil, nil
+	}
+	resp := createRespMsg(query)
+	rr := new(dns.A)
+	rr.Hdr = dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: respTTL}
+	rr.A = nr.ip
+	resp.Answer = append(resp.Answer, rr)
+	return resp, nil
+}
+
+func TestResolverNameResolverHook(t *testing.T) {
+	if err := RegisterNameResolver("corp.example", &tstnameresolver{ip: net.ParseIP("10.1.1.10")}); err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterNameResolver("corp.example")
+
+	if err := RegisterNameResolver("corp.example.", &tstnameresolver{}); err == nil {
+		t.Fatal("Expected failure registering a second resolver for the same domain")
+	}
+
+	r := NewResolver(resolverIPSandbox, false, "", &tstbackend{})
+	w := new(tstwriter)
+
+	q := new(dns.Msg)
+	q.SetQuestion("host.Corp.Example.", dns.TypeA)
+	r.(*resolver).ServeDNS(w, q)
+	resp := w.GetResponse()
+	checkNonNullResponse(t, resp)
+	checkDNSResponseCode(t, resp, dns.RcodeSuccess)
+	checkDNSAnswersCount(t, resp, 1)
+	if answer, ok := resp.Answer[0].(*dns.A); !ok || !answer.A.Equal(net.ParseIP("10.1.1.10")) {
+		t.Fatalf("Unexpected answer %v", resp.Answer[0])
+	}
+	w.ClearResponse()
+
+	// names outside the domain are not passed to the hook
+	q = new(dns.Msg)
+	q.SetQuestion("host.example.", dns.TypeA)
+	r.(*resolver).ServeDNS(w, q)
+	resp = w.GetResponse()
+	checkNonNullResponse(t, resp)
+	checkDNSResponseCode(t, resp, dns.RcodeServerFailure)
+}
+
+func TestDNS64Embed(t *testing.T) {
+	// examples from RFC 6052 Section-2.4
+	ip := net.ParseIP("192.0.2.33")
+	for prefix, expected := range map[string]string{
+		"2001:db8::/32":         "2001:db8:c000:221::",
+		"2001:db8:100::/40":     "2001:db8:1c0:2:21::",
+		"2001:db8:122::/48":     "2001:db8:122:c000:2:2100::",
+		"2001:db8:122:300::/56": "2001:db8:122:3c0:0:221::",
+		"2001:db8:122:344::/64": "2001:db8:122:344:c0:2:2100:0",
+		"2001:db8:122:344::/96": "2001:db8:122:344::c000:221",
+	} {
+		pfx, err := validateNAT64Prefix(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip6 := dns64Embed(pfx, ip)
+		if !ip6.Equal(net.ParseIP(expected)) {
+			t.Fatalf("Unexpected address for prefix %s: %s, expected %s", prefix, ip6, expected)
+		}
+		if ip4 := dns64Extract(pfx, ip6); !ip4.Equal(ip) {
+			t.Fatalf("Unexpected address extracted from %s: %s", ip6, ip4)
+		}
+	}
+}
+
+func TestResolverDNS64(t *testing.T) {
+	b := &tstbackend{
+		names: map[string][]net.IP{
+			"name1.": {net.ParseIP("192.0.2.33")},
+			"name2.": {net.ParseIP("127.0.0.1")},
+		},
+		ptrs:  map[string]string{"33.2.0.192": "name1"},
+		nat64: dns64WellKnownPrefix,
+	}
+	r := NewResolver(resolverIPSandbox, false, "", b)
+	w := new(tstwriter)
+
+	q := new(dns.Msg)
+	q.SetQuestion("name1.", dns.TypeAAAA)
+	r.(*resolver).ServeDNS(w, q)
+	resp := w.GetResponse()
+	checkNonNullResponse(t, resp)
+	checkDNSResponseCode(t, resp, dns.RcodeSuccess)
+	checkDNSAnswersCount(t, resp, 1)
+	if answer, ok := resp.Answer[0].(*dns.AAAA); !ok || !answer.AAAA.Equal(net.ParseIP("64:ff9b::c000:221")) {
+		t.Fatalf("Unexpected answer %v", resp.Answer[0])
+	}
+	w.ClearResponse()
+
+	// excluded IPv4 addresses are not mapped
+	q = new(dns.Msg)
+	q.SetQuestion("name2.", dns.TypeAAAA)
+	r.(*resolver).ServeDNS(w, q)
+	resp = w.GetResponse()
+	checkNonNullResponse(t, resp)
+	checkDNSAnswersCount(t, resp, 0)
+	w.ClearResponse()
+
+	q = new(dns.Msg)
+	q.SetQuestion("1.2.2.0.0.0.0.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.b.9.f.f.4.6.0.0.ip6.arpa.", dns.TypePTR)
+	r.(*resolver).ServeDNS(w, q)
+	resp = w.GetResponse()
+	checkNonNullResponse(t, resp)
+	checkDNSAnswersCount(t, resp, 1)
+	if answer, ok := resp.Answer[0].(*dns.PTR); !ok || answer.Ptr != "name1." {
+		t.Fatalf("Unexpected answer %v", resp.Answer[0])
+	}
+}
+
+func TestUpstreamHealth(t *testing.T) {
+	p := &ForwardPolicy{Mode: ForwardFastest, FailureThreshold: 2, BlacklistDuration: time.Minute}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, inv := range []*ForwardPolicy{{Mode: "random"}, {Retries: -1}, {FailureThreshold: 1}} {
+		if err := inv.Validate(); err == nil {
+			t.Fatalf("Expected failure validating %v", inv)
+		}
+	}
+
+	s1, s2, s3 := &extDNSEntry{IPStr: "10.0.0.1"}, &extDNSEntry{IPStr: "10.0.0.2"}, &extDNSEntry{IPStr: "10.0.0.3"}
+	var h upstreamHealth
+	h.record(s1.IPStr, true, 30*time.Millisecond, p)
+	h.record(s2.IPStr, true, 10*time.Millisecond, p)
+	h.record(s3.IPStr, true, 20*time.Millisecond, p)
+	order := h.order([]*extDNSEntry{s1, s2, s3}, p)
+	if len(order) != 3 || order[0] != s2 || order[1] != s3 || order[2] != s1 {
+		t.Fatalf("Unexpected nameservers order: %v", order)
+	}
+
+	// the second consecutive failure blacklists the nameserver
+	h.record(s2.IPStr, false, 0, p)
+	if order = h.order([]*extDNSEntry{s1, s2, s3}, p); len(order) != 3 {
+		t.Fatalf("Unexpected nameservers order: %v", order)
+	}
+	h.record(s2.IPStr, false, 0, p)
+	if order = h.order([]*extDNSEntry{s1, s2, s3}, p); len(order) != 2 || order[0] != s3 {
+		t.Fatalf("Unexpected nameservers order: %v", order)
+	}
+	// all the nameservers are used when all of them are blacklisted
+	if order = h.order([]*extDNSEntry{s2}, p); len(order) != 1 {
+		t.Fatalf("Unexpected nameservers order: %v", order)
+	}
+}
+
+func TestResolverQueryPolicy(t *testing.T) {
+	b := &tstbackend{names: map[string][]net.IP{"name1.": {net.ParseIP("192.168.0.1")}}}
+	r := NewResolver(resolverIPSandbox, true, "", b)
+	r.SetQueryPolicy(QueryPolicy{RateLimit: 1, Burst: 2, DenyDomains: []string{"exfil.example"}})
+	w := new(tstwriter)
+
+	// internal names are not subject to the domain lists
+	for i := 0; i < 2; i++ {
+		q := new(dns.Msg)
+		q.SetQuestion("name1.", dns.TypeA)
+		r.(*resolver).ServeDNS(w, q)
+		checkDNSResponseCode(t, w.GetResponse(), dns.RcodeSuccess)
+		w.ClearResponse()
+	}
+
+	// the burst is exhausted
+	q := new(dns.Msg)
+	q.SetQuestion("name1.", dns.TypeA)
+	r.(*resolver).ServeDNS(w, q)
+	checkDNSResponseCode(t, w.GetResponse(), dns.RcodeRefused)
+	w.ClearResponse()
+
+	acl := &r.(*resolver).acl
+	acl.set(QueryPolicy{DenyDomains: []string{"exfil.example"}, AllowDomains: []string{"example", "corp."}})
+	if acl.forwardAllowed("data.Exfil.Example.") || !acl.forwardAllowed("www.example.") || acl.forwardAllowed("www.example.org.") {
+		t.Fatal("Unexpected domain lists result")
+	}
+
+	q = new(dns.Msg)
+	q.SetQuestion("a.exfil.example.", dns.TypeA)
+	r.(*resolver).ServeDNS(w, q)
+	checkDNSResponseCode(t, w.GetResponse(), dns.RcodeRefused)
+
+	// the denial is not overridden for single label names with ndots set
+	b.ndots = true
+	acl.set(QueryPolicy{DenyDomains: []string{"intranet"}})
+	w.ClearResponse()
+	q = new(dns.Msg)
+	q.SetQuestion("intranet.", dns.TypeA)
+	r.(*resolver).ServeDNS(w, q)
+	checkDNSResponseCode(t, w.GetResponse(), dns.RcodeRefused)
+
+	st := r.Statistics()
+	if st.RateLimited != 1 || st.Denied != 2 {
+		t.Fatalf("Unexpected rate limited and denied counters: %d, %d", st.RateLimited, st.Denied)
+	}
+}
+
+func TestForwardDNSSEC(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.", dns.TypeA)
+
+	fwd, size := prepareForward(q, defaultRespSize, &ForwardPolicy{})
+	if fwd != q || size != defaultRespSize {
+		t.Fatal("Expected the query to be forwarded unchanged")
+	}
+
+	fwd, size = prepareForward(q, defaultRespSize, &ForwardPolicy{DNSSEC: true})
+	opt := fwd.IsEdns0()
+	if opt == nil || !opt.Do() || opt.UDPSize() != defaultEDNSBufferSize || size != defaultEDNSBufferSize {
+		t.Fatalf("Unexpected forwarded query %v", fwd)
+	}
+	if q.IsEdns0() != nil {
+		t.Fatal("The client query must not be modified")
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(fwd)
+	resp.Answer = append(resp.Answer,
+		&dns.A{Hdr: dns.RR_Header{Name: "www.example.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP("192.0.2.1")},
+		&dns.RRSIG{Hdr: dns.RR_Header{Name: "www.example.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET}, TypeCovered: dns.TypeA})
+	resp.SetEdns0(defaultEDNSBufferSize, true)
+
+	// the client did not use EDNS0 nor asked for DNSSEC records
+	resp = finishForward(q, resp, "udp", defaultRespSize)
+	if len(resp.Answer) != 1 || resp.IsEdns0() != nil {
+		t.Fatalf("Unexpected response %v", resp)
+	}
+}
+
+func TestForwardRequireAuthenticated(t *testing.T) {
+	q := new(dns.Msg)
+	q.SetQuestion("www.example.", dns.TypeA)
+
+	fwd, _ := prepareForward(q, defaultRespSize, &ForwardPolicy{RequireAuthenticated: true})
+	if opt := fwd.IsEdns0(); opt == nil || !opt.Do() || !fwd.AuthenticatedData {
+		t.Fatalf("Expected the forwarded query to ask for the validated DNSSEC records, got %v", fwd)
+	}
+	if q.AuthenticatedData {
+		t.Fatal("The client query must not be modified")
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(fwd)
+	if authenticated(resp) {
+		t.Fatal("Expected an answer without the AD bit not to be authenticated")
+	}
+	resp.AuthenticatedData = true
+	if !authenticated(resp) {
+		t.Fatal("Expected an answer with the AD bit to be authenticated")
+	}
+	resp.SetRcode(fwd, dns.RcodeNameError)
+	resp.AuthenticatedData = false
+	if authenticated(resp) {
+		t.Fatal("Expected a nonexistence proof without the AD bit not to be authenticated")
+	}
+	resp.SetRcode(fwd, dns.RcodeServerFailure)
+	if !authenticated(resp) {
+		t.Fatal("Expected a failure to be passed through")
+	}
+}
+
+func TestResolverQueryLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "querylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "queries.log")
+	b := &tstbackend{names: map[string][]net.IP{"name1.": {net.ParseIP("192.168.0.1")}}}
+	r := NewResolver(resolverIPSandbox, false, "", b)
+	if err := r.SetQueryLog(&QueryLogConfig{Path: path, MaxSize: 200, MaxFiles: 2}, "ctr1"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := new(tstwriter)
+	for i := 0; i < 4; i++ {
+		q := new(dns.Msg)
+		q.SetQuestion("name1.", dns.TypeA)
+		r.(*resolver).ServeDNS(w, q)
+	}
+	if err := r.SetQueryLog(nil, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e QueryLogEntry
+	if err := json.Unmarshal(bytes.SplitN(data, []byte("\n"), 2)[0], &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Source != "ctr1" || e.Name != "name1." || e.Type != "A" || e.Result != "NOERROR" || e.Answers != 1 {
+		t.Fatalf("Unexpected query log entry %+v", e)
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("Expected the query log to be rotated: %v", err)
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Fatal("Expected at most 2 rotated query logs")
+	}
+}
+
+func TestForwardRules(t *testing.T) {
+	rules := []*ForwardRule{
+		{Domain: "*.corp.example", Servers: []string{"10.1.1.53"}},
+		{Domain: "lab.corp.example.", Servers: []string{"10.2.2.53", "10.2.2.54"}},
+	}
+	for _, fr := range rules {
+		if err := fr.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, inv := range []*ForwardRule{{Domain: "corp.example"}, {Domain: "*.", Servers: []string{"10.1.1.53"}}, {Domain: "corp.example", Servers: []string{"corp-ns"}}} {
+		if err := inv.Validate(); err == nil {
+			t.Fatalf("Expected failure validating %v", inv)
+		}
+	}
+
+	r := NewResolver(resolverIPSandbox, true, "", &tstbackend{}).(*resolver)
+	r.SetExtServers([]extDNSEntry{{IPStr: "8.8.8.8"}})
+	r.SetForwardRules(rules)
+	for name, expected := range map[string]string{
+		"corp.example.":          "10.1.1.53",
+		"www.CORP.example.":      "10.1.1.53",
+		"db.lab.corp.example.":   "10.2.2.53",
+		"lab.corp.example":       "10.2.2.53",
+		"notcorp.example.":       "8.8.8.8",
+		"www.example.":           "8.8.8.8",
+		"corp.example.internal.": "8.8.8.8",
+	} {
+		if servers := r.serversFor(name); len(servers) == 0 || servers[0].IPStr != expected {
+			t.Fatalf("Expected %s to be forwarded to %s, got %v", name, expected, servers)
+		}
+	}
+
+	r.SetForwardRules(nil)
+	if servers := r.serversFor("www.corp.example."); len(servers) != 1 || servers[0].IPStr != "8.8.8.8" {
+		t.Fatalf("Unexpected nameservers after removing the rules: %v", servers)
+	}
+}
+
+func TestForwardRuleIPv6(t *testing.T) {
+	var nRequests int
+	l, err := net.Listen("tcp", "[::1]:53")
+	if err != nil {
+		t.Skipf("Could not listen on the IPv6 loopback: %v", err)
+	}
+	server := &dns.Server{Listener: l, Handler: dns.HandlerFunc(newDNSHandlerServFailOnce(&nRequests))}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	fr := &ForwardRule{Domain: "corp.example", Servers: []string{"::1", "::1"}}
+	if err := fr.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	r := NewResolver(resolverIPSandbox, true, "", &tstbackend{}).(*resolver)
+	r.SetForwardRules([]*ForwardRule{fr})
+
+	// the first nameserver fails the query, the second one answers it
+	w := new(tstwriter)
+	q := new(dns.Msg)
+	q.SetQuestion("www.corp.example.", dns.TypeA)
+	r.ServeDNS(w, q)
+	checkNonNullResponse(t, w.GetResponse())
+	checkDNSResponseCode(t, w.GetResponse(), dns.RcodeSuccess)
+	if nRequests != 2 {
+		t.Fatalf("Expected 2 DNS queries to the IPv6 nameserver. Found: %d", nRequests)
+	}
+}
+
+func TestTruncateResp(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("many.example.", dns.TypeA)
+	query.SetEdns0(4096, false)
+	resp := createRespMsg(query)
+	for i := 0; i < 100; i++ {
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "many.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: respTTL},
+			A:   net.IPv4(10, 0, byte(i>>8), byte(i)),
+		})
+	}
+	resp.SetEdns0(4096, false)
+	truncateResp(resp, defaultRespSize, false)
+	if !resp.Truncated || resp.Len() > defaultRespSize || len(resp.Answer) == 0 {
+		t.Fatalf("Unexpected truncated response: truncated %t, length %d, %d answers", resp.Truncated, resp.Len(), len(resp.Answer))
+	}
+	if resp.IsEdns0() == nil {
+		t.Fatal("Expected the OPT record to be kept in the truncated response")
+	}
+
+	// records other than the answers are trimmed when needed
+	resp = createRespMsg(query)
+	for i := 0; i < 100; i++ {
+		resp.Ns = append(resp.Ns, &dns.NS{
+			Hdr: dns.RR_Header{Name: "many.example.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: respTTL},
+			Ns:  dns.Fqdn(string(rune('a'+i%26)) + ".ns.example"),
+		})
+	}
+	truncateResp(resp, defaultRespSize, false)
+	if resp.Len() > defaultRespSize {
+		t.Fatalf("Unexpected truncated response length %d", resp.Len())
+	}
+}
+
+func TestTCPDNSServerPipelining(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTCPDNSServer(l, dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+		if q.Question[0].Name == "slow.example." {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteMsg(createRespMsg(q))
+	}))
+	go s.Serve()
+	defer s.Shutdown()
+
+	c, err := net.DialTimeout("tcp", l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	co := &dns.Conn{Conn: c}
+	defer co.Close()
+	co.SetDeadline(time.Now().Add(2 * time.Second))
+
+	for _, name := range []string{"slow.example.", "fast.example."} {
+		q := new(dns.Msg)
+		q.SetQuestion(name, dns.TypeA)
+		if err := co.WriteMsg(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the fast query is answered without waiting for the slow one
+	for _, expected := range []string{"fast.example.", "slow.example."} {
+		resp, err := co.ReadMsg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Question[0].Name != expected {
+			t.Fatalf("Expected the response for %s, got %s", expected, resp.Question[0].Name)
+		}
+	}
+}
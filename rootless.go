@@ -0,0 +1,28 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/rootless"
+)
+
+// rootlessUnsupportedDrivers are the drivers needing privileges on the host,
+// to program the host interfaces or to join the gossip cluster
+var rootlessUnsupportedDrivers = map[string]bool{
+	"overlay": true,
+	"macvlan": true,
+	"ipvlan":  true,
+}
+
+// checkRootless returns an error when the network needs privileges on the
+// host the controller does not have in rootless mode
+func (c *controller) checkRootless(n *network) error {
+	if c.cfg == nil || !c.cfg.Daemon.Rootless {
+		return nil
+	}
+	if rootlessUnsupportedDrivers[n.networkType] {
+		return rootless.UnsupportedError(n.networkType + " driver")
+	}
+	if n.ingress {
+		return rootless.UnsupportedError("ingress network")
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package rootless supports running libnetwork without privileges on the
+// host, in the user and network namespaces set up by RootlessKit. The
+// daemon programs its network namespace as usual, the host side of the
+// connectivity is provided by slirp4netns and the published ports are
+// exposed on the host through the RootlessKit port driver.
+package rootless
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StateDirEnv is the environment variable RootlessKit sets to its state
+// directory in the namespaces it creates
+const StateDirEnv = "ROOTLESSKIT_STATE_DIR"
+
+// apiTimeout bounds the requests to the RootlessKit API
+const apiTimeout = 10 * time.Second
+
+// RunningWithRootlessKit returns true when the process runs in the
+// namespaces of RootlessKit
+func RunningWithRootlessKit() bool {
+	return os.Getenv(StateDirEnv) != ""
+}
+
+// StateDir returns the state directory of RootlessKit
+func StateDir() string {
+	return os.Getenv(StateDirEnv)
+}
+
+// UnsupportedError is returned for the features needing privileges on the
+// host, which are not available in rootless mode
+type UnsupportedError string
+
+func (e UnsupportedError) Error() string {
+	return fmt.Sprintf("%s not supported in rootless mode", string(e))
+}
+
+// Forbidden denotes the type of this error
+func (e UnsupportedError) Forbidden() {}
+
+// portSpec and portStatus are the port requests and replies of the
+// RootlessKit API
+type portSpec struct {
+	Proto      string `json:"proto,omitempty"`
+	ParentIP   string `json:"parentIP,omitempty"`
+	ParentPort int    `json:"parentPort,omitempty"`
+	ChildPort  int    `json:"childPort,omitempty"`
+}
+
+type portStatus struct {
+	ID   int      `json:"id"`
+	Spec portSpec `json:"spec"`
+}
+
+type apiError struct {
+	Message string `json:"message"`
+}
+
+// PortDriver exposes the ports of the network namespace of the daemon on
+// the host, through the API of the RootlessKit port driver
+type PortDriver struct {
+	client *http.Client
+	// ids are the RootlessKit ids of the exposed ports
+	ids map[string]int
+	sync.Mutex
+}
+
+// NewPortDriver returns a port driver talking to the API socket of
+// RootlessKit in stateDir
+func NewPortDriver(stateDir string) *PortDriver {
+	sock := filepath.Join(stateDir, "api.sock")
+	return &PortDriver{
+		client: &http.Client{
+			Timeout: apiTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", sock)
+				},
+			},
+		},
+		ids: make(map[string]int),
+	}
+}
+
+func portKey(proto string, ip net.IP, port int) string {
+	return fmt.Sprintf("%s/%s:%d", proto, ip, port)
+}
+
+// ExposePort exposes the port of the daemon namespace on the host address
+// ip, with the same port number
+func (d *PortDriver) ExposePort(proto string, ip net.IP, port int) error {
+	key := portKey(proto, ip, port)
+	d.Lock()
+	defer d.Unlock()
+	if _, ok := d.ids[key]; ok {
+		return nil
+	}
+
+	spec := portSpec{Proto: proto, ParentIP: ip.String(), ParentPort: port, ChildPort: port}
+	body, err := json.Marshal(&spec)
+	if err != nil {
+		return err
+	}
+	var st portStatus
+	if err := d.call("POST", "/v1/ports", body, &st); err != nil {
+		return fmt.Errorf("failed to expose port %s on the host: %v", key, err)
+	}
+	d.ids[key] = st.ID
+	return nil
+}
+
+// UnexposePort removes the port exposed by ExposePort
+func (d *PortDriver) UnexposePort(proto string, ip net.IP, port int) error {
+	key := portKey(proto, ip, port)
+	d.Lock()
+	defer d.Unlock()
+	id, ok := d.ids[key]
+	if !ok {
+		return nil
+	}
+	if err := d.call("DELETE", fmt.Sprintf("/v1/ports/%d", id), nil, nil); err != nil {
+		return fmt.Errorf("failed to remove the host port %s: %v", key, err)
+	}
+	delete(d.ids, key)
+	return nil
+}
+
+func (d *PortDriver) call(method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(method, "http://rootlesskit"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var ae apiError
+		if json.Unmarshal(data, &ae) == nil && ae.Message != "" {
+			return fmt.Errorf("%s", ae.Message)
+		}
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,93 @@
+package rootless
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestPortDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rootlesskit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", filepath.Join(dir, "api.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	exposed := make(map[string]portSpec)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v1/ports":
+			var spec portSpec
+			if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if spec.ParentPort == 22 {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(&apiError{Message: "port already in use"})
+				return
+			}
+			exposed["/v1/ports/1"] = spec
+			json.NewEncoder(w).Encode(&portStatus{ID: 1, Spec: spec})
+		case r.Method == "DELETE":
+			if _, ok := exposed[r.URL.Path]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(exposed, r.URL.Path)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	d := NewPortDriver(dir)
+	ip := net.ParseIP("127.0.0.1")
+	if err := d.ExposePort("tcp", ip, 8080); err != nil {
+		t.Fatal(err)
+	}
+	expected := portSpec{Proto: "tcp", ParentIP: "127.0.0.1", ParentPort: 8080, ChildPort: 8080}
+	if spec := exposed["/v1/ports/1"]; spec != expected {
+		t.Fatalf("unexpected exposed port %+v", spec)
+	}
+	if err := d.ExposePort("tcp", ip, 22); err == nil {
+		t.Fatal("expected the port in use to fail")
+	}
+
+	if err := d.UnexposePort("tcp", ip, 8080); err != nil {
+		t.Fatal(err)
+	}
+	if len(exposed) != 0 {
+		t.Fatalf("expected no exposed ports, got %v", exposed)
+	}
+	// ports not exposed are not an error
+	if err := d.UnexposePort("tcp", ip, 8080); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnsupportedError(t *testing.T) {
+	var err error = UnsupportedError("overlay driver")
+	if err.Error() != "overlay driver not supported in rootless mode" {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+	if _, ok := err.(interface{ Forbidden() }); !ok {
+		t.Fatal("expected a forbidden error")
+	}
+}
@@ -1,6 +1,7 @@
 package libnetwork
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -37,6 +38,10 @@ type Sandbox interface {
 	Rename(name string) error
 	// Delete destroys this container after detaching it from all connected endpoints.
 	Delete() error
+	// DeleteContext is Delete which gives up when the context is done before
+	// all the endpoints are detached, the sandbox is kept with the endpoints
+	// not yet detached.
+	DeleteContext(ctx context.Context) error
 	// Endpoints returns all the endpoints connected to the sandbox
 	Endpoints() []Endpoint
 	// ResolveService returns all the backend details about the containers or hosts
@@ -83,6 +88,7 @@ type sandbox struct {
 	inDelete           bool
 	ingress            bool
 	ndotsSet           bool
+	ipvsSyncStarted    bool
 	oslTypes           []osl.SandboxType // slice of properties of this sandbox
 	loadBalancerNID    string            // NID that this SB is a load balancer for
 	sync.Mutex
@@ -120,6 +126,7 @@ type resolvConfPathConfig struct {
 	dnsList              []string
 	dnsSearchList        []string
 	dnsOptionsList       []string
+	dnsQueryLog          *QueryLogConfig
 }
 
 type containerConfig struct {
@@ -130,6 +137,7 @@ type containerConfig struct {
 	useExternalKey    bool
 	prio              int // higher the value, more the priority
 	exposedPorts      []types.TransportPort
+	sysctls           map[string]string
 }
 
 const (
@@ -182,10 +190,14 @@ func (sb *sandbox) Statistics() (map[string]*types.InterfaceStatistics, error) {
 }
 
 func (sb *sandbox) Delete() error {
-	return sb.delete(false)
+	return sb.delete(context.Background(), false)
 }
 
-func (sb *sandbox) delete(force bool) error {
+func (sb *sandbox) DeleteContext(ctx context.Context) error {
+	return sb.delete(ctx, false)
+}
+
+func (sb *sandbox) delete(ctx context.Context, force bool) error {
 	sb.Lock()
 	if sb.inDelete {
 		sb.Unlock()
@@ -207,6 +219,12 @@ func (sb *sandbox) delete(force bool) error {
 	// Detach from all endpoints
 	retain := false
 	for _, ep := range sb.getConnectedEndpoints() {
+		if err := ctx.Err(); err != nil {
+			sb.Lock()
+			sb.inDelete = false
+			sb.Unlock()
+			return err
+		}
 		// gw network endpoint detach and removal are automatic
 		if ep.endpointInGWNetwork() && !force {
 			continue
@@ -243,6 +261,7 @@ func (sb *sandbox) delete(force bool) error {
 
 	if sb.resolver != nil {
 		sb.resolver.Stop()
+		sb.resolver.SetQueryLog(nil, "")
 	}
 
 	if sb.osSbox != nil && !sb.config.useDefaultSandBox {
@@ -260,6 +279,7 @@ func (sb *sandbox) delete(force bool) error {
 	delete(c.sandboxes, sb.ID())
 	c.Unlock()
 
+	c.publishSandbox(EventSandboxDestroy, sb)
 	return nil
 }
 
@@ -530,6 +550,12 @@ func (sb *sandbox) ResolveName(name string, ipType int) ([]net.IP, bool) {
 	// {a in network b.c.d},
 
 	logrus.Debugf("Name To resolve: %v", name)
+
+	// View rules of the connected networks take precedence over the records
+	if ip, ok := sb.resolveView(name, ipType); ok {
+		return ip, ip == nil
+	}
+
 	name = strings.TrimSuffix(name, ".")
 	reqName := []string{name}
 	networkName := []string{""}
@@ -668,6 +694,10 @@ func (sb *sandbox) SetKey(basePath string) error {
 	sb.osSbox = osSbox
 	sb.Unlock()
 
+	if err := sb.setSysctls(); err != nil {
+		return err
+	}
+
 	// If the resolver was setup before stop it and set it up in the
 	// new osl sandbox.
 	if oldosSbox != nil && sb.resolver != nil {
@@ -682,12 +712,7 @@ func (sb *sandbox) SetKey(basePath string) error {
 		}
 	}
 
-	for _, ep := range sb.getConnectedEndpoints() {
-		if err = sb.populateNetworkResources(ep); err != nil {
-			return err
-		}
-	}
-	return nil
+	return sb.populateEndpoints(sb.getConnectedEndpoints())
 }
 
 func (sb *sandbox) EnableService() (err error) {
@@ -731,6 +756,15 @@ func (sb *sandbox) DisableService() (err error) {
 }
 
 func releaseOSSboxResources(osSbox osl.Sandbox, ep *endpoint) {
+	// Reset the sysctls while the interface is in the sandbox
+	ep.Lock()
+	prev := ep.sysctlsPrev
+	ep.sysctlsPrev = nil
+	ep.Unlock()
+	if err := osSbox.ResetSysctls(prev); err != nil {
+		logrus.Debugf("Reset sysctls of endpoint %s failed: %v", ep.Name(), err)
+	}
+
 	for _, i := range osSbox.Info().Interfaces() {
 		// Only remove the interfaces owned by this endpoint from the sandbox.
 		if ep.hasInterface(i.SrcName()) {
@@ -743,7 +777,7 @@ func releaseOSSboxResources(osSbox osl.Sandbox, ep *endpoint) {
 	ep.Lock()
 	joinInfo := ep.joinInfo
 	vip := ep.virtualIP
-	lbModeIsDSR := ep.network.loadBalancerMode == loadBalancerModeDSR
+	lbModeIsDSR := isDSRMode(ep.network.loadBalancerMode)
 	ep.Unlock()
 
 	if len(vip) > 0 && lbModeIsDSR {
@@ -829,6 +863,12 @@ func (sb *sandbox) restoreOslSandbox() error {
 }
 
 func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
+	return sb.populateEndpoints([]*endpoint{ep})
+}
+
+// populateEndpoints populates the network resources of the endpoints, their
+// interfaces being added to the sandbox in a single batch
+func (sb *sandbox) populateEndpoints(epList []*endpoint) error {
 	sb.Lock()
 	if sb.osSbox == nil {
 		sb.Unlock()
@@ -837,20 +877,23 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 	inDelete := sb.inDelete
 	sb.Unlock()
 
-	ep.Lock()
-	joinInfo := ep.joinInfo
-	i := ep.iface
-	lbModeIsDSR := ep.network.loadBalancerMode == loadBalancerModeDSR
-	ep.Unlock()
-
-	if ep.needResolver() {
-		sb.startResolver(false)
-	}
+	var (
+		ifaces []osl.InterfaceConfig
+		names  []string
+	)
+	for _, ep := range epList {
+		if ep.needResolver() {
+			sb.startResolver(false)
+		}
 
-	if i != nil && i.srcName != "" {
-		var ifaceOptions []osl.IfaceOption
+		ep.Lock()
+		i := ep.iface
+		ep.Unlock()
+		if i == nil || i.srcName == "" {
+			continue
+		}
 
-		ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().Address(i.addr), sb.osSbox.InterfaceOptions().Routes(i.routes))
+		ifaceOptions := []osl.IfaceOption{sb.osSbox.InterfaceOptions().Address(i.addr), sb.osSbox.InterfaceOptions().Routes(i.routes)}
 		if i.addrv6 != nil && i.addrv6.IP.To16() != nil {
 			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().AddressIPv6(i.addrv6))
 		}
@@ -860,16 +903,63 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 		if i.mac != nil {
 			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().MacAddress(i.mac))
 		}
+		ifaces = append(ifaces, osl.InterfaceConfig{SrcName: i.srcName, DstPrefix: i.dstPrefix, Options: ifaceOptions})
+		names = append(names, i.srcName)
+	}
+
+	if len(ifaces) > 0 {
+		if err := sb.osSbox.AddInterfaces(ifaces); err != nil {
+			return fmt.Errorf("failed to add interface %s to sandbox: %v", strings.Join(names, ", "), err)
+		}
+	}
 
-		if err := sb.osSbox.AddInterface(i.srcName, i.dstPrefix, ifaceOptions...); err != nil {
-			return fmt.Errorf("failed to add interface %s to sandbox: %v", i.srcName, err)
+	for _, ep := range epList {
+		if err := sb.populateEndpoint(ep); err != nil {
+			return err
 		}
+	}
 
-		if len(ep.virtualIP) > 0 && lbModeIsDSR {
+	// Only update the store if we did not come here as part of
+	// sandbox delete. If we came here as part of delete then do
+	// not bother updating the store. The sandbox object will be
+	// deleted anyway
+	if !inDelete {
+		return sb.storeUpdate()
+	}
+
+	return nil
+}
+
+// populateEndpoint populates the network resources of the endpoint whose
+// interface was added to the sandbox
+func (sb *sandbox) populateEndpoint(ep *endpoint) error {
+	ep.Lock()
+	joinInfo := ep.joinInfo
+	i := ep.iface
+	lbMode := ep.network.loadBalancerMode
+	ep.Unlock()
+
+	if i != nil && i.srcName != "" {
+		if sysctls := ep.sandboxSysctls(sandboxIfaceName(sb.osSbox, i.srcName)); len(sysctls) > 0 {
+			prev, err := sb.osSbox.SetSysctls(sysctls)
+			if err != nil {
+				return fmt.Errorf("failed to set the sysctls of endpoint %s: %v", ep.Name(), err)
+			}
+			ep.Lock()
+			ep.sysctlsPrev = prev
+			ep.Unlock()
+		}
+
+		if len(ep.virtualIP) > 0 && isDSRMode(lbMode) {
 			if sb.loadBalancerNID == "" {
 				if err := sb.osSbox.DisableARPForVIP(i.srcName); err != nil {
 					return fmt.Errorf("failed disable ARP for VIP: %v", err)
 				}
+				if lbMode == loadBalancerModeTunnel {
+					if err := sb.osSbox.EnableIPIPForVIP(); err != nil {
+						return fmt.Errorf("failed to enable IPIP for VIP: %v", err)
+					}
+				}
 			}
 			ipNet := &net.IPNet{IP: ep.virtualIP, Mask: net.CIDRMask(32, 32)}
 			if err := sb.osSbox.AddAliasIP(sb.osSbox.GetLoopbackIfaceName(), ipNet); err != nil {
@@ -905,14 +995,6 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 	// place in the sandbox.
 	sb.populateLoadBalancers(ep)
 
-	// Only update the store if we did not come here as part of
-	// sandbox delete. If we came here as part of delete then do
-	// not bother updating the store. The sandbox object will be
-	// deleted anyway
-	if !inDelete {
-		return sb.storeUpdate()
-	}
-
 	return nil
 }
 
@@ -997,8 +1079,9 @@ func (sb *sandbox) isEndpointPopulated(ep *endpoint) bool {
 }
 
 // joinLeaveStart waits to ensure there are no joins or leaves in progress and
-// marks this join/leave in progress without race
-func (sb *sandbox) joinLeaveStart() {
+// marks this join/leave in progress without race. It gives up waiting when
+// the context is done.
+func (sb *sandbox) joinLeaveStart(ctx context.Context) error {
 	sb.Lock()
 	defer sb.Unlock()
 
@@ -1006,12 +1089,18 @@ func (sb *sandbox) joinLeaveStart() {
 		joinLeaveDone := sb.joinLeaveDone
 		sb.Unlock()
 
-		<-joinLeaveDone
+		select {
+		case <-joinLeaveDone:
+		case <-ctx.Done():
+			sb.Lock()
+			return ctx.Err()
+		}
 
 		sb.Lock()
 	}
 
 	sb.joinLeaveDone = make(chan struct{})
+	return nil
 }
 
 // joinLeaveEnd marks the end of this join/leave operation and
@@ -1121,6 +1210,15 @@ func OptionDNSOptions(options string) SandboxOption {
 	}
 }
 
+// OptionDNSQueryLog function returns an option setter for the embedded DNS
+// server query log to be passed to container Create method. It takes
+// precedence over the query log of the networks the container is attached to.
+func OptionDNSQueryLog(config QueryLogConfig) SandboxOption {
+	return func(sb *sandbox) {
+		sb.config.dnsQueryLog = &config
+	}
+}
+
 // OptionUseDefaultSandbox function returns an option setter for using default sandbox
 // (host namespace) to be passed to container Create method.
 func OptionUseDefaultSandbox() SandboxOption {
@@ -1181,6 +1279,20 @@ func OptionPortMapping(portBindings []types.PortBinding) SandboxOption {
 	}
 }
 
+// OptionSysctls function returns an option setter for the network sysctls
+// to be set in the sandbox, as in "net.core.somaxconn". The sysctls are set
+// when the sandbox is set up and reset when it is torn down.
+func OptionSysctls(sysctls map[string]string) SandboxOption {
+	return func(sb *sandbox) {
+		if sb.config.sysctls == nil {
+			sb.config.sysctls = make(map[string]string, len(sysctls))
+		}
+		for k, v := range sysctls {
+			sb.config.sysctls[k] = v
+		}
+	}
+}
+
 // OptionIngress function returns an option setter for marking a
 // sandbox as the controller's ingress sandbox.
 func OptionIngress() SandboxOption {
@@ -1267,3 +1379,12 @@ func (epi *endpoint) Less(epj *endpoint) bool {
 func (sb *sandbox) NdotsSet() bool {
 	return sb.ndotsSet
 }
+
+func (sb *sandbox) NAT64Prefix() *net.IPNet {
+	for _, ep := range sb.getConnectedEndpoints() {
+		if prefix := ep.getNetwork().NAT64Prefix(); prefix != nil {
+			return prefix
+		}
+	}
+	return nil
+}
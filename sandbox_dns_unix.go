@@ -5,6 +5,7 @@ package libnetwork
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
@@ -46,6 +47,29 @@ func (sb *sandbox) startResolver(restore bool) {
 			}
 		}
 		sb.resolver.SetExtServers(sb.extDNS)
+		queryLog := sb.config.dnsQueryLog
+		if dc := sb.networkDNSConfig(); dc != nil {
+			if dc.Forwarding != nil {
+				sb.resolver.SetForwardPolicy(*dc.Forwarding)
+			}
+			if dc.QueryPolicy != nil {
+				sb.resolver.SetQueryPolicy(*dc.QueryPolicy)
+			}
+			if len(dc.ForwardRules) > 0 {
+				sb.resolver.SetForwardRules(dc.ForwardRules)
+			}
+			if dc.MaxUDPResponseSize != 0 {
+				sb.resolver.SetMaxUDPResponseSize(dc.MaxUDPResponseSize)
+			}
+			if queryLog == nil {
+				queryLog = dc.QueryLog
+			}
+		}
+		if queryLog != nil {
+			if err := sb.resolver.SetQueryLog(queryLog, sb.ContainerID()); err != nil {
+				logrus.Warnf("Failed to enable the DNS query log for container %s: %v", sb.ContainerID(), err)
+			}
+		}
 
 		if err = sb.osSbox.InvokeFunc(sb.resolver.SetupFunc(0)); err != nil {
 			logrus.Errorf("Resolver Setup function failed for container %s, %q", sb.ContainerID(), err)
@@ -252,14 +276,22 @@ func (sb *sandbox) setupDNS() error {
 		// config refers to the loopback in the container namespace
 		sb.setExternalResolvers(newRC.Content, types.IPv4, false)
 	} else {
+		// The host resolv.conf may only list the systemd-resolved stub
+		// listener, in which case its upstream nameservers are used
+		up, err := resolvconf.EffectiveUpstreams(sb.controller.upstreamOptions(originResolvConfPath))
+		if err != nil {
+			return err
+		}
+		logrus.Debugf("Sandbox %s uses the %s nameservers %v: %s", sb.ID(), up.Source, up.Nameservers, up.Reason)
+
 		// If the host resolv.conf file has 127.0.0.x container should
 		// use the host resolver for queries. This is supported by the
 		// docker embedded DNS server. Hence save the external resolvers
 		// before filtering it out.
-		sb.setExternalResolvers(currRC.Content, types.IPv4, true)
+		sb.setExternalResolvers(up.File.Content, types.IPv4, true)
 
 		// Replace any localhost/127.* (at this point we have no info about ipv6, pass it as true)
-		if newRC, err = resolvconf.FilterResolvDNS(currRC.Content, true); err != nil {
+		if newRC, err = resolvconf.FilterResolvDNS(up.File.Content, true); err != nil {
 			return err
 		}
 		// No contention on container resolv.conf file at sandbox creation
@@ -361,10 +393,30 @@ func (sb *sandbox) rebuildDNS() error {
 		dnsList        = []string{sb.resolver.NameServer()}
 		dnsOptionsList = resolvconf.GetOptions(currRC.Content)
 		dnsSearchList  = resolvconf.GetSearchDomains(currRC.Content)
+		v6Servers      = resolvconf.GetNameservers(currRC.Content, types.IPv6)
 	)
 
+	// Network level DNS configuration overrides what got inherited from the
+	// host, but not what the user explicitly configured for the container
+	if dc := sb.networkDNSConfig(); dc != nil {
+		if len(dc.Servers) > 0 && len(sb.config.dnsList) == 0 {
+			sb.extDNS = nil
+			v6Servers = nil
+			for _, srv := range dc.Servers {
+				if net.ParseIP(srv).To4() == nil {
+					v6Servers = append(v6Servers, srv)
+					continue
+				}
+				sb.extDNS = append(sb.extDNS, extDNSEntry{IPStr: srv})
+			}
+		}
+		if len(dc.Options) > 0 && len(sb.config.dnsOptionsList) == 0 {
+			dnsOptionsList = mergeDNSOptions(dnsOptionsList, dc.Options)
+		}
+	}
+
 	// external v6 DNS servers has to be listed in resolv.conf
-	dnsList = append(dnsList, resolvconf.GetNameservers(currRC.Content, types.IPv6)...)
+	dnsList = append(dnsList, v6Servers...)
 
 	// If the user config and embedded DNS server both have ndots option set,
 	// remember the user's config so that unqualified names not in the docker
@@ -404,6 +456,36 @@ dnsOpt:
 	return err
 }
 
+// networkDNSConfig returns the DNS configuration of the highest priority
+// network the sandbox is connected to which carries one
+func (sb *sandbox) networkDNSConfig() *DNSConfig {
+	for _, ep := range sb.getConnectedEndpoints() {
+		if dc := ep.getNetwork().DNSConfig(); dc != nil {
+			return dc
+		}
+	}
+	return nil
+}
+
+// mergeDNSOptions returns the options in base with the ones in overrides
+// replacing the options with the same name
+func mergeDNSOptions(base, overrides []string) []string {
+	name := func(o string) string {
+		return strings.SplitN(o, ":", 2)[0]
+	}
+	overridden := make(map[string]bool, len(overrides))
+	for _, o := range overrides {
+		overridden[name(o)] = true
+	}
+	merged := make([]string, 0, len(base)+len(overrides))
+	for _, o := range base {
+		if !overridden[name(o)] {
+			merged = append(merged, o)
+		}
+	}
+	return append(merged, overrides...)
+}
+
 func createBasePath(dir string) error {
 	return os.MkdirAll(dir, dirPerm)
 }
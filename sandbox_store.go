@@ -1,6 +1,7 @@
 package libnetwork
 
 import (
+	"context"
 	"encoding/json"
 	"sync"
 
@@ -32,6 +33,9 @@ type sbState struct {
 	// between >=1.14 and <1.14 versions.
 	ExtDNS  []string
 	ExtDNS2 []extDNSEntry
+	// the query log of the sandbox, which the restored sandbox keeps unless
+	// its options set another one
+	QueryLog *QueryLogConfig `json:",omitempty"`
 }
 
 func (sbs *sbState) Key() []string {
@@ -113,6 +117,10 @@ func (sbs *sbState) CopyTo(o datastore.KVObject) error {
 	dstSbs.dbIndex = sbs.dbIndex
 	dstSbs.dbExists = sbs.dbExists
 	dstSbs.EpPriority = sbs.EpPriority
+	if sbs.QueryLog != nil {
+		ql := *sbs.QueryLog
+		dstSbs.QueryLog = &ql
+	}
 
 	dstSbs.Eps = append(dstSbs.Eps, sbs.Eps...)
 
@@ -142,6 +150,7 @@ func (sb *sandbox) storeUpdate() error {
 		Cid:        sb.containerID,
 		EpPriority: sb.epPriority,
 		ExtDNS2:    sb.extDNS,
+		QueryLog:   sb.config.dnsQueryLog,
 	}
 
 	for _, ext := range sb.extDNS {
@@ -239,6 +248,9 @@ func (c *controller) sandboxCleanup(activeSandboxes map[string]interface{}) {
 			isRestore = true
 			opts := val.([]SandboxOption)
 			sb.processOptions(opts...)
+			if sb.config.dnsQueryLog == nil {
+				sb.config.dnsQueryLog = sbs.QueryLog
+			}
 			sb.restorePath()
 			create = !sb.config.useDefaultSandBox
 		}
@@ -275,7 +287,7 @@ func (c *controller) sandboxCleanup(activeSandboxes map[string]interface{}) {
 
 		if _, ok := activeSandboxes[sb.ID()]; !ok {
 			logrus.Infof("Removing stale sandbox %s (%s)", sb.id, sb.containerID)
-			if err := sb.delete(true); err != nil {
+			if err := sb.delete(context.Background(), true); err != nil {
 				logrus.Errorf("Failed to delete sandbox %s while trying to cleanup: %v", sb.id, err)
 			}
 			continue
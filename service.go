@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/docker/libnetwork/internal/setmatrix"
 )
@@ -15,6 +16,27 @@ var (
 	fwMarkCtrMu sync.Mutex
 )
 
+// LBSchedulers are the load balancing schedulers a service can select, an
+// empty scheduler means weighted round robin. The backend weights are
+// ignored by rr and lc.
+var LBSchedulers = []string{"rr", "wrr", "lc", "sh", "mh"}
+
+// MaxLBWeight is the maximum load balancing weight of a backend, zero means
+// the default weight of 1
+const MaxLBWeight = 65535
+
+func validLBScheduler(scheduler string) bool {
+	if scheduler == "" {
+		return true
+	}
+	for _, s := range LBSchedulers {
+		if s == scheduler {
+			return true
+		}
+	}
+	return false
+}
+
 type portConfigs []*PortConfig
 
 func (p portConfigs) String() string {
@@ -50,6 +72,12 @@ type service struct {
 	// Service aliases
 	aliases []string
 
+	// Load balancing scheduler, weighted round robin when empty
+	scheduler string
+
+	// Session affinity timeout, no affinity when zero
+	persistence time.Duration
+
 	// This maps tracks for each IP address the list of endpoints ID
 	// associated with it. At stable state the endpoint ID expected is 1
 	// but during transition and service change it is possible to have
@@ -82,6 +110,10 @@ func (s *service) printIPToEndpoint(ip string) (string, bool) {
 type lbBackend struct {
 	ip       net.IP
 	disabled bool
+	// unhealthy backends are kept out of the load balancer
+	unhealthy bool
+	// load balancing weight, 1 when zero
+	weight int
 }
 
 type loadBalancer struct {
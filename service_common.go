@@ -4,6 +4,7 @@ package libnetwork
 
 import (
 	"net"
+	"time"
 
 	"github.com/docker/libnetwork/internal/setmatrix"
 	"github.com/sirupsen/logrus"
@@ -43,7 +44,7 @@ func (c *controller) addEndpointNameResolution(svcName, svcID, nID, eID, contain
 	}
 
 	if addService && len(vip) != 0 {
-		n.(*network).addSvcRecords(eID, svcName, serviceID, vip, nil, false, method)
+		n.(*network).addSvcRecords(eID, svcName, serviceID, vip, nil, true, method)
 		for _, alias := range serviceAliases {
 			n.(*network).addSvcRecords(eID, alias, serviceID, vip, nil, false, method)
 		}
@@ -105,7 +106,7 @@ func (c *controller) deleteEndpointNameResolution(svcName, svcID, nID, eID, cont
 
 	// Remove the DNS record for VIP only if we are removing the service
 	if rmService && len(vip) != 0 && !multipleEntries {
-		n.(*network).deleteSvcRecords(eID, svcName, serviceID, vip, nil, false, method)
+		n.(*network).deleteSvcRecords(eID, svcName, serviceID, vip, nil, true, method)
 		for _, alias := range serviceAliases {
 			n.(*network).deleteSvcRecords(eID, alias, serviceID, vip, nil, false, method)
 		}
@@ -132,11 +133,13 @@ func (c *controller) delContainerNameResolution(nID, eID, containerName string,
 	return nil
 }
 
-func newService(name string, id string, ingressPorts []*PortConfig, serviceAliases []string) *service {
+func newService(name string, id string, ingressPorts []*PortConfig, scheduler string, persistence time.Duration, serviceAliases []string) *service {
 	return &service{
 		name:          name,
 		id:            id,
 		ingressPorts:  ingressPorts,
+		scheduler:     scheduler,
+		persistence:   persistence,
 		loadBalancers: make(map[string]*loadBalancer),
 		aliases:       serviceAliases,
 		ipToEndpoint:  setmatrix.NewSetMatrix(),
@@ -222,9 +225,11 @@ func makeServiceCleanupFunc(c *controller, s *service, nID, eID string, vip net.
 	}
 }
 
-func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName string, vip net.IP, ingressPorts []*PortConfig, serviceAliases, taskAliases []string, ip net.IP, method string) error {
+func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName string, vip net.IP, ingressPorts []*PortConfig, scheduler string, persistence time.Duration, weight int, serviceAliases, taskAliases []string, ip net.IP, method string) error {
 	var addService bool
 
+	unhealthy := c.isEndpointUnhealthy(nID, eID)
+
 	// Failure to lock the network ID on add can result in racing
 	// racing against network deletion resulting in inconsistent
 	// state in the c.serviceBindings map and it's sub-maps. Also,
@@ -250,7 +255,7 @@ func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName s
 		if !ok {
 			// Create a new service if we are seeing this service
 			// for the first time.
-			s = newService(svcName, svcID, ingressPorts, serviceAliases)
+			s = newService(svcName, svcID, ingressPorts, scheduler, persistence, serviceAliases)
 			c.serviceBindings[skey] = s
 		}
 		c.Unlock()
@@ -285,7 +290,7 @@ func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName s
 		addService = true
 	}
 
-	lb.backEnds[eID] = &lbBackend{ip, false}
+	lb.backEnds[eID] = &lbBackend{ip: ip, unhealthy: unhealthy, weight: weight}
 
 	ok, entries := s.assignIPToEndpoint(ip.String(), eID)
 	if !ok || entries > 1 {
@@ -296,8 +301,11 @@ func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName s
 		logrus.Warnf("addServiceBinding %s possible transient state ok:%t entries:%d set:%t %s", eID, ok, entries, b, setStr)
 	}
 
-	// Add loadbalancer service and backend to the network
-	n.(*network).addLBBackend(ip, lb)
+	// Add loadbalancer service and backend to the network, the backend of
+	// an unhealthy endpoint is added once healthy
+	if !unhealthy {
+		n.(*network).addLBBackend(ip, lb)
+	}
 
 	// Add the appropriate name resolutions
 	c.addEndpointNameResolution(svcName, svcID, nID, eID, containerName, vip, serviceAliases, taskAliases, ip, addService, "addServiceBinding")
@@ -307,10 +315,75 @@ func (c *controller) addServiceBinding(svcName, svcID, nID, eID, containerName s
 	return nil
 }
 
+// setServiceBindingHealth takes the backend of an unhealthy endpoint out of
+// the load balancer of its service, and puts it back once healthy. The
+// disabled backends are left as they are.
+func (c *controller) setServiceBindingHealth(nID, eID string, healthy bool) {
+	c.Lock()
+	services := make([]*service, 0, len(c.serviceBindings))
+	for _, s := range c.serviceBindings {
+		services = append(services, s)
+	}
+	c.Unlock()
+
+	for _, s := range services {
+		s.Lock()
+		lb, ok := s.loadBalancers[nID]
+		if !ok {
+			s.Unlock()
+			continue
+		}
+		be, ok := lb.backEnds[eID]
+		if !ok || be.unhealthy == !healthy {
+			s.Unlock()
+			continue
+		}
+		be.unhealthy = !healthy
+		if !be.disabled {
+			if n, err := c.NetworkByID(nID); err == nil {
+				logrus.Debugf("setServiceBindingHealth %s %s healthy:%t", s.name, eID, healthy)
+				if healthy {
+					n.(*network).addLBBackend(be.ip, lb)
+				} else {
+					n.(*network).rmLBBackend(be.ip, lb, false, true)
+				}
+			}
+		}
+		s.Unlock()
+	}
+}
+
+// drainLBBackend deletes a deweighted backend from the load balancer once the
+// drain period is over, unless the load balancer is gone or a backend got the
+// same IP in the meantime
+func (c *controller) drainLBBackend(nID string, ip net.IP, lb *loadBalancer, drain time.Duration) {
+	time.AfterFunc(drain, func() {
+		s := lb.service
+		s.Lock()
+		defer s.Unlock()
+		if s.loadBalancers[nID] != lb {
+			return
+		}
+		for _, be := range lb.backEnds {
+			if be.ip.Equal(ip) {
+				return
+			}
+		}
+		n, err := c.NetworkByID(nID)
+		if err != nil {
+			return
+		}
+		logrus.Debugf("drainLBBackend %s drained from %s in network %s", ip, s.name, nID)
+		n.(*network).rmLBBackend(ip, lb, false, true)
+	})
+}
+
 func (c *controller) rmServiceBinding(svcName, svcID, nID, eID, containerName string, vip net.IP, ingressPorts []*PortConfig, serviceAliases []string, taskAliases []string, ip net.IP, method string, deleteSvcRecords bool, fullRemove bool) error {
 
 	var rmService bool
 
+	drain := c.Config().Daemon.DrainPeriod
+
 	skey := serviceKey{
 		id:    svcID,
 		ports: portConfigs(ingressPorts).String(),
@@ -374,7 +447,15 @@ func (c *controller) rmServiceBinding(svcName, svcID, nID, eID, containerName st
 		// service bindings.
 		n, err := c.NetworkByID(nID)
 		if err == nil {
-			n.(*network).rmLBBackend(ip, lb, rmService, fullRemove)
+			if fullRemove && !rmService && drain > 0 {
+				// Deweight the backend and let its connections
+				// drain. The last backend goes away right away
+				// with the service.
+				n.(*network).rmLBBackend(ip, lb, false, false)
+				c.drainLBBackend(nID, ip, lb, drain)
+			} else {
+				n.(*network).rmLBBackend(ip, lb, rmService, fullRemove)
+			}
 		}
 	}
 
@@ -110,3 +110,25 @@ func TestDNSOptions(t *testing.T) {
 	err = sb2.(*sandbox).rebuildDNS()
 	assert.Error(t, err, "invalid number for ndots option: -1")
 }
+
+func TestValidLBScheduler(t *testing.T) {
+	for _, s := range append([]string{""}, LBSchedulers...) {
+		assert.Check(t, validLBScheduler(s), s)
+	}
+	for _, s := range []string{"dh", "RR", "round-robin"} {
+		assert.Check(t, !validLBScheduler(s), s)
+	}
+}
+
+func TestLBCountersAdd(t *testing.T) {
+	sum := &LBCounters{}
+	sum.Add(&LBCounters{ActiveConnections: 1, Connections: 10, PacketsIn: 5, BytesOut: 100})
+	sum.Add(&LBCounters{ActiveConnections: 2, InactiveConnections: 1, Connections: 3, PacketsIn: 1, BytesOut: 50})
+	assert.Check(t, is.DeepEqual(*sum, LBCounters{
+		ActiveConnections:   3,
+		InactiveConnections: 1,
+		Connections:         13,
+		PacketsIn:           6,
+		BytesOut:            150,
+	}))
+}
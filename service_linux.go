@@ -13,11 +13,13 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork/iptables"
 	"github.com/docker/libnetwork/ipvs"
 	"github.com/docker/libnetwork/ns"
+	"github.com/docker/libnetwork/types"
 	"github.com/gogo/protobuf/proto"
 	"github.com/ishidawataru/sctp"
 	"github.com/sirupsen/logrus"
@@ -48,6 +50,32 @@ func (sb *sandbox) populateLoadBalancers(ep *endpoint) {
 	}
 }
 
+// ipvsHandle is the part of *ipvs.Handle programming the load balancers
+type ipvsHandle interface {
+	IsServicePresent(s *ipvs.Service) bool
+	NewService(s *ipvs.Service) error
+	DelService(s *ipvs.Service) error
+	NewDestination(s *ipvs.Service, d *ipvs.Destination) error
+	UpdateDestination(s *ipvs.Service, d *ipvs.Destination) error
+	DelDestination(s *ipvs.Service, d *ipvs.Destination) error
+	GetDestinations(s *ipvs.Service) ([]*ipvs.Destination, error)
+	NewSyncDaemon(d *ipvs.SyncDaemon) error
+	Close()
+}
+
+// newIPVSHandle returns the ipvs handle of the namespace at path
+var newIPVSHandle = func(path string) (ipvsHandle, error) {
+	i, err := ipvs.New(path)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// lbEndpointSandbox returns the load balancing endpoint of the network and
+// its sandbox
+var lbEndpointSandbox = (*network).findLBEndpointSandbox
+
 func (n *network) findLBEndpointSandbox() (*endpoint, *sandbox, error) {
 	// TODO: get endpoint from store?  See EndpointInfo()
 	var ep *endpoint
@@ -87,13 +115,127 @@ func findIfaceDstName(sb *sandbox, ep *endpoint) string {
 	return ""
 }
 
+// ipvsScheduler returns the IPVS scheduler programmed for the service, the
+// default weighted round robin balances like round robin when all the
+// backends have the same weight
+func (s *service) ipvsScheduler() string {
+	if s.scheduler == "" {
+		return ipvs.WeightedRoundRobin
+	}
+	return s.scheduler
+}
+
+// backendWeight returns the IPVS weight of the backend with the passed IP
+func (lb *loadBalancer) backendWeight(ip net.IP) int {
+	for _, be := range lb.backEnds {
+		if be.ip.Equal(ip) && be.weight > 0 {
+			return be.weight
+		}
+	}
+	return 1
+}
+
+// ipvsService returns the IPVS service programmed for the load balancer. The
+// session affinity is per client IP address.
+func (lb *loadBalancer) ipvsService() *ipvs.Service {
+	s := &ipvs.Service{
+		AddressFamily: nl.FAMILY_V4,
+		FWMark:        lb.fwMark,
+		SchedName:     lb.service.ipvsScheduler(),
+	}
+	if lb.service.persistence > 0 {
+		s.Flags = ipvs.SvcFlagPersistent
+		s.Timeout = uint32(lb.service.persistence / time.Second)
+		s.Netmask = 0xFFFFFFFF
+	}
+	return s
+}
+
+// ipvsFwdMethod returns the IPVS forwarding method to the backends of the
+// load balancers of the network
+func (n *network) ipvsFwdMethod() uint32 {
+	switch n.loadBalancerMode {
+	case loadBalancerModeDSR:
+		return ipvs.ConnFwdDirectRoute
+	case loadBalancerModeTunnel:
+		return ipvs.ConnFwdTunnel
+	}
+	return ipvs.ConnFwdMasq
+}
+
+// lbCounters returns the IPVS counters of the backends of the load balancer,
+// keyed by backend IP
+func (n *network) lbCounters(lb *loadBalancer) (map[string]*LBCounters, error) {
+	if len(lb.vip) == 0 {
+		return nil, nil
+	}
+	_, sb, err := lbEndpointSandbox(n)
+	if err != nil {
+		return nil, err
+	}
+	if sb.osSbox == nil {
+		return nil, nil
+	}
+	i, err := newIPVSHandle(sb.Key())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create an ipvs handle for sbox %.7s: %v", sb.ID(), err)
+	}
+	defer i.Close()
+
+	dsts, err := i.GetDestinations(lb.ipvsService())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the real servers of fwmark %d: %v", lb.fwMark, err)
+	}
+	counters := make(map[string]*LBCounters, len(dsts))
+	for _, d := range dsts {
+		counters[d.Address.String()] = &LBCounters{
+			ActiveConnections:   d.ActiveConnections,
+			InactiveConnections: d.InactiveConnections,
+			Connections:         uint64(d.Stats.Connections),
+			PacketsIn:           uint64(d.Stats.PacketsIn),
+			PacketsOut:          uint64(d.Stats.PacketsOut),
+			BytesIn:             d.Stats.BytesIn,
+			BytesOut:            d.Stats.BytesOut,
+		}
+	}
+	return counters, nil
+}
+
+// startIPVSSync starts the IPVS connection synchronization daemons of the
+// ingress sandbox when enabled, once. Both the master and the backup daemons
+// run: every node load balances and takes over the others' connections.
+func (sb *sandbox) startIPVSSync(i ipvsHandle, ingressIfName string) {
+	cfg := sb.controller.Config().Daemon.IPVSSync
+	if !cfg.Enable {
+		return
+	}
+	sb.Lock()
+	started := sb.ipvsSyncStarted
+	sb.ipvsSyncStarted = true
+	sb.Unlock()
+	if started {
+		return
+	}
+
+	ifName := cfg.Interface
+	if ifName == "" {
+		ifName = ingressIfName
+	}
+	for _, state := range []uint32{ipvs.SyncMaster, ipvs.SyncBackup} {
+		d := &ipvs.SyncDaemon{State: state, McastInterface: ifName, SyncID: uint32(cfg.SyncID)}
+		if err := i.NewSyncDaemon(d); err != nil && err != syscall.EEXIST {
+			logrus.Errorf("Failed to start the ipvs sync daemon %d on %s in sbox %.7s: %v", state, ifName, sb.ID(), err)
+		}
+	}
+}
+
 // Add loadbalancer backend to the loadbalncer sandbox for the network.
 // If needed add the service as well.
 func (n *network) addLBBackend(ip net.IP, lb *loadBalancer) {
 	if len(lb.vip) == 0 {
 		return
 	}
-	ep, sb, err := n.findLBEndpointSandbox()
+	ep, sb, err := lbEndpointSandbox(n)
 	if err != nil {
 		logrus.Errorf("addLBBackend %s/%s: %v", n.ID(), n.Name(), err)
 		return
@@ -104,18 +246,14 @@ func (n *network) addLBBackend(ip net.IP, lb *loadBalancer) {
 
 	eIP := ep.Iface().Address()
 
-	i, err := ipvs.New(sb.Key())
+	i, err := newIPVSHandle(sb.Key())
 	if err != nil {
 		logrus.Errorf("Failed to create an ipvs handle for sbox %.7s (%.7s,%s) for lb addition: %v", sb.ID(), sb.ContainerID(), sb.Key(), err)
 		return
 	}
 	defer i.Close()
 
-	s := &ipvs.Service{
-		AddressFamily: nl.FAMILY_V4,
-		FWMark:        lb.fwMark,
-		SchedName:     ipvs.RoundRobin,
-	}
+	s := lb.ipvsService()
 
 	if !i.IsServicePresent(s) {
 		// Add IP alias for the VIP to the endpoint
@@ -135,10 +273,11 @@ func (n *network) addLBBackend(ip net.IP, lb *loadBalancer) {
 			if ep := sb.getGatewayEndpoint(); ep != nil {
 				gwIP = ep.Iface().Address().IP
 			}
-			if err := programIngress(gwIP, lb.service.ingressPorts, false); err != nil {
+			if err := programIngress(gwIP, "network:"+n.ID(), lb.service.ingressPorts, false); err != nil {
 				logrus.Errorf("Failed to add ingress: %v", err)
 				return
 			}
+			sb.startIPVSSync(i, ifName)
 		}
 
 		logrus.Debugf("Creating service for vip %s fwMark %d ingressPorts %#v in sbox %.7s (%.7s)", lb.vip, lb.fwMark, lb.service.ingressPorts, sb.ID(), sb.ContainerID())
@@ -156,16 +295,19 @@ func (n *network) addLBBackend(ip net.IP, lb *loadBalancer) {
 	d := &ipvs.Destination{
 		AddressFamily: nl.FAMILY_V4,
 		Address:       ip,
-		Weight:        1,
-	}
-	if n.loadBalancerMode == loadBalancerModeDSR {
-		d.ConnectionFlags = ipvs.ConnFwdDirectRoute
+		Weight:        lb.backendWeight(ip),
 	}
+	d.ConnectionFlags = n.ipvsFwdMethod()
 
 	// Remove the sched name before using the service to add
 	// destination.
 	s.SchedName = ""
-	if err := i.NewDestination(s, d); err != nil && err != syscall.EEXIST {
+	err = i.NewDestination(s, d)
+	if err == syscall.EEXIST {
+		// the real server may have been deweighted or had another weight
+		err = i.UpdateDestination(s, d)
+	}
+	if err != nil {
 		logrus.Errorf("Failed to create real server %s for vip %s fwmark %d in sbox %.7s (%.7s): %v", ip, lb.vip, lb.fwMark, sb.ID(), sb.ContainerID(), err)
 	}
 }
@@ -178,7 +320,7 @@ func (n *network) rmLBBackend(ip net.IP, lb *loadBalancer, rmService bool, fullR
 	if len(lb.vip) == 0 {
 		return
 	}
-	ep, sb, err := n.findLBEndpointSandbox()
+	ep, sb, err := lbEndpointSandbox(n)
 	if err != nil {
 		logrus.Debugf("rmLBBackend for %s/%s: %v -- probably transient state", n.ID(), n.Name(), err)
 		return
@@ -189,7 +331,7 @@ func (n *network) rmLBBackend(ip net.IP, lb *loadBalancer, rmService bool, fullR
 
 	eIP := ep.Iface().Address()
 
-	i, err := ipvs.New(sb.Key())
+	i, err := newIPVSHandle(sb.Key())
 	if err != nil {
 		logrus.Errorf("Failed to create an ipvs handle for sbox %.7s (%.7s,%s) for lb removal: %v", sb.ID(), sb.ContainerID(), sb.Key(), err)
 		return
@@ -206,9 +348,7 @@ func (n *network) rmLBBackend(ip net.IP, lb *loadBalancer, rmService bool, fullR
 		Address:       ip,
 		Weight:        1,
 	}
-	if n.loadBalancerMode == loadBalancerModeDSR {
-		d.ConnectionFlags = ipvs.ConnFwdDirectRoute
-	}
+	d.ConnectionFlags = n.ipvsFwdMethod()
 
 	if fullRemove {
 		if err := i.DelDestination(s, d); err != nil && err != syscall.ENOENT {
@@ -222,7 +362,7 @@ func (n *network) rmLBBackend(ip net.IP, lb *loadBalancer, rmService bool, fullR
 	}
 
 	if rmService {
-		s.SchedName = ipvs.RoundRobin
+		s.SchedName = lb.service.ipvsScheduler()
 		if err := i.DelService(s); err != nil && err != syscall.ENOENT {
 			logrus.Errorf("Failed to delete service for vip %s fwmark %d in sbox %.7s (%.7s): %v", lb.vip, lb.fwMark, sb.ID(), sb.ContainerID(), err)
 		}
@@ -232,7 +372,7 @@ func (n *network) rmLBBackend(ip net.IP, lb *loadBalancer, rmService bool, fullR
 			if ep := sb.getGatewayEndpoint(); ep != nil {
 				gwIP = ep.Iface().Address().IP
 			}
-			if err := programIngress(gwIP, lb.service.ingressPorts, true); err != nil {
+			if err := programIngress(gwIP, "network:"+n.ID(), lb.service.ingressPorts, true); err != nil {
 				logrus.Errorf("Failed to delete ingress: %v", err)
 			}
 		}
@@ -260,8 +400,15 @@ var (
 	ingressOnce     sync.Once
 	ingressMu       sync.Mutex // lock for operations on ingress
 	ingressProxyTbl = make(map[string]io.Closer)
-	portConfigMu    sync.Mutex
-	portConfigTbl   = make(map[PortConfig]int)
+	// l7ProxyTbl maps the published ports redirected to a local proxy to
+	// the port of the proxy, ingressGwIP is the ingress sandbox address
+	// on the gateway bridge and ingressOwner the owner of the rules of the
+	// ingress network. They are protected by ingressMu.
+	l7ProxyTbl    = make(map[uint32]uint16)
+	ingressGwIP   net.IP
+	ingressOwner  string
+	portConfigMu  sync.Mutex
+	portConfigTbl = make(map[PortConfig]int)
 )
 
 func filterPortConfigs(ingressPorts []*PortConfig, isDelete bool) []*PortConfig {
@@ -301,7 +448,9 @@ func filterPortConfigs(ingressPorts []*PortConfig, isDelete bool) []*PortConfig
 	return iPorts
 }
 
-func programIngress(gwIP net.IP, ingressPorts []*PortConfig, isDelete bool) error {
+// programIngress programs the rules of the published ports of the ingress
+// network, tagged with its owner when the rules are tagged
+func programIngress(gwIP net.IP, owner string, ingressPorts []*PortConfig, isDelete bool) error {
 	addDelOpt := "-I"
 	rollbackAddDelOpt := "-D"
 	if isDelete {
@@ -369,6 +518,8 @@ func programIngress(gwIP net.IP, ingressPorts []*PortConfig, isDelete bool) erro
 			arrangeUserFilterRule()
 		}
 
+		ingressGwIP, ingressOwner = gwIP, owner
+
 		oifName, err := findOIFName(gwIP)
 		if err != nil {
 			return fmt.Errorf("failed to find gateway bridge interface name for %s: %v", gwIP, err)
@@ -379,7 +530,7 @@ func programIngress(gwIP net.IP, ingressPorts []*PortConfig, isDelete bool) erro
 			return fmt.Errorf("could not write to %s: %v", path, err)
 		}
 
-		ruleArgs := strings.Fields(fmt.Sprintf("-m addrtype --src-type LOCAL -o %s -j MASQUERADE", oifName))
+		ruleArgs := iptables.OwnedArgs(owner, strings.Fields(fmt.Sprintf("-m addrtype --src-type LOCAL -o %s -j MASQUERADE", oifName)))
 		if !iptables.Exists(iptables.Nat, "POSTROUTING", ruleArgs...) {
 			if err := iptables.RawCombinedOutput(append([]string{"-t", "nat", "-I", "POSTROUTING"}, ruleArgs...)...); err != nil {
 				return fmt.Errorf("failed to add ingress localhost POSTROUTING rule for %s: %v", oifName, err)
@@ -404,8 +555,7 @@ func programIngress(gwIP net.IP, ingressPorts []*PortConfig, isDelete bool) erro
 
 	for _, iPort := range filteredPorts {
 		if iptables.ExistChain(ingressChain, iptables.Nat) {
-			rule := strings.Fields(fmt.Sprintf("-t nat %s %s -p %s --dport %d -j DNAT --to-destination %s:%d",
-				addDelOpt, ingressChain, strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.PublishedPort, gwIP, iPort.PublishedPort))
+			rule := append([]string{"-t", "nat", addDelOpt, ingressChain}, ingressNatRule(iPort, gwIP, owner)...)
 			if portErr = iptables.RawCombinedOutput(rule...); portErr != nil {
 				errStr := fmt.Sprintf("set up rule failed, %v: %v", rule, portErr)
 				if !isDelete {
@@ -413,16 +563,16 @@ func programIngress(gwIP net.IP, ingressPorts []*PortConfig, isDelete bool) erro
 				}
 				logrus.Infof("%s", errStr)
 			}
-			rollbackRule := strings.Fields(fmt.Sprintf("-t nat %s %s -p %s --dport %d -j DNAT --to-destination %s:%d", rollbackAddDelOpt,
-				ingressChain, strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.PublishedPort, gwIP, iPort.PublishedPort))
+			rollbackRule := append([]string{"-t", "nat", rollbackAddDelOpt, ingressChain}, ingressNatRule(iPort, gwIP, owner)...)
 			rollbackRules = append(rollbackRules, rollbackRule)
 		}
 
 		// Filter table rules to allow a published service to be accessible in the local node from..
 		// 1) service tasks attached to other networks
 		// 2) unmanaged containers on bridge networks
-		rule := strings.Fields(fmt.Sprintf("%s %s -m state -p %s --sport %d --state ESTABLISHED,RELATED -j ACCEPT",
-			addDelOpt, ingressChain, strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.PublishedPort))
+		proto := strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)])
+		establishedArgs := iptables.OwnedArgs(owner, strings.Fields(fmt.Sprintf("-m state -p %s --sport %d --state ESTABLISHED,RELATED -j ACCEPT", proto, iPort.PublishedPort)))
+		rule := append([]string{addDelOpt, ingressChain}, establishedArgs...)
 		if portErr = iptables.RawCombinedOutput(rule...); portErr != nil {
 			errStr := fmt.Sprintf("set up rule failed, %v: %v", rule, portErr)
 			if !isDelete {
@@ -430,12 +580,11 @@ func programIngress(gwIP net.IP, ingressPorts []*PortConfig, isDelete bool) erro
 			}
 			logrus.Warnf("%s", errStr)
 		}
-		rollbackRule := strings.Fields(fmt.Sprintf("%s %s -m state -p %s --sport %d --state ESTABLISHED,RELATED -j ACCEPT", rollbackAddDelOpt,
-			ingressChain, strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.PublishedPort))
+		rollbackRule := append([]string{rollbackAddDelOpt, ingressChain}, establishedArgs...)
 		rollbackRules = append(rollbackRules, rollbackRule)
 
-		rule = strings.Fields(fmt.Sprintf("%s %s -p %s --dport %d -j ACCEPT",
-			addDelOpt, ingressChain, strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.PublishedPort))
+		acceptArgs := iptables.OwnedArgs(owner, strings.Fields(fmt.Sprintf("-p %s --dport %d -j ACCEPT", proto, iPort.PublishedPort)))
+		rule = append([]string{addDelOpt, ingressChain}, acceptArgs...)
 		if portErr = iptables.RawCombinedOutput(rule...); portErr != nil {
 			errStr := fmt.Sprintf("set up rule failed, %v: %v", rule, portErr)
 			if !isDelete {
@@ -443,8 +592,7 @@ func programIngress(gwIP net.IP, ingressPorts []*PortConfig, isDelete bool) erro
 			}
 			logrus.Warnf("%s", errStr)
 		}
-		rollbackRule = strings.Fields(fmt.Sprintf("%s %s -p %s --dport %d -j ACCEPT", rollbackAddDelOpt,
-			ingressChain, strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)]), iPort.PublishedPort))
+		rollbackRule = append([]string{rollbackAddDelOpt, ingressChain}, acceptArgs...)
 		rollbackRules = append(rollbackRules, rollbackRule)
 
 		if err := plumbProxy(iPort, isDelete); err != nil {
@@ -455,6 +603,76 @@ func programIngress(gwIP net.IP, ingressPorts []*PortConfig, isDelete bool) erro
 	return nil
 }
 
+// ingressNatRule returns the nat table rule of the ingress chain for a
+// published port, less the table and chain: the traffic goes to the ingress
+// sandbox at gwIP, or to the local proxy registered for the port. The rule
+// is tagged with the owner when the rules are tagged.
+func ingressNatRule(iPort *PortConfig, gwIP net.IP, owner string) []string {
+	proto := strings.ToLower(PortConfig_Protocol_name[int32(iPort.Protocol)])
+	if proxyPort, ok := l7ProxyTbl[iPort.PublishedPort]; ok && iPort.Protocol == ProtocolTCP {
+		return iptables.OwnedArgs(owner, strings.Fields(fmt.Sprintf("-p %s --dport %d -j REDIRECT --to-ports %d", proto, iPort.PublishedPort, proxyPort)))
+	}
+	return iptables.OwnedArgs(owner, strings.Fields(fmt.Sprintf("-p %s --dport %d -j DNAT --to-destination %s:%d", proto, iPort.PublishedPort, gwIP, iPort.PublishedPort)))
+}
+
+// setIngressProxy registers, or removes, the local proxy of a TCP published
+// port and swaps the ingress rule of the port if already programmed
+func setIngressProxy(publishedPort uint32, proxyPort uint16, isDelete bool) error {
+	ingressMu.Lock()
+	defer ingressMu.Unlock()
+
+	iPort := &PortConfig{Protocol: ProtocolTCP, PublishedPort: publishedPort}
+	var current []string
+	if ingressGwIP != nil {
+		if rule := ingressNatRule(iPort, ingressGwIP, ingressOwner); iptables.Exists(iptables.Nat, ingressChain, rule...) {
+			current = rule
+		}
+	}
+
+	old, ok := l7ProxyTbl[publishedPort]
+	if isDelete {
+		if !ok {
+			return types.NotFoundErrorf("no ingress proxy registered for published port %d", publishedPort)
+		}
+		delete(l7ProxyTbl, publishedPort)
+	} else {
+		if ok {
+			if old != proxyPort {
+				return types.ForbiddenErrorf("published port %d is already redirected to proxy port %d", publishedPort, old)
+			}
+			return nil
+		}
+		l7ProxyTbl[publishedPort] = proxyPort
+	}
+
+	if current == nil {
+		// the port is not published yet, its rule is programmed with the proxy
+		return nil
+	}
+	rule := ingressNatRule(iPort, ingressGwIP, ingressOwner)
+	if err := iptables.RawCombinedOutput(append([]string{"-t", "nat", "-I", ingressChain}, rule...)...); err != nil {
+		if isDelete {
+			l7ProxyTbl[publishedPort] = old
+		} else {
+			delete(l7ProxyTbl, publishedPort)
+		}
+		return fmt.Errorf("failed to redirect the ingress published port %d: %v", publishedPort, err)
+	}
+	if err := iptables.RawCombinedOutput(append([]string{"-t", "nat", "-D", ingressChain}, current...)...); err != nil {
+		logrus.Warnf("Failed to remove the previous ingress rule %v: %v", current, err)
+	}
+	return nil
+}
+
+func ingressProxyUpstream(publishedPort uint32) (string, error) {
+	ingressMu.Lock()
+	defer ingressMu.Unlock()
+	if ingressGwIP == nil {
+		return "", types.NotFoundErrorf("ingress not programmed on this node")
+	}
+	return net.JoinHostPort(ingressGwIP.String(), strconv.Itoa(int(publishedPort))), nil
+}
+
 // In the filter table FORWARD chain the first rule should be to jump to
 // DOCKER-USER so the user is able to filter packet first.
 // The second rule should be jump to INGRESS-CHAIN.
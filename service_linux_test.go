@@ -0,0 +1,409 @@
+package libnetwork
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/ipvs"
+	"github.com/docker/libnetwork/networkdb"
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+	"github.com/gogo/protobuf/proto"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestIngressNatRule(t *testing.T) {
+	gwIP := net.ParseIP("172.18.0.2")
+	tcp := &PortConfig{Protocol: ProtocolTCP, PublishedPort: 8080}
+	udp := &PortConfig{Protocol: ProtocolUDP, PublishedPort: 8080}
+
+	assert.Equal(t, strings.Join(ingressNatRule(tcp, gwIP, "network:n1"), " "), "-p tcp --dport 8080 -j DNAT --to-destination 172.18.0.2:8080")
+
+	l7ProxyTbl[8080] = 9090
+	defer delete(l7ProxyTbl, 8080)
+	assert.Equal(t, strings.Join(ingressNatRule(tcp, gwIP, "network:n1"), " "), "-p tcp --dport 8080 -j REDIRECT --to-ports 9090")
+	// only the TCP traffic goes to the proxy
+	assert.Equal(t, strings.Join(ingressNatRule(udp, gwIP, "network:n1"), " "), "-p udp --dport 8080 -j DNAT --to-destination 172.18.0.2:8080")
+
+	defer iptables.SetOwnerTagging(iptables.OwnerTagging())
+	iptables.SetOwnerTagging(true)
+	assert.Equal(t, strings.Join(ingressNatRule(udp, gwIP, "network:n1"), " "), "-p udp --dport 8080 -m comment --comment libnetwork:network:n1 -j DNAT --to-destination 172.18.0.2:8080")
+}
+
+// fakeIPVS is an ipvs handle keeping the real servers of the services in
+// memory. The services are always present, so that the load balancers
+// only program their real servers.
+type fakeIPVS struct {
+	sync.Mutex
+	// the real servers by firewall mark and address
+	dsts map[uint32]map[string]*ipvs.Destination
+	// the services of the added real servers
+	services []*ipvs.Service
+	deleted  []uint32
+	// the addresses of the real servers deleted, or attempted to
+	removed []string
+}
+
+func (f *fakeIPVS) IsServicePresent(s *ipvs.Service) bool { return true }
+
+func (f *fakeIPVS) NewService(s *ipvs.Service) error { return nil }
+
+func (f *fakeIPVS) DelService(s *ipvs.Service) error {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.dsts, s.FWMark)
+	f.deleted = append(f.deleted, s.FWMark)
+	return nil
+}
+
+func (f *fakeIPVS) NewDestination(s *ipvs.Service, d *ipvs.Destination) error {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.dsts[s.FWMark][d.Address.String()]; ok {
+		return syscall.EEXIST
+	}
+	if f.dsts[s.FWMark] == nil {
+		f.dsts[s.FWMark] = map[string]*ipvs.Destination{}
+	}
+	dst := *d
+	f.dsts[s.FWMark][d.Address.String()] = &dst
+	svc := *s
+	f.services = append(f.services, &svc)
+	return nil
+}
+
+func (f *fakeIPVS) UpdateDestination(s *ipvs.Service, d *ipvs.Destination) error {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.dsts[s.FWMark][d.Address.String()]; !ok {
+		return syscall.ENOENT
+	}
+	dst := *d
+	f.dsts[s.FWMark][d.Address.String()] = &dst
+	return nil
+}
+
+func (f *fakeIPVS) DelDestination(s *ipvs.Service, d *ipvs.Destination) error {
+	f.Lock()
+	defer f.Unlock()
+	f.removed = append(f.removed, d.Address.String())
+	if _, ok := f.dsts[s.FWMark][d.Address.String()]; !ok {
+		return syscall.ENOENT
+	}
+	delete(f.dsts[s.FWMark], d.Address.String())
+	return nil
+}
+
+func (f *fakeIPVS) GetDestinations(s *ipvs.Service) ([]*ipvs.Destination, error) {
+	f.Lock()
+	defer f.Unlock()
+	var dsts []*ipvs.Destination
+	for _, d := range f.dsts[s.FWMark] {
+		dsts = append(dsts, d)
+	}
+	return dsts, nil
+}
+
+func (f *fakeIPVS) NewSyncDaemon(d *ipvs.SyncDaemon) error { return nil }
+
+func (f *fakeIPVS) Close() {}
+
+// weights returns the weights of the real servers of the firewall mark by
+// address
+func (f *fakeIPVS) weights(fwMark uint32) map[string]int {
+	f.Lock()
+	defer f.Unlock()
+	weights := map[string]int{}
+	for addr, d := range f.dsts[fwMark] {
+		weights[addr] = d.Weight
+	}
+	return weights
+}
+
+// lbTestSandbox is the namespace of the load balancing sandbox of the tests
+type lbTestSandbox struct {
+	osl.Sandbox
+}
+
+func (lbTestSandbox) Info() osl.Info { return lbTestInfo{} }
+
+// lbTestInfo has no interfaces to alias the VIP on
+type lbTestInfo struct {
+	osl.Info
+}
+
+func (lbTestInfo) Interfaces() []osl.Interface { return nil }
+
+// newLBTest returns a controller with a network whose load balancers are
+// programmed in the returned ipvs handle, and the function restoring the
+// ipvs seams and cleaning up
+func newLBTest(t *testing.T, opts ...config.Option) (*controller, Network, *fakeIPVS, func()) {
+	fake := &fakeIPVS{dsts: map[uint32]map[string]*ipvs.Destination{}}
+	origHandle, origSandbox := newIPVSHandle, lbEndpointSandbox
+	newIPVSHandle = func(string) (ipvsHandle, error) { return fake, nil }
+	lbEndpointSandbox = func(*network) (*endpoint, *sandbox, error) {
+		ep := &endpoint{id: "lb", iface: &endpointInterface{addr: &net.IPNet{IP: net.ParseIP("10.0.0.254"), Mask: net.CIDRMask(24, 32)}}}
+		return ep, &sandbox{id: "lb", osSbox: lbTestSandbox{}}, nil
+	}
+
+	c, err := New(opts...)
+	assert.NilError(t, err)
+	n, err := c.NewNetwork("bridge", "lbnet", "", nil)
+	assert.NilError(t, err)
+	return c.(*controller), n, fake, func() {
+		n.Delete()
+		c.Stop()
+		newIPVSHandle, lbEndpointSandbox = origHandle, origSandbox
+	}
+}
+
+// addTestBackend adds the backend eID at ip to the service svc1 of the VIP
+// 10.0.0.100
+func addTestBackend(t *testing.T, c *controller, n Network, eID, ip string, weight int) {
+	err := c.addServiceBinding("svc1", "svc1", n.ID(), eID, eID, net.ParseIP("10.0.0.100"), nil, "", 0, weight, nil, nil, net.ParseIP(ip), "test")
+	assert.NilError(t, err)
+}
+
+func rmTestBackend(t *testing.T, c *controller, n Network, eID, ip string) {
+	err := c.rmServiceBinding("svc1", "svc1", n.ID(), eID, eID, net.ParseIP("10.0.0.100"), nil, nil, nil, net.ParseIP(ip), "test", true, true)
+	assert.NilError(t, err)
+}
+
+// testFWMark returns the firewall mark of the load balancer of svc1
+func testFWMark(t *testing.T, c *controller, n Network) uint32 {
+	c.Lock()
+	defer c.Unlock()
+	s, ok := c.serviceBindings[serviceKey{id: "svc1", ports: portConfigs(nil).String()}]
+	assert.Assert(t, ok)
+	return s.loadBalancers[n.ID()].fwMark
+}
+
+// waitWeights waits for the real servers of the firewall mark to have the
+// weights
+func waitWeights(t *testing.T, fake *fakeIPVS, fwMark uint32, weights map[string]int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !is.DeepEqual(fake.weights(fwMark), weights)().Success() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the real servers %v, got %v", weights, fake.weights(fwMark))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDrainLBBackend(t *testing.T) {
+	drain := 100 * time.Millisecond
+	c, n, fake, cleanup := newLBTest(t, config.OptionDrainPeriod(drain))
+	defer cleanup()
+
+	addTestBackend(t, c, n, "ep1", "10.0.0.2", 0)
+	addTestBackend(t, c, n, "ep2", "10.0.0.3", 0)
+	fwMark := testFWMark(t, c, n)
+	assert.Check(t, is.DeepEqual(fake.weights(fwMark), map[string]int{"10.0.0.2": 1, "10.0.0.3": 1}))
+
+	// the removed backend keeps its connections with no new ones until the
+	// drain is over
+	rmTestBackend(t, c, n, "ep1", "10.0.0.2")
+	assert.Check(t, is.DeepEqual(fake.weights(fwMark), map[string]int{"10.0.0.2": 0, "10.0.0.3": 1}))
+	waitWeights(t, fake, fwMark, map[string]int{"10.0.0.3": 1})
+}
+
+func TestDrainLBBackendReAdded(t *testing.T) {
+	drain := 100 * time.Millisecond
+	c, n, fake, cleanup := newLBTest(t, config.OptionDrainPeriod(drain))
+	defer cleanup()
+
+	addTestBackend(t, c, n, "ep1", "10.0.0.2", 0)
+	addTestBackend(t, c, n, "ep2", "10.0.0.3", 0)
+	fwMark := testFWMark(t, c, n)
+
+	// a backend getting the address of the draining one cancels the drain
+	rmTestBackend(t, c, n, "ep1", "10.0.0.2")
+	addTestBackend(t, c, n, "ep3", "10.0.0.2", 2)
+	expected := map[string]int{"10.0.0.2": 2, "10.0.0.3": 1}
+	assert.Check(t, is.DeepEqual(fake.weights(fwMark), expected))
+	time.Sleep(3 * drain)
+	assert.Check(t, is.DeepEqual(fake.weights(fwMark), expected))
+}
+
+func TestDrainLBBackendServiceRemoved(t *testing.T) {
+	drain := 100 * time.Millisecond
+	c, n, fake, cleanup := newLBTest(t, config.OptionDrainPeriod(drain))
+	defer cleanup()
+
+	addTestBackend(t, c, n, "ep1", "10.0.0.2", 0)
+	addTestBackend(t, c, n, "ep2", "10.0.0.3", 0)
+	fwMark := testFWMark(t, c, n)
+
+	// the last backend goes away with the service right away, and the
+	// drain of the other one finds its load balancer gone
+	rmTestBackend(t, c, n, "ep1", "10.0.0.2")
+	rmTestBackend(t, c, n, "ep2", "10.0.0.3")
+	assert.Check(t, is.DeepEqual(fake.deleted, []uint32{fwMark}))
+	assert.Check(t, is.Len(fake.weights(fwMark), 0))
+
+	time.Sleep(3 * drain)
+	fake.Lock()
+	defer fake.Unlock()
+	assert.Check(t, is.DeepEqual(fake.removed, []string{"10.0.0.3"}))
+}
+
+// addRemoteTestBackend adds the backend of the record as the service table
+// events of the cluster do
+func addRemoteTestBackend(t *testing.T, c *controller, n Network, eID string, rec *EndpointRecord) {
+	rec.Name, rec.ServiceName, rec.ServiceID, rec.VirtualIP = eID, "svc1", "svc1", "10.0.0.100"
+	buf, err := proto.Marshal(rec)
+	assert.NilError(t, err)
+	c.handleEpTableEvent(networkdb.CreateEvent{Table: libnetworkEPTable, NetworkID: n.ID(), Key: eID, Value: buf})
+}
+
+func TestLBSessionAffinity(t *testing.T) {
+	s := newService("svc1", "svc1", nil, "", 0, nil)
+	lb := &loadBalancer{fwMark: 1, service: s}
+	svc := lb.ipvsService()
+	assert.Check(t, is.Equal(svc.SchedName, ipvs.WeightedRoundRobin))
+	assert.Check(t, is.Equal(svc.Flags, uint32(0)))
+
+	s = newService("svc1", "svc1", nil, ipvs.SourceHashing, 30*time.Second, nil)
+	lb = &loadBalancer{fwMark: 1, service: s}
+	svc = lb.ipvsService()
+	assert.Check(t, is.Equal(svc.SchedName, ipvs.SourceHashing))
+	assert.Check(t, is.Equal(svc.Flags, uint32(ipvs.SvcFlagPersistent)))
+	assert.Check(t, is.Equal(svc.Timeout, uint32(30)))
+	assert.Check(t, is.Equal(svc.Netmask, uint32(0xFFFFFFFF)))
+}
+
+func TestLBSessionAffinityRemote(t *testing.T) {
+	c, n, fake, cleanup := newLBTest(t)
+	defer cleanup()
+
+	// the affinity of a remote backend comes with its record
+	addRemoteTestBackend(t, c, n, "ep1", &EndpointRecord{EndpointIP: "10.0.0.2", LBScheduler: ipvs.SourceHashing, PersistenceTimeout: 30})
+	c.Lock()
+	s := c.serviceBindings[serviceKey{id: "svc1", ports: portConfigs(nil).String()}]
+	c.Unlock()
+	assert.Assert(t, s != nil)
+	assert.Check(t, is.Equal(s.scheduler, ipvs.SourceHashing))
+	assert.Check(t, is.Equal(s.persistence, 30*time.Second))
+
+	fake.Lock()
+	defer fake.Unlock()
+	assert.Assert(t, is.Len(fake.services, 1))
+	assert.Check(t, is.Equal(fake.services[0].Flags, uint32(ipvs.SvcFlagPersistent)))
+	assert.Check(t, is.Equal(fake.services[0].Timeout, uint32(30)))
+}
+
+func TestServicePersistenceOption(t *testing.T) {
+	_, n, _, cleanup := newLBTest(t)
+	defer cleanup()
+
+	_, err := n.CreateEndpoint("ep1", CreateOptionServicePersistence(1500*time.Millisecond))
+	_, ok := err.(types.BadRequestError)
+	assert.Check(t, ok, "expected a bad request error, got %v", err)
+
+	e, err := n.CreateEndpoint("ep1", CreateOptionServiceScheduler(ipvs.SourceHashing), CreateOptionServicePersistence(30*time.Second))
+	assert.NilError(t, err)
+	defer e.Delete(true)
+
+	// the affinity survives the store
+	b, err := e.(*endpoint).MarshalJSON()
+	assert.NilError(t, err)
+	ep := &endpoint{}
+	assert.NilError(t, ep.UnmarshalJSON(b))
+	assert.Check(t, is.Equal(ep.svcScheduler, ipvs.SourceHashing))
+	assert.Check(t, is.Equal(ep.svcPersistence, 30*time.Second))
+
+	dst := &endpoint{}
+	assert.NilError(t, ep.CopyTo(dst))
+	assert.Check(t, is.Equal(dst.svcPersistence, 30*time.Second))
+}
+
+func TestOverlayLoadBalancerMode(t *testing.T) {
+	for mode, opts := range map[string]map[string]string{
+		loadBalancerModeNAT:    {},
+		loadBalancerModeDSR:    {overlayDSROptionString: ""},
+		loadBalancerModeTunnel: {overlayDSROptionString: overlayDSRTunnel},
+	} {
+		assert.Check(t, is.Equal(overlayLoadBalancerMode(opts), mode))
+	}
+	assert.Check(t, !isDSRMode(loadBalancerModeNAT))
+	assert.Check(t, isDSRMode(loadBalancerModeDSR))
+	assert.Check(t, isDSRMode(loadBalancerModeTunnel))
+}
+
+func TestLBForwardingMethod(t *testing.T) {
+	c, n, fake, cleanup := newLBTest(t)
+	defer cleanup()
+
+	// the real servers are programmed with the forwarding method of the
+	// load balancing mode of the network
+	for i, m := range []struct {
+		mode   string
+		method uint32
+	}{
+		{loadBalancerModeNAT, ipvs.ConnFwdMasq},
+		{loadBalancerModeDSR, ipvs.ConnFwdDirectRoute},
+		{loadBalancerModeTunnel, ipvs.ConnFwdTunnel},
+	} {
+		n.(*network).Lock()
+		n.(*network).loadBalancerMode = m.mode
+		n.(*network).Unlock()
+
+		ip := net.IPv4(10, 0, 0, byte(2+i))
+		addTestBackend(t, c, n, "ep"+m.mode, ip.String(), 0)
+		fwMark := testFWMark(t, c, n)
+		fake.Lock()
+		d := fake.dsts[fwMark][ip.String()]
+		fake.Unlock()
+		assert.Assert(t, d != nil, m.mode)
+		assert.Check(t, is.Equal(d.ConnectionFlags, m.method), m.mode)
+	}
+}
+
+func TestLBBackendWeight(t *testing.T) {
+	c, n, fake, cleanup := newLBTest(t)
+	defer cleanup()
+
+	addTestBackend(t, c, n, "ep1", "10.0.0.2", 0)
+	addTestBackend(t, c, n, "ep2", "10.0.0.3", 5)
+	// the weight of a remote backend comes with its record
+	addRemoteTestBackend(t, c, n, "ep3", &EndpointRecord{EndpointIP: "10.0.0.4", Weight: 3})
+	fwMark := testFWMark(t, c, n)
+	assert.Check(t, is.DeepEqual(fake.weights(fwMark), map[string]int{"10.0.0.2": 1, "10.0.0.3": 5, "10.0.0.4": 3}))
+
+	// the real server already there gets the new weight
+	addTestBackend(t, c, n, "ep2", "10.0.0.3", 2)
+	assert.Check(t, is.DeepEqual(fake.weights(fwMark), map[string]int{"10.0.0.2": 1, "10.0.0.3": 2, "10.0.0.4": 3}))
+}
+
+func TestServiceWeightOption(t *testing.T) {
+	_, n, _, cleanup := newLBTest(t)
+	defer cleanup()
+
+	for _, w := range []int{-1, MaxLBWeight + 1} {
+		_, err := n.CreateEndpoint("ep1", CreateOptionServiceWeight(w))
+		_, ok := err.(types.BadRequestError)
+		assert.Check(t, ok, "expected a bad request error for the weight %d, got %v", w, err)
+	}
+
+	e, err := n.CreateEndpoint("ep1", CreateOptionServiceWeight(MaxLBWeight))
+	assert.NilError(t, err)
+	defer e.Delete(true)
+
+	// the weight survives the store
+	b, err := e.(*endpoint).MarshalJSON()
+	assert.NilError(t, err)
+	ep := &endpoint{}
+	assert.NilError(t, ep.UnmarshalJSON(b))
+	assert.Check(t, is.Equal(ep.svcWeight, MaxLBWeight))
+
+	dst := &endpoint{}
+	assert.NilError(t, ep.CopyTo(dst))
+	assert.Check(t, is.Equal(dst.svcWeight, MaxLBWeight))
+}
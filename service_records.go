@@ -0,0 +1,401 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/go-events"
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/networkdb"
+	"github.com/docker/libnetwork/types"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	serviceRecordsKeyPrefix = "service_records"
+	// the records of a node are refreshed every serviceRecordRefresh and
+	// expire after serviceRecordTTL, so that the records of a dead node go
+	// away
+	serviceRecordTTL     = 30 * time.Second
+	serviceRecordRefresh = serviceRecordTTL / 3
+	serviceRecordRetry   = 5 * time.Second
+)
+
+// serviceRecords is the control plane storing the endpoint table, which
+// carries the service records of the cluster. It is gossiped by NetworkDB
+// unless a consistent KV store is configured.
+type serviceRecords interface {
+	CreateEntry(nid, eid string, value []byte) error
+	UpdateEntry(nid, eid string, value []byte) error
+	DeleteEntry(nid, eid string) error
+	// TakeEntry creates the record of an endpoint migrated from another
+	// node, taking it over from that node
+	TakeEntry(nid, eid string, value []byte) error
+	// ReleaseEntry stops maintaining the record of an endpoint migrated to
+	// another node, without removing it
+	ReleaseEntry(nid, eid string)
+	GetEntry(nid, eid string) ([]byte, error)
+	// GetTableByNetwork returns the records of a network keyed by endpoint
+	GetTableByNetwork(nid string) map[string][]byte
+	// Watch returns the networkdb table events of the records of the other
+	// nodes
+	Watch() (*events.Channel, func())
+	Close()
+}
+
+// gossipServiceRecords keeps the service records in the NetworkDB endpoint
+// table
+type gossipServiceRecords struct {
+	nDB *networkdb.NetworkDB
+}
+
+func (g *gossipServiceRecords) CreateEntry(nid, eid string, value []byte) error {
+	return g.nDB.CreateEntry(libnetworkEPTable, nid, eid, value)
+}
+
+func (g *gossipServiceRecords) UpdateEntry(nid, eid string, value []byte) error {
+	return g.nDB.UpdateEntry(libnetworkEPTable, nid, eid, value)
+}
+
+func (g *gossipServiceRecords) DeleteEntry(nid, eid string) error {
+	return g.nDB.DeleteEntry(libnetworkEPTable, nid, eid)
+}
+
+func (g *gossipServiceRecords) TakeEntry(nid, eid string, value []byte) error {
+	return g.nDB.TakeEntry(libnetworkEPTable, nid, eid, value)
+}
+
+// ReleaseEntry does nothing, the entry is replaced when the other node
+// takes it over
+func (g *gossipServiceRecords) ReleaseEntry(nid, eid string) {}
+
+func (g *gossipServiceRecords) GetEntry(nid, eid string) ([]byte, error) {
+	return g.nDB.GetEntry(libnetworkEPTable, nid, eid)
+}
+
+func (g *gossipServiceRecords) GetTableByNetwork(nid string) map[string][]byte {
+	entries := make(map[string][]byte)
+	for eid, v := range g.nDB.GetTableByNetwork(libnetworkEPTable, nid) {
+		entries[eid] = v.Value
+	}
+	return entries
+}
+
+func (g *gossipServiceRecords) Watch() (*events.Channel, func()) {
+	return g.nDB.Watch(libnetworkEPTable, "", "")
+}
+
+// Close is a no-op, the NetworkDB instance is closed by the agent
+func (g *gossipServiceRecords) Close() {}
+
+// kvServiceRecord is the value of a service record in the KV store
+type kvServiceRecord struct {
+	Node  string `json:"node"`
+	Value []byte `json:"value"`
+}
+
+// kvServiceRecords keeps the service records in a consistent KV store, for
+// the clusters preferring consistency over the eventual convergence of the
+// gossip. The records are flat keys <nid>.<eid> under the prefix, as not
+// all the stores list directories recursively.
+type kvServiceRecords struct {
+	store store.Store
+	node  string
+	// local are the records of this node, refreshed before they expire
+	local  map[string][]byte
+	stopCh chan struct{}
+	sync.Mutex
+}
+
+// serviceRecordsNode returns the ID the records of this node are stored
+// under. It is derived from the hostname and the advertise address rather
+// than generated, so that the records left by the previous run of the
+// daemon are still known as the ones of this node.
+func serviceRecordsNode(advertiseAddr string) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		logrus.Warnf("Failed to get the hostname, the service records of this node get a random ID: %v", err)
+		return stringid.TruncateID(stringid.GenerateRandomID())
+	}
+	return hostname + "/" + advertiseAddr
+}
+
+func newKVServiceRecords(cfg *datastore.ScopeCfg, node string) (*kvServiceRecords, error) {
+	ds, err := datastore.NewDataStore(datastore.GlobalScope, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the service record store: %v", err)
+	}
+	s := &kvServiceRecords{
+		store:  ds.KVStore(),
+		node:   node,
+		local:  make(map[string][]byte),
+		stopCh: make(chan struct{}),
+	}
+	// a missing directory can't be watched
+	dir := datastore.Key(serviceRecordsKeyPrefix)
+	if ok, err := s.store.Exists(dir); err == nil && !ok {
+		if err := s.store.Put(dir, []byte{}, &store.WriteOptions{IsDir: true}); err != nil {
+			logrus.Warnf("Failed to create the service record directory: %v", err)
+		}
+	}
+	go s.refresh()
+	return s, nil
+}
+
+func serviceRecordKey(nid, eid string) string {
+	return nid + "." + eid
+}
+
+func parseServiceRecordKey(key string) (string, string, bool) {
+	parts := strings.SplitN(path.Base(key), ".", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func (s *kvServiceRecords) storeKey(key string) string {
+	return datastore.Key(serviceRecordsKeyPrefix) + key
+}
+
+func (s *kvServiceRecords) put(key string, value []byte) error {
+	buf, err := json.Marshal(&kvServiceRecord{Node: s.node, Value: value})
+	if err != nil {
+		return err
+	}
+	return s.store.Put(s.storeKey(key), buf, &store.WriteOptions{TTL: serviceRecordTTL})
+}
+
+func (s *kvServiceRecords) CreateEntry(nid, eid string, value []byte) error {
+	key := serviceRecordKey(nid, eid)
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.local[key]; ok {
+		return types.ForbiddenErrorf("service record %s in network %s already exists", eid, nid)
+	}
+	if err := s.put(key, value); err != nil {
+		return err
+	}
+	s.local[key] = value
+	return nil
+}
+
+func (s *kvServiceRecords) UpdateEntry(nid, eid string, value []byte) error {
+	key := serviceRecordKey(nid, eid)
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.local[key]; !ok {
+		return types.NotFoundErrorf("service record %s in network %s does not exist", eid, nid)
+	}
+	if err := s.put(key, value); err != nil {
+		return err
+	}
+	s.local[key] = value
+	return nil
+}
+
+func (s *kvServiceRecords) DeleteEntry(nid, eid string) error {
+	key := serviceRecordKey(nid, eid)
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.local[key]; !ok {
+		return types.NotFoundErrorf("service record %s in network %s does not exist", eid, nid)
+	}
+	delete(s.local, key)
+	if err := s.store.Delete(s.storeKey(key)); err != nil && err != store.ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+func (s *kvServiceRecords) TakeEntry(nid, eid string, value []byte) error {
+	key := serviceRecordKey(nid, eid)
+	s.Lock()
+	defer s.Unlock()
+	if err := s.put(key, value); err != nil {
+		return err
+	}
+	s.local[key] = value
+	return nil
+}
+
+func (s *kvServiceRecords) ReleaseEntry(nid, eid string) {
+	s.Lock()
+	delete(s.local, serviceRecordKey(nid, eid))
+	s.Unlock()
+}
+
+func (s *kvServiceRecords) GetEntry(nid, eid string) ([]byte, error) {
+	key := serviceRecordKey(nid, eid)
+	s.Lock()
+	value, ok := s.local[key]
+	s.Unlock()
+	if ok {
+		return value, nil
+	}
+
+	kvp, err := s.store.Get(s.storeKey(key))
+	if err != nil {
+		if err == store.ErrKeyNotFound {
+			return nil, types.NotFoundErrorf("service record %s in network %s does not exist", eid, nid)
+		}
+		return nil, err
+	}
+	var rec kvServiceRecord
+	if err := json.Unmarshal(kvp.Value, &rec); err != nil {
+		return nil, err
+	}
+	return rec.Value, nil
+}
+
+func (s *kvServiceRecords) GetTableByNetwork(nid string) map[string][]byte {
+	entries := make(map[string][]byte)
+	records, err := s.list()
+	if err != nil {
+		logrus.Warnf("Failed to list the service records of network %s: %v", nid, err)
+		return entries
+	}
+	for key, rec := range records {
+		if n, eid, ok := parseServiceRecordKey(key); ok && n == nid {
+			entries[eid] = rec.Value
+		}
+	}
+	return entries
+}
+
+// list returns the records of the store keyed by <nid>.<eid>
+func (s *kvServiceRecords) list() (map[string]*kvServiceRecord, error) {
+	kvps, err := s.store.List(datastore.Key(serviceRecordsKeyPrefix))
+	if err != nil && err != store.ErrKeyNotFound {
+		return nil, err
+	}
+	return decodeServiceRecords(kvps), nil
+}
+
+func decodeServiceRecords(kvps []*store.KVPair) map[string]*kvServiceRecord {
+	records := make(map[string]*kvServiceRecord, len(kvps))
+	for _, kvp := range kvps {
+		if _, _, ok := parseServiceRecordKey(kvp.Key); !ok {
+			continue
+		}
+		var rec kvServiceRecord
+		if err := json.Unmarshal(kvp.Value, &rec); err != nil {
+			logrus.Warnf("Invalid service record %s: %v", kvp.Key, err)
+			continue
+		}
+		records[path.Base(kvp.Key)] = &rec
+	}
+	return records
+}
+
+// diffServiceRecords returns the table events turning the old records of the
+// other nodes into the new ones
+func diffServiceRecords(node string, old, cur map[string]*kvServiceRecord) []events.Event {
+	var evs []events.Event
+	for key, rec := range cur {
+		if rec.Node == node {
+			continue
+		}
+		nid, eid, _ := parseServiceRecordKey(key)
+		prev, ok := old[key]
+		switch {
+		case !ok || prev.Node == node:
+			evs = append(evs, networkdb.CreateEvent{Table: libnetworkEPTable, NetworkID: nid, Key: eid, Value: rec.Value})
+		case string(prev.Value) != string(rec.Value):
+			evs = append(evs, networkdb.UpdateEvent{Table: libnetworkEPTable, NetworkID: nid, Key: eid, Value: rec.Value})
+		}
+	}
+	for key, prev := range old {
+		if prev.Node == node {
+			continue
+		}
+		if rec, ok := cur[key]; !ok || rec.Node == node {
+			nid, eid, _ := parseServiceRecordKey(key)
+			evs = append(evs, networkdb.DeleteEvent{Table: libnetworkEPTable, NetworkID: nid, Key: eid, Value: prev.Value})
+		}
+	}
+	return evs
+}
+
+func (s *kvServiceRecords) Watch() (*events.Channel, func()) {
+	ch := events.NewChannel(0)
+	sink := events.NewQueue(ch)
+	stopCh := make(chan struct{})
+	go s.watch(sink, stopCh)
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			close(stopCh)
+			ch.Close()
+			sink.Close()
+		})
+	}
+}
+
+// watch turns the changes of the store into table events. The watch is
+// restarted when the connection with the store is lost, the known records
+// are kept so that only the changes in between are notified.
+func (s *kvServiceRecords) watch(sink events.Sink, stopCh chan struct{}) {
+	known := make(map[string]*kvServiceRecord)
+	opts := datastore.WatchOptions{
+		Tree:          true,
+		RetryInterval: serviceRecordRetry,
+		Disconnected: func(err error) {
+			logrus.Warnf("Failed to watch the service records, retrying: %v", err)
+		},
+	}
+	for ev := range datastore.WatchKV(s.store, datastore.Key(serviceRecordsKeyPrefix), opts, stopCh) {
+		if ev.Err != nil {
+			logrus.Errorf("Stopped watching the service records: %v", ev.Err)
+			return
+		}
+		records := decodeServiceRecords(ev.Pairs)
+		for _, e := range diffServiceRecords(s.node, known, records) {
+			sink.Write(e)
+		}
+		known = records
+	}
+}
+
+// refresh writes again the records of this node before they expire
+func (s *kvServiceRecords) refresh() {
+	ticker := time.NewTicker(serviceRecordRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Lock()
+			for key, value := range s.local {
+				if err := s.put(key, value); err != nil {
+					logrus.Warnf("Failed to refresh the service record %s: %v", key, err)
+				}
+			}
+			s.Unlock()
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// Close removes the records of this node, so that the other nodes don't wait
+// for them to expire
+func (s *kvServiceRecords) Close() {
+	close(s.stopCh)
+	s.Lock()
+	defer s.Unlock()
+	for key := range s.local {
+		if err := s.store.Delete(s.storeKey(key)); err != nil && err != store.ErrKeyNotFound {
+			logrus.Warnf("Failed to remove the service record %s: %v", key, err)
+		}
+		delete(s.local, key)
+	}
+	s.store.Close()
+}
@@ -0,0 +1,53 @@
+package libnetwork
+
+import (
+	"testing"
+
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/networkdb"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestDiffServiceRecords(t *testing.T) {
+	old := decodeServiceRecords([]*store.KVPair{
+		{Key: "/docker/network/v1.0/service_records/", Value: []byte{}},
+		{Key: "/docker/network/v1.0/service_records/net1.ep1", Value: []byte(`{"node":"node2","value":"AQ=="}`)},
+		{Key: "/docker/network/v1.0/service_records/net1.ep2", Value: []byte(`{"node":"node2","value":"AQ=="}`)},
+		{Key: "/docker/network/v1.0/service_records/net1.ep3", Value: []byte(`{"node":"node2","value":"AQ=="}`)},
+		{Key: "/docker/network/v1.0/service_records/net1.ep4", Value: []byte(`{"node":"node1","value":"AQ=="}`)},
+	})
+	assert.Check(t, is.Len(old, 4))
+
+	cur := decodeServiceRecords([]*store.KVPair{
+		{Key: "/docker/network/v1.0/service_records/net1.ep1", Value: []byte(`{"node":"node2","value":"AQ=="}`)},
+		{Key: "/docker/network/v1.0/service_records/net1.ep2", Value: []byte(`{"node":"node2","value":"Ag=="}`)},
+		{Key: "/docker/network/v1.0/service_records/net2.ep5", Value: []byte(`{"node":"node3","value":"AQ=="}`)},
+		{Key: "/docker/network/v1.0/service_records/net1.ep6", Value: []byte(`{"node":"node1","value":"AQ=="}`)},
+	})
+
+	// the records of node1 are the local ones
+	evs := diffServiceRecords("node1", old, cur)
+	assert.Check(t, is.Len(evs, 3))
+	for _, ev := range evs {
+		switch ev := ev.(type) {
+		case networkdb.CreateEvent:
+			assert.Check(t, is.Equal(ev.NetworkID, "net2"))
+			assert.Check(t, is.Equal(ev.Key, "ep5"))
+		case networkdb.UpdateEvent:
+			assert.Check(t, is.Equal(ev.Key, "ep2"))
+			assert.Check(t, is.DeepEqual(ev.Value, []byte{2}))
+		case networkdb.DeleteEvent:
+			assert.Check(t, is.Equal(ev.Key, "ep3"))
+			assert.Check(t, is.DeepEqual(ev.Value, []byte{1}))
+		default:
+			t.Fatalf("unexpected event %#v", ev)
+		}
+	}
+}
+
+func TestServiceRecordsNode(t *testing.T) {
+	// the ID is kept across restarts and tells the nodes sharing a hostname apart
+	assert.Check(t, is.Equal(serviceRecordsNode("10.0.0.1"), serviceRecordsNode("10.0.0.1")))
+	assert.Check(t, serviceRecordsNode("10.0.0.1") != serviceRecordsNode("10.0.0.2"))
+}
@@ -0,0 +1,182 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/sirupsen/logrus"
+)
+
+// lbPaths2Func are the diagnostic handlers exposing the load balancer state
+var lbPaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/lbstats": lbStats2Diag,
+}
+
+// LBCounters are the load balancer counters of a backend, or the sum of the
+// counters of the backends of a service
+type LBCounters struct {
+	ActiveConnections   int    `json:"active_connections"`
+	InactiveConnections int    `json:"inactive_connections"`
+	Connections         uint64 `json:"connections"`
+	PacketsIn           uint64 `json:"packets_in"`
+	PacketsOut          uint64 `json:"packets_out"`
+	BytesIn             uint64 `json:"bytes_in"`
+	BytesOut            uint64 `json:"bytes_out"`
+}
+
+// Add adds the counters of o to the counters
+func (c *LBCounters) Add(o *LBCounters) {
+	c.ActiveConnections += o.ActiveConnections
+	c.InactiveConnections += o.InactiveConnections
+	c.Connections += o.Connections
+	c.PacketsIn += o.PacketsIn
+	c.PacketsOut += o.PacketsOut
+	c.BytesIn += o.BytesIn
+	c.BytesOut += o.BytesOut
+}
+
+func (c *LBCounters) String() string {
+	return fmt.Sprintf("active: %d, inactive: %d, connections: %d, packets in/out: %d/%d, bytes in/out: %d/%d",
+		c.ActiveConnections, c.InactiveConnections, c.Connections, c.PacketsIn, c.PacketsOut, c.BytesIn, c.BytesOut)
+}
+
+// LBBackendStats are the load balancer counters of a backend of a service
+type LBBackendStats struct {
+	EndpointID string `json:"endpoint_id"`
+	IP         string `json:"ip"`
+	Disabled   bool   `json:"disabled"`
+	Unhealthy  bool   `json:"unhealthy"`
+	Weight     int    `json:"weight"`
+	LBCounters
+}
+
+// ServiceLBStats are the load balancer counters of a service in a network,
+// the counters of the backends are summed up in LBCounters
+type ServiceLBStats struct {
+	Name      string            `json:"name"`
+	ID        string            `json:"id"`
+	NetworkID string            `json:"network_id"`
+	VIP       string            `json:"vip"`
+	Backends  []*LBBackendStats `json:"backends"`
+	// Error is why the counters could not be read, they are zero then
+	Error string `json:"error,omitempty"`
+	LBCounters
+}
+
+// LBStatsResult is the diagnostic result listing the load balancer counters
+// of the services
+type LBStatsResult struct {
+	Services []*ServiceLBStats `json:"services"`
+}
+
+func (r *LBStatsResult) String() string {
+	var b strings.Builder
+	for _, s := range r.Services {
+		fmt.Fprintf(&b, "service %s (%s) network %s vip %s %s\n", s.Name, s.ID, s.NetworkID, s.VIP, s.LBCounters.String())
+		if s.Error != "" {
+			fmt.Fprintf(&b, "  error: %s\n", s.Error)
+		}
+		for _, be := range s.Backends {
+			fmt.Fprintf(&b, "  backend %s %s weight:%d disabled:%t unhealthy:%t %s\n", be.EndpointID, be.IP, be.Weight, be.Disabled, be.Unhealthy, be.LBCounters.String())
+		}
+	}
+	return b.String()
+}
+
+// LoadBalancerStats returns the load balancer counters of the services of
+// this node, per service and network. When nid is not empty only the
+// services of the matching network are reported.
+func (c *controller) LoadBalancerStats(nid string) []*ServiceLBStats {
+	c.Lock()
+	services := make([]*service, 0, len(c.serviceBindings))
+	for _, s := range c.serviceBindings {
+		services = append(services, s)
+	}
+	c.Unlock()
+
+	var res []*ServiceLBStats
+	for _, s := range services {
+		s.Lock()
+		for lbNID, lb := range s.loadBalancers {
+			if nid != "" && lbNID != nid {
+				continue
+			}
+			res = append(res, c.serviceLBStats(s, lbNID, lb))
+		}
+		s.Unlock()
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Name != res[j].Name {
+			return res[i].Name < res[j].Name
+		}
+		return res[i].NetworkID < res[j].NetworkID
+	})
+	return res
+}
+
+// serviceLBStats collects the counters of a load balancer, the service must
+// be locked
+func (c *controller) serviceLBStats(s *service, nid string, lb *loadBalancer) *ServiceLBStats {
+	st := &ServiceLBStats{
+		Name:      s.name,
+		ID:        s.id,
+		NetworkID: nid,
+		VIP:       lb.vip.String(),
+	}
+
+	var counters map[string]*LBCounters
+	n, err := c.NetworkByID(nid)
+	if err == nil {
+		counters, err = n.(*network).lbCounters(lb)
+	}
+	if err != nil {
+		st.Error = err.Error()
+	}
+
+	for eid, be := range lb.backEnds {
+		bst := &LBBackendStats{
+			EndpointID: eid,
+			IP:         be.ip.String(),
+			Disabled:   be.disabled,
+			Unhealthy:  be.unhealthy,
+			Weight:     be.weight,
+		}
+		if cnt, ok := counters[bst.IP]; ok {
+			bst.LBCounters = *cnt
+		}
+		st.LBCounters.Add(&bst.LBCounters)
+		st.Backends = append(st.Backends, bst)
+	}
+	sort.Slice(st.Backends, func(i, j int) bool {
+		return st.Backends[i].EndpointID < st.Backends[j].EndpointID
+	})
+	return st
+}
+
+func lbStats2Diag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("load balancer stats")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+
+	var nid string
+	if len(r.Form["nid"]) > 0 {
+		nid = r.Form["nid"][0]
+	}
+	rsp := &LBStatsResult{Services: c.LoadBalancerStats(nid)}
+	log.Info("load balancer stats done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(rsp), json)
+}
@@ -23,3 +23,18 @@ func (sb *sandbox) populateLoadBalancers(ep *endpoint) {
 
 func arrangeIngressFilterRule() {
 }
+
+func (c *controller) setServiceBindingHealth(nID, eID string, healthy bool) {
+}
+
+func (n *network) lbCounters(lb *loadBalancer) (map[string]*LBCounters, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+func setIngressProxy(publishedPort uint32, proxyPort uint16, isDelete bool) error {
+	return fmt.Errorf("not supported")
+}
+
+func ingressProxyUpstream(publishedPort uint32) (string, error) {
+	return "", fmt.Errorf("not supported")
+}
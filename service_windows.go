@@ -5,6 +5,7 @@ import (
 
 	"github.com/Microsoft/hcsshim"
 	"github.com/docker/docker/pkg/system"
+	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 )
 
@@ -51,7 +52,7 @@ func (n *network) addLBBackend(ip net.IP, lb *loadBalancer) {
 		var endpoints []hcsshim.HNSEndpoint
 
 		for eid, be := range lb.backEnds {
-			if be.disabled {
+			if be.disabled || be.unhealthy {
 				continue
 			}
 			//Call HNS to get back ID (GUID) corresponding to the endpoint.
@@ -156,10 +157,14 @@ func (n *network) rmLBBackend(ip net.IP, lb *loadBalancer, rmService bool, fullR
 	}
 }
 
+func (n *network) lbCounters(lb *loadBalancer) (map[string]*LBCounters, error) {
+	return nil, types.NotImplementedErrorf("load balancer counters not supported on windows")
+}
+
 func numEnabledBackends(lb *loadBalancer) int {
 	nEnabled := 0
 	for _, be := range lb.backEnds {
-		if !be.disabled {
+		if !be.disabled && !be.unhealthy {
 			nEnabled++
 		}
 	}
@@ -171,3 +176,11 @@ func (sb *sandbox) populateLoadBalancers(ep *endpoint) {
 
 func arrangeIngressFilterRule() {
 }
+
+func setIngressProxy(publishedPort uint32, proxyPort uint16, isDelete bool) error {
+	return types.NotImplementedErrorf("ingress proxies not supported on windows")
+}
+
+func ingressProxyUpstream(publishedPort uint32) (string, error) {
+	return "", types.NotImplementedErrorf("ingress proxies not supported on windows")
+}
@@ -1,6 +1,7 @@
 package libnetwork
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -8,7 +9,10 @@ import (
 	"github.com/docker/libkv/store/consul"
 	"github.com/docker/libkv/store/etcd"
 	"github.com/docker/libkv/store/zookeeper"
+	"github.com/docker/libnetwork/correlation"
 	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/datastore/redis"
+	"github.com/docker/libnetwork/tracing"
 	"github.com/sirupsen/logrus"
 )
 
@@ -17,6 +21,7 @@ func registerKVStores() {
 	zookeeper.Register()
 	etcd.Register()
 	boltdb.Register()
+	redis.Register()
 }
 
 func (c *controller) initScopedStore(scope string, scfg *datastore.ScopeCfg) error {
@@ -49,10 +54,45 @@ func (c *controller) initStores() error {
 		}
 	}
 
+	c.maintainLocalStore()
+
 	c.startWatch()
 	return nil
 }
 
+// maintainLocalStore verifies and compacts the local store at startup, as
+// configured
+func (c *controller) maintainLocalStore() {
+	verify, compact := c.cfg.Daemon.VerifyLocalStore, c.cfg.Daemon.CompactLocalStore
+	if !verify && !compact {
+		return
+	}
+	m, ok := c.getStore(datastore.LocalScope).(datastore.BoltMaintainer)
+	if !ok {
+		return
+	}
+	if verify {
+		res, err := m.Verify()
+		if err != nil {
+			logrus.Warnf("Could not verify the local store: %v", err)
+			return
+		}
+		if len(res.Errors) > 0 {
+			logrus.Errorf("The local store is inconsistent, not compacting it: %s", res)
+			return
+		}
+		logrus.Infof("Local store verified: %s", res.Stats.String())
+	}
+	if compact {
+		res, err := m.Compact()
+		if err != nil {
+			logrus.Warnf("Could not compact the local store: %v", err)
+			return
+		}
+		logrus.Infof("Local store compacted from %d to %d bytes", res.Before.Size, res.After.Size)
+	}
+}
+
 func (c *controller) closeStores() {
 	for _, store := range c.getStores() {
 		store.Close()
@@ -228,7 +268,36 @@ func (n *network) getEndpointsFromStore() ([]*endpoint, error) {
 	return epl, nil
 }
 
+// updateNetworkInStore applies update to the network identified by nid in
+// its store, from the latest network when it was modified meanwhile
+func (c *controller) updateNetworkInStore(nid string, update func(n *network)) error {
+retry:
+	n, err := c.getNetworkFromStore(nid)
+	if err != nil {
+		return err
+	}
+	n.Lock()
+	update(n)
+	n.Unlock()
+	if err := c.updateToStore(n); err != nil {
+		if err == datastore.ErrKeyModified {
+			goto retry
+		}
+		return err
+	}
+	return nil
+}
+
 func (c *controller) updateToStore(kvObject datastore.KVObject) error {
+	return c.updateToStoreContext(context.Background(), kvObject)
+}
+
+// updateToStoreContext is updateToStore logging with the correlation ID
+// of the operation in ctx
+func (c *controller) updateToStoreContext(ctx context.Context, kvObject datastore.KVObject) (err error) {
+	_, span := tracing.Start(ctx, "store.Update", storeSpanAttrs(kvObject)...)
+	defer func() { tracing.End(span, err) }()
+
 	cs := c.getStore(kvObject.DataScope())
 	if cs == nil {
 		return ErrDataStoreNotInitialized(kvObject.DataScope())
@@ -240,31 +309,50 @@ func (c *controller) updateToStore(kvObject datastore.KVObject) error {
 		}
 		return fmt.Errorf("failed to update store for object type %T: %v", kvObject, err)
 	}
+	correlation.Logger(ctx).Debugf("Updated object %v in the %s store", kvObject.Key(), kvObject.DataScope())
 
 	return nil
 }
 
 func (c *controller) deleteFromStore(kvObject datastore.KVObject) error {
+	return c.deleteFromStoreContext(context.Background(), kvObject)
+}
+
+// deleteFromStoreContext is deleteFromStore logging with the correlation
+// ID of the operation in ctx
+func (c *controller) deleteFromStoreContext(ctx context.Context, kvObject datastore.KVObject) (err error) {
+	_, span := tracing.Start(ctx, "store.Delete", storeSpanAttrs(kvObject)...)
+	defer func() { tracing.End(span, err) }()
+
 	cs := c.getStore(kvObject.DataScope())
 	if cs == nil {
 		return ErrDataStoreNotInitialized(kvObject.DataScope())
 	}
 
+	log := correlation.Logger(ctx)
 retry:
 	if err := cs.DeleteObjectAtomic(kvObject); err != nil {
 		if err == datastore.ErrKeyModified {
 			if err := cs.GetObject(datastore.Key(kvObject.Key()...), kvObject); err != nil {
 				return fmt.Errorf("could not update the kvobject to latest when trying to delete: %v", err)
 			}
-			logrus.Warnf("Error (%v) deleting object %v, retrying....", err, kvObject.Key())
+			log.Warnf("Error (%v) deleting object %v, retrying....", err, kvObject.Key())
 			goto retry
 		}
 		return err
 	}
+	log.Debugf("Deleted object %v from the %s store", kvObject.Key(), kvObject.DataScope())
 
 	return nil
 }
 
+func storeSpanAttrs(kvObject datastore.KVObject) []tracing.Attribute {
+	return []tracing.Attribute{
+		tracing.String("store.key", datastore.Key(kvObject.Key()...)),
+		tracing.String("store.scope", kvObject.DataScope()),
+	}
+}
+
 type netWatch struct {
 	localEps  map[string]*endpoint
 	remoteEps map[string]*endpoint
@@ -0,0 +1,137 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/diagnostic"
+	"github.com/docker/libnetwork/internal/caller"
+	"github.com/sirupsen/logrus"
+)
+
+// storePaths2Func are the diagnostic handlers of the local store maintenance
+// and of the datastore metrics
+var storePaths2Func = map[string]diagnostic.HTTPHandlerFunc{
+	"/storeverify":  storeVerify2Diag,
+	"/storecompact": storeCompact2Diag,
+	"/storemetrics": storeMetrics2Diag,
+}
+
+func localBoltStore(ctx interface{}) (datastore.BoltMaintainer, error) {
+	c, ok := ctx.(*controller)
+	if !ok {
+		return nil, fmt.Errorf("controller not available")
+	}
+	m, ok := c.getStore(datastore.LocalScope).(datastore.BoltMaintainer)
+	if !ok {
+		return nil, fmt.Errorf("local store not available")
+	}
+	return m, nil
+}
+
+func storeVerify2Diag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("verify local store")
+
+	m, err := localBoltStore(ctx)
+	if err != nil {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	res, err := m.Verify()
+	if err != nil {
+		log.WithError(err).Error("verify local store failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	log.Info("verify local store done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(res), json)
+}
+
+func storeCompact2Diag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("compact local store")
+
+	m, err := localBoltStore(ctx)
+	if err != nil {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	res, err := m.Compact()
+	if err != nil {
+		log.WithError(err).Error("compact local store failed")
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(err), json)
+		return
+	}
+	log.Info("compact local store done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(res), json)
+}
+
+// storeMetrics2Diag returns the metrics of the datastore calls, of all the
+// stores or of the one of the scope passed
+func storeMetrics2Diag(ctx interface{}, w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	diagnostic.DebugHTTPForm(r)
+	_, json := diagnostic.ParseHTTPFormOptions(r)
+
+	// audit logs
+	log := logrus.WithFields(logrus.Fields{"component": "diagnostic", "remoteIP": r.RemoteAddr, "method": caller.Name(0), "url": r.URL.String()})
+	log.Info("store metrics")
+
+	c, ok := ctx.(*controller)
+	if !ok {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("controller not available")), json)
+		return
+	}
+	var scope string
+	if len(r.Form["scope"]) > 0 {
+		scope = r.Form["scope"][0]
+	}
+
+	res := make(map[string]*datastore.StoreMetrics)
+	for _, store := range c.getStores() {
+		if scope != "" && store.Scope() != scope {
+			continue
+		}
+		if m, ok := store.(datastore.MetricsReporter); ok {
+			res[store.Scope()] = m.Metrics()
+		}
+	}
+	if scope != "" && len(res) == 0 {
+		diagnostic.HTTPReply(w, diagnostic.FailCommand(fmt.Errorf("no metrics for the %s store", scope)), json)
+		return
+	}
+	log.Info("store metrics done")
+	diagnostic.HTTPReply(w, diagnostic.CommandSucceed(&storeMetricsResult{res}), json)
+}
+
+// storeMetricsResult are the metrics of the stores by scope
+type storeMetricsResult struct {
+	Stores map[string]*datastore.StoreMetrics `json:"stores"`
+}
+
+func (r *storeMetricsResult) String() string {
+	scopes := make([]string, 0, len(r.Stores))
+	for scope := range r.Stores {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	var b strings.Builder
+	for _, scope := range scopes {
+		b.WriteString(r.Stores[scope].String())
+	}
+	return b.String()
+}
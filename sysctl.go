@@ -0,0 +1,118 @@
+package libnetwork
+
+import (
+	"sort"
+
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
+)
+
+// validateSysctls returns an error when a sysctl is not a network sysctl
+// the sandbox can set. The sysctls of an endpoint may name the sandbox
+// interface of the endpoint with osl.SysctlIfname.
+func validateSysctls(sysctls map[string]string, endpoint bool) error {
+	keys := make([]string, 0, len(sysctls))
+	for k := range sysctls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := osl.ValidateSysctl(k, sysctls[k], endpoint); err != nil {
+			return types.BadRequestErrorf("%v", err)
+		}
+	}
+	return nil
+}
+
+func (sb *sandbox) validateSysctls() error {
+	if len(sb.config.sysctls) == 0 {
+		return nil
+	}
+	// The default sandbox is the host network namespace
+	if sb.config.useDefaultSandBox {
+		return types.ForbiddenErrorf("sysctls cannot be set in the host network namespace")
+	}
+	return validateSysctls(sb.config.sysctls, false)
+}
+
+// setSysctls sets the sysctls of the sandbox in the osl sandbox
+func (sb *sandbox) setSysctls() error {
+	if len(sb.config.sysctls) == 0 || sb.osSbox == nil {
+		return nil
+	}
+	if _, err := sb.osSbox.SetSysctls(sb.config.sysctls); err != nil {
+		return types.InternalErrorf("failed to set the sysctls of sandbox %s: %v", sb.id, err)
+	}
+	return nil
+}
+
+// checkEndpointSysctls returns an error when the sandbox cannot set the
+// sysctls of the endpoint joining it: the default sandbox is the host
+// network namespace, and a sysctl set by the sandbox or by another of its
+// endpoints would not be restored to its value when the endpoint leaves.
+func (sb *sandbox) checkEndpointSysctls(ep *endpoint) error {
+	ep.Lock()
+	sysctls := ep.sysctls
+	epid := ep.id
+	ep.Unlock()
+	if len(sysctls) == 0 {
+		return nil
+	}
+	if sb.config.useDefaultSandBox {
+		return types.ForbiddenErrorf("sysctls of endpoint %s cannot be set in the host network namespace", ep.Name())
+	}
+
+	keys := make([]string, 0, len(sysctls))
+	for k := range sysctls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// The sysctls of the interface of an endpoint are its own
+		key := osl.ExpandSysctlIfname(k, epid)
+		if _, ok := sb.config.sysctls[key]; ok {
+			return types.ForbiddenErrorf("sysctl %s of endpoint %s is set by sandbox %s", k, ep.Name(), sb.ID())
+		}
+		for _, oep := range sb.getConnectedEndpoints() {
+			if oep.ID() == epid {
+				continue
+			}
+			oep.Lock()
+			set := false
+			for ok := range oep.sysctls {
+				set = set || osl.ExpandSysctlIfname(ok, oep.id) == key
+			}
+			oep.Unlock()
+			if set {
+				return types.ForbiddenErrorf("sysctl %s of endpoint %s is set by endpoint %s", k, ep.Name(), oep.Name())
+			}
+		}
+	}
+	return nil
+}
+
+// sandboxSysctls returns the sysctls of the endpoint for its sandbox
+// interface ifName
+func (ep *endpoint) sandboxSysctls(ifName string) map[string]string {
+	ep.Lock()
+	defer ep.Unlock()
+	if len(ep.sysctls) == 0 {
+		return nil
+	}
+	sysctls := make(map[string]string, len(ep.sysctls))
+	for k, v := range ep.sysctls {
+		sysctls[osl.ExpandSysctlIfname(k, ifName)] = v
+	}
+	return sysctls
+}
+
+// sandboxIfaceName returns the name of the interface srcName in the osl
+// sandbox
+func sandboxIfaceName(osSbox osl.Sandbox, srcName string) string {
+	for _, i := range osSbox.Info().Interfaces() {
+		if i.SrcName() == srcName {
+			return i.DstName()
+		}
+	}
+	return ""
+}
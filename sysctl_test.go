@@ -0,0 +1,134 @@
+package libnetwork
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/testutils"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestSandboxSysctls(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+	c, nws := getTestEnv(t, []NetworkOption{})
+	ctrlr := c.(*controller)
+	defer ctrlr.Stop()
+
+	if _, err := ctrlr.NewSandbox("sandbox0", OptionSysctls(map[string]string{"kernel.shmmax": "1"})); err == nil {
+		t.Fatal("expected an error for a sysctl outside of the network sysctls")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("expected a bad request error, got %v", err)
+	}
+	if _, err := ctrlr.NewSandbox("sandbox0", OptionUseDefaultSandbox(), OptionSysctls(map[string]string{"net.core.somaxconn": "1024"})); err == nil {
+		t.Fatal("expected an error for the sysctls of the default sandbox")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("expected a forbidden error, got %v", err)
+	}
+
+	sb, err := ctrlr.NewSandbox("sandbox0", OptionSysctls(map[string]string{"net.core.somaxconn": "1024"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Delete()
+
+	if _, err := nws[0].CreateEndpoint("ep0", CreateOptionSysctls(map[string]string{"net.ipv4.conf.eth0..rp_filter": "2"})); err == nil {
+		t.Fatal("expected an error for an invalid endpoint sysctl")
+	}
+	ep, err := nws[0].CreateEndpoint("ep0", CreateOptionSysctls(map[string]string{"net.ipv4.conf." + osl.SysctlIfname + ".rp_filter": "2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete(false)
+
+	if err := ep.Join(sb); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Leave(sb); err != nil {
+		t.Fatal(err)
+	}
+	osl.GC()
+}
+
+func TestEndpointSysctlsConflicts(t *testing.T) {
+	if !testutils.IsRunningInContainer() {
+		defer testutils.SetupTestOSContext(t)()
+	}
+	c, nws := getTestEnv(t, []NetworkOption{}, []NetworkOption{}, []NetworkOption{})
+	ctrlr := c.(*controller)
+	defer ctrlr.Stop()
+
+	sb, err := ctrlr.NewSandbox("sandbox0", OptionSysctls(map[string]string{"net.core.somaxconn": "1024"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Delete()
+
+	const finTimeout = "net.ipv4.tcp_fin_timeout"
+	ep0, err := nws[0].CreateEndpoint("ep0", CreateOptionSysctls(map[string]string{
+		finTimeout: "17",
+		"net.ipv4.conf." + osl.SysctlIfname + ".rp_filter": "2",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep0.Delete(false)
+	if err := ep0.Join(sb); err != nil {
+		t.Fatal(err)
+	}
+
+	// The previous values are persisted with the endpoint
+	stored, err := nws[0].(*network).getEndpointFromStore(ep0.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored.sysctlsPrev[finTimeout]; !ok {
+		t.Fatalf("expected the previous value of %s to be persisted, got %v", finTimeout, stored.sysctlsPrev)
+	}
+
+	// The sysctls of an interface do not conflict
+	ep1, err := nws[1].CreateEndpoint("ep1", CreateOptionSysctls(map[string]string{"net.ipv4.conf." + osl.SysctlIfname + ".rp_filter": "2"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Delete(false)
+	if err := ep1.Join(sb); err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Leave(sb)
+
+	for _, sysctls := range []map[string]string{
+		{finTimeout: "18"},
+		{"net.core.somaxconn": "2048"},
+	} {
+		ep2, err := nws[2].CreateEndpoint("ep2", CreateOptionSysctls(sysctls))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ep2.Join(sb); err == nil {
+			t.Fatalf("expected the sysctls %v already set in the sandbox to be refused", sysctls)
+		} else if _, ok := err.(types.ForbiddenError); !ok {
+			t.Fatalf("expected a forbidden error, got %v", err)
+		}
+		if err := ep2.Delete(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ep0.Leave(sb); err != nil {
+		t.Fatal(err)
+	}
+
+	// The host network namespace does not take the sysctls of endpoints
+	hsb, err := ctrlr.NewSandbox("sandbox1", OptionUseDefaultSandbox())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hsb.Delete()
+	if err := ep0.Join(hsb); err == nil {
+		t.Fatal("expected an error for the sysctls of an endpoint of the default sandbox")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("expected a forbidden error, got %v", err)
+	}
+}
@@ -18,7 +18,7 @@ import (
 //
 //     defer SetupTestOSContext(t)()
 //
-func SetupTestOSContext(t *testing.T) func() {
+func SetupTestOSContext(t testing.TB) func() {
 	runtime.LockOSThread()
 	if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
 		t.Fatalf("Failed to enter netns: %v", err)
@@ -12,7 +12,7 @@ import (
 //
 //     defer SetupTestOSContext(t)()
 //
-func SetupTestOSContext(t *testing.T) func() {
+func SetupTestOSContext(t testing.TB) func() {
 	return func() {
 	}
 }
@@ -0,0 +1,41 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/correlation"
+	"github.com/docker/libnetwork/tracing"
+)
+
+// spanAttrs returns the span attributes of the network
+func (n *network) spanAttrs() []tracing.Attribute {
+	return []tracing.Attribute{
+		tracing.String("network.id", n.ID()),
+		tracing.String("network.name", n.Name()),
+		tracing.String("network.driver", n.Type()),
+	}
+}
+
+// driverSpanAttrs returns the span attributes of a driver call for the
+// network, telling the remote plugin calls apart
+func (n *network) driverSpanAttrs() []tracing.Attribute {
+	attrs := n.spanAttrs()
+	if d, _ := n.getController().drvRegistry.Driver(n.Type()); d != nil {
+		attrs = append(attrs, tracing.Bool("driver.remote", !d.IsBuiltIn()))
+	}
+	return attrs
+}
+
+// endpointSpanAttrs returns the span attributes of the endpoint
+func (ep *endpoint) spanAttrs() []tracing.Attribute {
+	attrs := []tracing.Attribute{
+		tracing.String("endpoint.id", ep.ID()),
+		tracing.String("endpoint.name", ep.Name()),
+	}
+	if n := ep.getNetwork(); n != nil {
+		attrs = append(attrs, n.spanAttrs()...)
+	}
+	return attrs
+}
+
+func correlationAttr(id string) tracing.Attribute {
+	return tracing.String(correlation.Field, id)
+}
@@ -0,0 +1,92 @@
+// Package tracing traces the network operations of the controller, from
+// the controller calls down to the driver, IPAM, firewall and store calls
+// they make. The embedding daemon sets the Tracer creating the spans, such
+// as an adapter of an OpenTelemetry tracer exporting them, with SetTracer.
+// The operations are not traced until then.
+package tracing
+
+import (
+	"context"
+	"sync"
+)
+
+// Attribute is a key value pair describing a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool returns a bool attribute
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a traced operation
+type Span interface {
+	// SetAttributes adds attributes to the span
+	SetAttributes(attrs ...Attribute)
+	// RecordError records the error the operation failed with
+	RecordError(err error)
+	// End ends the span
+	End()
+}
+
+// Tracer creates the spans
+type Tracer interface {
+	// Start starts a span named name, child of the span of ctx if any,
+	// and returns a copy of ctx carrying it
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+var (
+	tracer Tracer
+	mu     sync.RWMutex
+)
+
+// SetTracer sets the tracer of the network operations, nil stops tracing
+func SetTracer(t Tracer) {
+	mu.Lock()
+	tracer = t
+	mu.Unlock()
+}
+
+// Start starts a span named name with the tracer set, a span doing nothing
+// if none is
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	mu.RLock()
+	t := tracer
+	mu.RUnlock()
+	if t == nil {
+		return ctx, noopSpan{}
+	}
+	return t.Start(ctx, name, attrs...)
+}
+
+// End records err on the span, if any, and ends it
+func End(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// Trace runs f in a span named name, child of the span of ctx
+func Trace(ctx context.Context, name string, f func() error, attrs ...Attribute) error {
+	_, span := Start(ctx, name, attrs...)
+	err := f()
+	End(span, err)
+	return err
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attrs ...Attribute) {}
+
+func (noopSpan) RecordError(err error) {}
+
+func (noopSpan) End() {}
@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type spanKey struct{}
+
+type testSpan struct {
+	name   string
+	parent *testSpan
+	attrs  []Attribute
+	err    error
+	ended  bool
+}
+
+func (s *testSpan) SetAttributes(attrs ...Attribute) { s.attrs = append(s.attrs, attrs...) }
+
+func (s *testSpan) RecordError(err error) { s.err = err }
+
+func (s *testSpan) End() { s.ended = true }
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*testSpan)
+	s := &testSpan{name: name, parent: parent, attrs: attrs}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func TestNoTracer(t *testing.T) {
+	ctx := context.Background()
+	sctx, span := Start(ctx, "op")
+	if sctx != ctx {
+		t.Fatal("expected the context to be returned as is")
+	}
+	End(span, errors.New("failed"))
+}
+
+func TestTrace(t *testing.T) {
+	tr := &testTracer{}
+	SetTracer(tr)
+	defer SetTracer(nil)
+
+	ctx, span := Start(context.Background(), "libnetwork.NewNetwork", String("network.name", "net1"))
+	failure := errors.New("failed")
+	if err := Trace(ctx, "driver.CreateNetwork", func() error { return failure }, Bool("driver.remote", true)); err != failure {
+		t.Fatalf("expected the error of the traced call, got %v", err)
+	}
+	End(span, nil)
+
+	if len(tr.spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(tr.spans))
+	}
+	root, child := tr.spans[0], tr.spans[1]
+	if !root.ended || root.err != nil || root.parent != nil {
+		t.Fatalf("unexpected root span %+v", root)
+	}
+	if child.name != "driver.CreateNetwork" || child.parent != root || !child.ended || child.err != failure {
+		t.Fatalf("unexpected child span %+v", child)
+	}
+	if len(child.attrs) != 1 || child.attrs[0] != Bool("driver.remote", true) {
+		t.Fatalf("unexpected child span attributes %v", child.attrs)
+	}
+}
@@ -101,11 +101,30 @@ func (p PortBinding) HostAddr() (net.Addr, error) {
 		return &net.TCPAddr{IP: p.HostIP, Port: int(p.HostPort)}, nil
 	case SCTP:
 		return &sctp.SCTPAddr{IP: []net.IP{p.HostIP}, Port: int(p.HostPort)}, nil
+	case DCCP:
+		return &DCCPAddr{IP: p.HostIP, Port: int(p.HostPort)}, nil
 	default:
 		return nil, ErrInvalidProtocolBinding(p.Proto.String())
 	}
 }
 
+// DCCPAddr is the address of a DCCP end point, which the net package does
+// not support
+type DCCPAddr struct {
+	IP   net.IP
+	Port int
+}
+
+// Network returns the address's network name, "dccp"
+func (a *DCCPAddr) Network() string { return "dccp" }
+
+func (a *DCCPAddr) String() string {
+	if a == nil {
+		return "<nil>"
+	}
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
+}
+
 // ContainerAddr returns the container side transport address
 func (p PortBinding) ContainerAddr() (net.Addr, net.Addr, error) {
 	switch p.Proto {
@@ -124,6 +143,11 @@ func (p PortBinding) ContainerAddr() (net.Addr, net.Addr, error) {
 			return &sctp.SCTPAddr{IP: []net.IP{p.IPv6}, Port: int(p.Port)}, &sctp.SCTPAddr{IP: []net.IP{p.IPv6}, Port: int(p.Port)}, nil
 		}
 		return &sctp.SCTPAddr{IP: []net.IP{p.IP}, Port: int(p.Port)}, nil, nil
+	case DCCP:
+		if p.IPv6 != nil {
+			return &DCCPAddr{IP: p.IP, Port: int(p.Port)}, &DCCPAddr{IP: p.IPv6, Port: int(p.Port)}, nil
+		}
+		return &DCCPAddr{IP: p.IP, Port: int(p.Port)}, nil, nil
 	default:
 		return nil, nil, ErrInvalidProtocolBinding(p.Proto.String())
 	}
@@ -255,6 +279,8 @@ const (
 	UDP = 17
 	// SCTP is for the SCTP ip protocol
 	SCTP = 132
+	// DCCP is for the DCCP ip protocol
+	DCCP = 33
 )
 
 // Protocol represents an IP protocol number
@@ -270,6 +296,8 @@ func (p Protocol) String() string {
 		return "udp"
 	case SCTP:
 		return "sctp"
+	case DCCP:
+		return "dccp"
 	default:
 		return fmt.Sprintf("%d", p)
 	}
@@ -286,6 +314,8 @@ func ParseProtocol(s string) Protocol {
 		return TCP
 	case "sctp":
 		return SCTP
+	case "dccp":
+		return DCCP
 	default:
 		return 0
 	}
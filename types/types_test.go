@@ -26,6 +26,27 @@ func TestTransportPortConv(t *testing.T) {
 	}
 }
 
+func TestDCCPPortBinding(t *testing.T) {
+	if p := ParseProtocol("dccp"); p != DCCP || p.String() != "dccp" {
+		t.Fatalf("expected the dccp protocol, got %v", p)
+	}
+	pb := PortBinding{Proto: DCCP, IP: net.IPv4(172, 28, 30, 23), Port: 5004, HostIP: net.IPv4(127, 0, 0, 1), HostPort: 8001}
+	ca, _, err := pb.ContainerAddr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "172.28.30.23:5004"; ca.String() != expected || ca.Network() != "dccp" {
+		t.Fatalf("expected container address dccp/%s, got %s/%s", expected, ca.Network(), ca)
+	}
+	ha, err := pb.HostAddr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "127.0.0.1:8001"; ha.String() != expected {
+		t.Fatalf("expected host address %s, got %s", expected, ha)
+	}
+}
+
 func TestTransportPortBindingConv(t *testing.T) {
 	input := []struct {
 		sform      string